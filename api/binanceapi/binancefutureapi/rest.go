@@ -131,7 +131,7 @@ func GetExchangeInfo_Symbols(ac APIClass) (*binanceapi.ExchangeInfo_Symbols, err
 	method := "GET"
 	url := rootUrl + action
 
-	rst, err := network.ParseHttpResult[binanceapi.ExchangeInfo_Symbols](restLogPrefix, "GetExchangeInfo_Symbols", realUrlMissingInUnified(url, ac), method, "", nil, func(resp *http.Response, body []byte) {
+	rst, err := network.ParseHttpResultStream[binanceapi.ExchangeInfo_Symbols](restLogPrefix, "GetExchangeInfo_Symbols", realUrlMissingInUnified(url, ac), method, "", nil, func(resp *http.Response, body []byte) {
 		binanceapi.ProcessResponse(resp, body, apiType(ac))
 	}, binanceapi.ErrorCallback)
 	if err == nil && serverTsDelta == 0 {
@@ -307,8 +307,10 @@ func getKlineFromEndpoint(action, symbol, interval string, t0, t1 time.Time, lim
 		binanceapi.ProcessResponse(resp, body, "spot")
 	}, binanceapi.ErrorCallback)
 
-	for i := 0; i < len(*rst); i++ {
-		(*rst)[i][0] = int64((*rst)[i][0].(float64))
+	if err == nil {
+		for i := 0; i < len(*rst); i++ {
+			(*rst)[i][0] = int64((*rst)[i][0].(float64))
+		}
 	}
 
 	return rst, err
@@ -354,6 +356,31 @@ func GetPremiumIndex(symbol string, ac APIClass) (*binanceapi.PremiumIndexResp,
 	return rst, err
 }
 
+// 获取资金费率配置（上下限、结算周期）
+func GetFundingInfo(ac APIClass) (*[]binanceapi.FundingInfo, error) {
+	action := "/fapi/v1/fundingInfo"
+	method := "GET"
+	url := rootUrl + action
+	rst, err := network.ParseHttpResult[[]binanceapi.FundingInfo](restLogPrefix, "GetFundingInfo", realUrlMissingInUnified(url, ac), method, "", nil, func(resp *http.Response, body []byte) {
+		binanceapi.ProcessResponse(resp, body, apiType(ac))
+	}, binanceapi.ErrorCallback)
+	return rst, err
+}
+
+// 获取某个合约的实时持仓总量
+func GetOpenInterest(symbol string, ac APIClass) (*binanceapi.OpenInterest, error) {
+	action := "/fapi/v1/openInterest"
+	method := "GET"
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	action = action + "?" + params.Encode()
+	url := rootUrl + action
+	rst, err := network.ParseHttpResult[binanceapi.OpenInterest](restLogPrefix, "GetOpenInterest", realUrlMissingInUnified(url, ac), method, "", nil, func(resp *http.Response, body []byte) {
+		binanceapi.ProcessResponse(resp, body, apiType(ac))
+	}, binanceapi.ErrorCallback)
+	return rst, err
+}
+
 // 获取当前的市场合约持仓量
 // pair: BTCUSD
 // contractType：ALL, CURRENT_QUARTER, NEXT_QUARTER, PERPETUAL
@@ -382,7 +409,7 @@ func GetMarketHold(symbolOrPair string, period string, limit int, ac APIClass) (
 }
 
 // 获取杠杆分层标准
-func GetLeverageBracket(symbolOrPair string, ac APIClass) (*[]binanceapi.LeverageBracket, error) {
+func GetLeverageBracket(cred *binanceapi.Credential, symbolOrPair string, ac APIClass) (*[]binanceapi.LeverageBracket, error) {
 	action := "/fapi/v1/leverageBracket"
 	method := "GET"
 	params := url.Values{}
@@ -392,7 +419,7 @@ func GetLeverageBracket(symbolOrPair string, ac APIClass) (*[]binanceapi.Leverag
 		params.Set("pair", symbolOrPair)
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rst, err := network.ParseHttpResult[[]binanceapi.LeverageBracket](
@@ -408,7 +435,7 @@ func GetLeverageBracket(symbolOrPair string, ac APIClass) (*[]binanceapi.Leverag
 }
 
 // 获取成交记录
-func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac APIClass) (*[]binanceapi.FutureUserTrade, error) {
+func GetUserTrade(cred *binanceapi.Credential, symbol string, t0, t1 time.Time, limit int, fromId int64, ac APIClass) (*[]binanceapi.FutureUserTrade, error) {
 	action := "/fapi/v1/userTrades"
 	method := "GET"
 	params := url.Values{}
@@ -429,7 +456,7 @@ func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac A
 		params.Set("limit", strconv.FormatInt(int64(limit), 10))
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rst, err := network.ParseHttpResult[[]binanceapi.FutureUserTrade](
@@ -445,7 +472,7 @@ func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac A
 }
 
 // 获取资金流水
-func GetAccountIncome(symbol string, incomeType string, t0, t1 time.Time, limit int, page int, ac APIClass) (*[]binanceapi.AccountIncome, error) {
+func GetAccountIncome(cred *binanceapi.Credential, symbol string, incomeType string, t0, t1 time.Time, limit int, page int, ac APIClass) (*[]binanceapi.AccountIncome, error) {
 	action := "/fapi/v1/income"
 	method := "GET"
 	params := url.Values{}
@@ -481,7 +508,7 @@ func GetAccountIncome(symbol string, incomeType string, t0, t1 time.Time, limit
 		params.Set("page", strconv.FormatInt(int64(page), 10))
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rst, err := network.ParseHttpResult[[]binanceapi.AccountIncome](
@@ -502,7 +529,7 @@ func GetAccountIncome(symbol string, incomeType string, t0, t1 time.Time, limit
 }
 
 // 获取当前仓位
-func GetPositionRisk(symbolOrPair string, ac APIClass) (*[]binanceapi.PositionRisk, error) {
+func GetPositionRisk(cred *binanceapi.Credential, symbolOrPair string, ac APIClass) (*[]binanceapi.PositionRisk, error) {
 	action := "/fapi/v2/positionRisk"
 	method := "GET"
 	params := url.Values{}
@@ -512,7 +539,7 @@ func GetPositionRisk(symbolOrPair string, ac APIClass) (*[]binanceapi.PositionRi
 		params.Set("pair", symbolOrPair)
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	// 只有经典U本位合约的url是v2，其他都是v1
@@ -532,3 +559,73 @@ func GetPositionRisk(symbolOrPair string, ac APIClass) (*[]binanceapi.PositionRi
 
 	return rst, err
 }
+
+// 倒计时撤销全部订单(dead-man switch)
+// countdownMs毫秒后撤销该交易对的所有挂单，0表示取消倒计时。需要在超时前重复调用以续期
+// 统一账户没有这个接口
+func CountdownCancelAll(cred *binanceapi.Credential, symbol string, countdownMs int64, ac APIClass) (*binanceapi.CountdownCancelAllResponse, error) {
+	action := "/fapi/v1/countdownCancelAll"
+	method := "POST"
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("countdownTime", strconv.FormatInt(countdownMs, 10))
+
+	header, paramstr, err := cred.Sign(params)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
+
+	rst, err := network.ParseHttpResult[binanceapi.CountdownCancelAllResponse](
+		restLogPrefix,
+		"CountdownCancelAll",
+		realUrlMissingInUnified(url, ac),
+		method,
+		"",
+		header, func(resp *http.Response, body []byte) {
+			binanceapi.ProcessResponse(resp, body, apiType(ac))
+		}, binanceapi.ErrorCallback)
+
+	return rst, err
+}
+
+// 下单
+// positionSide: BOTH(单向持仓)/LONG/SHORT，双向持仓模式下不能使用reduceOnly
+// price/quantity为已按交易对精度格式化的字符串，市价单price传空
+func MakeOrder(cred *binanceapi.Credential, symbol, side, positionSide, orderType, timeInForce, clientOrderID string, reduceOnly bool, price, quantity string, ac APIClass) (*binanceapi.MakeOrderResponse_Ack, error) {
+	action := "/fapi/v1/order"
+	method := "POST"
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("side", side)
+	params.Set("type", orderType)
+	params.Set("newClientOrderId", clientOrderID)
+	params.Set("quantity", quantity)
+	if len(positionSide) > 0 {
+		params.Set("positionSide", positionSide)
+	}
+	if len(price) > 0 {
+		params.Set("price", price)
+	}
+	if len(timeInForce) > 0 {
+		params.Set("timeInForce", timeInForce)
+	}
+	if reduceOnly {
+		params.Set("reduceOnly", "true")
+	}
+
+	header, paramstr, err := cred.Sign(params)
+	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
+
+	rst, err := network.ParseHttpResult[binanceapi.MakeOrderResponse_Ack](
+		restLogPrefix,
+		"MakeOrder",
+		realUrl(url, ac),
+		method,
+		"",
+		header, func(resp *http.Response, body []byte) {
+			binanceapi.ProcessResponse(resp, body, apiType(ac))
+		}, binanceapi.ErrorCallback)
+
+	return rst, err
+}
@@ -48,3 +48,19 @@ func (ws *WsClient) SubscribeContractInfo(fn api.OnRecvWSMsg, isUsdt bool) *api.
 	ws.publicStreams[streamName] = stream
 	return s
 }
+
+// 全市场强平订单
+func (ws *WsClient) SubscribeForceOrder(fn api.OnRecvWSMsg, isUsdt bool) *api.WsSubscriber {
+	streamName := "!forceOrder@arr"
+	s, stream := binanceapi.SubscribeWithStream[binanceapi.WSPayload_ForceOrder](baseUrl(isUsdt), streamName, logPrefix(isUsdt), fn)
+	ws.publicStreams[logPrefix(isUsdt)+streamName] = stream
+	return s
+}
+
+func (ws *WsClient) UnsubscribeForceOrder(isUsdt bool) {
+	streamName := logPrefix(isUsdt) + "!forceOrder@arr"
+	if stream, ok := ws.publicStreams[streamName]; ok {
+		stream.Stop()
+		delete(ws.publicStreams, streamName)
+	}
+}
@@ -22,13 +22,17 @@ import (
 	"github.com/aztecqt/dagger/api/binanceapi"
 	"github.com/aztecqt/dagger/util/logger"
 	"github.com/aztecqt/dagger/util/network"
-	"github.com/shopspring/decimal"
 )
 
 const rootUrl = "https://api.binance.com"
 const rootUrlUnifiled = "https://papi.binance.com"
 const restLogPrefix = "binance_spot_rest"
 
+// rest和websocket使用的所有地址，用于设置代理等
+func Urls() []string {
+	return []string{rootUrl, rootUrlUnifiled, binanceapi.SpotBaseUrl}
+}
+
 // 获取服务器时间（毫秒数）
 var serverTsDelta int64
 
@@ -99,7 +103,7 @@ func GetExchangeInfo_Symbols(symbol string) (*binanceapi.ExchangeInfo_Symbols, e
 	}
 	ep := rootUrl + action
 
-	rst, err := network.ParseHttpResult[binanceapi.ExchangeInfo_Symbols](restLogPrefix, "GetExchangeInfo_Symbols", ep, method, "", nil, func(resp *http.Response, body []byte) {
+	rst, err := network.ParseHttpResultStream[binanceapi.ExchangeInfo_Symbols](restLogPrefix, "GetExchangeInfo_Symbols", ep, method, "", nil, func(resp *http.Response, body []byte) {
 		binanceapi.ProcessResponse(resp, body, "spot")
 	}, binanceapi.ErrorCallback)
 	if err == nil && serverTsDelta == 0 {
@@ -108,6 +112,22 @@ func GetExchangeInfo_Symbols(symbol string) (*binanceapi.ExchangeInfo_Symbols, e
 	return rst, err
 }
 
+// 获取多个交易对的信息，避免拉取全部交易对
+func GetExchangeInfo_SymbolList(symbols []string) (*binanceapi.ExchangeInfo_Symbols, error) {
+	action := "/api/v3/exchangeInfo"
+	method := "GET"
+	params := url.Values{}
+	d, _ := json.Marshal(symbols)
+	params.Set("symbols", string(d))
+	action = action + "?" + params.Encode()
+	ep := rootUrl + action
+
+	rst, err := network.ParseHttpResultStream[binanceapi.ExchangeInfo_Symbols](restLogPrefix, "GetExchangeInfo_SymbolList", ep, method, "", nil, func(resp *http.Response, body []byte) {
+		binanceapi.ProcessResponse(resp, body, "spot")
+	}, binanceapi.ErrorCallback)
+	return rst, err
+}
+
 // 取K线
 // 返回：[[开盘时间，开盘价，最高，最低，收盘价，成交额]]
 /*
@@ -289,6 +309,22 @@ func GetBookTicker(symbols ...string) (*[]binanceapi.BookTicker, error) {
 	}
 }
 
+// 深度快照，limit最大5000
+func GetDepth(symbol string, limit int) (*binanceapi.DepthSnapshot, error) {
+	action := "/api/v3/depth"
+	method := "GET"
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, params.Encode())
+	rst, err := network.ParseHttpResultStream[binanceapi.DepthSnapshot](restLogPrefix, "GetDepth", ep, method, "", nil, func(resp *http.Response, body []byte) {
+		binanceapi.ProcessResponse(resp, body, "spot")
+	}, binanceapi.ErrorCallback)
+	return rst, err
+}
+
 // 24小时价格变动（好奇怪的名字）
 func Get24hrTicker(symbols ...string) (*[]binanceapi.Ticker24hr, error) {
 	action := "/api/v3/ticker/24hr"
@@ -330,10 +366,10 @@ func Get24hrTicker(symbols ...string) (*[]binanceapi.Ticker24hr, error) {
 }
 
 // ListenKey(UserDataStream)管理
-func GetListenKey() (*binanceapi.ListenKeyResponse, error) {
+func GetListenKey(cred *binanceapi.Credential) (*binanceapi.ListenKeyResponse, error) {
 	action := "/api/v3/userDataStream"
 	method := "POST"
-	header := binanceapi.SignerIns.HeaderWithApiKey()
+	header := cred.HeaderWithApiKey()
 	ep := fmt.Sprintf("%s%s", rootUrl, action)
 
 	rest, err := network.ParseHttpResult[binanceapi.ListenKeyResponse](
@@ -350,13 +386,13 @@ func GetListenKey() (*binanceapi.ListenKeyResponse, error) {
 	return rest, err
 }
 
-func KeepListenKey(listenKey string) (*binanceapi.ErrorMessage, error) {
+func KeepListenKey(cred *binanceapi.Credential, listenKey string) (*binanceapi.ErrorMessage, error) {
 	action := "/api/v3/userDataStream"
 	method := "PUT"
 
 	params := url.Values{}
 	params.Set("listenKey", listenKey)
-	header := binanceapi.SignerIns.HeaderWithApiKey()
+	header := cred.HeaderWithApiKey()
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, params.Encode())
 
 	rest, err := network.ParseHttpResult[binanceapi.ErrorMessage](
@@ -374,13 +410,13 @@ func KeepListenKey(listenKey string) (*binanceapi.ErrorMessage, error) {
 }
 
 // 获取现货账户权益
-func GetAccountInfo() (*binanceapi.AccountInfo, error) {
+func GetAccountInfo(cred *binanceapi.Credential) (*binanceapi.AccountInfo, error) {
 	action := "/api/v3/account"
 	method := "GET"
 
 	// 参数（无业务参数）
 	params := url.Values{}
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rest, err := network.ParseHttpResult[binanceapi.AccountInfo](
@@ -403,7 +439,9 @@ func GetAccountInfo() (*binanceapi.AccountInfo, error) {
 // LIMIT 限价单/MARKET 市价单
 // STOP_LOSS 止损单/STOP_LOSS_LIMIT 限价止损单/TAKE_PROFIT 止盈单/TAKE_PROFIT_LIMIT 限价止盈单
 // LIMIT_MAKER 限价只挂单
-func MakeOrder(symbol, side, orderType, clientOrderID string, price, quantity decimal.Decimal) (*binanceapi.MakeOrderResponse_Ack, error) {
+// 有效方式(timeInForce)：GTC/IOC/FOK，为空则不传（LIMIT_MAKER/MARKET不需要）
+// price/quantity为已按交易对精度格式化的字符串
+func MakeOrder(cred *binanceapi.Credential, symbol, side, orderType, timeInForce, clientOrderID string, price, quantity string) (*binanceapi.MakeOrderResponse_Ack, error) {
 	action := "/api/v3/order"
 	method := "POST"
 
@@ -413,11 +451,13 @@ func MakeOrder(symbol, side, orderType, clientOrderID string, price, quantity de
 	params.Set("side", side)
 	params.Set("type", orderType)
 	params.Set("newClientOrderId", clientOrderID)
-	params.Set("price", price.String())
-	params.Set("quantity", quantity.String())
-	params.Set("timeInForce", "GTC")
+	params.Set("price", price)
+	params.Set("quantity", quantity)
+	if len(timeInForce) > 0 {
+		params.Set("timeInForce", timeInForce)
+	}
 	params.Set("newOrderRespType", "ACK") // ACK/RESULT/FULL
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rest, err := network.ParseHttpResult[binanceapi.MakeOrderResponse_Ack](
@@ -434,9 +474,103 @@ func MakeOrder(symbol, side, orderType, clientOrderID string, price, quantity de
 	return rest, err
 }
 
+// 市价单
+// quantity和quoteOrderQty二选一，quoteOrderQty不为空时按计价币数量下单
+func MakeMarketOrder(cred *binanceapi.Credential, symbol, side, clientOrderID string, quantity, quoteOrderQty string) (*binanceapi.MakeOrderResponse_Ack, error) {
+	action := "/api/v3/order"
+	method := "POST"
+
+	// 参数
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("side", side)
+	params.Set("type", "MARKET")
+	params.Set("newClientOrderId", clientOrderID)
+	if len(quoteOrderQty) > 0 {
+		params.Set("quoteOrderQty", quoteOrderQty)
+	} else {
+		params.Set("quantity", quantity)
+	}
+	params.Set("newOrderRespType", "ACK")
+	header, paramstr, err := cred.Sign(params)
+	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
+
+	rest, err := network.ParseHttpResult[binanceapi.MakeOrderResponse_Ack](
+		restLogPrefix,
+		"MakeMarketOrder",
+		ep,
+		method,
+		"",
+		header,
+		func(resp *http.Response, body []byte) {
+			binanceapi.ProcessResponse(resp, body, "spot")
+		}, binanceapi.ErrorCallback)
+
+	return rest, err
+}
+
+// OCO订单：一个限价止盈单+一个止损限价单，一个成交后另一个自动撤销
+// 平多(SELL)时price高于市价、stopPrice低于市价，平空(BUY)时相反
+func MakeOcoOrder(cred *binanceapi.Credential, symbol, side, listClientOrderId, quantity, price, stopPrice, stopLimitPrice string) (*binanceapi.OrderListResponse, error) {
+	action := "/api/v3/order/oco"
+	method := "POST"
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("side", side)
+	params.Set("quantity", quantity)
+	params.Set("price", price)
+	params.Set("stopPrice", stopPrice)
+	params.Set("stopLimitPrice", stopLimitPrice)
+	params.Set("stopLimitTimeInForce", "GTC")
+	if len(listClientOrderId) > 0 {
+		params.Set("listClientOrderId", listClientOrderId)
+	}
+	header, paramstr, err := cred.Sign(params)
+	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
+
+	rest, err := network.ParseHttpResult[binanceapi.OrderListResponse](
+		restLogPrefix,
+		"MakeOcoOrder",
+		ep,
+		method,
+		"",
+		header,
+		func(resp *http.Response, body []byte) {
+			binanceapi.ProcessResponse(resp, body, "spot")
+		}, binanceapi.ErrorCallback)
+
+	return rest, err
+}
+
+// 撤销OCO订单
+func CancelOrderList(cred *binanceapi.Credential, symbol string, orderListId int64) (*binanceapi.OrderListResponse, error) {
+	action := "/api/v3/orderList"
+	method := "DELETE"
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("orderListId", fmt.Sprintf("%d", orderListId))
+	header, paramstr, err := cred.Sign(params)
+	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
+
+	rest, err := network.ParseHttpResult[binanceapi.OrderListResponse](
+		restLogPrefix,
+		"CancelOrderList",
+		ep,
+		method,
+		"",
+		header,
+		func(resp *http.Response, body []byte) {
+			binanceapi.ProcessResponse(resp, body, "spot")
+		}, binanceapi.ErrorCallback)
+
+	return rest, err
+}
+
 // 撤单
 // 有orderId则优先使用orderId
-func CancelOrder(symbol string, orderId int64, clientOrderId string) (*binanceapi.CancelOrderResponse, error) {
+func CancelOrder(cred *binanceapi.Credential, symbol string, orderId int64, clientOrderId string) (*binanceapi.CancelOrderResponse, error) {
 	action := "/api/v3/order"
 	method := "DELETE"
 
@@ -448,9 +582,9 @@ func CancelOrder(symbol string, orderId int64, clientOrderId string) (*binanceap
 	} else if len(clientOrderId) > 0 {
 		params.Set("origClientOrderId", clientOrderId)
 	} else {
-		logger.LogPanic(restLogPrefix, "CancelOrder-no orderId and no clientOrderId")
+		return nil, logger.LogStrict(restLogPrefix, "CancelOrder-no orderId and no clientOrderId")
 	}
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rest, err := network.ParseHttpResult[binanceapi.CancelOrderResponse](
@@ -468,14 +602,14 @@ func CancelOrder(symbol string, orderId int64, clientOrderId string) (*binanceap
 }
 
 // 撤销某一交易对下的所有订单
-func CancelOpenOrders(symbol string) (*binanceapi.CancelOpenOrdersResponse, *binanceapi.ErrorMessage, error) {
+func CancelOpenOrders(cred *binanceapi.Credential, symbol string) (*binanceapi.CancelOpenOrdersResponse, *binanceapi.ErrorMessage, error) {
 	action := "/api/v3/openOrders"
 	method := "DELETE"
 
 	// 参数
 	params := url.Values{}
 	params.Set("symbol", symbol)
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	var errmsg *binanceapi.ErrorMessage
@@ -498,7 +632,7 @@ func CancelOpenOrders(symbol string) (*binanceapi.CancelOpenOrdersResponse, *bin
 }
 
 // 查询订单
-func GetOrder(symbol string, orderId int64, clientOrderId string) (*binanceapi.GetOrderResponse, error) {
+func GetOrder(cred *binanceapi.Credential, symbol string, orderId int64, clientOrderId string) (*binanceapi.GetOrderResponse, error) {
 	action := "/api/v3/order"
 	method := "GET"
 
@@ -510,10 +644,10 @@ func GetOrder(symbol string, orderId int64, clientOrderId string) (*binanceapi.G
 	} else if len(clientOrderId) > 0 {
 		params.Set("origClientOrderId", clientOrderId)
 	} else {
-		logger.LogPanic(restLogPrefix, "GetOrder-no orderId and no clientOrderId")
+		return nil, logger.LogStrict(restLogPrefix, "GetOrder-no orderId and no clientOrderId")
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	resp, err := network.ParseHttpResult[binanceapi.GetOrderResponse](
@@ -533,7 +667,7 @@ func GetOrder(symbol string, orderId int64, clientOrderId string) (*binanceapi.G
 
 // 查询所有挂单
 // symbol不指定，则会返回所有交易对的挂单，但成本为40。指定的话成本为3
-func GetOpenOrders(symbol string) (*binanceapi.GetOpenOrdersResponse, *binanceapi.ErrorMessage, error) {
+func GetOpenOrders(cred *binanceapi.Credential, symbol string) (*binanceapi.GetOpenOrdersResponse, *binanceapi.ErrorMessage, error) {
 	action := "/api/v3/openOrders"
 	method := "GET"
 
@@ -542,7 +676,7 @@ func GetOpenOrders(symbol string) (*binanceapi.GetOpenOrdersResponse, *binanceap
 	if len(symbol) > 0 {
 		params.Set("symbol", symbol)
 	}
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	var errmsg *binanceapi.ErrorMessage
@@ -565,13 +699,13 @@ func GetOpenOrders(symbol string) (*binanceapi.GetOpenOrdersResponse, *binanceap
 }
 
 // 测试接口
-func GetWalletSystemStatus() {
+func GetWalletSystemStatus(cred *binanceapi.Credential) {
 	action := "/sapi/v1/system/status"
 	method := "GET"
 
 	// 参数
 	params := url.Values{}
-	header, paramstr, _ := binanceapi.SignerIns.Sign(params)
+	header, paramstr, _ := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	network.ParseHttpResult[interface{}](
@@ -586,14 +720,14 @@ func GetWalletSystemStatus() {
 		}, binanceapi.ErrorCallback)
 }
 
-func WalletDust() {
+func WalletDust(cred *binanceapi.Credential) {
 	action := "/sapi/v1/asset/dust"
 	method := "POST"
 
 	// 参数
 	params := url.Values{}
 	params.Add("asset", "XRP")
-	header, paramstr, _ := binanceapi.SignerIns.Sign(params)
+	header, paramstr, _ := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	network.ParseHttpResult[interface{}](
@@ -609,7 +743,7 @@ func WalletDust() {
 }
 
 // 测试接口
-func MakeMarginOrder(symbol, side, orderType, clientOrderID string, price, quantity decimal.Decimal) (*binanceapi.MakeOrderResponse_Ack, error) {
+func MakeMarginOrder(cred *binanceapi.Credential, symbol, side, orderType, clientOrderID string, price, quantity string) (*binanceapi.MakeOrderResponse_Ack, error) {
 	action := "/sapi/v1/margin/order"
 	method := "POST"
 
@@ -619,11 +753,11 @@ func MakeMarginOrder(symbol, side, orderType, clientOrderID string, price, quant
 	params.Set("side", side)
 	params.Set("type", orderType)
 	params.Set("newClientOrderId", clientOrderID)
-	params.Set("price", price.String())
-	params.Set("quantity", quantity.String())
+	params.Set("price", price)
+	params.Set("quantity", quantity)
 	params.Set("timeInForce", "GTC")
 	params.Set("newOrderRespType", "ACK") // ACK/RESULT/FULL
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	ep := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rest, err := network.ParseHttpResult[binanceapi.MakeOrderResponse_Ack](
@@ -641,7 +775,7 @@ func MakeMarginOrder(symbol, side, orderType, clientOrderID string, price, quant
 }
 
 // 获取成交记录
-func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac APIClass) (*[]binanceapi.SpotUserTrade, error) {
+func GetUserTrade(cred *binanceapi.Credential, symbol string, t0, t1 time.Time, limit int, fromId int64, ac APIClass) (*[]binanceapi.SpotUserTrade, error) {
 	action := "/api/v3/myTrades"
 	method := "GET"
 	params := url.Values{}
@@ -666,7 +800,7 @@ func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac A
 		params.Set("isIsolated", "TRUE")
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 	url = realUrl(url, ac)
 
@@ -683,12 +817,12 @@ func GetUserTrade(symbol string, t0, t1 time.Time, limit int, fromId int64, ac A
 }
 
 // 获取下架计划
-func GetDelistPlan() (*[]binanceapi.DelistPlan, error) {
+func GetDelistPlan(cred *binanceapi.Credential) (*[]binanceapi.DelistPlan, error) {
 	action := "/sapi/v1/spot/delist-schedule"
 	method := "GET"
 	ep := rootUrl + action
 	params := url.Values{}
-	header, _, err := binanceapi.SignerIns.Sign(params)
+	header, _, err := cred.Sign(params)
 	rst, err := network.ParseHttpResult[[]binanceapi.DelistPlan](
 		restLogPrefix,
 		"GetDelistPlan",
@@ -710,12 +844,12 @@ func GetDelistPlan() (*[]binanceapi.DelistPlan, error) {
 }
 
 // 获取资产的质押折扣率
-func GetCollateralRate() (*[]binanceapi.CollateralRate, error) {
+func GetCollateralRate(cred *binanceapi.Credential) (*[]binanceapi.CollateralRate, error) {
 	action := "/sapi/v1/portfolio/collateralRate"
 	method := "GET"
 	ep := rootUrl + action
 	params := url.Values{}
-	header, _, err := binanceapi.SignerIns.Sign(params)
+	header, _, err := cred.Sign(params)
 	rst, err := network.ParseHttpResult[[]binanceapi.CollateralRate](
 		restLogPrefix,
 		"GetCollateralRate",
@@ -731,7 +865,7 @@ func GetCollateralRate() (*[]binanceapi.CollateralRate, error) {
 
 // 获取利息历史
 // asset: USDT
-func GetMarginInterestHistory(asset string, t0, t1 time.Time, ac APIClass) (*binanceapi.GetInterestHistoryResp, error) {
+func GetMarginInterestHistory(cred *binanceapi.Credential, asset string, t0, t1 time.Time, ac APIClass) (*binanceapi.GetInterestHistoryResp, error) {
 	ep := ""
 	switch ac {
 	case API_ClassicSpot:
@@ -764,7 +898,7 @@ func GetMarginInterestHistory(asset string, t0, t1 time.Time, ac APIClass) (*bin
 	}
 
 	params.Add("size", "100")
-	header, _, err := binanceapi.SignerIns.Sign(params)
+	header, _, err := cred.Sign(params)
 
 	paramsStr := params.Encode()
 	ep = ep + "?" + paramsStr
@@ -783,7 +917,7 @@ func GetMarginInterestHistory(asset string, t0, t1 time.Time, ac APIClass) (*bin
 
 // 获取交易手续费
 // symbol可以不填
-func GetTradeFee(symbol string) (*binanceapi.GetSpotTradeFeeResp, error) {
+func GetTradeFee(cred *binanceapi.Credential, symbol string) (*binanceapi.GetSpotTradeFeeResp, error) {
 	action := "/sapi/v1/asset/tradeFee"
 	method := "GET"
 	params := url.Values{}
@@ -791,7 +925,7 @@ func GetTradeFee(symbol string) (*binanceapi.GetSpotTradeFeeResp, error) {
 		params.Set("symbol", symbol)
 	}
 
-	header, paramstr, err := binanceapi.SignerIns.Sign(params)
+	header, paramstr, err := cred.Sign(params)
 	url := fmt.Sprintf("%s%s?%s", rootUrl, action, paramstr)
 
 	rst, err := network.ParseHttpResult[binanceapi.GetSpotTradeFeeResp](
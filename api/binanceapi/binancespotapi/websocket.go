@@ -11,11 +11,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aztecqt/dagger/api"
 	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 )
 
 const wsLogPrefix = "binance_spot_ws"
@@ -23,6 +26,12 @@ const wsLogPrefix = "binance_spot_ws"
 type WsClient struct {
 	userStream    *binanceapi.WsStream
 	publicStreams map[string]*binanceapi.WsStream
+	cred          *binanceapi.Credential // 用户数据使用的账户，nil为默认账户
+}
+
+// 需在SubscribeUserData之前调用
+func (ws *WsClient) SetCredential(cred *binanceapi.Credential) {
+	ws.cred = cred
 }
 
 func (ws *WsClient) Start() {
@@ -81,58 +90,167 @@ func (ws *WsClient) UnsubscribeDepth(pair string) {
 	}
 }
 
+// 增量深度，配合rest深度快照维护完整的本地盘口
+func (ws *WsClient) SubscribeDepthDiff(pair string, fn api.OnRecvWSMsg) *api.WsSubscriber {
+	pair = strings.ToLower(pair)
+	streamName := fmt.Sprintf("%s@depth@100ms", pair)
+	s, stream := binanceapi.SubscribeWithStream[binanceapi.WSPayload_DepthDiff](binanceapi.SpotBaseUrl, streamName, wsLogPrefix, fn)
+	ws.publicStreams[streamName] = stream
+	return s
+}
+
+func (ws *WsClient) UnsubscribeDepthDiff(pair string) {
+	pair = strings.ToLower(pair)
+	streamName := fmt.Sprintf("%s@depth@100ms", pair)
+	if stream, ok := ws.publicStreams[streamName]; ok {
+		stream.Stop()
+		delete(ws.publicStreams, streamName)
+	}
+}
+
+// 订阅某个交易对深度stream(简略深度或增量深度)的连接状态，需在订阅深度之后调用
+func (ws *WsClient) SubscribeDepthState(pair string, fn func(e network.ConnEvent)) bool {
+	pair = strings.ToLower(pair)
+	for _, streamName := range []string{fmt.Sprintf("%s@depth@100ms", pair), fmt.Sprintf("%s@depth10@100ms", pair)} {
+		if stream, ok := ws.publicStreams[streamName]; ok {
+			stream.SubscribeState(fn)
+			return true
+		}
+	}
+	return false
+}
+
+func (ws *WsClient) depthStream(pair string) *binanceapi.WsStream {
+	pair = strings.ToLower(pair)
+	for _, streamName := range []string{fmt.Sprintf("%s@depth@100ms", pair), fmt.Sprintf("%s@depth10@100ms", pair)} {
+		if stream, ok := ws.publicStreams[streamName]; ok {
+			return stream
+		}
+	}
+	return nil
+}
+
+// 某个交易对深度stream最近一次收到数据(含ping/pong)的时间，没有订阅时为零值
+func (ws *WsClient) DepthLastRecvTime(pair string) time.Time {
+	if stream := ws.depthStream(pair); stream != nil {
+		return stream.LastRecvTime()
+	}
+	return time.Time{}
+}
+
+// ping某个交易对的深度stream，用于在没有推送时确认连接
+func (ws *WsClient) PingDepth(pair string) {
+	if stream := ws.depthStream(pair); stream != nil {
+		stream.Ping()
+	}
+}
+
+// 归集成交
+func (ws *WsClient) SubscribeAggTrade(pair string, fn api.OnRecvWSMsg) *api.WsSubscriber {
+	pair = strings.ToLower(pair)
+	streamName := fmt.Sprintf("%s@aggTrade", pair)
+	s, stream := binanceapi.SubscribeWithStream[binanceapi.WSPayload_AggTrade](binanceapi.SpotBaseUrl, streamName, wsLogPrefix, fn)
+	ws.publicStreams[streamName] = stream
+	return s
+}
+
+func (ws *WsClient) UnsubscribeAggTrade(pair string) {
+	pair = strings.ToLower(pair)
+	streamName := fmt.Sprintf("%s@aggTrade", pair)
+	if stream, ok := ws.publicStreams[streamName]; ok {
+		stream.Stop()
+		delete(ws.publicStreams, streamName)
+	}
+}
+
 // 订阅用户信息需要先获取ListenKey，并且每间隔一段时间就保活这个ListenKey
-// 暂时每处理保活失败的情况，仅输出日志
+// 保活失败时(listenKey已过期)主动重连，重连时重新获取listenKey
 func (ws *WsClient) SubscribeUserData(fnAccountUpdate, fnOrderUpdate api.OnRecvWSMsg) *api.WsSubscriber {
-	resp, err := GetListenKey()
-	if err != nil {
-		logger.LogImportant(wsLogPrefix, "get listen-key failed, err=%s", err.Error())
-		return nil
-	} else if resp.Code != 0 {
-		logger.LogImportant(wsLogPrefix, "get listen-key failed, code=%d, msg=%s", resp.Code, resp.Message)
+	if ws.userStream != nil {
 		return nil
-	} else if len(resp.ListenKey) == 0 {
-		logger.LogImportant(wsLogPrefix, "get listen-key failed, no key")
+	}
+
+	listenKey := ws.fetchListenKey()
+	if len(listenKey) == 0 {
 		return nil
-	} else {
-		listenKey := resp.ListenKey
-		if ws.userStream == nil {
-			ws.userStream = new(binanceapi.WsStream)
-			s := ws.userStream.Start(binanceapi.SpotBaseUrl, listenKey, func(rawMsg api.WSRawMsg) {
-				localTime := time.Now()
-				if !strings.Contains(rawMsg.Str, "result") {
-					// 将rawMsg序列化成对象，并返回
-					payload := binanceapi.WSPayload_Common{}
-					json.Unmarshal(rawMsg.Data, &payload)
-					if payload.EventType == binanceapi.WSPayloadEventType_AccountUpdate {
-						au := binanceapi.WSPayload_AccountUpdate{}
-						json.Unmarshal(rawMsg.Data, &au)
-						if fnAccountUpdate != nil {
-							fnAccountUpdate(au)
-						}
-					} else if payload.EventType == binanceapi.WSPayloadEventType_OrderUpdate {
-						ou := binanceapi.WSPayload_OrderUpdate{}
-						json.Unmarshal(rawMsg.Data, &ou)
-						ou.LocalTime = localTime
-						if fnOrderUpdate != nil {
-							fnOrderUpdate(ou)
-						}
-					}
-				}
-			})
+	}
 
-			go func() {
-				for ws.userStream != nil /*代表没有反订阅*/ {
-					time.Sleep(time.Minute * 10)
-					KeepListenKey(listenKey)
+	muKey := sync.Mutex{}
+	firstConn := true
+	stream := new(binanceapi.WsStream)
+	ws.userStream = stream
+	s := stream.StartDynamic(binanceapi.SpotBaseUrl, "user-data", func() string {
+		muKey.Lock()
+		defer muKey.Unlock()
+		if !firstConn {
+			if key := ws.fetchListenKey(); len(key) > 0 {
+				listenKey = key
+			}
+		}
+		firstConn = false
+		return listenKey
+	}, func(rawMsg api.WSRawMsg) {
+		localTime := time.Now()
+		if !strings.Contains(rawMsg.Str, "result") {
+			// 将rawMsg序列化成对象，并返回
+			payload := binanceapi.WSPayload_Common{}
+			json.Unmarshal(rawMsg.Data, &payload)
+			if payload.EventType == binanceapi.WSPayloadEventType_AccountUpdate {
+				au := binanceapi.WSPayload_AccountUpdate{}
+				json.Unmarshal(rawMsg.Data, &au)
+				if fnAccountUpdate != nil {
+					fnAccountUpdate(au)
+				}
+			} else if payload.EventType == binanceapi.WSPayloadEventType_OrderUpdate {
+				ou := binanceapi.WSPayload_OrderUpdate{}
+				json.Unmarshal(rawMsg.Data, &ou)
+				ou.LocalTime = localTime
+				if fnOrderUpdate != nil {
+					fnOrderUpdate(ou)
 				}
-			}()
+			} else if payload.EventType == binanceapi.WSPayloadEventType_ListenKeyExpired {
+				stream.Reconnect("listen-key expired")
+			}
+		}
+	})
+
+	go func() {
+		defer util.DefaultRecover()
+		for ws.userStream == stream /*代表没有反订阅*/ {
+			time.Sleep(time.Minute * 10)
+			if ws.userStream != stream {
+				break
+			}
 
-			return s
-		} else {
-			return nil
+			muKey.Lock()
+			key := listenKey
+			muKey.Unlock()
+			resp, err := KeepListenKey(ws.cred, key)
+			if err != nil {
+				logger.LogImportant(wsLogPrefix, "keep listen-key failed, err=%s", err.Error())
+			} else if resp.Code != 0 {
+				logger.LogImportant(wsLogPrefix, "keep listen-key failed, code=%d, msg=%s", resp.Code, resp.Message)
+				stream.Reconnect("keep listen-key failed")
+			}
 		}
+	}()
+
+	return s
+}
+
+func (ws *WsClient) fetchListenKey() string {
+	resp, err := GetListenKey(ws.cred)
+	if err != nil {
+		logger.LogImportant(wsLogPrefix, "get listen-key failed, err=%s", err.Error())
+		return ""
+	} else if resp.Code != 0 {
+		logger.LogImportant(wsLogPrefix, "get listen-key failed, code=%d, msg=%s", resp.Code, resp.Message)
+		return ""
+	} else if len(resp.ListenKey) == 0 {
+		logger.LogImportant(wsLogPrefix, "get listen-key failed, no key")
+		return ""
 	}
+	return resp.ListenKey
 }
 
 func (ws *WsClient) UnsubscribeUserData() {
@@ -11,10 +11,24 @@ import (
 	"time"
 
 	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/util"
 )
 
 var logPrefix = "bn.cached"
 var DisableCached = false
 
+// 缓存文件的格式版本。格式变化时递增版本号，并注册迁移函数
+const (
+	klineMagic         = "BNKL"
+	klineVersion       = 1
+	fundingFeesKind    = "binance_fundingfees"
+	fundingFeesVersion = 1
+)
+
+func init() {
+	// 版本0为没有版本信息的旧文件，格式与版本1相同
+	util.RegisterMigration(fundingFeesKind, 0, util.NoopMigration)
+}
+
 type fnKlineRaw func(symbol, interval string, t0, t1 time.Time, limit int) (*binanceapi.KLine, error)
 type fnprg func(prg time.Time)
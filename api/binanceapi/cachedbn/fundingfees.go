@@ -82,7 +82,7 @@ func loadFundingFeesOfMonth(instId string, dt time.Time) ([]binanceapi.FundingFe
 
 	path := pathOfFundingFees(instId, dt)
 	result := make([]binanceapi.FundingFee, 0)
-	return result, util.ObjectFromFile(path, &result)
+	return result, util.VersionedObjectFromFile(path, fundingFeesKind, fundingFeesVersion, &result)
 }
 
 func saveFundingFees(instId string, fees []binanceapi.FundingFee) {
@@ -100,7 +100,7 @@ func saveFundingFees(instId string, fees []binanceapi.FundingFee) {
 
 	// 执行保存
 	for path, v := range dataByPath {
-		util.ObjectToFile(path, v)
+		util.VersionedObjectToFile(path, fundingFeesKind, fundingFeesVersion, v)
 	}
 }
 
@@ -133,9 +133,11 @@ func loadKlineOfDate(instType, instId, bar string, dt time.Time) ([]binanceapi.K
 	}
 	path := klineCachePath(instType, instId, bar, dt)
 	result := make([]binanceapi.KLineUnit, 0)
-	ok := util.FileDeserializeToObjects(
+	ok := util.FileDeserializeToObjectsVersioned(
 		path,
-		func() *binanceapi.KLineUnit { return &binanceapi.KLineUnit{} },
+		klineMagic,
+		klineVersion,
+		func(version uint16) *binanceapi.KLineUnit { return &binanceapi.KLineUnit{} },
 		func(o *binanceapi.KLineUnit) bool { result = append(result, *o); return true },
 	)
 	return result, ok
@@ -162,7 +164,7 @@ func saveKlines(instType, instId, bar string, kl []binanceapi.KLineUnit) {
 			ku.Serialize(buf)
 		}
 
-		util.BytesToFile(path, buf.Bytes())
+		util.VersionedBytesToFile(path, klineMagic, klineVersion, buf.Bytes())
 	}
 }
 
@@ -25,6 +25,9 @@ var MuApiStatus sync.Mutex
 // 处理rest请求的头
 func ProcessResponse(resp *http.Response, body []byte, apiType string) *ErrorMessage {
 	if resp != nil {
+		// 校正权重预算，见weight.go
+		updateWeight(resp)
+
 		// 超频判断
 		for keystr, value := range resp.Header {
 			if strings.Contains(keystr, "X-Mbx-Used-Weight-") {
@@ -44,7 +47,7 @@ func ProcessResponse(resp *http.Response, body []byte, apiType string) *ErrorMes
 		}
 
 		// 尝试解析错误码
-		bodystr := string(body[:20])
+		bodystr := string(body[:min(len(body), 20)])
 		if strings.Contains(bodystr, `"code"`) {
 			errmsg := new(ErrorMessage)
 			json.Unmarshal(body, errmsg)
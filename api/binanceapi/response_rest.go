@@ -46,15 +46,17 @@ type ExchangeInfo_RateLimit struct {
 
 // symbol集合
 type Symbol struct {
-	Symbol        string                   `json:"symbol"`
-	Status        string                   `json:"status"`
-	Status2       string                   `json:"contractStatus"`
-	BaseCcy       string                   `json:"baseAsset"`
-	QuoteCcy      string                   `json:"quoteAsset"`
-	ContractSize  decimal.Decimal          `json:"contractSize"`
-	SpotEnabled   bool                     `json:"isSpotTradingAllowed"`
-	MarginEnabled bool                     `json:"isMarginTradingAllowed"`
-	Filters       []map[string]interface{} `json:"filters"`
+	Symbol         string                   `json:"symbol"`
+	Status         string                   `json:"status"`
+	Status2        string                   `json:"contractStatus"`
+	BaseCcy        string                   `json:"baseAsset"`
+	QuoteCcy       string                   `json:"quoteAsset"`
+	QuotePrecision int32                    `json:"quoteAssetPrecision"`
+	ContractSize   decimal.Decimal          `json:"contractSize"`
+	SpotEnabled    bool                     `json:"isSpotTradingAllowed"`
+	MarginEnabled  bool                     `json:"isMarginTradingAllowed"`
+	OrderTypes     []string                 `json:"orderTypes"`
+	Filters        []map[string]interface{} `json:"filters"`
 }
 
 func (s *Symbol) FindFilterByType(ftype string) map[string]interface{} {
@@ -95,6 +97,14 @@ type BookTicker struct {
 	Ts          int64           `json:"time"`
 }
 
+// 深度快照
+type DepthSnapshot struct {
+	ErrorMessage
+	LastUpdateId int64               `json:"lastUpdateId"`
+	Bids         [][]decimal.Decimal `json:"bids"`
+	Asks         [][]decimal.Decimal `json:"asks"`
+}
+
 // 24小时价格变动
 type Ticker24hr struct {
 	Symbol      string          `json:"symbol"`
@@ -240,6 +250,22 @@ type CancelOrderResponse struct {
 	ClientOrderID string `json:"clientOrderId"`
 }
 
+// OCO下单/撤单返回
+type OrderListResponse struct {
+	ErrorMessage
+	OrderListId       int64  `json:"orderListId"`
+	ListClientOrderId string `json:"listClientOrderId"`
+	ListOrderStatus   string `json:"listOrderStatus"` // EXECUTING/ALL_DONE/REJECT
+	Symbol            string `json:"symbol"`
+}
+
+// 倒计时撤销全部订单
+type CountdownCancelAllResponse struct {
+	ErrorMessage
+	Symbol        string `json:"symbol"`
+	CountdownTime string `json:"countdownTime"`
+}
+
 // 撤销交易对订单
 type CancelOpenOrdersResponse []OrderStatus
 
@@ -279,6 +305,14 @@ type FundingFee struct {
 	FundingRate      decimal.Decimal `json:"fundingRate"`
 }
 
+// 实时持仓总量
+type OpenInterest struct {
+	ErrorMessage
+	Symbol       string          `json:"symbol"`
+	OpenInterest decimal.Decimal `json:"openInterest"` // U本位为币数量，币本位为张数
+	TimeStamp    int64           `json:"time"`
+}
+
 // 市场持仓量
 type MarketHold struct {
 	Pair                 string          `json:"pair"`
@@ -388,6 +422,14 @@ func (p *PremiumIndexResp) Parse() {
 	p.Time = time.UnixMilli(p.TimeStamp)
 }
 
+// 资金费率配置。只返回经过调整的币种，未返回的币种为默认配置（8小时结算）
+type FundingInfo struct {
+	Symbol                   string          `json:"symbol"`
+	AdjustedFundingRateCap   decimal.Decimal `json:"adjustedFundingRateCap"`
+	AdjustedFundingRateFloor decimal.Decimal `json:"adjustedFundingRateFloor"`
+	FundingIntervalHours     int             `json:"fundingIntervalHours"`
+}
+
 // 质押折扣率
 type CollateralRate struct {
 	Asset          string          `json:"asset"`
@@ -24,6 +24,9 @@ type WSPayload_MiniTicker struct {
 	WSPayload_Common
 	Pair        string          `json:"s"`
 	LatestPrice decimal.Decimal `json:"c"`
+	Open        decimal.Decimal `json:"o"`
+	High        decimal.Decimal `json:"h"`
+	Low         decimal.Decimal `json:"l"`
 	Volume      decimal.Decimal `json:"v"`
 	VolumeUsd   decimal.Decimal `json:"q"`
 }
@@ -33,6 +36,9 @@ type WSPayload_Ticker struct {
 	WSPayload_Common
 	Pair        string          `json:"s"`
 	LatestPrice decimal.Decimal `json:"c"`
+	Open        decimal.Decimal `json:"o"`
+	High        decimal.Decimal `json:"h"`
+	Low         decimal.Decimal `json:"l"`
 	Volume      decimal.Decimal `json:"v"`
 	VolumeUsd   decimal.Decimal `json:"q"`
 	Buy1        decimal.Decimal `json:"b"`
@@ -47,10 +53,49 @@ type WSPayload_Depth struct {
 	Asks [][]decimal.Decimal `json:"asks"`
 }
 
+// 归集成交
+type WSPayload_AggTrade struct {
+	WSPayload_Common
+	Pair         string          `json:"s"`
+	AggTradeId   int64           `json:"a"`
+	Price        decimal.Decimal `json:"p"`
+	Quantity     decimal.Decimal `json:"q"`
+	FirstTradeId int64           `json:"f"`
+	LastTradeId  int64           `json:"l"`
+	TradeTime    int64           `json:"T"`
+	BuyerIsMaker bool            `json:"m"` // true表示主动卖出
+}
+
+// 强平订单。每个品种每秒最多推送一条(最后一笔)
+type WSPayload_ForceOrder struct {
+	WSPayload_Common
+	Order struct {
+		Symbol    string          `json:"s"`
+		Side      string          `json:"S"` // BUY/SELL
+		Quantity  decimal.Decimal `json:"q"`
+		Price     decimal.Decimal `json:"p"`
+		AvgPrice  decimal.Decimal `json:"ap"`
+		Status    string          `json:"X"`
+		FilledQty decimal.Decimal `json:"z"` // 累计成交量
+		TradeTime int64           `json:"T"`
+	} `json:"o"`
+}
+
+// 增量深度。按U/u检查连续性，不连续时需重新获取快照
+type WSPayload_DepthDiff struct {
+	WSPayload_Common
+	Pair          string              `json:"s"`
+	FirstUpdateId int64               `json:"U"`
+	FinalUpdateId int64               `json:"u"`
+	Bids          [][]decimal.Decimal `json:"b"`
+	Asks          [][]decimal.Decimal `json:"a"`
+}
+
 // 账户信息推送有三种Payload，分别为：
 const WSPayloadEventType_AccountUpdate = "outboundAccountPosition"        // 账户更新
 const WSAccountPayloadEventType_BalanceUpdate = "outboundAccountPosition" // 余额更新(暂未使用)
 const WSPayloadEventType_OrderUpdate = "executionReport"                  // 订单更新
+const WSPayloadEventType_ListenKeyExpired = "listenKeyExpired"            // listenKey过期
 
 // 账户更新
 type WSPayload_AccountUpdate struct {
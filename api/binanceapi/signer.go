@@ -2,37 +2,157 @@
  * @Author: aztec
  * @Date: 2022-03-26 10:22:43
  * @Description: binance消息签名器
- *
+ * Signer负责对payload签名，支持binance的三种api key：HMAC-SHA256、Ed25519(ws api必需)、RSA
+ * Credential = api key + Signer + 服务器时间，每个账户一个，可同时存在多个账户、多种key
+ * rest接口的cred参数为nil时使用Init设置的默认账户(SignerIns)
  * Copyright (c) 2022 by aztec, All Rights Reserved.
  */
 package binanceapi
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/url"
 	"time"
 
+	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
 )
 
-type signer struct {
+type Signer interface {
+	// 返回放在signature参数里的签名
+	Sign(payload string) (string, error)
+}
+
+// #region 各种key
+type hmacSigner struct {
+	secret []byte
+}
+
+func NewHmacSigner(secret string) Signer {
+	return &hmacSigner{secret: []byte(secret)}
+}
+
+func (s *hmacSigner) Sign(payload string) (string, error) {
+	if len(s.secret) == 0 {
+		return "", errors.New("no secret")
+	}
+	return getParamHmacSHA256Sign(payload, string(s.secret))
+}
+
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// privateKeyPem为PKCS#8格式的私钥
+func NewEd25519Signer(privateKeyPem string) (Signer, error) {
+	key, err := parsePrivateKey(privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return &ed25519Signer{key: k}, nil
+	}
+	return nil, errors.New("not an ed25519 private key")
+}
+
+func (s *ed25519Signer) Sign(payload string) (string, error) {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, []byte(payload))), nil
+}
+
+type rsaSigner struct {
+	key *rsa.PrivateKey
+}
+
+// privateKeyPem为PKCS#8或PKCS#1格式的私钥
+func NewRsaSigner(privateKeyPem string) (Signer, error) {
+	key, err := parsePrivateKey(privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return &rsaSigner{key: k}, nil
+	}
+	return nil, errors.New("not a rsa private key")
+}
+
+func (s *rsaSigner) Sign(payload string) (string, error) {
+	hash := sha256.Sum256([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func parsePrivateKey(privateKeyPem string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(privateKeyPem))
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	} else {
+		return nil, fmt.Errorf("parse private key failed: %s", err.Error())
+	}
+}
+
+func getParamHmacSHA256Sign(message string, secretKey string) (string, error) {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	_, err := mac.Write([]byte(message))
+	if err != nil {
+		return "", err
+	}
+	str := fmt.Sprintf("%x", (mac.Sum(nil)))
+	return str, nil
+}
+
+// #endregion
+
+type Credential struct {
 	key        string
-	secret     string
+	signer     Signer
 	serverTsFn func() int64
 }
 
-var SignerIns *signer
+// 默认账户
+var SignerIns *Credential
 var signerLogPrefix = "bn_signer"
 
 var inited bool = false
 
+func NewCredential(key string, signer Signer, serverTsFn func() int64) *Credential {
+	c := new(Credential)
+	c.key = key
+	c.signer = signer
+	c.serverTsFn = serverTsFn
+	return c
+}
+
+// 用HMAC key初始化默认账户
 func Init(key string, secret string, serverTsFn func() int64) {
-	SignerIns = new(signer)
-	SignerIns.key = key
-	SignerIns.secret = secret
-	SignerIns.serverTsFn = serverTsFn
+	InitWithSigner(key, util.ValueIf[Signer](len(secret) > 0, NewHmacSigner(secret), nil), serverTsFn)
+}
+
+// 用任意类型的key初始化默认账户
+func InitWithSigner(key string, signer Signer, serverTsFn func() int64) {
+	SignerIns = NewCredential(key, signer, serverTsFn)
 
 	// 获取服务器时间跟本地时间的差
 	for {
@@ -48,62 +168,56 @@ func Init(key string, secret string, serverTsFn func() int64) {
 }
 
 func HasKey() bool {
-	return len(SignerIns.key) > 0 && len(SignerIns.secret) > 0
+	return SignerIns.HasKey()
 }
 
-func getParamHmacSHA256Sign(message string, secretKey string) (string, error) {
-	mac := hmac.New(sha256.New, []byte(secretKey))
-	_, err := mac.Write([]byte(message))
-	if err != nil {
-		return "", err
+// nil表示默认账户
+func (c *Credential) orDefault() *Credential {
+	if c == nil {
+		return SignerIns
 	}
-	str := fmt.Sprintf("%x", (mac.Sum(nil)))
-	return str, nil
+	return c
 }
 
-func (s *signer) Sign(param url.Values) (header map[string]string, paramStr string, err error) {
-	// 需要签名的参数，都要包含这两个东西
-	param.Set("timestamp", fmt.Sprintf("%d", s.serverTsFn()))
-	param.Set("recvWindow", "10000")
-	payload := param.Encode()
+func (c *Credential) HasKey() bool {
+	c = c.orDefault()
+	return c != nil && len(c.key) > 0 && c.signer != nil
+}
 
-	signature, err := getParamHmacSHA256Sign(payload, s.secret)
-	if err != nil {
-		logger.LogPanic(signerLogPrefix, "sign error!")
-		return
+func (c *Credential) ApiKey() string {
+	if c = c.orDefault(); c == nil {
+		return ""
 	}
-
-	param.Set("signature", signature)
-	paramStr = param.Encode()
-
-	header = make(map[string]string)
-	header["X-MBX-APIKEY"] = s.key
-	return
+	return c.key
 }
 
-func (s *signer) Sign2(param url.Values) (header map[string]string, paramStr string, err error) {
+func (c *Credential) Sign(param url.Values) (header map[string]string, paramStr string, err error) {
+	if c = c.orDefault(); !c.HasKey() {
+		err = errors.New("no valid key")
+		return
+	}
+
 	// 需要签名的参数，都要包含这两个东西
-	param.Set("timestamp", fmt.Sprintf("%d", s.serverTsFn()))
+	param.Set("timestamp", fmt.Sprintf("%d", c.serverTsFn()))
 	param.Set("recvWindow", "10000")
 	payload := param.Encode()
 
-	signature, err := getParamHmacSHA256Sign(payload, s.secret)
+	signature, err := c.signer.Sign(payload)
 	if err != nil {
-		logger.LogPanic(signerLogPrefix, "sign error!")
+		err = logger.LogStrict(signerLogPrefix, "sign error: %s", err.Error())
 		return
 	}
 
-	signature = "xxx"
 	param.Set("signature", signature)
 	paramStr = param.Encode()
 
 	header = make(map[string]string)
-	header["X-MBX-APIKEY"] = s.key
+	header["X-MBX-APIKEY"] = c.key
 	return
 }
 
-func (s *signer) HeaderWithApiKey() map[string]string {
+func (c *Credential) HeaderWithApiKey() map[string]string {
 	header := make(map[string]string)
-	header["X-MBX-APIKEY"] = s.key
+	header["X-MBX-APIKEY"] = c.ApiKey()
 	return header
 }
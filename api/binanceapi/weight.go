@@ -0,0 +1,213 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-28 14:18:50
+- @Description: 按权重限制rest请求频率。币安按host统计每分钟的请求权重，超出后返回429，继续请求会被封IP(418)
+- 1. 每个host维护当分钟已用权重：发出请求前按估算的权重预扣，收到响应后以X-MBX-USED-WEIGHT-1M为准校正，每分钟清零
+- 2. 下单/撤单等交易请求(非GET)可用到上限的TradeWeightRatio，行情等其他请求只能用到DataWeightRatio，剩余额度留给交易
+- 3. 超出额度时阻塞到下一分钟(或响应头更新出可用额度)。收到429/418时按Retry-After暂停该host的所有请求
+- 各接口的权重为近似值(如深度按limit分档)，以响应头校正
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package binanceapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
+)
+
+const weightLogPrefix = "binance_weight"
+
+type RequestPriority int
+
+const (
+	RequestPriority_Trade RequestPriority = iota
+	RequestPriority_Data
+)
+
+// 各host每分钟的权重上限
+var DefaultWeightLimits = map[string]int{
+	"api.binance.com":  6000,
+	"fapi.binance.com": 2400,
+	"dapi.binance.com": 2400,
+	"papi.binance.com": 6000,
+}
+
+var TradeWeightRatio = 0.98
+var DataWeightRatio = 0.8
+
+type weightBudget struct {
+	host         string
+	limit        int
+	used         int
+	window       int64 // 当前统计的分钟
+	blockedUntil time.Time
+}
+
+var weightBudgets = make(map[string]*weightBudget)
+var muWeight sync.Mutex
+
+func init() {
+	for host, limit := range DefaultWeightLimits {
+		SetWeightLimit(host, limit)
+	}
+}
+
+// 设置某个host的权重上限，limit<=0时不再限制该host
+func SetWeightLimit(host string, limit int) {
+	muWeight.Lock()
+	defer muWeight.Unlock()
+	if limit <= 0 {
+		delete(weightBudgets, host)
+		network.SetRequestGate(host, nil)
+		return
+	}
+
+	if b, ok := weightBudgets[host]; ok {
+		b.limit = limit
+	} else {
+		weightBudgets[host] = &weightBudget{host: host, limit: limit}
+		network.SetRequestGate(host, acquireWeight)
+	}
+}
+
+// 某host当分钟已用的权重和上限
+func UsedWeight(host string) (used, limit int) {
+	muWeight.Lock()
+	defer muWeight.Unlock()
+	if b, ok := weightBudgets[host]; ok {
+		b.roll(time.Now())
+		return b.used, b.limit
+	}
+	return 0, 0
+}
+
+func (b *weightBudget) roll(now time.Time) {
+	if w := now.Unix() / 60; w != b.window {
+		b.window = w
+		b.used = 0
+	}
+}
+
+// 请求的估算权重和优先级。只有下单、撤单、改单等修改类请求(非GET)享有交易优先级，查询订单按普通请求处理
+func requestWeight(method, path string, query url.Values) (int, RequestPriority) {
+	if method != http.MethodGet {
+		for _, suffix := range []string{"/order", "/batchOrders", "/orderList", "/order/oco", "/allOpenOrders", "/openOrders", "/userDataStream", "/listenKey"} {
+			if strings.HasSuffix(path, suffix) {
+				return 1, RequestPriority_Trade
+			}
+		}
+	}
+
+	if strings.HasSuffix(path, "/openOrders") {
+		return util.ValueIf(query.Has("symbol"), 6, 80), RequestPriority_Data
+	}
+
+	switch {
+	case strings.HasSuffix(path, "/depth"):
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		switch {
+		case limit <= 100:
+			return 5, RequestPriority_Data
+		case limit <= 500:
+			return 25, RequestPriority_Data
+		case limit <= 1000:
+			return 50, RequestPriority_Data
+		default:
+			return 250, RequestPriority_Data
+		}
+	case strings.HasSuffix(path, "/exchangeInfo"):
+		return 20, RequestPriority_Data
+	case strings.HasSuffix(path, "/ticker/24hr"):
+		return util.ValueIf(query.Has("symbol"), 2, 80), RequestPriority_Data
+	case strings.HasSuffix(path, "/klines"), strings.HasSuffix(path, "/continuousKlines"):
+		return 2, RequestPriority_Data
+	case strings.HasSuffix(path, "/allOrders"), strings.HasSuffix(path, "/myTrades"), strings.HasSuffix(path, "/userTrades"), strings.HasSuffix(path, "/account"):
+		return 20, RequestPriority_Data
+	default:
+		return 1, RequestPriority_Data
+	}
+}
+
+// 请求前调用，额度不足时阻塞
+func acquireWeight(method, rawUrl string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return
+	}
+
+	cost, prio := requestWeight(method, u.Path, u.Query())
+	ratio := TradeWeightRatio
+	if prio == RequestPriority_Data {
+		ratio = DataWeightRatio
+	}
+
+	logged := false
+	for {
+		muWeight.Lock()
+		b, ok := weightBudgets[u.Host]
+		if !ok {
+			muWeight.Unlock()
+			return
+		}
+
+		now := time.Now()
+		b.roll(now)
+		wait := time.Duration(0)
+		if now.Before(b.blockedUntil) {
+			wait = b.blockedUntil.Sub(now)
+		} else if float64(b.used+cost) > float64(b.limit)*ratio && b.used > 0 {
+			wait = time.Unix((b.window+1)*60, 0).Sub(now)
+		} else {
+			b.used += cost
+			muWeight.Unlock()
+			return
+		}
+		used, limit := b.used, b.limit
+		muWeight.Unlock()
+
+		if !logged {
+			logger.LogImportant(weightLogPrefix, "%s weight %d/%d, throttling %s %s(cost=%d) for %v", u.Host, used, limit, method, u.Path, cost, wait.Round(time.Millisecond))
+			logged = true
+		}
+		time.Sleep(min(wait, time.Second)) // 响应头可能更新出可用额度，每秒重新检查
+	}
+}
+
+// 以响应头校正已用权重
+func updateWeight(resp *http.Response) {
+	if resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return
+	}
+
+	muWeight.Lock()
+	defer muWeight.Unlock()
+	b, ok := weightBudgets[resp.Request.URL.Host]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	b.roll(now)
+	if v := resp.Header.Get("X-Mbx-Used-Weight-1m"); len(v) > 0 {
+		if w, err := strconv.Atoi(v); err == nil {
+			b.used = w
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 418 {
+		sec, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || sec <= 0 {
+			sec = 60 - int(now.Unix()%60)
+		}
+		b.blockedUntil = now.Add(time.Duration(sec) * time.Second)
+		logger.LogImportant(weightLogPrefix, "%s rate limited(%d), blocked for %ds", b.host, resp.StatusCode, sec)
+	}
+}
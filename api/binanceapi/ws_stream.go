@@ -11,9 +11,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aztecqt/dagger/api"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 )
 
 const SpotBaseUrl = "wss://stream.binance.com:9443/ws/"
@@ -46,10 +49,69 @@ func (ws *WsStream) Start(baseUrl, streamName string, fnOnRawMsg api.OnRecvWSRaw
 	return s
 }
 
+// 频道名会变化的stream(如用户数据流的listenKey)。每次连接前调用fnStreamName获取频道名
+func (ws *WsStream) StartDynamic(baseUrl, name string, fnStreamName func() string, fnOnRawMsg api.OnRecvWSRawMsg) *api.WsSubscriber {
+	logger.LogImportant(wsLogPrefix, "starting...")
+	streamName := ""
+	muName := sync.Mutex{}
+	ws.wsConn.SetUrlFn(func() string {
+		muName.Lock()
+		defer muName.Unlock()
+		streamName = fnStreamName()
+		return fmt.Sprintf("%s%s", baseUrl, streamName)
+	})
+	ws.wsConn.Start(baseUrl, wsLogPrefix, fnOnRawMsg)
+
+	id := wsSubscribeId
+	wsSubscribeId++
+
+	s := new(api.WsSubscriber)
+	s.Init(
+		name,
+		"",
+		true,
+		func() string {
+			muName.Lock()
+			defer muName.Unlock()
+			return fmt.Sprintf(`{"method":"SUBSCRIBE","params":["%s"],"id": %d}`, streamName, id)
+		},
+		[]string{fmt.Sprintf(`"id":%d`, id), `"result":null`})
+	ws.wsConn.Subscribe(s)
+	return s
+}
+
 func (ws *WsStream) Stop() {
 	ws.wsConn.Stop()
 }
 
+// 断开当前连接，随后自动重连并重新订阅
+func (ws *WsStream) Reconnect(reason string) {
+	ws.wsConn.Reconnect(reason)
+}
+
+func (ws *WsStream) State() network.ConnState {
+	return ws.wsConn.State()
+}
+
+// 最近一次收到数据(含ping/pong)的时间
+func (ws *WsStream) LastRecvTime() time.Time {
+	return ws.wsConn.LastRecvTime()
+}
+
+// 主动ping，收到pong时更新LastRecvTime
+func (ws *WsStream) Ping() {
+	ws.wsConn.SendPing(nil)
+}
+
+// 订阅连接状态变化。订阅时立即以当前状态回调一次
+func (ws *WsStream) SubscribeState(fn func(e network.ConnEvent)) int {
+	return ws.wsConn.SubscribeState(fn)
+}
+
+func (ws *WsStream) UnsubscribeState(id int) {
+	ws.wsConn.UnsubscribeState(id)
+}
+
 func SubscribeWithStream[T any](baseUrl, streamName, logPrefix string, fn api.OnRecvWSMsg) (*api.WsSubscriber, *WsStream) {
 	stream := new(WsStream)
 	s := stream.Start(baseUrl, streamName, func(rawMsg api.WSRawMsg) {
@@ -0,0 +1,104 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-17 14:05:27
+- @Description: nonce管理器。部分交易所(Kraken、FIX等)要求同一个key的nonce严格递增
+- 多个协程共用同一个管理器。nonce以微秒时间戳为基础，保证不小于上一个值+1
+- 预分配一段nonce写入文件，重启后从文件中的高水位继续，避免时钟回拨导致nonce变小
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package api
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+// 每次落盘预留的nonce数量
+const nonceReserveStep = 1000000
+
+type NonceManager struct {
+	mu        sync.Mutex
+	path      string
+	last      int64
+	highWater int64 // 已落盘的上限，last超过它时需要重新落盘
+}
+
+// path为空则不持久化
+func NewNonceManager(path string) *NonceManager {
+	n := new(NonceManager)
+	n.path = path
+	n.load()
+	return n
+}
+
+func (n *NonceManager) load() {
+	if len(n.path) == 0 {
+		return
+	}
+
+	b, err := os.ReadFile(n.path)
+	if err != nil {
+		return
+	}
+
+	if v, ok := util.String2Int64(strings.TrimSpace(string(b))); ok {
+		// 上次预留的上限都可能已被使用，从上限开始
+		n.last = v
+		n.highWater = v
+	} else {
+		logger.LogImportant("nonce", "invalid nonce file %s: %s", n.path, string(b))
+	}
+}
+
+func (n *NonceManager) save(v int64) bool {
+	if len(n.path) == 0 {
+		return true
+	}
+
+	if !util.StringToFile(n.path, strconv.FormatInt(v, 10)) {
+		logger.LogImportant("nonce", "save nonce to %s failed", n.path)
+		return false
+	}
+	return true
+}
+
+// 获取下一个nonce
+func (n *NonceManager) Next() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	v := time.Now().UnixMicro()
+	if v <= n.last {
+		v = n.last + 1
+	}
+
+	if v > n.highWater || len(n.path) == 0 {
+		hw := v + nonceReserveStep
+		if n.save(hw) {
+			n.highWater = hw
+		}
+	}
+
+	n.last = v
+	return v
+}
+
+// 字符串形式的nonce
+func (n *NonceManager) NextString() string {
+	return strconv.FormatInt(n.Next(), 10)
+}
+
+// 交易所返回nonce过小时调用，把后续nonce抬高到v之后
+func (n *NonceManager) Bump(v int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if v > n.last {
+		n.last = v
+	}
+}
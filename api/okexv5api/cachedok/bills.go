@@ -121,7 +121,7 @@ func billsCachePath(acc string, dt time.Time) string {
 func loadBillsOfDate(acc string, dt time.Time) ([]okexv5api.Bill, bool) {
 	path := billsCachePath(acc, dt)
 	var bills []okexv5api.Bill
-	if util.ObjectFromFile(path, &bills) {
+	if util.VersionedObjectFromFile(path, billsKind, billsVersion, &bills) {
 		return bills, true
 	} else {
 		return nil, false
@@ -144,6 +144,6 @@ func saveBills(acc string, bills []okexv5api.Bill) {
 
 	// 执行保存
 	for path, v := range dataByPath {
-		util.ObjectToFile(path, v)
+		util.VersionedObjectToFile(path, billsKind, billsVersion, v)
 	}
 }
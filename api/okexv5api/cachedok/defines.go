@@ -7,9 +7,32 @@
 */
 package cachedok
 
-import "time"
+import (
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+)
 
 var logPrefix = "okexv5api.cached"
 var DisableCached = false
 
+// 缓存文件的格式版本。格式变化时递增版本号，并注册迁移函数
+const (
+	klineMagic             = "OKKL"
+	klineVersion           = 1
+	fundingFeesKind        = "okx_fundingfees"
+	fundingFeesVersion     = 1
+	billsKind              = "okx_bills"
+	billsVersion           = 1
+	positionHistoryKind    = "okx_position_history"
+	positionHistoryVersion = 1
+)
+
+func init() {
+	// 版本0为没有版本信息的旧文件，格式与版本1相同
+	util.RegisterMigration(fundingFeesKind, 0, util.NoopMigration)
+	util.RegisterMigration(billsKind, 0, util.NoopMigration)
+	util.RegisterMigration(positionHistoryKind, 0, util.NoopMigration)
+}
+
 type fnprg func(prg time.Time)
@@ -81,7 +81,7 @@ func loadFundingFeesOfMonth(instId string, dt time.Time) ([]okexv5api.FundingRat
 
 	path := pathOfFundingFees(instId, dt)
 	result := make([]okexv5api.FundingRateHistory, 0)
-	return result, util.ObjectFromFile(path, &result)
+	return result, util.VersionedObjectFromFile(path, fundingFeesKind, fundingFeesVersion, &result)
 }
 
 func saveFundingFees(instId string, fees []okexv5api.FundingRateHistory) {
@@ -99,7 +99,7 @@ func saveFundingFees(instId string, fees []okexv5api.FundingRateHistory) {
 
 	// 执行保存
 	for path, v := range dataByPath {
-		util.ObjectToFile(path, v)
+		util.VersionedObjectToFile(path, fundingFeesKind, fundingFeesVersion, v)
 	}
 }
 
@@ -116,9 +116,11 @@ func loadKlineOfDate(instId, bar string, dt time.Time) ([]okexv5api.KLineUnit, b
 
 	path := klineCachePath(instId, bar, dt)
 	result := make([]okexv5api.KLineUnit, 0)
-	ok := util.FileDeserializeToObjects(
+	ok := util.FileDeserializeToObjectsVersioned(
 		path,
-		func() *okexv5api.KLineUnit { return &okexv5api.KLineUnit{} },
+		klineMagic,
+		klineVersion,
+		func(version uint16) *okexv5api.KLineUnit { return &okexv5api.KLineUnit{} },
 		func(ku *okexv5api.KLineUnit) bool { result = append(result, *ku); return true })
 	return result, ok
 }
@@ -144,7 +146,7 @@ func saveKlines(instId, bar string, kl []okexv5api.KLineUnit) {
 			ku.Serialize(buf)
 		}
 
-		util.BytesToFile(path, buf.Bytes())
+		util.VersionedBytesToFile(path, klineMagic, klineVersion, buf.Bytes())
 	}
 }
 
@@ -115,7 +115,7 @@ func positionHistoryCachePath(acc, instType string, dt time.Time) string {
 func loadPositionHistoryOfDate(acc, instType string, dt time.Time) ([]okexv5api.PositionHistory, bool) {
 	path := positionHistoryCachePath(acc, instType, dt)
 	var phs []okexv5api.PositionHistory
-	if util.ObjectFromFile(path, &phs) {
+	if util.VersionedObjectFromFile(path, positionHistoryKind, positionHistoryVersion, &phs) {
 		return phs, true
 	} else {
 		return nil, false
@@ -150,6 +150,6 @@ func savePositionHistorys(acc, instType string, phs []okexv5api.PositionHistory)
 	}
 
 	for path, v := range dataByPath {
-		util.ObjectToFile(path, v)
+		util.VersionedObjectToFile(path, positionHistoryKind, positionHistoryVersion, v)
 	}
 }
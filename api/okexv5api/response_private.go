@@ -228,6 +228,9 @@ type PositionUnit struct {
 	AvgPx    string `json:"avgPx"`
 	LiqPx    string `json:"liqPx"`
 	MarkPx   string `json:"markPx"`
+	MgnRatio string `json:"mgnRatio"` // 维持保证金率(逐仓为仓位级，全仓为账户级)
+	Mmr      string `json:"mmr"`      // 维持保证金
+	Lever    string `json:"lever"`
 }
 
 type PositionWsResp struct {
@@ -476,6 +479,15 @@ type CancelOrderRestResp struct {
 	} `json:"data"`
 }
 
+// 倒计时全部撤单
+type CancelAllAfterRestResp struct {
+	CommonRestResp
+	Data []struct {
+		TriggerTime string `json:"triggerTime"` // 触发撤单的时间，0表示已取消倒计时
+		Ts          string `json:"ts"`
+	} `json:"data"`
+}
+
 // 批量撤单请求单元
 type CancelBatchOrderRestReq struct {
 	InstId  string `json:"instId"`
@@ -494,12 +506,25 @@ type AmendOrderRestResp struct {
 	} `json:"data"`
 }
 
+// 策略委托下单/撤单返回
+type AlgoOrderRestResp struct {
+	CommonRestResp
+	Data []struct {
+		AlgoId      string `json:"algoId"`
+		AlgoClOrdId string `json:"algoClOrdId"`
+		SCode       string `json:"sCode"`
+		SMsg        string `json:"sMsg"`
+	} `json:"data"`
+}
+
 // 查询订单
 type OrderResp struct {
 	InstId        string `json:"instId"`
 	OrderId       string `json:"ordId"`
 	ClientOrderId string `json:"clOrdId"`
 	Tag           string `json:"tag"`
+	Side          string `json:"side"`
+	ReduceOnly    string `json:"reduceOnly"`
 	Price         string `json:"px"`
 	Size          string `json:"sz"`
 	AccFillSize   string `json:"accFillSz"`
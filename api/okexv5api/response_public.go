@@ -148,6 +148,8 @@ type Instrument struct {
 	MinSz     string `json:"minSz"`     // 最小下单数量
 	Alias     string `json:"alias"`     // 别名(this_week/next_week/quarter/next_quarter)
 	State     string `json:"state"`     // 状态：live：交易中	suspend：暂停中	expired：已过期	preopen：预上线	settlement：资金费结算
+	ListTime  string `json:"listTime"`  // 上线时间，毫秒时间戳
+	OpenType  string `json:"openType"`  // 开盘方式：fix_price：定价开盘	pre_quote：预挂单	call_auction：集合竞价
 }
 
 // 交易对信息
@@ -163,7 +165,11 @@ type TickerResp struct {
 	Last         decimal.Decimal `json:"last"`
 	Sell1        decimal.Decimal `json:"askPx"`
 	Buy1         decimal.Decimal `json:"bidPx"`
-	VolCcy24h    decimal.Decimal `json:"volCcy24h"`
+	Open24h      decimal.Decimal `json:"open24h"`
+	High24h      decimal.Decimal `json:"high24h"`
+	Low24h       decimal.Decimal `json:"low24h"`
+	Vol24h       decimal.Decimal `json:"vol24h"`    // 现货为交易币，合约为张数
+	VolCcy24h    decimal.Decimal `json:"volCcy24h"` // 现货为计价币，合约为币
 	TimeStampStr string          `json:"ts"`
 	Time         time.Time
 	VolUsd24h    decimal.Decimal
@@ -204,6 +210,7 @@ func (t *TickerRestResp) parse() {
 type IndexTicker struct {
 	InstId     string          `json:"instId"`
 	IndexPrice decimal.Decimal `json:"idxPx"`
+	TS         string          `json:"ts"`
 }
 
 type IndexTickerRestResp struct {
@@ -211,6 +218,11 @@ type IndexTickerRestResp struct {
 	Data []IndexTicker `json:"data"`
 }
 
+type IndexTickerWsResp struct {
+	CommonWsResp
+	Data []IndexTicker `json:"data"`
+}
+
 // k线
 type KLineUnit struct {
 	Time      time.Time
@@ -353,6 +365,8 @@ type DepthResp struct {
 		Asks      [][4]string `json:"asks"`
 		Bids      [][4]string `json:"bids"`
 		Checksum  int32       `json:"checksum"`
+		SeqId     int64       `json:"seqId"`
+		PrevSeqId int64       `json:"prevSeqId"` // 快照为-1。增量推送的prevSeqId应等于上一条的seqId
 		TimeStamp string      `json:"ts"`
 	} `json:"data"`
 }
@@ -374,6 +388,8 @@ type FundingRate struct {
 	NextFundingRate    decimal.Decimal `json:"nextFundingRate"`
 	FundingTimeStr     string          `json:"fundingTime"`
 	NextFundingTimeStr string          `json:"nextFundingTime"`
+	MaxFundingRate     decimal.Decimal `json:"maxFundingRate"`
+	MinFundingRate     decimal.Decimal `json:"minFundingRate"`
 
 	FundingTime     time.Time
 	NextFundingTime time.Time
@@ -381,7 +397,9 @@ type FundingRate struct {
 
 func (f *FundingRate) parse() {
 	f.FundingTime = time.UnixMilli(util.String2Int64Panic(f.FundingTimeStr))
-	f.NextFundingTime = time.UnixMilli(util.String2Int64Panic(f.NextFundingTimeStr))
+	if len(f.NextFundingTimeStr) > 0 {
+		f.NextFundingTime = time.UnixMilli(util.String2Int64Panic(f.NextFundingTimeStr))
+	}
 }
 
 type FundingRateRestResp struct {
@@ -463,7 +481,15 @@ func (r *GetMarketTradesResp) Parse() {
 // 市场持仓
 type MarketHolding struct {
 	InstId       string          `json:"instId"`
+	Holding      decimal.Decimal `json:"oi"` // 张
 	HoldingInCcy decimal.Decimal `json:"oiCcy"`
+	HoldingInUsd decimal.Decimal `json:"oiUsd"`
+	TimeStamp    string          `json:"ts"`
+}
+
+type OpenInterestWsResp struct {
+	CommonWsResp
+	Data []MarketHolding `json:"data"`
 }
 
 type GetMarketHoldingResp struct {
@@ -635,3 +661,29 @@ func (r *DiscountInfoResp) parse() {
 		r.Data[i].parse()
 	}
 }
+
+// 仓位阶梯档位
+type PositionTier struct {
+	InstFamily string          `json:"instFamily"`
+	InstId     string          `json:"instId"`
+	TierStr    string          `json:"tier"`
+	MinSz      decimal.Decimal `json:"minSz"`
+	MaxSz      decimal.Decimal `json:"maxSz"`
+	Mmr        decimal.Decimal `json:"mmr"`      // 维持保证金率
+	Imr        decimal.Decimal `json:"imr"`      // 初始保证金率
+	MaxLevStr  string          `json:"maxLever"` // 最高可用杠杆倍数
+	Tier       int             `json:"-"`
+	MaxLever   int             `json:"-"`
+}
+
+type PositionTiersResp struct {
+	CommonRestResp
+	Data []PositionTier `json:"data"`
+}
+
+func (r *PositionTiersResp) parse() {
+	for i := range r.Data {
+		r.Data[i].Tier, _ = util.String2Int(r.Data[i].TierStr)
+		r.Data[i].MaxLever, _ = util.String2Int(r.Data[i].MaxLevStr)
+	}
+}
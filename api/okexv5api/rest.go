@@ -29,6 +29,18 @@ const restLogPrefix = "okexv5_rest"
 // 外部通过设置这个回调来处理关键错误
 var ErrorCallback func(e error)
 
+// 模拟盘(demo trading)，需要使用模拟盘的key。须在交易所初始化之前设置
+var simulatedTrading bool
+
+func UseSimulatedTrading(sim bool) {
+	simulatedTrading = sim
+}
+
+// rest和websocket使用的所有地址，用于设置代理等
+func Urls() []string {
+	return []string{rootUrl, publicURL, privateURL, publicURLSimulated, privateURLSimulated}
+}
+
 // 通用错误处理
 func CheckRestResp(resp CommonRestResp, err error, op, logPrefix string) bool {
 	if err != nil {
@@ -48,7 +60,7 @@ func GetServerTS() int64 {
 	method := "GET"
 	url := rootUrl + action
 	resp, err := network.ParseHttpResult[serverTimeRestResp](restLogPrefix, "GetInstruments", url, method, "", nil, nil, ErrorCallback)
-	if err == nil {
+	if err == nil && len(resp.Data) > 0 {
 		ts, _ := strconv.ParseInt(resp.Data[0].TS, 10, 64)
 		return ts
 	} else {
@@ -89,7 +101,7 @@ func GetInstruments(instType string) (*InstrumentRestResp, error) {
 	params.Set("instType", instType)
 	action = action + "?" + params.Encode()
 	url := rootUrl + action
-	resp, err := network.ParseHttpResult[InstrumentRestResp](restLogPrefix, "GetInstruments", url, method, "", nil, nil, ErrorCallback)
+	resp, err := network.ParseHttpResultStream[InstrumentRestResp](restLogPrefix, "GetInstruments", url, method, "", nil, nil, ErrorCallback)
 	return resp, err
 }
 
@@ -150,7 +162,7 @@ func GetIndexTickers(quoteCcy, instId string) (*IndexTickerRestResp, error) {
 	}
 
 	if len(instId) > 0 {
-		params.Set("instId", quoteCcy)
+		params.Set("instId", instId)
 	}
 
 	action = action + "?" + params.Encode()
@@ -168,7 +180,7 @@ func GetDepth(instId string, sz int) (*DepthRestResp, error) {
 	params.Set("sz", fmt.Sprintf("%d", sz))
 	action = action + "?" + params.Encode()
 	url := rootUrl + action
-	resp, err := network.ParseHttpResult[DepthRestResp](restLogPrefix, "GetDepth", url, method, "", nil, nil, ErrorCallback)
+	resp, err := network.ParseHttpResultStream[DepthRestResp](restLogPrefix, "GetDepth", url, method, "", nil, nil, ErrorCallback)
 	return resp, err
 }
 
@@ -591,7 +603,8 @@ func GetWithdrawHistory(clientId string) (*WithdrawHistoryResp, error) {
 }
 
 // 下单
-func MakeOrder(instID, clientOrderId, tag, side, posSide, orderType, tradeMode string, reduceOnly bool, price, size decimal.Decimal) (*MakeorderRestResp, error) {
+// price/size为已按品种精度格式化的字符串
+func MakeOrder(instID, clientOrderId, tag, side, posSide, orderType, tradeMode string, reduceOnly bool, price, size string) (*MakeorderRestResp, error) {
 	action := "/api/v5/trade/order"
 	method := "POST"
 	url := rootUrl + action
@@ -605,8 +618,8 @@ func MakeOrder(instID, clientOrderId, tag, side, posSide, orderType, tradeMode s
 		PosSide:       posSide,
 		OrderType:     orderType,
 		ReduceOnly:    reduceOnly,
-		Price:         price.String(),
-		Size:          size.String(),
+		Price:         price,
+		Size:          size,
 	}
 
 	b, _ := json.Marshal(req)
@@ -615,6 +628,37 @@ func MakeOrder(instID, clientOrderId, tag, side, posSide, orderType, tradeMode s
 	return resp, err
 }
 
+// 市价单
+// tgtCcy：现货按哪种币计量sz，base_ccy/quote_ccy，合约传空
+func MakeMarketOrder(instID, clientOrderId, tag, side, posSide, tradeMode, tgtCcy string, reduceOnly bool, size string) (*MakeorderRestResp, error) {
+	action := "/api/v5/trade/order"
+	method := "POST"
+	url := rootUrl + action
+
+	req := make(map[string]interface{})
+	req["instId"] = instID
+	req["tdMode"] = tradeMode
+	req["clOrdId"] = clientOrderId
+	req["tag"] = tag
+	req["side"] = side
+	req["ordType"] = "market"
+	req["sz"] = size
+	if len(posSide) > 0 {
+		req["posSide"] = posSide
+	}
+	if len(tgtCcy) > 0 {
+		req["tgtCcy"] = tgtCcy
+	}
+	if reduceOnly {
+		req["reduceOnly"] = true
+	}
+
+	b, _ := json.Marshal(req)
+	postStr := string(b)
+	resp, err := network.ParseHttpResult[MakeorderRestResp](restLogPrefix, "MakeMarketOrder", url, method, postStr, signerIns.getHttpHeaderWithSign(method, action, postStr), nil, ErrorCallback)
+	return resp, err
+}
+
 // 撤单
 func CancelOrder(instID, clientOrderId string, orderId int64) (*CancelOrderRestResp, error) {
 	action := "/api/v5/trade/cancel-order"
@@ -651,8 +695,78 @@ func CancelOrderBatch(orders []CancelBatchOrderRestReq) (*CancelOrderRestResp, e
 	return resp, err
 }
 
+// 倒计时全部撤单(dead-man switch)
+// timeOut秒后撤销所有挂单，取值0或10~120，0表示取消倒计时。需要在超时前重复调用以续期
+func CancelAllAfter(timeOut int) (*CancelAllAfterRestResp, error) {
+	action := "/api/v5/trade/cancel-all-after"
+	method := "POST"
+	url := rootUrl + action
+
+	req := make(map[string]string)
+	req["timeOut"] = strconv.Itoa(timeOut)
+
+	b, _ := json.Marshal(req)
+	postStr := string(b)
+	resp, err := network.ParseHttpResult[CancelAllAfterRestResp](restLogPrefix, "CancelAllAfter", url, method, postStr, signerIns.getHttpHeaderWithSign(method, action, postStr), nil, ErrorCallback)
+	return resp, err
+}
+
+// 止盈止损策略委托(oco)，触发后以市价平仓
+// tpTriggerPx/slTriggerPx为已格式化的触发价，为空表示不设置
+func MakeAlgoOrderOco(instID, algoClOrdId, tag, side, posSide, tradeMode string, reduceOnly bool, size, tpTriggerPx, slTriggerPx string) (*AlgoOrderRestResp, error) {
+	action := "/api/v5/trade/order-algo"
+	method := "POST"
+	url := rootUrl + action
+
+	req := make(map[string]interface{})
+	req["instId"] = instID
+	req["tdMode"] = tradeMode
+	req["side"] = side
+	req["ordType"] = util.ValueIf(len(tpTriggerPx) > 0 && len(slTriggerPx) > 0, "oco", "conditional")
+	req["sz"] = size
+	if len(algoClOrdId) > 0 {
+		req["algoClOrdId"] = algoClOrdId
+	}
+	if len(tag) > 0 {
+		req["tag"] = tag
+	}
+	if len(posSide) > 0 {
+		req["posSide"] = posSide
+	}
+	if reduceOnly {
+		req["reduceOnly"] = true
+	}
+	if len(tpTriggerPx) > 0 {
+		req["tpTriggerPx"] = tpTriggerPx
+		req["tpOrdPx"] = "-1"
+	}
+	if len(slTriggerPx) > 0 {
+		req["slTriggerPx"] = slTriggerPx
+		req["slOrdPx"] = "-1"
+	}
+
+	b, _ := json.Marshal(req)
+	postStr := string(b)
+	resp, err := network.ParseHttpResult[AlgoOrderRestResp](restLogPrefix, "MakeAlgoOrderOco", url, method, postStr, signerIns.getHttpHeaderWithSign(method, action, postStr), nil, ErrorCallback)
+	return resp, err
+}
+
+// 撤销策略委托
+func CancelAlgoOrder(instID, algoId string) (*AlgoOrderRestResp, error) {
+	action := "/api/v5/trade/cancel-algos"
+	method := "POST"
+	url := rootUrl + action
+
+	req := []map[string]string{{"instId": instID, "algoId": algoId}}
+	b, _ := json.Marshal(req)
+	postStr := string(b)
+	resp, err := network.ParseHttpResult[AlgoOrderRestResp](restLogPrefix, "CancelAlgoOrder", url, method, postStr, signerIns.getHttpHeaderWithSign(method, action, postStr), nil, ErrorCallback)
+	return resp, err
+}
+
 // 修改订单
-func AmendOrder(instID, clientOrderId, reqId string, orderId int64, newPrice, newSize decimal.Decimal) (*AmendOrderRestResp, error) {
+// newPrice/newSize为空表示不修改
+func AmendOrder(instID, clientOrderId, reqId string, orderId int64, newPrice, newSize string) (*AmendOrderRestResp, error) {
 	action := "/api/v5/trade/amend-order"
 	method := "POST"
 	url := rootUrl + action
@@ -661,12 +775,12 @@ func AmendOrder(instID, clientOrderId, reqId string, orderId int64, newPrice, ne
 	req["instId"] = instID
 	req["cxlOnFail"] = true
 
-	if newPrice.IsPositive() {
-		req["newPx"] = newPrice.String()
+	if len(newPrice) > 0 {
+		req["newPx"] = newPrice
 	}
 
-	if newSize.IsPositive() {
-		req["newSz"] = newSize.String()
+	if len(newSize) > 0 {
+		req["newSz"] = newSize
 	}
 
 	if orderId > 0 {
@@ -758,7 +872,7 @@ func GetFillsHistory(instId string, t0, t1 time.Time) (*FillsResp, error) {
 	} else if strings.Count(instId, "-") == 1 {
 		params.Set("instType", "SPOT")
 	} else {
-		logger.LogPanic(restLogPrefix, "GetFillsHistory:unknown instType")
+		return nil, logger.LogStrict(restLogPrefix, "GetFillsHistory:unknown instType of %s", instId)
 	}
 
 	if !t0.IsZero() {
@@ -1051,3 +1165,21 @@ func GetDiscountInfo(ccy string) (*DiscountInfoResp, error) {
 	}
 	return resp, err
 }
+
+// 获取仓位阶梯档位。instType为SWAP/FUTURES，tdMode为cross/isolated，instFamily如BTC-USDT
+func GetPositionTiers(instType, tdMode, instFamily string) (*PositionTiersResp, error) {
+	action := "/api/v5/public/position-tiers"
+	method := "GET"
+	params := url.Values{}
+	params.Set("instType", instType)
+	params.Set("tdMode", tdMode)
+	params.Set("instFamily", instFamily)
+
+	action = action + "?" + params.Encode()
+	url := rootUrl + action
+	resp, err := network.ParseHttpResult[PositionTiersResp](restLogPrefix, "GetPositionTiers", url, method, "", nil, nil, ErrorCallback)
+	if err == nil {
+		resp.parse()
+	}
+	return resp, err
+}
@@ -82,12 +82,15 @@ func getParamHmacSHA256Sign(message string, secretKey string) (string, error) {
 }
 
 func (s *signer) shar256(timestamp string, method string, action string, body string) string {
+	// 签名无效时请求会被交易所拒绝，调用方按普通的请求错误处理
 	if !inited {
-		logger.LogPanic(signerLogPrefix, "not inited")
+		logger.LogStrict(signerLogPrefix, "not inited")
+		return ""
 	}
 
 	if len(s.key) == 0 || len(s.secret) == 0 {
-		logger.LogPanic(signerLogPrefix, "no valid key")
+		logger.LogStrict(signerLogPrefix, "no valid key")
+		return ""
 	}
 
 	bb := bytes.Buffer{}
@@ -100,7 +103,7 @@ func (s *signer) shar256(timestamp string, method string, action string, body st
 	if err == nil {
 		return sign
 	} else {
-		logger.LogPanic(signerLogPrefix, "sign error!")
+		logger.LogStrict(signerLogPrefix, "sign error!")
 		return ""
 	}
 }
@@ -132,6 +135,9 @@ func (s *signer) getHttpHeaderWithSign(method string, action string, body string
 	headers["OK-ACCESS-TIMESTAMP"] = timestamp
 	headers["OK-ACCESS-PASSPHRASE"] = s.pass
 	headers["Content-Type"] = "application/json"
+	if simulatedTrading {
+		headers["x-simulated-trading"] = "1"
+	}
 
 	return headers
 }
@@ -23,10 +23,13 @@ import (
 	"github.com/aztecqt/dagger/api"
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 )
 
 const publicURL = "wss://ws.okx.com:8443/ws/v5/public"
 const privateURL = "wss://ws.okx.com:8443/ws/v5/private"
+const publicURLSimulated = "wss://wspap.okx.com:8443/ws/v5/public"
+const privateURLSimulated = "wss://wspap.okx.com:8443/ws/v5/private"
 const wsLogPrefix = "okexv5_ws"
 const wsLogPrefixPublic = "okexv5_public_ws"
 const wsLogPrefixPrivate = "okexv5_private_ws"
@@ -46,6 +49,8 @@ type WsClient struct {
 	depthRespFns             map[string]api.OnRecvWSMsg
 	fundingRateRespFns       map[string][]api.OnRecvWSMsg
 	liquidationOrdersRespFns map[string]api.OnRecvWSMsg
+	openInterestRespFns      map[string]api.OnRecvWSMsg
+	indexTickerRespFns       map[string][]api.OnRecvWSMsg
 	muFns                    sync.Mutex
 
 	// 外部回调
@@ -54,13 +59,19 @@ type WsClient struct {
 	ordersRespFn         api.OnRecvWSMsg
 }
 
+// 请求permessage-deflate压缩，降低完整深度等频道的带宽。需在Start之前设置
+func (ws *WsClient) SetCompression(enable bool) {
+	ws.publicWsConn.SetCompression(enable)
+	ws.privateWsConn.SetCompression(enable)
+}
+
 func (ws *WsClient) Start() {
 	logger.LogImportant(wsLogPrefix, "starting...")
-	ws.publicWsConn.Start(publicURL, wsLogPrefixPublic, ws.onRecvMsg)
+	ws.publicWsConn.Start(util.ValueIf(simulatedTrading, publicURLSimulated, publicURL), wsLogPrefixPublic, ws.onRecvMsg)
 	p1 := api.Pinger{}
 	p1.Start(&ws.publicWsConn, wsLogPrefix, "ping", 25, 50)
 
-	ws.privateWsConn.Start(privateURL, wsLogPrefixPrivate, ws.onRecvMsg)
+	ws.privateWsConn.Start(util.ValueIf(simulatedTrading, privateURLSimulated, privateURL), wsLogPrefixPrivate, ws.onRecvMsg)
 	p2 := api.Pinger{}
 	p2.Start(&ws.privateWsConn, wsLogPrefix, "ping", 25, 50)
 
@@ -75,6 +86,8 @@ func (ws *WsClient) Start() {
 	ws.rawRespFns["books50-l2-tbt"] = ws.rawRespDepth
 	ws.rawRespFns["funding-rate"] = ws.rawRespFundingRate
 	ws.rawRespFns["liquidation-orders"] = ws.rawRespLiquidationOrders
+	ws.rawRespFns["open-interest"] = ws.rawRespOpenInterest
+	ws.rawRespFns["index-tickers"] = ws.rawRespIndexTicker
 	ws.rawRespFns["account"] = ws.rawRespAccountBalance
 	ws.rawRespFns["positions"] = ws.rawRespPosition
 	ws.rawRespFns["orders"] = ws.rawRespOrders
@@ -87,6 +100,8 @@ func (ws *WsClient) Start() {
 	ws.depthRespFns = make(map[string]api.OnRecvWSMsg)
 	ws.fundingRateRespFns = make(map[string][]api.OnRecvWSMsg)
 	ws.liquidationOrdersRespFns = make(map[string]api.OnRecvWSMsg)
+	ws.openInterestRespFns = make(map[string]api.OnRecvWSMsg)
+	ws.indexTickerRespFns = make(map[string][]api.OnRecvWSMsg)
 }
 
 // #region public channels
@@ -171,7 +186,9 @@ func (ws *WsClient) subscribePublicChannelWithInstIDMulti(channel string, instID
 		[]string{"subscribe", channel, instID})
 	ws.publicWsConn.Subscribe(&s)
 
+	ws.muFns.Lock()
 	(*fnMap)[instID] = append((*fnMap)[instID], fn)
+	ws.muFns.Unlock()
 	return &s
 }
 
@@ -205,6 +222,29 @@ func (ws *WsClient) UnsubscribePriceLimit(instID string) {
 	ws.unsubscribePublicChannelWithInstID("price-limit", instID)
 }
 
+// 持仓总量(有变化时每3秒推送一次)
+func (ws *WsClient) SubscribeOpenInterest(instID string, fn api.OnRecvWSMsg) *api.WsSubscriber {
+	s := ws.subscribePublicChannelWithInstID("open-interest", instID, fn, &ws.openInterestRespFns)
+	return s
+}
+
+func (ws *WsClient) UnsubscribeOpenInterest(instID string) {
+	ws.unsubscribePublicChannelWithInstID("open-interest", instID)
+}
+
+// 指数行情。instID为指数名，如BTC-USDT。同一指数可能被多个合约共用，所以支持多个回调
+func (ws *WsClient) SubscribeIndexTicker(instID string, fn api.OnRecvWSMsg) *api.WsSubscriber {
+	s := ws.subscribePublicChannelWithInstIDMulti("index-tickers", instID, fn, &ws.indexTickerRespFns)
+	return s
+}
+
+func (ws *WsClient) UnsubscribeIndexTicker(instID string) {
+	ws.unsubscribePublicChannelWithInstID("index-tickers", instID)
+	ws.muFns.Lock()
+	delete(ws.indexTickerRespFns, instID)
+	ws.muFns.Unlock()
+}
+
 // 成交数据
 func (ws *WsClient) SubscribeTrades(instID string, fn api.OnRecvWSMsg) *api.WsSubscriber {
 	s := ws.subscribePublicChannelWithInstID("trades", instID, fn, &ws.tradesRespFns)
@@ -312,7 +352,7 @@ func (ws *WsClient) UnsubscribeAccountBalance() {
 	s.Init(
 		"account",
 		`{"op": "unsubscribe","args": [{"channel":"account"}]}`,
-		true,
+		false,
 		nil,
 		[]string{"unsubscribe", "account"})
 	ws.privateWsConn.Subscribe(&s)
@@ -337,7 +377,7 @@ func (ws *WsClient) UnsubscribePosition() {
 	s.Init(
 		"positions",
 		`{"op": "unsubscribe","args": [{"channel":"positions","instType":"ANY"}]}`,
-		true,
+		false,
 		nil,
 		[]string{"unsubscribe", "positions", "ANY"})
 	ws.privateWsConn.Subscribe(&s)
@@ -362,7 +402,7 @@ func (ws *WsClient) UnsubscribeOrders() {
 	s.Init(
 		"orders",
 		`{"op": "unsubscribe","args": [{"channel":"orders","instType":"ANY"}]}`,
-		true,
+		false,
 		nil,
 		[]string{"unsubscribe", "orders", "ANY"})
 	ws.privateWsConn.Subscribe(&s)
@@ -407,6 +447,33 @@ func (ws *WsClient) rawRespPriceLimit(msg api.WSRawMsg) {
 	}
 }
 
+func (ws *WsClient) rawRespOpenInterest(msg api.WSRawMsg) {
+	r := OpenInterestWsResp{}
+	err := json.Unmarshal(msg.Data, &r)
+	if err == nil {
+		if fn := ws.findFromFnMap(ws.openInterestRespFns, r.Arg.InstId); fn != nil {
+			fn(r)
+		}
+	} else {
+		ws.logUnmarshalError(wsLogPrefixPublic, r, err, msg.Str)
+	}
+}
+
+func (ws *WsClient) rawRespIndexTicker(msg api.WSRawMsg) {
+	r := IndexTickerWsResp{}
+	err := json.Unmarshal(msg.Data, &r)
+	if err == nil {
+		ws.muFns.Lock()
+		fns := ws.indexTickerRespFns[r.Arg.InstId]
+		ws.muFns.Unlock()
+		for _, fn := range fns {
+			fn(r)
+		}
+	} else {
+		ws.logUnmarshalError(wsLogPrefixPublic, r, err, msg.Str)
+	}
+}
+
 func (ws *WsClient) rawRespMarkPrice(msg api.WSRawMsg) {
 	r := MarkPriceWsResp{}
 	err := json.Unmarshal(msg.Data, &r)
@@ -519,3 +586,39 @@ func (ws *WsClient) logUnmarshalError(prefix string, respStruct interface{}, err
 }
 
 // #endregion
+
+// 私有频道是否连接
+func (ws *WsClient) PrivateConnected() bool {
+	return ws.privateWsConn.Connected()
+}
+
+// 公共频道是否连接
+func (ws *WsClient) PublicConnected() bool {
+	return ws.publicWsConn.Connected()
+}
+
+func (ws *WsClient) PublicState() network.ConnState {
+	return ws.publicWsConn.State()
+}
+
+func (ws *WsClient) PrivateState() network.ConnState {
+	return ws.privateWsConn.State()
+}
+
+// 订阅公共/私有连接的状态变化。重连后会自动重新登录并恢复所有订阅，全部完成时状态变为Ready
+// 返回的Id用于取消订阅
+func (ws *WsClient) SubscribePublicState(fn func(e network.ConnEvent)) int {
+	return ws.publicWsConn.SubscribeState(fn)
+}
+
+func (ws *WsClient) UnsubscribePublicState(id int) {
+	ws.publicWsConn.UnsubscribeState(id)
+}
+
+func (ws *WsClient) SubscribePrivateState(fn func(e network.ConnEvent)) int {
+	return ws.privateWsConn.SubscribeState(fn)
+}
+
+func (ws *WsClient) UnsubscribePrivateState(id int) {
+	ws.privateWsConn.UnsubscribeState(id)
+}
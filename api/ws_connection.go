@@ -12,12 +12,13 @@
 package api
 
 import (
-	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 	"github.com/gorilla/websocket"
 )
 
@@ -32,15 +33,23 @@ type OnRecvWSMsg func(interface{})
 
 var LogWebsocketDetail bool = false
 
+// 为所有连接请求permessage-deflate压缩(需服务器支持，不支持时自动不压缩)
+var WsCompression bool = false
+
 // 主结构
 type WsConnection struct {
 	url         string
+	urlFn       func() string // 不为空时每次连接前重新获取url(如币安的listenKey)
 	logPrefix   string
 	needStop    bool
 	reConnCount int
+	backoff     network.Backoff
+	state       network.ConnStateHub
+	compression bool
+	lastRecv    atomic.Int64 // 最近一次收到数据(含ping/pong)的时间，unix毫秒
 
-	// ws连接
-	Conn   *websocket.Conn
+	// ws连接。读取连接、替换连接和写入都需持有muConn，ReadMessage在锁外进行
+	conn   *websocket.Conn
 	muConn sync.Mutex
 
 	// 订阅器
@@ -66,6 +75,8 @@ func (ws *WsConnection) Start(url string, logPrefix string, onRecv OnRecvWSRawMs
 	ws.onRecvChans = make(map[chan WSRawMsg]bool)
 	ws.onConnChans = make(map[chan int]bool)
 	ws.onRecv = onRecv
+	ws.backoff = network.Backoff{Base: time.Second, Max: time.Second * 30}
+	ws.compression = ws.compression || WsCompression
 
 	// 启动主循环
 	logger.LogImportant(logPrefix, "websocket starting...")
@@ -76,22 +87,71 @@ func (ws *WsConnection) Start(url string, logPrefix string, onRecv OnRecvWSRawMs
 func (ws *WsConnection) Stop() {
 	logger.LogImportant(ws.logPrefix, "stopping...")
 	ws.needStop = true
-	if ws.Conn != nil {
-		ws.Conn.Close()
-		ws.Conn = nil
+	ws.closeConn()
+	ws.setState(network.ConnState_Disconnected, "stopped")
+}
+
+func (ws *WsConnection) getConn() *websocket.Conn {
+	ws.muConn.Lock()
+	defer ws.muConn.Unlock()
+	return ws.conn
+}
+
+// 关闭当前连接，正在进行的ReadMessage会返回错误
+func (ws *WsConnection) closeConn() {
+	ws.muConn.Lock()
+	defer ws.muConn.Unlock()
+	if ws.conn != nil {
+		ws.conn.Close()
+		ws.conn = nil
+	}
+}
+
+// 为这个连接请求permessage-deflate压缩，需在Start之前设置
+func (ws *WsConnection) SetCompression(enable bool) {
+	ws.compression = enable
+}
+
+// 每次连接前调用fn获取url，需在Start之前设置
+func (ws *WsConnection) SetUrlFn(fn func() string) {
+	ws.urlFn = fn
+}
+
+func (ws *WsConnection) State() network.ConnState {
+	return ws.state.State()
+}
+
+// 订阅连接状态变化。订阅时立即以当前状态回调一次，返回的Id用于取消订阅
+func (ws *WsConnection) SubscribeState(fn func(e network.ConnEvent)) int {
+	return ws.state.Subscribe(fn)
+}
+
+func (ws *WsConnection) UnsubscribeState(id int) {
+	ws.state.Unsubscribe(id)
+}
+
+func (ws *WsConnection) setState(state network.ConnState, err string) {
+	ws.state.Publish(network.ConnEvent{Name: ws.logPrefix, State: state, Time: time.Now(), Reconnects: ws.reConnCount, Err: err})
+	if len(err) > 0 {
+		logger.LogImportant(ws.logPrefix, "state: %s, reason: %s", state.String(), err)
+	}
+}
+
+// 最近一次收到数据(含ping/pong)的时间，还没有收到过时为零值。用于判断连接是否真的中断，而不只是某个频道没有推送
+func (ws *WsConnection) LastRecvTime() time.Time {
+	if ms := ws.lastRecv.Load(); ms > 0 {
+		return time.UnixMilli(ms)
 	}
+	return time.Time{}
 }
 
 func (ws *WsConnection) Connected() bool {
-	return ws.Conn != nil
+	return ws.getConn() != nil
 }
 
 func (ws *WsConnection) Reconnect(reason string) {
 	logger.LogImportant(ws.logPrefix, "need reconnect, reason=[%s], close current connection", reason)
-	if ws.Conn != nil {
-		ws.Conn.Close() // 关闭当前连接就会导致重连
-		ws.Conn = nil
-	}
+	ws.closeConn() // 关闭当前连接就会导致重连
 }
 
 func (ws *WsConnection) Ready() bool {
@@ -99,6 +159,12 @@ func (ws *WsConnection) Ready() bool {
 		return false
 	}
 
+	if ws.subLogin != nil && !ws.subLogin.Successed() {
+		return false
+	}
+
+	ws.muSubs.Lock()
+	defer ws.muSubs.Unlock()
 	for _, s := range ws.subOthers {
 		if !s.Successed() {
 			return false
@@ -112,6 +178,7 @@ func (ws *WsConnection) Ready() bool {
 func (ws *WsConnection) Login(s *WsSubscriber) {
 	go s.run(ws)
 	ws.subLogin = s
+	ws.unready()
 }
 
 func (ws *WsConnection) Subscribe(s *WsSubscriber) {
@@ -119,55 +186,78 @@ func (ws *WsConnection) Subscribe(s *WsSubscriber) {
 	ws.muSubs.Lock()
 	ws.subOthers = append(ws.subOthers, s)
 	ws.muSubs.Unlock()
+	ws.unready()
 }
 
-// 连接到服务器。连接不成功则一直连接
-func (ws *WsConnection) connect() {
-	if ws.Conn != nil {
-		ws.Conn.Close()
-		ws.Conn = nil
+// 有新的订阅时回到Connected，等这个订阅也成功后再变为Ready
+func (ws *WsConnection) unready() {
+	if ws.State() == network.ConnState_Ready {
+		ws.setState(network.ConnState_Connected, "")
 	}
+}
+
+// 连接到服务器。连接不成功则按退避间隔一直连接
+func (ws *WsConnection) connect() {
+	ws.closeConn()
 
-	logger.LogImportant(ws.logPrefix, "connecting...(%d) url=%s", ws.reConnCount, ws.url)
 	ws.reConnCount++
+	ws.setState(network.ConnState_Connecting, "")
+
+	dialer := websocket.Dialer{Proxy: network.ProxyFunc, HandshakeTimeout: 5 * time.Second, EnableCompression: ws.compression}
+	for i := 0; !ws.needStop; i++ {
+		if ws.urlFn != nil {
+			ws.url = ws.urlFn()
+		}
 
-	dialer := websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 5 * time.Second}
-	for i := 0; ; i++ {
-		logger.LogImportant(ws.logPrefix, "dialing....(%d)", i)
-		c, _, err := dialer.Dial(ws.url, nil)
+		logger.LogImportant(ws.logPrefix, "dialing....(%d-%d) url=%s", ws.reConnCount, i, ws.url)
+		c, resp, err := dialer.Dial(network.RewriteWsUrl(ws.url), nil)
 		if err == nil {
 			logger.LogImportant(ws.logPrefix, "connect success, local addr:%s, remote addr: %s", c.LocalAddr().String(), c.RemoteAddr().String())
+			if ws.compression {
+				logger.LogImportant(ws.logPrefix, "compression negotiated: [%s]", resp.Header.Get("Sec-Websocket-Extensions"))
+			}
 			c.SetReadDeadline(time.Time{}) // 读取永不超时
 			c.SetPingHandler(func(appData string) error {
 				logger.LogImportant(ws.logPrefix, "recv ping: %s", appData)
+				ws.lastRecv.Store(time.Now().UnixMilli())
+				ws.muConn.Lock()
+				defer ws.muConn.Unlock()
 				return c.WriteMessage(websocket.PongMessage, []byte(appData))
 			})
-			ws.Conn = c
+			c.SetPongHandler(func(appData string) error {
+				ws.lastRecv.Store(time.Now().UnixMilli())
+				return nil
+			})
+			ws.backoff.Reset()
+			ws.needResub = true
+			ws.muConn.Lock()
+			ws.conn = c
+			ws.muConn.Unlock()
+			ws.setState(network.ConnState_Connected, "")
 			break
 		} else {
-			logger.LogImportant(ws.logPrefix, "dailing failed, retry in 5 seconds...")
+			d := ws.backoff.Next()
+			logger.LogImportant(ws.logPrefix, "dailing failed, retry in %v...", d.Round(time.Millisecond))
 			logger.LogImportant(ws.logPrefix, "err=%s", err.Error())
-			time.Sleep(time.Second * 5)
+			time.Sleep(d)
 		}
 	}
 }
 
 // 发送消息
 func (ws *WsConnection) Send(msg string) {
-	if ws.Conn != nil {
-		func() {
-			ws.muConn.Lock()
-			defer ws.muConn.Unlock()
-			defer util.DefaultRecover()
-			err := ws.Conn.WriteMessage(websocket.TextMessage, []byte(msg))
-			if err != nil {
-				logger.LogImportant(ws.logPrefix, "send message failed, msg=%s, err=%s", msg, err.Error())
-			} else {
-				if LogWebsocketDetail {
-					logger.LogDebug(ws.logPrefix, "send: %s", msg)
-				}
+	ws.muConn.Lock()
+	defer ws.muConn.Unlock()
+	defer util.DefaultRecover()
+	if ws.conn != nil {
+		err := ws.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		if err != nil {
+			logger.LogImportant(ws.logPrefix, "send message failed, msg=%s, err=%s", msg, err.Error())
+		} else {
+			if LogWebsocketDetail {
+				logger.LogDebug(ws.logPrefix, "send: %s", msg)
 			}
-		}()
+		}
 	} else {
 		logger.LogImportant(ws.logPrefix, "conn not ready yet")
 	}
@@ -178,9 +268,9 @@ func (ws *WsConnection) SendPing(data []byte) {
 	ws.muConn.Lock()
 	defer ws.muConn.Unlock()
 	defer util.DefaultRecover()
-	if ws.Conn != nil {
-		ws.Conn.WriteMessage(websocket.PingMessage, nil)
-		if err := ws.Conn.WriteMessage(websocket.PingMessage, data); err == nil {
+	if ws.conn != nil {
+		ws.conn.WriteMessage(websocket.PingMessage, nil)
+		if err := ws.conn.WriteMessage(websocket.PingMessage, data); err == nil {
 			logger.LogInfo(ws.logPrefix, "sended ping: %s", string(data))
 		} else {
 			logger.LogImportant(ws.logPrefix, "send ping failed: %s", err.Error())
@@ -193,8 +283,8 @@ func (ws *WsConnection) SendPong(data []byte) {
 	ws.muConn.Lock()
 	defer ws.muConn.Unlock()
 	defer util.DefaultRecover()
-	if ws.Conn != nil {
-		if err := ws.Conn.WriteMessage(websocket.PongMessage, data); err == nil {
+	if ws.conn != nil {
+		if err := ws.conn.WriteMessage(websocket.PongMessage, data); err == nil {
 			logger.LogInfo(ws.logPrefix, "sended pong: %s", string(data))
 		} else {
 			logger.LogImportant(ws.logPrefix, "send pong failed: %s", err.Error())
@@ -202,24 +292,27 @@ func (ws *WsConnection) SendPong(data []byte) {
 	}
 }
 
-// 接收消息
-func (ws *WsConnection) readMessage() {
+// 接收消息，连接断开时返回原因
+func (ws *WsConnection) readMessage() string {
+	reason := ""
 	for {
 		needReconnect := func() bool {
 			defer util.DefaultRecover()
-			if ws.Conn != nil {
-				messageType, msgData, err := ws.Conn.ReadMessage()
+			if c := ws.getConn(); c != nil {
+				messageType, msgData, err := c.ReadMessage()
 				if err != nil {
 					logger.LogImportant(ws.logPrefix, "readMessage error: %s", err.Error())
 					logger.LogImportant(ws.logPrefix, "reconnect...")
+					reason = err.Error()
 					return true
 				} else {
+					ws.lastRecv.Store(time.Now().UnixMilli())
 					var msgStr string
 					switch messageType {
 					case websocket.TextMessage: // 文本消息
 						msgStr = string(msgData)
-					case websocket.BinaryMessage: // 压缩消息
-						msgDecode, err := util.GzipDecode(msgData)
+					case websocket.BinaryMessage: // 交易所自行压缩的消息(gzip/zlib/deflate)。permessage-deflate由websocket库透明解压
+						msgDecode, err := util.DecompressAuto(msgData)
 						if err == nil {
 							msgStr = string(msgDecode)
 						} else {
@@ -245,6 +338,7 @@ func (ws *WsConnection) readMessage() {
 
 				return false
 			} else {
+				reason = "connection closed"
 				return true
 			}
 		}()
@@ -253,6 +347,7 @@ func (ws *WsConnection) readMessage() {
 			break
 		}
 	}
+	return reason
 }
 
 // 订阅器逻辑循环
@@ -272,6 +367,11 @@ func (ws *WsConnection) keepSubscribing() {
 			ws.needResub = false
 		}
 
+		// 登录和订阅全部完成
+		if ws.State() == network.ConnState_Connected && !ws.needResub && ws.Ready() {
+			ws.setState(network.ConnState_Ready, "")
+		}
+
 		// 优先保证login成功
 		processOthers := false
 		if ws.subLogin == nil {
@@ -291,11 +391,17 @@ func (ws *WsConnection) keepSubscribing() {
 				}
 			}
 
-			// 清理订阅完毕的订阅器
-			// 为了简化处理，一个轮询只清理一个
+			// 订阅成功的订阅器保留下来，重连后重新订阅
+			// 反订阅成功后，移除它和之前同名的订阅器。为了简化处理，一个轮询只清理一个
 			for i, s := range ws.subOthers {
-				if s.Successed() {
-					util.SliceRemoveAt(ws.subOthers, i)
+				if !s.isSubscriber && s.Successed() {
+					kept := make([]*WsSubscriber, 0, len(ws.subOthers))
+					for j, o := range ws.subOthers {
+						if j != i && !(j < i && o.isSubscriber && o.name == s.name) {
+							kept = append(kept, o)
+						}
+					}
+					ws.subOthers = kept
 					break
 				}
 			}
@@ -311,9 +417,12 @@ func (ws *WsConnection) keepConnecting() {
 	for {
 		if !ws.needStop {
 			ws.connect()
-			ws.needResub = true
+			if ws.needStop {
+				break
+			}
 			ws.notifyConnectingToChans()
-			ws.readMessage()
+			reason := ws.readMessage()
+			ws.setState(network.ConnState_Disconnected, reason)
 		} else {
 			break
 		}
@@ -26,18 +26,21 @@ const (
 )
 
 type WsSubscriber struct {
-	name       string
-	text       string
-	actionName string
-	gen        SubscribeTextGen
-	succKeys   []string
-	status     int // Subscriber_status_xxx
-	onRecv     chan WSRawMsg
+	name         string
+	text         string
+	actionName   string
+	isSubscriber bool
+	gen          SubscribeTextGen
+	succKeys     []string
+	status       int // Subscriber_status_xxx
+	resetTime    int64
+	onRecv       chan WSRawMsg
 }
 
 func (s *WsSubscriber) Init(name string, text string, isSubscriber bool, gen SubscribeTextGen, successKeys []string) {
 	s.name = name
 	s.text = text
+	s.isSubscriber = isSubscriber
 	if isSubscriber {
 		s.actionName = "subscribe"
 	} else {
@@ -54,7 +57,9 @@ func (s *WsSubscriber) startSubscribing() {
 	s.status = Subscriber_status_subscribing
 }
 
+// 重置后立即重新发送(如断线重连后)
 func (s *WsSubscriber) Reset() {
+	s.resetTime = time.Now().UnixMilli()
 	s.status = Subscriber_status_subscribing
 }
 
@@ -96,7 +101,7 @@ func (s *WsSubscriber) run(ws *WsConnection) {
 		case <-ticker.C:
 			if s.status == Subscriber_status_subscribing &&
 				ws.Connected() &&
-				time.Now().UnixMilli()-lastSendTime.UnixMilli() > 5000 {
+				(time.Now().UnixMilli()-lastSendTime.UnixMilli() > 5000 || lastSendTime.UnixMilli() < s.resetTime) {
 				logger.LogInfo(ws.logPrefix, "%s [%s] trying...", s.actionName, s.name)
 				ws.AddRecvChans(s.onRecv)
 				if s.gen != nil {
@@ -0,0 +1,399 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 10:12:35
+- @Description: 模拟账户。保存所有币种的余额、合约仓位和成交记录，由模拟交易器(SimSpotTrader/SimFutureTrader)撮合后更新
+- 不依赖行情来源，回测(SimExchange)和基于实盘行情的模拟交易都可以使用
+- 现货手续费统一以计价币扣除，合约手续费以保证金币种扣除，开启平台币抵扣时从抵扣币中扣除(见cost.go)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+type Account struct {
+	mu        sync.Mutex
+	feeMaker  decimal.Decimal
+	feeTaker  decimal.Decimal
+	clock     common.Clock
+	orderId   int64
+	balances  map[string]*balance
+	positions map[string]*position        // instId-position
+	fills     map[string][]Fill           // instId-成交记录
+	fundings  map[string][]FundingPayment // instId-资金费记录
+	match     MatchConfig
+	rnd       *rand.Rand
+	cost      CostConfig
+	slippage  SlippageModel
+	volumes   []volumeRecord  // 近30日的成交额记录
+	volume    decimal.Decimal // 近30日的成交额
+
+	// 组合模式(见portfolio.go)
+	portfolio    PortfolioConfig
+	fnPrice      func(ccy string) decimal.Decimal
+	pnls         map[string]*pnlTally // instId-累计盈亏
+	liquidations []time.Time
+
+	// 事件发布的总线，订单是否与全局状态隔离(见Isolate)
+	bus      *common.EventBus
+	isolated bool
+	checkers []common.PreTradeChecker
+}
+
+// balances为各币种的初始余额，clock为模拟时钟，为nil时使用本地时间
+func NewAccount(balances map[string]decimal.Decimal, feeMaker, feeTaker decimal.Decimal, clock common.Clock) *Account {
+	a := new(Account)
+	a.feeMaker = feeMaker
+	a.feeTaker = feeTaker
+	a.clock = common.ClockOrReal(clock)
+	a.balances = make(map[string]*balance)
+	a.positions = make(map[string]*position)
+	a.fills = make(map[string][]Fill)
+	a.fundings = make(map[string][]FundingPayment)
+	a.pnls = make(map[string]*pnlTally)
+	a.rnd = rand.New(rand.NewSource(0))
+	a.bus = common.DefaultEventBus
+	for ccy, v := range balances {
+		a.balance(ccy).cash = v
+	}
+	return a
+}
+
+// 与全局状态隔离：订单不经过全局的下单前检查(风控、合理性检查)，不写审计、追踪和延迟统计，事件发布到bus而不是DefaultEventBus(bus可以为nil)
+// 回测需要隔离，否则并行回测之间、回测与同进程的实盘之间会互相影响。模拟盘保持默认，与实盘行为一致
+func (a *Account) Isolate(bus *common.EventBus) {
+	a.bus = bus
+	a.isolated = true
+}
+
+// 隔离后使用的下单前检查器，需在下单之前添加
+func (a *Account) AddPreTradeChecker(c common.PreTradeChecker) {
+	a.checkers = append(a.checkers, c)
+}
+
+// 事件发布的总线，隔离且未指定总线时为nil
+func (a *Account) EventBus() *common.EventBus {
+	return a.bus
+}
+
+// 模拟时钟，回放开始前(时钟为零值)使用本地时间
+func (a *Account) Now() time.Time {
+	if t := a.clock.Now(); !t.IsZero() {
+		return t
+	}
+	return time.Now()
+}
+
+func (a *Account) Clock() common.Clock {
+	return a.clock
+}
+
+// 当前maker费率，配置了手续费等级时与30日成交额有关
+func (a *Account) FeeMaker() decimal.Decimal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.feeRate(true)
+}
+
+func (a *Account) FeeTaker() decimal.Decimal {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.feeRate(false)
+}
+
+// 某币种余额，不存在时创建一个空余额
+func (a *Account) Balance(ccy string) common.Balance {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.balance(ccy)
+}
+
+func (a *Account) Balances() []common.Balance {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]common.Balance, 0, len(a.balances))
+	for _, b := range a.balances {
+		result = append(result, b)
+	}
+	return result
+}
+
+func (a *Account) Positions() []common.Position {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]common.Position, 0, len(a.positions))
+	for _, p := range a.positions {
+		result = append(result, p)
+	}
+	return result
+}
+
+// [t0, t1)之间的成交记录
+func (a *Account) DealHistory(instId string, t0, t1 time.Time) []common.DealHistory {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := []common.DealHistory{}
+	for _, f := range a.fills[instId] {
+		if !f.Time.Before(t0) && f.Time.Before(t1) {
+			result = append(result, common.DealHistory{Time: f.Time, Dir: f.Dir, Price: f.Price, Amount: f.Amount})
+		}
+	}
+	return slices.Clip(result)
+}
+
+// 所有品种的成交明细，instId-成交记录
+func (a *Account) Fills() map[string][]Fill {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make(map[string][]Fill, len(a.fills))
+	for instId, fills := range a.fills {
+		result[instId] = slices.Clone(fills)
+	}
+	return result
+}
+
+// 以下函数需在持有锁的情况下调用
+func (a *Account) balance(ccy string) *balance {
+	b, ok := a.balances[ccy]
+	if !ok {
+		b = &balance{acc: a, ccy: ccy}
+		a.balances[ccy] = b
+	}
+	return b
+}
+
+func (a *Account) nextOrderId() int64 {
+	a.orderId++
+	return a.orderId
+}
+
+// 合约仓位，同一合约只创建一次
+func (a *Account) position(inst *common.Instruments, lever int, fnMarkPrice func() decimal.Decimal) *position {
+	p, ok := a.positions[inst.Id]
+	if !ok {
+		p = &position{
+			acc:          a,
+			instId:       inst.Id,
+			symbol:       inst.CtSymbol,
+			contractType: string(inst.CtType),
+			settleCcy:    inst.CtSettleCcy,
+			ctVal:        inst.CtVal,
+			inverse:      !inst.IsUsdtContract,
+			lever:        lever,
+			fnMarkPrice:  fnMarkPrice,
+		}
+		a.positions[inst.Id] = p
+	}
+	return p
+}
+
+func (a *Account) recordFill(f Fill) {
+	a.fills[f.InstId] = append(a.fills[f.InstId], f)
+	t := a.tally(f.InstId, f.Ccy)
+	t.realized = t.realized.Add(f.Realized)
+	t.fee = t.fee.Add(f.Fee)
+}
+
+// 成交明细，用于生成回测报告
+type Fill struct {
+	InstId   string
+	Time     time.Time
+	Dir      common.OrderDir
+	Price    decimal.Decimal
+	Amount   decimal.Decimal
+	Value    decimal.Decimal // 成交额(以Ccy计)
+	Fee      decimal.Decimal // 以Ccy扣除的手续费，已用抵扣币支付的部分不计入
+	Realized decimal.Decimal // 已实现盈亏(不含手续费)，开仓为0
+	Ccy      string          // 计价币/保证金币种
+	Taker    bool
+}
+
+// #region balance
+type balance struct {
+	acc    *Account
+	ccy    string
+	cash   decimal.Decimal // 不含未实现盈亏的余额
+	frozen decimal.Decimal // 挂单冻结
+}
+
+// 持仓的未实现盈亏和占用保证金
+func (b *balance) positionStat() (upl, margin decimal.Decimal) {
+	for _, p := range b.acc.positions {
+		if p.settleCcy == b.ccy {
+			upl = upl.Add(p.upl())
+			margin = margin.Add(p.margin())
+		}
+	}
+	return
+}
+
+func (b *balance) Ccy() string {
+	return b.ccy
+}
+
+func (b *balance) Rights() decimal.Decimal {
+	b.acc.mu.Lock()
+	defer b.acc.mu.Unlock()
+	upl, _ := b.positionStat()
+	return b.cash.Add(upl)
+}
+
+func (b *balance) Frozen() decimal.Decimal {
+	b.acc.mu.Lock()
+	defer b.acc.mu.Unlock()
+	_, margin := b.positionStat()
+	return b.frozen.Add(margin)
+}
+
+func (b *balance) Available() decimal.Decimal {
+	b.acc.mu.Lock()
+	defer b.acc.mu.Unlock()
+	return b.available()
+}
+
+// 全仓模式下为账户总可用权益折算到本币种
+func (b *balance) available() decimal.Decimal {
+	if b.acc.crossMargin() {
+		if px := b.acc.price(b.ccy); px.IsPositive() {
+			return b.acc.crossAvailable().Div(px)
+		}
+	}
+	return b.isolatedAvailable()
+}
+
+// 仅以本币种计算的可用余额
+func (b *balance) isolatedAvailable() decimal.Decimal {
+	upl, margin := b.positionStat()
+	return b.cash.Add(upl).Sub(b.frozen).Sub(margin)
+}
+
+// #endregion
+
+// #region position
+// 单向持仓(净仓位)
+type position struct {
+	acc          *Account
+	instId       string
+	symbol       string
+	contractType string
+	settleCcy    string
+	ctVal        decimal.Decimal
+	inverse      bool // 币本位合约
+	lever        int
+	fnMarkPrice  func() decimal.Decimal
+
+	net   decimal.Decimal
+	avgPx decimal.Decimal
+}
+
+// sz张合约在px价格下的价值(以保证金币种计)
+func (p *position) value(px, sz decimal.Decimal) decimal.Decimal {
+	if p.inverse {
+		if !px.IsPositive() {
+			return decimal.Zero
+		}
+		return sz.Mul(p.ctVal).Div(px)
+	}
+	return sz.Mul(p.ctVal).Mul(px)
+}
+
+// 以px平掉sz张(带符号，正数为多仓)的盈亏
+func (p *position) pnl(sz, px decimal.Decimal) decimal.Decimal {
+	if p.inverse {
+		if !px.IsPositive() || !p.avgPx.IsPositive() {
+			return decimal.Zero
+		}
+		return sz.Mul(p.ctVal).Mul(decimal.NewFromInt(1).Div(p.avgPx).Sub(decimal.NewFromInt(1).Div(px)))
+	}
+	return sz.Mul(p.ctVal).Mul(px.Sub(p.avgPx))
+}
+
+func (p *position) upl() decimal.Decimal {
+	if p.net.IsZero() {
+		return decimal.Zero
+	}
+	return p.pnl(p.net, p.fnMarkPrice())
+}
+
+func (p *position) margin() decimal.Decimal {
+	return p.value(p.avgPx, p.net.Abs()).Div(decimal.NewFromInt(int64(max(p.lever, 1))))
+}
+
+// 仓位成交，返回已实现盈亏
+func (p *position) onFill(dir common.OrderDir, px, sz decimal.Decimal) decimal.Decimal {
+	signed := sz
+	if dir == common.OrderDir_Sell {
+		signed = sz.Neg()
+	}
+
+	realized := decimal.Zero
+	if p.net.IsZero() || p.net.Sign() == signed.Sign() {
+		// 开仓，更新均价(币本位按调和平均)
+		n := p.net.Abs()
+		if p.inverse {
+			p.avgPx = n.Add(sz).Div(p.value(p.avgPx, n).Add(p.value(px, sz)).Div(p.ctVal))
+		} else {
+			p.avgPx = n.Mul(p.avgPx).Add(sz.Mul(px)).Div(n.Add(sz))
+		}
+		p.net = p.net.Add(signed)
+	} else {
+		// 平仓，超出部分反向开仓
+		closeSz := decimal.Min(sz, p.net.Abs())
+		realized = p.pnl(decimal.NewFromInt(int64(p.net.Sign())).Mul(closeSz), px)
+		p.net = p.net.Add(signed)
+		if p.net.IsZero() {
+			p.avgPx = decimal.Zero
+		} else if p.net.Sign() == signed.Sign() {
+			p.avgPx = px
+		}
+	}
+	return realized
+}
+
+func (p *position) Symbol() string {
+	return p.symbol
+}
+
+func (p *position) ContractType() string {
+	return p.contractType
+}
+
+func (p *position) Long() decimal.Decimal {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return decimal.Max(p.net, decimal.Zero)
+}
+
+func (p *position) Short() decimal.Decimal {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return decimal.Max(p.net.Neg(), decimal.Zero)
+}
+
+func (p *position) LongAvgPx() decimal.Decimal {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return util.ValueIf(p.net.IsPositive(), p.avgPx, decimal.Zero)
+}
+
+func (p *position) ShortAvgPx() decimal.Decimal {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return util.ValueIf(p.net.IsNegative(), p.avgPx, decimal.Zero)
+}
+
+func (p *position) Net() decimal.Decimal {
+	p.acc.mu.Lock()
+	defer p.acc.mu.Unlock()
+	return p.net
+}
+
+// #endregion
@@ -0,0 +1,597 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-18 10:05:41
+- @Description: 回测检查点。按回放时钟定期保存模拟器状态(余额、仓位、挂单、成交记录、报告采样、策略状态)，中断后可从检查点继续回放
+- 策略状态通过可选的Snapshotter接口保存和恢复
+- 检查点文件带版本号(见util.VersionedObjectToFile)，并记录回测配置的哈希，配置变化后拒绝恢复
+- 成交和资金费记录只增不改，增量追加到<Path>.ledger.jsonl，检查点中只记录各品种的条数
+- 用法：Init -> UseXXX -> NewReporter -> SetSnapshotter -> Resume -> Go。Resume找不到检查点时从头开始
+- 注意：
+- 1. 恢复的挂单没有成交回调(OrderObserver无法序列化)，策略应在Restore中通过交易器的Orders()按CltOrderId找回订单并重新AddObserver
+- 2. 检查点在某个行情事件的处理过程中保存，与检查点时间相同的后续事件在恢复后不再回放
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 策略状态的保存和恢复
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+const checkpointKind = "backtest_checkpoint"
+const checkpointVersion = 1
+
+type CheckpointConfig struct {
+	Path        string `json:"path"`         // 检查点文件，为空时不保存
+	IntervalSec int    `json:"interval_sec"` // 保存间隔(回放时钟)，默认86400
+}
+
+type positionState struct {
+	Net   decimal.Decimal `json:"net"`
+	AvgPx decimal.Decimal `json:"avg_px"`
+}
+
+type spotState struct {
+	Held    decimal.Decimal `json:"held"`
+	AvgCost decimal.Decimal `json:"avg_cost"`
+}
+
+type orderState struct {
+	InstId      string             `json:"inst_id"`
+	OrderId     int64              `json:"order_id"`
+	CltOrderId  string             `json:"clt_order_id"`
+	Dir         common.OrderDir    `json:"dir"`
+	Price       decimal.Decimal    `json:"price"`
+	Size        decimal.Decimal    `json:"size"`
+	QuoteSize   decimal.Decimal    `json:"quote_size"`
+	Filled      decimal.Decimal    `json:"filled"`
+	AvgPrice    decimal.Decimal    `json:"avg_price"`
+	QuoteFilled decimal.Decimal    `json:"quote_filled"`
+	ReduceOnly  bool               `json:"reduce_only"`
+	MakeOnly    bool               `json:"make_only"`
+	TimeInForce common.TimeInForce `json:"tif"`
+	MarketOrder bool               `json:"market_order"`
+	Purpose     string             `json:"purpose"`
+	Status      string             `json:"status"`
+	Borntime    time.Time          `json:"born_time"`
+	UpdateTime  time.Time          `json:"update_time"`
+	Acked       bool               `json:"acked"`
+	AckTime     time.Time          `json:"ack_time"`
+	CancelTime  time.Time          `json:"cancel_time"`
+	QueueAhead  decimal.Decimal    `json:"queue_ahead"`
+}
+
+type volumeState struct {
+	Time  time.Time       `json:"time"`
+	Value decimal.Decimal `json:"value"`
+}
+
+type reporterState struct {
+	Next     time.Time     `json:"next"`
+	Equity   []EquityPoint `json:"equity"`
+	Curves   [][]float64   `json:"curves"`
+	MaxGross float64       `json:"max_gross"`
+	MaxNet   float64       `json:"max_net"`
+}
+
+type checkpoint struct {
+	Time          time.Time                  `json:"time"`
+	ConfigHash    string                     `json:"config_hash"`
+	OrderId       int64                      `json:"order_id"`
+	Balances      map[string]decimal.Decimal `json:"balances"` // 不含冻结，冻结由挂单重新计算
+	Positions     map[string]positionState   `json:"positions"`
+	Spots         map[string]spotState       `json:"spots"`
+	Orders        []orderState               `json:"orders"`
+	FillCounts    map[string]int             `json:"fill_counts"`    // 各品种的成交条数，记录在ledger中
+	FundingCounts map[string]int             `json:"funding_counts"` // 各品种的资金费条数，记录在ledger中
+	Volumes       []volumeState              `json:"volumes"`
+	Liquidations  []time.Time                `json:"liquidations"`
+	Reporter      *reporterState             `json:"reporter"`
+	Stratergy     json.RawMessage            `json:"stratergy"`
+}
+
+// ledger文件的一行，Fill和Funding二选一
+type ledgerEntry struct {
+	Fill    *Fill           `json:"fill,omitempty"`
+	Funding *FundingPayment `json:"funding,omitempty"`
+}
+
+// 已追加到ledger文件的条数
+type ledgerState struct {
+	fills    map[string]int
+	fundings map[string]int
+}
+
+// 回测配置的哈希，不含检查点设置本身
+func (e *SimExchange) configHash() string {
+	cfg := e.cfg
+	cfg.Checkpoint = CheckpointConfig{}
+	b, _ := json.Marshal(cfg)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:8])
+}
+
+func (e *SimExchange) ledgerPath() string {
+	return e.cfg.Checkpoint.Path + ".ledger.jsonl"
+}
+
+// 设置策略状态的保存/恢复接口，需在Resume之前调用
+func (e *SimExchange) SetSnapshotter(s Snapshotter) {
+	e.snapshotter = s
+}
+
+// #region 保存
+// 观察所有行情，到达保存间隔时保存检查点
+type checkpointer struct {
+	e        *SimExchange
+	interval time.Duration
+	next     time.Time
+}
+
+func (c *checkpointer) OnDepthChanged() {
+	now := c.e.Now()
+	if c.next.IsZero() {
+		c.next = now.Truncate(c.interval).Add(c.interval)
+		return
+	}
+
+	if now.Before(c.next) {
+		return
+	}
+
+	c.next = now.Truncate(c.interval).Add(c.interval)
+	if err := c.e.SaveCheckpoint(); err != nil {
+		logger.LogImportant(logPrefix, "save checkpoint failed: %s", err.Error())
+	}
+}
+
+func (e *SimExchange) startCheckpointer() {
+	if len(e.cfg.Checkpoint.Path) == 0 {
+		return
+	}
+
+	c := &checkpointer{e: e, interval: time.Duration(util.ValueIf(e.cfg.Checkpoint.IntervalSec > 0, e.cfg.Checkpoint.IntervalSec, 86400)) * time.Second}
+	for _, m := range e.spotMarkets {
+		m.AddDepthObserver(c)
+	}
+	for _, m := range e.futureMarkets {
+		m.AddDepthObserver(c)
+	}
+}
+
+// 立即保存检查点，需在回放协程中(行情回调里)或回放停止后调用
+func (e *SimExchange) SaveCheckpoint() error {
+	if len(e.cfg.Checkpoint.Path) == 0 {
+		return fmt.Errorf("checkpoint path not set")
+	}
+
+	cp := checkpoint{Time: e.Now(), ConfigHash: e.configHash()}
+	if e.snapshotter != nil {
+		b, err := e.snapshotter.Snapshot()
+		if err != nil {
+			return fmt.Errorf("snapshot stratergy failed: %s", err.Error())
+		}
+		cp.Stratergy = b
+	}
+
+	e.snapshotOrders(&cp)
+	entries := e.snapshotAccount(&cp)
+
+	// 先追加ledger再写检查点。中途中断时ledger中多出的记录在恢复时丢弃
+	if len(entries) > 0 {
+		if err := appendLedger(e.ledgerPath(), entries); err != nil {
+			return fmt.Errorf("append ledger failed: %s", err.Error())
+		}
+	}
+	e.ledger = ledgerState{fills: cp.FillCounts, fundings: cp.FundingCounts}
+
+	if r := e.reporter; r != nil {
+		r.mu.Lock()
+		cp.Reporter = &reporterState{Next: r.next, Equity: r.equity, Curves: r.curves, MaxGross: r.maxGross, MaxNet: r.maxNet}
+		b, err := json.Marshal(cp)
+		r.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return e.writeCheckpoint(b, cp.Time)
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return e.writeCheckpoint(b, cp.Time)
+}
+
+// 先写临时文件再替换，避免保存过程中中断导致检查点损坏
+func (e *SimExchange) writeCheckpoint(b []byte, t time.Time) error {
+	path := e.cfg.Checkpoint.Path
+	util.MakeSureDirForFile(path)
+	vb, err := json.Marshal(util.VersionedFile{Kind: checkpointKind, Version: checkpointVersion, Data: b})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", vb, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	logger.LogImportant(logPrefix, "checkpoint saved at %s", t.Format(time.DateTime))
+	return nil
+}
+
+func appendLedger(path string, entries []ledgerEntry) error {
+	util.MakeSureDirForFile(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	for _, en := range entries {
+		b, err := json.Marshal(en)
+		if err != nil {
+			return err
+		}
+		bw.Write(append(b, '\n'))
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (e *SimExchange) snapshotOrders(cp *checkpoint) {
+	traders := []*simTrader{}
+	for _, t := range e.spotTraders {
+		traders = append(traders, &t.simTrader)
+	}
+	for _, t := range e.futureTraders {
+		traders = append(traders, &t.simTrader)
+	}
+
+	for _, t := range traders {
+		t.mu.Lock()
+		t.acc.mu.Lock()
+		for _, o := range t.orders {
+			if o.finishing {
+				continue
+			}
+
+			cp.Orders = append(cp.Orders, orderState{
+				InstId:      o.InstId,
+				OrderId:     o.OrderId,
+				CltOrderId:  fmt.Sprintf("%v", o.CltOrderId),
+				Dir:         o.Dir,
+				Price:       o.Price,
+				Size:        o.Size,
+				QuoteSize:   o.QuoteSize,
+				Filled:      o.Filled,
+				AvgPrice:    o.AvgPrice,
+				QuoteFilled: o.quoteFilled,
+				ReduceOnly:  o.ReduceOnly,
+				MakeOnly:    o.MakeOnly,
+				TimeInForce: o.TimeInForce,
+				MarketOrder: o.MarketOrder,
+				Purpose:     o.Purpose,
+				Status:      o.Status,
+				Borntime:    o.Borntime,
+				UpdateTime:  o.UpdateTime,
+				Acked:       o.acked,
+				AckTime:     o.ackTime,
+				CancelTime:  o.cancelTime,
+				QueueAhead:  o.queueAhead,
+			})
+		}
+		t.acc.mu.Unlock()
+		t.mu.Unlock()
+	}
+}
+
+// 返回上次保存之后新增的成交和资金费记录
+func (e *SimExchange) snapshotAccount(cp *checkpoint) []ledgerEntry {
+	a := e.acc
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cp.OrderId = a.orderId
+	cp.Balances = make(map[string]decimal.Decimal)
+	for ccy, b := range a.balances {
+		cp.Balances[ccy] = b.cash
+	}
+
+	cp.Positions = make(map[string]positionState)
+	for instId, p := range a.positions {
+		cp.Positions[instId] = positionState{Net: p.net, AvgPx: p.avgPx}
+	}
+
+	cp.Spots = make(map[string]spotState)
+	for instId, t := range e.spotTraders {
+		cp.Spots[instId] = spotState{Held: t.held, AvgCost: t.avgCost}
+	}
+
+	entries := []ledgerEntry{}
+	cp.FillCounts = make(map[string]int)
+	for instId, fills := range a.fills {
+		for i := e.ledger.fills[instId]; i < len(fills); i++ {
+			entries = append(entries, ledgerEntry{Fill: &fills[i]})
+		}
+		cp.FillCounts[instId] = len(fills)
+	}
+
+	cp.FundingCounts = make(map[string]int)
+	for instId, ps := range a.fundings {
+		for i := e.ledger.fundings[instId]; i < len(ps); i++ {
+			entries = append(entries, ledgerEntry{Funding: &ps[i]})
+		}
+		cp.FundingCounts[instId] = len(ps)
+	}
+
+	for _, v := range a.volumes {
+		cp.Volumes = append(cp.Volumes, volumeState{Time: v.t, Value: v.value})
+	}
+	cp.Liquidations = a.liquidations
+	return entries
+}
+
+// #endregion
+
+// #region 恢复
+// 从检查点恢复状态，需在UseXXX、NewReporter、SetSnapshotter之后，Go之前调用。返回是否找到了检查点
+func (e *SimExchange) Resume() (bool, error) {
+	if len(e.cfg.Checkpoint.Path) == 0 {
+		return false, nil
+	}
+
+	if exist, _ := util.PathExists(e.cfg.Checkpoint.Path); !exist {
+		return false, nil
+	}
+
+	cp := checkpoint{}
+	if !util.VersionedObjectFromFile(e.cfg.Checkpoint.Path, checkpointKind, checkpointVersion, &cp) {
+		return false, fmt.Errorf("invalid checkpoint %s", e.cfg.Checkpoint.Path)
+	}
+
+	if h := e.configHash(); cp.ConfigHash != h {
+		return false, fmt.Errorf("checkpoint config hash mismatch(%s != %s), config changed since the checkpoint was saved", cp.ConfigHash, h)
+	}
+
+	fills, fundings, err := e.loadLedger(&cp)
+	if err != nil {
+		return false, err
+	}
+
+	e.replayer.ResumeFrom(cp.Time)
+	if err := e.restoreAccount(&cp, fills, fundings); err != nil {
+		return false, err
+	}
+	e.restoreOrders(&cp)
+
+	if r := e.reporter; r != nil && cp.Reporter != nil {
+		r.mu.Lock()
+		r.next, r.equity, r.maxGross, r.maxNet = cp.Reporter.Next, cp.Reporter.Equity, cp.Reporter.MaxGross, cp.Reporter.MaxNet
+		if len(cp.Reporter.Curves) == len(r.curves) {
+			r.curves = cp.Reporter.Curves
+		}
+		r.mu.Unlock()
+	}
+
+	if e.snapshotter != nil && len(cp.Stratergy) > 0 {
+		if err := e.snapshotter.Restore(cp.Stratergy); err != nil {
+			return false, fmt.Errorf("restore stratergy failed: %s", err.Error())
+		}
+	}
+
+	logger.LogImportant(logPrefix, "resumed from checkpoint at %s, %d orders restored", cp.Time.Format(time.DateTime), len(cp.Orders))
+	return true, nil
+}
+
+// 读取ledger，每个品种只取检查点记录的条数。有多余的记录(保存中途中断)时重写ledger
+func (e *SimExchange) loadLedger(cp *checkpoint) (map[string][]Fill, map[string][]FundingPayment, error) {
+	all, broken, err := readLedger(e.ledgerPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("read ledger failed: %s", err.Error())
+	}
+
+	fills := make(map[string][]Fill)
+	fundings := make(map[string][]FundingPayment)
+	entries := []ledgerEntry{}
+	dropped := broken
+	for _, en := range all {
+		if en.Fill != nil && len(fills[en.Fill.InstId]) < cp.FillCounts[en.Fill.InstId] {
+			fills[en.Fill.InstId] = append(fills[en.Fill.InstId], *en.Fill)
+			entries = append(entries, en)
+		} else if en.Funding != nil && len(fundings[en.Funding.InstId]) < cp.FundingCounts[en.Funding.InstId] {
+			fundings[en.Funding.InstId] = append(fundings[en.Funding.InstId], *en.Funding)
+			entries = append(entries, en)
+		} else {
+			dropped++
+		}
+	}
+
+	for instId, n := range cp.FillCounts {
+		if len(fills[instId]) != n {
+			return nil, nil, fmt.Errorf("ledger incomplete, %s fills %d < %d", instId, len(fills[instId]), n)
+		}
+	}
+	for instId, n := range cp.FundingCounts {
+		if len(fundings[instId]) != n {
+			return nil, nil, fmt.Errorf("ledger incomplete, %s fundings %d < %d", instId, len(fundings[instId]), n)
+		}
+	}
+
+	if dropped > 0 {
+		logger.LogImportant(logPrefix, "%d ledger entries after checkpoint dropped", dropped)
+		path := e.ledgerPath()
+		os.Remove(path + ".tmp")
+		if err := appendLedger(path+".tmp", entries); err != nil {
+			return nil, nil, err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	e.ledger = ledgerState{fills: cp.FillCounts, fundings: cp.FundingCounts}
+	return fills, fundings, nil
+}
+
+// 文件不存在时返回空。broken为无法解析的行数(保存中途中断时最后一行可能没写完)
+func readLedger(path string) (entries []ledgerEntry, broken int, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1024*64), 1024*1024)
+	for sc.Scan() {
+		en := ledgerEntry{}
+		if json.Unmarshal(sc.Bytes(), &en) != nil {
+			broken++
+			continue
+		}
+		entries = append(entries, en)
+	}
+	return entries, broken, sc.Err()
+}
+
+func (e *SimExchange) restoreAccount(cp *checkpoint, fills map[string][]Fill, fundings map[string][]FundingPayment) error {
+	a := e.acc
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for instId := range cp.Positions {
+		if _, ok := a.positions[instId]; !ok {
+			return fmt.Errorf("position %s in checkpoint, but its trader is not created", instId)
+		}
+	}
+
+	a.orderId = cp.OrderId
+	for _, b := range a.balances {
+		b.cash = decimal.Zero
+		b.frozen = decimal.Zero
+	}
+	for ccy, cash := range cp.Balances {
+		a.balance(ccy).cash = cash
+	}
+
+	for instId, ps := range cp.Positions {
+		a.positions[instId].net = ps.Net
+		a.positions[instId].avgPx = ps.AvgPx
+	}
+
+	for instId, ss := range cp.Spots {
+		if t, ok := e.spotTraders[instId]; ok {
+			t.held, t.avgCost = ss.Held, ss.AvgCost
+		}
+	}
+
+	a.fills = fills
+	a.fundings = fundings
+	a.volumes = nil
+	a.volume = decimal.Zero
+	for _, v := range cp.Volumes {
+		a.volumes = append(a.volumes, volumeRecord{t: v.Time, value: v.Value})
+		a.volume = a.volume.Add(v.Value)
+	}
+	a.liquidations = cp.Liquidations
+
+	// 累计盈亏由成交和资金费记录重新统计
+	a.pnls = make(map[string]*pnlTally)
+	for instId, fills := range a.fills {
+		for _, f := range fills {
+			t := a.tally(instId, f.Ccy)
+			t.realized = t.realized.Add(f.Realized)
+			t.fee = t.fee.Add(f.Fee)
+		}
+	}
+	for instId, ps := range a.fundings {
+		for _, p := range ps {
+			t := a.tally(instId, p.Ccy)
+			t.funding = t.funding.Add(p.Amount)
+		}
+	}
+
+	// 资金费从检查点时间之后开始结算
+	for _, t := range e.futureTraders {
+		if t.funding != nil {
+			t.funding.last, t.funding.loaded = cp.Time, cp.Time
+		}
+	}
+	return nil
+}
+
+func (e *SimExchange) restoreOrders(cp *checkpoint) {
+	for _, s := range cp.Orders {
+		var t *simTrader
+		if st, ok := e.spotTraders[s.InstId]; ok {
+			t = &st.simTrader
+		} else if ft, ok := e.futureTraders[s.InstId]; ok {
+			t = &ft.simTrader
+		} else {
+			logger.LogImportant(logPrefix, "trader of order %s(%s) not found, order dropped", s.CltOrderId, s.InstId)
+			continue
+		}
+
+		o := new(SimOrder)
+		o.trader = t
+		o.Trader = t.self
+		o.InstrumentMgr = t.instrumentMgr
+		o.InstId = s.InstId
+		o.OrderId = s.OrderId
+		o.CltOrderId = s.CltOrderId
+		o.LogPrefix = fmt.Sprintf("Order-%s-%v", o.InstId, o.CltOrderId)
+		o.LogFields = logger.Fields{Exchange: t.exName, InstId: o.InstId, OrderId: fmt.Sprintf("%v", o.CltOrderId)}
+		o.Dir = s.Dir
+		o.Price = s.Price
+		o.Size = s.Size
+		o.QuoteSize = s.QuoteSize
+		o.Filled = s.Filled
+		o.AvgPrice = s.AvgPrice
+		o.quoteFilled = s.QuoteFilled
+		o.ReduceOnly = s.ReduceOnly
+		o.MakeOnly = s.MakeOnly
+		o.TimeInForce = s.TimeInForce
+		o.MarketOrder = s.MarketOrder
+		o.Purpose = s.Purpose
+		o.Status = s.Status
+		o.Borntime = s.Borntime
+		o.UpdateTime = s.UpdateTime
+		o.acked = s.Acked
+		o.ackTime = s.AckTime
+		o.cancelTime = s.CancelTime
+		o.queueAhead = s.QueueAhead
+		o.Observers = make([]common.OrderObserver, 0)
+
+		t.mu.Lock()
+		t.acc.mu.Lock()
+		t.orders = append(t.orders, o)
+		t.refreeze(o)
+		t.acc.mu.Unlock()
+		t.mu.Unlock()
+	}
+}
+
+// #endregion
@@ -0,0 +1,151 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-10 09:52:37
+- @Description: 交易成本模型：手续费等级(common.FeeSchedule)和taker滑点。回测和模拟交易使用同一套配置
+- 手续费：配置了FeeSchedule时按30日成交额分档，抵扣币余额充足时按折扣费率从抵扣币中扣除，否则使用固定的FeeMaker/FeeTaker
+- 滑点：taker成交价在盘口价格基础上再偏离一个比例，用于模拟盘口快照之间的变化和市场冲击。限价单的成交价不会差于订单价格
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"math"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+type CostConfig struct {
+	FeeSchedule   *common.FeeSchedule `json:"fee_schedule"`   // 为空时使用固定费率
+	DiscountPrice decimal.Decimal     `json:"discount_price"` // 抵扣币的价格(以手续费币种计)，用于换算抵扣数量。为0时不抵扣
+	Slippage      SlippageConfig      `json:"slippage"`
+}
+
+// #region 滑点
+// 滑点模型，返回taker成交价相对盘口价格的偏离比例(正数表示更差)
+type SlippageModel interface {
+	Slippage(ob *common.Orderbook, dir common.OrderDir, size decimal.Decimal) float64
+}
+
+// 固定滑点
+type FixedSlippage struct {
+	Bps float64
+}
+
+func (s FixedSlippage) Slippage(ob *common.Orderbook, dir common.OrderDir, size decimal.Decimal) float64 {
+	return s.Bps / 10000
+}
+
+// 市场冲击：Coef * (size / 对手方前Levels档的深度)^Exp，单位bps
+type ImpactSlippage struct {
+	Coef   float64
+	Exp    float64
+	Levels int
+}
+
+func (s ImpactSlippage) Slippage(ob *common.Orderbook, dir common.OrderDir, size decimal.Decimal) float64 {
+	asks, bids := ob.Levels(s.Levels)
+	depth := 0.0
+	for _, l := range util.ValueIf(dir == common.OrderDir_Buy, asks, bids) {
+		depth += l[1].InexactFloat64()
+	}
+
+	if depth <= 0 {
+		return s.Coef / 10000
+	}
+	return s.Coef * math.Pow(size.InexactFloat64()/depth, s.Exp) / 10000
+}
+
+type SlippageConfig struct {
+	Mode   string  `json:"mode"`   // fixed/impact，为空表示无滑点
+	Bps    float64 `json:"bps"`    // fixed: 固定滑点; impact: 冲击系数
+	Exp    float64 `json:"exp"`    // impact: 指数，默认0.5(平方根冲击)
+	Levels int     `json:"levels"` // impact: 统计深度的档数，默认5
+}
+
+func (c SlippageConfig) Model() SlippageModel {
+	switch c.Mode {
+	case "fixed":
+		return FixedSlippage{Bps: c.Bps}
+	case "impact":
+		return ImpactSlippage{Coef: c.Bps, Exp: util.ValueIf(c.Exp > 0, c.Exp, 0.5), Levels: util.ValueIf(c.Levels > 0, c.Levels, 5)}
+	default:
+		return nil
+	}
+}
+
+// #endregion
+
+// #region 手续费
+// 设置交易成本模型，需在创建交易器之前调用
+func (a *Account) SetCostConfig(cfg CostConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cfg.FeeSchedule != nil {
+		cfg.FeeSchedule.Init()
+	}
+	a.cost = cfg
+	a.slippage = cfg.Slippage.Model()
+}
+
+type volumeRecord struct {
+	t     time.Time
+	value decimal.Decimal
+}
+
+// 以下函数需在持有锁的情况下调用
+// 30日成交额
+func (a *Account) volume30d() decimal.Decimal {
+	t0 := a.Now().Add(-time.Hour * 24 * 30)
+	for len(a.volumes) > 0 && a.volumes[0].t.Before(t0) {
+		a.volume = a.volume.Sub(a.volumes[0].value)
+		a.volumes = a.volumes[1:]
+	}
+	return a.volume
+}
+
+// 抵扣币余额是否足够支付fee
+func (a *Account) discountAvail(fee decimal.Decimal) bool {
+	s := a.cost.FeeSchedule
+	if s == nil || len(s.DiscountCcy) == 0 || !a.cost.DiscountPrice.IsPositive() {
+		return false
+	}
+	avail := a.balance(s.DiscountCcy).isolatedAvailable()
+	return avail.IsPositive() && avail.GreaterThanOrEqual(fee.Div(a.cost.DiscountPrice))
+}
+
+// 当前费率
+func (a *Account) feeRate(isMaker bool) decimal.Decimal {
+	if s := a.cost.FeeSchedule; s != nil {
+		return s.Charge(decimal.NewFromInt(1), isMaker, a.volume30d(), a.discountAvail(decimal.Zero)).Rate
+	}
+	return util.ValueIf(isMaker, a.feeMaker, a.feeTaker)
+}
+
+// 成交value产生的手续费，返回需要从手续费币种中扣除的数量(已用抵扣币支付的部分为0)
+func (a *Account) charge(value decimal.Decimal, isMaker bool) decimal.Decimal {
+	fee := decimal.Zero
+	if s := a.cost.FeeSchedule; s != nil {
+		full := s.Charge(value, isMaker, a.volume30d(), false)
+		if a.discountAvail(full.Amount) {
+			if c := s.Charge(value, isMaker, a.volume30d(), true); len(c.Ccy) > 0 {
+				b := a.balance(c.Ccy)
+				b.cash = b.cash.Sub(c.Amount.Div(a.cost.DiscountPrice))
+			} else {
+				fee = c.Amount // 返佣不抵扣
+			}
+		} else {
+			fee = full.Amount
+		}
+	} else {
+		fee = value.Abs().Mul(util.ValueIf(isMaker, a.feeMaker, a.feeTaker))
+	}
+
+	a.volumes = append(a.volumes, volumeRecord{t: a.Now(), value: value.Abs()})
+	a.volume = a.volume.Add(value.Abs())
+	return fee
+}
+
+// #endregion
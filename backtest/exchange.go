@@ -0,0 +1,340 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 15:06:12
+- @Description: 回测交易所，实现common.CEx。行情来自recorder录制的深度/逐笔成交(见data/replay)，K线来自data/klines
+- 订单在模拟交易器中按回放的盘口撮合，策略代码不需要区分回测和实盘
+- 用法：Init -> 策略中调用UseXXX创建行情/交易器 -> Go -> Wait。UseXXX需在Go之前全部调用完毕。中断后继续回放见checkpoint.go
+- 所有行情回调、成交回调都在回放协程中按顺序触发，订单时间、成交时间均为回放时钟
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/data/klines"
+	"github.com/aztecqt/dagger/data/replay"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+const exchangeName = "backtest"
+const logPrefix = exchangeName
+
+type Config struct {
+	Replay      replay.Config              `json:"replay"`
+	Instruments []common.Instruments       `json:"instruments"`  // 回测品种，Id需与录制目录中的品种名一致
+	Balances    map[string]decimal.Decimal `json:"balances"`     // 初始余额
+	FeeMaker    decimal.Decimal            `json:"fee_maker"`    //
+	FeeTaker    decimal.Decimal            `json:"fee_taker"`    //
+	KlineSource klines.Source              `json:"kline_source"` // K线数据源，品种Id即为该数据源的symbol。为空时不提供K线
+	FundingSrc  klines.Source              `json:"funding_src"`  // 资金费率数据源(okx/binance_future)，为空时同KlineSource。永续合约按历史费率结算资金费
+	Match       MatchConfig                `json:"match"`        // 撮合模型，可以使用VenueMatchConfig(录制行情的交易所)
+	Cost        CostConfig                 `json:"cost"`         // 手续费等级和滑点
+	Portfolio   PortfolioConfig            `json:"portfolio"`    // 全仓/强平设置，见portfolio.go
+	Checkpoint  CheckpointConfig           `json:"checkpoint"`   // 检查点设置，见checkpoint.go
+}
+
+type SimExchange struct {
+	cfg           Config
+	replayer      *replay.Replayer
+	instrumentMgr *common.InstrumentMgr
+	instruments   []*common.Instruments
+	acc           *Account
+
+	spotMarkets   map[string]*SimSpotMarket
+	futureMarkets map[string]*SimFutureMarket
+	spotTraders   map[string]*SimSpotTrader
+	futureTraders map[string]*SimFutureTrader
+	guard         *marginGuard
+	reporter      *Reporter
+	snapshotter   Snapshotter
+	ledger        ledgerState // 已追加到检查点ledger的记录数
+}
+
+func (e *SimExchange) Init(cfg Config) {
+	e.cfg = cfg
+	e.replayer = new(replay.Replayer)
+	e.replayer.Init(cfg.Replay)
+	e.instrumentMgr = common.NewInstrumentMgr(logPrefix)
+	for i := range cfg.Instruments {
+		inst := cfg.Instruments[i]
+		e.instrumentMgr.Set(inst.Id, &inst)
+		e.instruments = append(e.instruments, &inst)
+	}
+	e.acc = NewAccount(cfg.Balances, cfg.FeeMaker, cfg.FeeTaker, e.replayer.Clock())
+	e.acc.Isolate(common.NewEventBus())
+	e.acc.SetMatchConfig(cfg.Match)
+	e.acc.SetCostConfig(cfg.Cost)
+	if cfg.Portfolio.CrossMargin {
+		e.acc.SetPortfolio(cfg.Portfolio, func(ccy string) decimal.Decimal { return e.price(ccy, cfg.Portfolio.ValueCcy) })
+	}
+	e.spotMarkets = make(map[string]*SimSpotMarket)
+	e.futureMarkets = make(map[string]*SimFutureMarket)
+	e.spotTraders = make(map[string]*SimSpotTrader)
+	e.futureTraders = make(map[string]*SimFutureTrader)
+	logger.LogImportant(logPrefix, "inited, %d instruments", len(e.instruments))
+}
+
+// 开始回放
+func (e *SimExchange) Go() {
+	e.startCheckpointer()
+	e.replayer.Go()
+}
+
+// 等待回放结束
+func (e *SimExchange) Wait() {
+	e.replayer.Wait()
+}
+
+// 回放结束时回调
+func (e *SimExchange) SetFinishedFn(fn func()) {
+	e.replayer.SetFinishedFn(fn)
+}
+
+// 回放时钟，回放开始前为本地时间
+func (e *SimExchange) Now() time.Time {
+	return e.acc.Now()
+}
+
+// 回放时钟，策略中的定时逻辑应使用该时钟而不是time.Now/time.After
+func (e *SimExchange) Clock() common.Clock {
+	return e.replayer.Clock()
+}
+
+func (e *SimExchange) Account() *Account {
+	return e.acc
+}
+
+// 某币种以valueCcy计的价格，按回测品种的中间价换算(现货为ccy/valueCcy交易对，币本位合约为保证金币种的标记价格)，找不到时为0
+func (e *SimExchange) price(ccy, valueCcy string) decimal.Decimal {
+	if strings.EqualFold(ccy, valueCcy) {
+		return decimal.NewFromInt(1)
+	}
+
+	for _, m := range e.spotMarkets {
+		if strings.EqualFold(m.BaseCurrency(), ccy) && strings.EqualFold(m.QuoteCurrency(), valueCcy) {
+			return midPrice(m.Market)
+		}
+	}
+
+	for _, m := range e.futureMarkets {
+		if !m.inst.IsUsdtContract && strings.EqualFold(m.SettlementCurrency(), ccy) {
+			return m.MarkPrice()
+		}
+	}
+	return decimal.Zero
+}
+
+// #region 实现common.CEx
+func (e *SimExchange) Name() string {
+	return exchangeName
+}
+
+func (e *SimExchange) Instruments() []*common.Instruments {
+	return e.instruments
+}
+
+func (e *SimExchange) GetSpotInstrument(baseCcy, quoteCcy string) *common.Instruments {
+	for _, inst := range e.instruments {
+		if inst.CtType == "" && strings.EqualFold(inst.BaseCcy, baseCcy) && strings.EqualFold(inst.QuoteCcy, quoteCcy) {
+			return inst
+		}
+	}
+	return nil
+}
+
+func (e *SimExchange) GetFutureInstrument(symbol, contractType string) *common.Instruments {
+	for _, inst := range e.instruments {
+		if strings.EqualFold(inst.CtSymbol, symbol) && string(inst.CtType) == contractType {
+			return inst
+		}
+	}
+	return nil
+}
+
+func (e *SimExchange) GetUniAccRisk() common.UniAccRisk {
+	return common.UniAccRisk{Level: common.UniAccRiskLevel_Safe}
+}
+
+func (e *SimExchange) FutureMarkets() []common.FutureMarket {
+	result := make([]common.FutureMarket, 0, len(e.futureMarkets))
+	for _, m := range e.futureMarkets {
+		result = append(result, m)
+	}
+	return result
+}
+
+func (e *SimExchange) FutureTraders() []common.FutureTrader {
+	result := make([]common.FutureTrader, 0, len(e.futureTraders))
+	for _, t := range e.futureTraders {
+		result = append(result, t)
+	}
+	return result
+}
+
+func (e *SimExchange) UseFutureMarket(symbol, contractType string) common.FutureMarket {
+	inst := e.GetFutureInstrument(symbol, contractType)
+	if inst == nil {
+		logger.LogImportant(logPrefix, "future instrument not found, symbol=%s, contractType=%s", symbol, contractType)
+		return nil
+	}
+
+	if m, ok := e.futureMarkets[inst.Id]; ok {
+		return m
+	}
+
+	m := &SimFutureMarket{Market: e.replayer.AddMarket(*inst), inst: inst}
+	e.futureMarkets[inst.Id] = m
+	return m
+}
+
+func (e *SimExchange) UseFutureTrader(symbol, contractType string, lever int) common.FutureTrader {
+	m := e.UseFutureMarket(symbol, contractType)
+	if m == nil {
+		return nil
+	}
+
+	sm := m.(*SimFutureMarket)
+	if t, ok := e.futureTraders[sm.inst.Id]; ok {
+		return t
+	}
+
+	t := NewFutureTrader(exchangeName, e.acc, sm, sm.inst, e.instrumentMgr, lever)
+	if src := util.ValueIf(e.cfg.FundingSrc != "", e.cfg.FundingSrc, e.cfg.KlineSource); src != "" {
+		t.SetFundingSource(src, sm.inst.Id)
+	}
+	e.futureTraders[sm.inst.Id] = t
+
+	if e.cfg.Portfolio.CrossMargin && e.cfg.Portfolio.MaintMarginRate.IsPositive() {
+		if e.guard == nil {
+			e.guard = &marginGuard{e: e}
+		}
+		sm.AddDepthObserver(e.guard)
+	}
+	return t
+}
+
+func (e *SimExchange) SpotMarkets() []common.SpotMarket {
+	result := make([]common.SpotMarket, 0, len(e.spotMarkets))
+	for _, m := range e.spotMarkets {
+		result = append(result, m)
+	}
+	return result
+}
+
+func (e *SimExchange) SpotTraders() []common.SpotTrader {
+	result := make([]common.SpotTrader, 0, len(e.spotTraders))
+	for _, t := range e.spotTraders {
+		result = append(result, t)
+	}
+	return result
+}
+
+func (e *SimExchange) UseSpotMarket(baseCcy, quoteCcy string) common.SpotMarket {
+	inst := e.GetSpotInstrument(baseCcy, quoteCcy)
+	if inst == nil {
+		logger.LogImportant(logPrefix, "spot instrument not found, %s-%s", baseCcy, quoteCcy)
+		return nil
+	}
+
+	if m, ok := e.spotMarkets[inst.Id]; ok {
+		return m
+	}
+
+	m := &SimSpotMarket{Market: e.replayer.AddMarket(*inst), inst: inst}
+	e.spotMarkets[inst.Id] = m
+	return m
+}
+
+func (e *SimExchange) UseSpotTrader(baseCcy, quoteCcy string) common.SpotTrader {
+	m := e.UseSpotMarket(baseCcy, quoteCcy)
+	if m == nil {
+		return nil
+	}
+
+	sm := m.(*SimSpotMarket)
+	if t, ok := e.spotTraders[sm.inst.Id]; ok {
+		return t
+	}
+
+	t := NewSpotTrader(exchangeName, e.acc, sm, sm.inst, e.instrumentMgr)
+	e.spotTraders[sm.inst.Id] = t
+	return t
+}
+
+func (e *SimExchange) GetFinance() common.Finance {
+	return nil
+}
+
+func (e *SimExchange) GetAllPositions() []common.Position {
+	return e.acc.Positions()
+}
+
+func (e *SimExchange) GetAllBalances() []common.Balance {
+	return e.acc.Balances()
+}
+
+func (e *SimExchange) UseFundingFeeInfoObserver() common.FundingFeeObserver {
+	return nil
+}
+
+func (e *SimExchange) FundingFeeInfoObserver() common.FundingFeeObserver {
+	return nil
+}
+
+func (e *SimExchange) UseContractObserver(contractType string) common.ContractObserver {
+	return nil
+}
+
+// 不返回回放时钟之后的K线，避免使用未来数据
+func (e *SimExchange) getKline(inst *common.Instruments, t0, t1 time.Time, intervalSec int) []common.KUnit {
+	if inst == nil || e.cfg.KlineSource == "" {
+		return nil
+	}
+
+	t1 = util.ValueIf(t1.After(e.Now()), e.Now(), t1)
+	d := new(klines.Downloader)
+	d.Init(e.cfg.KlineSource)
+	if kus, ok := d.GetKlines(inst.Id, time.Duration(intervalSec)*time.Second, t0, t1); ok {
+		return kus
+	}
+	return nil
+}
+
+func (e *SimExchange) GetSpotKline(baseCcy, quoteCcy string, t0, t1 time.Time, intervalSec int) []common.KUnit {
+	return e.getKline(e.GetSpotInstrument(baseCcy, quoteCcy), t0, t1, intervalSec)
+}
+
+func (e *SimExchange) GetFutureKline(symbol, contractType string, t0, t1 time.Time, intervalSec int) []common.KUnit {
+	return e.getKline(e.GetFutureInstrument(symbol, contractType), t0, t1, intervalSec)
+}
+
+func (e *SimExchange) GetSpotDealHistory(baseCcy, quoteCcy string, t0, t1 time.Time) []common.DealHistory {
+	if inst := e.GetSpotInstrument(baseCcy, quoteCcy); inst != nil {
+		return e.acc.DealHistory(inst.Id, t0, t1)
+	}
+	return nil
+}
+
+func (e *SimExchange) GetFutureDealHistory(symbol, contractType string, t0, t1 time.Time) []common.DealHistory {
+	if inst := e.GetFutureInstrument(symbol, contractType); inst != nil {
+		return e.acc.DealHistory(inst.Id, t0, t1)
+	}
+	return nil
+}
+
+func (e *SimExchange) Exit() {
+	e.replayer.Stop()
+	for _, t := range e.spotTraders {
+		t.Uninit()
+	}
+	for _, t := range e.futureTraders {
+		t.Uninit()
+	}
+}
+
+// #endregion
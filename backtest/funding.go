@@ -0,0 +1,130 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-10 15:26:08
+- @Description: 永续合约资金费结算。按历史资金费率(cachedok/cachedbn)的结算时间，对当时持有的仓位收取/支付资金费
+- 资金费 = -仓位方向 * 仓位价值(按结算时的标记价格) * 费率，计入保证金币种的余额(费率为正时多头支付、空头收取)
+- 回放开始之前的结算时间不计算。费率按需分段加载，每段30天
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"slices"
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi/cachedbn"
+	"github.com/aztecqt/dagger/api/okexv5api/cachedok"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/data/klines"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type FundingRate struct {
+	Time time.Time
+	Rate decimal.Decimal
+}
+
+// 一次资金费结算
+type FundingPayment struct {
+	InstId    string
+	Time      time.Time
+	Rate      decimal.Decimal
+	MarkPrice decimal.Decimal
+	Position  decimal.Decimal // 结算时的净仓位(张)
+	Amount    decimal.Decimal // 正数为收入，负数为支出
+	Ccy       string
+}
+
+// 加载[t0, t1)区间内的历史资金费率。src为okx或binance_future，其余数据源返回空
+func LoadFundingRates(src klines.Source, instId string, t0, t1 time.Time) []FundingRate {
+	result := []FundingRate{}
+	switch src {
+	case klines.Source_Okx:
+		for _, f := range cachedok.GetFundingFees(instId, t0, t1, nil) {
+			result = append(result, FundingRate{Time: time.UnixMilli(f.FundingTimeStamp), Rate: f.FundingRate})
+		}
+	case klines.Source_BinanceFuture:
+		for _, f := range cachedbn.GetFundingFees(instId, t0, t1, nil) {
+			result = append(result, FundingRate{Time: time.UnixMilli(f.FundingTimeStamp), Rate: f.FundingRate})
+		}
+	}
+	return result
+}
+
+func isSwap(inst *common.Instruments) bool {
+	return inst.CtType == common.ContractType_UsdSwap || inst.CtType == common.ContractType_UsdtSwap
+}
+
+// 单个品种的资金费率流，只在回放协程中访问
+type fundingFeed struct {
+	src    klines.Source
+	instId string
+	rates  []FundingRate // 尚未结算的费率
+	last   time.Time     // 上次检查的时间
+	loaded time.Time     // 已加载到的时间
+}
+
+func newFundingFeed(src klines.Source, instId string) *fundingFeed {
+	f := new(fundingFeed)
+	f.src = src
+	f.instId = instId
+	return f
+}
+
+// 返回(last, now]之间需要结算的费率
+func (f *fundingFeed) due(now time.Time) []FundingRate {
+	if f.last.IsZero() {
+		f.last = now
+		f.loaded = now
+		return nil
+	}
+
+	if !now.After(f.last) {
+		return nil
+	}
+
+	if !now.Before(f.loaded) {
+		t1 := now.AddDate(0, 0, 30)
+		for _, r := range LoadFundingRates(f.src, f.instId, f.loaded, t1) {
+			if r.Time.After(f.last) {
+				f.rates = append(f.rates, r)
+			}
+		}
+		logger.LogInfo(logPrefix, "funding rates of %s loaded until %s, %d pending", f.instId, t1.Format(time.DateTime), len(f.rates))
+		f.loaded = t1
+	}
+
+	n := 0
+	for n < len(f.rates) && !f.rates[n].Time.After(now) {
+		n++
+	}
+
+	result := f.rates[:n]
+	f.rates = f.rates[n:]
+	f.last = now
+	return result
+}
+
+// #region 资金费记录
+// 需在持有锁的情况下调用
+func (a *Account) recordFunding(p FundingPayment) {
+	a.fundings[p.InstId] = append(a.fundings[p.InstId], p)
+	t := a.tally(p.InstId, p.Ccy)
+	t.funding = t.funding.Add(p.Amount)
+}
+
+// [t0, t1)区间内的资金费记录
+func (a *Account) FundingHistory(instId string, t0, t1 time.Time) []FundingPayment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := []FundingPayment{}
+	for _, p := range a.fundings[instId] {
+		if !p.Time.Before(t0) && p.Time.Before(t1) {
+			result = append(result, p)
+		}
+	}
+	return slices.Clip(result)
+}
+
+// #endregion
@@ -0,0 +1,225 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 13:52:09
+- @Description: 模拟合约交易器，实现common.FutureTrader。单向持仓，保证金为仓位价值/杠杆，默认只使用保证金币种的余额，全仓模式见portfolio.go
+- 未实现盈亏按行情的标记价格计算，计入保证金币种的权益。设置了资金费率数据源的永续合约按历史费率结算资金费(见funding.go)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/data/klines"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type SimFutureTrader struct {
+	simTrader
+	futureMarket common.FutureMarket
+	lever        int
+	settle       *balance
+	funding      *fundingFeed
+}
+
+// m可以是回放行情，也可以是交易所的实时行情。inst需已加入instrumentMgr。lever为0时使用品种的最大杠杆
+func NewFutureTrader(exName string, acc *Account, m common.FutureMarket, inst *common.Instruments, instrumentMgr *common.InstrumentMgr, lever int) *SimFutureTrader {
+	t := new(SimFutureTrader)
+	t.futureMarket = m
+	t.lever = lever
+	if t.lever <= 0 {
+		t.lever = max(inst.Lever, 1)
+	}
+
+	acc.mu.Lock()
+	t.settle = acc.balance(m.SettlementCurrency())
+	t.pos = acc.position(inst, t.lever, m.MarkPrice)
+	acc.mu.Unlock()
+	t.ccy = m.SettlementCurrency()
+	t.fnFreeze = t.freeze
+	t.fnValue = t.pos.value
+	t.fnFill = t.onFill
+	t.fnTick = t.onTick
+	t.init(t, exName, acc, m, inst, instrumentMgr)
+	return t
+}
+
+func (t *SimFutureTrader) freeze(o *SimOrder, remain decimal.Decimal) (*balance, decimal.Decimal) {
+	if o.ReduceOnly {
+		return t.settle, decimal.Zero
+	}
+	return t.settle, t.pos.value(o.Price, remain).Div(decimal.NewFromInt(int64(t.lever)))
+}
+
+func (t *SimFutureTrader) onFill(o *SimOrder, px, sz, fee decimal.Decimal) decimal.Decimal {
+	realized := t.pos.onFill(o.Dir, px, sz)
+	t.settle.cash = t.settle.cash.Add(realized).Sub(fee)
+	return realized
+}
+
+// 按src中instId的历史资金费率结算资金费，需在回放开始前调用。非永续合约忽略
+func (t *SimFutureTrader) SetFundingSource(src klines.Source, instId string) {
+	if isSwap(t.inst) {
+		t.funding = newFundingFeed(src, instId)
+	}
+}
+
+func (t *SimFutureTrader) onTick() {
+	if t.funding == nil {
+		return
+	}
+
+	rates := t.funding.due(t.acc.Now())
+	if len(rates) == 0 {
+		return
+	}
+
+	markPx := t.futureMarket.MarkPrice()
+	payments := []FundingPayment{}
+	t.acc.mu.Lock()
+	for _, r := range rates {
+		if t.pos.net.IsZero() || !markPx.IsPositive() {
+			continue
+		}
+
+		amount := t.pos.value(markPx, t.pos.net.Abs()).Mul(r.Rate)
+		amount = util.ValueIf(t.pos.net.IsPositive(), amount.Neg(), amount)
+		t.settle.cash = t.settle.cash.Add(amount)
+		p := FundingPayment{
+			InstId:    t.inst.Id,
+			Time:      r.Time,
+			Rate:      r.Rate,
+			MarkPrice: markPx,
+			Position:  t.pos.net,
+			Amount:    amount,
+			Ccy:       t.settle.ccy,
+		}
+		t.acc.recordFunding(p)
+		payments = append(payments, p)
+		logger.LogInfo(t.logPrefix, "funding settled, time=%s, rate=%v, position=%v, amount=%v", r.Time.Format(time.DateTime), r.Rate, t.pos.net, amount)
+	}
+	t.acc.mu.Unlock()
+
+	for _, p := range payments {
+		t.acc.bus.PublishFundingSettled(t.exName, p.InstId, p.Ccy, p.Rate, p.Amount, p.Time)
+	}
+}
+
+func (t *SimFutureTrader) unrealized() decimal.Decimal {
+	t.acc.mu.Lock()
+	defer t.acc.mu.Unlock()
+	return t.pos.upl()
+}
+
+// 仓位按标记价格的价值(以保证金币种计)，空仓为负
+func (t *SimFutureTrader) exposure() decimal.Decimal {
+	markPx := t.futureMarket.MarkPrice()
+	t.acc.mu.Lock()
+	defer t.acc.mu.Unlock()
+	v := t.pos.value(markPx, t.pos.net.Abs())
+	return util.ValueIf(t.pos.net.IsNegative(), v.Neg(), v)
+}
+
+// #region 实现common.FutureTrader
+func (t *SimFutureTrader) FutureMarket() common.FutureMarket {
+	return t.futureMarket
+}
+
+func (t *SimFutureTrader) String() string {
+	bb := bytes.Buffer{}
+	bb.WriteString(t.market.String())
+	bb.WriteString(fmt.Sprintf("\nsim future trader:%s, lever=%d\n", t.inst.Id, t.lever))
+	bb.WriteString(fmt.Sprintf("balance(%s): %v/%v\n", t.settle.Ccy(), t.settle.Available(), t.settle.Rights()))
+	bb.WriteString(fmt.Sprintf("position: net=%v, avgPx=%v\n", t.pos.Net(), decimal.Max(t.pos.LongAvgPx(), t.pos.ShortAvgPx())))
+
+	orders := t.Orders()
+	bb.WriteString(fmt.Sprintf("%d alive orders:\n", len(orders)))
+	for _, o := range orders {
+		bb.WriteString(o.String())
+	}
+	return bb.String()
+}
+
+// 有反向仓位时只返回可平仓数量
+func (t *SimFutureTrader) AvailableAmount(dir common.OrderDir, price decimal.Decimal) decimal.Decimal {
+	net := t.pos.Net()
+	if dir == common.OrderDir_Buy && net.IsNegative() {
+		return net.Neg()
+	} else if dir == common.OrderDir_Sell && net.IsPositive() {
+		return net
+	}
+
+	unit := t.pos.value(price, decimal.NewFromInt(1))
+	if !unit.IsPositive() {
+		return decimal.Zero
+	}
+	return t.market.AlignSize(common.AvailableOpenValue(t.settle.Available(), t.lever, t.FeeTaker()).Div(unit))
+}
+
+func (t *SimFutureTrader) Lever() int {
+	return t.lever
+}
+
+func (t *SimFutureTrader) Balance() common.Balance {
+	return t.settle
+}
+
+func (t *SimFutureTrader) AssetId() int {
+	return AssetId_Backtest
+}
+
+func (t *SimFutureTrader) Position() common.Position {
+	return t.pos
+}
+
+// 维持保证金按PortfolioConfig.MaintMarginRate计算，未设置时保证金率为0。全仓模式为账户级(以ValueCcy计)
+// 非全仓模式下，强平价格以结算币种权益(扣除本仓位盈亏)作为保证金估算
+func (t *SimFutureTrader) MarginInfo() common.MarginInfo {
+	mi := common.MarginInfo{MarkPrice: t.futureMarket.MarkPrice(), Time: t.acc.Now()}
+	t.acc.mu.Lock()
+	defer t.acc.mu.Unlock()
+	if t.acc.crossMargin() {
+		mi.Equity, mi.MaintMargin = t.acc.marginStat()
+	} else {
+		upl, _ := t.settle.positionStat()
+		mi.Equity = t.settle.cash.Add(upl)
+		for _, p := range t.acc.positions {
+			if p.settleCcy == t.settle.ccy && !p.net.IsZero() {
+				mi.MaintMargin = mi.MaintMargin.Add(p.value(p.fnMarkPrice(), p.net.Abs()).Mul(t.acc.portfolio.MaintMarginRate))
+			}
+		}
+	}
+	mi.MarginRatio = common.MarginRatioOf(mi.Equity, mi.MaintMargin)
+	if !t.acc.crossMargin() && !t.pos.net.IsZero() {
+		dir := util.ValueIf(t.pos.net.IsPositive(), common.OrderDir_Buy, common.OrderDir_Sell)
+		if margin := mi.Equity.Sub(t.pos.upl()); margin.IsPositive() {
+			mi.LiqPrice = t.EstimateLiqPrice(dir, t.pos.net.Abs(), t.pos.avgPx, margin)
+		}
+	}
+	return mi
+}
+
+// 回测只有一档，维持保证金率为PortfolioConfig.MaintMarginRate
+func (t *SimFutureTrader) MarginTiers() common.MarginTiers {
+	return common.MarginTiers{{Tier: 1, MaintMarginRate: t.acc.portfolio.MaintMarginRate, MaxLever: t.lever}}
+}
+
+func (t *SimFutureTrader) EstimateLiqPrice(dir common.OrderDir, size, entryPx, margin decimal.Decimal) decimal.Decimal {
+	return common.EstimateLiqPrice(common.LiqParams{
+		Dir:        dir,
+		Size:       size,
+		EntryPrice: entryPx,
+		Margin:     margin,
+		Lever:      t.lever,
+		CtVal:      t.pos.ctVal,
+		Inverse:    t.pos.inverse,
+		Tiers:      t.MarginTiers(),
+	})
+}
+
+// #endregion
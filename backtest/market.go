@@ -0,0 +1,107 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 14:31:50
+- @Description: 回测用的现货/合约行情，在回放行情(replay.Market)的基础上补充品种信息
+- 录制文件中没有标记价格、指数价格、资金费率和持仓量，标记价格和指数价格用中间价代替，其余为零值
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/data/replay"
+	"github.com/shopspring/decimal"
+)
+
+// 中间价，盘口为空时使用最新成交价
+func midPrice(m *replay.Market) decimal.Decimal {
+	if ob := m.OrderBook(); !ob.Empty() {
+		return ob.MiddlePrice()
+	}
+	return m.LatestPrice()
+}
+
+type SimSpotMarket struct {
+	*replay.Market
+	inst *common.Instruments
+}
+
+// #region 实现common.SpotMarket
+func (m *SimSpotMarket) BaseCurrency() string {
+	return m.inst.BaseCcy
+}
+
+func (m *SimSpotMarket) QuoteCurrency() string {
+	return m.inst.QuoteCcy
+}
+
+// #endregion
+
+type SimFutureMarket struct {
+	*replay.Market
+	inst *common.Instruments
+}
+
+// #region 实现common.FutureMarket
+func (m *SimFutureMarket) Symbol() string {
+	return strings.ToLower(m.inst.CtSymbol)
+}
+
+func (m *SimFutureMarket) ContractType() string {
+	return string(m.inst.CtType)
+}
+
+func (m *SimFutureMarket) IsUsdtContract() bool {
+	return m.inst.IsUsdtContract
+}
+
+func (m *SimFutureMarket) MarkPrice() decimal.Decimal {
+	return midPrice(m.Market)
+}
+
+func (m *SimFutureMarket) IndexPrice() decimal.Decimal {
+	return m.MarkPrice()
+}
+
+func (m *SimFutureMarket) ValueAmount() decimal.Decimal {
+	return m.inst.CtVal
+}
+
+func (m *SimFutureMarket) ValueCurrency() string {
+	return m.inst.CtValCcy
+}
+
+func (m *SimFutureMarket) SettlementCurrency() string {
+	return m.inst.CtSettleCcy
+}
+
+func (m *SimFutureMarket) FundingInfo() (decimal.Decimal, decimal.Decimal, time.Time, time.Time) {
+	return decimal.Zero, decimal.Zero, time.Time{}, time.Time{}
+}
+
+func (m *SimFutureMarket) FundingDetail() common.FundingDetail {
+	return common.FundingDetail{}
+}
+
+func (m *SimFutureMarket) FundingForecast() common.FundingForecast {
+	return common.FundingForecast{}
+}
+
+func (m *SimFutureMarket) OpenInterest() common.OpenInterest {
+	return common.OpenInterest{}
+}
+
+func (m *SimFutureMarket) OpenInterestHistory(t0 time.Time) []common.OpenInterest {
+	return nil
+}
+
+func (m *SimFutureMarket) AddLiquidationObserver(o common.LiquidationObserver) {
+}
+
+func (m *SimFutureMarket) RemoveLiquidationObserver(o common.LiquidationObserver) {
+}
+
+// #endregion
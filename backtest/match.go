@@ -0,0 +1,51 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-09 14:18:05
+- @Description: 撮合模型配置。模拟下单/撤单确认延迟、挂单排队位置和挂单成交概率
+- 延迟：下单后经过AckLatency才进入撮合，撤单后经过CancelLatency才生效，期间订单仍可能成交(撤单与成交的竞争)
+- 排队：挂单时排在同价位已有挂单之后，同价位的成交先消耗前方队列，队列的减少按深度变化估算(前方撤单视为已离开队列)
+- 延迟在行情事件(深度/逐笔成交)到达时检查，精度取决于行情频率
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"math/rand"
+	"time"
+)
+
+type MatchConfig struct {
+	AckLatency    time.Duration `json:"ack_latency"`     // 下单确认延迟
+	CancelLatency time.Duration `json:"cancel_latency"`  // 撤单生效延迟
+	QueueModel    bool          `json:"queue_model"`     // 是否模拟排队位置。不模拟时，成交价等于挂单价不成交
+	MakerFillProb float64       `json:"maker_fill_prob"` // 满足条件时挂单实际成交的概率，用于模拟其他参与者的竞争。0表示总是成交
+	Seed          int64         `json:"seed"`            // 随机数种子，相同种子的回测结果可复现
+}
+
+// 各交易所的典型配置(按大陆以外的云服务器估算)
+var VenueMatchConfigs = map[string]MatchConfig{
+	"okx":     {AckLatency: time.Millisecond * 15, CancelLatency: time.Millisecond * 15, QueueModel: true},
+	"binance": {AckLatency: time.Millisecond * 10, CancelLatency: time.Millisecond * 10, QueueModel: true},
+}
+
+// 某个交易所的撮合配置，未知交易所返回零值(无延迟、不排队)
+func VenueMatchConfig(venue string) MatchConfig {
+	return VenueMatchConfigs[venue]
+}
+
+// 设置撮合模型，需在创建交易器之前调用
+func (a *Account) SetMatchConfig(cfg MatchConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.match = cfg
+	a.rnd = rand.New(rand.NewSource(cfg.Seed))
+}
+
+// 挂单是否成交，需在持有锁的情况下调用
+func (a *Account) makerFill() bool {
+	p := a.match.MakerFillProb
+	if p <= 0 || p >= 1 {
+		return true
+	}
+	return a.rnd.Float64() < p
+}
@@ -0,0 +1,175 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-17 14:20:36
+- @Description: 蒙特卡洛稳健性分析。将回测的逐笔交易盈亏重新排列(shuffle，打乱顺序)或有放回抽样(bootstrap)，
+- 生成大量可能的权益路径，统计最大回撤、最终收益的分布和破产概率，用于评估仓位大小
+- 一笔交易为一次平仓成交(产生已实现盈亏)，盈亏为已实现盈亏减去该品种自上次平仓以来的所有手续费，以ValueCcy计
+- 资金费和未平仓的浮动盈亏不计入
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+)
+
+const (
+	MonteCarlo_Shuffle   = "shuffle"
+	MonteCarlo_Bootstrap = "bootstrap"
+)
+
+type MonteCarloConfig struct {
+	Runs      int     `json:"runs"`       // 模拟次数，默认1000
+	Method    string  `json:"method"`     // shuffle/bootstrap，默认shuffle
+	Seed      int64   `json:"seed"`       // 随机种子，相同种子结果可复现
+	RuinLevel float64 `json:"ruin_level"` // 权益低于初始权益的该比例视为破产，默认0.5
+}
+
+// 分布的统计值
+type Distribution struct {
+	Mean  float64 `json:"mean"`
+	P5    float64 `json:"p5"`
+	P25   float64 `json:"p25"`
+	P50   float64 `json:"p50"`
+	P75   float64 `json:"p75"`
+	P95   float64 `json:"p95"`
+	Worst float64 `json:"worst"` // 对回撤为最大值，对收益为最小值
+}
+
+type MonteCarloResult struct {
+	Method           string       `json:"method"`
+	Runs             int          `json:"runs"`
+	Trades           int          `json:"trades"`
+	Scale            float64      `json:"scale"`              // 仓位倍数，交易盈亏乘以该倍数
+	MaxDrawdown      Distribution `json:"max_drawdown"`       // 最大回撤比例
+	MaxDrawdownValue Distribution `json:"max_drawdown_value"` // 最大回撤金额
+	FinalReturn      Distribution `json:"final_return"`       // 总收益率
+	RuinProbability  float64      `json:"ruin_probability"`
+}
+
+// 按时间排序的逐笔交易盈亏，以ValueCcy计
+func (r *Reporter) Trades() []float64 {
+	type trade struct {
+		t   time.Time
+		pnl float64
+	}
+
+	trades := []trade{}
+	for _, fills := range r.e.acc.Fills() {
+		fee := 0.0
+		for _, f := range fills {
+			fee += f.Fee.InexactFloat64()
+			if f.Realized.IsZero() {
+				continue
+			}
+
+			trades = append(trades, trade{t: f.Time, pnl: (f.Realized.InexactFloat64() - fee) * r.price(f.Ccy)})
+			fee = 0
+		}
+	}
+
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].t.Before(trades[j].t) })
+	result := make([]float64, len(trades))
+	for i, t := range trades {
+		result[i] = t.pnl
+	}
+	return result
+}
+
+func distributionOf(vs []float64, worstIsMax bool) Distribution {
+	if len(vs) == 0 {
+		return Distribution{}
+	}
+
+	sorted := slices.Clone(vs)
+	slices.Sort(sorted)
+	pct := func(p float64) float64 {
+		return sorted[int(math.Round(p*float64(len(sorted)-1)))]
+	}
+
+	d := Distribution{P5: pct(0.05), P25: pct(0.25), P50: pct(0.5), P75: pct(0.75), P95: pct(0.95)}
+	for _, v := range sorted {
+		d.Mean += v
+	}
+	d.Mean /= float64(len(sorted))
+	d.Worst = util.ValueIf(worstIsMax, sorted[len(sorted)-1], sorted[0])
+	return d
+}
+
+// 对trades做蒙特卡洛模拟，initialEquity为初始权益(通常为Report.InitialEquity)
+func MonteCarlo(trades []float64, initialEquity float64, cfg MonteCarloConfig) *MonteCarloResult {
+	return monteCarlo(trades, initialEquity, cfg, 1)
+}
+
+// 以不同的仓位倍数分别模拟，用于选择破产概率可接受的最大仓位
+func MonteCarloScan(trades []float64, initialEquity float64, cfg MonteCarloConfig, scales []float64) []*MonteCarloResult {
+	result := make([]*MonteCarloResult, 0, len(scales))
+	for _, s := range scales {
+		result = append(result, monteCarlo(trades, initialEquity, cfg, s))
+	}
+	return result
+}
+
+func monteCarlo(trades []float64, initialEquity float64, cfg MonteCarloConfig, scale float64) *MonteCarloResult {
+	cfg.Runs = util.ValueIf(cfg.Runs > 0, cfg.Runs, 1000)
+	cfg.Method = util.ValueIf(cfg.Method == MonteCarlo_Bootstrap, MonteCarlo_Bootstrap, MonteCarlo_Shuffle)
+	cfg.RuinLevel = util.ValueIf(cfg.RuinLevel > 0, cfg.RuinLevel, 0.5)
+
+	mr := &MonteCarloResult{Method: cfg.Method, Runs: cfg.Runs, Trades: len(trades), Scale: scale}
+	if len(trades) == 0 || initialEquity <= 0 {
+		return mr
+	}
+
+	rnd := rand.New(rand.NewSource(cfg.Seed))
+	path := make([]float64, len(trades))
+	dds := make([]float64, 0, cfg.Runs)
+	ddValues := make([]float64, 0, cfg.Runs)
+	rets := make([]float64, 0, cfg.Runs)
+	ruined := 0
+	ruinEquity := initialEquity * cfg.RuinLevel
+
+	for run := 0; run < cfg.Runs; run++ {
+		if cfg.Method == MonteCarlo_Bootstrap {
+			for i := range path {
+				path[i] = trades[rnd.Intn(len(trades))]
+			}
+		} else {
+			copy(path, trades)
+			rnd.Shuffle(len(path), func(i, j int) { path[i], path[j] = path[j], path[i] })
+		}
+
+		equity, peak, dd, ddValue, ruin := initialEquity, initialEquity, 0.0, 0.0, false
+		for _, pnl := range path {
+			equity += pnl * scale
+			peak = math.Max(peak, equity)
+			ddValue = math.Max(ddValue, peak-equity)
+			dd = math.Max(dd, (peak-equity)/peak)
+			ruin = ruin || equity <= ruinEquity
+		}
+
+		dds = append(dds, dd)
+		ddValues = append(ddValues, ddValue)
+		rets = append(rets, equity/initialEquity-1)
+		ruined += util.ValueIf(ruin, 1, 0)
+	}
+
+	mr.MaxDrawdown = distributionOf(dds, true)
+	mr.MaxDrawdownValue = distributionOf(ddValues, true)
+	mr.FinalReturn = distributionOf(rets, false)
+	mr.RuinProbability = float64(ruined) / float64(cfg.Runs)
+	return mr
+}
+
+func (mr *MonteCarloResult) String() string {
+	return fmt.Sprintf("%s x%d, %d trades, scale=%.2f: maxDrawdown p50=%.2f%% p95=%.2f%% worst=%.2f%%, return p5=%.2f%% p50=%.2f%%, ruin=%.2f%%",
+		mr.Method, mr.Runs, mr.Trades, mr.Scale,
+		mr.MaxDrawdown.P50*100, mr.MaxDrawdown.P95*100, mr.MaxDrawdown.Worst*100,
+		mr.FinalReturn.P5*100, mr.FinalReturn.P50*100, mr.RuinProbability*100)
+}
@@ -0,0 +1,343 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-11 16:05:27
+- @Description: 参数优化。在参数网格上并行运行回测，按评分排序；支持滚动(walk-forward)样本内寻优、样本外验证
+- 回测本身由fnRun完成(通常是用参数和时间区间构造SimExchange和策略，运行完毕后返回Reporter.Build的结果)，各次回测之间不能共享状态
+- 配置了ResultPath时，每完成一次回测追加写入一行json。重启后相同参数、相同区间的回测直接读取结果，不再运行
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const optimizerLogPrefix = "optimizer"
+
+// #region 参数
+// 一组策略参数。从结果文件读取时数字均为float64，取值时请使用Float/Int等函数
+type Params map[string]interface{}
+
+// 参数的唯一标识，json序列化时key有序
+func (p Params) Key() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+func (p Params) Float(name string) float64 {
+	switch v := p[name].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		return 0
+	}
+}
+
+func (p Params) Int(name string) int {
+	return int(p.Float(name))
+}
+
+func (p Params) String(name string) string {
+	s, _ := p[name].(string)
+	return s
+}
+
+func (p Params) Bool(name string) bool {
+	b, _ := p[name].(bool)
+	return b
+}
+
+// 参数网格，参数名-候选值
+type ParamGrid map[string][]interface{}
+
+// 所有参数组合(笛卡尔积)，按参数名排序后展开，结果顺序固定
+func (g ParamGrid) Combinations() []Params {
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []Params{{}}
+	for _, name := range names {
+		next := make([]Params, 0, len(result)*len(g[name]))
+		for _, p := range result {
+			for _, v := range g[name] {
+				np := make(Params, len(p)+1)
+				for k, pv := range p {
+					np[k] = pv
+				}
+				np[name] = v
+				next = append(next, np)
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// #endregion
+
+// #region 滚动窗口
+type Split struct {
+	TrainT0 time.Time `json:"train_t0"`
+	TrainT1 time.Time `json:"train_t1"`
+	TestT0  time.Time `json:"test_t0"`
+	TestT1  time.Time `json:"test_t1"`
+}
+
+// 将[t0, t1)切分为若干个样本内train、样本外test的窗口，每次向后滚动test。anchored为true时样本内起点固定为t0
+func WalkForwardSplits(t0, t1 time.Time, train, test time.Duration, anchored bool) []Split {
+	result := []Split{}
+	if train <= 0 || test <= 0 {
+		return result
+	}
+
+	for start := t0; !start.Add(train + test).After(t1); start = start.Add(test) {
+		s := Split{TrainT0: util.ValueIf(anchored, t0, start), TrainT1: start.Add(train)}
+		s.TestT0 = s.TrainT1
+		s.TestT1 = s.TestT0.Add(test)
+		result = append(result, s)
+	}
+	return result
+}
+
+// #endregion
+
+type OptimizerConfig struct {
+	Workers    int    `json:"workers"`     // 并行回测数，默认为CPU核数
+	ResultPath string `json:"result_path"` // 结果文件，为空时不保存
+	KeepEquity bool   `json:"keep_equity"` // 结果中是否保留权益曲线，默认不保留以减小文件
+}
+
+type RunResult struct {
+	Params Params    `json:"params"`
+	T0     time.Time `json:"t0"`
+	T1     time.Time `json:"t1"`
+	Score  float64   `json:"score"`
+	Report *Report   `json:"report"`
+	Err    string    `json:"err"`
+}
+
+func (r RunResult) key() string {
+	return runKey(r.Params, r.T0, r.T1)
+}
+
+func runKey(p Params, t0, t1 time.Time) string {
+	return fmt.Sprintf("%s|%d|%d", p.Key(), t0.Unix(), t1.Unix())
+}
+
+type WalkForwardResult struct {
+	Split Split     `json:"split"`
+	Best  RunResult `json:"best"` // 样本内评分最高的参数
+	Test  RunResult `json:"test"` // 该参数的样本外结果
+}
+
+type Optimizer struct {
+	cfg     OptimizerConfig
+	fnRun   func(p Params, t0, t1 time.Time) (*Report, error)
+	fnScore func(rp *Report) float64
+
+	mu   sync.Mutex
+	done map[string]RunResult
+	file *os.File
+}
+
+// fnRun会在多个协程中同时调用
+func NewOptimizer(cfg OptimizerConfig, fnRun func(p Params, t0, t1 time.Time) (*Report, error)) *Optimizer {
+	o := new(Optimizer)
+	o.cfg = cfg
+	o.cfg.Workers = util.ValueIf(cfg.Workers > 0, cfg.Workers, runtime.NumCPU())
+	o.fnRun = fnRun
+	o.fnScore = func(rp *Report) float64 { return rp.Sharpe }
+	o.done = make(map[string]RunResult)
+	o.load()
+	return o
+}
+
+// 评分函数，越大越好，默认为夏普比率。修改评分函数不影响已保存的结果，排序时重新评分
+func (o *Optimizer) SetScoreFn(fn func(rp *Report) float64) {
+	o.fnScore = fn
+}
+
+// 加载已完成的结果，并以追加方式打开结果文件
+func (o *Optimizer) load() {
+	if len(o.cfg.ResultPath) == 0 {
+		return
+	}
+
+	if f, err := os.Open(o.cfg.ResultPath); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+		for scanner.Scan() {
+			r := RunResult{}
+			if err := json.Unmarshal(scanner.Bytes(), &r); err == nil && len(r.Err) == 0 {
+				o.done[r.key()] = r
+			}
+		}
+		f.Close()
+		logger.LogImportant(optimizerLogPrefix, "%d results loaded from %s", len(o.done), o.cfg.ResultPath)
+	}
+
+	util.MakeSureDirForFile(o.cfg.ResultPath)
+	if f, err := os.OpenFile(o.cfg.ResultPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666); err == nil {
+		o.file = f
+	} else {
+		logger.LogImportant(optimizerLogPrefix, "open result file failed: %s", err.Error())
+	}
+}
+
+func (o *Optimizer) save(r RunResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(r.Err) == 0 {
+		o.done[r.key()] = r
+	}
+
+	if o.file != nil {
+		if b, err := json.Marshal(r); err == nil {
+			o.file.Write(append(b, '\n'))
+		}
+	}
+}
+
+func (o *Optimizer) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+}
+
+// 运行一次回测，panic视为失败
+func (o *Optimizer) run(p Params, t0, t1 time.Time) (r RunResult) {
+	r = RunResult{Params: p, T0: t0, T1: t1}
+	o.mu.Lock()
+	prev, ok := o.done[r.key()]
+	o.mu.Unlock()
+	if ok {
+		return prev
+	}
+
+	defer func() {
+		o.save(r)
+	}()
+	defer util.DefaultRecoverWithCallback(func(err string) {
+		r.Err = err
+	})
+
+	rp, err := o.fnRun(p, t0, t1)
+	if err != nil {
+		r.Err = err.Error()
+	} else if rp == nil {
+		r.Err = "empty report"
+	} else {
+		if !o.cfg.KeepEquity {
+			rp.Equity = nil
+		}
+		r.Report = rp
+	}
+	return
+}
+
+// 在[t0, t1)上运行所有参数组合，按评分由高到低返回。失败的回测排在最后
+func (o *Optimizer) Sweep(grid ParamGrid, t0, t1 time.Time) []RunResult {
+	combs := grid.Combinations()
+	results := make([]RunResult, len(combs))
+	chJob := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < o.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range chJob {
+				results[n] = o.run(combs[n], t0, t1)
+			}
+		}()
+	}
+
+	tStart := time.Now()
+	for n := range combs {
+		chJob <- n
+		if (n+1)%o.cfg.Workers == 0 {
+			logger.LogInfo(optimizerLogPrefix, "sweep %s~%s: %d/%d dispatched", t0.Format(time.DateOnly), t1.Format(time.DateOnly), n+1, len(combs))
+		}
+	}
+	close(chJob)
+	wg.Wait()
+
+	failed := 0
+	for i := range results {
+		if results[i].Report != nil {
+			results[i].Score = o.fnScore(results[i].Report)
+		} else {
+			failed++
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b RunResult) int {
+		if (a.Report == nil) != (b.Report == nil) {
+			return util.ValueIf(a.Report == nil, 1, -1)
+		}
+		return util.ValueIf(a.Score > b.Score, -1, util.ValueIf(a.Score < b.Score, 1, 0))
+	})
+
+	logger.LogImportant(optimizerLogPrefix, "sweep %s~%s finished, %d combinations, %d failed, cost %v", t0.Format(time.DateOnly), t1.Format(time.DateOnly), len(combs), failed, time.Since(tStart))
+	return results
+}
+
+// 对每个窗口先在样本内寻优，再用最优参数运行样本外回测
+func (o *Optimizer) WalkForward(grid ParamGrid, splits []Split) []WalkForwardResult {
+	result := []WalkForwardResult{}
+	for _, s := range splits {
+		sweep := o.Sweep(grid, s.TrainT0, s.TrainT1)
+		if len(sweep) == 0 || sweep[0].Report == nil {
+			logger.LogImportant(optimizerLogPrefix, "no valid result in train window %s~%s", s.TrainT0.Format(time.DateOnly), s.TrainT1.Format(time.DateOnly))
+			continue
+		}
+
+		wf := WalkForwardResult{Split: s, Best: sweep[0]}
+		wf.Test = o.run(wf.Best.Params, s.TestT0, s.TestT1)
+		if wf.Test.Report != nil {
+			wf.Test.Score = o.fnScore(wf.Test.Report)
+		}
+		result = append(result, wf)
+
+		logger.LogImportant(optimizerLogPrefix, "walk forward %s~%s, best params=%s, train score=%.4f, test score=%.4f",
+			s.TestT0.Format(time.DateOnly), s.TestT1.Format(time.DateOnly), wf.Best.Params.Key(), wf.Best.Score, wf.Test.Score)
+	}
+	return result
+}
+
+// 样本外结果汇总，便于评估参数稳定性
+func WalkForwardSummary(results []WalkForwardResult) string {
+	sb := strings.Builder{}
+	for _, r := range results {
+		ret := 0.0
+		if r.Test.Report != nil {
+			ret = r.Test.Report.TotalReturn
+		}
+		sb.WriteString(fmt.Sprintf("%s~%s params=%s train=%.4f test=%.4f return=%.2f%%\n",
+			r.Split.TestT0.Format(time.DateOnly), r.Split.TestT1.Format(time.DateOnly), r.Best.Params.Key(), r.Best.Score, r.Test.Score, ret*100))
+	}
+	return sb.String()
+}
@@ -0,0 +1,46 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 11:41:26
+- @Description: 模拟订单，实现common.Order。撤单按MatchConfig延迟生效，改单立即生效
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/shopspring/decimal"
+)
+
+type SimOrder struct {
+	common.OrderImpl
+	trader      *simTrader
+	finishing   bool            // 已完结，等待回调结束后再置Finished
+	frozenBal   *balance        // 冻结的币种
+	frozen      decimal.Decimal // 冻结数量
+	quoteFilled decimal.Decimal // 已成交的计价币数量
+	acked       bool            // 交易所已确认，开始撮合
+	ackTime     time.Time       // 预计确认时间
+	cancelTime  time.Time       // 撤单生效时间，零值表示未撤单
+	queueAhead  decimal.Decimal // 同价位排在前面的数量
+}
+
+// #region 实现common.Order
+func (o *SimOrder) GetExchangeName() string {
+	return o.trader.exName
+}
+
+func (o *SimOrder) IsSupportModify() bool {
+	return true
+}
+
+func (o *SimOrder) Modify(price, size decimal.Decimal) {
+	o.trader.modify(o, price, size)
+}
+
+func (o *SimOrder) Cancel() {
+	o.trader.cancel(o)
+}
+
+// #endregion
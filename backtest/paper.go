@@ -0,0 +1,26 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-09 09:40:21
+- @Description: 模拟交易(paper trading)配置。交易所开启后照常订阅实时行情，但订单由本地的模拟交易器撮合，使用虚拟余额
+- 用于新策略上线前的验证，撮合规则与回测一致(见trader.go)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import "github.com/shopspring/decimal"
+
+type PaperConfig struct {
+	Balances map[string]decimal.Decimal `json:"balances"`  // 虚拟初始余额，币种名与交易所品种信息一致(小写)
+	FeeMaker decimal.Decimal            `json:"fee_maker"` //
+	FeeTaker decimal.Decimal            `json:"fee_taker"` //
+	Match    MatchConfig                `json:"match"`     // 撮合模型，延迟为在真实延迟之外额外增加的部分
+	Cost     CostConfig                 `json:"cost"`      // 手续费等级和滑点，与回测一致
+}
+
+// 模拟账户，时钟为本地时间
+func (c PaperConfig) NewAccount() *Account {
+	acc := NewAccount(c.Balances, c.FeeMaker, c.FeeTaker, nil)
+	acc.SetMatchConfig(c.Match)
+	acc.SetCostConfig(c.Cost)
+	return acc
+}
@@ -0,0 +1,307 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-16 15:32:08
+- @Description: 组合回测。一个策略同时交易多个品种时，所有交易器共用一个账户(余额)，可选全仓模式：
+- 1. 全仓模式下，每个币种的可用余额为账户总可用权益(各币种按ValueCcy折算后合并)再折算回该币种，一个品种的亏损会占用其他品种的保证金
+- 2. 设置了维持保证金率时，账户权益低于维持保证金则撤销所有挂单，按标记价格强平所有合约仓位
+- 组合指标(品种间相关性、相关性调整后的回撤、分散度、敞口)见Reporter.Build中的Portfolio部分
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type PortfolioConfig struct {
+	CrossMargin     bool            `json:"cross_margin"`      // 全仓模式
+	ValueCcy        string          `json:"value_ccy"`         // 全仓模式下的折算币种，如usdt
+	MaintMarginRate decimal.Decimal `json:"maint_margin_rate"` // 维持保证金率(相对仓位价值)，0表示不强平
+}
+
+// 单个品种的累计盈亏(不含浮动盈亏)，以成交的Ccy计
+type pnlTally struct {
+	ccy      string
+	realized decimal.Decimal
+	fee      decimal.Decimal
+	funding  decimal.Decimal
+}
+
+// 设置组合模式，fnPrice返回某币种以cfg.ValueCcy计的价格，找不到时返回0
+func (a *Account) SetPortfolio(cfg PortfolioConfig, fnPrice func(ccy string) decimal.Decimal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.portfolio = cfg
+	a.fnPrice = fnPrice
+}
+
+// 强平发生的时间
+func (a *Account) Liquidations() []time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]time.Time{}, a.liquidations...)
+}
+
+// 某品种的累计已实现盈亏+资金费-手续费，及其币种
+func (a *Account) closedPnl(instId string) (decimal.Decimal, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.pnls[instId]; ok {
+		return t.realized.Add(t.funding).Sub(t.fee), t.ccy
+	}
+	return decimal.Zero, ""
+}
+
+// 以下函数需在持有锁的情况下调用
+func (a *Account) crossMargin() bool {
+	return a.portfolio.CrossMargin && a.fnPrice != nil
+}
+
+func (a *Account) tally(instId, ccy string) *pnlTally {
+	t, ok := a.pnls[instId]
+	if !ok {
+		t = &pnlTally{ccy: ccy}
+		a.pnls[instId] = t
+	}
+	return t
+}
+
+// 某币种以ValueCcy计的价格
+func (a *Account) price(ccy string) decimal.Decimal {
+	if strings.EqualFold(ccy, a.portfolio.ValueCcy) {
+		return decimal.NewFromInt(1)
+	}
+	return a.fnPrice(ccy)
+}
+
+// 全仓模式的总可用权益，以ValueCcy计。没有价格的币种不计入
+func (a *Account) crossAvailable() decimal.Decimal {
+	total := decimal.Zero
+	for _, b := range a.balances {
+		if px := a.price(b.ccy); px.IsPositive() {
+			total = total.Add(b.isolatedAvailable().Mul(px))
+		}
+	}
+	return total
+}
+
+// 权益和维持保证金，以ValueCcy计
+func (a *Account) marginStat() (equity, maint decimal.Decimal) {
+	for _, b := range a.balances {
+		if px := a.price(b.ccy); px.IsPositive() {
+			upl, _ := b.positionStat()
+			equity = equity.Add(b.cash.Add(upl).Mul(px))
+		}
+	}
+
+	for _, p := range a.positions {
+		if p.net.IsZero() {
+			continue
+		}
+		if px := a.price(p.settleCcy); px.IsPositive() {
+			maint = maint.Add(p.value(p.fnMarkPrice(), p.net.Abs()).Mul(px).Mul(a.portfolio.MaintMarginRate))
+		}
+	}
+	return
+}
+
+func (a *Account) shouldLiquidate() bool {
+	if !a.crossMargin() || !a.portfolio.MaintMarginRate.IsPositive() {
+		return false
+	}
+
+	equity, maint := a.marginStat()
+	return maint.IsPositive() && equity.LessThan(maint)
+}
+
+// 按标记价格平掉所有合约仓位，不收手续费
+func (a *Account) liquidate() {
+	now := a.Now()
+	equity, maint := a.marginStat()
+	logger.LogImportant(logPrefix, "liquidation at %s, equity=%v, maint margin=%v", now.Format(time.DateTime), equity, maint)
+	a.liquidations = append(a.liquidations, now)
+
+	for _, p := range a.positions {
+		if p.net.IsZero() {
+			continue
+		}
+
+		px := p.fnMarkPrice()
+		sz := p.net.Abs()
+		dir := util.ValueIf(p.net.IsPositive(), common.OrderDir_Sell, common.OrderDir_Buy)
+		realized := p.onFill(dir, px, sz)
+		settle := a.balance(p.settleCcy)
+		settle.cash = settle.cash.Add(realized)
+		a.recordFill(Fill{
+			InstId:   p.instId,
+			Time:     now,
+			Dir:      dir,
+			Price:    px,
+			Amount:   sz,
+			Value:    p.value(px, sz),
+			Realized: realized,
+			Ccy:      p.settleCcy,
+			Taker:    true,
+		})
+	}
+}
+
+// #region 强平检查
+// 观察所有合约行情，每次行情变化时检查维持保证金
+type marginGuard struct {
+	e *SimExchange
+}
+
+func (g *marginGuard) OnDepthChanged() {
+	acc := g.e.acc
+	acc.mu.Lock()
+	ok := acc.shouldLiquidate()
+	acc.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, t := range g.e.spotTraders {
+		for _, o := range t.Orders() {
+			o.Cancel()
+		}
+	}
+	for _, t := range g.e.futureTraders {
+		for _, o := range t.Orders() {
+			o.Cancel()
+		}
+	}
+
+	acc.mu.Lock()
+	if acc.shouldLiquidate() {
+		acc.liquidate()
+	}
+	acc.mu.Unlock()
+}
+
+// #endregion
+
+// #region 组合指标
+type PortfolioReport struct {
+	InstIds              []string    `json:"inst_ids"`
+	Correlation          [][]float64 `json:"correlation"`           // 各品种每个采样间隔盈亏的相关系数，顺序同InstIds
+	MaxDrawdownValue     float64     `json:"max_drawdown_value"`    // 组合的最大回撤金额
+	SumDrawdownValue     float64     `json:"sum_drawdown_value"`    // 各品种最大回撤金额之和，即各品种完全正相关时的回撤
+	CorrelatedDrawdown   float64     `json:"correlated_drawdown"`   // 按相关系数合成的回撤估计sqrt(ΣΣρij*ddi*ddj)
+	DiversificationRatio float64     `json:"diversification_ratio"` // 各品种盈亏波动之和/组合盈亏波动，越大说明分散效果越好
+	MaxGrossExposure     float64     `json:"max_gross_exposure"`    // 最大总敞口(多空绝对值之和)
+	MaxNetExposure       float64     `json:"max_net_exposure"`      // 最大净敞口(绝对值)
+	Liquidations         int         `json:"liquidations"`
+}
+
+// 最大回撤金额
+func maxDrawdownValue(curve []float64) float64 {
+	if len(curve) == 0 {
+		return 0
+	}
+
+	peak, dd := curve[0], 0.0
+	for _, v := range curve {
+		peak = math.Max(peak, v)
+		dd = math.Max(dd, peak-v)
+	}
+	return dd
+}
+
+// 相邻差分
+func diffs(curve []float64) []float64 {
+	result := make([]float64, 0, max(len(curve)-1, 0))
+	for i := 1; i < len(curve); i++ {
+		result = append(result, curve[i]-curve[i-1])
+	}
+	return result
+}
+
+func stdev(vs []float64) float64 {
+	if len(vs) < 2 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(len(vs))
+
+	variance := 0.0
+	for _, v := range vs {
+		variance += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(variance / float64(len(vs)-1))
+}
+
+// 皮尔逊相关系数，任一序列无波动时为0
+func correlation(a, b []float64) float64 {
+	n := min(len(a), len(b))
+	if n < 2 {
+		return 0
+	}
+
+	ma, mb := 0.0, 0.0
+	for i := 0; i < n; i++ {
+		ma += a[i]
+		mb += b[i]
+	}
+	ma /= float64(n)
+	mb /= float64(n)
+
+	cov, va, vb := 0.0, 0.0, 0.0
+	for i := 0; i < n; i++ {
+		cov += (a[i] - ma) * (b[i] - mb)
+		va += (a[i] - ma) * (a[i] - ma)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+
+	if va <= 0 || vb <= 0 {
+		return 0
+	}
+	return cov / math.Sqrt(va*vb)
+}
+
+// instCurves为各品种的累计盈亏曲线(以ValueCcy计，与equity采样点一一对应)
+func buildPortfolio(instIds []string, instCurves [][]float64, equity []float64) *PortfolioReport {
+	pr := &PortfolioReport{InstIds: instIds}
+	pr.MaxDrawdownValue = maxDrawdownValue(equity)
+
+	n := len(instIds)
+	changes := make([][]float64, n)
+	dds := make([]float64, n)
+	sumStd := 0.0
+	for i := range instIds {
+		changes[i] = diffs(instCurves[i])
+		dds[i] = maxDrawdownValue(instCurves[i])
+		pr.SumDrawdownValue += dds[i]
+		sumStd += stdev(changes[i])
+	}
+
+	pr.Correlation = make([][]float64, n)
+	combined := 0.0
+	for i := 0; i < n; i++ {
+		pr.Correlation[i] = make([]float64, n)
+		for j := 0; j < n; j++ {
+			rho := util.ValueIf(i == j, 1.0, correlation(changes[i], changes[j]))
+			pr.Correlation[i][j] = rho
+			combined += rho * dds[i] * dds[j]
+		}
+	}
+	pr.CorrelatedDrawdown = math.Sqrt(math.Max(combined, 0))
+
+	if portStd := stdev(diffs(equity)); portStd > 0 {
+		pr.DiversificationRatio = sumStd / portStd
+	}
+	return pr
+}
+
+// #endregion
@@ -0,0 +1,395 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-11 10:18:45
+- @Description: 回测报告。按固定间隔采样账户权益，回测结束后根据权益曲线和成交明细计算各项指标，输出为json和html
+- 权益以ValueCcy计，其他币种按回测品种的中间价换算(现货为base/ValueCcy交易对，币本位合约为保证金币种的标记价格)，找不到价格的币种不计入
+- 收益率按采样间隔计算，年化按每年365天。最大回撤为比例，持续时间为从前高到恢复(或回测结束)的时长
+- 胜率统计所有产生已实现盈亏的成交(平仓)，盈亏不含手续费
+- 多品种时同时给出组合指标(品种盈亏的相关性、相关性调整后的回撤等，见portfolio.go)
+- 用法：UseXXX -> NewReporter -> Go -> Wait -> Build -> SaveJson/SaveHtml
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type ReportConfig struct {
+	ValueCcy    string `json:"value_ccy"`    // 权益计价币种，如usdt
+	IntervalSec int    `json:"interval_sec"` // 权益采样间隔，默认3600
+}
+
+type EquityPoint struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// 单个品种的收益归因，除PnLValue外均以Ccy计
+type InstrumentReport struct {
+	InstId     string  `json:"inst_id"`
+	Ccy        string  `json:"ccy"`
+	Fills      int     `json:"fills"`
+	Volume     float64 `json:"volume"`
+	Fee        float64 `json:"fee"`
+	Realized   float64 `json:"realized"`
+	Unrealized float64 `json:"unrealized"`
+	Funding    float64 `json:"funding"`
+	PnL        float64 `json:"pnl"`       // Realized + Unrealized + Funding - Fee
+	PnLValue   float64 `json:"pnl_value"` // 以ValueCcy计，按回测结束时的价格换算
+	WinRate    float64 `json:"win_rate"`
+}
+
+type Report struct {
+	ValueCcy       string             `json:"value_ccy"`
+	Start          time.Time          `json:"start"`
+	End            time.Time          `json:"end"`
+	InitialEquity  float64            `json:"initial_equity"`
+	FinalEquity    float64            `json:"final_equity"`
+	TotalReturn    float64            `json:"total_return"`
+	AnnualReturn   float64            `json:"annual_return"`
+	Volatility     float64            `json:"volatility"` // 年化
+	Sharpe         float64            `json:"sharpe"`
+	Sortino        float64            `json:"sortino"`
+	MaxDrawdown    float64            `json:"max_drawdown"`
+	MaxDrawdownDur time.Duration      `json:"max_drawdown_dur"`
+	Volume         float64            `json:"volume"`   // 总成交额，以ValueCcy计
+	Turnover       float64            `json:"turnover"` // 总成交额/平均权益
+	Fills          int                `json:"fills"`
+	WinRate        float64            `json:"win_rate"`
+	Fee            float64            `json:"fee"`     // 以ValueCcy计
+	Funding        float64            `json:"funding"` // 以ValueCcy计
+	Instruments    []InstrumentReport `json:"instruments"`
+	Portfolio      *PortfolioReport   `json:"portfolio"` // 组合指标，见portfolio.go
+	Equity         []EquityPoint      `json:"equity"`
+}
+
+type Reporter struct {
+	e        *SimExchange
+	cfg      ReportConfig
+	interval time.Duration
+
+	mu     sync.Mutex
+	next   time.Time
+	equity []EquityPoint
+
+	// 组合指标的采样，instIds为所有交易器的品种
+	instIds  []string
+	curves   [][]float64 // 各品种的累计盈亏，与equity一一对应
+	maxGross float64
+	maxNet   float64
+}
+
+// 创建报告生成器，并观察所有已创建的行情用于采样，需在UseXXX之后、Go之前调用
+func (e *SimExchange) NewReporter(cfg ReportConfig) *Reporter {
+	r := new(Reporter)
+	r.e = e
+	r.cfg = cfg
+	r.interval = time.Duration(util.ValueIf(cfg.IntervalSec > 0, cfg.IntervalSec, 3600)) * time.Second
+	for _, m := range e.spotMarkets {
+		m.AddDepthObserver(r)
+	}
+	for _, m := range e.futureMarkets {
+		m.AddDepthObserver(r)
+	}
+
+	for id := range e.spotTraders {
+		r.instIds = append(r.instIds, id)
+	}
+	for id := range e.futureTraders {
+		r.instIds = append(r.instIds, id)
+	}
+	slices.Sort(r.instIds)
+	r.curves = make([][]float64, len(r.instIds))
+	e.reporter = r
+	return r
+}
+
+// #region 实现common.DepthObserver
+func (r *Reporter) OnDepthChanged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.e.Now()
+	if now.Before(r.next) {
+		return
+	}
+
+	r.equity = append(r.equity, EquityPoint{Time: now, Equity: r.Equity()})
+	pnls, gross, net := r.sampleInstruments()
+	for i, v := range pnls {
+		r.curves[i] = append(r.curves[i], v)
+	}
+	r.maxGross = math.Max(r.maxGross, gross)
+	r.maxNet = math.Max(r.maxNet, math.Abs(net))
+	r.next = now.Truncate(r.interval).Add(r.interval)
+}
+
+// #endregion
+
+// 某币种以ValueCcy计的价格，找不到时为0
+func (r *Reporter) price(ccy string) float64 {
+	return r.e.price(ccy, r.cfg.ValueCcy).InexactFloat64()
+}
+
+// 各品种当前的累计盈亏，及总敞口、净敞口，均以ValueCcy计
+func (r *Reporter) sampleInstruments() (pnls []float64, gross, net float64) {
+	pnls = make([]float64, len(r.instIds))
+	for i, id := range r.instIds {
+		var ccy string
+		var unrealized, exposure decimal.Decimal
+		if t, ok := r.e.futureTraders[id]; ok {
+			ccy, unrealized, exposure = t.ccy, t.unrealized(), t.exposure()
+		} else if t, ok := r.e.spotTraders[id]; ok {
+			ccy, unrealized, exposure = t.ccy, t.unrealized(), t.exposure()
+		}
+
+		closed, _ := r.e.acc.closedPnl(id)
+		px := r.price(ccy)
+		pnls[i] = closed.Add(unrealized).InexactFloat64() * px
+		gross += math.Abs(exposure.InexactFloat64() * px)
+		net += exposure.InexactFloat64() * px
+	}
+	return
+}
+
+// 当前总权益，以ValueCcy计
+func (r *Reporter) Equity() float64 {
+	total := 0.0
+	for _, b := range r.e.acc.Balances() {
+		total += b.Rights().InexactFloat64() * r.price(b.Ccy())
+	}
+	return total
+}
+
+// 根据采样结果和成交明细生成报告，需在回放结束后调用
+func (r *Reporter) Build() *Report {
+	r.mu.Lock()
+	rp := &Report{ValueCcy: r.cfg.ValueCcy, Equity: slices.Clone(r.equity)}
+	rp.Equity = append(rp.Equity, EquityPoint{Time: r.e.Now(), Equity: r.Equity()})
+	pnls, gross, net := r.sampleInstruments()
+	curves := make([][]float64, len(r.curves))
+	for i := range r.curves {
+		curves[i] = append(slices.Clone(r.curves[i]), pnls[i])
+	}
+	maxGross, maxNet := math.Max(r.maxGross, gross), math.Max(r.maxNet, math.Abs(net))
+	r.mu.Unlock()
+
+	r.buildInstruments(rp)
+	r.buildStats(rp)
+
+	equity := make([]float64, len(rp.Equity))
+	for i, p := range rp.Equity {
+		equity[i] = p.Equity
+	}
+	rp.Portfolio = buildPortfolio(r.instIds, curves, equity)
+	rp.Portfolio.MaxGrossExposure = maxGross
+	rp.Portfolio.MaxNetExposure = maxNet
+	rp.Portfolio.Liquidations = len(r.e.acc.Liquidations())
+	logger.LogImportant(logPrefix, "report built, return=%.2f%%, sharpe=%.2f, maxDrawdown=%.2f%%", rp.TotalReturn*100, rp.Sharpe, rp.MaxDrawdown*100)
+	return rp
+}
+
+func (r *Reporter) buildInstruments(rp *Report) {
+	wins, closes := 0, 0
+	fillsById := r.e.acc.Fills()
+	for _, inst := range r.e.instruments {
+		ir := InstrumentReport{InstId: inst.Id}
+		instWins, instCloses := 0, 0
+		for _, f := range fillsById[inst.Id] {
+			ir.Fills++
+			ir.Ccy = f.Ccy
+			ir.Volume += f.Value.Abs().InexactFloat64()
+			ir.Fee += f.Fee.InexactFloat64()
+			ir.Realized += f.Realized.InexactFloat64()
+			if !f.Realized.IsZero() {
+				instCloses++
+				instWins += util.ValueIf(f.Realized.IsPositive(), 1, 0)
+			}
+		}
+		ir.WinRate = util.ValueIf(instCloses > 0, float64(instWins)/float64(max(instCloses, 1)), 0)
+
+		for _, p := range r.e.acc.FundingHistory(inst.Id, time.Time{}, r.e.Now().Add(time.Second)) {
+			ir.Ccy = p.Ccy
+			ir.Funding += p.Amount.InexactFloat64()
+		}
+
+		if t, ok := r.e.futureTraders[inst.Id]; ok {
+			ir.Ccy = t.ccy
+			ir.Unrealized = t.unrealized().InexactFloat64()
+		} else if t, ok := r.e.spotTraders[inst.Id]; ok {
+			ir.Ccy = t.ccy
+			ir.Unrealized = t.unrealized().InexactFloat64()
+		}
+
+		if ir.Fills == 0 && ir.Funding == 0 {
+			continue
+		}
+
+		ir.PnL = ir.Realized + ir.Unrealized + ir.Funding - ir.Fee
+		px := r.price(ir.Ccy)
+		ir.PnLValue = ir.PnL * px
+		rp.Fills += ir.Fills
+		wins += instWins
+		closes += instCloses
+		rp.Volume += ir.Volume * px
+		rp.Fee += ir.Fee * px
+		rp.Funding += ir.Funding * px
+		rp.Instruments = append(rp.Instruments, ir)
+	}
+	rp.WinRate = util.ValueIf(closes > 0, float64(wins)/float64(max(closes, 1)), 0)
+}
+
+func (r *Reporter) buildStats(rp *Report) {
+	eq := rp.Equity
+	if len(eq) == 0 {
+		return
+	}
+
+	rp.Start, rp.End = eq[0].Time, eq[len(eq)-1].Time
+	rp.InitialEquity, rp.FinalEquity = eq[0].Equity, eq[len(eq)-1].Equity
+	if rp.InitialEquity <= 0 {
+		return
+	}
+
+	rp.TotalReturn = rp.FinalEquity/rp.InitialEquity - 1
+	year := float64(time.Hour * 24 * 365)
+	if dur := rp.End.Sub(rp.Start); dur > 0 && rp.FinalEquity > 0 {
+		rp.AnnualReturn = math.Pow(rp.FinalEquity/rp.InitialEquity, year/float64(dur)) - 1
+	}
+
+	// 收益率序列
+	rets := []float64{}
+	sumEquity := eq[0].Equity
+	for i := 1; i < len(eq); i++ {
+		sumEquity += eq[i].Equity
+		if eq[i-1].Equity > 0 {
+			rets = append(rets, eq[i].Equity/eq[i-1].Equity-1)
+		}
+	}
+	rp.Turnover = rp.Volume / (sumEquity / float64(len(eq)))
+
+	if len(rets) > 1 {
+		mean, variance, downside := 0.0, 0.0, 0.0
+		for _, v := range rets {
+			mean += v
+		}
+		mean /= float64(len(rets))
+		for _, v := range rets {
+			variance += (v - mean) * (v - mean)
+			if v < 0 {
+				downside += v * v
+			}
+		}
+		std := math.Sqrt(variance / float64(len(rets)-1))
+		downStd := math.Sqrt(downside / float64(len(rets)))
+		scale := math.Sqrt(year / float64(r.interval))
+		rp.Volatility = std * scale
+		rp.Sharpe = util.ValueIf(std > 0, mean/std*scale, 0)
+		rp.Sortino = util.ValueIf(downStd > 0, mean/downStd*scale, 0)
+	}
+
+	// 最大回撤及持续时间
+	peak, peakTime := eq[0].Equity, eq[0].Time
+	for _, p := range eq {
+		if p.Equity >= peak {
+			rp.MaxDrawdownDur = max(rp.MaxDrawdownDur, p.Time.Sub(peakTime))
+			peak, peakTime = p.Equity, p.Time
+		} else if peak > 0 {
+			rp.MaxDrawdown = math.Max(rp.MaxDrawdown, (peak-p.Equity)/peak)
+		}
+	}
+	if eq[len(eq)-1].Equity < peak {
+		rp.MaxDrawdownDur = max(rp.MaxDrawdownDur, rp.End.Sub(peakTime))
+	}
+}
+
+// #region 输出
+func (rp *Report) SaveJson(path string) bool {
+	return util.ObjectToFile(path, rp)
+}
+
+func (rp *Report) SaveHtml(path string) bool {
+	bb := bytes.Buffer{}
+	if err := reportTemplate.Execute(&bb, rp); err != nil {
+		logger.LogImportant(logPrefix, "render report failed: %s", err.Error())
+		return false
+	}
+	return util.StringToFile(path, bb.String())
+}
+
+// 权益曲线的svg折线坐标
+func (rp *Report) equityPolyline(w, h float64) string {
+	if len(rp.Equity) < 2 {
+		return ""
+	}
+
+	lo, hi := rp.Equity[0].Equity, rp.Equity[0].Equity
+	for _, p := range rp.Equity {
+		lo, hi = math.Min(lo, p.Equity), math.Max(hi, p.Equity)
+	}
+	hi = util.ValueIf(hi > lo, hi, lo+1)
+
+	t0, dur := rp.Start, float64(rp.End.Sub(rp.Start))
+	sb := strings.Builder{}
+	for _, p := range rp.Equity {
+		x := util.ValueIf(dur > 0, float64(p.Time.Sub(t0))/dur*w, 0)
+		y := h - (p.Equity-lo)/(hi-lo)*h
+		sb.WriteString(fmt.Sprintf("%.1f,%.1f ", x, y))
+	}
+	return sb.String()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct":  func(v float64) string { return fmt.Sprintf("%.2f%%", v*100) },
+	"num":  func(v float64) string { return decimal.NewFromFloat(v).Round(4).String() },
+	"dt":   func(t time.Time) string { return t.Format(time.DateTime) },
+	"line": func(rp *Report) string { return rp.equityPolyline(800, 240) },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>backtest report</title>
+<style>body{font-family:sans-serif;margin:24px}table{border-collapse:collapse;margin-bottom:24px}td,th{border:1px solid #ccc;padding:4px 10px;text-align:right}th{background:#f4f4f4}</style>
+</head><body>
+<h2>backtest report ({{dt .Start}} ~ {{dt .End}})</h2>
+<svg width="800" height="240" style="border:1px solid #ccc"><polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="{{line .}}"/></svg>
+<table>
+<tr><th>equity({{.ValueCcy}})</th><td>{{num .InitialEquity}} -> {{num .FinalEquity}}</td></tr>
+<tr><th>total return</th><td>{{pct .TotalReturn}}</td></tr>
+<tr><th>annual return</th><td>{{pct .AnnualReturn}}</td></tr>
+<tr><th>volatility</th><td>{{pct .Volatility}}</td></tr>
+<tr><th>sharpe</th><td>{{num .Sharpe}}</td></tr>
+<tr><th>sortino</th><td>{{num .Sortino}}</td></tr>
+<tr><th>max drawdown</th><td>{{pct .MaxDrawdown}} ({{.MaxDrawdownDur}})</td></tr>
+<tr><th>volume / turnover</th><td>{{num .Volume}} / {{num .Turnover}}</td></tr>
+<tr><th>fills / win rate</th><td>{{.Fills}} / {{pct .WinRate}}</td></tr>
+<tr><th>fee / funding</th><td>{{num .Fee}} / {{num .Funding}}</td></tr>
+</table>
+<table>
+<tr><th>instrument</th><th>ccy</th><th>fills</th><th>volume</th><th>fee</th><th>realized</th><th>unrealized</th><th>funding</th><th>pnl</th><th>pnl({{.ValueCcy}})</th><th>win rate</th></tr>
+{{range .Instruments}}<tr><td>{{.InstId}}</td><td>{{.Ccy}}</td><td>{{.Fills}}</td><td>{{num .Volume}}</td><td>{{num .Fee}}</td><td>{{num .Realized}}</td><td>{{num .Unrealized}}</td><td>{{num .Funding}}</td><td>{{num .PnL}}</td><td>{{num .PnLValue}}</td><td>{{pct .WinRate}}</td></tr>
+{{end}}</table>
+{{with .Portfolio}}<table>
+<tr><th>max drawdown({{$.ValueCcy}})</th><td>{{num .MaxDrawdownValue}}</td></tr>
+<tr><th>sum of instrument drawdowns</th><td>{{num .SumDrawdownValue}}</td></tr>
+<tr><th>correlated drawdown</th><td>{{num .CorrelatedDrawdown}}</td></tr>
+<tr><th>diversification ratio</th><td>{{num .DiversificationRatio}}</td></tr>
+<tr><th>max gross / net exposure</th><td>{{num .MaxGrossExposure}} / {{num .MaxNetExposure}}</td></tr>
+<tr><th>liquidations</th><td>{{.Liquidations}}</td></tr>
+</table>
+<table>
+<tr><th>correlation</th>{{range .InstIds}}<th>{{.}}</th>{{end}}</tr>
+{{range $i, $row := .Correlation}}<tr><th>{{index $.Portfolio.InstIds $i}}</th>{{range $row}}<td>{{num .}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+</body></html>
+`))
+
+// #endregion
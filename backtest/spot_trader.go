@@ -0,0 +1,136 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 13:20:44
+- @Description: 模拟现货交易器，实现common.SpotTrader。手续费以计价币(或抵扣币)扣除
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/shopspring/decimal"
+)
+
+type SimSpotTrader struct {
+	simTrader
+	spotMarket common.SpotMarket
+	base       *balance
+	quote      *balance
+	held       decimal.Decimal // 由本交易器买入的持仓及其平均成本，用于计算已实现盈亏
+	avgCost    decimal.Decimal
+}
+
+// m可以是回放行情，也可以是交易所的实时行情。inst需已加入instrumentMgr
+func NewSpotTrader(exName string, acc *Account, m common.SpotMarket, inst *common.Instruments, instrumentMgr *common.InstrumentMgr) *SimSpotTrader {
+	t := new(SimSpotTrader)
+	t.spotMarket = m
+	acc.mu.Lock()
+	t.base = acc.balance(m.BaseCurrency())
+	t.quote = acc.balance(m.QuoteCurrency())
+	acc.mu.Unlock()
+	t.ccy = m.QuoteCurrency()
+	t.fnFreeze = t.freeze
+	t.fnValue = func(px, sz decimal.Decimal) decimal.Decimal { return px.Mul(sz) }
+	t.fnFill = t.onFill
+	t.init(t, exName, acc, m, inst, instrumentMgr)
+	return t
+}
+
+func (t *SimSpotTrader) freeze(o *SimOrder, remain decimal.Decimal) (*balance, decimal.Decimal) {
+	if o.Dir == common.OrderDir_Buy {
+		return t.quote, remain.Mul(o.Price).Mul(decimal.NewFromInt(1).Add(t.acc.feeRate(false)))
+	} else {
+		return t.base, remain
+	}
+}
+
+// 卖出超过持仓的部分(初始余额)不计算盈亏
+func (t *SimSpotTrader) onFill(o *SimOrder, px, sz, fee decimal.Decimal) decimal.Decimal {
+	value := px.Mul(sz)
+	realized := decimal.Zero
+	if o.Dir == common.OrderDir_Buy {
+		t.base.cash = t.base.cash.Add(sz)
+		t.quote.cash = t.quote.cash.Sub(value).Sub(fee)
+		t.avgCost = t.avgCost.Mul(t.held).Add(value).Div(t.held.Add(sz))
+		t.held = t.held.Add(sz)
+	} else {
+		t.base.cash = t.base.cash.Sub(sz)
+		t.quote.cash = t.quote.cash.Add(value).Sub(fee)
+		closed := decimal.Min(sz, t.held)
+		realized = px.Sub(t.avgCost).Mul(closed)
+		t.held = t.held.Sub(closed)
+	}
+	return realized
+}
+
+// 本交易器买入的持仓按当前价格计算的浮动盈亏
+func (t *SimSpotTrader) unrealized() decimal.Decimal {
+	t.acc.mu.Lock()
+	held, avgCost := t.held, t.avgCost
+	t.acc.mu.Unlock()
+	if !held.IsPositive() {
+		return decimal.Zero
+	}
+
+	return t.price().Sub(avgCost).Mul(held)
+}
+
+// 本交易器买入的持仓价值(以计价币计)
+func (t *SimSpotTrader) exposure() decimal.Decimal {
+	t.acc.mu.Lock()
+	held := t.held
+	t.acc.mu.Unlock()
+	return held.Mul(t.price())
+}
+
+func (t *SimSpotTrader) price() decimal.Decimal {
+	if ob := t.market.OrderBook(); !ob.Empty() {
+		return ob.MiddlePrice()
+	}
+	return t.market.LatestPrice()
+}
+
+// #region 实现common.SpotTrader
+func (t *SimSpotTrader) SpotMarket() common.SpotMarket {
+	return t.spotMarket
+}
+
+func (t *SimSpotTrader) String() string {
+	bb := bytes.Buffer{}
+	bb.WriteString(t.market.String())
+	bb.WriteString(fmt.Sprintf("\nsim spot trader:%s\n", t.inst.Id))
+	bb.WriteString(fmt.Sprintf("base currency(%s): %v/%v\n", t.base.Ccy(), t.base.Available(), t.base.Rights()))
+	bb.WriteString(fmt.Sprintf("quote currency(%s): %v/%v\n", t.quote.Ccy(), t.quote.Available(), t.quote.Rights()))
+
+	orders := t.Orders()
+	bb.WriteString(fmt.Sprintf("%d alive orders:\n", len(orders)))
+	for _, o := range orders {
+		bb.WriteString(o.String())
+	}
+	return bb.String()
+}
+
+func (t *SimSpotTrader) AvailableAmount(dir common.OrderDir, price decimal.Decimal) decimal.Decimal {
+	if dir == common.OrderDir_Buy {
+		return t.market.AlignSize(common.AvailableBuyAmount(t.quote.Available(), price, t.FeeTaker()))
+	} else {
+		return t.market.AlignSize(common.AvailableSellAmount(t.base.Available()))
+	}
+}
+
+func (t *SimSpotTrader) BaseBalance() common.Balance {
+	return t.base
+}
+
+func (t *SimSpotTrader) QuoteBalance() common.Balance {
+	return t.quote
+}
+
+func (t *SimSpotTrader) AssetId() int {
+	return AssetId_Backtest
+}
+
+// #endregion
@@ -0,0 +1,595 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 11:03:17
+- @Description: 模拟交易器的公共部分：下单、撤单、改单和撮合
+- 新订单先按当前盘口吃单(taker)，剩余部分挂单。挂单在以下情况成交(maker，按挂单价格)：
+- 1. 深度变化后对手盘越过挂单价格，成交数量不超过越过部分的深度
+- 2. 逐笔成交的价格穿过挂单价格，成交数量不超过该笔成交的数量
+- 3. 开启排队模拟时，同价位的成交消耗完前方队列后的剩余部分。不开启时价格相等不成交(保守假设排在队尾)
+- 下单/撤单延迟、成交概率见MatchConfig。改单立即生效，不考虑自身成交对盘口的影响
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OrderStatus_Pending         = "pending" // 等待交易所确认
+	OrderStatus_Live            = "live"
+	OrderStatus_PartiallyFilled = "partially_filled"
+	OrderStatus_Filled          = "filled"
+	OrderStatus_Canceled        = "canceled"
+)
+
+// 回测中所有交易器共用一个账户
+const AssetId_Backtest = 1
+
+type simTrader struct {
+	self          common.CommonTrader // 外层的现货/合约交易器
+	exName        string
+	acc           *Account
+	market        common.CommonMarket
+	inst          *common.Instruments
+	instrumentMgr *common.InstrumentMgr
+	pos           *position // 仅合约
+	ccy           string    // 成交额/盈亏的币种，现货为计价币，合约为保证金币种
+	logPrefix     string
+
+	mu     sync.Mutex
+	orders []*SimOrder // 未完结的订单
+	closed bool
+
+	// 由现货/合约交易器提供，在持有账户锁时调用
+	fnFreeze func(o *SimOrder, remain decimal.Decimal) (*balance, decimal.Decimal) // 挂单剩余部分需冻结的币种和数量
+	fnValue  func(px, sz decimal.Decimal) decimal.Decimal                          // 成交额(以手续费币种计)
+	fnFill   func(o *SimOrder, px, sz, fee decimal.Decimal) decimal.Decimal        // 成交后更新余额/仓位，返回已实现盈亏
+	fnTick   func()                                                                // 每个行情事件到来时调用，不持有锁。可为空
+}
+
+// 一次撮合产生的回调，在释放锁之后触发
+type simEvents struct {
+	exName   string
+	bus      *common.EventBus
+	deals    []common.Deal
+	finished []*SimOrder
+}
+
+func (e *simEvents) notify() {
+	for _, d := range e.deals {
+		o := d.O.(*SimOrder)
+		o.LogFields.LogDebug(o.LogPrefix, "order dealing, dir=%s, price=%v, amount=%v, time=%v", common.OrderDir2Str(o.Dir), d.Price, d.Amount, d.UTime)
+		for _, obs := range o.Observers {
+			obs.OnDeal(d)
+		}
+		e.bus.PublishDeal(e.exName, d)
+	}
+
+	// 外部回调结束后，再置订单完成状态
+	for _, o := range e.finished {
+		o.Finished = true
+		o.LogFields.LogDebug(o.LogPrefix, "order finished, status=%s", o.Status)
+		e.bus.PublishOrderUpdate(e.exName, o, o.UpdateTime)
+	}
+}
+
+func (t *simTrader) init(self common.CommonTrader, exName string, acc *Account, m common.CommonMarket, inst *common.Instruments, instrumentMgr *common.InstrumentMgr) {
+	t.self = self
+	t.exName = exName
+	t.acc = acc
+	t.market = m
+	t.inst = inst
+	t.instrumentMgr = instrumentMgr
+	t.logPrefix = fmt.Sprintf("sim-Trader-%s", inst.Id)
+	m.AddDepthObserver(t)
+	m.SubscribeTrades(t.onTrade)
+}
+
+// #region 撮合
+func crosses(dir common.OrderDir, orderPx, px decimal.Decimal) bool {
+	if dir == common.OrderDir_Buy {
+		return px.LessThanOrEqual(orderPx)
+	} else {
+		return px.GreaterThanOrEqual(orderPx)
+	}
+}
+
+// 对手盘
+func (t *simTrader) oppositeLevels(dir common.OrderDir) [][2]decimal.Decimal {
+	asks, bids := t.market.OrderBook().Levels(0)
+	return util.ValueIf(dir == common.OrderDir_Buy, asks, bids)
+}
+
+func (t *simTrader) submit(o *SimOrder, obs common.OrderObserver) {
+	if obs != nil {
+		o.AddObserver(obs)
+	}
+
+	t.mu.Lock()
+	t.acc.mu.Lock()
+	now := t.acc.Now()
+	o.OrderId = t.acc.nextOrderId()
+	o.CltOrderId = fmt.Sprintf("bt%d", o.OrderId)
+	o.LogPrefix = fmt.Sprintf("Order-%s-%v", o.InstId, o.CltOrderId)
+	o.LogFields = logger.Fields{Exchange: t.exName, InstId: o.InstId, OrderId: fmt.Sprintf("%v", o.CltOrderId)}
+	o.Borntime = now
+	o.UpdateTime = now
+	o.Status = OrderStatus_Pending
+	o.ackTime = now.Add(t.acc.match.AckLatency)
+	t.orders = append(t.orders, o)
+	t.refreeze(o)
+
+	ev := simEvents{exName: t.exName, bus: t.acc.bus}
+	if t.acc.match.AckLatency <= 0 {
+		t.activate(o, &ev)
+	}
+
+	t.acc.mu.Unlock()
+	t.mu.Unlock()
+	ev.notify()
+}
+
+// 订单到达交易所，先吃单，剩余部分挂单或撤销
+func (t *simTrader) activate(o *SimOrder, ev *simEvents) {
+	o.acked = true
+	o.Status = OrderStatus_Live
+	o.UpdateTime = t.acc.Now()
+
+	asks, bids := t.market.OrderBook().Levels(0)
+	levels := util.ValueIf(o.Dir == common.OrderDir_Buy, asks, bids)
+	if o.TimeInForce == common.TimeInForce_GTX && len(levels) > 0 && crosses(o.Dir, o.Price, levels[0][0]) {
+		o.ErrMsg = "post only order would take liquidity"
+		t.finish(o, OrderStatus_Canceled, ev)
+	} else if o.TimeInForce == common.TimeInForce_FOK && t.takeable(o, levels).LessThan(o.GetUnfilled()) {
+		o.ErrMsg = "fill or kill order can't be fully filled"
+		t.finish(o, OrderStatus_Canceled, ev)
+	} else {
+		t.take(o, levels, ev)
+		if !o.finishing {
+			if o.MarketOrder || o.TimeInForce == common.TimeInForce_IOC || o.TimeInForce == common.TimeInForce_FOK {
+				t.finish(o, OrderStatus_Canceled, ev)
+			} else if t.acc.match.QueueModel {
+				// 排在同价位已有挂单之后
+				o.queueAhead = levelSize(util.ValueIf(o.Dir == common.OrderDir_Buy, bids, asks), o.Price)
+			}
+		}
+	}
+}
+
+// 处理到期的下单确认和撤单，返回刚刚确认的订单
+func (t *simTrader) processDue(ev *simEvents) []*SimOrder {
+	now := t.acc.Now()
+	activated := []*SimOrder{}
+	for _, o := range slices.Clone(t.orders) {
+		if !o.acked && !now.Before(o.ackTime) {
+			t.activate(o, ev)
+			activated = append(activated, o)
+		}
+
+		if !o.finishing && !o.cancelTime.IsZero() && !now.Before(o.cancelTime) {
+			t.finish(o, OrderStatus_Canceled, ev)
+		}
+	}
+	return activated
+}
+
+// 某个价位的数量
+func levelSize(levels [][2]decimal.Decimal, px decimal.Decimal) decimal.Decimal {
+	for _, l := range levels {
+		if l[0].Equal(px) {
+			return l[1]
+		}
+	}
+	return decimal.Zero
+}
+
+// 按订单价格可以立即成交的数量
+func (t *simTrader) takeable(o *SimOrder, levels [][2]decimal.Decimal) decimal.Decimal {
+	sz := decimal.Zero
+	for _, l := range levels {
+		if !crosses(o.Dir, o.Price, l[0]) {
+			break
+		}
+		sz = sz.Add(l[1])
+	}
+	return sz
+}
+
+// 作为taker逐档吃单
+func (t *simTrader) take(o *SimOrder, levels [][2]decimal.Decimal, ev *simEvents) {
+	slip := decimal.Zero
+	if t.acc.slippage != nil && len(levels) > 0 && crosses(o.Dir, o.Price, levels[0][0]) {
+		slip = decimal.NewFromFloat(t.acc.slippage.Slippage(t.market.OrderBook(), o.Dir, o.GetUnfilled()))
+	}
+
+	for _, l := range levels {
+		if o.finishing || !crosses(o.Dir, o.Price, l[0]) {
+			break
+		}
+
+		// 加上滑点后不差于订单价格
+		px := l[0]
+		if o.Dir == common.OrderDir_Buy {
+			px = decimal.Min(px.Mul(decimal.NewFromInt(1).Add(slip)), o.Price)
+		} else {
+			px = decimal.Max(px.Mul(decimal.NewFromInt(1).Sub(slip)), o.Price)
+		}
+
+		sz := decimal.Min(l[1], o.GetUnfilled())
+		if o.QuoteSize.IsPositive() {
+			// 按计价币下单，花完为止
+			quoteRemain := o.QuoteSize.Sub(o.quoteFilled)
+			if !quoteRemain.IsPositive() {
+				break
+			}
+			sz = decimal.Min(l[1], quoteRemain.Div(px))
+		}
+		t.fill(o, px, sz, true, ev)
+	}
+
+	// 按计价币下单的实际数量以成交为准
+	if o.QuoteSize.IsPositive() && !o.finishing {
+		o.Size = o.Filled
+		t.finish(o, util.ValueIf(o.Filled.IsPositive(), OrderStatus_Filled, OrderStatus_Canceled), ev)
+	}
+}
+
+// 挂单成交，按概率决定是否真的成交
+func (t *simTrader) makerFill(o *SimOrder, sz decimal.Decimal, ev *simEvents) {
+	if sz.IsPositive() && t.acc.makerFill() {
+		t.fill(o, o.Price, decimal.Min(sz, o.GetUnfilled()), false, ev)
+	}
+}
+
+func (t *simTrader) fill(o *SimOrder, px, sz decimal.Decimal, taker bool, ev *simEvents) {
+	if !sz.IsPositive() {
+		return
+	}
+
+	now := t.acc.Now()
+	value := t.fnValue(px, sz)
+	fee := t.acc.charge(value, !taker)
+	realized := t.fnFill(o, px, sz, fee)
+	o.AvgPrice = o.AvgPrice.Mul(o.Filled).Add(px.Mul(sz)).Div(o.Filled.Add(sz))
+	o.Filled = o.Filled.Add(sz)
+	o.quoteFilled = o.quoteFilled.Add(px.Mul(sz))
+	o.UpdateTime = now
+	o.Status = OrderStatus_PartiallyFilled
+	t.acc.recordFill(Fill{
+		InstId:   o.InstId,
+		Time:     now,
+		Dir:      o.Dir,
+		Price:    px,
+		Amount:   sz,
+		Value:    value,
+		Fee:      fee,
+		Realized: realized,
+		Ccy:      t.ccy,
+		Taker:    taker,
+	})
+	ev.deals = append(ev.deals, common.Deal{LocalTime: now, UTime: now, O: o, Price: px, Amount: sz})
+
+	if !o.QuoteSize.IsPositive() && !o.GetUnfilled().IsPositive() {
+		t.finish(o, OrderStatus_Filled, ev)
+	} else {
+		t.refreeze(o)
+	}
+}
+
+func (t *simTrader) finish(o *SimOrder, status string, ev *simEvents) {
+	o.Status = status
+	o.UpdateTime = t.acc.Now()
+	o.finishing = true
+	if i := slices.Index(t.orders, o); i >= 0 {
+		t.orders = slices.Delete(t.orders, i, i+1)
+	}
+	t.refreeze(o)
+	ev.finished = append(ev.finished, o)
+}
+
+// 重新计算挂单的冻结数量
+func (t *simTrader) refreeze(o *SimOrder) {
+	if o.frozenBal != nil {
+		o.frozenBal.frozen = o.frozenBal.frozen.Sub(o.frozen)
+		o.frozenBal, o.frozen = nil, decimal.Zero
+	}
+
+	if !o.finishing && slices.Contains(t.orders, o) {
+		o.frozenBal, o.frozen = t.fnFreeze(o, o.GetUnfilled())
+		o.frozenBal.frozen = o.frozenBal.frozen.Add(o.frozen)
+	}
+}
+
+// 实现common.DepthObserver
+func (t *simTrader) OnDepthChanged() {
+	if t.fnTick != nil {
+		t.fnTick()
+	}
+
+	t.mu.Lock()
+	if len(t.orders) == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	t.acc.mu.Lock()
+	ev := simEvents{exName: t.exName, bus: t.acc.bus}
+	activated := t.processDue(&ev)
+	asks, bids := t.market.OrderBook().Levels(0)
+	for _, o := range slices.Clone(t.orders) {
+		if !o.acked || slices.Contains(activated, o) {
+			continue
+		}
+
+		// 前方队列只会因成交或撤单减少
+		if t.acc.match.QueueModel {
+			o.queueAhead = decimal.Min(o.queueAhead, levelSize(util.ValueIf(o.Dir == common.OrderDir_Buy, bids, asks), o.Price))
+		}
+
+		t.makerFill(o, t.takeable(o, util.ValueIf(o.Dir == common.OrderDir_Buy, asks, bids)), &ev)
+	}
+	t.acc.mu.Unlock()
+	t.mu.Unlock()
+	ev.notify()
+}
+
+func (t *simTrader) onTrade(tr common.PublicTrade) {
+	if t.fnTick != nil {
+		t.fnTick()
+	}
+
+	t.mu.Lock()
+	if t.closed || len(t.orders) == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	t.acc.mu.Lock()
+	ev := simEvents{exName: t.exName, bus: t.acc.bus}
+	t.processDue(&ev)
+	for _, o := range slices.Clone(t.orders) {
+		if !o.acked || tr.Dir == o.Dir {
+			continue
+		}
+
+		if o.Dir == common.OrderDir_Buy && tr.Price.LessThan(o.Price) || o.Dir == common.OrderDir_Sell && tr.Price.GreaterThan(o.Price) {
+			// 穿过挂单价格
+			t.makerFill(o, tr.Size, &ev)
+		} else if t.acc.match.QueueModel && tr.Price.Equal(o.Price) {
+			// 同价位成交，先消耗前方队列
+			consumed := decimal.Min(o.queueAhead, tr.Size)
+			o.queueAhead = o.queueAhead.Sub(consumed)
+			t.makerFill(o, tr.Size.Sub(consumed), &ev)
+		}
+	}
+	t.acc.mu.Unlock()
+	t.mu.Unlock()
+	ev.notify()
+}
+
+// 撤单在CancelLatency之后生效，在此之前订单仍可能成交
+func (t *simTrader) cancel(o *SimOrder) {
+	t.mu.Lock()
+	t.acc.mu.Lock()
+	ev := simEvents{exName: t.exName, bus: t.acc.bus}
+	if slices.Contains(t.orders, o) {
+		if t.acc.match.CancelLatency <= 0 {
+			t.finish(o, OrderStatus_Canceled, &ev)
+		} else if o.cancelTime.IsZero() {
+			o.cancelTime = t.acc.Now().Add(t.acc.match.CancelLatency)
+			if o.cancelTime.Before(o.ackTime) {
+				o.cancelTime = o.ackTime
+			}
+		}
+	}
+	t.acc.mu.Unlock()
+	t.mu.Unlock()
+	ev.notify()
+}
+
+// 改单后如果越过对手盘，按taker立即成交(只挂单则撤销)
+func (t *simTrader) modify(o *SimOrder, price, size decimal.Decimal) {
+	ob := t.market.OrderBook()
+	if price.IsPositive() {
+		price = t.instrumentMgr.AlignPrice(o.InstId, price, o.Dir, false, ob.Buy1Price(), ob.Sell1Price())
+	}
+	if size.IsPositive() {
+		size = t.instrumentMgr.AlignSize(o.InstId, size)
+	}
+
+	t.mu.Lock()
+	t.acc.mu.Lock()
+	ev := simEvents{exName: t.exName, bus: t.acc.bus}
+	if slices.Contains(t.orders, o) {
+		if price.IsPositive() && !price.Equal(o.Price) {
+			// 改价后重新排队
+			o.Price = price
+			if t.acc.match.QueueModel {
+				asks, bids := t.market.OrderBook().Levels(0)
+				o.queueAhead = levelSize(util.ValueIf(o.Dir == common.OrderDir_Buy, bids, asks), o.Price)
+			}
+		}
+		if size.GreaterThan(o.Filled) {
+			o.Size = size
+		}
+		o.UpdateTime = t.acc.Now()
+		t.refreeze(o)
+
+		levels := t.oppositeLevels(o.Dir)
+		if o.acked && len(levels) > 0 && crosses(o.Dir, o.Price, levels[0][0]) {
+			if o.MakeOnly {
+				o.ErrMsg = "post only order would take liquidity"
+				t.finish(o, OrderStatus_Canceled, &ev)
+			} else {
+				t.take(o, levels, &ev)
+			}
+		}
+	} else {
+		o.LogFields.LogInfo(o.LogPrefix, "modify failed, order not alive")
+	}
+	t.acc.mu.Unlock()
+	t.mu.Unlock()
+	ev.notify()
+}
+
+// #endregion
+
+// #region 实现common.CommonTrader的公共部分
+func (t *simTrader) Uninit() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.market.RemoveDepthObserver(t)
+	logger.LogImportant(t.logPrefix, "sim trader uninited")
+}
+
+func (t *simTrader) Market() common.CommonMarket {
+	return t.market
+}
+
+func (t *simTrader) Ready() bool {
+	return t.market.Ready()
+}
+
+func (t *simTrader) UnreadyReason() string {
+	return t.market.UnreadyReason()
+}
+
+func (t *simTrader) BuyPriceRange() (min, max decimal.Decimal) {
+	return decimal.Zero, decimal.NewFromInt(math.MaxInt32)
+}
+
+func (t *simTrader) SellPriceRange() (min, max decimal.Decimal) {
+	return decimal.Zero, decimal.NewFromInt(math.MaxInt32)
+}
+
+func (t *simTrader) MakeOrder(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	makeOnly, reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	return t.MakeOrderTIF(price, amount, dir, util.ValueIf(makeOnly, common.TimeInForce_GTX, common.TimeInForce_GTC), reduceOnly, purpose, obs)
+}
+
+func (t *simTrader) MakeOrderTIF(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif common.TimeInForce,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	if !t.self.Ready() {
+		logger.LogInfo(t.logPrefix, "trader not ready, can't Makeorder. reason=%s", t.self.UnreadyReason())
+		return nil
+	}
+
+	amount, ok := t.checkReduceOnly(dir, amount, reduceOnly)
+	if !ok {
+		return nil
+	}
+
+	o := t.newOrder()
+	if o.InitTIF(t.self, t.instrumentMgr, t.inst.Id, price, amount, dir, tif, reduceOnly, purpose) {
+		t.submit(o, obs)
+		return o
+	} else {
+		return nil
+	}
+}
+
+func (t *simTrader) newOrder() *SimOrder {
+	o := new(SimOrder)
+	o.trader = t
+	if t.acc.isolated {
+		o.Isolate(t.acc.checkers)
+	}
+	return o
+}
+
+func (t *simTrader) MakeMarketOrder(
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	byQuote bool,
+	maxSlippage decimal.Decimal,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	if !t.self.Ready() {
+		logger.LogInfo(t.logPrefix, "trader not ready, can't make market order. reason=%s", t.self.UnreadyReason())
+		return nil
+	}
+
+	guardPrice, baseAmount, err := common.CheckMarketOrder(t.market.OrderBook(), dir, amount, byQuote, maxSlippage)
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "market order rejected: %s", err.Error())
+		return nil
+	}
+
+	baseAmount, ok := t.checkReduceOnly(dir, baseAmount, reduceOnly)
+	if !ok {
+		return nil
+	}
+
+	o := t.newOrder()
+	if o.InitMarket(t.self, t.instrumentMgr, t.inst.Id, guardPrice, baseAmount, util.ValueIf(byQuote, amount, decimal.Zero), dir, reduceOnly, purpose) {
+		t.submit(o, obs)
+		return o
+	} else {
+		return nil
+	}
+}
+
+// 只减仓订单的数量不超过可减仓位(扣除已挂出的只减仓订单)，仅合约有效
+func (t *simTrader) checkReduceOnly(dir common.OrderDir, amount decimal.Decimal, reduceOnly bool) (decimal.Decimal, bool) {
+	if !reduceOnly || t.pos == nil {
+		return amount, true
+	}
+
+	pending := decimal.Zero
+	t.mu.Lock()
+	for _, o := range t.orders {
+		if o.ReduceOnly && o.Dir == dir {
+			pending = pending.Add(o.GetUnfilled())
+		}
+	}
+	t.mu.Unlock()
+
+	amount, err := common.CheckReduceOnly(t.pos, dir, amount, pending)
+	if err != nil {
+		logger.LogInfo(t.logPrefix, err.Error())
+		return decimal.Zero, false
+	}
+	return amount, true
+}
+
+func (t *simTrader) Orders() []common.Order {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	orders := make([]common.Order, 0, len(t.orders))
+	for _, o := range t.orders {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+func (t *simTrader) FeeTaker() decimal.Decimal {
+	return t.acc.FeeTaker()
+}
+
+func (t *simTrader) FeeMaker() decimal.Decimal {
+	return t.acc.FeeMaker()
+}
+
+// #endregion
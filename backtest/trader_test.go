@@ -0,0 +1,229 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-08 17:26:40
+- @Description: 模拟交易器的撮合、冻结和手续费测试
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package backtest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(logger.SplitMode_ByDays, 1)
+	os.Exit(m.Run())
+}
+
+// 手动驱动的行情，只实现交易器用到的方法
+type stubSpotMarket struct {
+	common.SpotMarket
+	ob      *common.Orderbook
+	obs     []common.DepthObserver
+	fnTrade []func(t common.PublicTrade)
+	mgr     *common.InstrumentMgr
+	instId  string
+}
+
+func (m *stubSpotMarket) OrderBook() *common.Orderbook               { return m.ob }
+func (m *stubSpotMarket) AddDepthObserver(o common.DepthObserver)    { m.obs = append(m.obs, o) }
+func (m *stubSpotMarket) RemoveDepthObserver(o common.DepthObserver) {}
+func (m *stubSpotMarket) SubscribeTrades(fn func(t common.PublicTrade)) int {
+	m.fnTrade = append(m.fnTrade, fn)
+	return len(m.fnTrade)
+}
+func (m *stubSpotMarket) Ready() bool                  { return true }
+func (m *stubSpotMarket) BaseCurrency() string         { return "BTC" }
+func (m *stubSpotMarket) QuoteCurrency() string        { return "USDT" }
+func (m *stubSpotMarket) LatestPrice() decimal.Decimal { return m.ob.MiddlePrice() }
+func (m *stubSpotMarket) AlignSize(sz decimal.Decimal) decimal.Decimal {
+	return m.mgr.AlignSize(m.instId, sz)
+}
+func (m *stubSpotMarket) MinSize() decimal.Decimal { return m.mgr.MinSize(m.instId, m.LatestPrice()) }
+func (m *stubSpotMarket) AlignPriceNumber(px decimal.Decimal) decimal.Decimal {
+	return m.mgr.AlignPriceNumber(m.instId, px)
+}
+
+func (m *stubSpotMarket) depth(asks, bids []float64) {
+	m.ob.Rebuild(decimals(asks...), decimals(bids...))
+	for _, o := range m.obs {
+		o.OnDepthChanged()
+	}
+}
+
+func (m *stubSpotMarket) trade(px, sz float64, dir common.OrderDir) {
+	for _, fn := range m.fnTrade {
+		fn(common.PublicTrade{Price: dec(px), Size: dec(sz), Dir: dir})
+	}
+}
+
+func dec(v float64) decimal.Decimal {
+	return decimal.NewFromFloat(v)
+}
+
+func decimals(vs ...float64) []decimal.Decimal {
+	ds := make([]decimal.Decimal, len(vs))
+	for i, v := range vs {
+		ds[i] = dec(v)
+	}
+	return ds
+}
+
+func expectDec(t *testing.T, name string, got decimal.Decimal, expect float64) {
+	t.Helper()
+	if !got.Equal(dec(expect)) {
+		t.Errorf("%s = %v, expect %v", name, got, expect)
+	}
+}
+
+// 初始盘口：卖101x1、102x2，买99x1、98x2
+func newStubSpotTrader(match MatchConfig, clock common.Clock) (*SimSpotTrader, *stubSpotMarket) {
+	mgr := common.NewInstrumentMgr("test")
+	inst := &common.Instruments{Id: "BTC-USDT", BaseCcy: "BTC", QuoteCcy: "USDT", TickSize: dec(0.1), LotSize: dec(0.001), MinSize: dec(0.001)}
+	mgr.Set(inst.Id, inst)
+
+	m := &stubSpotMarket{ob: common.NewOrderBook(), mgr: mgr, instId: inst.Id}
+	m.ob.Rebuild(decimals(101, 1, 102, 2), decimals(99, 1, 98, 2))
+	acc := NewAccount(map[string]decimal.Decimal{"USDT": dec(1000)}, dec(0.0002), dec(0.0005), clock)
+	acc.SetMatchConfig(match)
+	return NewSpotTrader(exchangeName, acc, m, inst, mgr), m
+}
+
+func TestTakerFillRestsRemain(t *testing.T) {
+	tr, m := newStubSpotTrader(MatchConfig{}, nil)
+
+	// 只吃掉不超过限价的档位，剩余部分挂单并冻结(按taker费率预留手续费)
+	o := tr.MakeOrder(dec(101.5), dec(2), common.OrderDir_Buy, false, false, "t", nil)
+	if o == nil {
+		t.Fatal("make order failed")
+	}
+	expectDec(t, "filled", o.GetFilled(), 1)
+	expectDec(t, "base", tr.BaseBalance().Rights(), 1)
+	expectDec(t, "quote", tr.QuoteBalance().Rights(), 1000-101-101*0.0005)
+	expectDec(t, "quote frozen", tr.QuoteBalance().Frozen(), 101.5*1.0005)
+	if o.IsFinished() {
+		t.Fatal("order should be alive")
+	}
+
+	// 卖盘移动到挂单价以内，剩余部分成交
+	m.depth([]float64{101, 3}, []float64{99, 1})
+	expectDec(t, "filled", o.GetFilled(), 2)
+	expectDec(t, "quote frozen", tr.QuoteBalance().Frozen(), 0)
+	if !o.IsFinished() || len(tr.Orders()) != 0 {
+		t.Errorf("order should be finished and removed, status=%s, orders=%d", o.GetStatus(), len(tr.Orders()))
+	}
+}
+
+func TestMakerFillByTrade(t *testing.T) {
+	tr, m := newStubSpotTrader(MatchConfig{}, nil)
+	tr.MakeOrder(dec(101), dec(1), common.OrderDir_Buy, false, false, "t", nil)
+
+	// 只挂单，成交价穿过挂单价时按挂单价、maker费率成交
+	o := tr.MakeOrder(dec(105), dec(1), common.OrderDir_Sell, true, false, "t", nil)
+	if o == nil || o.IsFinished() {
+		t.Fatal("make only order should be alive")
+	}
+	expectDec(t, "base frozen", tr.BaseBalance().Frozen(), 1)
+
+	m.trade(105, 0.4, common.OrderDir_Buy) // 等于挂单价，不排队时不成交
+	expectDec(t, "filled", o.GetFilled(), 0)
+
+	m.trade(106, 0.4, common.OrderDir_Buy)
+	expectDec(t, "filled", o.GetFilled(), 0.4)
+	expectDec(t, "avg price", o.GetAvgPrice(), 105)
+	expectDec(t, "quote", tr.QuoteBalance().Rights(), 1000-101*1.0005+42*0.9998)
+
+	// 撤单后解冻
+	o.Cancel()
+	if !o.IsFinished() {
+		t.Fatal("order should be canceled")
+	}
+	expectDec(t, "base frozen", tr.BaseBalance().Frozen(), 0)
+	expectDec(t, "base", tr.BaseBalance().Rights(), 0.6)
+}
+
+func TestMakeOnlyRepriced(t *testing.T) {
+	tr, _ := newStubSpotTrader(MatchConfig{}, nil)
+
+	// 会吃单的只挂单订单，价格调整到买一，不成交
+	o := tr.MakeOrder(dec(101), dec(1), common.OrderDir_Buy, true, false, "t", nil)
+	if o == nil || o.IsFinished() {
+		t.Fatal("make only order should be alive")
+	}
+	expectDec(t, "price", o.GetPrice(), 99)
+	expectDec(t, "filled", o.GetFilled(), 0)
+	expectDec(t, "quote", tr.QuoteBalance().Rights(), 1000)
+}
+
+func TestAckLatency(t *testing.T) {
+	clock := common.NewVirtualClock(time.Date(2024, 7, 8, 0, 0, 0, 0, time.UTC))
+	tr, m := newStubSpotTrader(MatchConfig{AckLatency: time.Millisecond * 10}, clock)
+
+	// 确认之前不撮合
+	o := tr.MakeOrder(dec(102), dec(2), common.OrderDir_Buy, false, false, "t", nil)
+	expectDec(t, "filled", o.GetFilled(), 0)
+
+	clock.Set(clock.Now().Add(time.Millisecond * 5))
+	m.depth([]float64{101, 1, 102, 2}, []float64{99, 1})
+	expectDec(t, "filled", o.GetFilled(), 0)
+
+	clock.Set(clock.Now().Add(time.Millisecond * 5))
+	m.depth([]float64{101, 1, 102, 2}, []float64{99, 1})
+	expectDec(t, "filled", o.GetFilled(), 2)
+	expectDec(t, "avg price", o.GetAvgPrice(), 101.5)
+}
+
+func TestMarketOrderByQuote(t *testing.T) {
+	tr, _ := newStubSpotTrader(MatchConfig{}, nil)
+
+	// 按计价币数量买入，吃掉卖一后在卖二成交剩余部分
+	o := tr.MakeMarketOrder(dec(203), common.OrderDir_Buy, true, dec(0.02), false, "m", nil)
+	if o == nil {
+		t.Fatal("make market order failed")
+	}
+	if !o.IsFinished() {
+		t.Fatalf("market order should be finished, status=%s", o.GetStatus())
+	}
+	expectDec(t, "base", tr.BaseBalance().Rights(), 2)
+	expectDec(t, "quote", tr.QuoteBalance().Rights(), 1000-203*1.0005)
+}
+
+func TestMarketOrderNoSlippage(t *testing.T) {
+	tr, _ := newStubSpotTrader(MatchConfig{}, nil)
+
+	// 不允许滑点时只在对手方一档成交，剩余部分撤销
+	o := tr.MakeMarketOrder(dec(2), common.OrderDir_Buy, false, decimal.Zero, false, "m", nil)
+	if o == nil {
+		t.Fatal("make market order failed")
+	}
+	if !o.IsFinished() {
+		t.Fatalf("market order should be finished, status=%s", o.GetStatus())
+	}
+	expectDec(t, "filled", o.GetFilled(), 1)
+	expectDec(t, "quote frozen", tr.QuoteBalance().Frozen(), 0)
+}
+
+func TestPositionOnFill(t *testing.T) {
+	p := &position{ctVal: dec(0.01), fnMarkPrice: func() decimal.Decimal { return dec(110) }}
+
+	// 同向加仓更新均价，反向成交先平仓，超出部分反向开仓
+	expectDec(t, "realized", p.onFill(common.OrderDir_Buy, dec(100), dec(10)), 0)
+	expectDec(t, "realized", p.onFill(common.OrderDir_Buy, dec(110), dec(10)), 0)
+	expectDec(t, "avg price", p.avgPx, 105)
+	expectDec(t, "upl", p.upl(), 1)
+
+	expectDec(t, "realized", p.onFill(common.OrderDir_Sell, dec(120), dec(30)), 3)
+	expectDec(t, "net", p.net, -10)
+	expectDec(t, "avg price", p.avgPx, 120)
+	expectDec(t, "upl", p.upl(), 1)
+
+	expectDec(t, "realized", p.onFill(common.OrderDir_Buy, dec(100), dec(10)), 2)
+	expectDec(t, "net", p.net, 0)
+	expectDec(t, "avg price", p.avgPx, 0)
+}
@@ -0,0 +1,232 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-02 14:08:33
+- @Description: 跨交易所合并订单簿。把多个交易所同一品种的深度合并成一个，每档保留来源交易所
+- 开启费率调整时，按各交易所的吃单费率折算价格(买盘*(1-fee)，卖盘*(1+fee))后再排序，便于比较真实成本
+- 合约深度的数量为张数，合并时统一折算为基础币数量(U本位乘面值，币本位乘面值再除以价格)
+- Sweep给出按合并深度吃单时各交易所应分配的数量，用于跨交易所执行。分配按各交易所的下单精度向下取整，不足最小下单量的不分配
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/shopspring/decimal"
+)
+
+type BookVenue struct {
+	Name     string
+	Market   common.CommonMarket
+	TakerFee decimal.Decimal // 吃单费率
+}
+
+// 合并深度中的一档
+type CompositeLevel struct {
+	Venue     string
+	Price     decimal.Decimal // 费后价格，未开启费率调整时等于RawPrice
+	RawPrice  decimal.Decimal
+	Size      decimal.Decimal // 基础币数量
+	VenueSize decimal.Decimal // 交易所深度中的原始数量，合约为张数
+}
+
+// 某个交易所的吃单分配
+type CompositeAlloc struct {
+	Venue      string
+	Market     common.CommonMarket
+	Size       decimal.Decimal // 基础币数量
+	VenueSize  decimal.Decimal // 下单数量(合约为张数)，已按精度对齐
+	AvgPrice   decimal.Decimal // 原始价格的均价
+	WorstPrice decimal.Decimal // 需要吃到的最差原始价格，可作为限价
+}
+
+type CompositeBook struct {
+	symbol      string
+	feeAdjusted bool
+	depth       int // 每个交易所参与合并的档数
+
+	mu        sync.Mutex
+	venues    []BookVenue
+	fnChanged func()
+}
+
+// depth为每个交易所参与合并的档数，<=0表示全部
+func NewCompositeBook(symbol string, feeAdjusted bool, depth int) *CompositeBook {
+	b := new(CompositeBook)
+	b.symbol = symbol
+	b.feeAdjusted = feeAdjusted
+	b.depth = depth
+	return b
+}
+
+func (b *CompositeBook) Symbol() string {
+	return b.symbol
+}
+
+func (b *CompositeBook) AddVenue(v BookVenue) {
+	b.mu.Lock()
+	b.venues = append(b.venues, v)
+	b.mu.Unlock()
+	v.Market.AddDepthObserver(b)
+}
+
+// 任一交易所深度变化时回调
+func (b *CompositeBook) SetChangedFn(fn func()) {
+	b.fnChanged = fn
+}
+
+// 实现common.DepthObserver
+func (b *CompositeBook) OnDepthChanged() {
+	if b.fnChanged != nil {
+		b.fnChanged()
+	}
+}
+
+// 至少有一个交易所的深度可用
+func (b *CompositeBook) Ready() bool {
+	for _, v := range b.readyVenues() {
+		if v.Market.DepthConsistent() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *CompositeBook) readyVenues() []BookVenue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	venues := make([]BookVenue, 0, len(b.venues))
+	for _, v := range b.venues {
+		if v.Market.Ready() {
+			venues = append(venues, v)
+		}
+	}
+	return venues
+}
+
+// 交易所深度数量折算为基础币数量
+func baseSize(m common.CommonMarket, price, size decimal.Decimal) decimal.Decimal {
+	if fm, ok := m.(common.FutureMarket); ok {
+		if fm.IsUsdtContract() {
+			return size.Mul(fm.ValueAmount())
+		} else if price.IsPositive() {
+			return size.Mul(fm.ValueAmount()).Div(price)
+		}
+	}
+	return size
+}
+
+// 合并后的前n档，n<=0表示全部。asks由低到高，bids由高到低，同价时按添加顺序
+func (b *CompositeBook) Levels(n int) (asks, bids []CompositeLevel) {
+	level := func(v BookVenue, l [2]decimal.Decimal, px decimal.Decimal) CompositeLevel {
+		return CompositeLevel{Venue: v.Name, Price: px, RawPrice: l[0], Size: baseSize(v.Market, l[0], l[1]), VenueSize: l[1]}
+	}
+
+	for _, v := range b.readyVenues() {
+		va, vb := v.Market.OrderBook().Levels(b.depth)
+		for _, l := range va {
+			px := l[0]
+			if b.feeAdjusted {
+				px = px.Mul(decimal.NewFromInt(1).Add(v.TakerFee))
+			}
+			asks = append(asks, level(v, l, px))
+		}
+		for _, l := range vb {
+			px := l[0]
+			if b.feeAdjusted {
+				px = px.Mul(decimal.NewFromInt(1).Sub(v.TakerFee))
+			}
+			bids = append(bids, level(v, l, px))
+		}
+	}
+
+	slices.SortStableFunc(asks, func(x, y CompositeLevel) int { return x.Price.Cmp(y.Price) })
+	slices.SortStableFunc(bids, func(x, y CompositeLevel) int { return y.Price.Cmp(x.Price) })
+	if n > 0 {
+		asks = asks[:min(n, len(asks))]
+		bids = bids[:min(n, len(bids))]
+	}
+	return
+}
+
+// 合并后的买一/卖一
+func (b *CompositeBook) Best() (bid, ask CompositeLevel, ok bool) {
+	asks, bids := b.Levels(0)
+	if len(asks) == 0 || len(bids) == 0 {
+		return CompositeLevel{}, CompositeLevel{}, false
+	}
+	return bids[0], asks[0], true
+}
+
+// 按合并深度吃单size数量(基础币)，各交易所的分配。深度不足时只分配可成交的部分
+// 各交易所的分配按下单精度向下取整，取整后不足最小下单量的交易所不分配，因此总量可能略小于size
+// dir为吃单方向，买入吃asks，卖出吃bids
+func (b *CompositeBook) Sweep(dir common.OrderDir, size decimal.Decimal) []CompositeAlloc {
+	asks, bids := b.Levels(0)
+	levels := asks
+	if dir == common.OrderDir_Sell {
+		levels = bids
+	}
+
+	markets := map[string]common.CommonMarket{}
+	for _, v := range b.readyVenues() {
+		markets[v.Name] = v.Market
+	}
+
+	// 先按合并深度分配，记录每个交易所吃到的档位(数量为交易所原始单位)
+	venues := []string{}
+	taken := map[string][]CompositeLevel{}
+	remain := size
+	for _, l := range levels {
+		if !remain.IsPositive() {
+			break
+		}
+
+		if _, ok := taken[l.Venue]; !ok {
+			venues = append(venues, l.Venue)
+		}
+
+		sz := decimal.Min(remain, l.Size)
+		if sz.LessThan(l.Size) {
+			l.VenueSize = l.VenueSize.Mul(sz).Div(l.Size)
+			l.Size = sz
+		}
+		taken[l.Venue] = append(taken[l.Venue], l)
+		remain = remain.Sub(sz)
+	}
+
+	// 按各交易所的精度取整，再从最优档开始重新累计均价
+	allocs := []CompositeAlloc{}
+	for _, venue := range venues {
+		m := markets[venue]
+		venueSize := decimal.Zero
+		for _, l := range taken[venue] {
+			venueSize = venueSize.Add(l.VenueSize)
+		}
+		venueSize = m.AlignSize(venueSize)
+		if !venueSize.IsPositive() || venueSize.LessThan(m.MinSize()) {
+			continue
+		}
+
+		a := CompositeAlloc{Venue: venue, Market: m, VenueSize: venueSize}
+		value := decimal.Zero
+		left := venueSize
+		for _, l := range taken[venue] {
+			if !left.IsPositive() {
+				break
+			}
+
+			vsz := decimal.Min(left, l.VenueSize)
+			bsz := baseSize(m, l.RawPrice, vsz)
+			a.Size = a.Size.Add(bsz)
+			a.WorstPrice = l.RawPrice
+			value = value.Add(bsz.Mul(l.RawPrice))
+			left = left.Sub(vsz)
+		}
+		a.AvgPrice = value.Div(a.Size)
+		allocs = append(allocs, a)
+	}
+	return allocs
+}
@@ -0,0 +1,423 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-29 14:26:50
+- @Description: 通用挂单网格引擎，基于common.CommonTrader，现货、合约均可使用
+- 在[LowPrice, HighPrice]之间按等差或等比划分档位，低于盘口的档位挂买单，高于盘口的档位挂卖单
+- 某档买单成交后，在上一档挂卖单；某档卖单成交后，在下一档挂买单
+- 网格状态持久化到StatePath，重启后按保存的各档方向重新挂单(交易所初始化时会撤销所有挂单)
+- 挂单价格按tick对齐(买单向下、卖单向上)。下单被拒绝的档位按指数退避重试
+- 下单时不持有锁，期间到达的成交先缓存，登记订单后再处理
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 状态文件格式版本
+const (
+	gridEngineStateKind    = "grid_engine_state"
+	gridEngineStateVersion = 1
+)
+
+// 下单被拒绝后的重试间隔
+const (
+	gridRetryMin = time.Second
+	gridRetryMax = time.Minute
+)
+
+func init() {
+	util.RegisterMigration(gridEngineStateKind, 0, util.NoopMigration)
+}
+
+type GridSpacing int
+
+const (
+	GridSpacing_Arithmetic GridSpacing = iota // 等差
+	GridSpacing_Geometric                     // 等比
+)
+
+type GridEngineConfig struct {
+	Spacing    GridSpacing       `json:"spacing"`
+	LowPrice   decimal.Decimal   `json:"low_px"`
+	HighPrice  decimal.Decimal   `json:"high_px"`
+	Levels     int               `json:"levels"`      // 档位数量，含上下边界
+	LevelSize  decimal.Decimal   `json:"level_size"`  // 每档数量
+	LevelSizes []decimal.Decimal `json:"level_sizes"` // 逐档数量(由低到高)，不为空时覆盖LevelSize
+	MakeOnly   bool              `json:"make_only"`
+	StatePath  string            `json:"state_path"` // 状态文件，为空表示不持久化
+}
+
+// 单个档位
+type GridLevelState struct {
+	Price  decimal.Decimal `json:"price"`
+	Size   decimal.Decimal `json:"size"`
+	Side   string          `json:"side"`   // 该档等待成交的方向：buy/sell，为空表示不挂单
+	Filled decimal.Decimal `json:"filled"` // 当前方向上已成交的数量
+}
+
+// 持久化的网格状态
+type GridEngineState struct {
+	Config     GridEngineConfig `json:"config"`
+	Levels     []GridLevelState `json:"levels"`
+	Rounds     int              `json:"rounds"` // 完成的买卖回合数
+	Profit     decimal.Decimal  `json:"profit"` // 按档位价差估算的网格利润
+	UpdateTime time.Time        `json:"update_time"`
+}
+
+type GridEngine struct {
+	logPrefix string
+	mu        sync.Mutex
+	trader    common.CommonTrader
+	state     GridEngineState
+	orders    map[int]common.Order // 档位-订单
+	orderLvl  map[common.Order]int
+	dirty     bool
+	fnDeal    func(level int, deal common.Deal)
+	chStop    chan int
+
+	placing    bool                           // 正在下单(不持有锁)
+	earlyDeals map[common.Order][]common.Deal // 下单期间、订单登记前到达的成交
+	retries    map[int]gridRetry              // 下单被拒绝的档位
+}
+
+type gridRetry struct {
+	wait time.Duration
+	at   time.Time
+}
+
+// 待下的单
+type gridPlace struct {
+	lvl   int
+	price decimal.Decimal
+	size  decimal.Decimal
+	dir   common.OrderDir
+}
+
+func (g *GridEngine) Init(trader common.CommonTrader, cfg GridEngineConfig) bool {
+	g.trader = trader
+	g.logPrefix = fmt.Sprintf("grid-engine-%s", trader.Market().Type())
+	g.orders = make(map[int]common.Order)
+	g.orderLvl = make(map[common.Order]int)
+	g.retries = make(map[int]gridRetry)
+	g.chStop = make(chan int, 1)
+
+	levels, ok := buildGridLevels(cfg)
+	if !ok {
+		logger.LogImportant(g.logPrefix, "invalid grid config, low=%v high=%v levels=%d", cfg.LowPrice, cfg.HighPrice, cfg.Levels)
+		return false
+	}
+
+	// 有状态文件且档位一致时恢复
+	if len(cfg.StatePath) > 0 {
+		if _, err := os.Stat(cfg.StatePath); err == nil {
+			saved := GridEngineState{}
+			if util.VersionedObjectFromFile(cfg.StatePath, gridEngineStateKind, gridEngineStateVersion, &saved) && sameGridLevels(saved.Levels, levels) {
+				saved.Config = cfg
+				g.state = saved
+				logger.LogImportant(g.logPrefix, "state resumed from %s, rounds=%d, profit=%v", cfg.StatePath, saved.Rounds, saved.Profit)
+				return true
+			}
+			logger.LogImportant(g.logPrefix, "state file %s not match current config, start over", cfg.StatePath)
+		}
+	}
+
+	g.state = GridEngineState{Config: cfg, Levels: levels}
+	return true
+}
+
+func buildGridLevels(cfg GridEngineConfig) ([]GridLevelState, bool) {
+	if cfg.Levels < 2 || !cfg.LowPrice.IsPositive() || !cfg.HighPrice.GreaterThan(cfg.LowPrice) {
+		return nil, false
+	}
+	if len(cfg.LevelSizes) > 0 && len(cfg.LevelSizes) != cfg.Levels {
+		return nil, false
+	}
+
+	levels := make([]GridLevelState, cfg.Levels)
+	n := decimal.NewFromInt(int64(cfg.Levels - 1))
+	step := cfg.HighPrice.Sub(cfg.LowPrice).Div(n)
+	ratio := cfg.HighPrice.Div(cfg.LowPrice).InexactFloat64()
+	for i := range levels {
+		if cfg.Spacing == GridSpacing_Geometric {
+			levels[i].Price = cfg.LowPrice.Mul(decimal.NewFromFloat(ratio).Pow(decimal.NewFromInt(int64(i)).Div(n)))
+		} else {
+			levels[i].Price = cfg.LowPrice.Add(step.Mul(decimal.NewFromInt(int64(i))))
+		}
+		levels[i].Size = util.ValueIf(len(cfg.LevelSizes) > 0, cfg.LevelSizes[i], cfg.LevelSize)
+	}
+	return levels, true
+}
+
+func sameGridLevels(a, b []GridLevelState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Price.Round(8).Equal(b[i].Price.Round(8)) || !a[i].Size.Equal(b[i].Size) {
+			return false
+		}
+	}
+	return true
+}
+
+// 成交回调
+func (g *GridEngine) SetDealFn(fn func(level int, deal common.Deal)) {
+	g.fnDeal = fn
+}
+
+func (g *GridEngine) State() GridEngineState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.state
+	s.Levels = append([]GridLevelState{}, g.state.Levels...)
+	return s
+}
+
+func (g *GridEngine) Go() {
+	go g.update()
+}
+
+// 撤销所有挂单并保存状态
+func (g *GridEngine) Stop() {
+	g.chStop <- 0
+}
+
+// 实现common.OrderObserver
+func (g *GridEngine) OnDeal(deal common.Deal) {
+	g.mu.Lock()
+	lvl, ok := g.orderLvl[deal.O]
+	if !ok {
+		if g.placing {
+			g.earlyDeals[deal.O] = append(g.earlyDeals[deal.O], deal)
+		}
+		g.mu.Unlock()
+		return
+	}
+
+	g.applyDeal(lvl, deal)
+	g.mu.Unlock()
+
+	if g.fnDeal != nil {
+		g.fnDeal(lvl, deal)
+	}
+}
+
+// 需加锁调用
+func (g *GridEngine) applyDeal(lvl int, deal common.Deal) {
+	l := &g.state.Levels[lvl]
+	l.Filled = l.Filled.Add(deal.Amount)
+	g.dirty = true
+	delete(g.retries, lvl)
+	if g.trader.Market().AlignSize(l.Size.Sub(l.Filled)).LessThan(g.trader.Market().MinSize()) {
+		g.rebalance(lvl)
+	}
+}
+
+// 某档成交完成，翻转到相邻档位。需加锁调用
+func (g *GridEngine) rebalance(lvl int) {
+	l := &g.state.Levels[lvl]
+	side := l.Side
+	l.Side = ""
+	l.Filled = decimal.Zero
+
+	next := util.ValueIf(side == "buy", lvl+1, lvl-1)
+	if next < 0 || next >= len(g.state.Levels) {
+		logger.LogImportant(g.logPrefix, "level %d %s filled at edge", lvl, side)
+		return
+	}
+
+	n := &g.state.Levels[next]
+	if len(n.Side) > 0 {
+		logger.LogImportant(g.logPrefix, "level %d %s filled, level %d already has %s pending", lvl, side, next, n.Side)
+		return
+	}
+
+	n.Side = util.ValueIf(side == "buy", "sell", "buy")
+	if side == "sell" {
+		g.state.Rounds++
+		g.state.Profit = g.state.Profit.Add(l.Price.Sub(n.Price).Mul(decimal.Min(l.Size, n.Size)))
+	}
+	logger.LogInfo(g.logPrefix, "level %d %s filled, arm %s at level %d(%v)", lvl, side, n.Side, next, n.Price)
+}
+
+func (g *GridEngine) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if g.trader.Ready() {
+				g.placeOrders()
+			}
+			g.save(false)
+		case <-g.chStop:
+			g.cancelAll()
+			g.save(true)
+			logger.LogImportant(g.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+func (g *GridEngine) placeOrders() {
+	places := g.prepareOrders()
+	if len(places) == 0 {
+		return
+	}
+
+	// 下单时不持有锁，成交回调可能在MakeOrder返回前到达
+	for _, p := range places {
+		o := g.trader.MakeOrder(p.price, p.size, p.dir, g.cfg().MakeOnly, false, fmt.Sprintf("grid%d", p.lvl), g)
+		g.onOrderMade(p.lvl, o)
+	}
+
+	g.mu.Lock()
+	g.placing = false
+	g.earlyDeals = nil
+	g.mu.Unlock()
+}
+
+// 整理需要下单的档位
+func (g *GridEngine) prepareOrders() []gridPlace {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ob := g.trader.Market().OrderBook()
+	buy1, sell1 := ob.Buy1Price(), ob.Sell1Price()
+	if !buy1.IsPositive() || !sell1.IsPositive() {
+		return nil
+	}
+
+	// 首次运行，按盘口分配各档方向
+	assigned := false
+	for _, l := range g.state.Levels {
+		if len(l.Side) > 0 {
+			assigned = true
+			break
+		}
+	}
+	if !assigned {
+		for i := range g.state.Levels {
+			l := &g.state.Levels[i]
+			if l.Price.LessThan(buy1) {
+				l.Side = "buy"
+			} else if l.Price.GreaterThan(sell1) {
+				l.Side = "sell"
+			}
+		}
+		g.dirty = true
+		logger.LogImportant(g.logPrefix, "levels assigned at buy1=%v sell1=%v", buy1, sell1)
+	}
+
+	now := time.Now()
+	places := []gridPlace{}
+	for i := range g.state.Levels {
+		l := &g.state.Levels[i]
+		if o, ok := g.orders[i]; ok {
+			if !o.IsFinished() {
+				continue
+			}
+			delete(g.orders, i)
+			delete(g.orderLvl, o)
+			if o.HasFatalError() {
+				g.reject(i)
+			} else {
+				delete(g.retries, i)
+			}
+		}
+
+		if len(l.Side) == 0 {
+			continue
+		}
+		if r, ok := g.retries[i]; ok && now.Before(r.at) {
+			continue
+		}
+
+		dir := util.ValueIf(l.Side == "buy", common.OrderDir_Buy, common.OrderDir_Sell)
+		places = append(places, gridPlace{
+			lvl:   i,
+			price: g.trader.Market().AlignPrice(l.Price, dir, false),
+			size:  l.Size.Sub(l.Filled),
+			dir:   dir,
+		})
+	}
+
+	if len(places) > 0 {
+		g.placing = true
+		g.earlyDeals = make(map[common.Order][]common.Deal)
+	}
+	return places
+}
+
+// 登记订单，并处理登记前到达的成交
+func (g *GridEngine) onOrderMade(lvl int, o common.Order) {
+	g.mu.Lock()
+	if o == nil {
+		g.reject(lvl)
+		g.mu.Unlock()
+		return
+	}
+
+	g.orders[lvl] = o
+	g.orderLvl[o] = lvl
+	deals := g.earlyDeals[o]
+	delete(g.earlyDeals, o)
+	for _, d := range deals {
+		g.applyDeal(lvl, d)
+	}
+	g.mu.Unlock()
+
+	if g.fnDeal != nil {
+		for _, d := range deals {
+			g.fnDeal(lvl, d)
+		}
+	}
+}
+
+// 下单被拒绝，退避后重试。需加锁调用
+func (g *GridEngine) reject(lvl int) {
+	r := g.retries[lvl]
+	r.wait = min(max(r.wait*2, gridRetryMin), gridRetryMax)
+	r.at = time.Now().Add(r.wait)
+	g.retries[lvl] = r
+	logger.LogInfo(g.logPrefix, "level %d order rejected, retry after %v", lvl, r.wait)
+}
+
+func (g *GridEngine) cfg() GridEngineConfig {
+	return g.state.Config
+}
+
+func (g *GridEngine) cancelAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, o := range g.orders {
+		if !o.IsFinished() {
+			o.Cancel()
+		}
+	}
+}
+
+func (g *GridEngine) save(force bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.cfg().StatePath) == 0 || (!g.dirty && !force) {
+		return
+	}
+
+	g.state.UpdateTime = time.Now()
+	if util.VersionedObjectToFile(g.cfg().StatePath, gridEngineStateKind, gridEngineStateVersion, g.state) {
+		g.dirty = false
+	}
+}
@@ -0,0 +1,275 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-30 15:18:42
+- @Description: 阶梯挂单(scaled orders)
+- 在NearPrice到FarPrice之间均匀挂出Count个同方向订单，各档数量按线性或指数权重分配，越远数量越大
+- 参数变化时整组替换：先撤销旧的一组并等待全部完结，再挂出新的一组，两组订单不会同时存在
+- 撤单超时未完结(如撤单请求失败)时会重新撤单
+- 下单时不持有锁，期间调用了Cancel的，新挂出的订单会立即撤销
+- 累计所有订单的成交数量和均价
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type LadderWeighting int
+
+const (
+	LadderWeighting_Linear      LadderWeighting = iota // 第i档权重为i+1
+	LadderWeighting_Exponential                        // 第i档权重为Factor^i
+)
+
+type LadderConfig struct {
+	Dir       common.OrderDir
+	NearPrice decimal.Decimal // 离盘口最近的一档
+	FarPrice  decimal.Decimal // 离盘口最远的一档
+	Count     int
+	TotalSize decimal.Decimal
+	Weighting LadderWeighting
+	Factor    decimal.Decimal // 指数权重的底数，默认1.5
+	MakeOnly  bool
+}
+
+// 撤单后超过这个时间仍未全部完结，重新撤单
+const ladderCancelTimeout = time.Second * 3
+
+type ladderLevel struct {
+	price decimal.Decimal
+	size  decimal.Decimal
+}
+
+type Ladder struct {
+	logPrefix string
+	mu        sync.Mutex
+	trader    common.CommonTrader
+	purpose   string
+
+	orders    []common.Order
+	pending   *LadderConfig // 等待生效的参数
+	canceling bool
+	cancelAt  time.Time // 最近一次撤单的时间
+	gen       int       // 每次Cancel加1，用于识别下单期间的撤销
+
+	filled    decimal.Decimal
+	filledVal decimal.Decimal
+	fnFill    func(filled, avgPrice decimal.Decimal)
+	chStop    chan int
+}
+
+func (l *Ladder) Init(trader common.CommonTrader, purpose string) {
+	l.trader = trader
+	l.purpose = purpose
+	l.logPrefix = fmt.Sprintf("ladder-%s-%s", trader.Market().Type(), purpose)
+	l.chStop = make(chan int, 1)
+}
+
+// 成交回调，参数为累计成交量和均价
+func (l *Ladder) SetFillFn(fn func(filled, avgPrice decimal.Decimal)) {
+	l.fnFill = fn
+}
+
+func (l *Ladder) Go() {
+	go l.update()
+}
+
+func (l *Ladder) Stop() {
+	l.chStop <- 0
+}
+
+// 设置(或替换)阶梯参数
+func (l *Ladder) Place(cfg LadderConfig) bool {
+	if _, ok := l.plan(cfg); !ok {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = &cfg
+	return true
+}
+
+// 撤销整组订单
+func (l *Ladder) Cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = nil
+	l.gen++
+	l.cancelAll()
+}
+
+// 累计成交量和均价
+func (l *Ladder) Filled() (filled, avgPrice decimal.Decimal) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.filled.IsPositive() {
+		avgPrice = l.filledVal.Div(l.filled)
+	}
+	return l.filled, avgPrice
+}
+
+// 当前挂单中的订单
+func (l *Ladder) LiveOrders() []common.Order {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	live := make([]common.Order, 0, len(l.orders))
+	for _, o := range l.orders {
+		if !o.IsFinished() {
+			live = append(live, o)
+		}
+	}
+	return live
+}
+
+// 实现common.OrderObserver
+func (l *Ladder) OnDeal(deal common.Deal) {
+	l.mu.Lock()
+	l.filled = l.filled.Add(deal.Amount)
+	l.filledVal = l.filledVal.Add(deal.Amount.Mul(deal.Price))
+	filled, avg := l.filled, l.filledVal.Div(l.filled)
+	l.mu.Unlock()
+
+	if l.fnFill != nil {
+		l.fnFill(filled, avg)
+	}
+}
+
+// 计算各档价格和数量
+func (l *Ladder) plan(cfg LadderConfig) ([]ladderLevel, bool) {
+	if cfg.Count <= 0 || !cfg.NearPrice.IsPositive() || !cfg.FarPrice.IsPositive() || !cfg.TotalSize.IsPositive() {
+		logger.LogImportant(l.logPrefix, "invalid ladder, count=%d near=%v far=%v size=%v", cfg.Count, cfg.NearPrice, cfg.FarPrice, cfg.TotalSize)
+		return nil, false
+	}
+
+	factor := util.ValueIf(cfg.Factor.IsPositive(), cfg.Factor, decimal.NewFromFloat(1.5))
+	weights := make([]decimal.Decimal, cfg.Count)
+	sum := decimal.Zero
+	for i := range weights {
+		if cfg.Weighting == LadderWeighting_Exponential {
+			weights[i] = factor.Pow(decimal.NewFromInt(int64(i)))
+		} else {
+			weights[i] = decimal.NewFromInt(int64(i + 1))
+		}
+		sum = sum.Add(weights[i])
+	}
+
+	m := l.trader.Market()
+	levels := make([]ladderLevel, 0, cfg.Count)
+	span := cfg.FarPrice.Sub(cfg.NearPrice)
+	for i := range weights {
+		px := cfg.NearPrice
+		if cfg.Count > 1 {
+			px = px.Add(span.Mul(decimal.NewFromInt(int64(i))).Div(decimal.NewFromInt(int64(cfg.Count - 1))))
+		}
+		sz := m.AlignSize(cfg.TotalSize.Mul(weights[i]).Div(sum))
+		if sz.GreaterThanOrEqual(m.MinSize()) {
+			levels = append(levels, ladderLevel{price: m.AlignPrice(px, cfg.Dir, cfg.MakeOnly), size: sz})
+		}
+	}
+
+	if len(levels) == 0 {
+		logger.LogImportant(l.logPrefix, "ladder size too small, total=%v, count=%d", cfg.TotalSize, cfg.Count)
+		return nil, false
+	}
+	return levels, true
+}
+
+// 需加锁调用
+func (l *Ladder) cancelAll() bool {
+	if l.canceling && time.Since(l.cancelAt) > ladderCancelTimeout {
+		logger.LogImportant(l.logPrefix, "cancel not finished in %v, retry", ladderCancelTimeout)
+		l.canceling = false
+	}
+
+	allFinished := true
+	for _, o := range l.orders {
+		if !o.IsFinished() {
+			allFinished = false
+			if !l.canceling {
+				o.Cancel()
+			}
+		}
+	}
+	if !allFinished && !l.canceling {
+		l.cancelAt = time.Now()
+	}
+	l.canceling = !allFinished
+	if allFinished {
+		l.orders = nil
+	}
+	return allFinished
+}
+
+func (l *Ladder) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.apply()
+		case <-l.chStop:
+			l.Cancel()
+			logger.LogImportant(l.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+// 旧的一组全部完结后，挂出新的一组
+func (l *Ladder) apply() {
+	l.mu.Lock()
+	if l.pending == nil {
+		if l.canceling {
+			l.cancelAll()
+		}
+		l.mu.Unlock()
+		return
+	}
+
+	if !l.cancelAll() || !l.trader.Ready() {
+		l.mu.Unlock()
+		return
+	}
+
+	cfg := *l.pending
+	l.pending = nil
+	gen := l.gen
+	l.mu.Unlock()
+
+	levels, ok := l.plan(cfg)
+	if !ok {
+		return
+	}
+
+	// 下单时不持有锁，成交回调可能在MakeOrder返回前到达
+	placed := 0
+	for i, lv := range levels {
+		o := l.trader.MakeOrder(lv.price, lv.size, cfg.Dir, cfg.MakeOnly, false, fmt.Sprintf("%s%d", l.purpose, i), l)
+		if o == nil {
+			continue
+		}
+
+		placed++
+		l.mu.Lock()
+		l.orders = append(l.orders, o)
+		if l.gen != gen {
+			// 下单期间被撤销
+			o.Cancel()
+			l.canceling = true
+			l.cancelAt = time.Now()
+		}
+		l.mu.Unlock()
+	}
+	logger.LogInfo(l.logPrefix, "ladder placed, %d/%d orders, %s %v~%v", placed, len(levels), common.OrderDir2Str(cfg.Dir), levels[0].price, levels[len(levels)-1].price)
+}
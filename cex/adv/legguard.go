@@ -0,0 +1,308 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-27 10:05:47
+- @Description: 多腿执行的原子性保护（价差、配对、路由等）
+- 登记各腿的订单后，持续按比例比较各腿成交量。某腿落后超过容忍度且持续grace时间，执行处理策略：
+- 立即市价补齐 / 在对手价用IOC重试 / 仅告警
+- 所有事件写日志，可选追加写入审计文件(每行一个json)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type LegOutPolicy int
+
+const (
+	LegOutPolicy_Hedge LegOutPolicy = iota // 立即市价补齐落后的腿，失败超过次数后告警
+	LegOutPolicy_Retry                     // 在对手价用IOC重试，失败超过次数后告警
+	LegOutPolicy_Alert                     // 仅告警
+)
+
+func (p LegOutPolicy) String() string {
+	switch p {
+	case LegOutPolicy_Hedge:
+		return "hedge"
+	case LegOutPolicy_Retry:
+		return "retry"
+	case LegOutPolicy_Alert:
+		return "alert"
+	default:
+		return "unknown"
+	}
+}
+
+type LegGuardConfig struct {
+	Policy      LegOutPolicy    `json:"policy"`
+	Grace       time.Duration   `json:"grace"`        // 不平衡持续多久后处理
+	Tolerance   decimal.Decimal `json:"tolerance"`    // 容忍的相对不平衡比例
+	MaxSlippage decimal.Decimal `json:"max_slippage"` // 市价补齐时的滑点保护
+	MaxRetry    int             `json:"max_retry"`
+	AuditPath   string          `json:"audit_path"` // 审计文件，为空则只写日志
+}
+
+// 审计事件
+type LegAuditEvent struct {
+	Time   time.Time `json:"time"`
+	Guard  string    `json:"guard"`
+	Leg    int       `json:"leg"`
+	Event  string    `json:"event"` // order/deal/imbalance/hedge/retry/alert/balanced
+	Detail string    `json:"detail"`
+}
+
+type guardedLeg struct {
+	trader  common.CommonTrader
+	dir     common.OrderDir
+	ratio   decimal.Decimal
+	filled  decimal.Decimal
+	orders  []common.Order
+	retries int
+}
+
+func (l *guardedLeg) hasLiveOrder() bool {
+	for _, o := range l.orders {
+		if !o.IsFinished() {
+			return true
+		}
+	}
+	return false
+}
+
+type LegGuard struct {
+	logPrefix      string
+	name           string
+	mu             sync.Mutex
+	cfg            LegGuardConfig
+	legs           []*guardedLeg
+	orderLeg       map[common.Order]int
+	imbalanceSince time.Time
+	alerted        bool
+	audit          []LegAuditEvent
+	fnAlert        func(ev LegAuditEvent)
+	chStop         chan int
+}
+
+func (g *LegGuard) Init(name string, cfg LegGuardConfig) {
+	if cfg.Grace <= 0 {
+		cfg.Grace = time.Second * 3
+	}
+	if cfg.Tolerance.IsZero() {
+		cfg.Tolerance = decimal.NewFromFloat(0.01)
+	}
+	if cfg.MaxRetry <= 0 {
+		cfg.MaxRetry = 3
+	}
+
+	g.name = name
+	g.cfg = cfg
+	g.logPrefix = "leg-guard-" + name
+	g.orderLeg = make(map[common.Order]int)
+	g.chStop = make(chan int, 1)
+}
+
+// 添加一条腿，ratio为该腿相对于一个单位组合的数量
+func (g *LegGuard) AddLeg(trader common.CommonTrader, dir common.OrderDir, ratio decimal.Decimal) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.legs = append(g.legs, &guardedLeg{trader: trader, dir: dir, ratio: ratio})
+	return len(g.legs) - 1
+}
+
+func (g *LegGuard) SetAlertFn(fn func(ev LegAuditEvent)) {
+	g.fnAlert = fn
+}
+
+// 登记某条腿上的订单
+func (g *LegGuard) Track(leg int, o common.Order) {
+	if o == nil {
+		return
+	}
+
+	g.mu.Lock()
+	g.legs[leg].orders = append(g.legs[leg].orders, o)
+	g.orderLeg[o] = leg
+	g.record(leg, "order", o.String())
+	g.mu.Unlock()
+
+	o.AddObserver(g)
+}
+
+func (g *LegGuard) Go() {
+	go g.update()
+}
+
+func (g *LegGuard) Stop() {
+	g.chStop <- 0
+}
+
+// 各腿相对于成交最多的腿的缺口（基础数量）
+func (g *LegGuard) Deficits() []decimal.Decimal {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.deficits()
+}
+
+func (g *LegGuard) Audit() []LegAuditEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]LegAuditEvent{}, g.audit...)
+}
+
+// 实现common.OrderObserver
+func (g *LegGuard) OnDeal(deal common.Deal) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if leg, ok := g.orderLeg[deal.O]; ok {
+		g.legs[leg].filled = g.legs[leg].filled.Add(deal.Amount)
+		g.record(leg, "deal", fmt.Sprintf("price=%v amount=%v total=%v", deal.Price, deal.Amount, g.legs[leg].filled))
+	}
+}
+
+func (g *LegGuard) deficits() []decimal.Decimal {
+	unit := decimal.Zero
+	for _, l := range g.legs {
+		if l.ratio.IsPositive() {
+			unit = decimal.Max(unit, l.filled.Div(l.ratio))
+		}
+	}
+
+	ds := make([]decimal.Decimal, len(g.legs))
+	for i, l := range g.legs {
+		ds[i] = decimal.Max(decimal.Zero, unit.Mul(l.ratio).Sub(l.filled))
+	}
+	return ds
+}
+
+// 需加锁调用
+func (g *LegGuard) record(leg int, event, detail string) LegAuditEvent {
+	ev := LegAuditEvent{Time: time.Now(), Guard: g.name, Leg: leg, Event: event, Detail: detail}
+	g.audit = append(g.audit, ev)
+	logger.LogImportant(g.logPrefix, "leg %d %s: %s", leg, event, detail)
+
+	if len(g.cfg.AuditPath) > 0 {
+		if b, err := json.Marshal(ev); err == nil {
+			util.MakeSureDirForFile(g.cfg.AuditPath)
+			if f, err := os.OpenFile(g.cfg.AuditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+				f.Write(append(b, '\n'))
+				f.Close()
+			}
+		}
+	}
+	return ev
+}
+
+func (g *LegGuard) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 200)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.check()
+		case <-g.chStop:
+			logger.LogInfo(g.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+func (g *LegGuard) check() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ds := g.deficits()
+	lagging := -1
+	for i, l := range g.legs {
+		target := ds[i].Add(l.filled)
+		if target.IsPositive() && ds[i].Div(target).GreaterThan(g.cfg.Tolerance) && !l.hasLiveOrder() {
+			lagging = i
+			break
+		}
+	}
+
+	if lagging < 0 {
+		if !g.imbalanceSince.IsZero() {
+			g.record(-1, "balanced", "")
+			g.imbalanceSince = time.Time{}
+			g.alerted = false
+			for _, l := range g.legs {
+				l.retries = 0
+			}
+		}
+		return
+	}
+
+	now := time.Now()
+	if g.imbalanceSince.IsZero() {
+		g.imbalanceSince = now
+		g.record(lagging, "imbalance", fmt.Sprintf("deficit=%v", ds[lagging]))
+		return
+	}
+
+	if now.Sub(g.imbalanceSince) < g.cfg.Grace {
+		return
+	}
+
+	l := g.legs[lagging]
+	deficit := l.trader.Market().AlignSize(ds[lagging])
+	if !deficit.IsPositive() {
+		return
+	}
+
+	if g.cfg.Policy == LegOutPolicy_Alert {
+		g.alert(lagging, fmt.Sprintf("deficit=%v", deficit))
+		return
+	}
+
+	if l.retries >= g.cfg.MaxRetry {
+		g.alert(lagging, fmt.Sprintf("%s exhausted, deficit=%v", g.cfg.Policy.String(), deficit))
+		return
+	}
+
+	l.retries++
+	if g.cfg.Policy == LegOutPolicy_Hedge {
+		o := l.trader.MakeMarketOrder(deficit, l.dir, false, g.cfg.MaxSlippage, false, "legout_hedge", g)
+		g.onLegOutOrder(lagging, "hedge", deficit, o)
+	} else {
+		ob := l.trader.Market().OrderBook()
+		px := util.ValueIf(l.dir == common.OrderDir_Buy, ob.Sell1Price(), ob.Buy1Price())
+		o := l.trader.MakeOrderTIF(px, deficit, l.dir, common.TimeInForce_IOC, false, "legout_retry", g)
+		g.onLegOutOrder(lagging, "retry", deficit, o)
+	}
+}
+
+// 需加锁调用
+func (g *LegGuard) onLegOutOrder(leg int, action string, deficit decimal.Decimal, o common.Order) {
+	if o == nil {
+		g.record(leg, action, fmt.Sprintf("order failed, deficit=%v", deficit))
+		return
+	}
+
+	g.legs[leg].orders = append(g.legs[leg].orders, o)
+	g.orderLeg[o] = leg
+	g.record(leg, action, fmt.Sprintf("deficit=%v order=%s", deficit, o.String()))
+}
+
+// 需加锁调用。同一次不平衡只告警一次
+func (g *LegGuard) alert(leg int, detail string) {
+	if g.alerted {
+		return
+	}
+	g.alerted = true
+	ev := g.record(leg, "alert", detail)
+	if g.fnAlert != nil {
+		go g.fnAlert(ev)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-26 09:51:18
+- @Description: 新币上线快速启动
+- 上线前lead时间加载品种信息、登记上线时间并订阅行情，上线时刻精确回调fnLive
+- 上线前的交易限制（只挂单、集合竞价等）由品种状态自动约束，到达上线时间后自动放开
+- 交易所需实现PrepareSpotListing
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type listingPreparer interface {
+	PrepareSpotListing(baseCcy, quoteCcy string, listTime time.Time) bool
+}
+
+type ListingStarter struct {
+	logPrefix string
+	mu        sync.Mutex
+	ex        common.CEx
+	baseCcy   string
+	quoteCcy  string
+	listTime  time.Time
+	lead      time.Duration
+	trader    common.SpotTrader
+	fnLive    func(trader common.SpotTrader)
+	fnReady   func(trader common.SpotTrader)
+	fnFail    func(reason string)
+	chStop    chan int
+}
+
+// lead：提前多久开始准备，默认1分钟
+func (l *ListingStarter) Init(ex common.CEx, baseCcy, quoteCcy string, listTime time.Time, lead time.Duration) {
+	l.ex = ex
+	l.baseCcy = baseCcy
+	l.quoteCcy = quoteCcy
+	l.listTime = listTime
+	l.lead = util.ValueIf(lead > 0, lead, time.Minute)
+	l.logPrefix = fmt.Sprintf("listing-%s-%s_%s", ex.Name(), baseCcy, quoteCcy)
+	l.chStop = make(chan int, 1)
+}
+
+// 到达上线时间时回调
+func (l *ListingStarter) SetLiveFn(fn func(trader common.SpotTrader)) {
+	l.fnLive = fn
+}
+
+// 上线后交易器首次就绪时回调
+func (l *ListingStarter) SetReadyFn(fn func(trader common.SpotTrader)) {
+	l.fnReady = fn
+}
+
+func (l *ListingStarter) SetFailFn(fn func(reason string)) {
+	l.fnFail = fn
+}
+
+func (l *ListingStarter) Trader() common.SpotTrader {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.trader
+}
+
+func (l *ListingStarter) Go() {
+	go l.run()
+}
+
+func (l *ListingStarter) Stop() {
+	l.chStop <- 0
+}
+
+// 等待到指定时间，返回false表示被停止
+func (l *ListingStarter) waitUntil(t time.Time) bool {
+	if d := time.Until(t); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-l.chStop:
+			return false
+		}
+	}
+	return true
+}
+
+func (l *ListingStarter) fail(reason string) {
+	logger.LogImportant(l.logPrefix, "failed: %s", reason)
+	if l.fnFail != nil {
+		l.fnFail(reason)
+	}
+}
+
+func (l *ListingStarter) run() {
+	defer util.DefaultRecover()
+
+	prep, ok := l.ex.(listingPreparer)
+	if !ok {
+		l.fail("exchange not support listing mode")
+		return
+	}
+
+	logger.LogImportant(l.logPrefix, "waiting, list time=%s, lead=%v", l.listTime.Format(time.RFC3339Nano), l.lead)
+	if !l.waitUntil(l.listTime.Add(-l.lead)) {
+		return
+	}
+
+	// 加载品种信息。新品种可能很晚才出现在交易所的列表里，持续重试到上线后一段时间
+	for !prep.PrepareSpotListing(l.baseCcy, l.quoteCcy, l.listTime) {
+		if time.Now().After(l.listTime.Add(time.Minute)) {
+			l.fail("instrument not found")
+			return
+		}
+		if !l.waitUntil(time.Now().Add(time.Second)) {
+			return
+		}
+	}
+
+	// 提前订阅
+	trader := l.ex.UseSpotTrader(l.baseCcy, l.quoteCcy)
+	if trader == nil {
+		l.fail("create trader failed")
+		return
+	}
+	l.mu.Lock()
+	l.trader = trader
+	l.mu.Unlock()
+	logger.LogImportant(l.logPrefix, "prepared, status=%s", trader.UnreadyReason())
+
+	// 粗等到上线前一小段时间，再用短定时器等到上线时刻，避免长定时器的误差累积，也不占用CPU自旋
+	if !l.waitUntil(l.listTime.Add(-time.Millisecond*20)) || !l.waitUntil(l.listTime) {
+		return
+	}
+
+	logger.LogImportant(l.logPrefix, "live")
+	if l.fnLive != nil {
+		l.fnLive(trader)
+	}
+
+	// 等待交易器就绪
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if trader.Ready() {
+				logger.LogImportant(l.logPrefix, "trader ready, %dms after open", time.Since(l.listTime).Milliseconds())
+				if l.fnReady != nil {
+					l.fnReady(trader)
+				}
+				return
+			}
+		case <-l.chStop:
+			return
+		}
+	}
+}
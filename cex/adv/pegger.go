@@ -0,0 +1,159 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-18 10:37:52
+- @Description: 追价挂单器。让一个只挂单(post-only)订单始终保持在买一/卖一之后N个tick
+- 盘口变化时修改或重挂订单，价格受交易所限价(maxBuyPrice/minSellPrice)和用户限价约束
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type Pegger struct {
+	logPrefix  string
+	mu         sync.Mutex
+	trader     common.CommonTrader
+	mk         *Maker
+	dir        common.OrderDir
+	size       decimal.Decimal
+	ticks      int             // 距离己方一档的tick数，0表示挂在一档上
+	limitPrice decimal.Decimal // 用户限价，买单不高于、卖单不低于这个价格。0表示不限制
+	reduceOnly bool
+	dirty      bool
+	running    bool
+	chStop     chan int
+}
+
+func (p *Pegger) Init(trader common.CommonTrader, ticks int, purpose string) {
+	p.trader = trader
+	p.ticks = ticks
+	p.logPrefix = fmt.Sprintf("pegger-%s-%s", trader.Market().Type(), purpose)
+	p.mk = new(Maker)
+	p.mk.Init(trader, true, true, true, 0, 0, purpose)
+	p.chStop = make(chan int, 1)
+}
+
+func (p *Pegger) SetDealFn(fn OnMakerOrderDeal) {
+	p.mk.SetDealFn(fn)
+}
+
+// 设置挂单目标。size为剩余需要挂出的数量，为0则撤单
+func (p *Pegger) Set(dir common.OrderDir, size, limitPrice decimal.Decimal, reduceOnly bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dir = dir
+	p.size = size
+	p.limitPrice = limitPrice
+	p.reduceOnly = reduceOnly
+	p.dirty = true
+}
+
+func (p *Pegger) Go() {
+	p.mu.Lock()
+	p.running = true
+	p.dirty = true
+	p.mu.Unlock()
+
+	p.trader.Market().AddDepthObserver(p)
+	p.mk.Go()
+	go p.update()
+}
+
+func (p *Pegger) Stop() {
+	p.trader.Market().RemoveDepthObserver(p)
+	p.chStop <- 0
+}
+
+func (p *Pegger) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// 实现common.DepthObserver
+func (p *Pegger) OnDepthChanged() {
+	p.mu.Lock()
+	p.dirty = true
+	p.mu.Unlock()
+}
+
+// 计算目标价格，不满足约束时返回0
+func (p *Pegger) targetPrice() decimal.Decimal {
+	m := p.trader.Market()
+	ob := m.OrderBook()
+	tick := m.TickSize()
+	offset := tick.Mul(decimal.NewFromInt(int64(p.ticks)))
+	bid, ask := ob.Buy1Price(), ob.Sell1Price()
+	if !tick.IsPositive() || !bid.IsPositive() || !ask.IsPositive() {
+		return decimal.Zero
+	}
+
+	px := decimal.Zero
+	if p.dir == common.OrderDir_Buy {
+		px = bid.Sub(offset)
+		_, maxPx := p.trader.BuyPriceRange()
+		if maxPx.IsPositive() {
+			px = decimal.Min(px, maxPx)
+		}
+		if p.limitPrice.IsPositive() {
+			px = decimal.Min(px, p.limitPrice)
+		}
+		px = decimal.Min(px, ask.Sub(tick)) // 保证不会吃单
+	} else if p.dir == common.OrderDir_Sell {
+		px = ask.Add(offset)
+		minPx, _ := p.trader.SellPriceRange()
+		if minPx.IsPositive() {
+			px = decimal.Max(px, minPx)
+		}
+		if p.limitPrice.IsPositive() {
+			px = decimal.Max(px, p.limitPrice)
+		}
+		px = decimal.Max(px, bid.Add(tick))
+	}
+
+	px = m.AlignPrice(px, p.dir, true)
+	if !px.IsPositive() || !common.PriceInRange(px, p.dir, p.trader) {
+		return decimal.Zero
+	}
+	return px
+}
+
+func (p *Pegger) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.dirty && p.trader.Ready() {
+				p.dirty = false
+				px := p.targetPrice()
+				if px.IsZero() || !p.size.IsPositive() {
+					p.mk.Modify(decimal.Zero, decimal.Zero, common.OrderDir_None, false)
+				} else {
+					p.mk.Modify(px, p.size, p.dir, p.reduceOnly)
+				}
+			}
+			p.mu.Unlock()
+		case <-p.chStop:
+			p.mk.Cancel()
+			p.mk.Stop()
+			p.mu.Lock()
+			p.running = false
+			p.mu.Unlock()
+			logger.LogInfo(p.logPrefix, "stopped")
+			return
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-03 10:20:00
+- @Description: 组合再平衡器。按目标权重调整多个现货交易器的持仓，用于指数类策略
+- 所有交易器需使用同一计价币种。偏离度超过阈值带才会调整，先卖后买，同方向内成本低的优先执行
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 参数
+type RebalancerConfig struct {
+	Weights       map[string]float64 `json:"weights"`         // 目标权重，key为交易币种(小写)。剩余部分为计价币
+	Band          float64            `json:"band"`            // 阈值带。实际权重与目标权重的偏离超过这个值才调整
+	MinTradeValue float64            `json:"min_trade_value"` // 单笔调整的最小价值(计价币)
+	MaxSlippage   float64            `json:"max_slippage"`    // 估算滑点超过这个值的调整暂不执行
+}
+
+// 一笔调整
+type RebalanceTrade struct {
+	Trader common.SpotTrader
+	Dir    common.OrderDir
+	Amount decimal.Decimal // 交易币数量
+	Value  decimal.Decimal // 计价币价值
+	Cost   decimal.Decimal // 估算成本率（滑点+手续费）
+}
+
+func (r RebalanceTrade) String() string {
+	return fmt.Sprintf("[%s %s amount:%v value:%v cost:%v]",
+		r.Trader.Market().Type(),
+		common.OrderDir2Str(r.Dir),
+		r.Amount,
+		r.Value.Round(2),
+		r.Cost.Round(6))
+}
+
+type Rebalancer struct {
+	logPrefix string
+	mu        sync.Mutex
+	cfg       RebalancerConfig
+	quoteCcy  string
+	traders   map[string]common.SpotTrader // baseCcy-trader
+	purpose   string
+
+	// 执行中的调整
+	pending []RebalanceTrade
+	taker   *Taker
+	running bool
+
+	fnFinish func()
+}
+
+func (r *Rebalancer) Init(traders []common.SpotTrader, cfg RebalancerConfig, purpose string) {
+	r.cfg = cfg
+	r.purpose = purpose
+	r.logPrefix = fmt.Sprintf("rebalancer-%s", purpose)
+	r.traders = make(map[string]common.SpotTrader)
+	for _, tr := range traders {
+		base := tr.SpotMarket().BaseCurrency()
+		quote := tr.SpotMarket().QuoteCurrency()
+		if len(r.quoteCcy) == 0 {
+			r.quoteCcy = quote
+		} else if r.quoteCcy != quote {
+			logger.LogPanic(r.logPrefix, "quote currency mismatch: %s vs %s", r.quoteCcy, quote)
+		}
+		r.traders[base] = tr
+	}
+
+	totalWeight := 0.0
+	for ccy, w := range cfg.Weights {
+		if _, ok := r.traders[ccy]; !ok {
+			logger.LogPanic(r.logPrefix, "no trader for ccy %s", ccy)
+		}
+		totalWeight += w
+	}
+
+	if totalWeight > 1 {
+		logger.LogPanic(r.logPrefix, "total weight %.4f > 1", totalWeight)
+	}
+
+	r.logPrefix = fmt.Sprintf("rebalancer-%s-%s", r.quoteCcy, purpose)
+	logger.LogInfo(r.logPrefix, "inited, weights=%v, band=%.4f", cfg.Weights, cfg.Band)
+}
+
+func (r *Rebalancer) SetFinishFn(fn func()) {
+	r.fnFinish = fn
+}
+
+func (r *Rebalancer) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// 当前组合总价值（计价币）
+func (r *Rebalancer) TotalValue() decimal.Decimal {
+	total := decimal.Zero
+	quoteCounted := false
+	for _, tr := range r.traders {
+		px := tr.Market().LatestPrice()
+		total = total.Add(tr.BaseBalance().Rights().Mul(px))
+		if !quoteCounted {
+			// 计价币权益是共享的，只计算一次
+			total = total.Add(tr.QuoteBalance().Rights())
+			quoteCounted = true
+		}
+	}
+	return total
+}
+
+// 当前各币种权重
+func (r *Rebalancer) CurrentWeights() map[string]float64 {
+	weights := make(map[string]float64)
+	total := r.TotalValue()
+	if total.IsZero() {
+		return weights
+	}
+
+	for ccy, tr := range r.traders {
+		value := tr.BaseBalance().Rights().Mul(tr.Market().LatestPrice())
+		weights[ccy] = value.Div(total).InexactFloat64()
+	}
+	return weights
+}
+
+// 计算需要的调整，不执行
+// 返回结果已排序：先卖后买，同方向成本低的在前
+func (r *Rebalancer) Plan() []RebalanceTrade {
+	trades := []RebalanceTrade{}
+	total := r.TotalValue()
+	if total.IsZero() {
+		return trades
+	}
+
+	for ccy, tr := range r.traders {
+		if !tr.Ready() {
+			logger.LogInfo(r.logPrefix, "trader %s not ready: %s", tr.Market().Type(), tr.UnreadyReason())
+			continue
+		}
+
+		px := tr.Market().LatestPrice()
+		if px.IsZero() {
+			continue
+		}
+
+		target := decimal.NewFromFloat(r.cfg.Weights[ccy]).Mul(total)
+		current := tr.BaseBalance().Rights().Mul(px)
+		diff := target.Sub(current)
+
+		// 阈值带以内不调整
+		if diff.Div(total).Abs().LessThan(decimal.NewFromFloat(r.cfg.Band)) {
+			continue
+		}
+
+		if diff.Abs().LessThan(decimal.NewFromFloat(r.cfg.MinTradeValue)) {
+			continue
+		}
+
+		t := RebalanceTrade{Trader: tr}
+		t.Dir = util.ValueIf(diff.IsPositive(), common.OrderDir_Buy, common.OrderDir_Sell)
+		t.Value = diff.Abs()
+		t.Amount = tr.Market().AlignSize(t.Value.Div(px))
+		if t.Amount.LessThan(tr.Market().MinSize()) {
+			continue
+		}
+
+		// 成本 = 盘口滑点 + taker手续费
+		ob := tr.Market().OrderBook()
+		execPx := decimal.Zero
+		if t.Dir == common.OrderDir_Buy {
+			execPx = ob.GetBuyPriceByAmount(t.Amount)
+		} else {
+			execPx = ob.GetSellPriceByAmount(t.Amount)
+		}
+
+		slippage := decimal.Zero
+		if execPx.IsPositive() {
+			slippage = util.DecimalDeviationAbs(execPx, px)
+		}
+
+		if r.cfg.MaxSlippage > 0 && slippage.GreaterThan(decimal.NewFromFloat(r.cfg.MaxSlippage)) {
+			logger.LogInfo(r.logPrefix, "skip %s, slippage too large: %v", t.String(), slippage)
+			continue
+		}
+
+		t.Cost = slippage.Add(tr.FeeTaker().Abs())
+		trades = append(trades, t)
+	}
+
+	sort.SliceStable(trades, func(i, j int) bool {
+		if trades[i].Dir != trades[j].Dir {
+			return trades[i].Dir == common.OrderDir_Sell
+		}
+		return trades[i].Cost.LessThan(trades[j].Cost)
+	})
+
+	return trades
+}
+
+// 计算并执行调整。上一次调整尚未结束时返回false
+func (r *Rebalancer) Rebalance() bool {
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return false
+	}
+
+	r.pending = r.Plan()
+	if len(r.pending) == 0 {
+		r.mu.Unlock()
+		return true
+	}
+
+	r.running = true
+	for _, t := range r.pending {
+		logger.LogInfo(r.logPrefix, "plan: %s", t.String())
+	}
+	r.mu.Unlock()
+
+	go r.execute()
+	return true
+}
+
+func (r *Rebalancer) execute() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mu.Lock()
+		if r.taker != nil {
+			if r.taker.Finished() {
+				r.taker.Stop()
+				logger.LogInfo(r.logPrefix, "trade finished, dealed=%v, price=%v", r.taker.Dealed(), r.taker.DealPrice())
+				r.taker = nil
+			}
+		}
+
+		if r.taker == nil {
+			if len(r.pending) == 0 {
+				r.running = false
+				r.mu.Unlock()
+				logger.LogInfo(r.logPrefix, "rebalance finished")
+				if r.fnFinish != nil {
+					r.fnFinish()
+				}
+				return
+			}
+
+			t := r.pending[0]
+			r.pending = r.pending[1:]
+
+			// 买单受可用计价币限制，卖单完成后再重新计算可买数量
+			amount := t.Amount
+			if t.Dir == common.OrderDir_Buy {
+				avail := t.Trader.AvailableAmount(common.OrderDir_Buy, t.Trader.Market().OrderBook().Sell1Price())
+				amount = t.Trader.Market().AlignSize(decimal.Min(amount, avail))
+			}
+
+			if amount.GreaterThanOrEqual(t.Trader.Market().MinSize()) {
+				r.taker = new(Taker)
+				r.taker.Init(t.Trader, amount, t.Dir, false, r.purpose, nil)
+				r.taker.Go()
+			} else {
+				logger.LogInfo(r.logPrefix, "skip %s, insufficient amount", t.String())
+			}
+		}
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,326 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-20 10:08:31
+- @Description: 交易时段订单流统计。在配置的时段分界点，为每个交易器输出一份汇总
+- 下单/成交/撤单数、挂单成交占比、成交量、手续费、盈亏、最大持仓、告警次数
+- 汇总会写日志、可选导出为json文件，并推送给外部注册的告警通道
+- 使用方式：MakeOrder之后调用OnOrderPlaced登记订单，不再使用时调用Stop
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 参数
+type SessionStatsConfig struct {
+	Boundaries []string `json:"boundaries"` // 时段分界点(本地时间)，如["00:00", "08:00", "16:00"]
+	ExportDir  string   `json:"export_dir"` // 汇总导出目录，为空则不导出
+}
+
+// 单个交易器的时段汇总
+type SessionSummary struct {
+	Trader       string    `json:"trader"`
+	SessionStart time.Time `json:"session_start"`
+	SessionEnd   time.Time `json:"session_end"`
+	Placed       int       `json:"placed"`
+	Filled       int       `json:"filled"`   // 有成交的订单数
+	Canceled     int       `json:"canceled"` // 未完全成交就结束的订单数
+	MakerRatio   float64   `json:"maker_ratio"`
+	Volume       float64   `json:"volume"`       // 成交数量
+	VolumeValue  float64   `json:"volume_value"` // 成交金额（计价币）
+	Fee          float64   `json:"fee"`          // 估算手续费（计价币）
+	PnL          float64   `json:"pnl"`          // 按最新价计算的时段盈亏（计价币，未扣手续费）
+	MaxPosition  float64   `json:"max_position"` // 时段内最大净持仓（绝对值）
+	Alerts       int       `json:"alerts"`
+}
+
+func (s SessionSummary) String() string {
+	return fmt.Sprintf("[%s] placed:%d filled:%d canceled:%d maker:%.1f%% vol:%.4f(%.2f) fee:%.4f pnl:%.4f maxpos:%.4f alerts:%d",
+		s.Trader, s.Placed, s.Filled, s.Canceled, s.MakerRatio*100, s.Volume, s.VolumeValue, s.Fee, s.PnL, s.MaxPosition, s.Alerts)
+}
+
+type SessionSummarySink func(summaries []SessionSummary)
+
+// 统计中的订单，结束后移出并计入filled/canceled
+type sessionOrder struct {
+	makeOnly bool
+	filled   bool // 本时段有成交
+}
+
+type traderSessionStats struct {
+	trader      common.CommonTrader
+	placed      int
+	filled      int
+	canceled    int
+	orders      map[common.Order]*sessionOrder
+	makerVolume decimal.Decimal
+	volume      decimal.Decimal
+	volumeValue decimal.Decimal
+	fee         decimal.Decimal
+	cashFlow    decimal.Decimal // 卖出为正，买入为负
+	position    decimal.Decimal
+	maxPosition decimal.Decimal
+	alerts      int
+}
+
+func newTraderSessionStats(trader common.CommonTrader) *traderSessionStats {
+	t := &traderSessionStats{trader: trader}
+	t.orders = make(map[common.Order]*sessionOrder)
+	return t
+}
+
+type SessionStats struct {
+	logPrefix    string
+	mu           sync.Mutex
+	cfg          SessionStatsConfig
+	boundaries   []int // 分界点，当日分钟数
+	sessionStart time.Time
+	traders      map[string]*traderSessionStats
+	orderTrader  map[common.Order]*traderSessionStats
+	sinks        []SessionSummarySink
+	stopOnce     sync.Once
+	chStop       chan int
+}
+
+func (s *SessionStats) Init(cfg SessionStatsConfig) {
+	s.logPrefix = "session-stats"
+	s.cfg = cfg
+	s.traders = make(map[string]*traderSessionStats)
+	s.orderTrader = make(map[common.Order]*traderSessionStats)
+	for _, b := range cfg.Boundaries {
+		if t, err := time.Parse("15:04", strings.TrimSpace(b)); err == nil {
+			s.boundaries = append(s.boundaries, t.Hour()*60+t.Minute())
+		} else {
+			logger.LogPanic(s.logPrefix, "invalid session boundary: %s", b)
+		}
+	}
+	s.sessionStart = time.Now()
+	s.chStop = make(chan int, 1)
+	go s.update()
+}
+
+// 停止定时统计，不再输出汇总
+func (s *SessionStats) Stop() {
+	s.stopOnce.Do(func() {
+		s.chStop <- 0
+	})
+}
+
+// 添加汇总的输出通道（告警等）
+func (s *SessionStats) AddSink(sink SessionSummarySink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sinks = append(s.sinks, sink)
+}
+
+// 登记新订单
+func (s *SessionStats) OnOrderPlaced(trader common.CommonTrader, o common.Order, makeOnly bool) {
+	if o == nil {
+		return
+	}
+
+	s.mu.Lock()
+	ts := s.getTrader(trader)
+	ts.placed++
+	ts.orders[o] = &sessionOrder{makeOnly: makeOnly}
+	s.orderTrader[o] = ts
+	s.mu.Unlock()
+
+	o.AddObserver(s)
+}
+
+// 记录一次告警
+func (s *SessionStats) OnAlert(trader common.CommonTrader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getTrader(trader).alerts++
+}
+
+func (s *SessionStats) getTrader(trader common.CommonTrader) *traderSessionStats {
+	key := trader.Market().Type()
+	ts, ok := s.traders[key]
+	if !ok {
+		ts = newTraderSessionStats(trader)
+		s.traders[key] = ts
+	}
+	return ts
+}
+
+// 实现common.OrderObserver
+func (s *SessionStats) OnDeal(deal common.Deal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.orderTrader[deal.O]
+	if !ok {
+		return
+	}
+
+	so, ok := ts.orders[deal.O]
+	if !ok {
+		return
+	}
+
+	value := deal.Amount.Mul(deal.Price)
+	isMaker := so.makeOnly
+	if !so.filled {
+		so.filled = true
+		ts.filled++
+	}
+	ts.volume = ts.volume.Add(deal.Amount)
+	ts.volumeValue = ts.volumeValue.Add(value)
+
+	if isMaker {
+		ts.makerVolume = ts.makerVolume.Add(deal.Amount)
+		ts.fee = ts.fee.Add(value.Mul(ts.trader.FeeMaker()))
+	} else {
+		ts.fee = ts.fee.Add(value.Mul(ts.trader.FeeTaker()))
+	}
+
+	if deal.O.GetDir() == common.OrderDir_Buy {
+		ts.cashFlow = ts.cashFlow.Sub(value)
+		ts.position = ts.position.Add(deal.Amount)
+	} else {
+		ts.cashFlow = ts.cashFlow.Add(value)
+		ts.position = ts.position.Sub(deal.Amount)
+	}
+	ts.maxPosition = decimal.Max(ts.maxPosition, ts.position.Abs())
+}
+
+// 立即生成当前时段的汇总（不会重置）
+func (s *SessionStats) Summaries() []SessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.summaries(time.Now())
+}
+
+// 移出已结束的订单，未完全成交的计为撤单
+func (s *SessionStats) evict() {
+	for _, ts := range s.traders {
+		for o := range ts.orders {
+			if o.IsFinished() {
+				if o.GetUnfilled().IsPositive() {
+					ts.canceled++
+				}
+				delete(ts.orders, o)
+				delete(s.orderTrader, o)
+			}
+		}
+	}
+}
+
+func (s *SessionStats) summaries(now time.Time) []SessionSummary {
+	s.evict()
+	sums := []SessionSummary{}
+	for key, ts := range s.traders {
+		sum := SessionSummary{
+			Trader:       key,
+			SessionStart: s.sessionStart,
+			SessionEnd:   now,
+			Placed:       ts.placed,
+			Filled:       ts.filled,
+			Canceled:     ts.canceled,
+			Volume:       ts.volume.InexactFloat64(),
+			VolumeValue:  ts.volumeValue.InexactFloat64(),
+			Fee:          ts.fee.InexactFloat64(),
+			MaxPosition:  ts.maxPosition.InexactFloat64(),
+			Alerts:       ts.alerts,
+		}
+
+		if ts.volume.IsPositive() {
+			sum.MakerRatio = ts.makerVolume.Div(ts.volume).InexactFloat64()
+		}
+
+		px := ts.trader.Market().LatestPrice()
+		sum.PnL = ts.cashFlow.Add(ts.position.Mul(px)).InexactFloat64()
+		sums = append(sums, sum)
+	}
+	return sums
+}
+
+// 当前时间所处时段的序号
+func (s *SessionStats) sessionIndex(t time.Time) int {
+	m := t.Hour()*60 + t.Minute()
+	index := -1
+	for i, b := range s.boundaries {
+		if m >= b {
+			index = i
+		}
+	}
+	return index
+}
+
+func (s *SessionStats) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastIndex := s.sessionIndex(time.Now())
+	lastDay := time.Now().YearDay()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.evict()
+			s.mu.Unlock()
+
+			index := s.sessionIndex(now)
+			if len(s.boundaries) > 0 && (index != lastIndex || (now.YearDay() != lastDay && len(s.boundaries) == 1)) {
+				s.emit(now)
+			}
+			lastIndex = index
+			lastDay = now.YearDay()
+		case <-s.chStop:
+			logger.LogInfo(s.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+// 输出汇总并开始新时段
+func (s *SessionStats) emit(now time.Time) {
+	s.mu.Lock()
+	sums := s.summaries(now)
+	sinks := s.sinks
+
+	// 重置。未结束的订单(summaries中已移出结束的)保留到下个时段继续统计
+	oldTraders := s.traders
+	s.traders = make(map[string]*traderSessionStats)
+	s.orderTrader = make(map[common.Order]*traderSessionStats)
+	for key, old := range oldTraders {
+		ts := newTraderSessionStats(old.trader)
+		ts.position = old.position
+		ts.maxPosition = old.position.Abs()
+		ts.cashFlow = old.position.Mul(old.trader.Market().LatestPrice()).Neg()
+		for o, so := range old.orders {
+			ts.orders[o] = &sessionOrder{makeOnly: so.makeOnly}
+			s.orderTrader[o] = ts
+		}
+		s.traders[key] = ts
+	}
+	s.sessionStart = now
+	s.mu.Unlock()
+
+	for _, sum := range sums {
+		logger.LogImportant(s.logPrefix, "session summary: %s", sum.String())
+	}
+
+	if len(s.cfg.ExportDir) > 0 && len(sums) > 0 {
+		path := fmt.Sprintf("%s/session_%s.json", s.cfg.ExportDir, now.Format("2006-01-02_15-04"))
+		util.ObjectToFile(path, sums)
+	}
+
+	for _, sink := range sinks {
+		sink(sums)
+	}
+}
@@ -0,0 +1,399 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-05 14:32:10
+- @Description: 合成品种。由若干真实品种按权重组合而成（价差、篮子），可以当作一个品种来看盘和交易
+- 交易时按份额切片，每片所有腿同时吃单，全部完成后再执行下一片。某条腿失败时停止并尝试补齐，以控制单腿风险
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package adv
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 合成品种的一条腿
+// Weight为每份合成品种对应的该腿数量，负数表示反向
+type SyntheticLeg struct {
+	Trader common.CommonTrader
+	Weight decimal.Decimal
+}
+
+// 合成品种
+type Synthetic struct {
+	name string
+	legs []SyntheticLeg
+}
+
+func NewSynthetic(name string, legs []SyntheticLeg) *Synthetic {
+	s := new(Synthetic)
+	s.name = name
+	s.legs = legs
+	return s
+}
+
+func (s *Synthetic) Name() string {
+	return s.name
+}
+
+func (s *Synthetic) Legs() []SyntheticLeg {
+	return s.legs
+}
+
+func (s *Synthetic) String() string {
+	sb := strings.Builder{}
+	for i, l := range s.legs {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(fmt.Sprintf("%v*%s", l.Weight, l.Trader.Market().Type()))
+	}
+	return fmt.Sprintf("%s[%s]", s.name, sb.String())
+}
+
+func (s *Synthetic) Ready() bool {
+	for _, l := range s.legs {
+		if !l.Trader.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// 买入一份合成品种的价格：正权重腿吃卖一，负权重腿吃买一
+func (s *Synthetic) BuyPrice() decimal.Decimal {
+	px := decimal.Zero
+	for _, l := range s.legs {
+		ob := l.Trader.Market().OrderBook()
+		legPx := util.ValueIf(l.Weight.IsPositive(), ob.Sell1Price(), ob.Buy1Price())
+		px = px.Add(legPx.Mul(l.Weight))
+	}
+	return px
+}
+
+// 卖出一份合成品种的价格：正权重腿吃买一，负权重腿吃卖一
+func (s *Synthetic) SellPrice() decimal.Decimal {
+	px := decimal.Zero
+	for _, l := range s.legs {
+		ob := l.Trader.Market().OrderBook()
+		legPx := util.ValueIf(l.Weight.IsPositive(), ob.Buy1Price(), ob.Sell1Price())
+		px = px.Add(legPx.Mul(l.Weight))
+	}
+	return px
+}
+
+func (s *Synthetic) MiddlePrice() decimal.Decimal {
+	px := decimal.Zero
+	for _, l := range s.legs {
+		px = px.Add(l.Trader.Market().OrderBook().MiddlePrice().Mul(l.Weight))
+	}
+	return px
+}
+
+// 盘口一档可成交的份数（受最薄的一条腿限制）
+func (s *Synthetic) TopSize(dir common.OrderDir) decimal.Decimal {
+	size := decimal.Zero
+	first := true
+	for _, l := range s.legs {
+		if l.Weight.IsZero() {
+			continue
+		}
+
+		ob := l.Trader.Market().OrderBook()
+		legDir := s.legDir(l, dir)
+		legSz := decimal.Zero
+		if legDir == common.OrderDir_Buy {
+			_, legSz = ob.Sell1()
+		} else {
+			_, legSz = ob.Buy1()
+		}
+
+		units := legSz.Div(l.Weight.Abs())
+		if first || units.LessThan(size) {
+			size = units
+			first = false
+		}
+	}
+	return size
+}
+
+// 合成出一个仅含一档的盘口
+func (s *Synthetic) OrderBook() *common.Orderbook {
+	ob := common.NewOrderBook()
+	ob.Rebuild(
+		[]decimal.Decimal{s.BuyPrice(), s.TopSize(common.OrderDir_Buy)},
+		[]decimal.Decimal{s.SellPrice(), s.TopSize(common.OrderDir_Sell)})
+	return ob
+}
+
+func (s *Synthetic) legDir(l SyntheticLeg, dir common.OrderDir) common.OrderDir {
+	if l.Weight.IsNegative() {
+		return util.ValueIf(dir == common.OrderDir_Buy, common.OrderDir_Sell, common.OrderDir_Buy)
+	}
+	return dir
+}
+
+// 合成品种交易器
+type SyntheticTrader struct {
+	logPrefix string
+	mu        sync.Mutex
+	syn       *Synthetic
+	purpose   string
+
+	clip         decimal.Decimal // 每片份数
+	maxLegRisk   decimal.Decimal // 允许的最大腿间偏差(份数)，超过则停止执行
+	amount       decimal.Decimal // 目标份数
+	dir          common.OrderDir
+	legDealed    []decimal.Decimal // 每条腿已成交数量
+	takers       []*Taker
+	running      bool
+	legRiskError bool
+
+	fnFinish func(dealedUnits decimal.Decimal, legRiskError bool)
+}
+
+func (t *SyntheticTrader) Init(syn *Synthetic, clip, maxLegRisk decimal.Decimal, purpose string) {
+	t.syn = syn
+	t.clip = clip
+	t.maxLegRisk = maxLegRisk
+	t.purpose = purpose
+	t.logPrefix = fmt.Sprintf("synthetic-%s-%s", syn.Name(), purpose)
+	t.legDealed = make([]decimal.Decimal, len(syn.legs))
+}
+
+func (t *SyntheticTrader) SetFinishFn(fn func(dealedUnits decimal.Decimal, legRiskError bool)) {
+	t.fnFinish = fn
+}
+
+func (t *SyntheticTrader) Running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running
+}
+
+// 按最慢的一条腿计算已成交份数
+func (t *SyntheticTrader) DealedUnits() decimal.Decimal {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dealedUnits()
+}
+
+func (t *SyntheticTrader) dealedUnits() decimal.Decimal {
+	units := decimal.Zero
+	first := true
+	for i, l := range t.syn.legs {
+		if l.Weight.IsZero() {
+			continue
+		}
+
+		u := t.legDealed[i].Div(l.Weight.Abs())
+		if first || u.LessThan(units) {
+			units = u
+			first = false
+		}
+	}
+	return units
+}
+
+// 腿间偏差(份数)
+func (t *SyntheticTrader) legRisk() decimal.Decimal {
+	minU, maxU := decimal.Zero, decimal.Zero
+	first := true
+	for i, l := range t.syn.legs {
+		if l.Weight.IsZero() {
+			continue
+		}
+
+		u := t.legDealed[i].Div(l.Weight.Abs())
+		if first {
+			minU, maxU = u, u
+			first = false
+		} else {
+			minU = decimal.Min(minU, u)
+			maxU = decimal.Max(maxU, u)
+		}
+	}
+	return maxU.Sub(minU)
+}
+
+// 开始交易amount份合成品种
+func (t *SyntheticTrader) Go(amount decimal.Decimal, dir common.OrderDir) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running {
+		return false
+	}
+
+	if !t.syn.Ready() {
+		logger.LogImportant(t.logPrefix, "synthetic not ready")
+		return false
+	}
+
+	t.amount = amount
+	t.dir = dir
+	t.legRiskError = false
+	for i := range t.legDealed {
+		t.legDealed[i] = decimal.Zero
+	}
+
+	t.running = true
+	logger.LogInfo(t.logPrefix, "start, %s %v units of %s", common.OrderDir2Str(dir), amount, t.syn.String())
+	go t.update()
+	return true
+}
+
+func (t *SyntheticTrader) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 50)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !t.step() {
+			return
+		}
+	}
+}
+
+// 返回false表示结束
+func (t *SyntheticTrader) step() bool {
+	t.mu.Lock()
+
+	// 等待本片所有腿结束
+	if len(t.takers) > 0 {
+		for _, tk := range t.takers {
+			if tk != nil && !tk.Finished() {
+				t.mu.Unlock()
+				return true
+			}
+		}
+
+		// Stop需要在锁外调用，防止与成交回调互相等待
+		takers := t.takers
+		t.takers = nil
+		t.mu.Unlock()
+		for _, tk := range takers {
+			if tk != nil {
+				tk.Stop()
+			}
+		}
+		t.mu.Lock()
+
+		if t.legRisk().GreaterThan(t.maxLegRisk) {
+			// 腿间偏差过大，尝试把落后的腿补齐一次，仍然不行则报错退出
+			if !t.legRiskError {
+				t.legRiskError = true
+				logger.LogImportant(t.logPrefix, "leg risk %v exceeds %v, try to fix", t.legRisk(), t.maxLegRisk)
+				sizes := t.fixSizes()
+				t.mu.Unlock()
+				t.startTakers(sizes)
+				return true
+			}
+
+			logger.LogImportant(t.logPrefix, "leg risk still too large: %v, stopped", t.legRisk())
+			return t.finish()
+		}
+		t.legRiskError = false
+	}
+
+	remain := t.amount.Sub(t.dealedUnits())
+	units := decimal.Min(remain, t.clip)
+	sizes, ok := t.clipSizes(units)
+	if !ok {
+		return t.finish()
+	}
+
+	t.mu.Unlock()
+	t.startTakers(sizes)
+	return true
+}
+
+// 下一片每条腿的数量。任何一条腿数量不足最小下单量时返回false
+func (t *SyntheticTrader) clipSizes(units decimal.Decimal) ([]decimal.Decimal, bool) {
+	if units.LessThanOrEqual(decimal.Zero) {
+		return nil, false
+	}
+
+	sizes := make([]decimal.Decimal, len(t.syn.legs))
+	for i, l := range t.syn.legs {
+		sizes[i] = l.Trader.Market().AlignSize(units.Mul(l.Weight.Abs()))
+		if !l.Weight.IsZero() && sizes[i].LessThan(l.Trader.Market().MinSize()) {
+			return nil, false
+		}
+	}
+	return sizes, true
+}
+
+// 把每条腿补齐到最快的那条腿的份数，返回每条腿需补的数量
+func (t *SyntheticTrader) fixSizes() []decimal.Decimal {
+	maxU := decimal.Zero
+	for i, l := range t.syn.legs {
+		if !l.Weight.IsZero() {
+			maxU = decimal.Max(maxU, t.legDealed[i].Div(l.Weight.Abs()))
+		}
+	}
+
+	sizes := make([]decimal.Decimal, len(t.syn.legs))
+	for i, l := range t.syn.legs {
+		if l.Weight.IsZero() {
+			continue
+		}
+
+		need := l.Trader.Market().AlignSize(maxU.Mul(l.Weight.Abs()).Sub(t.legDealed[i]))
+		if need.GreaterThanOrEqual(l.Trader.Market().MinSize()) {
+			sizes[i] = need
+		}
+	}
+	return sizes
+}
+
+// 不持有锁调用。Taker在Go中立即下单，成交回调(onLegDeal)可能在返回前到达
+func (t *SyntheticTrader) startTakers(sizes []decimal.Decimal) {
+	takers := make([]*Taker, len(t.syn.legs))
+	for i, l := range t.syn.legs {
+		if !l.Weight.IsZero() && sizes[i].IsPositive() {
+			takers[i] = t.newLegTaker(i, sizes[i])
+		}
+	}
+
+	t.mu.Lock()
+	t.takers = takers
+	t.mu.Unlock()
+}
+
+func (t *SyntheticTrader) newLegTaker(index int, size decimal.Decimal) *Taker {
+	l := t.syn.legs[index]
+	tk := new(Taker)
+	tk.Init(l.Trader, size, t.syn.legDir(l, t.dir), false, t.purpose, index)
+	tk.SetDealFn(t.onLegDeal)
+	tk.Go()
+	return tk
+}
+
+func (t *SyntheticTrader) onLegDeal(deal TakerDeal) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := deal.UserData.(int)
+	t.legDealed[index] = t.legDealed[index].Add(deal.Deal.Amount)
+}
+
+// 调用时需持有锁，返回时释放
+func (t *SyntheticTrader) finish() bool {
+	t.running = false
+	dealed := t.dealedUnits()
+	legRiskError := t.legRisk().GreaterThan(t.maxLegRisk)
+	t.mu.Unlock()
+
+	logger.LogInfo(t.logPrefix, "finished, dealed %v/%v units, legRiskError=%v", dealed, t.amount, legRiskError)
+	if t.fnFinish != nil {
+		t.fnFinish(dealed, legRiskError)
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-25 11:30:05
+- @Description: 币安断线撤单
+- 现货没有原生倒计时撤单，由本地看门狗撤单
+- 合约使用countdownCancelAll，按交易对设置，心跳停止后由交易所撤单
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package binance
+
+import (
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/api/binanceapi/binancefutureapi"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+// 开启现货断线撤单。策略主循环需定时调用返回值的Heartbeat
+func (e *Exchange) EnableDeadManSwitch(timeout time.Duration) *common.DeadManSwitch {
+	d := new(common.DeadManSwitch)
+	d.Init(exchangeName, timeout, nil, func() { e.CloseAllOrders() }, func() bool { return exchangeReady })
+	d.Go()
+	return d
+}
+
+// 开启合约断线撤单，使用当前账户对symbols设置倒计时撤单。策略主循环需定时调用返回值的Heartbeat
+func (e *Exchange) EnableFutureDeadManSwitch(timeout time.Duration, ac binancefutureapi.APIClass, symbols ...string) *common.DeadManSwitch {
+	d := new(common.DeadManSwitch)
+	d.Init(exchangeName+"-future", timeout, FutureCountdownArm(e.cred, ac, symbols...), nil, func() bool { return exchangeReady })
+	d.Go()
+	return d
+}
+
+// 合约原生倒计时撤单，可作为common.DeadManSwitch的fnArm。cred为nil时使用默认账户
+func FutureCountdownArm(cred *binanceapi.Credential, ac binancefutureapi.APIClass, symbols ...string) common.DeadManArmFn {
+	return func(timeout time.Duration) bool {
+		ok := true
+		for _, symbol := range symbols {
+			resp, err := binancefutureapi.CountdownCancelAll(cred, symbol, timeout.Milliseconds(), ac)
+			if err != nil {
+				logger.LogImportant(logPrefix, "countdown cancel all failed, symbol=%s, err=%s", symbol, err.Error())
+				ok = false
+			} else if resp.Code != 0 {
+				logger.LogImportant(logPrefix, "countdown cancel all failed, symbol=%s, code=%d, msg=%s", symbol, resp.Code, resp.Message)
+				ok = false
+			}
+		}
+		return ok
+	}
+}
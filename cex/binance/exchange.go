@@ -15,11 +15,13 @@ import (
 
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 
 	"github.com/aztecqt/dagger/api/binanceapi"
 	"github.com/aztecqt/dagger/api/binanceapi/binancespotapi"
 	"github.com/aztecqt/dagger/api/binanceapi/cachedbn"
 
+	"github.com/aztecqt/dagger/backtest"
 	"github.com/aztecqt/dagger/cex/common"
 	"github.com/emirpasic/gods/sets/hashset"
 )
@@ -27,6 +29,15 @@ import (
 const logPrefix = "Binance"
 const exchangeName = "Binance"
 
+// 现货下单/撤单频率预算(次/秒)的默认值，见SetOrderRateLimit
+const (
+	defaultOrderRateLimit = 10
+	defaultOrderRateBurst = 10
+)
+
+// 交易对状态的刷新间隔
+const spotStatusInterval = time.Minute * 5
+
 var exchangeReady = false
 
 type OnOrderSnapshotFn func(OrderSnapshot)
@@ -45,12 +56,44 @@ type Exchange struct {
 	// 交易品种
 	instrumentMgr *common.InstrumentMgr
 
+	// 现货详细盘口是否维护完整深度(增量推送+rest快照)，否则使用10档快照
+	fullDepth bool
+
+	// 深度降级为rest快照时，行情是否仍视为Ready
+	degradedDepthReady bool
+
+	// 订单操作队列
+	actionQueue    *common.ActionQueue
+	orderRateLimit int
+	orderRateBurst int
+
+	// 合约资金费率配置的缓存
+	fundingInfos fundingInfoCache
+
 	// 现货权益
 	spotBalanceMgr *common.BalanceMgr
 
 	// 现货订单更新的分发
 	spotOrderSnapshotFns map[string] /*spot-symbol*/ OnOrderSnapshotFn
 	muSpotOSFn           sync.Mutex
+
+	// 模拟交易(见SetPaperTrading)
+	paperCfg         *backtest.PaperConfig
+	paperAcc         *backtest.Account
+	paperSpotTraders map[string]*backtest.SimSpotTrader
+
+	// 代理(见SetProxy)
+	proxy string
+
+	// 启动、退出时不撤销挂单(见SetKeepOpenOrders)
+	keepOpenOrders bool
+
+	// 账户
+	signer binanceapi.Signer // 见SetSigner
+	cred   *binanceapi.Credential
+
+	// 行情、交易器的本地时间戳(见SetClock)
+	clock common.Clock
 }
 
 func (e *Exchange) Init(key, secret string, ecb func(e error)) {
@@ -63,27 +106,58 @@ func (e *Exchange) Init(key, secret string, ecb func(e error)) {
 
 	e.stratergyId = int(time.Now().Unix())
 	e.spotBalanceMgr = common.NewBalanceMgr(false)
+	e.spotBalanceMgr.SetExchangeName(exchangeName)
 	e.instrumentMgr = common.NewInstrumentMgr(logPrefix)
+	e.actionQueue = common.NewActionQueue(
+		exchangeName,
+		float64(util.ValueIf(e.orderRateLimit == 0, defaultOrderRateLimit, e.orderRateLimit)),
+		util.ValueIf(e.orderRateBurst == 0, defaultOrderRateBurst, e.orderRateBurst))
 	e.spotOrderSnapshotFns = make(map[string]OnOrderSnapshotFn)
 
 	// 初始化api
 	logger.LogImportant(logPrefix, "init api...")
-	binanceapi.Init(key, secret, binancespotapi.ServerTs)
+	if len(e.proxy) > 0 {
+		if err := network.SetProxyForUrls(e.proxy, binancespotapi.Urls()...); err != nil {
+			logger.LogPanic(logPrefix, "invalid proxy %s: %s", e.proxy, err.Error())
+		}
+	}
+	signer := e.signer
+	if signer == nil && len(secret) > 0 {
+		signer = binanceapi.NewHmacSigner(secret)
+	}
+	e.cred = binanceapi.NewCredential(key, signer, binancespotapi.ServerTs)
+	if binanceapi.SignerIns == nil {
+		// 第一个实例同时作为默认账户
+		binanceapi.InitWithSigner(key, signer, binancespotapi.ServerTs)
+	}
 	binanceapi.ErrorCallback = ecb
 
 	// 获取所有交易对列表
 	logger.LogImportant(logPrefix, "fetching spot instruments...")
 	e.initSpotInstruments("")
+	go e.updateSpotInstrumentStatus()
 
 	// 启动ws，订阅各种数据
 	logger.LogImportant(logPrefix, "starting spot websocket...")
 	e.wsSpot = new(binancespotapi.WsClient)
+	e.wsSpot.SetCredential(e.cred)
 	e.wsSpot.Start()
 
-	if binanceapi.HasKey() {
+	if e.paperCfg != nil {
+		// 模拟交易不使用真实账户
+		logger.LogImportant(logPrefix, "paper trading enabled, orders will be simulated locally")
+		e.paperAcc = e.paperCfg.NewAccount()
+		e.paperSpotTraders = make(map[string]*backtest.SimSpotTrader)
+	} else if e.cred.HasKey() {
 		// 关闭所有订单
-		logger.LogImportant(logPrefix, "close all spot orders...")
-		e.CloseAllOrders()
+		if e.keepOpenOrders {
+			logger.LogImportant(logPrefix, "keep open spot orders")
+		} else {
+			logger.LogImportant(logPrefix, "close all spot orders...")
+			if err := e.CloseAllOrders(); err != nil {
+				logger.LogPanic(logPrefix, "close all spot orders failed: %s", err.Error())
+			}
+		}
 
 		// 初始化现货账户权益
 		logger.LogImportant(logPrefix, "initializing spot account info...")
@@ -105,6 +179,8 @@ func (e *Exchange) initSpotInstruments(instId string) {
 			ins.Id = symbol.Symbol
 			ins.BaseCcy = strings.ToLower(symbol.BaseCcy)
 			ins.QuoteCcy = strings.ToLower(symbol.QuoteCcy)
+			ins.Status = spotSymbolStatus(symbol)
+			ins.QuotePrecision = symbol.QuotePrecision
 
 			if filter := symbol.FindFilterByType("PRICE_FILTER"); filter != nil {
 				if v, ok := filter["tickSize"]; ok {
@@ -139,6 +215,45 @@ func (e *Exchange) initSpotInstruments(instId string) {
 	}
 }
 
+// 定时刷新交易对状态（暂停交易、集合竞价等）
+// 全量的exchangeInfo很大且权重很高，只拉取正在使用的交易对
+func (e *Exchange) updateSpotInstrumentStatus() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(spotStatusInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		symbols := []string{}
+		for _, m := range e.spotMarketsSlice {
+			symbols = append(symbols, m.(*SpotMarket).instId)
+		}
+		if len(symbols) == 0 {
+			continue
+		}
+
+		resp, err := binancespotapi.GetExchangeInfo_SymbolList(symbols)
+		if err != nil {
+			logger.LogImportant(logPrefix, "refresh spot instrument status failed: %s", err.Error())
+			continue
+		}
+
+		for _, symbol := range resp.Symbols {
+			e.instrumentMgr.SetStatus(symbol.Symbol, spotSymbolStatus(symbol))
+		}
+	}
+}
+
+// 为新币上线做准备：确保品种信息已加载，并登记上线时间
+// 币安的交易对信息里没有上线时间，listTime必须指定
+func (e *Exchange) PrepareSpotListing(baseCcy, quoteCcy string, listTime time.Time) (ok bool) {
+	defer util.DefaultRecover() // 上线前查询交易对可能失败
+	instId := SpotTypeToInstId(baseCcy, quoteCcy)
+	if e.findOrGetSpotInstrument(instId) == nil {
+		return false
+	}
+	return e.instrumentMgr.SetListTime(instId, listTime)
+}
+
 func (e *Exchange) findOrGetSpotInstrument(instId string) *common.Instruments {
 	inst := e.instrumentMgr.Get(instId)
 	if inst != nil {
@@ -152,7 +267,7 @@ func (e *Exchange) findOrGetSpotInstrument(instId string) *common.Instruments {
 
 // 初始化现货账户权益
 func (e *Exchange) initSpotAccountInfo() {
-	accountInfo, err := binancespotapi.GetAccountInfo()
+	accountInfo, err := binancespotapi.GetAccountInfo(e.cred)
 	if err == nil {
 		ts := time.UnixMilli(accountInfo.Timestamp)
 		for _, v := range accountInfo.Balances {
@@ -210,35 +325,61 @@ func (e *Exchange) onWsOrderUpdate(msg interface{}) {
 
 // 撤销所有订单
 // 因为查询订单成本太高，这里就不用循环确认的方式了，而是采用一过性撤销，不检查结果
-func (e *Exchange) CloseAllOrders() {
+// 断线撤单、退出时也会调用，这里只记录并返回错误，由调用者决定是否退出进程
+func (e *Exchange) CloseAllOrders() error {
 	logger.LogImportant(logPrefix, "closing open orders...")
 
 	// 查询当前所有挂单
 	symbolset := hashset.New()
-	r0, emsg0, e0 := binancespotapi.GetOpenOrders("")
+	r0, emsg0, e0 := binancespotapi.GetOpenOrders(e.cred, "")
 	if e0 != nil {
-		logger.LogPanic(logPrefix, "GetOpenOrders failed: %s", e0.Error())
+		logger.LogImportant(logPrefix, "GetOpenOrders failed: %s", e0.Error())
+		return e0
 	} else if emsg0 != nil {
-		logger.LogPanic(logPrefix, "GetOpenOrders failed, code=%d, msg=%s", emsg0.Code, emsg0.Message)
+		logger.LogImportant(logPrefix, "GetOpenOrders failed, code=%d, msg=%s", emsg0.Code, emsg0.Message)
+		return fmt.Errorf("GetOpenOrders failed, code=%d, msg=%s", emsg0.Code, emsg0.Message)
 	}
 
 	for _, os := range *r0 {
 		symbolset.Add(os.Symbol)
 	}
 
+	var err error
 	symbols := symbolset.Values()
 	for _, v := range symbols {
 		symbol := v.(string)
 		logger.LogImportant(logPrefix, "closing %s...", symbol)
-		_, emsg1, e1 := binancespotapi.CancelOpenOrders(symbol)
+		_, emsg1, e1 := binancespotapi.CancelOpenOrders(e.cred, symbol)
 		if e1 != nil {
-			logger.LogPanic(logPrefix, "CancelOpenOrders failed: %s", e1.Error())
+			logger.LogImportant(logPrefix, "CancelOpenOrders %s failed: %s", symbol, e1.Error())
+			err = e1
 		} else if emsg1 != nil {
-			logger.LogPanic(logPrefix, "CancelOpenOrders failed, code:%d, msg:%s", emsg1.Code, emsg1.Message)
+			logger.LogImportant(logPrefix, "CancelOpenOrders %s failed, code:%d, msg:%s", symbol, emsg1.Code, emsg1.Message)
+			err = fmt.Errorf("CancelOpenOrders %s failed, code:%d, msg:%s", symbol, emsg1.Code, emsg1.Message)
 		}
 	}
 
-	logger.LogImportant(logPrefix, "all open orders closed")
+	if err == nil {
+		logger.LogImportant(logPrefix, "all open orders closed")
+	}
+	return err
+}
+
+func (e *Exchange) usePaperSpotTrader(instId, baseCcy, quoteCcy string) common.SpotTrader {
+	if t, ok := e.paperSpotTraders[instId]; ok {
+		return t
+	}
+
+	m := e.UseSpotMarket(baseCcy, quoteCcy)
+	if m == nil {
+		return nil
+	}
+
+	t := backtest.NewSpotTrader(exchangeName, e.paperAcc, m, e.instrumentMgr.Get(instId), e.instrumentMgr)
+	e.paperSpotTraders[instId] = t
+	e.spotTradersSlice = append(e.spotTradersSlice, t)
+	logger.LogImportant(logPrefix, "paper spot trader(%s) created", instId)
+	return t
 }
 
 // #region 实现common.CEx接口
@@ -270,6 +411,55 @@ func (e *Exchange) UseFutureTrader(symbol string, contractType string, lever int
 	return nil
 }
 
+// 开启模拟交易：照常订阅行情，订单在本地撮合，使用虚拟余额。需在Init之前调用
+func (e *Exchange) SetPaperTrading(cfg backtest.PaperConfig) {
+	e.paperCfg = &cfg
+}
+
+// 设置代理，如socks5://127.0.0.1:1080，只作用于binance现货的地址。需在Init之前调用
+func (e *Exchange) SetProxy(proxy string) {
+	e.proxy = proxy
+}
+
+// 启动和退出时不撤销账户的挂单(默认全部撤销)，账户上有其他程序的挂单时使用，此时交易器自己的订单需自行撤销。需在Init之前调用
+func (e *Exchange) SetKeepOpenOrders(b bool) {
+	e.keepOpenOrders = b
+}
+
+// 下单/撤单的频率预算(次/秒)和突发容量，0使用默认值，负数表示不限制。需在Init之前调用
+func (e *Exchange) SetOrderRateLimit(limit, burst int) {
+	e.orderRateLimit = limit
+	e.orderRateBurst = burst
+}
+
+// 使用Ed25519或RSA key，此时Init的secret不再使用。需在Init之前调用
+func (e *Exchange) SetSigner(signer binanceapi.Signer) {
+	e.signer = signer
+}
+
+// 当前账户，可用于直接调用binancespotapi中需要签名的接口
+func (e *Exchange) Credential() *binanceapi.Credential {
+	return e.cred
+}
+
+// 需在UseSpotMarket之前调用
+func (e *Exchange) SetFullDepth(b bool) {
+	e.fullDepth = b
+}
+
+func (e *Exchange) SetDegradedDepthReady(b bool) {
+	e.degradedDepthReady = b
+}
+
+// 行情、交易器打本地时间戳用的时钟，默认为本地时间。回放行情时可注入回放时钟，需在Init之前调用
+func (e *Exchange) SetClock(c common.Clock) {
+	e.clock = c
+}
+
+func (e *Exchange) now() time.Time {
+	return common.ClockOrReal(e.clock).Now()
+}
+
 func (e *Exchange) UseSpotMarket(baseCcy string, quoteCcy string) common.SpotMarket {
 	instId := SpotTypeToInstId(baseCcy, quoteCcy)
 
@@ -293,6 +483,10 @@ func (e *Exchange) UseSpotMarket(baseCcy string, quoteCcy string) common.SpotMar
 
 func (e *Exchange) UseSpotTrader(baseCcy string, quoteCcy string) common.SpotTrader {
 	instId := SpotTypeToInstId(baseCcy, quoteCcy)
+	if e.paperAcc != nil {
+		return e.usePaperSpotTrader(instId, baseCcy, quoteCcy)
+	}
+
 	t, ok := e.spotTraders[instId]
 	if ok {
 		return t
@@ -320,6 +514,9 @@ func (e *Exchange) GetAllPositions() []common.Position {
 }
 
 func (e *Exchange) GetAllBalances() []common.Balance {
+	if e.paperAcc != nil {
+		return e.paperAcc.Balances()
+	}
 	return []common.Balance{}
 }
 
@@ -357,6 +554,9 @@ func (e *Exchange) GetFutureDealHistory(symbol, contractType string, t0, t1 time
 }
 
 func (e *Exchange) GetSpotDealHistory(baseCcy, quoteCcy string, t0, t1 time.Time) []common.DealHistory {
+	if e.paperAcc != nil {
+		return e.paperAcc.DealHistory(SpotTypeToInstId(baseCcy, quoteCcy), t0, t1)
+	}
 	return nil
 }
 
@@ -491,7 +691,25 @@ func (e *Exchange) Exit() {
 	exchangeReady = false
 
 	// 撤销所有订单
-	e.CloseAllOrders()
+	if e.paperAcc != nil {
+		for _, t := range e.paperSpotTraders {
+			for _, o := range t.Orders() {
+				o.Cancel()
+			}
+		}
+	} else if !e.keepOpenOrders {
+		e.CloseAllOrders()
+	}
+	e.actionQueue.Stop()
+}
+
+// 实现common.HealthChecker
+func (e *Exchange) Ready() bool {
+	return exchangeReady
+}
+
+func (e *Exchange) UnreadyReason() string {
+	return util.ValueIf(exchangeReady, "", "exchange not ready")
 }
 
 // #endregion
@@ -0,0 +1,128 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-06 11:05:41
+- @Description: 币安合约资金费率详情。币安暂未实现FutureMarket，这里先提供与common.FundingDetail一致的取数接口
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package binance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/api/binanceapi/binancefutureapi"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 费率配置变化很慢，缓存起来定期刷新
+// rest请求在锁外进行，同一时间只有一个协程刷新，其余协程使用旧数据
+type fundingInfoCache struct {
+	mu         sync.Mutex
+	infos      map[string]binanceapi.FundingInfo
+	updateTime time.Time
+	refreshing bool
+}
+
+func (c *fundingInfoCache) get(symbol string) (binanceapi.FundingInfo, bool) {
+	c.mu.Lock()
+	needRefresh := !c.refreshing && (c.infos == nil || time.Since(c.updateTime) > time.Hour)
+	if needRefresh {
+		c.refreshing = true
+	}
+	c.mu.Unlock()
+
+	if needRefresh {
+		resp, err := binancefutureapi.GetFundingInfo(binancefutureapi.API_ClassicUsdt)
+		c.mu.Lock()
+		c.refreshing = false
+		if err != nil {
+			logger.LogImportant(logPrefix, "get funding info failed: %s", err.Error())
+		} else {
+			c.infos = make(map[string]binanceapi.FundingInfo)
+			for _, fi := range *resp {
+				c.infos[fi.Symbol] = fi
+			}
+			c.updateTime = time.Now()
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fi, ok := c.infos[symbol]
+	return fi, ok
+}
+
+// 获取U本位永续合约的资金费率详情
+// 币安的lastFundingRate为本期实时预测费率，不提供下期费率
+func (e *Exchange) GetFundingDetail(symbol, contractType string) (common.FundingDetail, bool) {
+	d := common.FundingDetail{}
+	if contractType != common.ContractType_UsdtSwap {
+		logger.LogImportant(logPrefix, "funding detail only support usdt_swap now, got %s", contractType)
+		return d, false
+	}
+
+	instId := CCyCttypeToInstId(symbol, contractType)
+	pi, err := binancefutureapi.GetPremiumIndex(instId, binancefutureapi.API_ClassicUsdt)
+	if err != nil {
+		logger.LogImportant(logPrefix, "get premium index of %s failed: %s", instId, err.Error())
+		return d, false
+	}
+
+	d.Rate = pi.LatestFr
+	d.FundingTime = pi.NextFundingTime
+	d.Interval = time.Hour * 8
+	if fi, ok := e.fundingInfos.get(instId); ok {
+		if fi.FundingIntervalHours > 0 {
+			d.Interval = time.Hour * time.Duration(fi.FundingIntervalHours)
+		}
+		d.RateCap = fi.AdjustedFundingRateCap
+		d.RateFloor = fi.AdjustedFundingRateFloor
+	}
+	d.NextFundingTime = d.FundingTime.Add(d.Interval)
+
+	return d, true
+}
+
+// 预测U本位永续合约本期的结算费率
+// 从本期开始拉取1分钟溢价指数K线作为采样，上期结算费率取自历史费率
+func (e *Exchange) GetFundingForecast(symbol, contractType string) (common.FundingForecast, bool) {
+	d, ok := e.GetFundingDetail(symbol, contractType)
+	if !ok {
+		return common.FundingForecast{}, false
+	}
+
+	instId := CCyCttypeToInstId(symbol, contractType)
+	p := common.FundingPredictor{}
+	p.Init(common.FundingSample_Premium)
+	p.SetDetail(d.Interval, d.RateCap, d.RateFloor)
+
+	prevRate := decimal.Zero
+	if hist, err := binancefutureapi.GetHistoryFundingRate(instId, time.Time{}, time.Time{}, 1, binancefutureapi.API_ClassicUsdt); err == nil && len(*hist) > 0 {
+		prevRate = (*hist)[0].FundingRate
+	}
+	p.SetFundingTime(d.FundingTime, prevRate)
+
+	t0 := d.FundingTime.Add(-d.Interval)
+	kl, err := binancefutureapi.GetPremiumIndexKline(instId, "1m", t0, time.Time{}, 1000, binancefutureapi.API_ClassicUsdt)
+	if err != nil {
+		logger.LogImportant(logPrefix, "get premium index kline of %s failed: %s", instId, err.Error())
+		return common.FundingForecast{}, false
+	}
+
+	// [开盘时间, 开盘, 最高, 最低, 收盘, ...]，以收盘值作为该分钟的采样
+	for _, raw := range *kl {
+		if len(raw) < 5 {
+			continue
+		}
+		if px, ok := raw[4].(string); ok {
+			p.OnSample(time.UnixMilli(raw[0].(int64)), util.String2DecimalPanic(px))
+		}
+	}
+
+	return p.Forecast(), true
+}
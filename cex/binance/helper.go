@@ -9,9 +9,12 @@ package binance
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"sync/atomic"
 
+	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/cex/common"
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
 )
@@ -96,3 +99,25 @@ func NewClientOrderId(purpose string) string {
 	newId := atomic.AddInt32(&accClientOrderId, 1)
 	return util.ToLetterNumberOnly(fmt.Sprintf("%05d%s", newId, purpose), 32)
 }
+
+// 币安交易对状态 -> 通用状态
+// 正常交易但不支持LIMIT、只支持LIMIT_MAKER时，视为只挂单阶段
+func spotSymbolStatus(symbol binanceapi.Symbol) common.InstrumentStatus {
+	switch symbol.Status {
+	case "TRADING":
+		if !symbol.SpotEnabled {
+			return common.InstrumentStatus_Halted
+		}
+		if len(symbol.OrderTypes) > 0 && !slices.Contains(symbol.OrderTypes, "LIMIT") && slices.Contains(symbol.OrderTypes, "LIMIT_MAKER") {
+			return common.InstrumentStatus_PostOnly
+		}
+		return common.InstrumentStatus_Trading
+	case "AUCTION_MATCH":
+		return common.InstrumentStatus_Auction
+	case "PRE_TRADING":
+		return common.InstrumentStatus_PreOpen
+	default:
+		// BREAK/HALT/POST_TRADING/END_OF_DAY
+		return common.InstrumentStatus_Halted
+	}
+}
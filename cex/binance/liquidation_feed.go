@@ -0,0 +1,66 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-03 11:02:16
+- @Description: 币安U本位合约全市场爆仓推送。币安暂未实现FutureMarket，这里独立订阅并统一为common.Liquidation
+- 币安每个品种每秒最多推送一笔强平，金额按累计成交量*成交均价计算
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package binance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/api/binanceapi/binancefutureapi"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type LiquidationFeed struct {
+	ws binancefutureapi.WsClient
+
+	mu  sync.Mutex
+	fns []func(l common.Liquidation)
+}
+
+func (f *LiquidationFeed) Init() {
+	f.ws.Start()
+}
+
+func (f *LiquidationFeed) Subscribe(fn func(l common.Liquidation)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fns = append(f.fns, fn)
+}
+
+func (f *LiquidationFeed) Go() {
+	f.ws.SubscribeForceOrder(f.onForceOrder, true)
+}
+
+func (f *LiquidationFeed) Stop() {
+	f.ws.UnsubscribeForceOrder(true)
+	logger.LogImportant(logPrefix, "liquidation feed stopped")
+}
+
+func (f *LiquidationFeed) onForceOrder(i interface{}) {
+	p := i.(*binanceapi.WSPayload_ForceOrder)
+	o := p.Order
+	l := common.Liquidation{
+		Exchange: exchangeName,
+		InstId:   o.Symbol,
+		Time:     time.UnixMilli(o.TradeTime),
+		Price:    o.AvgPrice,
+		Size:     o.FilledQty,
+		Notional: o.FilledQty.Mul(o.AvgPrice),
+		Dir:      util.ValueIf(o.Side == "BUY", common.OrderDir_Buy, common.OrderDir_Sell),
+	}
+
+	f.mu.Lock()
+	fns := f.fns
+	f.mu.Unlock()
+	for _, fn := range fns {
+		fn(l)
+	}
+}
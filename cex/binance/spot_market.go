@@ -10,9 +10,13 @@ package binance
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 
 	"github.com/aztecqt/dagger/api"
 	"github.com/aztecqt/dagger/api/binanceapi"
@@ -28,12 +32,29 @@ type SpotMarket struct {
 	instId        string
 	inst          common.Instruments
 	latestPrice   decimal.Decimal
+	ticker24h     common.Ticker24h
 	orderBook     *common.Orderbook
 	detailedDepth bool
 
 	priceOK bool
 	depthOK bool
 
+	// ws深度中断时，改为定期拉取rest快照(降级模式)
+	depthDegraded bool
+
+	// 完整深度：增量推送+rest快照，按lastUpdateId校验连续性
+	fullDepth       bool
+	muDepth         sync.Mutex
+	lastUpdateId    int64
+	depthConsistent bool
+	diffBuffer      []*binanceapi.WSPayload_DepthDiff // 快照完成前缓存的增量
+	waitFirstDiff   bool                              // 快照之后还没有应用过增量，第一条只需覆盖lastUpdateId+1
+	chResnapshot    chan int
+
+	// 逐笔成交回调
+	trades common.TradeHub
+	barHub common.BarHub
+
 	// 深度变化回调。策略的主要驱动之一
 	depthObserversSet *hashset.Set
 	depthObservers    []interface{}
@@ -47,6 +68,8 @@ func (m *SpotMarket) Init(ex *Exchange, instID string, detailedDepth bool) {
 	m.instId = instID
 	m.inst = *ex.instrumentMgr.Get(instID)
 	m.detailedDepth = detailedDepth
+	m.fullDepth = detailedDepth && ex.fullDepth
+	m.chResnapshot = make(chan int, 1)
 	m.orderBook = common.NewOrderBook()
 	m.priceOK = false
 	m.depthOK = false
@@ -54,6 +77,7 @@ func (m *SpotMarket) Init(ex *Exchange, instID string, detailedDepth bool) {
 	m.depthObserversSet = hashset.New()
 	m.depthObservers = nil
 	m.subscribing = false
+	m.barHub.SetClock(ex.clock)
 
 	// 执行频道订阅
 	m.subscribe(instID)
@@ -109,6 +133,7 @@ func (m *SpotMarket) subscribe(instID string) {
 					m.depthOK = false
 				}
 				s.Reset()
+				m.refreshTicker24hRest(instID)
 			case <-updateTicker.C:
 				if !m.subscribing {
 					break
@@ -117,10 +142,13 @@ func (m *SpotMarket) subscribe(instID string) {
 		}
 	}()
 
-	// 订阅深度（10秒没有盘口就判定失败）
-	if m.detailedDepth {
+	// 订阅深度（10秒没有盘口就重新订阅。3秒没有盘口、且连接上一段时间没有收到任何数据(含ping/pong)时，改为拉取rest快照，直到ws恢复）
+	if m.fullDepth {
+		go m.subscribeFullDepth(instID)
+	} else if m.detailedDepth {
 		go func() {
 			timeout := time.NewTicker(time.Second * 10)
+			timeoutREST := time.NewTicker(time.Second * 3)
 			updateTicker := time.NewTicker(time.Second)
 			s := m.ws.SubscribeDepth(instID, func(resp interface{}) {
 				depth := resp.(*binanceapi.WSPayload_Depth)
@@ -130,13 +158,24 @@ func (m *SpotMarket) subscribe(instID string) {
 					observer.(common.DepthObserver).OnDepthChanged()
 				}
 				timeout.Reset(time.Second * 10)
+				timeoutREST.Reset(time.Second * 3)
+				m.leaveDegraded()
 				m.depthOK = true
 			})
+			m.ws.SubscribeDepthState(instID, func(e network.ConnEvent) {
+				if e.State == network.ConnState_Disconnected {
+					m.depthOK = m.depthDegraded
+				}
+			})
 
 			for {
 				select {
+				case <-timeoutREST.C:
+					if m.depthStale(instID) {
+						m.depthOK = m.pollRestDepth(instID, 10)
+					}
 				case <-timeout.C:
-					m.depthOK = false
+					m.depthOK = m.depthDegraded
 					s.Reset()
 				case <-updateTicker.C:
 					if !m.subscribing {
@@ -152,27 +191,72 @@ func (m *SpotMarket) subscribe(instID string) {
 
 func (m *SpotMarket) unsubscribe(instID string) {
 	m.subscribing = false
-	if m.detailedDepth {
+	if m.fullDepth {
+		m.ws.UnsubscribeMiniTicker(instID)
+		m.ws.UnsubscribeDepthDiff(instID)
+	} else if m.detailedDepth {
 		m.ws.UnsubscribeMiniTicker(instID)
 		m.ws.UnsubscribeDepth(instID)
 	} else {
 		m.ws.UnsubscribeTicker(instID)
 	}
 
+	if m.trades.Len() > 0 {
+		m.ws.UnsubscribeAggTrade(instID)
+	}
+
 }
 
 func (m *SpotMarket) onTickerResp(ticker *binanceapi.WSPayload_Ticker) {
 	m.latestPrice = ticker.LatestPrice // 最新成交价
+	m.ticker24h = common.Ticker24h{
+		Time:      time.UnixMilli(ticker.TimeStamp),
+		Open:      ticker.Open,
+		High:      ticker.High,
+		Low:       ticker.Low,
+		Last:      ticker.LatestPrice,
+		Volume:    ticker.Volume,
+		VolumeUsd: ticker.VolumeUsd,
+	}
 
 	// ticker模拟深度
 	if !m.detailedDepth {
 		m.orderBook.UpdateAsk(ticker.Buy1, ticker.Buy1Size)
 		m.orderBook.UpdateAsk(ticker.Sell1, ticker.Sell1Size)
+		m.orderBook.Updated()
 	}
 }
 
 func (m *SpotMarket) onMiniTickerResp(ticker *binanceapi.WSPayload_MiniTicker) {
 	m.latestPrice = ticker.LatestPrice // 最新成交价
+	m.ticker24h = common.Ticker24h{
+		Time:      time.UnixMilli(ticker.TimeStamp),
+		Open:      ticker.Open,
+		High:      ticker.High,
+		Low:       ticker.Low,
+		Last:      ticker.LatestPrice,
+		Volume:    ticker.Volume,
+		VolumeUsd: ticker.VolumeUsd,
+	}
+}
+
+// ticker推送中断时，用rest刷新24小时统计
+func (m *SpotMarket) refreshTicker24hRest(instID string) {
+	resp, err := binancespotapi.Get24hrTicker(instID)
+	if err != nil || len(*resp) == 0 {
+		return
+	}
+
+	tk := (*resp)[0]
+	m.ticker24h = common.Ticker24h{
+		Time:      m.ex.now(),
+		Open:      tk.OpenPrice,
+		High:      tk.HighPrice,
+		Low:       tk.LowPrice,
+		Last:      tk.LastPrice,
+		Volume:    tk.Volume,
+		VolumeUsd: tk.VolumeQuote,
+	}
 }
 
 func (m *SpotMarket) onDepthResp(resp *binanceapi.WSPayload_Depth) {
@@ -186,9 +270,248 @@ func (m *SpotMarket) onDepthResp(resp *binanceapi.WSPayload_Depth) {
 	for _, depthUnit := range resp.Bids {
 		m.orderBook.UpdateBids(depthUnit[0], depthUnit[1])
 	}
+	m.orderBook.Updated()
+}
+
+func (m *SpotMarket) onAggTrade(resp interface{}) {
+	d := resp.(*binanceapi.WSPayload_AggTrade)
+	t := common.PublicTrade{
+		LocalTime: m.ex.now(),
+		UTime:     time.UnixMilli(d.TradeTime),
+		Id:        strconv.FormatInt(d.AggTradeId, 10),
+		Price:     d.Price,
+		Size:      d.Quantity,
+		Dir:       util.ValueIf(d.BuyerIsMaker, common.OrderDir_Sell, common.OrderDir_Buy),
+	}
+
+	m.trades.Publish(t)
+}
+
+// 完整深度。先缓存增量推送，再拉取rest快照，丢弃快照之前的增量后依次应用
+// 快照后的第一条增量需覆盖lastUpdateId+1，之后增量的U必须等于上一条的u+1，否则判定为缺口，重新拉取快照
+func (m *SpotMarket) subscribeFullDepth(instID string) {
+	defer util.DefaultRecover()
+	timeout := time.NewTicker(time.Second * 10)
+	timeoutREST := time.NewTicker(time.Second * 3)
+	updateTicker := time.NewTicker(time.Second)
+	s := m.ws.SubscribeDepthDiff(instID, func(resp interface{}) {
+		if m.onDepthDiff(resp.(*binanceapi.WSPayload_DepthDiff)) {
+			for _, observer := range m.depthObservers {
+				observer.(common.DepthObserver).OnDepthChanged()
+			}
+			timeout.Reset(time.Second * 10)
+			timeoutREST.Reset(time.Second * 3)
+			m.leaveDegraded()
+			m.depthOK = true
+		}
+	})
+
+	// 连接断开时增量序列必然中断，立即作废本地深度，重连后重新拉取快照对齐
+	m.ws.SubscribeDepthState(instID, func(e network.ConnEvent) {
+		if e.State == network.ConnState_Disconnected {
+			m.muDepth.Lock()
+			m.depthOK = m.depthDegraded
+			m.depthConsistent = false
+			m.diffBuffer = nil
+			m.muDepth.Unlock()
+		}
+	})
+
+	for {
+		select {
+		case <-timeoutREST.C:
+			if m.depthStale(instID) {
+				m.depthOK = m.pollRestDepth(instID, 100)
+			}
+		case <-timeout.C:
+			m.muDepth.Lock()
+			m.depthOK = m.depthDegraded
+			m.depthConsistent = false
+			m.diffBuffer = nil
+			m.muDepth.Unlock()
+			s.Reset()
+		case <-m.chResnapshot:
+			m.resnapshot(instID)
+		case <-updateTicker.C:
+			if !m.subscribing {
+				return
+			}
+		}
+	}
+}
+
+// 深度连接是否中断。只是没有盘口变化(冷门交易对)时，上一次检查发出的ping会收到pong，不算中断
+func (m *SpotMarket) depthStale(instID string) bool {
+	t := m.ws.DepthLastRecvTime(instID)
+	m.ws.PingDepth(instID)
+	return t.IsZero() || time.Since(t) > time.Second*5
+}
+
+// 降级模式：用rest快照重建盘口。完整深度模式下同时作废本地的增量序列，ws恢复后重新对齐
+func (m *SpotMarket) pollRestDepth(instID string, limit int) bool {
+	snap, err := binancespotapi.GetDepth(instID, limit)
+	if err != nil || snap.Code != 0 {
+		if m.depthDegraded {
+			logger.LogImportant(logPrefix, "%s rest depth fallback failed", instID)
+		}
+		m.depthDegraded = false
+		return false
+	}
+
+	m.muDepth.Lock()
+	m.depthConsistent = false
+	m.diffBuffer = nil
+	m.orderBook.Clear()
+	for _, depthUnit := range snap.Asks {
+		m.orderBook.UpdateAsk(depthUnit[0], depthUnit[1])
+	}
+	for _, depthUnit := range snap.Bids {
+		m.orderBook.UpdateBids(depthUnit[0], depthUnit[1])
+	}
+	m.orderBook.Updated()
+	m.muDepth.Unlock()
+
+	if !m.depthDegraded {
+		logger.LogImportant(logPrefix, "%s depth websocket stalled, fallback to rest snapshots", instID)
+		m.depthDegraded = true
+	}
+
+	for _, observer := range m.depthObservers {
+		observer.(common.DepthObserver).OnDepthChanged()
+	}
+	return true
+}
+
+func (m *SpotMarket) leaveDegraded() {
+	if m.depthDegraded {
+		logger.LogImportant(logPrefix, "%s depth websocket recovered", m.instId)
+		m.depthDegraded = false
+	}
+}
+
+// 是否处于降级模式(深度来自定期拉取的rest快照)
+func (m *SpotMarket) DepthDegraded() bool {
+	return m.depthDegraded
+}
+
+func (m *SpotMarket) requestResnapshot() {
+	select {
+	case m.chResnapshot <- 0:
+	default:
+	}
+}
+
+// 返回盘口是否有更新
+func (m *SpotMarket) onDepthDiff(d *binanceapi.WSPayload_DepthDiff) bool {
+	m.muDepth.Lock()
+	defer m.muDepth.Unlock()
+
+	if !m.depthConsistent {
+		if len(m.diffBuffer) < 1000 {
+			m.diffBuffer = append(m.diffBuffer, d)
+		}
+		m.requestResnapshot()
+		return false
+	}
+
+	// 快照后的第一条增量需满足U <= lastUpdateId+1 <= u，之前的丢弃。之后每条的U必须等于上一条的u+1
+	next := m.lastUpdateId + 1
+	continuous := d.FirstUpdateId == next
+	if m.waitFirstDiff {
+		if d.FinalUpdateId < next {
+			return false
+		}
+		continuous = d.FirstUpdateId <= next
+	}
+
+	if !continuous {
+		logger.LogImportant(logPrefix, "%s depth gap detected, U=%d, u=%d, local lastUpdateId=%d, resnapshot", m.instId, d.FirstUpdateId, d.FinalUpdateId, m.lastUpdateId)
+		m.depthConsistent = false
+		m.diffBuffer = []*binanceapi.WSPayload_DepthDiff{d}
+		m.requestResnapshot()
+		return false
+	}
+
+	m.waitFirstDiff = false
+	m.applyDepthDiff(d)
+	return true
+}
+
+func (m *SpotMarket) applyDepthDiff(d *binanceapi.WSPayload_DepthDiff) {
+	for _, depthUnit := range d.Asks {
+		m.orderBook.UpdateAsk(depthUnit[0], depthUnit[1])
+	}
+
+	for _, depthUnit := range d.Bids {
+		m.orderBook.UpdateBids(depthUnit[0], depthUnit[1])
+	}
+	m.orderBook.Updated()
+	m.lastUpdateId = d.FinalUpdateId
+}
+
+// 拉取rest快照，并应用缓存的增量
+func (m *SpotMarket) resnapshot(instID string) {
+	snap, err := binancespotapi.GetDepth(instID, 1000)
+	if err != nil || snap.Code != 0 {
+		logger.LogImportant(logPrefix, "%s get depth snapshot failed", instID)
+		return // 下一条增量推送时会再次触发
+	}
+
+	m.muDepth.Lock()
+	defer m.muDepth.Unlock()
+	if m.depthConsistent {
+		return
+	}
+
+	// 丢弃快照之前的增量。快照比缓存的增量还旧时，等待下一次
+	buffer := m.diffBuffer
+	for len(buffer) > 0 && buffer[0].FinalUpdateId <= snap.LastUpdateId {
+		buffer = buffer[1:]
+	}
+	if len(buffer) > 0 && buffer[0].FirstUpdateId > snap.LastUpdateId+1 {
+		logger.LogInfo(logPrefix, "%s depth snapshot too old(%d < %d), retry", instID, snap.LastUpdateId, buffer[0].FirstUpdateId)
+		m.diffBuffer = nil
+		return
+	}
+
+	m.orderBook.Clear()
+	for _, depthUnit := range snap.Asks {
+		m.orderBook.UpdateAsk(depthUnit[0], depthUnit[1])
+	}
+	for _, depthUnit := range snap.Bids {
+		m.orderBook.UpdateBids(depthUnit[0], depthUnit[1])
+	}
+	m.orderBook.Updated()
+	m.lastUpdateId = snap.LastUpdateId
+
+	for i, d := range buffer {
+		if i > 0 && d.FirstUpdateId != m.lastUpdateId+1 {
+			logger.LogImportant(logPrefix, "%s buffered depth not continuous, resnapshot", instID)
+			m.diffBuffer = nil
+			return
+		}
+		m.applyDepthDiff(d)
+	}
+
+	m.diffBuffer = nil
+	m.waitFirstDiff = len(buffer) == 0
+	m.depthConsistent = true
+	logger.LogInfo(logPrefix, "%s depth snapshot applied, lastUpdateId=%d, %d buffered diffs", instID, m.lastUpdateId, len(buffer))
 }
 
 // #region 实现common.Common_Market
+func (m *SpotMarket) SubscribeTrades(fn func(t common.PublicTrade)) int {
+	return m.trades.Add(fn, func() { m.ws.SubscribeAggTrade(m.instId, m.onAggTrade) })
+}
+
+func (m *SpotMarket) UnsubscribeTrades(id int) {
+	m.trades.Remove(id, func() { m.ws.UnsubscribeAggTrade(m.instId) })
+}
+
+func (m *SpotMarket) Bars(cfg common.BarConfig) *common.BarBuilder {
+	return m.barHub.Use(cfg, m.SubscribeTrades)
+}
+
 func (m *SpotMarket) Type() string {
 	return m.instId
 }
@@ -206,13 +529,21 @@ func (m *SpotMarket) String() string {
 	return bb.String()
 }
 
+// 降级模式下默认不可交易，可通过Exchange.SetDegradedDepthReady允许
 func (m *SpotMarket) Ready() bool {
-	return m.depthOK
+	depthReady := m.DepthConsistent() || m.depthOK && m.depthDegraded && m.ex.degradedDepthReady
+	return depthReady && m.ex.instrumentMgr.Status(m.instId).Tradable()
 }
 
 func (m *SpotMarket) UnreadyReason() string {
-	if !m.depthOK {
+	if status := m.ex.instrumentMgr.Status(m.instId); !status.Tradable() {
+		return "instrument " + status.String()
+	} else if !m.depthOK {
 		return "depth not ready"
+	} else if m.depthDegraded {
+		return "depth degraded, using rest snapshots"
+	} else if !m.DepthConsistent() {
+		return "depth not consistent"
 	} else {
 		return ""
 	}
@@ -230,10 +561,23 @@ func (m *SpotMarket) LatestPrice() decimal.Decimal {
 	return m.latestPrice
 }
 
+func (m *SpotMarket) Ticker24h() common.Ticker24h {
+	return m.ticker24h
+}
+
 func (m *SpotMarket) OrderBook() *common.Orderbook {
 	return m.orderBook
 }
 
+func (m *SpotMarket) DepthConsistent() bool {
+	if m.fullDepth {
+		return m.depthOK && m.depthConsistent
+	} else if m.detailedDepth {
+		return m.depthOK && !m.depthDegraded
+	}
+	return m.depthOK
+}
+
 func (m *SpotMarket) AlignPriceNumber(price decimal.Decimal) decimal.Decimal {
 	return m.ex.instrumentMgr.AlignPriceNumber(m.instId, price)
 }
@@ -258,4 +602,8 @@ func (m *SpotMarket) MinSize() decimal.Decimal {
 	return m.ex.instrumentMgr.MinSize(m.instId, m.orderBook.Buy1Price())
 }
 
+func (m *SpotMarket) TickSize() decimal.Decimal {
+	return m.ex.instrumentMgr.TickSize(m.instId)
+}
+
 // #endregion
@@ -13,8 +13,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aztecqt/dagger/util/logger"
-
 	"github.com/aztecqt/dagger/api/binanceapi"
 	"github.com/aztecqt/dagger/api/binanceapi/binancespotapi"
 	"github.com/aztecqt/dagger/cex/common"
@@ -34,6 +32,9 @@ type SpotOrder struct {
 	muRefresh        sync.Mutex
 	tkRefreshTimeout *time.Ticker
 	chRefreshImm     chan int
+
+	actionQueue *common.ActionQueue
+	cred        *binanceapi.Credential
 }
 
 // 初始化
@@ -41,17 +42,42 @@ func (o *SpotOrder) Init(
 	trader *SpotTrader,
 	price, amount decimal.Decimal,
 	dir common.OrderDir,
-	makeOnly bool,
+	tif common.TimeInForce,
 	purpose string) bool {
 	o.CltOrderId = NewClientOrderId(purpose)
-	return o.OrderImpl.Init(
+	o.LogFields.Exchange = exchangeName
+	o.actionQueue = trader.exchange.actionQueue
+	o.cred = trader.exchange.cred
+	return o.OrderImpl.InitTIF(
 		trader,
 		trader.exchange.instrumentMgr,
 		trader.Market().Type(),
 		price,
 		amount,
 		dir,
-		makeOnly,
+		tif,
+		false,
+		purpose)
+}
+
+// 市价单。quoteAmount为正时按计价币数量下单(quoteOrderQty)
+func (o *SpotOrder) InitMarket(
+	trader *SpotTrader,
+	guardPrice, amount, quoteAmount decimal.Decimal,
+	dir common.OrderDir,
+	purpose string) bool {
+	o.CltOrderId = NewClientOrderId(purpose)
+	o.LogFields.Exchange = exchangeName
+	o.actionQueue = trader.exchange.actionQueue
+	o.cred = trader.exchange.cred
+	return o.OrderImpl.InitMarket(
+		trader,
+		trader.exchange.instrumentMgr,
+		trader.Market().Type(),
+		guardPrice,
+		amount,
+		quoteAmount,
+		dir,
 		false,
 		purpose)
 }
@@ -75,7 +101,7 @@ func (o *SpotOrder) IsSupportModify() bool {
 }
 
 func (o *SpotOrder) Modify(newPrice, newSize decimal.Decimal) {
-	logger.LogPanic(o.LogPrefix, "modify not supported")
+	o.LogFields.LogStrict(o.LogPrefix, "modify not supported")
 }
 
 func (o *SpotOrder) Cancel() {
@@ -101,30 +127,62 @@ func (o *SpotOrder) create() {
 		side = "SELL"
 	}
 
-	logger.LogInfo(o.LogPrefix, "creating [%s]", o.String())
-	resp, err := binancespotapi.MakeOrder(o.InstId, side, "LIMIT", o.CltOrderId.(string), o.Price, o.Size)
+	// 只挂单使用LIMIT_MAKER，此时不能指定timeInForce
+	orderType := "LIMIT"
+	tif := o.TimeInForce.String()
+	if o.TimeInForce == common.TimeInForce_GTX {
+		orderType = "LIMIT_MAKER"
+		tif = ""
+	}
+
+	o.LogFields.LogInfo(o.LogPrefix, "creating [%s]", o.String())
+	var resp *binanceapi.MakeOrderResponse_Ack
+	var err error
+	o.RecordPlace()
+	o.actionQueue.Do(common.ActionPriority_Place, func() {
+		if o.MarketOrder {
+			resp, err = binancespotapi.MakeMarketOrder(
+				o.cred,
+				o.InstId,
+				side,
+				o.CltOrderId.(string),
+				o.InstrumentMgr.FormatSize(o.InstId, o.Size),
+				util.ValueIf(o.QuoteSize.IsPositive(), o.InstrumentMgr.FormatQuoteSize(o.InstId, o.QuoteSize), ""))
+		} else {
+			resp, err = binancespotapi.MakeOrder(
+				o.cred,
+				o.InstId,
+				side,
+				orderType,
+				tif,
+				o.CltOrderId.(string),
+				o.InstrumentMgr.FormatPrice(o.InstId, o.Price),
+				o.InstrumentMgr.FormatSize(o.InstId, o.Size))
+		}
+	})
 	if err == nil {
 		if resp.Code == 0 && len(resp.Message) == 0 {
 			if resp.OrderID > 0 {
 				// 创建成功
 				o.OrderId = resp.OrderID
-				logger.LogInfo(o.LogPrefix, "create success, order id = %v", o.OrderId)
+				o.LogFields.LogInfo(o.LogPrefix, "create success, order id = %v", o.OrderId)
+				o.RecordAck()
 			} else {
 				// 订单id缺失，应该是不会出现这种情况
 				o.ErrMsg = "create success but missing order id"
 				o.FatalError = true
-				logger.LogImportant(o.LogPrefix, "create order error, missing order id ")
+				o.LogFields.LogImportant(o.LogPrefix, "create order error, missing order id ")
 			}
 		} else {
 			// 订单创建失败
 			o.ErrMsg = fmt.Sprintf("create failed, code=%d, msg=%s", resp.Code, resp.Message)
 			o.FatalError = true
-			logger.LogImportant(o.LogPrefix, "create order error: %s", o.ErrMsg)
+			o.LogFields.LogImportant(o.LogPrefix, "create order error: %s", o.ErrMsg)
 		}
 	} else {
 		// 网络错误不代表订单未创建成功
 		// 应该查询时返回“订单不存在”作为订单错误的触发条件
-		logger.LogImportant(o.LogPrefix, "create order with rest error: %s", err.Error())
+		o.LogFields.LogImportant(o.LogPrefix, "create order with rest error: %s", err.Error())
 	}
 }
 
@@ -138,18 +196,23 @@ func (o *SpotOrder) cancel() {
 			o.canceling = false
 		}()
 
-		logger.LogInfo(o.LogPrefix, "canceling [%s]", o.String())
-		resp, err := binancespotapi.CancelOrder(o.InstId, 0, o.CltOrderId.(string))
+		o.LogFields.LogInfo(o.LogPrefix, "canceling [%s]", o.String())
+		o.RecordCancel()
+		var resp *binanceapi.CancelOrderResponse
+		var err error
+		o.actionQueue.Do(common.ActionPriority_Cancel, func() {
+			resp, err = binancespotapi.CancelOrder(o.cred, o.InstId, 0, o.CltOrderId.(string))
+		})
 		if err == nil {
 			if resp.Code != 0 || len(resp.Message) > 0 {
 				o.ErrMsg = fmt.Sprintf("code:%d, msg:%s", resp.Code, resp.Message)
-				logger.LogImportant(o.LogPrefix, "cancel order error: %s", o.ErrMsg)
+				o.LogFields.LogImportant(o.LogPrefix, "cancel order error: %s", o.ErrMsg)
 				time.Sleep(time.Second)
 			} else {
-				logger.LogInfo(o.LogPrefix, "cancel responsed")
+				o.LogFields.LogInfo(o.LogPrefix, "cancel responsed")
 			}
 		} else {
-			logger.LogImportant(o.LogPrefix, "cancel order with rest error: %s", err.Error())
+			o.LogFields.LogImportant(o.LogPrefix, "cancel order with rest error: %s", err.Error())
 			time.Sleep(time.Second)
 		}
 	}
@@ -169,15 +232,22 @@ func (o *SpotOrder) onSnapshot(os OrderSnapshot) {
 		if o.OrderId == 0 {
 			o.OrderId = os.OrderID
 		} else if o.OrderId > 0 && o.OrderId != os.OrderID {
-			logger.LogPanic(o.LogPrefix, "order id not match! o=%s, new id=%d", o.String(), os.OrderID)
+			// 不属于本订单的快照，丢弃
+			o.LogFields.LogStrict(o.LogPrefix, "order id not match! o=%s, new id=%d", o.String(), os.OrderID)
+			return
 		}
 
 		if o.CltOrderId != os.ClientOrderID {
-			logger.LogPanic(o.LogPrefix, "order client-id not match! o=%s, new id=%s", o.String(), os.ClientOrderID)
+			o.LogFields.LogStrict(o.LogPrefix, "order client-id not match! o=%s, new id=%s", o.String(), os.ClientOrderID)
+			return
+		}
+		o.RecordAck()
+		if os.Source == "ws" {
+			o.RecordWsUpdate(os.LocalTime)
 		}
 
 		// 刷新数据
-		logger.LogInfo(o.LogPrefix, "recv order snapshot:%s", os.String())
+		o.LogFields.LogInfo(o.LogPrefix, "recv order snapshot:%s", os.String())
 		if os.UpdateTime.UnixMilli() >= o.UpdateTime.UnixMilli() && os.FilledSize.GreaterThanOrEqual(o.Filled) {
 
 			deal = common.Deal{O: o, LocalTime: os.LocalTime, UTime: os.UpdateTime}
@@ -198,26 +268,30 @@ func (o *SpotOrder) onSnapshot(os OrderSnapshot) {
 			o.Filled = os.FilledSize
 
 			if deal.Price.IsPositive() && deal.Amount.IsPositive() {
-				logger.LogInfo(
+				o.LogFields.LogInfo(
 					o.LogPrefix,
 					"order dealing, dir=%s, price=%v, amount=%v, time=%v",
 					common.OrderDir2Str(o.Dir), deal.Price, deal.Amount, deal.UTime)
 
 				// 回调外部
+				o.RecordDeal(deal)
 				for _, obs := range o.Observers {
 					if obs != nil {
 						obs.OnDeal(deal)
 					}
 				}
+				common.PublishDeal(exchangeName, deal)
 			}
 
 			// 注意一定要等外部回调结束后，再置订单完成状态
 			finished := o.Status == binanceapi.OrderStatus_Canceled || o.Status == binanceapi.OrderStatus_Filled
 			if !o.Finished && finished {
 				o.Finished = finished
-				logger.LogInfo(o.LogPrefix, "order finished")
+				o.LogFields.LogInfo(o.LogPrefix, "order finished")
+				o.RecordFinish()
+				common.PublishOrderUpdate(exchangeName, o, o.UpdateTime)
 			} else if o.Finished && !finished {
-				logger.LogImportant(o.LogPrefix, "order already finished but try set to unfinished? impossible!")
+				o.LogFields.LogImportant(o.LogPrefix, "order already finished but try set to unfinished? impossible!")
 			}
 
 			o.refreshCount++
@@ -231,10 +305,10 @@ func (o *SpotOrder) refreshImm() {
 }
 
 func (o *SpotOrder) doRestRefresh() {
-	logger.LogInfo(o.LogPrefix, "geting order info from rest...")
-	resp, err := binancespotapi.GetOrder(o.InstId, 0, o.CltOrderId.(string))
+	o.LogFields.LogInfo(o.LogPrefix, "geting order info from rest...")
+	resp, err := binancespotapi.GetOrder(o.cred, o.InstId, 0, o.CltOrderId.(string))
 	b, _ := json.Marshal(resp)
-	logger.LogInfo(o.LogPrefix, "getted order info from rest, resp=%s", string(b))
+	o.LogFields.LogInfo(o.LogPrefix, "getted order info from rest, resp=%s", string(b))
 	if err == nil {
 		if resp.Code == 0 && len(resp.Message) == 0 {
 			os := NewOrderSnapShotFromRestResponse(*resp)
@@ -251,7 +325,8 @@ func (o *SpotOrder) doRestRefresh() {
 }
 
 func (o *SpotOrder) update() {
-	defer logger.LogInfo(o.LogPrefix, "update exit")
+	defer o.LogFields.LogInfo(o.LogPrefix, "update exit")
+	defer o.RecordFinish()
 
 	// go o.create()
 	o.create()
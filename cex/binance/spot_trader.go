@@ -11,12 +11,14 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aztecqt/dagger/util/logger"
 
 	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
 	"github.com/shopspring/decimal"
 )
 
@@ -56,8 +58,11 @@ func (t *SpotTrader) Init(ex *Exchange, stratergyId int, m *SpotMarket) {
 		var ok bool = false
 
 		if os.StratergyId > 0 && os.StratergyId != stratergyId {
-			t.errorlock = true
-			logger.LogPanic(t.logPrefix, "found order from other stratergy!")
+			if !common.IsRunnerId(strconv.Itoa(os.StratergyId)) {
+				t.errorlock = true
+				logger.LogImportant(t.logPrefix, "found order from other stratergy(%d)!", os.StratergyId)
+			}
+			return
 		}
 
 		t.muOrders.RLock()
@@ -171,9 +176,20 @@ func (t *SpotTrader) MakeOrder(
 	makeOnly, reduceOnly bool,
 	purpose string,
 	obs common.OrderObserver) common.Order {
+	return t.MakeOrderTIF(price, amount, dir, util.ValueIf(makeOnly, common.TimeInForce_GTX, common.TimeInForce_GTC), reduceOnly, purpose, obs)
+}
+
+func (t *SpotTrader) MakeOrderTIF(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif common.TimeInForce,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
 	if t.Ready() {
 		o := new(SpotOrder)
-		if o.Init(t, price, amount, dir, makeOnly, purpose) {
+		if o.Init(t, price, amount, dir, tif, purpose) {
 			t.muOrders.Lock()
 			t.orders[o.CltOrderId.(string)] = o
 			t.muOrders.Unlock()
@@ -191,6 +207,38 @@ func (t *SpotTrader) MakeOrder(
 	}
 }
 
+func (t *SpotTrader) MakeMarketOrder(
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	byQuote bool,
+	maxSlippage decimal.Decimal,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	if !t.Ready() {
+		logger.LogInfo(t.logPrefix, "trader not ready, can't make market order. reason=%s", t.UnreadyReason())
+		return nil
+	}
+	guardPrice, baseAmount, err := common.CheckMarketOrder(t.market.OrderBook(), dir, amount, byQuote, maxSlippage)
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "market order rejected: %s", err.Error())
+		return nil
+	}
+
+	o := new(SpotOrder)
+	if o.InitMarket(t, guardPrice, baseAmount, util.ValueIf(byQuote, amount, decimal.Zero), dir, purpose) {
+		t.muOrders.Lock()
+		t.orders[o.CltOrderId.(string)] = o
+		t.muOrders.Unlock()
+		o.AddObserver(t)
+		o.AddObserver(obs)
+		o.Go()
+		return o
+	} else {
+		return nil
+	}
+}
+
 func (t *SpotTrader) Orders() []common.Order {
 	orders := make([]common.Order, 0, len(t.orders))
 
@@ -213,13 +261,13 @@ func (t *SpotTrader) FeeMaker() decimal.Decimal {
 
 func (t *SpotTrader) AvailableAmount(dir common.OrderDir, price decimal.Decimal) decimal.Decimal {
 	if dir == common.OrderDir_Buy {
-		// 可买数量为当前可用Quote除以购买价格，向下取整
-		amount := t.quoteBalance.Available().Div(price)
+		// 可买数量为当前可用Quote(扣除安全边际)除以购买价格，向下取整
+		amount := common.AvailableBuyAmount(t.quoteBalance.Available(), price, t.FeeTaker())
 		amount = t.market.AlignSize(amount)
 		return amount
 	} else {
-		// 可卖数量为当前可用Base
-		return t.baseBalance.Available()
+		// 可卖数量为当前可用Base(扣除安全边际)
+		return t.market.AlignSize(common.AvailableSellAmount(t.baseBalance.Available()))
 	}
 }
 
@@ -0,0 +1,80 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-28 17:02:45
+- @Description: 现货OCO止盈止损，实现common.TpSlArmer
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package binance
+
+import (
+	"strconv"
+
+	"github.com/aztecqt/dagger/api/binanceapi"
+	"github.com/aztecqt/dagger/api/binanceapi/binancespotapi"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 止损触发后的限价相对于触发价的偏移，保证尽量成交
+var ocoStopLimitOffset = decimal.NewFromFloat(0.005)
+
+// #region 实现common.TpSlArmer
+// 返回orderListId
+func (t *SpotTrader) ArmTpSl(dir common.OrderDir, amount, tpPrice, slPrice decimal.Decimal, purpose string) (string, bool) {
+	side := util.ValueIf(dir == common.OrderDir_Buy, "BUY", "SELL")
+	stopLimit := util.ValueIf(
+		dir == common.OrderDir_Buy,
+		slPrice.Mul(decimal.NewFromInt(1).Add(ocoStopLimitOffset)),
+		slPrice.Mul(decimal.NewFromInt(1).Sub(ocoStopLimitOffset)))
+
+	im := t.exchange.instrumentMgr
+	instId := t.market.instId
+	var resp *binanceapi.OrderListResponse
+	var err error
+	t.exchange.actionQueue.Do(common.ActionPriority_Place, func() {
+		resp, err = binancespotapi.MakeOcoOrder(
+			t.exchange.cred,
+			instId,
+			side,
+			NewClientOrderId(purpose),
+			im.FormatSize(instId, amount),
+			im.FormatPrice(instId, tpPrice),
+			im.FormatPrice(instId, slPrice),
+			im.FormatPrice(instId, stopLimit))
+	})
+
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "arm tp/sl failed: %s", err.Error())
+		return "", false
+	} else if resp.Code != 0 || len(resp.Message) > 0 {
+		logger.LogImportant(t.logPrefix, "arm tp/sl failed, code=%d, msg=%s", resp.Code, resp.Message)
+		return "", false
+	}
+	return strconv.FormatInt(resp.OrderListId, 10), true
+}
+
+func (t *SpotTrader) DisarmTpSl(id string) bool {
+	orderListId, ok := util.String2Int64(id)
+	if !ok || orderListId <= 0 {
+		return false
+	}
+
+	var resp *binanceapi.OrderListResponse
+	var err error
+	t.exchange.actionQueue.Do(common.ActionPriority_Cancel, func() {
+		resp, err = binancespotapi.CancelOrderList(t.exchange.cred, t.market.instId, orderListId)
+	})
+
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "disarm tp/sl failed: %s", err.Error())
+		return false
+	} else if resp.Code != 0 || len(resp.Message) > 0 {
+		logger.LogImportant(t.logPrefix, "disarm tp/sl failed, code=%d, msg=%s", resp.Code, resp.Message)
+		return false
+	}
+	return true
+}
+
+// #endregion
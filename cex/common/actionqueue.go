@@ -0,0 +1,155 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-24 11:02:16
+- @Description: 订单操作队列。同一个交易所的下单/撤单/改单统一经过这里排队，按令牌桶控制频率
+- 令牌不足时优先放行撤单，其次改单，最后下单
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util/debugstats"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type ActionPriority int
+
+const (
+	ActionPriority_Cancel ActionPriority = iota
+	ActionPriority_Amend
+	ActionPriority_Place
+	actionPriority_Count
+)
+
+type ActionQueue struct {
+	logPrefix string
+	mu        sync.Mutex
+	cond      *sync.Cond
+	rate      float64 // 每秒令牌数
+	burst     float64 // 令牌桶容量
+	tokens    float64
+	lastTime  time.Time
+	waiting   [actionPriority_Count]int // 各优先级等待中的数量
+	stopped   bool
+	chStop    chan struct{}
+}
+
+// ratePerSec<=0 表示不限制
+func NewActionQueue(name string, ratePerSec float64, burst int) *ActionQueue {
+	q := new(ActionQueue)
+	q.logPrefix = "action-queue-" + name
+	q.cond = sync.NewCond(&q.mu)
+	q.rate = ratePerSec
+	q.burst = float64(burst)
+	if q.burst < 1 {
+		q.burst = 1
+	}
+	q.tokens = q.burst
+	q.lastTime = time.Now()
+	q.chStop = make(chan struct{})
+	debugstats.RegisterQueue(q.logPrefix, q.Pending)
+
+	if q.rate > 0 {
+		// 定时唤醒等待者，让它们重新检查令牌
+		go func() {
+			interval := time.Duration(float64(time.Second) / q.rate)
+			if interval < time.Millisecond*5 {
+				interval = time.Millisecond * 5
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					q.cond.Broadcast()
+				case <-q.chStop:
+					return
+				}
+			}
+		}()
+	}
+	return q
+}
+
+func (q *ActionQueue) refill() {
+	now := time.Now()
+	q.tokens += now.Sub(q.lastTime).Seconds() * q.rate
+	if q.tokens > q.burst {
+		q.tokens = q.burst
+	}
+	q.lastTime = now
+}
+
+// 是否有更高优先级的操作在等待
+func (q *ActionQueue) higherWaiting(pri ActionPriority) bool {
+	for p := ActionPriority(0); p < pri; p++ {
+		if q.waiting[p] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 等待令牌后执行fn，fn在调用者协程中执行
+// q为nil时直接执行
+func (q *ActionQueue) Do(pri ActionPriority, fn func()) {
+	if q == nil || q.rate <= 0 {
+		fn()
+		return
+	}
+
+	q.mu.Lock()
+	q.waiting[pri]++
+	t0 := time.Now()
+	for !q.stopped {
+		q.refill()
+		if q.tokens >= 1 && !q.higherWaiting(pri) {
+			q.tokens -= 1
+			break
+		}
+		q.cond.Wait()
+	}
+	q.waiting[pri]--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+
+	if wait := time.Since(t0); wait > time.Second {
+		logger.LogInfo(q.logPrefix, "action(priority=%d) waited %v for rate budget", pri, wait)
+	}
+
+	fn()
+}
+
+// 停止定时唤醒，之后的操作不再限频，等待中的操作立即放行。重复调用无副作用
+func (q *ActionQueue) Stop() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.chStop)
+		debugstats.UnregisterQueue(q.logPrefix)
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// 等待中的操作数量
+func (q *ActionQueue) Pending() int {
+	if q == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, c := range q.waiting {
+		n += c
+	}
+	return n
+}
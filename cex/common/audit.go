@@ -0,0 +1,301 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-05 15:20:37
+- @Description: 订单操作的审计记录，用于事后排查和合规
+- 记录的操作(Action)：
+- place: 下单请求发出    ack: 交易所确认    amend: 改单请求发出    cancel: 撤单请求发出
+- fill: 每次成交    reject: 被下单前检查或交易所拒绝    finish: 订单完结
+- 每条记录带完整的订单参数和时间。各交易所的订单在对应位置调用RecordXXX，同时完成链路追踪(见order_trace.go)和延迟统计(见order_latency.go)
+- 未设置AuditWriter时不记录。写入在调用者的协程中同步进行，失败时只记日志，不影响下单
+- FileAuditWriter按天写jsonl文件，只追加，不截断、不删除。需要写数据库时可自行实现AuditWriter
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	AuditAction_Place  = "place"
+	AuditAction_Ack    = "ack"
+	AuditAction_Amend  = "amend"
+	AuditAction_Cancel = "cancel"
+	AuditAction_Fill   = "fill"
+	AuditAction_Reject = "reject"
+	AuditAction_Finish = "finish"
+)
+
+type AuditRecord struct {
+	Time        time.Time        `json:"time"`
+	Action      string           `json:"action"`
+	Exchange    string           `json:"exchange,omitempty"`
+	StrategyId  string           `json:"strategy_id,omitempty"`
+	InstId      string           `json:"inst_id"`
+	CltOrderId  string           `json:"clt_order_id"`
+	OrderId     int64            `json:"order_id,omitempty"`
+	Dir         string           `json:"dir"`
+	Price       decimal.Decimal  `json:"price"`
+	Size        decimal.Decimal  `json:"size"`
+	QuoteSize   *decimal.Decimal `json:"quote_size,omitempty"`
+	TimeInForce string           `json:"tif"`
+	MarketOrder bool             `json:"market_order,omitempty"`
+	ReduceOnly  bool             `json:"reduce_only,omitempty"`
+	MakeOnly    bool             `json:"make_only,omitempty"`
+	Purpose     string           `json:"purpose,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Filled      decimal.Decimal  `json:"filled"`
+	AvgPrice    decimal.Decimal  `json:"avg_price"`
+	DealPrice   *decimal.Decimal `json:"deal_price,omitempty"`  // fill
+	DealAmount  *decimal.Decimal `json:"deal_amount,omitempty"` // fill
+	DealTime    *time.Time       `json:"deal_time,omitempty"`   // fill，交易所时间
+	NewPrice    *decimal.Decimal `json:"new_price,omitempty"`   // amend，0表示不修改
+	NewSize     *decimal.Decimal `json:"new_size,omitempty"`    // amend，0表示不修改
+	Err         string           `json:"err,omitempty"`
+}
+
+type AuditWriter interface {
+	Write(r AuditRecord) error
+}
+
+var muAudit sync.RWMutex
+var auditWriter AuditWriter
+
+// nil表示停止记录。只影响之后创建的订单
+func SetAuditWriter(w AuditWriter) {
+	muAudit.Lock()
+	defer muAudit.Unlock()
+	auditWriter = w
+}
+
+func getAuditWriter() AuditWriter {
+	muAudit.RLock()
+	defer muAudit.RUnlock()
+	return auditWriter
+}
+
+// #region 订单的记录
+type orderAudit struct {
+	mu       sync.Mutex
+	w        AuditWriter
+	acked    bool
+	finished bool
+}
+
+// Init/InitMarket成功后调用
+func (o *OrderImpl) startAudit() {
+	if w := getAuditWriter(); w != nil {
+		o.audit = &orderAudit{w: w}
+	}
+}
+
+func (o *OrderImpl) newAuditRecord(action string) AuditRecord {
+	f := o.LogFields.Merge(logger.GlobalFields())
+	r := AuditRecord{
+		Time:        time.Now(),
+		Action:      action,
+		Exchange:    f.Exchange,
+		StrategyId:  f.StrategyId,
+		InstId:      o.InstId,
+		CltOrderId:  fmt.Sprintf("%v", o.CltOrderId),
+		OrderId:     o.OrderId,
+		Dir:         OrderDir2Str(o.Dir),
+		Price:       o.Price,
+		Size:        o.Size,
+		TimeInForce: o.TimeInForce.String(),
+		MarketOrder: o.MarketOrder,
+		ReduceOnly:  o.ReduceOnly,
+		MakeOnly:    o.MakeOnly,
+		Purpose:     o.Purpose,
+		Status:      o.Status,
+		Filled:      o.Filled,
+		AvgPrice:    o.AvgPrice,
+	}
+
+	if o.QuoteSize.IsPositive() {
+		qs := o.QuoteSize
+		r.QuoteSize = &qs
+	}
+	return r
+}
+
+func writeAudit(w AuditWriter, r AuditRecord) {
+	defer util.DefaultRecover()
+	if err := w.Write(r); err != nil {
+		logger.LogImportant("audit", "write audit record failed, action=%s, order=%s, err=%s", r.Action, r.CltOrderId, err.Error())
+	}
+}
+
+// 下单前检查拒绝时调用。此时订单尚未完成初始化，直接取全局的AuditWriter
+func (o *OrderImpl) recordPreTradeReject(err error) {
+	if w := getAuditWriter(); w != nil {
+		r := o.newAuditRecord(AuditAction_Reject)
+		r.Err = err.Error()
+		writeAudit(w, r)
+	}
+}
+
+// 下单请求发出前调用
+func (o *OrderImpl) RecordPlace() {
+	o.tracePlace()
+	o.latencyPlace()
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		writeAudit(a.w, o.newAuditRecord(AuditAction_Place))
+	}
+}
+
+// 得到交易所订单Id时调用，只记录第一次
+func (o *OrderImpl) RecordAck() {
+	o.traceAck()
+	o.latencyStage(LatencyStage_Ack, time.Time{})
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.acked || a.finished {
+			return
+		}
+
+		a.acked = true
+		writeAudit(a.w, o.newAuditRecord(AuditAction_Ack))
+	}
+}
+
+// 改单请求发出前调用，参数为对齐后的新价格、新数量
+func (o *OrderImpl) RecordAmend(newPrice, newSize decimal.Decimal) {
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		r := o.newAuditRecord(AuditAction_Amend)
+		r.NewPrice = &newPrice
+		r.NewSize = &newSize
+		writeAudit(a.w, r)
+	}
+}
+
+// 撤单请求发出前调用
+func (o *OrderImpl) RecordCancel() {
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if !a.finished {
+			writeAudit(a.w, o.newAuditRecord(AuditAction_Cancel))
+		}
+	}
+}
+
+// 通知Observers之前调用
+func (o *OrderImpl) RecordDeal(d Deal) {
+	o.traceDeal(d)
+	o.latencyStage(LatencyStage_Fill, d.LocalTime)
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		r := o.newAuditRecord(AuditAction_Fill)
+		r.DealPrice = &d.Price
+		r.DealAmount = &d.Amount
+		r.DealTime = &d.UTime
+		writeAudit(a.w, r)
+	}
+}
+
+// 订单完结(含致命错误)时调用，只记录第一次。未被交易所确认就出现致命错误的，记为reject
+func (o *OrderImpl) RecordFinish() {
+	o.traceFinish()
+	if a := o.audit; a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.finished {
+			return
+		}
+
+		a.finished = true
+		r := o.newAuditRecord(util.ValueIf(o.FatalError && !a.acked && o.OrderId == 0, AuditAction_Reject, AuditAction_Finish))
+		if o.FatalError {
+			r.Err = o.ErrMsg
+		}
+		writeAudit(a.w, r)
+	}
+}
+
+// #endregion
+
+// #region 文件
+// 每天一个文件：dir/audit-2024-08-05.jsonl，每行一条记录
+type FileAuditWriter struct {
+	dir  string
+	sync bool
+
+	mu   sync.Mutex
+	date string
+	f    *os.File
+}
+
+// sync为true时每条记录写入后落盘，更可靠但较慢
+func NewFileAuditWriter(dir string, sync bool) (*FileAuditWriter, error) {
+	if !util.MakeSureDir(dir) {
+		return nil, fmt.Errorf("create audit dir failed: %s", dir)
+	}
+
+	w := new(FileAuditWriter)
+	w.dir = dir
+	w.sync = sync
+	return w, nil
+}
+
+func (w *FileAuditWriter) Write(r AuditRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	date := r.Time.Format(time.DateOnly)
+	if w.f == nil || w.date != date {
+		if w.f != nil {
+			w.f.Close()
+			w.f = nil
+		}
+
+		f, err := os.OpenFile(path.Join(w.dir, fmt.Sprintf("audit-%s.jsonl", date)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		w.f = f
+		w.date = date
+	}
+
+	if _, err := w.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if w.sync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func (w *FileAuditWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		err := w.f.Close()
+		w.f = nil
+		return err
+	}
+	return nil
+}
+
+// #endregion
@@ -0,0 +1,100 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-24 15:40:12
+- @Description: 可交易数量的安全边际。满仓使用时，价格波动、手续费、精度取整都可能导致余额不足被拒单
+- 这里提供统一的预留比例和含手续费计算，由各交易器的AvailableAmount调用。合约开仓时按保证金使用同样的计价币预留比例
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+type AvailableReserve struct {
+	QuoteRatio decimal.Decimal `json:"quote_ratio"` // 买入时保留的计价币比例，如0.002
+	BaseRatio  decimal.Decimal `json:"base_ratio"`  // 卖出时保留的基础币比例
+	IncludeFee bool            `json:"include_fee"` // 买入数量是否按扣除手续费后的余额计算
+	FeeRate    decimal.Decimal `json:"fee_rate"`    // 交易器未提供费率时使用的费率
+}
+
+var muAvailableReserve sync.RWMutex
+var availableReserve AvailableReserve
+
+// 设置全局的安全边际，默认全为0（不保留）
+func SetAvailableReserve(r AvailableReserve) {
+	muAvailableReserve.Lock()
+	defer muAvailableReserve.Unlock()
+	availableReserve = r
+}
+
+func GetAvailableReserve() AvailableReserve {
+	muAvailableReserve.RLock()
+	defer muAvailableReserve.RUnlock()
+	return availableReserve
+}
+
+// 扣除安全边际后，可用计价币能买入的数量（未对齐精度）
+func AvailableBuyAmount(quoteAvailable, price, feeRate decimal.Decimal) decimal.Decimal {
+	if !price.IsPositive() || !quoteAvailable.IsPositive() {
+		return decimal.Zero
+	}
+
+	r := GetAvailableReserve()
+	usable := quoteAvailable
+	if r.QuoteRatio.IsPositive() {
+		usable = usable.Mul(decimal.NewFromInt(1).Sub(r.QuoteRatio))
+	}
+
+	if r.IncludeFee {
+		if !feeRate.IsPositive() {
+			feeRate = r.FeeRate
+		}
+		if feeRate.IsPositive() {
+			usable = usable.Div(decimal.NewFromInt(1).Add(feeRate))
+		}
+	}
+
+	return usable.Div(price)
+}
+
+// 扣除安全边际后，可卖出的数量（未对齐精度）
+func AvailableSellAmount(baseAvailable decimal.Decimal) decimal.Decimal {
+	if !baseAvailable.IsPositive() {
+		return decimal.Zero
+	}
+
+	r := GetAvailableReserve()
+	if r.BaseRatio.IsPositive() {
+		return baseAvailable.Mul(decimal.NewFromInt(1).Sub(r.BaseRatio))
+	}
+	return baseAvailable
+}
+
+// 扣除安全边际后，可用保证金在lever杠杆下能开仓的名义价值（以保证金币种计）
+// 含手续费时，保证金需同时覆盖名义价值/lever和名义价值x费率
+func AvailableOpenValue(marginAvailable decimal.Decimal, lever int, feeRate decimal.Decimal) decimal.Decimal {
+	if !marginAvailable.IsPositive() || lever <= 0 {
+		return decimal.Zero
+	}
+
+	r := GetAvailableReserve()
+	usable := marginAvailable
+	if r.QuoteRatio.IsPositive() {
+		usable = usable.Mul(decimal.NewFromInt(1).Sub(r.QuoteRatio))
+	}
+
+	lv := decimal.NewFromInt(int64(lever))
+	value := usable.Mul(lv)
+	if r.IncludeFee {
+		if !feeRate.IsPositive() {
+			feeRate = r.FeeRate
+		}
+		if feeRate.IsPositive() {
+			value = value.Div(decimal.NewFromInt(1).Add(lv.Mul(feeRate)))
+		}
+	}
+	return value
+}
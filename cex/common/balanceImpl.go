@@ -19,6 +19,7 @@ import (
 type BalanceImpl struct {
 	inited bool
 	ccy    string
+	exName string          // 所属交易所，非空时发布余额变化事件
 	rights decimal.Decimal // 权益（推送）
 	total  decimal.Decimal // 权益（总计）
 	temp   estimateValue   // 权益（预估）
@@ -60,7 +61,7 @@ func (b *BalanceImpl) RecordTempRights(r decimal.Decimal, t time.Time) {
 // 返回权益偏移值
 func (b *BalanceImpl) Refresh(rights, frozen decimal.Decimal, tm time.Time) decimal.Decimal {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	changed := !rights.Equal(b.rights) || !frozen.Equal(b.frozen)
 	rightsOrign := b.rights
 	tempOrign := b.temp.val
 	b.temp.ClearTill(tm)
@@ -84,9 +85,45 @@ func (b *BalanceImpl) Refresh(rights, frozen decimal.Decimal, tm time.Time) deci
 	}
 
 	b.inited = true
+	b.mu.Unlock()
+
+	// 在锁外发布，订阅者可以读取余额
+	if changed && len(b.exName) > 0 {
+		PublishBalanceChange(b.exName, b.ccy, rights, frozen, tm)
+	}
 	return pitch
 }
 
+// 丢弃超过maxAge仍未被刷新确认的临时权益，返回丢弃的总值
+// 临时权益正常情况下会被下一次推送清除，推送丢失时由此衰减，避免权益长期偏离
+func (b *BalanceImpl) ExpireTemp(maxAge time.Duration) decimal.Decimal {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.temp.size == 0 {
+		return decimal.Zero
+	}
+
+	expired := b.temp.ExpireBefore(time.Now().Add(-maxAge))
+	if !expired.IsZero() {
+		b.total = b.rights.Add(b.temp.val)
+		logger.LogInfo(b.ccy, "temp rights expired: %v, rights:%v, temp:%v, tempDetail:%v", expired, b.rights, b.temp.val, b.temp.String())
+	}
+	return expired
+}
+
+// 是否有尚未被刷新确认的临时权益
+func (b *BalanceImpl) HasTempRights() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.temp.size > 0
+}
+
+func (b *BalanceImpl) UpdateTime() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.updateTime
+}
+
 func (b *BalanceImpl) Ccy() string {
 	return b.ccy
 }
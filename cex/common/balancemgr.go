@@ -16,6 +16,7 @@ import (
 
 type BalanceMgr struct {
 	needInit     bool
+	exName       string // 非空时余额变化发布到事件总线
 	balanceByCcy map[string] /*ccy*/ *BalanceImpl
 	muBalance    sync.RWMutex
 }
@@ -27,6 +28,16 @@ func NewBalanceMgr(needInit bool) *BalanceMgr {
 	return b
 }
 
+// 设置交易所名，之后余额变化会发布到DefaultEventBus
+func (e *BalanceMgr) SetExchangeName(exName string) {
+	e.muBalance.Lock()
+	defer e.muBalance.Unlock()
+	e.exName = exName
+	for _, b := range e.balanceByCcy {
+		b.exName = exName
+	}
+}
+
 // 设置权益
 // 返回与本地的偏移值
 func (e *BalanceMgr) RefreshBalance(ccy string, free, frozen decimal.Decimal, refreshTime time.Time) decimal.Decimal {
@@ -56,6 +67,7 @@ func (e *BalanceMgr) FindBalance(ccy string) *BalanceImpl {
 
 	if _, ok := e.balanceByCcy[ccy]; !ok {
 		e.balanceByCcy[ccy] = NewBalanceImpl(ccy, e.needInit)
+		e.balanceByCcy[ccy].exName = e.exName
 	}
 
 	b = e.balanceByCcy[ccy]
@@ -68,6 +80,7 @@ func (e *BalanceMgr) FindBalanceUnsafe(ccy string) *BalanceImpl {
 
 	if _, ok := e.balanceByCcy[ccy]; !ok {
 		e.balanceByCcy[ccy] = NewBalanceImpl(ccy, e.needInit)
+		e.balanceByCcy[ccy].exName = e.exName
 	}
 
 	b = e.balanceByCcy[ccy]
@@ -0,0 +1,295 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-01 10:12:36
+- @Description: 由逐笔成交实时合成K线，支持任意时间周期和成交量K线
+- 时间K线按UTime对周期取整，没有成交的周期以上一根的收盘价补齐
+- 成交量K线每累计Volume的成交量收一根，单笔成交跨越多根时按数量拆分
+- 读取时按时钟(默认本地时间，回测时为回放时钟)检查当前K线是否到期
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type BarType int
+
+const (
+	BarType_Time   BarType = iota // 时间K线
+	BarType_Volume                // 成交量K线
+)
+
+type BarConfig struct {
+	Type     BarType
+	Interval time.Duration   // 时间K线的周期
+	Volume   decimal.Decimal // 成交量K线每根的成交量
+	MaxBars  int             // 最多保留的已完成K线数量，默认1000
+}
+
+func (c BarConfig) key() string {
+	if c.Type == BarType_Volume {
+		return fmt.Sprintf("vol-%s-%d", c.Volume.String(), c.MaxBars)
+	}
+	return fmt.Sprintf("time-%d-%d", c.Interval, c.MaxBars)
+}
+
+type Bar struct {
+	Start     time.Time
+	End       time.Time // 时间K线为周期结束时间，成交量K线为最后一笔成交时间
+	Open      decimal.Decimal
+	High      decimal.Decimal
+	Low       decimal.Decimal
+	Close     decimal.Decimal
+	Volume    decimal.Decimal
+	Value     decimal.Decimal // 成交额(价格*数量)
+	BuyVolume decimal.Decimal // 主动买入的成交量
+	Trades    int
+}
+
+// 成交量加权均价
+func (b Bar) Vwap() decimal.Decimal {
+	if b.Volume.IsPositive() {
+		return b.Value.Div(b.Volume)
+	}
+	return b.Close
+}
+
+func (b *Bar) add(px, sz decimal.Decimal, dir OrderDir) {
+	if b.Trades == 0 {
+		b.Open, b.High, b.Low = px, px, px
+	} else {
+		b.High = decimal.Max(b.High, px)
+		b.Low = decimal.Min(b.Low, px)
+	}
+	b.Close = px
+	b.Volume = b.Volume.Add(sz)
+	b.Value = b.Value.Add(px.Mul(sz))
+	if dir == OrderDir_Buy {
+		b.BuyVolume = b.BuyVolume.Add(sz)
+	}
+	b.Trades++
+}
+
+type barSub struct {
+	id int
+	fn func(b Bar)
+}
+
+type BarBuilder struct {
+	mu     sync.Mutex
+	cfg    BarConfig
+	bars   []Bar // 已完成的K线
+	cur    Bar
+	hasCur bool
+	subs   []barSub // K线完成时的回调
+	nextId int
+	clock  Clock
+}
+
+func (b *BarBuilder) Init(cfg BarConfig) bool {
+	if cfg.Type == BarType_Time && cfg.Interval <= 0 || cfg.Type == BarType_Volume && !cfg.Volume.IsPositive() {
+		return false
+	}
+
+	if cfg.MaxBars <= 0 {
+		cfg.MaxBars = 1000
+	}
+	b.cfg = cfg
+	return true
+}
+
+func (b *BarBuilder) Config() BarConfig {
+	return b.cfg
+}
+
+// 设置时钟，默认为本地时间
+func (b *BarBuilder) SetClock(c Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = c
+}
+
+// 添加K线完成时的回调。同一个合成器可能被多个模块共用(见BarHub)，返回id用于RemoveBarFn
+func (b *BarBuilder) AddBarFn(fn func(b Bar)) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextId++
+	b.subs = append(b.subs, barSub{id: b.nextId, fn: fn})
+	return b.nextId
+}
+
+func (b *BarBuilder) RemoveBarFn(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.subs {
+		if s.id == id {
+			b.subs = append(slices.Clone(b.subs[:i]), b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *BarBuilder) OnTrade(t PublicTrade) {
+	b.mu.Lock()
+	closed := b.onTrade(t)
+	b.mu.Unlock()
+	b.notify(closed)
+}
+
+// 最近n根已完成的K线，n<=0表示全部
+func (b *BarBuilder) Bars(n int) []Bar {
+	b.mu.Lock()
+	closed := b.roll(ClockOrReal(b.clock).Now())
+	start := 0
+	if n > 0 && n < len(b.bars) {
+		start = len(b.bars) - n
+	}
+	bars := append([]Bar{}, b.bars[start:]...)
+	b.mu.Unlock()
+
+	b.notify(closed)
+	return bars
+}
+
+// 正在形成中的K线
+func (b *BarBuilder) Current() (Bar, bool) {
+	b.mu.Lock()
+	closed := b.roll(ClockOrReal(b.clock).Now())
+	cur, ok := b.cur, b.hasCur
+	b.mu.Unlock()
+
+	b.notify(closed)
+	return cur, ok
+}
+
+func (b *BarBuilder) notify(closed []Bar) {
+	if len(closed) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, bar := range closed {
+		for _, s := range subs {
+			s.fn(bar)
+		}
+	}
+}
+
+// 需加锁调用
+func (b *BarBuilder) onTrade(t PublicTrade) []Bar {
+	if b.cfg.Type == BarType_Volume {
+		return b.onTradeVolume(t)
+	}
+
+	closed := b.roll(t.UTime)
+	if !b.hasCur {
+		b.cur = b.newTimeBar(t.UTime)
+		b.hasCur = true
+	}
+	b.cur.add(t.Price, t.Size, t.Dir)
+	return closed
+}
+
+func (b *BarBuilder) onTradeVolume(t PublicTrade) []Bar {
+	closed := []Bar{}
+	remain := t.Size
+	for remain.IsPositive() {
+		if !b.hasCur {
+			b.cur = Bar{Start: t.UTime}
+			b.hasCur = true
+		}
+
+		sz := decimal.Min(remain, b.cfg.Volume.Sub(b.cur.Volume))
+		b.cur.add(t.Price, sz, t.Dir)
+		b.cur.End = t.UTime
+		remain = remain.Sub(sz)
+
+		if b.cur.Volume.GreaterThanOrEqual(b.cfg.Volume) {
+			closed = append(closed, b.cur)
+			b.push(b.cur)
+			b.hasCur = false
+		}
+	}
+	return closed
+}
+
+func (b *BarBuilder) newTimeBar(t time.Time) Bar {
+	start := t.Truncate(b.cfg.Interval)
+	return Bar{Start: start, End: start.Add(b.cfg.Interval)}
+}
+
+// 时间K线到期后收线，并补齐中间没有成交的周期
+func (b *BarBuilder) roll(now time.Time) []Bar {
+	if b.cfg.Type != BarType_Time || !b.hasCur || now.Before(b.cur.End) {
+		return nil
+	}
+
+	closed := []Bar{b.cur}
+	b.push(b.cur)
+	last := b.cur
+	b.hasCur = false
+
+	for i := 0; i < b.cfg.MaxBars; i++ {
+		next := Bar{Start: last.End, End: last.End.Add(b.cfg.Interval), Open: last.Close, High: last.Close, Low: last.Close, Close: last.Close}
+		if now.Before(next.End) {
+			b.cur = next
+			b.hasCur = true
+			break
+		}
+		closed = append(closed, next)
+		b.push(next)
+		last = next
+	}
+	return closed
+}
+
+func (b *BarBuilder) push(bar Bar) {
+	b.bars = append(b.bars, bar)
+	if len(b.bars) > b.cfg.MaxBars {
+		b.bars = b.bars[len(b.bars)-b.cfg.MaxBars:]
+	}
+}
+
+// 一个行情下的所有K线合成器，按配置复用
+type BarHub struct {
+	mu       sync.Mutex
+	builders map[string]*BarBuilder
+	clock    Clock
+}
+
+// 设置所有K线合成器的时钟，需在Use之前调用
+func (h *BarHub) SetClock(c Clock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock = c
+}
+
+// 获取(或创建)某个配置的K线合成器。首次创建时通过subscribe订阅逐笔成交
+func (h *BarHub) Use(cfg BarConfig, subscribe func(fn func(t PublicTrade)) int) *BarBuilder {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.builders == nil {
+		h.builders = make(map[string]*BarBuilder)
+	}
+
+	if b, ok := h.builders[cfg.key()]; ok {
+		return b
+	}
+
+	b := new(BarBuilder)
+	if !b.Init(cfg) {
+		return nil
+	}
+	b.clock = h.clock
+	h.builders[cfg.key()] = b
+	subscribe(b.OnTrade)
+	return b
+}
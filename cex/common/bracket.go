@@ -0,0 +1,231 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-28 16:05:21
+- @Description: 括号单(入场+止盈+止损)
+- 先下入场单，入场单完结且有成交后，按成交量挂出交易所原生的止盈止损对(币安现货OCO，okx策略委托)
+- 交易器需实现TpSlArmer
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 交易所原生的止盈止损对，一边触发后另一边自动撤销
+// dir为平仓方向
+type TpSlArmer interface {
+	ArmTpSl(dir OrderDir, amount, tpPrice, slPrice decimal.Decimal, purpose string) (id string, ok bool)
+	DisarmTpSl(id string) bool
+}
+
+type BracketState int
+
+const (
+	BracketState_Idle     BracketState = iota // 未开始
+	BracketState_Entering                     // 入场单进行中
+	BracketState_Armed                        // 止盈止损已挂出
+	BracketState_Canceled                     // 入场未成交或被撤销
+	BracketState_Failed                       // 入场或挂止盈止损失败
+)
+
+func (s BracketState) String() string {
+	switch s {
+	case BracketState_Idle:
+		return "idle"
+	case BracketState_Entering:
+		return "entering"
+	case BracketState_Armed:
+		return "armed"
+	case BracketState_Canceled:
+		return "canceled"
+	case BracketState_Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+type BracketOrder struct {
+	logPrefix string
+	mu        sync.Mutex
+	trader    CommonTrader
+	armer     TpSlArmer
+	price     decimal.Decimal
+	amount    decimal.Decimal
+	dir       OrderDir
+	tpPrice   decimal.Decimal
+	slPrice   decimal.Decimal
+	makeOnly  bool
+	purpose   string
+
+	state   BracketState
+	entry   Order
+	filled  decimal.Decimal
+	tpslId  string
+	fnState func(b *BracketOrder, state BracketState)
+	chStop  chan int
+}
+
+// trader需实现TpSlArmer。买入时要求tp>price>sl，卖出时相反
+func (b *BracketOrder) Init(trader CommonTrader, price, amount decimal.Decimal, dir OrderDir, tpPrice, slPrice decimal.Decimal, makeOnly bool, purpose string) bool {
+	b.logPrefix = fmt.Sprintf("bracket-%s-%s", trader.Market().Type(), purpose)
+	armer, ok := trader.(TpSlArmer)
+	if !ok {
+		logger.LogImportant(b.logPrefix, "trader not support native tp/sl")
+		return false
+	}
+
+	valid := false
+	if dir == OrderDir_Buy {
+		valid = tpPrice.GreaterThan(price) && slPrice.LessThan(price)
+	} else if dir == OrderDir_Sell {
+		valid = tpPrice.LessThan(price) && slPrice.GreaterThan(price)
+	}
+	if !valid || !slPrice.IsPositive() {
+		logger.LogImportant(b.logPrefix, "invalid bracket, dir=%s price=%v tp=%v sl=%v", OrderDir2Str(dir), price, tpPrice, slPrice)
+		return false
+	}
+
+	b.trader = trader
+	b.armer = armer
+	b.price = price
+	b.amount = amount
+	b.dir = dir
+	b.tpPrice = tpPrice
+	b.slPrice = slPrice
+	b.makeOnly = makeOnly
+	b.purpose = purpose
+	b.chStop = make(chan int, 1)
+	return true
+}
+
+// 状态变化回调
+func (b *BracketOrder) SetStateFn(fn func(b *BracketOrder, state BracketState)) {
+	b.fnState = fn
+}
+
+func (b *BracketOrder) State() BracketState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *BracketOrder) Entry() Order {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.entry
+}
+
+func (b *BracketOrder) Filled() decimal.Decimal {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.filled
+}
+
+// 下入场单并开始监控
+func (b *BracketOrder) Go() bool {
+	o := b.trader.MakeOrder(b.price, b.amount, b.dir, b.makeOnly, false, b.purpose, b)
+	if o == nil {
+		b.setState(BracketState_Failed, "make entry order failed")
+		return false
+	}
+
+	b.mu.Lock()
+	b.entry = o
+	b.mu.Unlock()
+	b.setState(BracketState_Entering, o.String())
+	go b.update()
+	return true
+}
+
+// 撤销入场单以及已挂出的止盈止损
+func (b *BracketOrder) Cancel() {
+	b.mu.Lock()
+	entry, tpslId, state := b.entry, b.tpslId, b.state
+	b.mu.Unlock()
+
+	if state == BracketState_Entering && entry != nil && !entry.IsFinished() {
+		entry.Cancel() // 入场单撤销后，已成交部分仍会挂出止盈止损
+	} else if state == BracketState_Armed && len(tpslId) > 0 {
+		if b.armer.DisarmTpSl(tpslId) {
+			b.setState(BracketState_Canceled, "tp/sl disarmed")
+		}
+	}
+}
+
+// 停止监控，不撤单
+func (b *BracketOrder) Stop() {
+	b.chStop <- 0
+}
+
+// 实现OrderObserver
+func (b *BracketOrder) OnDeal(deal Deal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filled = b.filled.Add(deal.Amount)
+}
+
+func (b *BracketOrder) setState(state BracketState, detail string) {
+	b.mu.Lock()
+	b.state = state
+	b.mu.Unlock()
+
+	logger.LogImportant(b.logPrefix, "%s: %s", state.String(), detail)
+	if b.fnState != nil {
+		b.fnState(b, state)
+	}
+}
+
+func (b *BracketOrder) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if b.check() {
+				return
+			}
+		case <-b.chStop:
+			return
+		}
+	}
+}
+
+// 入场单完结后挂止盈止损。返回true表示监控结束
+func (b *BracketOrder) check() bool {
+	b.mu.Lock()
+	entry, filled := b.entry, b.filled
+	b.mu.Unlock()
+
+	if !entry.IsFinished() {
+		return false
+	}
+
+	amount := b.trader.Market().AlignSize(filled)
+	if !amount.IsPositive() {
+		b.setState(BracketState_Canceled, "entry finished without fill")
+		return true
+	}
+
+	closeDir := util.ValueIf(b.dir == OrderDir_Buy, OrderDir_Sell, OrderDir_Buy)
+	id, ok := b.armer.ArmTpSl(closeDir, amount, b.tpPrice, b.slPrice, b.purpose)
+	if !ok {
+		b.setState(BracketState_Failed, fmt.Sprintf("arm tp/sl failed, position %v unprotected", amount))
+		return true
+	}
+
+	b.mu.Lock()
+	b.tpslId = id
+	b.mu.Unlock()
+	b.setState(BracketState_Armed, fmt.Sprintf("id=%s amount=%v tp=%v sl=%v", id, amount, b.tpPrice, b.slPrice))
+	return true
+}
@@ -0,0 +1,173 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-12 09:41:16
+- @Description: 时钟抽象。实盘使用RealClock(本地时间)，回测使用VirtualClock(由回放驱动的虚拟时间)
+- 需要取当前时间或定时的组件(K线合成、订单簿指标、回测交易器等)通过SetClock注入，未设置时使用本地时间
+- VirtualClock的定时器在Set推进时间时到期触发，不会随本地时间流逝
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// 为nil时返回本地时钟
+func ClockOrReal(c Clock) Clock {
+	if c == nil {
+		return RealClock{}
+	}
+	return c
+}
+
+// #region 本地时钟
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{t: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// #endregion
+
+// #region 虚拟时钟
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+type virtualTimer struct {
+	clock   *VirtualClock
+	at      time.Time
+	period  time.Duration // 为0表示一次性定时器
+	ch      chan time.Time
+	stopped bool
+}
+
+func NewVirtualClock(t time.Time) *VirtualClock {
+	c := new(VirtualClock)
+	c.now = t
+	return c
+}
+
+// 设置起始时间，只在时间为零值时生效。已创建的定时器以t为起点顺延
+func (c *VirtualClock) Start(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.now.IsZero() {
+		return
+	}
+
+	for _, vt := range c.timers {
+		vt.at = t.Add(vt.at.Sub(c.now))
+	}
+	c.now = t
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// 推进时间，并触发所有到期的定时器。时间只会前进，早于当前时间的t被忽略
+// 与time.Ticker一致，接收方来不及处理时丢弃多余的触发
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !t.After(c.now) {
+		return
+	}
+
+	c.now = t
+	alive := c.timers[:0]
+	for _, vt := range c.timers {
+		if vt.stopped {
+			continue
+		}
+
+		if !t.Before(vt.at) {
+			select {
+			case vt.ch <- vt.at:
+			default:
+			}
+
+			if vt.period <= 0 {
+				continue
+			}
+
+			// 跳过多个周期时只触发一次
+			n := t.Sub(vt.at)/vt.period + 1
+			vt.at = vt.at.Add(n * vt.period)
+		}
+		alive = append(alive, vt)
+	}
+	c.timers = alive
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+func (c *VirtualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for VirtualClock.NewTicker")
+	}
+	return c.add(d, d)
+}
+
+func (c *VirtualClock) add(d, period time.Duration) *virtualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vt := &virtualTimer{clock: c, at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 && period <= 0 {
+		vt.ch <- c.now
+		return vt
+	}
+	c.timers = append(c.timers, vt)
+	return vt
+}
+
+func (vt *virtualTimer) C() <-chan time.Time {
+	return vt.ch
+}
+
+func (vt *virtualTimer) Stop() {
+	vt.clock.mu.Lock()
+	defer vt.clock.mu.Unlock()
+	vt.stopped = true
+}
+
+// #endregion
@@ -0,0 +1,132 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-25 10:12:40
+- @Description: 断线撤单(dead-man switch)
+- 策略主循环定时调用Heartbeat，交易所连接状态由fnHealthy提供
+- 交易所支持原生倒计时撤单时(fnArm)，心跳正常就不断续期，进程卡死或断网后由交易所撤单
+- 否则由本地看门狗在心跳超时或连接断开超过timeout时调用fnCancelAll
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+// 设置/续期原生倒计时撤单，timeout为0表示取消倒计时
+type DeadManArmFn func(timeout time.Duration) bool
+
+type DeadManSwitch struct {
+	logPrefix   string
+	mu          sync.Mutex
+	timeout     time.Duration
+	fnArm       DeadManArmFn
+	fnCancelAll func()
+	fnHealthy   func() bool
+
+	lastHeartbeat time.Time
+	lastHealthy   time.Time
+	triggered     bool // 已触发撤单，恢复正常前不再重复触发
+	chStop        chan int
+}
+
+// fnArm可以为nil，表示交易所不支持原生倒计时撤单
+func (d *DeadManSwitch) Init(name string, timeout time.Duration, fnArm DeadManArmFn, fnCancelAll func(), fnHealthy func() bool) {
+	d.logPrefix = "deadman-" + name
+	d.timeout = timeout
+	d.fnArm = fnArm
+	d.fnCancelAll = fnCancelAll
+	d.fnHealthy = fnHealthy
+	d.chStop = make(chan int, 1)
+	d.lastHeartbeat = time.Now()
+	d.lastHealthy = time.Now()
+}
+
+func (d *DeadManSwitch) Go() {
+	logger.LogImportant(d.logPrefix, "started, timeout=%v, native=%v", d.timeout, d.fnArm != nil)
+	go d.update()
+}
+
+func (d *DeadManSwitch) Stop() {
+	d.chStop <- 0
+}
+
+// 策略主循环调用，表示进程仍在正常运行
+func (d *DeadManSwitch) Heartbeat() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastHeartbeat = time.Now()
+}
+
+func (d *DeadManSwitch) Triggered() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.triggered
+}
+
+func (d *DeadManSwitch) update() {
+	defer util.DefaultRecover()
+
+	interval := d.timeout / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	d.check()
+	for {
+		select {
+		case <-ticker.C:
+			d.check()
+		case <-d.chStop:
+			if d.fnArm != nil {
+				d.fnArm(0)
+			}
+			logger.LogImportant(d.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+func (d *DeadManSwitch) check() {
+	now := time.Now()
+	healthy := d.fnHealthy == nil || d.fnHealthy()
+
+	d.mu.Lock()
+	if healthy {
+		d.lastHealthy = now
+	}
+	alive := now.Sub(d.lastHeartbeat) < d.timeout
+	connected := now.Sub(d.lastHealthy) < d.timeout
+	trigger := (!alive || !connected) && !d.triggered
+	if alive && connected && d.triggered {
+		d.triggered = false
+		logger.LogImportant(d.logPrefix, "recovered")
+	}
+	if trigger {
+		d.triggered = true
+	}
+	d.mu.Unlock()
+
+	// 心跳正常时续期
+	if alive && healthy && d.fnArm != nil {
+		if !d.fnArm(d.timeout) {
+			logger.LogImportant(d.logPrefix, "arm native countdown failed")
+		}
+	}
+
+	if trigger {
+		logger.LogImportant(d.logPrefix, "triggered, heartbeat alive=%v, connected=%v, canceling all orders", alive, connected)
+		if d.fnCancelAll != nil {
+			func() {
+				defer util.DefaultRecover() // 撤单失败不能让看门狗退出
+				d.fnCancelAll()
+			}()
+		}
+	}
+}
@@ -28,6 +28,44 @@ type KUnit struct {
 	VolumeUSD    decimal.Decimal // 以USD计算的交易量
 }
 
+// 资金费率详情
+type FundingDetail struct {
+	Rate            decimal.Decimal // 当期费率（币安为实时预测费率）
+	NextRate        decimal.Decimal // 下期预测费率，交易所不提供时为0
+	FundingTime     time.Time       // 当期结算时间
+	NextFundingTime time.Time       // 下期结算时间，交易所不提供时为零值
+	Interval        time.Duration   // 结算周期（8h/4h/1h）
+	RateCap         decimal.Decimal // 费率上限，未知时为0
+	RateFloor       decimal.Decimal // 费率下限，未知时为0
+}
+
+// 持仓总量
+type OpenInterest struct {
+	Time     time.Time
+	Size     decimal.Decimal // 张
+	SizeCcy  decimal.Decimal // 币
+	ValueUsd decimal.Decimal // 交易所不提供时为0
+}
+
+// 24小时滚动行情统计
+type Ticker24h struct {
+	Time      time.Time
+	Open      decimal.Decimal // 24小时前的价格
+	High      decimal.Decimal
+	Low       decimal.Decimal
+	Last      decimal.Decimal
+	Volume    decimal.Decimal // 成交量，以交易币计
+	VolumeUsd decimal.Decimal // 成交额，以U/USD计
+}
+
+// 24小时涨跌幅(%)
+func (t Ticker24h) ChangePct() decimal.Decimal {
+	if !t.Open.IsPositive() {
+		return decimal.Zero
+	}
+	return t.Last.Sub(t.Open).Div(t.Open).Mul(decimal.NewFromInt(100))
+}
+
 type ContractType string
 
 const (
@@ -35,6 +73,44 @@ const (
 	ContractType_UsdtSwap              = "usdt_swap"
 )
 
+// 交易品种状态
+type InstrumentStatus int
+
+const (
+	InstrumentStatus_Trading  InstrumentStatus = iota // 正常交易
+	InstrumentStatus_PostOnly                         // 只允许挂单（如新币上线后的一段时间）
+	InstrumentStatus_Auction                          // 集合竞价
+	InstrumentStatus_PreOpen                          // 预上线，尚未开始交易
+	InstrumentStatus_Halted                           // 暂停交易
+)
+
+func (s InstrumentStatus) String() string {
+	switch s {
+	case InstrumentStatus_Trading:
+		return "trading"
+	case InstrumentStatus_PostOnly:
+		return "post_only"
+	case InstrumentStatus_Auction:
+		return "auction"
+	case InstrumentStatus_PreOpen:
+		return "pre_open"
+	case InstrumentStatus_Halted:
+		return "halted"
+	default:
+		return "unknown"
+	}
+}
+
+// 行情是否处于连续交易阶段
+func (s InstrumentStatus) Tradable() bool {
+	return s == InstrumentStatus_Trading || s == InstrumentStatus_PostOnly
+}
+
+// 当前状态下是否允许某类订单
+func (s InstrumentStatus) OrderAllowed(makeOnly bool) bool {
+	return s == InstrumentStatus_Trading || (s == InstrumentStatus_PostOnly && makeOnly)
+}
+
 type TickSizeMode int
 
 const (
@@ -45,21 +121,24 @@ const (
 
 type Instruments struct {
 	Id             string
-	BaseCcy        string          // 币币中的交易货币币种，如BTC-USDT中的BTC
-	QuoteCcy       string          // 币币中的计价货币币种，如BTC-USDT中的USDT
-	CtSymbol       string          // 表示是那个币种的合约（btc_usdt_swap就是btc，btc_usd_swap也是btc）
-	CtType         ContractType    // 合约类型 枚举：ContractType
-	IsUsdtContract bool            // 是否为U本位合约
-	CtSettleCcy    string          // 盈亏结算和保证金币种（btc_usdt_swap是usdt，btc_usd_swap是btc）
-	CtValCcy       string          // 合约面值计价币种（btc_usdt_swap是btc，btc_usd_swap是usdt）
-	CtVal          decimal.Decimal // 合约面值
-	ExpTime        time.Time       // 交割日期（交割合约、期权）
-	Lever          int             // 最大杠杆倍率
-	TickSize       decimal.Decimal // 下单价格精度
-	TickSizeMode   TickSizeMode    // 精度模式
-	LotSize        decimal.Decimal // 下单数量精度
-	MinSize        decimal.Decimal // 最小下单数量
-	MinValue       decimal.Decimal // 最小下单价值
+	BaseCcy        string           // 币币中的交易货币币种，如BTC-USDT中的BTC
+	QuoteCcy       string           // 币币中的计价货币币种，如BTC-USDT中的USDT
+	CtSymbol       string           // 表示是那个币种的合约（btc_usdt_swap就是btc，btc_usd_swap也是btc）
+	CtType         ContractType     // 合约类型 枚举：ContractType
+	IsUsdtContract bool             // 是否为U本位合约
+	CtSettleCcy    string           // 盈亏结算和保证金币种（btc_usdt_swap是usdt，btc_usd_swap是btc）
+	CtValCcy       string           // 合约面值计价币种（btc_usdt_swap是btc，btc_usd_swap是usdt）
+	CtVal          decimal.Decimal  // 合约面值
+	ExpTime        time.Time        // 交割日期（交割合约、期权）
+	Lever          int              // 最大杠杆倍率
+	TickSize       decimal.Decimal  // 下单价格精度
+	TickSizeMode   TickSizeMode     // 精度模式
+	LotSize        decimal.Decimal  // 下单数量精度
+	MinSize        decimal.Decimal  // 最小下单数量
+	MinValue       decimal.Decimal  // 最小下单价值
+	QuotePrecision int32            // 按计价币数量下单时的小数位数，0表示按价格精度
+	ListTime       time.Time        // 上线时间，未知时为零值
+	Status         InstrumentStatus // 交易状态
 }
 
 func (i *Instruments) refreshTickSizeMode() {
@@ -60,6 +60,29 @@ func (e *estimateValue) ClearTill(till time.Time) {
 	e.size = len(e.slc)
 }
 
+// 丢弃早于t的记录，返回丢弃的总值
+func (e *estimateValue) ExpireBefore(t time.Time) decimal.Decimal {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	expired := decimal.Zero
+	for i := 0; i < len(e.slc); {
+		if e.slc[i].t.Before(t) {
+			expired = expired.Add(e.slc[i].v)
+			e.val = e.val.Sub(e.slc[i].v)
+			e.slc = util.SliceRemoveAt(e.slc, i)
+		} else {
+			i++
+		}
+	}
+
+	if len(e.slc) == 0 {
+		e.val = decimal.Zero
+	}
+
+	e.size = len(e.slc)
+	return expired
+}
+
 func (e *estimateValue) String() string {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -0,0 +1,252 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-17 09:48:22
+- @Description: 事件总线。交易所/回测将成交、订单状态、余额变化、资金费结算统一发布到总线，
+- 策略、风控、记录、通知等模块只需订阅需要的事件类型，不用在各个Exchange/Order上分别注册回调
+- Subscribe的回调在发布者的协程中同步执行，不能阻塞；SubscribeAsync的回调在独立协程中按顺序执行，队列满时丢弃
+- 具名总线(如DefaultEventBus)的异步订阅队列深度注册到util/debugstats，可在调试服务中查看
+- 目前发布的事件：okexv5/binance/ibkrtws订单的成交和完结、余额刷新(BalanceMgr设置了交易所名时)、回测的成交/完结/资金费、下单前检查的拒绝(见pretrade.go)、配对交易信号(见util/signals)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/debugstats"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type EventType int
+
+const (
+	EventType_Deal           EventType = iota // 订单成交，Deal/Order有效
+	EventType_OrderUpdate                     // 订单状态变化(目前只发布完结)，Order有效
+	EventType_BalanceChange                   // 余额变化，Ccy/Rights/Frozen有效
+	EventType_FundingSettled                  // 资金费结算，InstId/Ccy/Rate/Amount有效
+	EventType_OrderRejected                   // 订单被下单前检查拒绝，InstId/Err有效
+	EventType_PairSignal                      // 配对交易信号，InstId(品种对名称)/Pair有效
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventType_Deal:
+		return "deal"
+	case EventType_OrderUpdate:
+		return "order_update"
+	case EventType_BalanceChange:
+		return "balance_change"
+	case EventType_FundingSettled:
+		return "funding_settled"
+	case EventType_OrderRejected:
+		return "order_rejected"
+	case EventType_PairSignal:
+		return "pair_signal"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type     EventType
+	Exchange string
+	InstId   string
+	Ccy      string
+	Time     time.Time
+	Deal     Deal
+	Order    Order
+	Rights   decimal.Decimal
+	Frozen   decimal.Decimal
+	Rate     decimal.Decimal // 资金费率
+	Amount   decimal.Decimal // 资金费金额，正数为收入
+	Err      error           // 拒绝原因
+	Pair     PairSignal      // 配对交易信号
+}
+
+// 配对交易信号，spread = ln(A) - HedgeRatio*ln(B) - Intercept
+type PairSignal struct {
+	SymbolA    string
+	SymbolB    string
+	HedgeRatio float64
+	Intercept  float64
+	Spread     float64
+	ZScore     float64
+	HalfLife   float64 // 均值回归半衰期（K线根数），不回归时为+Inf
+	Corr       float64 // 对数价格相关系数
+}
+
+type eventSub struct {
+	id     int
+	types  map[EventType]bool // 为空表示订阅所有类型
+	fn     func(e Event)
+	ch     chan Event // 异步订阅的队列
+	chStop chan int
+}
+
+func (s *eventSub) accept(t EventType) bool {
+	return len(s.types) == 0 || s.types[t]
+}
+
+type EventBus struct {
+	name   string // 不为空时，异步订阅的队列深度注册到debugstats
+	mu     sync.RWMutex
+	subs   []*eventSub
+	nextId int
+}
+
+// 默认总线，各交易所的事件发布到这里
+var DefaultEventBus = NewNamedEventBus("default")
+
+func NewEventBus() *EventBus {
+	return NewNamedEventBus("")
+}
+
+func NewNamedEventBus(name string) *EventBus {
+	b := new(EventBus)
+	b.name = name
+	b.nextId = 1
+	return b
+}
+
+func (b *EventBus) queueName(id int) string {
+	return fmt.Sprintf("eventbus-%s-%d", b.name, id)
+}
+
+func (b *EventBus) add(s *eventSub, types []EventType) int {
+	s.types = make(map[EventType]bool)
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.id = b.nextId
+	b.nextId++
+	b.subs = append(b.subs, s)
+	return s.id
+}
+
+// 同步订阅，types为空时订阅所有类型。返回订阅id，用于取消订阅
+func (b *EventBus) Subscribe(fn func(e Event), types ...EventType) int {
+	return b.add(&eventSub{fn: fn}, types)
+}
+
+// 异步订阅，bufSize为队列长度(默认1024)
+func (b *EventBus) SubscribeAsync(fn func(e Event), bufSize int, types ...EventType) int {
+	s := &eventSub{fn: fn, ch: make(chan Event, util.ValueIf(bufSize > 0, bufSize, 1024)), chStop: make(chan int, 1)}
+	id := b.add(s, types)
+	if len(b.name) > 0 {
+		debugstats.RegisterQueue(b.queueName(id), func() int { return len(s.ch) })
+	}
+	go func() {
+		defer util.DefaultRecover()
+		for {
+			select {
+			case <-s.chStop:
+				return
+			case e := <-s.ch:
+				fn(e)
+			}
+		}
+	}()
+	return id
+}
+
+func (b *EventBus) Unsubscribe(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, s := range b.subs {
+		if s.id == id {
+			if s.chStop != nil {
+				s.chStop <- 0
+				debugstats.UnregisterQueue(b.queueName(id))
+			}
+			b.subs = append(slices.Clone(b.subs[:i]), b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// b为nil时不发布
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		if !s.accept(e.Type) {
+			continue
+		}
+
+		if s.ch != nil {
+			select {
+			case s.ch <- e:
+			default:
+				logger.LogInfo("EventBus", "queue of subscriber %d is full, %s event dropped", s.id, e.Type.String())
+			}
+		} else {
+			b.call(s, e)
+		}
+	}
+}
+
+// 单个订阅者的panic不影响其他订阅者和发布者
+func (b *EventBus) call(s *eventSub, e Event) {
+	defer util.DefaultRecover()
+	s.fn(e)
+}
+
+// #region 发布辅助。不带总线的版本发布到DefaultEventBus
+func (b *EventBus) PublishDeal(exName string, d Deal) {
+	if d.O == nil {
+		return
+	}
+	b.Publish(Event{Type: EventType_Deal, Exchange: exName, InstId: d.O.GetType(), Time: d.UTime, Deal: d, Order: d.O})
+}
+
+func (b *EventBus) PublishOrderUpdate(exName string, o Order, t time.Time) {
+	b.Publish(Event{Type: EventType_OrderUpdate, Exchange: exName, InstId: o.GetType(), Time: t, Order: o})
+}
+
+func (b *EventBus) PublishFundingSettled(exName, instId, ccy string, rate, amount decimal.Decimal, t time.Time) {
+	b.Publish(Event{Type: EventType_FundingSettled, Exchange: exName, InstId: instId, Ccy: ccy, Time: t, Rate: rate, Amount: amount})
+}
+
+func (b *EventBus) PublishPairSignal(pair string, sig PairSignal, t time.Time) {
+	b.Publish(Event{Type: EventType_PairSignal, InstId: pair, Time: t, Pair: sig})
+}
+
+func PublishDeal(exName string, d Deal) {
+	DefaultEventBus.PublishDeal(exName, d)
+}
+
+func PublishOrderUpdate(exName string, o Order, t time.Time) {
+	DefaultEventBus.PublishOrderUpdate(exName, o, t)
+}
+
+func PublishBalanceChange(exName, ccy string, rights, frozen decimal.Decimal, t time.Time) {
+	DefaultEventBus.Publish(Event{Type: EventType_BalanceChange, Exchange: exName, Ccy: ccy, Time: t, Rights: rights, Frozen: frozen})
+}
+
+func PublishFundingSettled(exName, instId, ccy string, rate, amount decimal.Decimal, t time.Time) {
+	DefaultEventBus.PublishFundingSettled(exName, instId, ccy, rate, amount, t)
+}
+
+func PublishPairSignal(pair string, sig PairSignal, t time.Time) {
+	DefaultEventBus.PublishPairSignal(pair, sig, t)
+}
+
+func PublishOrderRejected(instId string, err error, t time.Time) {
+	DefaultEventBus.Publish(Event{Type: EventType_OrderRejected, InstId: instId, Time: t, Err: err})
+}
+
+// #endregion
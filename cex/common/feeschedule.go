@@ -0,0 +1,72 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-28 14:47:02
+- @Description: 手续费等级与平台币抵扣
+- 按30日成交量(计价币)分档设置maker/taker费率，可选用平台币(bnb/okb)抵扣并打折
+- 用于模拟盘/回测计算手续费，使模拟收益与实盘一致。见backtest.CostConfig
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+type FeeTier struct {
+	MinVolume decimal.Decimal `json:"min_volume"` // 30日成交量下限
+	Maker     decimal.Decimal `json:"maker"`
+	Taker     decimal.Decimal `json:"taker"`
+}
+
+type FeeSchedule struct {
+	Tiers       []FeeTier       `json:"tiers"`
+	DiscountCcy string          `json:"discount_ccy"` // 抵扣币种，如bnb、okb。为空表示不抵扣
+	Discount    decimal.Decimal `json:"discount"`     // 抵扣后费率的比例，如0.75表示打75折
+}
+
+// 一笔成交的手续费
+type FeeCharge struct {
+	Rate   decimal.Decimal // 实际费率
+	Amount decimal.Decimal // 手续费，以计价币计
+	Ccy    string          // 扣除币种，为空表示从计价币中扣除
+}
+
+// 按成交量下限排序，加载配置后调用一次
+func (f *FeeSchedule) Init() {
+	sort.Slice(f.Tiers, func(i, j int) bool {
+		return f.Tiers[i].MinVolume.LessThan(f.Tiers[j].MinVolume)
+	})
+}
+
+// 所处的等级。没有配置等级时返回零费率
+func (f *FeeSchedule) Tier(volume30d decimal.Decimal) FeeTier {
+	tier := FeeTier{}
+	for _, t := range f.Tiers {
+		if volume30d.GreaterThanOrEqual(t.MinVolume) {
+			tier = t
+		} else {
+			break
+		}
+	}
+	return tier
+}
+
+// 计算手续费。discountAvail表示抵扣币余额是否充足
+func (f *FeeSchedule) Charge(value decimal.Decimal, isMaker bool, volume30d decimal.Decimal, discountAvail bool) FeeCharge {
+	tier := f.Tier(volume30d)
+	c := FeeCharge{Rate: tier.Taker}
+	if isMaker {
+		c.Rate = tier.Maker
+	}
+
+	// 返佣(负费率)不打折
+	if len(f.DiscountCcy) > 0 && discountAvail && c.Rate.IsPositive() && f.Discount.IsPositive() {
+		c.Rate = c.Rate.Mul(f.Discount)
+		c.Ccy = f.DiscountCcy
+	}
+
+	c.Amount = value.Abs().Mul(c.Rate)
+	return c
+}
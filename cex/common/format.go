@@ -0,0 +1,46 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-27 15:32:10
+- @Description: 下单价格/数量的字符串格式化
+- 计算得到的decimal可能带有多余的精度，直接提交会被交易所拒绝。这里按品种的精度固定小数位数，不使用指数形式
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// 精度对应的小数位数。0.001->3, 0.5->1, 10->0
+func StepPlaces(step decimal.Decimal) int32 {
+	if !step.IsPositive() {
+		return 0
+	}
+
+	s := step.String()
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return int32(len(s) - i - 1)
+	}
+	return 0
+}
+
+// 按精度格式化。trimZeros为true时去掉小数部分末尾的0
+func FormatDecimalByStep(v, step decimal.Decimal, trimZeros bool) string {
+	places := StepPlaces(step)
+	return trimDecimalZeros(v.Round(places).StringFixed(places), trimZeros)
+}
+
+// 截断到places位小数，用于不能超过的数量(如按计价币数量下单时不能多于可用余额)
+func FormatDecimalTruncate(v decimal.Decimal, places int32, trimZeros bool) string {
+	return trimDecimalZeros(v.Truncate(places).StringFixed(places), trimZeros)
+}
+
+func trimDecimalZeros(s string, trimZeros bool) string {
+	if trimZeros && strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-04 10:12:37
+- @Description: 资金费率预测。交易所提供的下期费率经常缺失，这里按本结算周期内的采样自行预测本期结算费率
+- 溢价指数采样：模拟交易所的算法，P为溢价指数的加权均值(越晚的采样权重越高)，费率=P+clamp(I-P,±0.05%)
+- 预估费率采样：交易所已给出实时预估值(如okx)，直接取最新的采样
+- 周期初期采样少、波动大，所以按本期已过去的比例作为置信度，与上期结算费率加权，最后按上下限截断
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type FundingSampleType int
+
+const (
+	FundingSample_Premium  FundingSampleType = iota // 溢价指数
+	FundingSample_Estimate                          // 交易所的实时预估费率
+)
+
+type FundingForecast struct {
+	Rate        decimal.Decimal // 按置信度加权后的预测费率
+	RawRate     decimal.Decimal // 仅由本期采样得出的费率
+	PrevRate    decimal.Decimal // 上期结算费率
+	FundingTime time.Time       // 预测对应的结算时间
+	Confidence  float64         // 0~1，本期已过去的比例
+	Samples     int
+}
+
+type FundingPredictor struct {
+	mu          sync.Mutex
+	sampleType  FundingSampleType
+	interval    time.Duration
+	rateCap     decimal.Decimal
+	rateFloor   decimal.Decimal
+	fundingTime time.Time
+	prevRate    decimal.Decimal
+	samples     []decimal.Decimal
+	lastSample  time.Time
+}
+
+var premiumClamp = decimal.NewFromFloat(0.0005)
+var interestPer8h = decimal.NewFromFloat(0.0001)
+
+func (p *FundingPredictor) Init(sampleType FundingSampleType) {
+	p.sampleType = sampleType
+	p.interval = time.Hour * 8
+}
+
+// 结算周期和费率上下限，上下限为0表示不限制
+func (p *FundingPredictor) SetDetail(interval time.Duration, rateCap, rateFloor decimal.Decimal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if interval > 0 {
+		p.interval = interval
+	}
+	p.rateCap = rateCap
+	p.rateFloor = rateFloor
+}
+
+// 设置本期的结算时间。结算时间变化时进入新的周期，清空采样
+// prevRate为上期结算费率，未知时可传入上期最后的预测值
+func (p *FundingPredictor) SetFundingTime(fundingTime time.Time, prevRate decimal.Decimal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fundingTime.Equal(p.fundingTime) {
+		return
+	}
+
+	p.fundingTime = fundingTime
+	p.prevRate = prevRate
+	p.samples = nil
+	p.lastSample = time.Time{}
+}
+
+// 添加一个采样，结算时间之后的采样属于下一期，在进入新周期前忽略
+func (p *FundingPredictor) OnSample(t time.Time, v decimal.Decimal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.fundingTime.IsZero() && !t.Before(p.fundingTime) {
+		return
+	}
+
+	p.samples = append(p.samples, v)
+	p.lastSample = t
+}
+
+func (p *FundingPredictor) Forecast() FundingForecast {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f := FundingForecast{PrevRate: p.prevRate, FundingTime: p.fundingTime, Samples: len(p.samples)}
+	if len(p.samples) == 0 || p.fundingTime.IsZero() {
+		f.Rate = p.limit(p.prevRate)
+		return f
+	}
+
+	f.RawRate = p.rawRate()
+	elapsed := p.interval - p.fundingTime.Sub(p.lastSample)
+	f.Confidence = min(max(elapsed.Seconds()/p.interval.Seconds(), 0), 1)
+
+	conf := decimal.NewFromFloat(f.Confidence)
+	f.Rate = p.limit(f.RawRate.Mul(conf).Add(p.prevRate.Mul(decimal.NewFromInt(1).Sub(conf))))
+	return f
+}
+
+func (p *FundingPredictor) rawRate() decimal.Decimal {
+	if p.sampleType == FundingSample_Estimate {
+		return p.samples[len(p.samples)-1]
+	}
+
+	// 加权均值，第i个采样的权重为i
+	sum := decimal.Zero
+	weights := decimal.Zero
+	for i, v := range p.samples {
+		w := decimal.NewFromInt(int64(i + 1))
+		sum = sum.Add(v.Mul(w))
+		weights = weights.Add(w)
+	}
+	premium := sum.Div(weights)
+
+	// 利率按结算周期折算，8小时为0.01%
+	interest := interestPer8h.Mul(decimal.NewFromFloat(p.interval.Hours() / 8))
+	diff := decimal.Min(decimal.Max(interest.Sub(premium), premiumClamp.Neg()), premiumClamp)
+	return premium.Add(diff)
+}
+
+func (p *FundingPredictor) limit(rate decimal.Decimal) decimal.Decimal {
+	if !p.rateCap.IsZero() {
+		rate = decimal.Min(rate, p.rateCap)
+	}
+	if !p.rateFloor.IsZero() {
+		rate = decimal.Max(rate, p.rateFloor)
+	}
+	return rate
+}
@@ -0,0 +1,90 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-01 10:21:37
+- @Description: 健康检查。汇总交易所、各行情器、交易器的Ready状态和未就绪原因，供存活/就绪探针、外部看门狗查询
+- 交易所对象实现HealthChecker时，其自身状态(如私有连接断开、正在退出)也计入
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+// 交易所级别的就绪状态，由各交易所可选实现
+type HealthChecker interface {
+	Ready() bool
+	UnreadyReason() string
+}
+
+type ComponentHealth struct {
+	Name   string `json:"name"`
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type HealthReport struct {
+	Ready    bool              `json:"ready"`
+	Exchange ComponentHealth   `json:"exchange"`
+	Markets  []ComponentHealth `json:"markets"`
+	Traders  []ComponentHealth `json:"traders"`
+}
+
+// 未就绪的组件及原因，如["okx: exchange not ready", "BTC-USDT: depth not ready"]
+func (h HealthReport) UnreadyReasons() []string {
+	reasons := []string{}
+	add := func(c ComponentHealth) {
+		if !c.Ready {
+			reasons = append(reasons, c.Name+": "+c.Reason)
+		}
+	}
+
+	add(h.Exchange)
+	for _, c := range h.Markets {
+		add(c)
+	}
+	for _, c := range h.Traders {
+		add(c)
+	}
+	return reasons
+}
+
+// 全部就绪时Ready为true。ex为nil表示交易所尚未创建
+func CheckHealth(ex CEx) HealthReport {
+	h := HealthReport{Markets: []ComponentHealth{}, Traders: []ComponentHealth{}}
+	if ex == nil {
+		h.Exchange = ComponentHealth{Name: "exchange", Reason: "not created"}
+		return h
+	}
+
+	h.Ready = true
+	h.Exchange = ComponentHealth{Name: ex.Name(), Ready: true}
+	if hc, ok := ex.(HealthChecker); ok && !hc.Ready() {
+		h.Exchange.Ready = false
+		h.Exchange.Reason = hc.UnreadyReason()
+		h.Ready = false
+	}
+
+	check := func(name string, ready bool, reason func() string) ComponentHealth {
+		c := ComponentHealth{Name: name, Ready: ready}
+		if !ready {
+			c.Reason = reason()
+			h.Ready = false
+		}
+		return c
+	}
+
+	for _, m := range ex.FutureMarkets() {
+		h.Markets = append(h.Markets, check(m.Type(), m.Ready(), m.UnreadyReason))
+	}
+
+	for _, m := range ex.SpotMarkets() {
+		h.Markets = append(h.Markets, check(m.Type(), m.Ready(), m.UnreadyReason))
+	}
+
+	for _, t := range ex.FutureTraders() {
+		h.Traders = append(h.Traders, check(t.Market().Type(), t.Ready(), t.UnreadyReason))
+	}
+
+	for _, t := range ex.SpotTraders() {
+		h.Traders = append(h.Traders, check(t.Market().Type(), t.Ready(), t.UnreadyReason))
+	}
+
+	return h
+}
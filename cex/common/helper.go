@@ -10,6 +10,7 @@
 package common
 
 import (
+	"slices"
 	"strings"
 	"time"
 
@@ -28,7 +29,7 @@ func CalculateOrderDeal(filled, avgPrice, filledNew, avgPriceNew decimal.Decimal
 		m0 := filled.Mul(avgPrice)
 		m1 := filledNew.Mul(avgPriceNew)
 		if m1.LessThan(m0) {
-			logger.LogPanic("CalculateOrderDeal", `"filledNew" can't less than "filled"`)
+			logger.LogStrict("CalculateOrderDeal", `"filledNew" can't less than "filled"`)
 			price = decimal.Zero
 			amount = decimal.Zero
 		} else {
@@ -40,7 +41,7 @@ func CalculateOrderDeal(filled, avgPrice, filledNew, avgPriceNew decimal.Decimal
 			}
 		}
 	} else {
-		logger.LogPanic("CalculateOrderDeal", `"filled" and "avgPrice" can not one zero one not zero`)
+		logger.LogStrict("CalculateOrderDeal", `"filled" and "avgPrice" can not one zero one not zero`)
 		price = decimal.Zero
 		amount = decimal.Zero
 	}
@@ -187,3 +188,18 @@ func PriceInRange(px decimal.Decimal, dir OrderDir, t CommonTrader) bool {
 		return px.GreaterThanOrEqual(px0) && px.LessThanOrEqual(px1)
 	}
 }
+
+// 按24小时成交额筛选品种，结果按成交额由大到小排列
+func FilterByVolume24h[T CommonMarket](markets []T, minVolumeUsd decimal.Decimal) []T {
+	result := []T{}
+	for _, m := range markets {
+		if m.Ticker24h().VolumeUsd.GreaterThanOrEqual(minVolumeUsd) {
+			result = append(result, m)
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b T) int {
+		return b.Ticker24h().VolumeUsd.Cmp(a.Ticker24h().VolumeUsd)
+	})
+	return result
+}
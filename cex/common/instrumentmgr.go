@@ -9,6 +9,7 @@ package common
 import (
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
@@ -21,6 +22,7 @@ type InstrumentMgr struct {
 	logPrefix       string
 	instrumentsById map[string] /*instId*/ *Instruments
 	instruments     []*Instruments
+	trimZeros       bool // 格式化价格/数量时是否去掉末尾的0
 }
 
 func NewInstrumentMgr(logPrefix string) *InstrumentMgr {
@@ -31,6 +33,13 @@ func NewInstrumentMgr(logPrefix string) *InstrumentMgr {
 	return i
 }
 
+// 设置价格/数量格式化规则
+func (i *InstrumentMgr) SetTrimZeros(trim bool) {
+	i.Lock()
+	defer i.Unlock()
+	i.trimZeros = trim
+}
+
 func (i *InstrumentMgr) Set(instId string, ins *Instruments) {
 	i.Lock()
 	defer i.Unlock()
@@ -48,6 +57,63 @@ func (i *InstrumentMgr) Get(instId string) *Instruments {
 	}
 }
 
+// 上线后的一段时间内，交易所的状态更新可能滞后，忽略这段时间内的暂停状态
+const listingGrace = time.Minute
+
+// 交易状态，未知品种视为暂停
+// 上线前的状态（预上线/只挂单/集合竞价）在到达上线时间后自动视为正常交易
+func (i *InstrumentMgr) Status(instId string) InstrumentStatus {
+	i.Lock()
+	defer i.Unlock()
+	if v, ok := i.instrumentsById[instId]; ok {
+		if v.Status != InstrumentStatus_Trading &&
+			v.Status != InstrumentStatus_Halted &&
+			!v.ListTime.IsZero() &&
+			!time.Now().Before(v.ListTime) {
+			return InstrumentStatus_Trading
+		}
+		return v.Status
+	} else {
+		return InstrumentStatus_Halted
+	}
+}
+
+func (i *InstrumentMgr) SetStatus(instId string, status InstrumentStatus) {
+	i.Lock()
+	defer i.Unlock()
+	if v, ok := i.instrumentsById[instId]; ok {
+		if status == InstrumentStatus_Halted && !v.ListTime.IsZero() {
+			now := time.Now()
+			if now.Before(v.ListTime) {
+				status = InstrumentStatus_PreOpen // 上线前交易所一般报告为暂停
+			} else if now.Before(v.ListTime.Add(listingGrace)) {
+				return
+			}
+		}
+
+		if v.Status != status {
+			logger.LogImportant(i.logPrefix, "instrument %s status changed: %s -> %s", instId, v.Status.String(), status.String())
+			v.Status = status
+		}
+	}
+}
+
+// 登记上线时间。上线前品种为预上线状态，到达上线时间后自动切换为可交易
+func (i *InstrumentMgr) SetListTime(instId string, listTime time.Time) bool {
+	i.Lock()
+	defer i.Unlock()
+	if v, ok := i.instrumentsById[instId]; ok {
+		v.ListTime = listTime
+		if time.Now().Before(listTime) && v.Status == InstrumentStatus_Halted {
+			v.Status = InstrumentStatus_PreOpen
+		}
+		logger.LogImportant(i.logPrefix, "instrument %s list time set: %s, status: %s", instId, listTime.Format(time.RFC3339Nano), v.Status.String())
+		return true
+	} else {
+		return false
+	}
+}
+
 func (i *InstrumentMgr) GetAll() []*Instruments {
 	i.Lock()
 	temp := slices.Clone(i.instruments)
@@ -72,7 +138,7 @@ func (i *InstrumentMgr) AlignPriceNumber(instId string, price decimal.Decimal) d
 
 		return price
 	} else {
-		logger.LogPanic(i.logPrefix, "unknown instid:%s", instId)
+		logger.LogStrict(i.logPrefix, "unknown instid:%s", instId)
 		return decimal.Zero
 	}
 }
@@ -113,7 +179,7 @@ func (i *InstrumentMgr) AlignPrice(instId string, price decimal.Decimal, dir Ord
 		}
 		return price
 	} else {
-		logger.LogPanic(i.logPrefix, "unknown instid:%s", instId)
+		logger.LogStrict(i.logPrefix, "unknown instid:%s", instId)
 		return decimal.Zero
 	}
 }
@@ -132,7 +198,7 @@ func (i *InstrumentMgr) alignSize(instId string, size decimal.Decimal) decimal.D
 		size = inst.LotSize.Mul(decimal.NewFromInt(c))
 		return size
 	} else {
-		logger.LogPanic(i.logPrefix, "unknown instid:%s", instId)
+		logger.LogStrict(i.logPrefix, "unknown instid:%s", instId)
 		return decimal.Zero
 	}
 }
@@ -145,7 +211,70 @@ func (i *InstrumentMgr) MinSize(instId string, price decimal.Decimal) decimal.De
 		// 兼顾MinSize和MinValue
 		return i.alignSize(instId, decimal.Max(inst.MinSize, inst.MinValue.Div(price)))
 	} else {
-		logger.LogPanic(i.logPrefix, "unknown instid:%s", instId)
+		logger.LogStrict(i.logPrefix, "unknown instid:%s", instId)
+		return decimal.Zero
+	}
+}
+
+// 未知品种返回0，由调用者处理
+func (i *InstrumentMgr) TickSize(instId string) decimal.Decimal {
+	i.Lock()
+	defer i.Unlock()
+
+	if inst, ok := i.instrumentsById[instId]; ok {
+		return inst.TickSize
+	} else {
+		logger.LogImportant(i.logPrefix, "unknown instid:%s", instId)
 		return decimal.Zero
 	}
 }
+
+// 按品种精度四舍五入
+func (i *InstrumentMgr) RoundPrice(instId string, price decimal.Decimal) decimal.Decimal {
+	i.Lock()
+	defer i.Unlock()
+	if inst, ok := i.instrumentsById[instId]; ok {
+		return price.Round(StepPlaces(inst.TickSize))
+	}
+	return price
+}
+
+func (i *InstrumentMgr) RoundSize(instId string, size decimal.Decimal) decimal.Decimal {
+	i.Lock()
+	defer i.Unlock()
+	if inst, ok := i.instrumentsById[instId]; ok {
+		return size.Round(StepPlaces(inst.LotSize))
+	}
+	return size
+}
+
+// 提交给交易所的价格字符串
+func (i *InstrumentMgr) FormatPrice(instId string, price decimal.Decimal) string {
+	i.Lock()
+	defer i.Unlock()
+	if inst, ok := i.instrumentsById[instId]; ok {
+		return FormatDecimalByStep(price, inst.TickSize, i.trimZeros)
+	}
+	return price.String()
+}
+
+// 提交给交易所的计价币数量字符串(如币安的quoteOrderQty)，截断而不是四舍五入
+func (i *InstrumentMgr) FormatQuoteSize(instId string, quoteSize decimal.Decimal) string {
+	i.Lock()
+	defer i.Unlock()
+	if inst, ok := i.instrumentsById[instId]; ok {
+		places := util.ValueIf(inst.QuotePrecision > 0, inst.QuotePrecision, StepPlaces(inst.TickSize))
+		return FormatDecimalTruncate(quoteSize, places, i.trimZeros)
+	}
+	return quoteSize.String()
+}
+
+// 提交给交易所的数量字符串
+func (i *InstrumentMgr) FormatSize(instId string, size decimal.Decimal) string {
+	i.Lock()
+	defer i.Unlock()
+	if inst, ok := i.instrumentsById[instId]; ok {
+		return FormatDecimalByStep(size, inst.LotSize, i.trimZeros)
+	}
+	return size.String()
+}
@@ -50,6 +50,31 @@ func OppositeDir(dir OrderDir) OrderDir {
 	}
 }
 
+// 订单有效方式
+type TimeInForce int
+
+const (
+	TimeInForce_GTC TimeInForce = iota // 一直有效直到成交或撤销
+	TimeInForce_IOC                    // 立即成交，剩余部分撤销
+	TimeInForce_FOK                    // 全部立即成交，否则撤销
+	TimeInForce_GTX                    // 只挂单(post-only)
+)
+
+func (t TimeInForce) String() string {
+	switch t {
+	case TimeInForce_GTC:
+		return "GTC"
+	case TimeInForce_IOC:
+		return "IOC"
+	case TimeInForce_FOK:
+		return "FOK"
+	case TimeInForce_GTX:
+		return "GTX"
+	default:
+		return "unknown"
+	}
+}
+
 // 订单成交（实时）
 type Deal struct {
 	LocalTime time.Time // 该成交时间到达本地的时间戳，用于计算系统性能
@@ -59,6 +84,16 @@ type Deal struct {
 	Amount    decimal.Decimal
 }
 
+// 公开成交(逐笔)
+type PublicTrade struct {
+	LocalTime time.Time // 到达本地的时间
+	UTime     time.Time // 服务器成交时间
+	Id        string
+	Price     decimal.Decimal
+	Size      decimal.Decimal
+	Dir       OrderDir // 主动成交方(taker)的方向，无法判断时为OrderDir_None
+}
+
 // 订单成交（历史）
 type DealHistory struct {
 	Time   time.Time
@@ -143,12 +178,18 @@ type CommonMarket interface {
 	Uninit()
 	LatestPrice() decimal.Decimal
 	OrderBook() *Orderbook
+	DepthConsistent() bool // 本地深度是否与交易所一致(增量序列连续、校验通过)
 	AlignPriceNumber(price decimal.Decimal) decimal.Decimal
 	AlignPrice(price decimal.Decimal, dir OrderDir, makeOnly bool) decimal.Decimal
 	AlignSize(size decimal.Decimal) decimal.Decimal
 	MinSize() decimal.Decimal
+	TickSize() decimal.Decimal // 价格最小变动单位
 	AddDepthObserver(o DepthObserver)
 	RemoveDepthObserver(o DepthObserver)
+	SubscribeTrades(fn func(t PublicTrade)) int // 订阅逐笔成交，首次调用时才会订阅相应频道。返回的Id用于取消订阅
+	UnsubscribeTrades(id int)                   // 最后一个订阅者取消时退订相应频道
+	Bars(cfg BarConfig) *BarBuilder             // 由逐笔成交合成的K线，同一配置复用同一个合成器。配置无效时返回nil
+	Ticker24h() Ticker24h                       // 24小时滚动统计，来自ticker推送/rest
 }
 
 // 合约行情接口
@@ -159,10 +200,15 @@ type FutureMarket interface {
 	ContractType() string                                                  // 合约种类，表示是哪种合约。可选：usd_swap/usdt_swap/this_week/next_week/this_quarter/next_quarter
 	IsUsdtContract() bool                                                  // 是否为U本位合约
 	MarkPrice() decimal.Decimal                                            // 标记价格，用于计算仓位浮动盈亏的价格。如果交易所不提供，则使用中间价代替
+	IndexPrice() decimal.Decimal                                           // 指数价格，需在交易所配置中开启，否则为0
 	ValueAmount() decimal.Decimal                                          // 单位合约面值数量
 	ValueCurrency() string                                                 // 面值单位币种，usdt合约为币，usd合约为usdt
 	SettlementCurrency() string                                            // 保证金币种
 	FundingInfo() (decimal.Decimal, decimal.Decimal, time.Time, time.Time) // 当期费率、下期费率、当期时间
+	FundingDetail() FundingDetail                                          // 资金费率详情，含结算周期和上下限
+	FundingForecast() FundingForecast                                      // 本期结算费率的预测，见FundingPredictor
+	OpenInterest() OpenInterest                                            // 最新持仓总量，需在交易所配置中开启
+	OpenInterestHistory(t0 time.Time) []OpenInterest                       // t0之后的持仓总量记录(本地采集，按分钟保留)
 	AddLiquidationObserver(o LiquidationObserver)                          // 注册市场爆仓观察器
 	RemoveLiquidationObserver(o LiquidationObserver)                       //
 }
@@ -185,6 +231,11 @@ type CommonTrader interface {
 	BuyPriceRange() (min, max decimal.Decimal)
 	SellPriceRange() (min, max decimal.Decimal)
 	MakeOrder(price, amount decimal.Decimal, dir OrderDir, makeOnly, reduceOnly bool, purpose string, observer OrderObserver) Order
+	MakeOrderTIF(price, amount decimal.Decimal, dir OrderDir, tif TimeInForce, reduceOnly bool, purpose string, observer OrderObserver) Order // 指定有效方式下单，MakeOrder相当于GTC/GTX
+
+	// 市价单。byQuote为true时amount为计价币数量（仅现货）
+	// 按当前盘口估算的滑点超过maxSlippage(如0.002)时拒绝下单，maxSlippage为0表示不检查
+	MakeMarketOrder(amount decimal.Decimal, dir OrderDir, byQuote bool, maxSlippage decimal.Decimal, reduceOnly bool, purpose string, observer OrderObserver) Order
 	Orders() []Order
 	FeeTaker() decimal.Decimal
 	FeeMaker() decimal.Decimal
@@ -203,6 +254,11 @@ type FutureTrader interface {
 	Balance() Balance
 	AssetId() int // 合约保证金资产Id，不同交易器中的权益，如果是同一个Id，则认为是同一份资产
 	Position() Position
+	MarginInfo() MarginInfo   // 保证金率、强平价格等，见margin.go
+	MarginTiers() MarginTiers // 维持保证金阶梯，见liqprice.go
+
+	// 预估强平价格，dir为仓位方向，margin为0时按杠杆计算逐仓保证金
+	EstimateLiqPrice(dir OrderDir, size, entryPx, margin decimal.Decimal) decimal.Decimal
 }
 
 // 现货交易接口
@@ -0,0 +1,108 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-24 09:41:12
+- @Description: 预估强平价格。仓位的保证金+未实现盈亏 = 维持保证金率*仓位价值 时触发强平，维持保证金率按仓位数量所在的阶梯档位取值
+- 1. U本位合约：价值=张数*面值*价格，保证金以计价币计
+- 2. 币本位合约：价值=张数*面值/价格，保证金以币计
+- 逐仓时保证金为仓位保证金，未指定时按开仓价值/杠杆计算；全仓时应传入账户中可用于该仓位的权益
+- 不计手续费和资金费，结果为近似值
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// 维持保证金阶梯的一档，数量单位为张
+type MarginTier struct {
+	Tier            int
+	MinSize         decimal.Decimal
+	MaxSize         decimal.Decimal // 0表示无上限
+	MaintMarginRate decimal.Decimal
+	InitMarginRate  decimal.Decimal
+	MaxLever        int
+}
+
+type MarginTiers []MarginTier
+
+// 某仓位数量对应的维持保证金率。没有档位数据时返回0
+func (ts MarginTiers) MaintMarginRate(size decimal.Decimal) decimal.Decimal {
+	size = size.Abs()
+	for _, t := range ts {
+		if size.LessThanOrEqual(t.MaxSize) || !t.MaxSize.IsPositive() {
+			return t.MaintMarginRate
+		}
+	}
+
+	if len(ts) > 0 {
+		return ts[len(ts)-1].MaintMarginRate
+	}
+	return decimal.Zero
+}
+
+type LiqParams struct {
+	Dir        OrderDir        // 仓位方向，Buy为多仓
+	Size       decimal.Decimal // 张数
+	EntryPrice decimal.Decimal
+	Margin     decimal.Decimal // 保证金，0表示逐仓且按杠杆计算
+	Lever      int
+	CtVal      decimal.Decimal // 合约面值，0视为1
+	Inverse    bool            // 币本位合约
+	Tiers      MarginTiers
+}
+
+// 计算预估强平价格，返回0表示不会强平或参数无效
+func EstimateLiqPrice(p LiqParams) decimal.Decimal {
+	sz := p.Size.Abs()
+	if sz.IsZero() || !p.EntryPrice.IsPositive() {
+		return decimal.Zero
+	}
+
+	ctVal := p.CtVal
+	if !ctVal.IsPositive() {
+		ctVal = decimal.NewFromInt(1)
+	}
+
+	one := decimal.NewFromInt(1)
+	mmr := p.Tiers.MaintMarginRate(sz)
+	amount := sz.Mul(ctVal) // U本位为币数量，币本位为美元价值
+	margin := p.Margin
+	if margin.IsZero() && p.Lever > 0 {
+		if p.Inverse {
+			margin = amount.Div(p.EntryPrice).Div(decimal.NewFromInt(int64(p.Lever)))
+		} else {
+			margin = amount.Mul(p.EntryPrice).Div(decimal.NewFromInt(int64(p.Lever)))
+		}
+	}
+
+	long := p.Dir == OrderDir_Buy
+	var px, denom decimal.Decimal
+	if p.Inverse {
+		// 多: M + A*(1/E - 1/P) = mmr*A/P => P = A*(1+mmr) / (M + A/E)
+		// 空: M + A*(1/P - 1/E) = mmr*A/P => P = A*(1-mmr) / (A/E - M)
+		base := amount.Div(p.EntryPrice)
+		if long {
+			px = amount.Mul(one.Add(mmr))
+			denom = margin.Add(base)
+		} else {
+			px = amount.Mul(one.Sub(mmr))
+			denom = base.Sub(margin)
+		}
+	} else {
+		// 多: M + A*(P-E) = mmr*A*P => P = (A*E - M) / (A*(1-mmr))
+		// 空: M + A*(E-P) = mmr*A*P => P = (A*E + M) / (A*(1+mmr))
+		if long {
+			px = amount.Mul(p.EntryPrice).Sub(margin)
+			denom = amount.Mul(one.Sub(mmr))
+		} else {
+			px = amount.Mul(p.EntryPrice).Add(margin)
+			denom = amount.Mul(one.Add(mmr))
+		}
+	}
+
+	if !denom.IsPositive() || !px.IsPositive() {
+		return decimal.Zero
+	}
+	return px.Div(denom)
+}
@@ -0,0 +1,116 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-03 10:20:44
+- @Description: 市场爆仓数据的统一格式，以及按品种、按分钟的爆仓金额统计
+- Dir为强平单的方向：sell代表多仓被强平，buy代表空仓被强平
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 一笔爆仓
+type Liquidation struct {
+	Exchange string
+	InstId   string
+	Time     time.Time
+	Price    decimal.Decimal
+	Size     decimal.Decimal // 交易所原始数量(张或币)
+	Notional decimal.Decimal // 折算为USD的金额
+	Dir      OrderDir
+}
+
+// 一分钟内的爆仓统计
+type LiquidationMinute struct {
+	Time         time.Time
+	BuyNotional  decimal.Decimal // 空仓被强平的金额
+	SellNotional decimal.Decimal // 多仓被强平的金额
+	Count        int
+}
+
+func (l LiquidationMinute) Total() decimal.Decimal {
+	return l.BuyNotional.Add(l.SellNotional)
+}
+
+// 按品种、按分钟统计爆仓金额
+type LiquidationAggregator struct {
+	mu       sync.Mutex
+	keep     int // 每个品种保留的分钟数
+	minutes  map[string] /*instId*/ []LiquidationMinute
+	fnMinute func(instId string, m LiquidationMinute)
+}
+
+// keep为每个品种保留的分钟数，默认1440
+func (a *LiquidationAggregator) Init(keep int) {
+	a.keep = keep
+	if a.keep <= 0 {
+		a.keep = 1440
+	}
+	a.minutes = make(map[string][]LiquidationMinute)
+}
+
+// 每分钟统计完成时回调(在该品种下一分钟的第一笔爆仓到来时触发)
+func (a *LiquidationAggregator) SetMinuteFn(fn func(instId string, m LiquidationMinute)) {
+	a.fnMinute = fn
+}
+
+func (a *LiquidationAggregator) OnLiquidation(l Liquidation) {
+	t := l.Time.Truncate(time.Minute)
+
+	a.mu.Lock()
+	ms := a.minutes[l.InstId]
+	closed, hasClosed := LiquidationMinute{}, false
+	// 迟到的推送计入最后一分钟
+	if n := len(ms); n == 0 || ms[n-1].Time.Before(t) {
+		if n > 0 {
+			closed, hasClosed = ms[n-1], true
+		}
+		ms = append(ms, LiquidationMinute{Time: t})
+		if len(ms) > a.keep {
+			ms = ms[len(ms)-a.keep:]
+		}
+	}
+
+	m := &ms[len(ms)-1]
+	if l.Dir == OrderDir_Buy {
+		m.BuyNotional = m.BuyNotional.Add(l.Notional)
+	} else {
+		m.SellNotional = m.SellNotional.Add(l.Notional)
+	}
+	m.Count++
+	a.minutes[l.InstId] = ms
+	a.mu.Unlock()
+
+	if hasClosed && a.fnMinute != nil {
+		a.fnMinute(l.InstId, closed)
+	}
+}
+
+// 某品种最近n分钟的统计(只包含有爆仓的分钟)，n<=0表示全部
+func (a *LiquidationAggregator) Minutes(instId string, n int) []LiquidationMinute {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ms := a.minutes[instId]
+	if n > 0 && n < len(ms) {
+		ms = ms[len(ms)-n:]
+	}
+	return append([]LiquidationMinute{}, ms...)
+}
+
+// 某品种从t0开始的爆仓总金额
+func (a *LiquidationAggregator) NotionalSince(instId string, t0 time.Time) (buy, sell decimal.Decimal) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, m := range a.minutes[instId] {
+		if !m.Time.Before(t0.Truncate(time.Minute)) {
+			buy = buy.Add(m.BuyNotional)
+			sell = sell.Add(m.SellNotional)
+		}
+	}
+	return
+}
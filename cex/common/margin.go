@@ -0,0 +1,39 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-23 10:30:16
+- @Description: 合约交易器的保证金状况(FutureTrader.MarginInfo)。全仓时权益和维持保证金为账户级，强平价格为本品种仓位的
+- 交易所不提供的字段为0
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type MarginInfo struct {
+	Equity      decimal.Decimal // 有效保证金
+	MaintMargin decimal.Decimal // 维持保证金
+	MarginRatio decimal.Decimal // 有效保证金/维持保证金，越小越危险，小于等于1时强平。没有仓位时为0
+	MarkPrice   decimal.Decimal
+	LiqPrice    decimal.Decimal // 预估强平价格，0表示未知或没有仓位
+	Time        time.Time
+}
+
+// 标记价格到强平价格的距离(相对标记价格的比例)，强平价格未知时返回false
+func (m MarginInfo) LiqDistance() (decimal.Decimal, bool) {
+	if !m.LiqPrice.IsPositive() || !m.MarkPrice.IsPositive() {
+		return decimal.Zero, false
+	}
+	return m.MarkPrice.Sub(m.LiqPrice).Abs().Div(m.MarkPrice), true
+}
+
+// 由权益和维持保证金计算保证金率
+func MarginRatioOf(equity, maint decimal.Decimal) decimal.Decimal {
+	if !maint.IsPositive() {
+		return decimal.Zero
+	}
+	return equity.Div(maint)
+}
@@ -0,0 +1,51 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-26 14:20:33
+- @Description: 市价单的滑点保护
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// 按当前盘口估算市价单的成交均价，检查滑点
+// byQuote为true时amount为计价币数量，按对手方一档价格折算为基础币数量
+// 返回保护价格（对手方一档按maxSlippage偏移）和预计成交的基础币数量
+func CheckMarketOrder(ob *Orderbook, dir OrderDir, amount decimal.Decimal, byQuote bool, maxSlippage decimal.Decimal) (guardPrice, baseAmount decimal.Decimal, err error) {
+	best := decimal.Zero
+	if dir == OrderDir_Buy {
+		best = ob.Sell1Price()
+	} else if dir == OrderDir_Sell {
+		best = ob.Buy1Price()
+	}
+
+	if !best.IsPositive() {
+		return decimal.Zero, decimal.Zero, fmt.Errorf("orderbook empty")
+	}
+
+	baseAmount = amount
+	if byQuote {
+		baseAmount = amount.Div(best)
+	}
+
+	if dir == OrderDir_Buy {
+		guardPrice = best.Mul(decimal.NewFromInt(1).Add(maxSlippage))
+	} else {
+		guardPrice = best.Mul(decimal.NewFromInt(1).Sub(maxSlippage))
+	}
+
+	if maxSlippage.IsPositive() {
+		est := ob.EstimateFill(dir, baseAmount)
+		if est.Slippage.GreaterThan(maxSlippage) {
+			return guardPrice, baseAmount, fmt.Errorf("estimated slippage %v exceeds %v(best=%v, avg=%v)", est.Slippage.StringFixed(5), maxSlippage, best, est.AvgPrice)
+		}
+	} else {
+		guardPrice = best
+	}
+
+	return guardPrice, baseAmount, nil
+}
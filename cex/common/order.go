@@ -20,6 +20,7 @@ import (
 
 type OrderImpl struct {
 	LogPrefix     string
+	LogFields     logger.Fields   // 结构化日志的字段，Exchange由各交易所在Init之前设置
 	InstrumentMgr *InstrumentMgr  // 用于对齐价格、数量
 	Trader        CommonTrader    // 所属Traqder
 	InstId        string          // 现货交易对、合约Id等类型标识
@@ -30,6 +31,9 @@ type OrderImpl struct {
 	Dir           OrderDir        // 订单方向
 	ReduceOnly    bool            // 只减仓(仅合约有效)
 	MakeOnly      bool            // 只挂单
+	TimeInForce   TimeInForce     // 有效方式
+	MarketOrder   bool            // 是否为市价单。市价单的Price为保护价格(最差可接受价格)
+	QuoteSize     decimal.Decimal // 市价单按计价币数量下单时的数量，此时Size为估算的基础币数量
 	Purpose       string          // 订单目的（调试用）
 	Filled        decimal.Decimal // 已成交数量
 	AvgPrice      decimal.Decimal // 平均成交价格
@@ -42,6 +46,35 @@ type OrderImpl struct {
 
 	// 成交回调
 	Observers []OrderObserver
+
+	// 链路追踪，未开启时为nil
+	trace *orderTrace
+
+	// 审计记录，未开启时为nil
+	audit *orderAudit
+
+	// 往返延迟统计，未开启时为nil
+	latency *orderLatency
+
+	// 隔离的订单(见Isolate)
+	isolated bool
+	checkers []PreTradeChecker
+}
+
+// 不使用全局的下单前检查、审计、追踪和延迟统计，只使用checkers做下单前检查，拒绝时也不发布到DefaultEventBus
+// 用于回测，避免并行的回测之间、回测与实盘之间互相影响。需在Init/InitMarket之前调用
+func (o *OrderImpl) Isolate(checkers []PreTradeChecker) {
+	o.isolated = true
+	o.checkers = checkers
+}
+
+// Init/InitMarket成功后调用
+func (o *OrderImpl) startRecords() {
+	if !o.isolated {
+		o.startTrace()
+		o.startAudit()
+		o.startLatency()
+	}
 }
 
 // 初始化订单，矫正价格、数量
@@ -53,14 +86,34 @@ func (o *OrderImpl) Init(
 	dir OrderDir,
 	makeOnly, reduceOnly bool,
 	purpose string) bool {
+	return o.InitTIF(trader, instrumentMgr, instId, price, amount, dir, util.ValueIf(makeOnly, TimeInForce_GTX, TimeInForce_GTC), reduceOnly, purpose)
+}
+
+// 指定有效方式的初始化，GTX即为只挂单。风控检查和订单记录都能看到实际的有效方式
+func (o *OrderImpl) InitTIF(
+	trader CommonTrader,
+	instrumentMgr *InstrumentMgr,
+	instId string,
+	price, amount decimal.Decimal,
+	dir OrderDir,
+	tif TimeInForce,
+	reduceOnly bool,
+	purpose string) bool {
+	makeOnly := tif == TimeInForce_GTX
 	o.Trader = trader
 	o.InstrumentMgr = instrumentMgr
 	o.InstId = instId
 	o.Dir = dir
 	o.ReduceOnly = reduceOnly
 	o.MakeOnly = makeOnly
+	o.TimeInForce = tif
 	o.Purpose = purpose
 
+	if status := instrumentMgr.Status(instId); !status.OrderAllowed(makeOnly) {
+		o.LogFields.LogInfo(o.LogPrefix, "creating order failed, instrument %s is %s(makeOnly=%v)", instId, status.String(), makeOnly)
+		return false
+	}
+
 	o.Price = instrumentMgr.AlignPrice(
 		instId,
 		price,
@@ -74,7 +127,7 @@ func (o *OrderImpl) Init(
 	amount = instrumentMgr.AlignSize(instId, amount)      // 对齐
 	minSize := instrumentMgr.MinSize(instId, price)
 	if amount.LessThan(minSize) {
-		logger.LogInfo(o.LogPrefix, "creating order failed, size too small(instId=%s, raw amount=%v, aligned size=%v, minSize=%v)",
+		o.LogFields.LogInfo(o.LogPrefix, "creating order failed, size too small(instId=%s, raw amount=%v, aligned size=%v, minSize=%v)",
 			instId,
 			amount,
 			o.Size,
@@ -83,15 +136,80 @@ func (o *OrderImpl) Init(
 	}
 
 	if !PriceInRange(o.Price, o.Dir, o.Trader) {
-		logger.LogInfo(o.LogPrefix, "creating order failed, price(%v) out of range", o.Price)
+		o.LogFields.LogInfo(o.LogPrefix, "creating order failed, price(%v) out of range", o.Price)
 		return false
 	}
 
 	o.Size = amount
 	o.LogPrefix = fmt.Sprintf("Order-%s-%v", o.InstId, o.CltOrderId)
+	o.LogFields.InstId = o.InstId
+	o.LogFields.OrderId = fmt.Sprintf("%v", o.CltOrderId)
+	if checkPreTrade(o) != nil {
+		return false
+	}
+
+	o.Status = "born"
+	o.Borntime = time.Now()
+	o.Observers = make([]OrderObserver, 0)
+	o.startRecords()
+	return true
+}
+
+// 初始化市价单，guardPrice为保护价格，一般由CheckMarketOrder得到
+func (o *OrderImpl) InitMarket(
+	trader CommonTrader,
+	instrumentMgr *InstrumentMgr,
+	instId string,
+	guardPrice, amount, quoteAmount decimal.Decimal,
+	dir OrderDir,
+	reduceOnly bool,
+	purpose string) bool {
+	o.Trader = trader
+	o.InstrumentMgr = instrumentMgr
+	o.InstId = instId
+	o.Dir = dir
+	o.ReduceOnly = reduceOnly
+	o.TimeInForce = TimeInForce_IOC
+	o.MarketOrder = true
+	o.Purpose = purpose
+	o.LogPrefix = fmt.Sprintf("Order-%s-%v", o.InstId, o.CltOrderId)
+	o.LogFields.InstId = o.InstId
+	o.LogFields.OrderId = fmt.Sprintf("%v", o.CltOrderId)
+
+	if status := instrumentMgr.Status(instId); !status.OrderAllowed(false) {
+		o.LogFields.LogInfo(o.LogPrefix, "creating market order failed, instrument %s is %s", instId, status.String())
+		return false
+	}
+
+	o.Price = guardPrice
+	max := o.Trader.AvailableAmount(o.Dir, o.Price)
+	if quoteAmount.IsPositive() {
+		// 按计价币下单时，只检查不截断
+		if amount.GreaterThan(max) {
+			o.LogFields.LogInfo(o.LogPrefix, "creating market order failed, quote amount %v exceeds available", quoteAmount)
+			return false
+		}
+		o.QuoteSize = quoteAmount
+	} else {
+		amount = util.ClampDecimal(amount, decimal.Zero, max)
+		amount = instrumentMgr.AlignSize(instId, amount)
+	}
+
+	minSize := instrumentMgr.MinSize(instId, guardPrice)
+	if amount.LessThan(minSize) {
+		o.LogFields.LogInfo(o.LogPrefix, "creating market order failed, size too small(instId=%s, amount=%v, minSize=%v)", instId, amount, minSize)
+		return false
+	}
+
+	o.Size = amount
+	if checkPreTrade(o) != nil {
+		return false
+	}
+
 	o.Status = "born"
 	o.Borntime = time.Now()
 	o.Observers = make([]OrderObserver, 0)
+	o.startRecords()
 	return true
 }
 
@@ -0,0 +1,178 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-06 09:48:12
+- @Description: 订单往返延迟统计，按(交易所,阶段)聚合成直方图(桶同network.LatencyBuckets)
+- 各阶段都从下单请求发出(RecordPlace，含ActionQueue的排队时间)开始计时，每个订单只计第一次：
+- ack: 得到交易所订单Id    update: 收到第一次ws推送的订单更新    fill: 第一次成交
+- ws推送、成交的时间取本地收到数据的时间(LocalTime)，没有时取调用时间
+- 与http请求的统计(network.Metrics)配合，可以区分网络延迟与交易所撮合、推送的延迟
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util/network"
+)
+
+var EnableOrderLatency = true
+
+const (
+	LatencyStage_Ack    = "ack"
+	LatencyStage_Update = "update"
+	LatencyStage_Fill   = "fill"
+)
+
+type OrderLatencyStats struct {
+	Exchange   string
+	Stage      string
+	Count      int64
+	Latency    []int64 // 各个桶的次数，长度为len(network.LatencyBuckets)+1
+	LatencySum time.Duration
+	LatencyMax time.Duration
+}
+
+func (s OrderLatencyStats) AvgLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.LatencySum / time.Duration(s.Count)
+}
+
+// 延迟分位数(0~1)，取所在桶的上限，落在最后一个桶时取最大值
+func (s OrderLatencyStats) Percentile(p float64) time.Duration {
+	return network.LatencyPercentile(s.Latency, s.Count, s.LatencyMax, p)
+}
+
+type orderLatencyKey struct {
+	exchange string
+	stage    string
+}
+
+var orderLatencies = make(map[orderLatencyKey]*OrderLatencyStats)
+var muOrderLatency sync.Mutex
+var fnOrderLatencySink func(exchange, stage string, latency time.Duration)
+
+// 每次记录时调用，可接入外部的指标系统
+func SetOrderLatencySink(fn func(exchange, stage string, latency time.Duration)) {
+	muOrderLatency.Lock()
+	defer muOrderLatency.Unlock()
+	fnOrderLatencySink = fn
+}
+
+// 所有统计的快照，按交易所、阶段排序
+func OrderLatencies() []OrderLatencyStats {
+	muOrderLatency.Lock()
+	defer muOrderLatency.Unlock()
+	all := make([]OrderLatencyStats, 0, len(orderLatencies))
+	for _, s := range orderLatencies {
+		all = append(all, s.clone())
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Exchange != all[j].Exchange {
+			return all[i].Exchange < all[j].Exchange
+		}
+		return all[i].Stage < all[j].Stage
+	})
+	return all
+}
+
+func OrderLatencyOf(exchange, stage string) (OrderLatencyStats, bool) {
+	muOrderLatency.Lock()
+	defer muOrderLatency.Unlock()
+	if s, ok := orderLatencies[orderLatencyKey{exchange, stage}]; ok {
+		return s.clone(), true
+	}
+	return OrderLatencyStats{}, false
+}
+
+func ResetOrderLatencies() {
+	muOrderLatency.Lock()
+	defer muOrderLatency.Unlock()
+	orderLatencies = make(map[orderLatencyKey]*OrderLatencyStats)
+}
+
+func (s *OrderLatencyStats) clone() OrderLatencyStats {
+	c := *s
+	c.Latency = append([]int64{}, s.Latency...)
+	return c
+}
+
+func recordOrderLatency(exchange, stage string, latency time.Duration) {
+	latency = max(latency, 0)
+	muOrderLatency.Lock()
+	key := orderLatencyKey{exchange, stage}
+	s, ok := orderLatencies[key]
+	if !ok {
+		s = &OrderLatencyStats{Exchange: exchange, Stage: stage, Latency: make([]int64, len(network.LatencyBuckets)+1)}
+		orderLatencies[key] = s
+	}
+
+	s.Count++
+	s.Latency[network.LatencyBucket(latency)]++
+	s.LatencySum += latency
+	s.LatencyMax = max(s.LatencyMax, latency)
+	fn := fnOrderLatencySink
+	muOrderLatency.Unlock()
+
+	if fn != nil {
+		fn(exchange, stage, latency)
+	}
+}
+
+// #region 订单的计时
+type orderLatency struct {
+	mu      sync.Mutex
+	placeAt time.Time
+	stages  map[string]bool // 已记录的阶段
+}
+
+// Init/InitMarket成功后调用
+func (o *OrderImpl) startLatency() {
+	if EnableOrderLatency {
+		o.latency = &orderLatency{stages: make(map[string]bool)}
+	}
+}
+
+func (o *OrderImpl) latencyPlace() {
+	if l := o.latency; l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.placeAt.IsZero() {
+			l.placeAt = time.Now()
+		}
+	}
+}
+
+// 只记录每个阶段的第一次。t为零值时取当前时间
+func (o *OrderImpl) latencyStage(stage string, t time.Time) {
+	l := o.latency
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if l.placeAt.IsZero() || l.stages[stage] {
+		l.mu.Unlock()
+		return
+	}
+	l.stages[stage] = true
+	placeAt := l.placeAt
+	l.mu.Unlock()
+
+	if t.IsZero() {
+		t = time.Now()
+	}
+	recordOrderLatency(o.LogFields.Exchange, stage, t.Sub(placeAt))
+}
+
+// 收到ws推送的订单更新时调用，localTime为本地收到推送的时间
+func (o *OrderImpl) RecordWsUpdate(localTime time.Time) {
+	o.latencyStage(LatencyStage_Update, localTime)
+}
+
+// #endregion
@@ -0,0 +1,119 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-01 15:02:44
+- @Description: 订单生命周期的链路追踪(见util/tracing)
+- span从订单创建(Init)开始，即策略做出决定的时刻，到订单完结结束，期间的事件：
+- place: 下单请求发出    ack: 交易所确认(得到订单Id)    fill: 每次成交
+- ack、首次成交时记录距创建的延迟(毫秒)，便于按延迟筛选
+- 由OrderImpl.RecordXXX调用(见audit.go)，未开启追踪时什么都不做
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type orderTrace struct {
+	mu      sync.Mutex
+	span    trace.Span
+	acked   bool
+	dealt   bool
+	ended   bool
+	startAt time.Time
+}
+
+// Init/InitMarket成功后调用
+func (o *OrderImpl) startTrace() {
+	if !tracing.Enabled() {
+		return
+	}
+
+	_, span := tracing.Start(context.Background(), "order", trace.SpanKindInternal,
+		attribute.String("dagger.exchange", o.LogFields.Exchange),
+		attribute.String("dagger.inst_id", o.InstId),
+		attribute.String("dagger.client_order_id", fmt.Sprintf("%v", o.CltOrderId)),
+		attribute.String("dagger.dir", OrderDir2Str(o.Dir)),
+		attribute.String("dagger.price", o.Price.String()),
+		attribute.String("dagger.size", o.Size.String()),
+		attribute.String("dagger.tif", o.TimeInForce.String()),
+		attribute.Bool("dagger.market_order", o.MarketOrder),
+		attribute.String("dagger.purpose", o.Purpose))
+	o.trace = &orderTrace{span: span, startAt: o.Borntime}
+}
+
+// 下单请求发出前调用
+func (o *OrderImpl) tracePlace() {
+	if t := o.trace; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !t.ended {
+			t.span.AddEvent("place")
+		}
+	}
+}
+
+// 得到交易所订单Id时调用，只记录第一次
+func (o *OrderImpl) traceAck() {
+	if t := o.trace; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.acked || t.ended {
+			return
+		}
+
+		t.acked = true
+		latency := time.Since(t.startAt).Milliseconds()
+		t.span.SetAttributes(attribute.Int64("dagger.order_id", o.OrderId), attribute.Int64("dagger.ack_latency_ms", latency))
+		t.span.AddEvent("ack", trace.WithAttributes(attribute.Int64("dagger.order_id", o.OrderId)))
+	}
+}
+
+func (o *OrderImpl) traceDeal(d Deal) {
+	if t := o.trace; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.ended {
+			return
+		}
+
+		if !t.dealt {
+			t.dealt = true
+			t.span.SetAttributes(attribute.Int64("dagger.first_fill_latency_ms", time.Since(t.startAt).Milliseconds()))
+		}
+
+		t.span.AddEvent("fill", trace.WithAttributes(
+			attribute.String("dagger.price", d.Price.String()),
+			attribute.String("dagger.amount", d.Amount.String()),
+			attribute.String("dagger.filled", o.Filled.String())))
+	}
+}
+
+// 订单完结(含致命错误)时调用，之后的traceXXX不再生效
+func (o *OrderImpl) traceFinish() {
+	if t := o.trace; t != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.ended {
+			return
+		}
+
+		t.ended = true
+		t.span.SetAttributes(
+			attribute.String("dagger.status", o.Status),
+			attribute.String("dagger.filled", o.Filled.String()),
+			attribute.String("dagger.avg_price", o.AvgPrice.String()))
+		if o.FatalError {
+			t.span.SetStatus(codes.Error, o.ErrMsg)
+		}
+		t.span.End()
+	}
+}
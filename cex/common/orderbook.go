@@ -29,6 +29,7 @@ type Orderbook struct {
 	buy1Sz  decimal.Decimal
 	sell1Px decimal.Decimal
 	sell1Sz decimal.Decimal
+	metrics *bookMetrics // 微观结构指标，开启后才会计算
 }
 
 func NewOrderBook() *Orderbook {
@@ -137,6 +138,53 @@ func (ob *Orderbook) GetSellPriceByAmount(amount decimal.Decimal) decimal.Decima
 	}
 }
 
+// 吃单成交估算
+type FillEstimate struct {
+	AvgPrice   decimal.Decimal // 预计成交均价
+	WorstPrice decimal.Decimal // 最后一档的价格
+	Filled     decimal.Decimal // 盘口深度内可成交的数量，深度不足时小于请求数量
+	Levels     int             // 消耗的档位数
+	Slippage   decimal.Decimal // 均价相对于对手方一档的偏离比例
+}
+
+// 沿对手方盘口逐档吃单，估算成交情况。用于在挂单和吃单之间做选择
+func (ob *Orderbook) EstimateFill(dir OrderDir, size decimal.Decimal) FillEstimate {
+	ob.Lock()
+	defer ob.Unlock()
+
+	est := FillEstimate{}
+	var it treemap.Iterator
+	if dir == OrderDir_Buy {
+		it = ob.Asks.Iterator()
+	} else if dir == OrderDir_Sell {
+		it = ob.Bids.Iterator()
+	} else {
+		return est
+	}
+
+	best := decimal.Zero
+	amountPriceAcc := decimal.Zero
+	for est.Filled.LessThan(size) && it.Next() {
+		p := it.Key().(decimal.Decimal)
+		a := it.Value().(decimal.Decimal)
+		if est.Levels == 0 {
+			best = p
+		}
+
+		da := decimal.Min(a, size.Sub(est.Filled))
+		est.Filled = est.Filled.Add(da)
+		amountPriceAcc = amountPriceAcc.Add(da.Mul(p))
+		est.WorstPrice = p
+		est.Levels++
+	}
+
+	if est.Filled.IsPositive() {
+		est.AvgPrice = amountPriceAcc.Div(est.Filled)
+		est.Slippage = est.AvgPrice.Sub(best).Abs().Div(best)
+	}
+	return est
+}
+
 // 根据最大滑点，计算最大吃单买入数量
 func (ob *Orderbook) MaxBuyAmountBySlipPoint(maxSp decimal.Decimal) decimal.Decimal {
 	ob.Lock()
@@ -226,6 +274,7 @@ func (ob *Orderbook) Clear() {
 	defer ob.Unlock()
 	ob.Asks.Clear()
 	ob.Bids.Clear()
+	ob.markMetricsDirty()
 }
 
 // 更新数据
@@ -247,6 +296,7 @@ func (ob *Orderbook) UpdateAsk(price, amount decimal.Decimal) {
 		ob.sell1Px = k.(decimal.Decimal)
 		ob.sell1Sz = v.(decimal.Decimal)
 	}
+	ob.markMetricsDirty()
 }
 
 func (ob *Orderbook) UpdateBids(price, amount decimal.Decimal) {
@@ -267,6 +317,7 @@ func (ob *Orderbook) UpdateBids(price, amount decimal.Decimal) {
 		ob.buy1Px = k.(decimal.Decimal)
 		ob.buy1Sz = v.(decimal.Decimal)
 	}
+	ob.markMetricsDirty()
 }
 
 // asks：px,sz,px,sz
@@ -305,6 +356,24 @@ func (ob *Orderbook) Rebuild(asks, bids []decimal.Decimal) {
 		ob.buy1Px = k.(decimal.Decimal)
 		ob.buy1Sz = v.(decimal.Decimal)
 	}
+	ob.markMetricsDirty()
+	ob.countUpdate()
+}
+
+// 前n档的价格/数量，n<=0表示全部
+func (ob *Orderbook) Levels(n int) (asks, bids [][2]decimal.Decimal) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	collect := func(m *treemap.Map) [][2]decimal.Decimal {
+		levels := make([][2]decimal.Decimal, 0, util.ValueIf(n > 0 && n < m.Size(), n, m.Size()))
+		it := m.Iterator()
+		for it.Next() && (n <= 0 || len(levels) < n) {
+			levels = append(levels, [2]decimal.Decimal{it.Key().(decimal.Decimal), it.Value().(decimal.Decimal)})
+		}
+		return levels
+	}
+	return collect(ob.Asks), collect(ob.Bids)
 }
 
 // 转换为字符串
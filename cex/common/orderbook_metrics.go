@@ -0,0 +1,191 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-05 09:36:52
+- @Description: 订单簿微观结构指标：微观价格、前N档不平衡度、中间价X bps以内的深度、更新频率
+- 更新时只标记，读取时才重新计算(每次更新后最多算一次)，只遍历前N档和bps范围内的档位
+- 更新频率按推送(Updated)计数，一条推送含多个档位时只算一次
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+type BookMetricsConfig struct {
+	TopN  int       // 计算不平衡度的档数，默认5
+	Bps   []float64 // 统计深度的价格范围(相对中间价，单位bps)，默认10、50
+	Clock Clock     // 统计更新频率用的时钟，默认为本地时间
+}
+
+type BookMetrics struct {
+	MicroPrice decimal.Decimal   // 按买一卖一数量加权的价格，偏向数量少的一侧
+	Imbalance  float64           // 前N档(买量-卖量)/(买量+卖量)，范围[-1,1]
+	BidDepth   []decimal.Decimal // 与Bps一一对应，中间价以下X bps以内的买盘数量
+	AskDepth   []decimal.Decimal // 与Bps一一对应，中间价以上X bps以内的卖盘数量
+	UpdateRate float64           // 最近一秒的订单簿更新(推送)次数
+}
+
+type bookMetrics struct {
+	cfg     BookMetricsConfig
+	metrics BookMetrics
+	dirty   bool // 订单簿有变化，读取时需要重新计算
+
+	// 按秒统计更新次数
+	bucketStart time.Time
+	bucketCount int
+}
+
+// 开启指标计算
+func (ob *Orderbook) EnableMetrics(cfg BookMetricsConfig) {
+	if cfg.TopN <= 0 {
+		cfg.TopN = 5
+	}
+	if len(cfg.Bps) == 0 {
+		cfg.Bps = []float64{10, 50}
+	}
+
+	ob.Lock()
+	defer ob.Unlock()
+	cfg.Clock = ClockOrReal(cfg.Clock)
+	ob.metrics = &bookMetrics{cfg: cfg, dirty: true}
+}
+
+// 最新的指标，未开启时返回零值
+func (ob *Orderbook) Metrics() BookMetrics {
+	ob.Lock()
+	defer ob.Unlock()
+	if ob.metrics == nil {
+		return BookMetrics{}
+	}
+
+	if ob.metrics.dirty {
+		ob.refreshMetrics()
+		ob.metrics.dirty = false
+	}
+
+	m := ob.metrics.metrics
+	if ob.metrics.cfg.Clock.Now().Sub(ob.metrics.bucketStart) >= time.Second*2 {
+		m.UpdateRate = 0
+	}
+	m.BidDepth = append([]decimal.Decimal{}, m.BidDepth...)
+	m.AskDepth = append([]decimal.Decimal{}, m.AskDepth...)
+	return m
+}
+
+// 微观价格，不需要开启指标计算
+func (ob *Orderbook) MicroPrice() decimal.Decimal {
+	ob.Lock()
+	defer ob.Unlock()
+	return ob.microPrice()
+}
+
+func (ob *Orderbook) microPrice() decimal.Decimal {
+	total := ob.buy1Sz.Add(ob.sell1Sz)
+	if !total.IsPositive() {
+		return ob.buy1Px.Add(ob.sell1Px).Div(decimal.NewFromInt(2))
+	}
+	return ob.buy1Px.Mul(ob.sell1Sz).Add(ob.sell1Px.Mul(ob.buy1Sz)).Div(total)
+}
+
+// 一条推送(可能含多个档位)处理完后调用，用于统计更新频率。Rebuild会自动计数
+func (ob *Orderbook) Updated() {
+	ob.Lock()
+	defer ob.Unlock()
+	ob.countUpdate()
+}
+
+// 在持有锁的情况下调用
+func (ob *Orderbook) countUpdate() {
+	bm := ob.metrics
+	if bm == nil {
+		return
+	}
+
+	now := bm.cfg.Clock.Now()
+	if now.Sub(bm.bucketStart) >= time.Second {
+		// 超过一个统计周期没有更新时，频率按0计算
+		bm.metrics.UpdateRate = float64(util.ValueIf(now.Sub(bm.bucketStart) < time.Second*2, bm.bucketCount, 0))
+		bm.bucketStart = now.Truncate(time.Second)
+		bm.bucketCount = 0
+	}
+	bm.bucketCount++
+}
+
+// 在持有锁的情况下调用
+func (ob *Orderbook) markMetricsDirty() {
+	if ob.metrics != nil {
+		ob.metrics.dirty = true
+	}
+}
+
+// 在持有锁的情况下调用
+func (ob *Orderbook) refreshMetrics() {
+	bm := ob.metrics
+	m := &bm.metrics
+	m.MicroPrice = ob.microPrice()
+	m.BidDepth = make([]decimal.Decimal, len(bm.cfg.Bps))
+	m.AskDepth = make([]decimal.Decimal, len(bm.cfg.Bps))
+	m.Imbalance = 0
+	if !ob.buy1Px.IsPositive() || !ob.sell1Px.IsPositive() {
+		return
+	}
+
+	mid := ob.buy1Px.Add(ob.sell1Px).Div(decimal.NewFromInt(2))
+	askBounds := make([]decimal.Decimal, len(bm.cfg.Bps))
+	bidBounds := make([]decimal.Decimal, len(bm.cfg.Bps))
+	maxBps := 0.0
+	for i, bps := range bm.cfg.Bps {
+		r := decimal.NewFromFloat(bps / 10000)
+		askBounds[i] = mid.Mul(decimal.NewFromInt(1).Add(r))
+		bidBounds[i] = mid.Mul(decimal.NewFromInt(1).Sub(r))
+		maxBps = max(maxBps, bps)
+	}
+	askMax := mid.Mul(decimal.NewFromFloat(1 + maxBps/10000))
+	bidMin := mid.Mul(decimal.NewFromFloat(1 - maxBps/10000))
+
+	askTop := decimal.Zero
+	it := ob.Asks.Iterator()
+	for n := 0; it.Next(); n++ {
+		px := it.Key().(decimal.Decimal)
+		sz := it.Value().(decimal.Decimal)
+		if n >= bm.cfg.TopN && px.GreaterThan(askMax) {
+			break
+		}
+
+		if n < bm.cfg.TopN {
+			askTop = askTop.Add(sz)
+		}
+		for i, bound := range askBounds {
+			if px.LessThanOrEqual(bound) {
+				m.AskDepth[i] = m.AskDepth[i].Add(sz)
+			}
+		}
+	}
+
+	bidTop := decimal.Zero
+	it = ob.Bids.Iterator()
+	for n := 0; it.Next(); n++ {
+		px := it.Key().(decimal.Decimal)
+		sz := it.Value().(decimal.Decimal)
+		if n >= bm.cfg.TopN && px.LessThan(bidMin) {
+			break
+		}
+
+		if n < bm.cfg.TopN {
+			bidTop = bidTop.Add(sz)
+		}
+		for i, bound := range bidBounds {
+			if px.GreaterThanOrEqual(bound) {
+				m.BidDepth[i] = m.BidDepth[i].Add(sz)
+			}
+		}
+	}
+
+	if total := askTop.Add(bidTop); total.IsPositive() {
+		m.Imbalance = bidTop.Sub(askTop).Div(total).InexactFloat64()
+	}
+}
@@ -0,0 +1,59 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-18 15:40:12
+- @Description: 下单前检查。所有交易所(含回测)的订单在OrderImpl.Init/InitMarket校验通过后依次调用已注册的检查器，
+- 任一检查器返回错误则拒绝下单：MakeOrder返回nil，订单的ErrMsg为错误信息，并向事件总线发布EventType_OrderRejected(Err为检查器返回的错误)，开启审计时记录reject(见audit.go)
+- 检查器在下单的协程中同步调用，不能阻塞。内置的合理性检查见sanity.go，持仓/价值风控见cex/risk
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type PreTradeChecker interface {
+	CheckOrder(o *OrderImpl) error
+}
+
+var muPreTrade sync.RWMutex
+var preTradeCheckers []PreTradeChecker
+
+func AddPreTradeChecker(c PreTradeChecker) {
+	muPreTrade.Lock()
+	defer muPreTrade.Unlock()
+	preTradeCheckers = append(slices.Clone(preTradeCheckers), c)
+}
+
+func RemovePreTradeChecker(c PreTradeChecker) {
+	muPreTrade.Lock()
+	defer muPreTrade.Unlock()
+	if i := slices.Index(preTradeCheckers, c); i >= 0 {
+		preTradeCheckers = append(slices.Clone(preTradeCheckers[:i]), preTradeCheckers[i+1:]...)
+	}
+}
+
+// 依次调用检查器，返回第一个错误。隔离的订单只使用自己的检查器(见OrderImpl.Isolate)
+func checkPreTrade(o *OrderImpl) error {
+	checkers := o.checkers
+	if !o.isolated {
+		muPreTrade.RLock()
+		checkers = preTradeCheckers
+		muPreTrade.RUnlock()
+	}
+
+	for _, c := range checkers {
+		if err := c.CheckOrder(o); err != nil {
+			o.ErrMsg = err.Error()
+			o.LogFields.LogImportant(o.LogPrefix, "order rejected by pre-trade check: %s", err.Error())
+			if !o.isolated {
+				o.recordPreTradeReject(err)
+				PublishOrderRejected(o.InstId, err, time.Now())
+			}
+			return err
+		}
+	}
+	return nil
+}
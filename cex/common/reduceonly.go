@@ -0,0 +1,43 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-28 10:21:35
+- @Description: 只减仓订单的本地校验
+- 交易所的reduceOnly只在撮合时生效，挂单期间仓位变化仍可能导致反手。下单前按当前仓位截断数量，超出部分直接拒绝
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// 只减仓订单最多可下的数量
+// 单向持仓按净仓位计算，买单只能减空仓，卖单只能减多仓
+// pending为同方向已挂出、尚未成交的只减仓数量，它们也会占用可减仓位
+func ReduceOnlyAvailable(pos Position, dir OrderDir, pending decimal.Decimal) decimal.Decimal {
+	if pos == nil {
+		return decimal.Zero
+	}
+
+	net := pos.Net()
+	avail := decimal.Zero
+	if dir == OrderDir_Buy && net.IsNegative() {
+		avail = net.Neg()
+	} else if dir == OrderDir_Sell && net.IsPositive() {
+		avail = net
+	}
+
+	return decimal.Max(decimal.Zero, avail.Sub(pending))
+}
+
+// 校验并截断只减仓订单的数量
+func CheckReduceOnly(pos Position, dir OrderDir, amount, pending decimal.Decimal) (decimal.Decimal, error) {
+	avail := ReduceOnlyAvailable(pos, dir, pending)
+	if !avail.IsPositive() {
+		return decimal.Zero, fmt.Errorf("reduce only %s rejected, no position to reduce", OrderDir2Str(dir))
+	}
+
+	return decimal.Min(amount, avail), nil
+}
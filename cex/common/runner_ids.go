@@ -0,0 +1,32 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-15 11:02:37
+- @Description: 本进程中由stratergy.Runner管理的策略Id
+- 策略Id同时用作订单的策略标识(okx的tag、币安的strategyId)时，交易器收到这些Id的订单只忽略，不锁定交易器
+- 其他Id的订单仍视为外部策略的订单，交易器进入错误锁定状态
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import "sync"
+
+var muRunnerIds sync.RWMutex
+var runnerIds = make(map[string]bool)
+
+func RegisterRunnerId(id string) {
+	muRunnerIds.Lock()
+	defer muRunnerIds.Unlock()
+	runnerIds[id] = true
+}
+
+func UnregisterRunnerId(id string) {
+	muRunnerIds.Lock()
+	defer muRunnerIds.Unlock()
+	delete(runnerIds, id)
+}
+
+func IsRunnerId(id string) bool {
+	muRunnerIds.RLock()
+	defer muRunnerIds.RUnlock()
+	return runnerIds[id]
+}
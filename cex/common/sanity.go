@@ -0,0 +1,150 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-19 09:36:18
+- @Description: 下单前的合理性检查(防乌龙指)，作为内置的下单前检查器对所有交易所生效：
+- 1. 价格偏离：订单价格偏离参考价格超过MaxPriceDeviation时拒绝。参考价格为合约的标记价格/最新价格，都没有时为盘口中间价
+- 2. 数量异常：订单数量超过该品种最近AvgWindow笔订单平均数量的MaxSizeMultiple倍时拒绝，样本不足MinSamples笔时不检查
+- 只影响方向对自己不利的价格：买单只检查高于参考价格，卖单只检查低于参考价格
+- 拒绝时返回*SanityError
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type SanityConfig struct {
+	MaxPriceDeviation decimal.Decimal `json:"max_price_deviation"` // 价格偏离比例，如0.05，0表示不检查
+	MaxSizeMultiple   decimal.Decimal `json:"max_size_multiple"`   // 相对平均数量的倍数，如10，0表示不检查
+	AvgWindow         int             `json:"avg_window"`          // 平均数量的样本订单数，默认50
+	MinSamples        int             `json:"min_samples"`         // 检查数量所需的最少样本，默认10
+}
+
+type SanityKind int
+
+const (
+	SanityKind_PriceDeviation SanityKind = iota
+	SanityKind_SizeMultiple
+)
+
+func (k SanityKind) String() string {
+	switch k {
+	case SanityKind_PriceDeviation:
+		return "price_deviation"
+	case SanityKind_SizeMultiple:
+		return "size_multiple"
+	default:
+		return "unknown"
+	}
+}
+
+type SanityError struct {
+	Kind   SanityKind
+	InstId string
+	Value  decimal.Decimal // 偏离比例/数量倍数
+	Limit  decimal.Decimal
+	Ref    decimal.Decimal // 参考价格/平均数量
+}
+
+func (e *SanityError) Error() string {
+	return fmt.Sprintf("%s exceeded, inst=%s, value=%v, limit=%v, ref=%v", e.Kind.String(), e.InstId, e.Value.Round(4), e.Limit, e.Ref)
+}
+
+type sanityChecker struct {
+	cfg   SanityConfig
+	mu    sync.Mutex
+	sizes map[string][]decimal.Decimal // instId-最近的订单数量
+}
+
+var muSanity sync.Mutex
+var sanity *sanityChecker
+
+// 开启合理性检查，重复调用时替换配置并清空样本
+func EnableSanityCheck(cfg SanityConfig) {
+	cfg.AvgWindow = util.ValueIf(cfg.AvgWindow > 0, cfg.AvgWindow, 50)
+	cfg.MinSamples = util.ValueIf(cfg.MinSamples > 0, cfg.MinSamples, 10)
+	c := &sanityChecker{cfg: cfg, sizes: make(map[string][]decimal.Decimal)}
+
+	muSanity.Lock()
+	defer muSanity.Unlock()
+	if sanity != nil {
+		RemovePreTradeChecker(sanity)
+	}
+	sanity = c
+	AddPreTradeChecker(c)
+	logger.LogImportant("sanity", "enabled, max_price_deviation=%v, max_size_multiple=%v", cfg.MaxPriceDeviation, cfg.MaxSizeMultiple)
+}
+
+func DisableSanityCheck() {
+	muSanity.Lock()
+	defer muSanity.Unlock()
+	if sanity != nil {
+		RemovePreTradeChecker(sanity)
+		sanity = nil
+	}
+}
+
+// 参考价格：标记价格>最新价格>盘口中间价
+func referencePrice(t CommonTrader) decimal.Decimal {
+	if ft, ok := t.(FutureTrader); ok {
+		if px := ft.FutureMarket().MarkPrice(); px.IsPositive() {
+			return px
+		}
+	}
+
+	m := t.Market()
+	if px := m.LatestPrice(); px.IsPositive() {
+		return px
+	}
+
+	if ob := m.OrderBook(); ob != nil && !ob.Empty() {
+		return ob.MiddlePrice()
+	}
+	return decimal.Zero
+}
+
+// 实现PreTradeChecker
+func (c *sanityChecker) CheckOrder(o *OrderImpl) error {
+	if c.cfg.MaxPriceDeviation.IsPositive() && o.Trader != nil {
+		if ref := referencePrice(o.Trader); ref.IsPositive() {
+			dev := o.Price.Sub(ref).Div(ref)
+			if o.Dir == OrderDir_Sell {
+				dev = dev.Neg()
+			}
+
+			if dev.GreaterThan(c.cfg.MaxPriceDeviation) {
+				return &SanityError{Kind: SanityKind_PriceDeviation, InstId: o.InstId, Value: dev, Limit: c.cfg.MaxPriceDeviation, Ref: ref}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sizes := c.sizes[o.InstId]
+	if c.cfg.MaxSizeMultiple.IsPositive() && len(sizes) >= c.cfg.MinSamples {
+		avg := decimal.Zero
+		for _, sz := range sizes {
+			avg = avg.Add(sz)
+		}
+		avg = avg.Div(decimal.NewFromInt(int64(len(sizes))))
+
+		if avg.IsPositive() {
+			if multiple := o.Size.Div(avg); multiple.GreaterThan(c.cfg.MaxSizeMultiple) {
+				return &SanityError{Kind: SanityKind_SizeMultiple, InstId: o.InstId, Value: multiple, Limit: c.cfg.MaxSizeMultiple, Ref: avg}
+			}
+		}
+	}
+
+	sizes = append(sizes, o.Size)
+	if len(sizes) > c.cfg.AvgWindow {
+		sizes = sizes[len(sizes)-c.cfg.AvgWindow:]
+	}
+	c.sizes[o.InstId] = sizes
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-12 16:40:05
+- @Description: 逐笔成交的订阅管理。第一个订阅者加入时订阅交易所频道，最后一个退出时退订
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package common
+
+import (
+	"slices"
+	"sync"
+)
+
+type tradeSub struct {
+	id int
+	fn func(t PublicTrade)
+}
+
+type TradeHub struct {
+	mu     sync.Mutex
+	subs   []tradeSub
+	nextId int
+}
+
+// onFirst在第一个订阅者加入时调用(持有锁)，可以为nil。返回的Id用于取消订阅
+func (h *TradeHub) Add(fn func(t PublicTrade), onFirst func()) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextId++
+	h.subs = append(h.subs, tradeSub{id: h.nextId, fn: fn})
+	if len(h.subs) == 1 && onFirst != nil {
+		onFirst()
+	}
+	return h.nextId
+}
+
+// onLast在最后一个订阅者退出时调用(持有锁)，可以为nil
+func (h *TradeHub) Remove(id int, onLast func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := len(h.subs)
+	h.subs = slices.DeleteFunc(slices.Clone(h.subs), func(s tradeSub) bool { return s.id == id })
+	if n > 0 && len(h.subs) == 0 && onLast != nil {
+		onLast()
+	}
+}
+
+func (h *TradeHub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *TradeHub) Publish(t PublicTrade) {
+	h.mu.Lock()
+	subs := h.subs
+	h.mu.Unlock()
+
+	for _, s := range subs {
+		s.fn(t)
+	}
+}
@@ -82,6 +82,7 @@ func (e *Exchange) Init(excfg ExchangeConfig, logInfo, logDebug, logError fnLog)
 	e.freezedBalanceDetail = make(map[int]map[string]decimal.Decimal)
 	e.orderStatusHandler = make(map[int]func(*twsapi.OrderStatusMsg, *twsapi.OpenOrdersMsg))
 	e.balanceMgr = common.NewBalanceMgr(true)
+	e.balanceMgr.SetExchangeName(exchangeName)
 
 	// 资产最大容许偏移量设置
 	if excfg.MaxPitch != nil {
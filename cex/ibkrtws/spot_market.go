@@ -38,6 +38,7 @@ type SpotMarket struct {
 	quoteCcy       string
 
 	marketDataReqId      int
+	tickByTickReqId      int
 	msgHandlerRegisterId int
 	onConnectRegisterId  int
 
@@ -49,6 +50,10 @@ type SpotMarket struct {
 	depthObserversSet *hashset.Set
 	depthObservers    []interface{}
 
+	// 逐笔成交回调
+	trades common.TradeHub
+	barHub common.BarHub
+
 	// 交易时间配置，从exchange获取
 	tradingTimes       common.TradingTimes
 	instrumentsVersion int
@@ -169,6 +174,10 @@ func (m *SpotMarket) subscribeMarketData() {
 						m.marketDataReqId = id
 						m.needResub = false
 						logInfo(logPrefix, "subscribe market data success")
+
+						if m.trades.Len() > 0 {
+							m.reqTickByTick()
+						}
 					} else {
 						logError(logPrefix, "subscribe market data error, code=%d, msg=%s", resp.Err.ErrorCode, resp.Err.ErrorMessage)
 						time.Sleep(time.Minute)
@@ -225,6 +234,11 @@ func (m *SpotMarket) onTwsMessage(msg twsapi.Message) {
 				m.rebuildOrderBook()
 			}
 		}
+	} else if msg.MsgId == twsapi.InCommingMessage_TickByTick {
+		tbtmsg := msg.Msg.(*twsapi.TickByTickMsg)
+		if tbtmsg.RequestId == m.tickByTickReqId {
+			m.onTickByTick(tbtmsg)
+		}
 	} else if msg.MsgId == twsapi.InCommingMessage_TickSize {
 		tsmsg := msg.Msg.(*twsapi.TickSizeMsg)
 		if tsmsg.RequestId == m.marketDataReqId {
@@ -248,6 +262,37 @@ func (m *SpotMarket) onTwsMessage(msg twsapi.Message) {
 	}
 }
 
+// 逐笔成交。tws不提供主动成交方向，按当时的盘口推断
+func (m *SpotMarket) reqTickByTick() {
+	if m.tickByTickReqId > 0 {
+		m.c.CancelTickByTick(m.tickByTickReqId)
+	}
+	m.tickByTickReqId = m.c.ReqTickByTick(*m.contract, "AllLast", 0, false)
+}
+
+func (m *SpotMarket) onTickByTick(msg *twsapi.TickByTickMsg) {
+	if msg.Type != twsmodel.TickByTickType_Last && msg.Type != twsmodel.TickByTickType_AllLast {
+		return
+	}
+
+	dir := common.OrderDir_None
+	if m.askPrice.IsPositive() && msg.Last.Price.GreaterThanOrEqual(m.askPrice) {
+		dir = common.OrderDir_Buy
+	} else if m.bidPrice.IsPositive() && msg.Last.Price.LessThanOrEqual(m.bidPrice) {
+		dir = common.OrderDir_Sell
+	}
+
+	t := common.PublicTrade{
+		LocalTime: time.Now(),
+		UTime:     msg.Time,
+		Price:     msg.Last.Price,
+		Size:      msg.Last.Size,
+		Dir:       dir,
+	}
+
+	m.trades.Publish(t)
+}
+
 func (m *SpotMarket) rebuildOrderBook() {
 	m.orderBook.Rebuild([]decimal.Decimal{m.askPrice, m.askSize}, []decimal.Decimal{m.bidPrice, m.bidSize})
 	if m.askPrice.IsPositive() && m.askSize.IsPositive() && m.bidPrice.IsPositive() && m.bidSize.IsPositive() {
@@ -301,7 +346,28 @@ func (m *SpotMarket) UnreadyReason() string {
 	}
 }
 
+func (m *SpotMarket) SubscribeTrades(fn func(t common.PublicTrade)) int {
+	return m.trades.Add(fn, m.reqTickByTick)
+}
+
+func (m *SpotMarket) UnsubscribeTrades(id int) {
+	m.trades.Remove(id, func() {
+		if m.tickByTickReqId > 0 {
+			m.c.CancelTickByTick(m.tickByTickReqId)
+			m.tickByTickReqId = 0
+		}
+	})
+}
+
+func (m *SpotMarket) Bars(cfg common.BarConfig) *common.BarBuilder {
+	return m.barHub.Use(cfg, m.SubscribeTrades)
+}
+
 func (m *SpotMarket) Uninit() {
+	if m.tickByTickReqId > 0 {
+		m.c.CancelTickByTick(m.tickByTickReqId)
+	}
+
 	if m.msgHandlerRegisterId > 0 {
 		m.c.UnregisterMessageHandler(m.msgHandlerRegisterId)
 	}
@@ -315,10 +381,20 @@ func (m *SpotMarket) LatestPrice() decimal.Decimal {
 	return m.latestPrice
 }
 
+// tws没有24小时滚动统计，只提供最新价
+func (m *SpotMarket) Ticker24h() common.Ticker24h {
+	return common.Ticker24h{Last: m.latestPrice}
+}
+
 func (m *SpotMarket) OrderBook() *common.Orderbook {
 	return m.orderBook
 }
 
+// tws的深度为逐档推送，不提供序列号，只能判断是否及时
+func (m *SpotMarket) DepthConsistent() bool {
+	return m.depthOk()
+}
+
 func (m *SpotMarket) AlignPriceNumber(price decimal.Decimal) decimal.Decimal {
 	return m.ex.instrumentMgr.AlignPriceNumber(m.inst.Id, price)
 }
@@ -343,6 +419,10 @@ func (m *SpotMarket) MinSize() decimal.Decimal {
 	return m.ex.instrumentMgr.MinSize(m.inst.Id, m.orderBook.Buy1Price())
 }
 
+func (m *SpotMarket) TickSize() decimal.Decimal {
+	return m.ex.instrumentMgr.TickSize(m.inst.Id)
+}
+
 func (m *SpotMarket) BaseCurrency() string {
 	return m.baseCcy
 }
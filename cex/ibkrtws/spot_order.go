@@ -53,6 +53,7 @@ func (o *SpotOrder) init(
 	o.quoteCcy = trader.market.quoteCcy
 
 	o.CltOrderId = o.c.NextOrderId()
+	o.LogFields.Exchange = exchangeName
 	return o.OrderImpl.Init(trader, trader.ex.instrumentMgr, trader.market.inst.Id, price, amount, dir, false, false, purpose)
 }
 
@@ -105,12 +106,13 @@ func (o *SpotOrder) create() {
 
 	o.twsOrder.OrderId = o.CltOrderId.(int)
 	o.twsOrder.Action = util.ValueIf(o.Dir == common.OrderDir_Buy, "BUY", "SELL")
-	o.twsOrder.LmtPrice = o.Price
+	o.twsOrder.LmtPrice = o.InstrumentMgr.RoundPrice(o.InstId, o.Price)
 	o.twsOrder.OrderType = "LMT"
 	o.twsOrder.Tif = o.orderTif
-	o.twsOrder.TotalQuantity = o.Size
+	o.twsOrder.TotalQuantity = o.InstrumentMgr.RoundSize(o.InstId, o.Size)
 
 	// 返回不会为nil
+	o.RecordPlace()
 	resp := *o.c.PlaceOrder(*o.contract, o.twsOrder)
 	if resp.RespCode == twsapi.RespCode_Ok {
 		if resp.OrderStatus != nil {
@@ -145,6 +147,7 @@ func (o *SpotOrder) create() {
 func (o *SpotOrder) uninit() {
 	o.ex.unregisterOrderStatusHandler(o.CltOrderId.(int))
 	o.ex.clearFrozenBalance(o.twsOrder.OrderId)
+	o.RecordFinish()
 }
 
 // 取消订单
@@ -156,6 +159,7 @@ func (o *SpotOrder) cancel() {
 		}()
 
 		logInfo(o.LogPrefix, "canceling [%s]", o.String())
+		o.RecordCancel()
 		resp := o.c.CancelOrder(o.CltOrderId.(int), "")
 		if resp.RespCode == twsapi.RespCode_Ok {
 			if resp.OrderStatus != nil {
@@ -206,7 +210,7 @@ func (o *SpotOrder) modify(newPrice, newSize decimal.Decimal) {
 			o.Trader.Market().OrderBook().Buy1Price(),
 			o.Trader.Market().OrderBook().Sell1Price(),
 		)
-		o.twsOrder.LmtPrice = newPrice
+		o.twsOrder.LmtPrice = o.InstrumentMgr.RoundPrice(o.InstId, newPrice)
 		logInfo(o.LogPrefix, "modifying [%s], new price=%v", o.String(), newPrice)
 	}
 
@@ -218,9 +222,11 @@ func (o *SpotOrder) modify(newPrice, newSize decimal.Decimal) {
 			return
 		}
 		logInfo(o.LogPrefix, "modifying [%s], new size=%v", o.String(), newSize)
-		o.twsOrder.TotalQuantity = newSize
+		o.twsOrder.TotalQuantity = o.InstrumentMgr.RoundSize(o.InstId, newSize)
 	}
 
+	o.RecordAmend(newPrice, newSize)
+
 	// 返回不会为nil
 	resp := *o.c.PlaceOrder(*o.contract, o.twsOrder)
 	if resp.RespCode == twsapi.RespCode_Ok {
@@ -261,6 +267,8 @@ func (o *SpotOrder) onOrderStatus(os *twsapi.OrderStatusMsg, oo *twsapi.OpenOrde
 		if o.CltOrderId != os.OrderId {
 			logError(o.LogPrefix, "order client id not match, o=%s, new id=%d", o.String(), os.ClientId)
 		}
+		o.RecordAck()
+		o.RecordWsUpdate(time.Time{}) // tws的订单状态都是推送的
 
 		// 刷新数据
 		logInfo(o.LogPrefix, "recv order snapshot:%s", os.String())
@@ -283,12 +291,14 @@ func (o *SpotOrder) onOrderStatus(os *twsapi.OrderStatusMsg, oo *twsapi.OpenOrde
 			}
 
 			// 回调外部
-			if deal.Price.IsPositive() && deal.Amount.IsPositive() && len(o.Observers) > 0 {
+			if deal.Price.IsPositive() && deal.Amount.IsPositive() {
+				o.RecordDeal(deal)
 				for _, obs := range o.Observers {
 					if obs != nil {
 						obs.OnDeal(deal)
 					}
 				}
+				common.PublishDeal(exchangeName, deal)
 			}
 
 			// 注意一定要等外部回调结束后，再置订单完成状态
@@ -296,6 +306,8 @@ func (o *SpotOrder) onOrderStatus(os *twsapi.OrderStatusMsg, oo *twsapi.OpenOrde
 			if !o.Finished && finished {
 				o.Finished = finished
 				logInfo(o.LogPrefix, "order finished")
+				o.RecordFinish()
+				common.PublishOrderUpdate(exchangeName, o, o.UpdateTime)
 			} else if o.Finished && !finished {
 				logError(o.LogPrefix, "order already finished but try set to unfinished? impossible!")
 			}
@@ -155,9 +155,52 @@ func (t *SpotTrader) MakeOrder(
 	makeOnly, reduceOnly bool,
 	purpose string,
 	obs common.OrderObserver) common.Order {
+	return t.makeOrder(price, amount, dir, t.tif, purpose, obs)
+}
+
+// GTC使用交易器配置的tif，不支持只挂单
+func (t *SpotTrader) MakeOrderTIF(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif common.TimeInForce,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	switch tif {
+	case common.TimeInForce_GTC:
+		return t.makeOrder(price, amount, dir, t.tif, purpose, obs)
+	case common.TimeInForce_IOC, common.TimeInForce_FOK:
+		return t.makeOrder(price, amount, dir, tif.String(), purpose, obs)
+	default:
+		logInfo(t.logPrefix, "time in force %s not supported", tif.String())
+		return nil
+	}
+}
+
+// 暂不支持市价单
+func (t *SpotTrader) MakeMarketOrder(
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	byQuote bool,
+	maxSlippage decimal.Decimal,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	logInfo(t.logPrefix, "market order not supported")
+	return nil
+}
+
+func (t *SpotTrader) makeOrder(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif string,
+	purpose string,
+	obs common.OrderObserver) common.Order {
 	if t.Ready() {
 		o := new(SpotOrder)
-		if o.init(t, price, amount, dir, t.tif, purpose) {
+		if o.init(t, price, amount, dir, tif, purpose) {
 			t.muOrders.Lock()
 			t.orders[o.CltOrderId] = o
 			t.muOrders.Unlock()
@@ -0,0 +1,105 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-02 11:40:13
+- @Description: 常用告警
+- 1. WatchOrderRejected：订单被下单前检查拒绝(限额、熔断等)，按品种去重。warning
+- 2. WatchTraderReady：交易器连续未就绪超过N秒时告警(warning)，恢复时再通知一次(info)
+- 3. DrawdownAlertFn：回撤熔断触发，作为risk.CircuitBreaker.SetAlertFn的参数。critical
+- 4. WatchLargeFill：单笔成交金额(price*amount)达到阈值，按订单去重。合约amount为张数时需按面值折算阈值。info
+- 事件类的告警订阅common.EventBus，bus为nil时使用DefaultEventBus，返回订阅Id用于取消
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/cex/risk"
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+func busOrDefault(bus *common.EventBus) *common.EventBus {
+	return util.ValueIf(bus != nil, bus, common.DefaultEventBus)
+}
+
+func (n *Notifier) WatchOrderRejected(bus *common.EventBus) int {
+	return busOrDefault(bus).SubscribeAsync(func(e common.Event) {
+		reason := ""
+		if e.Err != nil {
+			reason = e.Err.Error()
+		}
+		n.Notify(Severity_Warning, "rejected-"+e.InstId, fmt.Sprintf("[%s] order rejected", e.InstId), reason)
+	}, 64, common.EventType_OrderRejected)
+}
+
+func (n *Notifier) WatchLargeFill(bus *common.EventBus, minValue decimal.Decimal) int {
+	return busOrDefault(bus).SubscribeAsync(func(e common.Event) {
+		value := e.Deal.Price.Mul(e.Deal.Amount)
+		if value.LessThan(minValue) || e.Order == nil {
+			return
+		}
+
+		_, cltId := e.Order.GetID()
+		n.Notifyf(
+			Severity_Info,
+			"fill-"+e.InstId+"-"+cltId,
+			fmt.Sprintf("[%s] large fill", e.InstId),
+			"exchange=%s, dir=%s, price=%v, amount=%v, value=%v, order=%s",
+			e.Exchange, common.OrderDir2Str(e.Order.GetDir()), e.Deal.Price, e.Deal.Amount, value.Round(2), cltId)
+	}, 256, common.EventType_Deal)
+}
+
+func (n *Notifier) DrawdownAlertFn() func(a risk.BreakerAlert) {
+	return func(a risk.BreakerAlert) {
+		n.Notifyf(
+			Severity_Critical,
+			"drawdown-"+a.Window,
+			fmt.Sprintf("%s drawdown breached, trading halted", a.Window),
+			"drawdown=%v, limit=%v, peak=%v, equity=%v",
+			a.Drawdown.Round(4), a.Limit, a.Peak, a.Equity)
+	}
+}
+
+type traderWatch struct {
+	t            common.CommonTrader
+	maxUnready   time.Duration
+	unreadySince time.Time // 零值表示就绪
+	alerted      bool
+}
+
+// 交易器连续未就绪超过unreadySec秒时告警，Go之前、之后调用均可
+func (n *Notifier) WatchTraderReady(t common.CommonTrader, unreadySec int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.traderWatches = append(n.traderWatches, &traderWatch{t: t, maxUnready: time.Duration(unreadySec) * time.Second})
+}
+
+// 由run定时调用
+func (n *Notifier) checkTraders() {
+	n.mu.Lock()
+	watches := append([]*traderWatch{}, n.traderWatches...)
+	n.mu.Unlock()
+
+	now := time.Now()
+	for _, w := range watches {
+		name := w.t.Market().Type()
+		if w.t.Ready() {
+			if w.alerted {
+				n.Notifyf(Severity_Info, "ready-"+name, fmt.Sprintf("[%s] trader recovered", name), "unready for %v", now.Sub(w.unreadySince).Round(time.Second))
+			}
+			w.unreadySince = time.Time{}
+			w.alerted = false
+			continue
+		}
+
+		if w.unreadySince.IsZero() {
+			w.unreadySince = now
+		} else if !w.alerted && now.Sub(w.unreadySince) >= w.maxUnready {
+			w.alerted = true
+			n.Notifyf(Severity_Warning, "unready-"+name, fmt.Sprintf("[%s] trader unready", name), "unready for %v: %s", now.Sub(w.unreadySince).Round(time.Second), w.t.UnreadyReason())
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-02 10:16:25
+- @Description: 消息通知。把告警推送到一个或多个Sink(Telegram/Slack/Discord/通用webhook)，带去重和限流：
+- 1. 去重：相同key的消息在DedupSec内只发送一次，被忽略的次数附在该key下一次发送的消息后面
+- 2. 限流：每分钟最多发送MaxPerMinute条，超出的丢弃，丢弃的条数附在下一条消息后面。Critical不受限流
+- 3. 按级别路由：每个Sink有最低级别，如Slack接收所有消息、Telegram只接收Critical
+- 4. 发送在独立协程中进行，Notify不阻塞，队列满时丢弃
+- 各Sink共用Message.Format的文本格式，通用webhook发送Message的json
+- 常用的告警见hooks.go(下单被拒、交易器长时间未就绪、回撤熔断、大额成交)，按规则告警见rules.go
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/debugstats"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type Severity int
+
+const (
+	Severity_Info Severity = iota
+	Severity_Warning
+	Severity_Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Severity_Info:
+		return "info"
+	case Severity_Warning:
+		return "warning"
+	case Severity_Critical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// 空字符串视为info
+func String2Severity(str string) (Severity, error) {
+	switch strings.ToLower(str) {
+	case "", "info":
+		return Severity_Info, nil
+	case "warning", "warn":
+		return Severity_Warning, nil
+	case "critical":
+		return Severity_Critical, nil
+	default:
+		return Severity_Info, fmt.Errorf("invalid severity: %s", str)
+	}
+}
+
+type Message struct {
+	Severity   Severity  `json:"-"`
+	Key        string    `json:"key"`
+	Title      string    `json:"title"`
+	Text       string    `json:"text"`
+	Time       time.Time `json:"time"`
+	Suppressed int       `json:"suppressed"` // 此前被去重忽略的相同key的消息数
+	Dropped    int       `json:"dropped"`    // 此前被限流丢弃的消息数
+}
+
+// 各Sink共用的文本格式
+func (m Message) Format() string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("[%s] %s", strings.ToUpper(m.Severity.String()), m.Title))
+	if len(m.Text) > 0 {
+		sb.WriteString("\n")
+		sb.WriteString(m.Text)
+	}
+
+	if m.Suppressed > 0 {
+		sb.WriteString(fmt.Sprintf("\n(%d duplicates suppressed)", m.Suppressed))
+	}
+
+	if m.Dropped > 0 {
+		sb.WriteString(fmt.Sprintf("\n(%d messages dropped by throttling)", m.Dropped))
+	}
+	return sb.String()
+}
+
+// 消息发送的目标
+type Sink interface {
+	Name() string
+	Send(m Message) error
+}
+
+type Config struct {
+	DedupSec     int          `json:"dedup_sec"`      // 相同key的消息的最小间隔，默认60
+	MaxPerMinute int          `json:"max_per_minute"` // 每分钟最多发送的条数，默认20
+	Sinks        []SinkConfig `json:"sinks"`
+}
+
+type route struct {
+	sink        Sink
+	minSeverity Severity
+}
+
+type Notifier struct {
+	logPrefix string
+	cfg       Config
+	routes    []route
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time // key->最近发送时间
+	suppressed map[string]int       // key->去重期间被忽略的次数
+	sentTimes  []time.Time          // 最近1分钟的发送时间
+	dropped    int                  // 被限流或队列满丢弃的条数
+
+	// 交易器就绪监控，见WatchTraderReady
+	traderWatches []*traderWatch
+
+	chMsg  chan Message
+	chStop chan int
+}
+
+// cfg.Sinks中的Sink按配置的级别接收，参数sinks接收所有级别
+func (n *Notifier) Init(name string, cfg Config, sinks ...Sink) {
+	n.logPrefix = "notifier-" + name
+	n.cfg = cfg
+	n.cfg.DedupSec = util.ValueIf(cfg.DedupSec > 0, cfg.DedupSec, 60)
+	n.cfg.MaxPerMinute = util.ValueIf(cfg.MaxPerMinute > 0, cfg.MaxPerMinute, 20)
+	n.lastSent = make(map[string]time.Time)
+	n.suppressed = make(map[string]int)
+	n.chMsg = make(chan Message, 256)
+	n.chStop = make(chan int, 1)
+	debugstats.RegisterQueue(n.logPrefix, func() int { return len(n.chMsg) })
+
+	for _, sc := range cfg.Sinks {
+		if severity, err := String2Severity(sc.MinSeverity); err != nil {
+			logger.LogImportant(n.logPrefix, "invalid sink config(type=%s): %s", sc.Type, err.Error())
+		} else if s, err := NewSink(sc); err != nil {
+			logger.LogImportant(n.logPrefix, "invalid sink config(type=%s): %s", sc.Type, err.Error())
+		} else {
+			n.AddSink(s, severity)
+		}
+	}
+
+	for _, s := range sinks {
+		n.AddSink(s, Severity_Info)
+	}
+}
+
+// 只发送级别不低于minSeverity的消息
+func (n *Notifier) AddSink(s Sink, minSeverity Severity) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.routes = append(n.routes, route{sink: s, minSeverity: minSeverity})
+}
+
+func (n *Notifier) Go() {
+	go n.run()
+}
+
+func (n *Notifier) Stop() {
+	n.chStop <- 0
+	debugstats.UnregisterQueue(n.logPrefix)
+}
+
+// key用于去重，为空时按title去重。返回false表示被去重/限流忽略
+func (n *Notifier) Notify(severity Severity, key, title, text string) bool {
+	key = util.ValueIf(len(key) > 0, key, title)
+	now := time.Now()
+
+	n.mu.Lock()
+	if t, ok := n.lastSent[key]; ok && now.Sub(t) < time.Duration(n.cfg.DedupSec)*time.Second {
+		n.suppressed[key]++
+		n.mu.Unlock()
+		return false
+	}
+
+	kept := n.sentTimes[:0]
+	for _, t := range n.sentTimes {
+		if now.Sub(t) < time.Minute {
+			kept = append(kept, t)
+		}
+	}
+	n.sentTimes = kept
+	if severity < Severity_Critical && len(n.sentTimes) >= n.cfg.MaxPerMinute {
+		n.dropped++
+		n.mu.Unlock()
+		logger.LogInfo(n.logPrefix, "throttled: %s", title)
+		return false
+	}
+
+	m := Message{
+		Severity:   severity,
+		Key:        key,
+		Title:      title,
+		Text:       text,
+		Time:       now,
+		Suppressed: n.suppressed[key],
+		Dropped:    n.dropped,
+	}
+
+	select {
+	case n.chMsg <- m:
+		n.lastSent[key] = now
+		delete(n.suppressed, key)
+		n.sentTimes = append(n.sentTimes, now)
+		n.dropped = 0
+		n.mu.Unlock()
+		return true
+	default:
+		n.dropped++
+		n.mu.Unlock()
+		logger.LogImportant(n.logPrefix, "queue full, dropped: %s", title)
+		return false
+	}
+}
+
+func (n *Notifier) Notifyf(severity Severity, key, title, format string, a ...interface{}) bool {
+	return n.Notify(severity, key, title, fmt.Sprintf(format, a...))
+}
+
+func (n *Notifier) run() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-n.chMsg:
+			n.send(m)
+		case <-ticker.C:
+			n.checkTraders()
+		case <-n.chStop:
+			return
+		}
+	}
+}
+
+func (n *Notifier) send(m Message) {
+	n.mu.Lock()
+	routes := append([]route{}, n.routes...)
+	n.mu.Unlock()
+
+	for _, r := range routes {
+		if m.Severity < r.minSeverity {
+			continue
+		}
+
+		func() {
+			defer util.DefaultRecover()
+			if err := r.sink.Send(m); err != nil {
+				logger.LogImportant(n.logPrefix, "send to %s failed, key=%s, err=%s", r.sink.Name(), m.Key, err.Error())
+			} else {
+				logger.LogInfo(n.logPrefix, "sent to %s, key=%s", r.sink.Name(), m.Key)
+			}
+		}()
+	}
+}
@@ -0,0 +1,316 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-05 10:32:06
+- @Description: 规则告警。定时用交易所的实时行情、账户数据评估规则，满足时通过Notifier发送
+- 内置的数据(kind)：
+- price      Inst的最新价                    funding   Inst(永续)的当期资金费率
+- position   Symbol+ContractType的净仓位      balance   Ccy的权益      available  Ccy的可用
+- 也可以通过AddValueRule使用自定义的数据源
+- 条件(op)：>、>=、<、<= 在条件由不满足变为满足时触发(包括首次评估)，条件不再满足后重新生效；RepeatSec>0时持续满足期间每隔RepeatSec再次触发
+- cross_up/cross_down/cross 在数据上穿/下穿/穿越Level时触发。数据暂时取不到(如行情未就绪)时跳过，不影响上一次的值
+- Abs为true时比较绝对值，如|position| > 10、|funding| > 0.001
+- 规则可在配置中给出，也可以运行时AddRule/RemoveRule
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type RuleConfig struct {
+	Name         string          `json:"name"`          // 唯一，用作去重的key
+	Kind         string          `json:"kind"`          // price/funding/position/balance/available，自定义数据源时不需要
+	Inst         string          `json:"inst"`          // price/funding使用，与行情器的Type()相同，如BTC-USDT-SWAP
+	Symbol       string          `json:"symbol"`        // position使用，如btc
+	ContractType string          `json:"contract_type"` // position使用，如usdt_swap
+	Ccy          string          `json:"ccy"`           // balance/available使用
+	Op           string          `json:"op"`            // >/>=/</<=/cross_up/cross_down/cross
+	Level        decimal.Decimal `json:"level"`
+	Abs          bool            `json:"abs"`
+	RepeatSec    int             `json:"repeat_sec"`
+	Severity     string          `json:"severity"` // 默认warning
+}
+
+type RuleEngineConfig struct {
+	IntervalSec int          `json:"interval_sec"` // 评估间隔，默认5秒
+	Rules       []RuleConfig `json:"rules"`
+}
+
+type rule struct {
+	cfg      RuleConfig
+	severity Severity
+	fnValue  func() (decimal.Decimal, bool)
+
+	prev      decimal.Decimal
+	hasPrev   bool
+	active    bool      // 阈值条件当前是否满足
+	lastFired time.Time // 最近一次触发时间
+}
+
+type RuleEngine struct {
+	logPrefix   string
+	ex          common.CEx
+	n           *Notifier
+	intervalSec int
+
+	mu    sync.Mutex
+	rules []*rule
+
+	chStop chan int
+}
+
+func (e *RuleEngine) Init(name string, ex common.CEx, n *Notifier, cfg RuleEngineConfig) {
+	e.logPrefix = "rules-" + name
+	e.ex = ex
+	e.n = n
+	e.intervalSec = util.ValueIf(cfg.IntervalSec > 0, cfg.IntervalSec, 5)
+	e.chStop = make(chan int, 1)
+
+	for _, rc := range cfg.Rules {
+		if err := e.AddRule(rc); err != nil {
+			logger.LogImportant(e.logPrefix, "invalid rule %s: %s", rc.Name, err.Error())
+		}
+	}
+}
+
+// 使用内置数据源的规则，同名规则会被替换
+func (e *RuleEngine) AddRule(rc RuleConfig) error {
+	fn, err := e.builtinValueFn(rc)
+	if err != nil {
+		return err
+	}
+	return e.AddValueRule(rc, fn)
+}
+
+// 使用自定义数据源的规则，fnValue返回false表示暂时取不到数据
+func (e *RuleEngine) AddValueRule(rc RuleConfig, fnValue func() (decimal.Decimal, bool)) error {
+	if len(rc.Name) == 0 {
+		return errors.New("rule name is empty")
+	}
+
+	switch rc.Op {
+	case ">", ">=", "<", "<=", "cross_up", "cross_down", "cross":
+	default:
+		return fmt.Errorf("invalid op: %s", rc.Op)
+	}
+
+	severity := Severity_Warning
+	if len(rc.Severity) > 0 {
+		var err error
+		if severity, err = String2Severity(rc.Severity); err != nil {
+			return err
+		}
+	}
+
+	r := &rule{cfg: rc, severity: severity, fnValue: fnValue}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, old := range e.rules {
+		if old.cfg.Name == rc.Name {
+			e.rules[i] = r
+			return nil
+		}
+	}
+	e.rules = append(e.rules, r)
+	logger.LogInfo(e.logPrefix, "rule added: %s", ruleDesc(rc))
+	return nil
+}
+
+func (e *RuleEngine) RemoveRule(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kept := make([]*rule, 0, len(e.rules))
+	for _, r := range e.rules {
+		if r.cfg.Name != name {
+			kept = append(kept, r)
+		}
+	}
+	e.rules = kept
+}
+
+func (e *RuleEngine) Rules() []RuleConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rcs := make([]RuleConfig, 0, len(e.rules))
+	for _, r := range e.rules {
+		rcs = append(rcs, r.cfg)
+	}
+	return rcs
+}
+
+func (e *RuleEngine) Go() {
+	go e.update()
+}
+
+func (e *RuleEngine) Stop() {
+	e.chStop <- 0
+}
+
+func (e *RuleEngine) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Duration(e.intervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.evaluate(time.Now())
+		case <-e.chStop:
+			return
+		}
+	}
+}
+
+func (e *RuleEngine) evaluate(now time.Time) {
+	e.mu.Lock()
+	rules := append([]*rule{}, e.rules...)
+	e.mu.Unlock()
+
+	for _, r := range rules {
+		func() {
+			defer util.DefaultRecover()
+			v, ok := r.fnValue()
+			if !ok {
+				return
+			}
+
+			if r.cfg.Abs {
+				v = v.Abs()
+			}
+
+			if r.check(v, now) {
+				e.n.Notifyf(
+					r.severity,
+					"rule-"+r.cfg.Name,
+					fmt.Sprintf("rule %s triggered", r.cfg.Name),
+					"%s, value=%v", ruleDesc(r.cfg), v)
+			}
+		}()
+	}
+}
+
+// 返回是否触发。只在评估协程中调用
+func (r *rule) check(v decimal.Decimal, now time.Time) bool {
+	prev, hasPrev := r.prev, r.hasPrev
+	r.prev, r.hasPrev = v, true
+
+	level := r.cfg.Level
+	switch r.cfg.Op {
+	case "cross_up":
+		return hasPrev && prev.LessThan(level) && v.GreaterThanOrEqual(level)
+	case "cross_down":
+		return hasPrev && prev.GreaterThan(level) && v.LessThanOrEqual(level)
+	case "cross":
+		return hasPrev && (prev.LessThan(level) && v.GreaterThanOrEqual(level) || prev.GreaterThan(level) && v.LessThanOrEqual(level))
+	}
+
+	met := false
+	switch r.cfg.Op {
+	case ">":
+		met = v.GreaterThan(level)
+	case ">=":
+		met = v.GreaterThanOrEqual(level)
+	case "<":
+		met = v.LessThan(level)
+	case "<=":
+		met = v.LessThanOrEqual(level)
+	}
+
+	fire := false
+	if met && !r.active {
+		fire = true
+	} else if met && r.cfg.RepeatSec > 0 && now.Sub(r.lastFired) >= time.Duration(r.cfg.RepeatSec)*time.Second {
+		fire = true
+	}
+
+	r.active = met
+	if fire {
+		r.lastFired = now
+	}
+	return fire
+}
+
+func (e *RuleEngine) builtinValueFn(rc RuleConfig) (func() (decimal.Decimal, bool), error) {
+	switch strings.ToLower(rc.Kind) {
+	case "price":
+		return func() (decimal.Decimal, bool) {
+			if m := e.findMarket(rc.Inst); m != nil && m.Ready() {
+				p := m.LatestPrice()
+				return p, p.IsPositive()
+			}
+			return decimal.Zero, false
+		}, nil
+	case "funding":
+		return func() (decimal.Decimal, bool) {
+			if m, ok := e.findMarket(rc.Inst).(common.FutureMarket); ok {
+				rate, _, _, _ := m.FundingInfo()
+				return rate, true
+			}
+			return decimal.Zero, false
+		}, nil
+	case "position":
+		return func() (decimal.Decimal, bool) {
+			for _, p := range e.ex.GetAllPositions() {
+				if strings.EqualFold(p.Symbol(), rc.Symbol) && strings.EqualFold(p.ContractType(), rc.ContractType) {
+					return p.Net(), true
+				}
+			}
+			return decimal.Zero, true // 没有仓位视为0
+		}, nil
+	case "balance", "available":
+		available := strings.ToLower(rc.Kind) == "available"
+		return func() (decimal.Decimal, bool) {
+			for _, b := range e.ex.GetAllBalances() {
+				if strings.EqualFold(b.Ccy(), rc.Ccy) {
+					return util.ValueIf(available, b.Available(), b.Rights()), true
+				}
+			}
+			return decimal.Zero, false
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid kind: %s", rc.Kind)
+	}
+}
+
+func (e *RuleEngine) findMarket(inst string) common.CommonMarket {
+	for _, m := range e.ex.FutureMarkets() {
+		if strings.EqualFold(m.Type(), inst) {
+			return m
+		}
+	}
+
+	for _, m := range e.ex.SpotMarkets() {
+		if strings.EqualFold(m.Type(), inst) {
+			return m
+		}
+	}
+	return nil
+}
+
+func ruleDesc(rc RuleConfig) string {
+	target := ""
+	switch strings.ToLower(rc.Kind) {
+	case "price", "funding":
+		target = rc.Inst
+	case "position":
+		target = rc.Symbol + "_" + rc.ContractType
+	case "balance", "available":
+		target = rc.Ccy
+	}
+
+	v := util.ValueIf(len(target) > 0, fmt.Sprintf("%s(%s)", rc.Kind, target), util.ValueIf(len(rc.Kind) > 0, rc.Kind, "value"))
+	if rc.Abs {
+		v = "|" + v + "|"
+	}
+	return fmt.Sprintf("%s %s %v", v, rc.Op, rc.Level)
+}
@@ -0,0 +1,74 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-02 15:03:19
+- @Description: Slack和Discord，均使用频道的incoming webhook
+- Slack: 在App的Incoming Webhooks中创建，如https://hooks.slack.com/services/T000/B000/XXXX
+- Discord: 频道设置-整合-Webhook中创建，如https://discord.com/api/webhooks/123/XXXX，单条消息最长2000字符
+- url中含密钥，错误信息中会隐去
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"net/url"
+
+	"github.com/aztecqt/dagger/util"
+)
+
+const discordMaxLen = 2000
+
+type SlackSink struct {
+	url string
+}
+
+func NewSlackSink(url string) *SlackSink {
+	s := new(SlackSink)
+	s.url = url
+	return s
+}
+
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+func (s *SlackSink) Send(m Message) error {
+	postData := util.Object2StringWithoutIntent(map[string]interface{}{"text": m.Format()})
+	status, body, err := postJson(s.url, postData, nil, urlPath(s.url))
+	return checkStatus(status, body, err)
+}
+
+type DiscordSink struct {
+	url string
+}
+
+func NewDiscordSink(url string) *DiscordSink {
+	s := new(DiscordSink)
+	s.url = url
+	return s
+}
+
+func (s *DiscordSink) Name() string {
+	return "discord"
+}
+
+func (s *DiscordSink) Send(m Message) error {
+	postData := util.Object2StringWithoutIntent(map[string]interface{}{"content": truncate(m.Format(), discordMaxLen)})
+	status, body, err := postJson(s.url, postData, nil, urlPath(s.url))
+	return checkStatus(status, body, err)
+}
+
+// webhook的密钥在路径中
+func urlPath(rawUrl string) string {
+	if u, err := url.Parse(rawUrl); err == nil && len(u.Path) > 1 {
+		return u.Path
+	}
+	return rawUrl
+}
+
+// 按字符截断
+func truncate(text string, maxLen int) string {
+	if r := []rune(text); len(r) > maxLen {
+		return string(r[:maxLen-3]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,75 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-02 11:02:40
+- @Description: Telegram机器人。通过bot api的sendMessage发送到指定的chat(个人、群组或频道)
+- token由@BotFather创建机器人时获得，chat_id可从getUpdates中查到，频道可用@频道名
+- 被限流(429)时按retry_after等待后重试一次(见postJson)。url中含token，错误信息中的token会被隐去
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aztecqt/dagger/util"
+)
+
+const telegramRootUrl = "https://api.telegram.org"
+
+// 单条消息的最大长度
+const telegramMaxLen = 4096
+
+type TelegramConfig struct {
+	Token  string `json:"token"`
+	ChatId string `json:"chat_id"`
+}
+
+type telegramResp struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+type TelegramSink struct {
+	token  string
+	chatId string
+}
+
+func NewTelegramSink(cfg TelegramConfig) *TelegramSink {
+	s := new(TelegramSink)
+	s.token = cfg.Token
+	s.chatId = cfg.ChatId
+	return s
+}
+
+func (s *TelegramSink) Name() string {
+	return "telegram"
+}
+
+func (s *TelegramSink) Send(m Message) error {
+	if len(s.token) == 0 || len(s.chatId) == 0 {
+		return errors.New("telegram token or chat_id not set")
+	}
+
+	postData := util.Object2StringWithoutIntent(map[string]interface{}{
+		"chat_id":                  s.chatId,
+		"text":                     truncate(m.Format(), telegramMaxLen),
+		"disable_web_page_preview": true,
+	})
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", telegramRootUrl, s.token)
+	status, body, err := postJson(url, postData, nil, s.token)
+	if err != nil {
+		return err
+	}
+
+	resp := telegramResp{}
+	if json.Unmarshal(body, &resp) != nil {
+		return fmt.Errorf("invalid response, status=%d", status)
+	} else if !resp.Ok {
+		return fmt.Errorf("code=%d, msg=%s", resp.ErrorCode, resp.Description)
+	}
+	return nil
+}
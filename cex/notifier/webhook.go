@@ -0,0 +1,143 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-02 14:27:51
+- @Description: 通用webhook，以及各Sink共用的发送逻辑
+- 通用webhook以POST发送json：{"severity":"critical","key":"...","title":"...","text":"...","time":"...","suppressed":0,"dropped":0,"content":"<Format的结果>"}
+- 可在Headers中配置鉴权等http头
+- 返回429时按Retry-After(没有时取body中的retry_after)等待，最多30秒，然后重试一次
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/network"
+)
+
+// 类型为telegram时使用Token、ChatId，其余类型使用Url
+type SinkConfig struct {
+	Type        string            `json:"type"` // telegram/slack/discord/webhook
+	Url         string            `json:"url"`
+	Token       string            `json:"token"`
+	ChatId      string            `json:"chat_id"`
+	Headers     map[string]string `json:"headers"`      // 仅webhook
+	MinSeverity string            `json:"min_severity"` // info/warning/critical，默认info
+}
+
+func NewSink(cfg SinkConfig) (Sink, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "telegram":
+		return NewTelegramSink(TelegramConfig{Token: cfg.Token, ChatId: cfg.ChatId}), nil
+	case "slack":
+		return NewSlackSink(cfg.Url), nil
+	case "discord":
+		return NewDiscordSink(cfg.Url), nil
+	case "webhook":
+		return NewWebhookSink(cfg.Url, cfg.Headers), nil
+	default:
+		return nil, fmt.Errorf("unknown sink type: %s", cfg.Type)
+	}
+}
+
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+}
+
+func NewWebhookSink(url string, headers map[string]string) *WebhookSink {
+	s := new(WebhookSink)
+	s.url = url
+	s.headers = headers
+	return s
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Send(m Message) error {
+	postData := util.Object2StringWithoutIntent(map[string]interface{}{
+		"severity":   m.Severity.String(),
+		"key":        m.Key,
+		"title":      m.Title,
+		"text":       m.Text,
+		"time":       m.Time,
+		"suppressed": m.Suppressed,
+		"dropped":    m.Dropped,
+		"content":    m.Format(),
+	})
+
+	status, body, err := postJson(s.url, postData, s.headers, "")
+	return checkStatus(status, body, err)
+}
+
+type retryAfterResp struct {
+	RetryAfter float64 `json:"retry_after"`
+	Parameters struct {
+		RetryAfter float64 `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// 发送json，429时重试一次。secret不为空时从错误信息中隐去(url中含密钥时使用)
+func postJson(url, postData string, headers map[string]string, secret string) (status int, body []byte, err error) {
+	if len(url) == 0 {
+		return 0, nil, errors.New("url not set")
+	}
+
+	h := network.JsonHeaders()
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		retryAfter := time.Duration(0)
+		network.HttpCall(url, "POST", postData, h, func(r *http.Response, e error) {
+			if e != nil {
+				err = e
+				return
+			}
+
+			status = r.StatusCode
+			body, err = io.ReadAll(r.Body)
+			if r.StatusCode == http.StatusTooManyRequests {
+				sec, _ := strconv.ParseFloat(r.Header.Get("Retry-After"), 64)
+				if sec <= 0 {
+					// telegram、discord在body中也会给出
+					ra := retryAfterResp{}
+					json.Unmarshal(body, &ra)
+					sec = max(ra.RetryAfter, ra.Parameters.RetryAfter)
+				}
+				retryAfter = time.Duration(util.ClampFloat(sec, 1, 30) * float64(time.Second))
+			}
+		})
+
+		if retryAfter == 0 || attempt > 0 {
+			break
+		}
+		time.Sleep(retryAfter)
+	}
+
+	if err != nil && len(secret) > 0 {
+		err = errors.New(strings.ReplaceAll(err.Error(), secret, "***"))
+	}
+	return
+}
+
+// 2xx为成功
+func checkStatus(status int, body []byte, err error) error {
+	if err != nil {
+		return err
+	} else if status < 200 || status >= 300 {
+		return fmt.Errorf("status=%d, body=%s", status, string(body[:min(len(body), 256)]))
+	}
+	return nil
+}
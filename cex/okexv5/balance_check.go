@@ -0,0 +1,157 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-26 10:12:45
+- @Description: 余额核对。余额平时由ws推送维护，成交时记录临时权益(RecordTempRights)，推送丢失时两者都会偏离
+- 定时(ExchangeConfig.BalanceCheckIntervalSec)执行：
+- 1. 丢弃超过TempRightsExpireSec仍未被推送确认的临时权益
+- 2. 拉取rest余额与本地比较，rest中没有的币种视为0。有临时权益、或本地刷新时间晚于rest的币种跳过
+- 3. 发现差异时5秒后再拉取一次，两次差异一致才认为是真实偏差，以rest为准修正
+- 4. 连续BalanceMismatchTimes次核对都需要修正时，说明推送持续异常，通过SubscribeBalanceMismatch报告
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 持续的余额差异
+type BalanceMismatch struct {
+	Ccy   string
+	Local decimal.Decimal
+	Rest  decimal.Decimal
+	Times int // 连续出现差异的次数
+	Time  time.Time
+}
+
+type restBalance struct {
+	rights     decimal.Decimal
+	frozen     decimal.Decimal
+	updateTime time.Time
+}
+
+// 订阅持续的余额差异
+func (e *Exchange) SubscribeBalanceMismatch(fn func(m BalanceMismatch)) {
+	e.muBalanceMismatch.Lock()
+	defer e.muBalanceMismatch.Unlock()
+	e.balanceMismatchFns = append(e.balanceMismatchFns, fn)
+}
+
+func (e *Exchange) fetchRestBalances() (map[string]restBalance, bool) {
+	resp, err := okexv5api.GetAccountBalance(nil)
+	if err != nil {
+		logger.LogImportant(logPrefix, "get balance from rest failed: %s", err.Error())
+		return nil, false
+	} else if resp.Code != "0" {
+		logger.LogImportant(logPrefix, "get balance from rest failed: code=%v, msg=%v", resp.Code, resp.Msg)
+		return nil, false
+	} else if len(resp.Data) == 0 {
+		return nil, false
+	}
+
+	result := make(map[string]restBalance)
+	for _, d := range resp.Data[0].Details {
+		result[strings.ToLower(d.Currency)] = restBalance{
+			rights:     util.String2DecimalPanic(d.Eq),
+			frozen:     util.String2DecimalPanicUnless(d.Frozen, ""),
+			updateTime: util.ConvetUnix13StrToTimePanicUnless(d.UTime, ""),
+		}
+	}
+	return result, true
+}
+
+// 本地余额与rest余额的差异(rest-本地)
+func (e *Exchange) balanceDrifts(rest map[string]restBalance) map[string]decimal.Decimal {
+	drifts := make(map[string]decimal.Decimal)
+	for _, b := range e.balanceMgr.GetAllBalances() {
+		if b.HasTempRights() {
+			continue
+		}
+
+		rb := rest[b.Ccy()]
+		if !rb.updateTime.IsZero() && b.UpdateTime().After(rb.updateTime) {
+			continue
+		}
+
+		if diff := rb.rights.Sub(b.Rights()); !diff.IsZero() {
+			drifts[b.Ccy()] = diff
+		}
+	}
+	return drifts
+}
+
+func (e *Exchange) checkBalances() {
+	// 衰减过期的临时权益
+	expireSec := util.ValueIf(e.excfg.TempRightsExpireSec > 0, e.excfg.TempRightsExpireSec, 10)
+	for _, b := range e.balanceMgr.GetAllBalances() {
+		if expired := b.ExpireTemp(time.Second * time.Duration(expireSec)); !expired.IsZero() {
+			logger.LogImportant(logPrefix, "temp rights of %s not confirmed in %ds, dropped %v", b.Ccy(), expireSec, expired)
+		}
+	}
+
+	rest, ok := e.fetchRestBalances()
+	if !ok {
+		return
+	}
+
+	drifts := e.balanceDrifts(rest)
+	if len(drifts) == 0 {
+		e.muBalanceMismatch.Lock()
+		clear(e.balanceMismatches)
+		e.muBalanceMismatch.Unlock()
+		return
+	}
+
+	for ccy, d := range drifts {
+		logger.LogInfo(logPrefix, "balance drift of %s: %v, confirming...", ccy, d)
+	}
+
+	time.Sleep(time.Second * 5)
+	if rest, ok = e.fetchRestBalances(); !ok {
+		return
+	}
+
+	now := time.Now()
+	confirmed := e.balanceDrifts(rest)
+	var mismatches []BalanceMismatch
+	e.muBalanceMismatch.Lock()
+	for ccy := range e.balanceMismatches {
+		if _, ok := confirmed[ccy]; !ok {
+			delete(e.balanceMismatches, ccy)
+		}
+	}
+
+	for ccy, d := range confirmed {
+		if prev, ok := drifts[ccy]; !ok || !prev.Equal(d) {
+			delete(e.balanceMismatches, ccy)
+			continue
+		}
+
+		b := e.balanceMgr.FindBalance(ccy)
+		rb := rest[ccy]
+		local := b.Rights()
+		logger.LogImportant(logPrefix, "balance of %s drifted, local=%v, rest=%v, corrected", ccy, local, rb.rights)
+		b.Refresh(rb.rights, rb.frozen, now)
+
+		e.balanceMismatches[ccy]++
+		times := e.balanceMismatches[ccy]
+		if times >= util.ValueIf(e.excfg.BalanceMismatchTimes > 0, e.excfg.BalanceMismatchTimes, 3) {
+			mismatches = append(mismatches, BalanceMismatch{Ccy: ccy, Local: local, Rest: rb.rights, Times: times, Time: now})
+		}
+	}
+	fns := e.balanceMismatchFns
+	e.muBalanceMismatch.Unlock()
+
+	for _, m := range mismatches {
+		logger.LogImportant(logPrefix, "balance of %s mismatched %d times in a row, local=%v, rest=%v", m.Ccy, m.Times, m.Local, m.Rest)
+		for _, fn := range fns {
+			fn(m)
+		}
+	}
+}
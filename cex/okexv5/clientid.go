@@ -0,0 +1,120 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-26 16:05:52
+- @Description: clientOrderId防重复。clientOrderId = 启动前缀 + 序号 + purpose(见helper.go)
+- 1. 配置了ExchangeConfig.ClientIdSeqFile时，前缀和序号持久化到文件，重启后序号接着上次继续，
+- 前缀与上次相同(同一秒内重启，或36进制前缀循环重合)时换一个前缀，保证与之前进程的订单不重复
+- 序号按块预留，每用完clientIdSeqBlock个才写一次文件。文件带版本号(见util.VersionedObjectToFile)
+- 2. 下单时交易所返回clientOrderId重复(51016)时，查询该订单：是本订单(同tag同方向同数量同价格)则直接接管，不重复下单；否则下单失败
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const clientIdSeqBlock = 1000
+
+// 序号文件格式版本
+const (
+	clientIdStateKind    = "okex_client_id"
+	clientIdStateVersion = 1
+)
+
+func init() {
+	util.RegisterMigration(clientIdStateKind, 0, util.NoopMigration)
+}
+
+type clientIdState struct {
+	Namespace string `json:"namespace"`
+	Seq       int32  `json:"seq"` // 已预留到的序号
+}
+
+var clientIdSeqFile string
+var clientIdSeqLeased int32
+var muClientIdSeq sync.Mutex
+
+// 载入上次的前缀和序号
+func initClientIdSeq(path string) {
+	if len(path) == 0 {
+		return
+	}
+
+	muClientIdSeq.Lock()
+	defer muClientIdSeq.Unlock()
+	clientIdSeqFile = path
+	st := clientIdState{}
+	if util.VersionedObjectFromFile(path, clientIdStateKind, clientIdStateVersion, &st) {
+		if st.Namespace == clientIdNamespace {
+			n, _ := strconv.ParseInt(st.Namespace, 36, 64)
+			ns := strconv.FormatInt((n+1)%1679616, 36)
+			clientIdNamespace = strings.Repeat("0", 4-len(ns)) + ns
+			logger.LogImportant(logPrefix, "client id namespace %s used by last process, switch to %s", st.Namespace, clientIdNamespace)
+		}
+
+		if st.Seq > atomic.LoadInt32(&accClientOrderId) {
+			atomic.StoreInt32(&accClientOrderId, st.Seq)
+		}
+	}
+
+	leaseClientIdSeqUnsafe(atomic.LoadInt32(&accClientOrderId))
+	logger.LogImportant(logPrefix, "client id namespace=%s, seq start from %d", clientIdNamespace, atomic.LoadInt32(&accClientOrderId))
+}
+
+// 序号用到seq时，确保已预留
+func leaseClientIdSeq(seq int32) {
+	if len(clientIdSeqFile) == 0 || seq <= atomic.LoadInt32(&clientIdSeqLeased) {
+		return
+	}
+
+	muClientIdSeq.Lock()
+	defer muClientIdSeq.Unlock()
+	if seq > clientIdSeqLeased {
+		leaseClientIdSeqUnsafe(seq)
+	}
+}
+
+func leaseClientIdSeqUnsafe(seq int32) {
+	st := clientIdState{Namespace: clientIdNamespace, Seq: seq + clientIdSeqBlock}
+	if util.VersionedObjectToFile(clientIdSeqFile, clientIdStateKind, clientIdStateVersion, st) {
+		atomic.StoreInt32(&clientIdSeqLeased, st.Seq)
+	} else {
+		logger.LogImportant(logPrefix, "save client id seq to %s failed", clientIdSeqFile)
+	}
+}
+
+// clientOrderId重复时，交易所上的同名订单是否就是本订单。市价单没有价格，不比较
+func (o *CommonOrder) isSameOrder(d okexv5api.OrderResp, side string) bool {
+	return d.ClientOrderId == o.CltOrderId.(string) &&
+		d.Tag == orderTag() &&
+		d.Side == side &&
+		util.String2DecimalPanicUnless(d.Size, "").Equal(o.Size) &&
+		(o.MarketOrder || util.String2DecimalPanicUnless(d.Price, "").Equal(o.Price))
+}
+
+// 下单返回clientOrderId重复时调用。返回true表示已接管交易所上的订单
+func (o *CommonOrder) adoptDuplicated(side string) bool {
+	resp, err := okexv5api.GetOrderInfo(o.InstId, 0, o.CltOrderId.(string))
+	if err != nil || resp.Code != "0" || len(resp.Data) == 0 {
+		o.LogFields.LogImportant(o.LogPrefix, "duplicated client id, query order failed")
+		return false
+	}
+
+	d := resp.Data[0]
+	if !o.isSameOrder(d, side) {
+		o.LogFields.LogImportant(o.LogPrefix, "client id reused by another order: id=%s, %s %s@%s, tag=%s", d.OrderId, d.Side, d.Size, d.Price, d.Tag)
+		return false
+	}
+
+	o.OrderId = util.String2Int64Panic(d.OrderId)
+	o.LogFields.LogImportant(o.LogPrefix, "order already exists, adopted, order id = %v", o.OrderId)
+	return true
+}
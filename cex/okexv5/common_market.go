@@ -18,6 +18,7 @@ import (
 
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
 
 	"github.com/aztecqt/dagger/api/okexv5api"
 	"github.com/aztecqt/dagger/cex/common"
@@ -31,18 +32,35 @@ type CommonMarket struct {
 	instId          string
 	inst            common.Instruments
 	latestPrice     decimal.Decimal
+	ticker24h       common.Ticker24h
 	orderBook       *common.Orderbook
 	depthFromTicker bool
 	tickerFromRest  bool
+	fullDepth       bool
+
+	// 本地深度的seqId，用于检测增量推送的缺口
+	lastSeqId       int64
+	depthConsistent bool
 
 	priceOK bool
 	depthOK bool
 
+	// 公共ws是否已连接。各频道的订阅是否恢复由各自的数据推送判断
+	wsConnected bool
+	wsStateId   int // 连接状态的订阅Id，Uninit时取消
+
 	// 深度变化回调
 	depthObserversSet *hashset.Set
 	depthObservers    []interface{}
 
+	// 逐笔成交回调
+	trades common.TradeHub
+	barHub common.BarHub
+
 	subscribing bool
+
+	// Uninit时关闭，通知各频道的订阅协程退出
+	chStop chan struct{}
 }
 
 func (m *CommonMarket) Init(ex *Exchange, inst common.Instruments, depthFromTicker, tickerFromRest bool) {
@@ -52,6 +70,7 @@ func (m *CommonMarket) Init(ex *Exchange, inst common.Instruments, depthFromTick
 	m.inst = inst
 	m.depthFromTicker = depthFromTicker
 	m.tickerFromRest = tickerFromRest
+	m.fullDepth = ex.excfg.FullDepth
 	m.orderBook = common.NewOrderBook()
 	m.priceOK = false
 	m.depthOK = false
@@ -59,6 +78,23 @@ func (m *CommonMarket) Init(ex *Exchange, inst common.Instruments, depthFromTick
 	m.depthObserversSet = hashset.New()
 
 	m.subscribing = false
+	m.chStop = make(chan struct{})
+	m.barHub.SetClock(ex.clock)
+	m.wsStateId = m.ws.SubscribePublicState(m.onWsState)
+}
+
+// 断线期间收不到推送，本地深度作废，等重连后的快照
+func (m *CommonMarket) onWsState(e network.ConnEvent) {
+	m.wsConnected = e.State >= network.ConnState_Connected
+	if e.State == network.ConnState_Disconnected && !m.depthFromTicker {
+		m.depthOK = false
+		m.depthConsistent = false
+	}
+}
+
+// 行情全部来自rest时不依赖ws
+func (m *CommonMarket) wsOK() bool {
+	return m.wsConnected || m.depthFromTicker && m.tickerFromRest
 }
 
 func (m *CommonMarket) subscribe(instID string) {
@@ -122,7 +158,12 @@ func (m *CommonMarket) subscribe(instID string) {
 			timeout := time.NewTicker(time.Second * 5)
 			chBadDepth := make(chan int, 1)
 			updateTicker := time.NewTicker(time.Second)
-			s := m.ws.SubscribeDepth5(instID, func(resp interface{}) {
+			fnSub := util.ValueIf(m.fullDepth, m.ws.SubscribeDepth, m.ws.SubscribeDepth5)
+			s := fnSub(instID, func(resp interface{}) {
+				if resp.(okexv5api.DepthWsResp).Action == "update" && !m.depthConsistent {
+					return // 已发现缺口，等待重新订阅后的快照
+				}
+
 				if m.onDepthResp(resp) {
 					// 推送
 					for _, observer := range m.depthObservers {
@@ -140,6 +181,7 @@ func (m *CommonMarket) subscribe(instID string) {
 				select {
 				case <-timeout.C:
 					m.depthOK = false
+					m.depthConsistent = false
 					s.Reset()
 				case <-chBadDepth:
 					m.depthOK = false
@@ -159,14 +201,34 @@ func (m *CommonMarket) subscribe(instID string) {
 
 func (m *CommonMarket) unsubscribe(instID string) {
 	m.subscribing = false
+	close(m.chStop)
+	m.ws.UnsubscribePublicState(m.wsStateId)
 	m.ws.UnsubscribeTicker(instID)
 	if !m.depthFromTicker {
-		m.ws.UnsubscribeDepth5(instID)
+		if m.fullDepth {
+			m.ws.UnsubscribeDepth(instID)
+		} else {
+			m.ws.UnsubscribeDepth5(instID)
+		}
+		m.depthConsistent = false
+	}
+
+	if m.trades.Len() > 0 {
+		m.ws.UnsubscribeTrades(instID)
 	}
 }
 
 func (m *CommonMarket) onTickerResp(ticker okexv5api.TickerResp) {
 	m.latestPrice = ticker.Last // 最新成交价
+	m.ticker24h = common.Ticker24h{
+		Time:      ticker.Time,
+		Open:      ticker.Open24h,
+		High:      ticker.High24h,
+		Low:       ticker.Low24h,
+		Last:      ticker.Last,
+		Volume:    util.ValueIf(ticker.InstType == "SPOT", ticker.Vol24h, ticker.VolCcy24h),
+		VolumeUsd: ticker.VolUsd24h,
+	}
 
 	// ticker模拟深度
 	if m.depthFromTicker {
@@ -175,43 +237,81 @@ func (m *CommonMarket) onTickerResp(ticker okexv5api.TickerResp) {
 		m.orderBook.Clear()
 		m.orderBook.UpdateBids(buy1, decimal.NewFromInt(1))
 		m.orderBook.UpdateAsk(sell1, decimal.NewFromInt(1))
+		m.orderBook.Updated()
 		m.depthOK = true
 	}
 }
 
 func (m *CommonMarket) onDepthResp(resp interface{}) bool {
 	r := resp.(okexv5api.DepthWsResp)
+	d := r.Data[0]
 
 	if r.Action != "update" { // "snapshot"/""
 		m.orderBook.Clear()
+	} else if d.PrevSeqId != m.lastSeqId {
+		// 增量推送不连续
+		logger.LogImportant(logPrefix, "%s depth gap detected, prevSeqId=%d, local seqId=%d, re-subscribe it", m.instId, d.PrevSeqId, m.lastSeqId)
+		m.depthConsistent = false
+		return false
 	}
+	m.lastSeqId = d.SeqId
 
 	// 构建/更新depth
-	for _, depthUnit := range r.Data[0].Asks {
+	for _, depthUnit := range d.Asks {
 		price := util.String2DecimalPanic(depthUnit[0])
 		amount := util.String2DecimalPanic(depthUnit[1])
 		m.orderBook.UpdateAsk(price, amount)
 	}
 
-	for _, depthUnit := range r.Data[0].Bids {
+	for _, depthUnit := range d.Bids {
 		price := util.String2DecimalPanic(depthUnit[0])
 		amount := util.String2DecimalPanic(depthUnit[1])
 		m.orderBook.UpdateBids(price, amount)
 	}
+	m.orderBook.Updated()
 
 	// 验证checksum
-	remoteChecksum := uint32(r.Data[0].Checksum)
+	remoteChecksum := uint32(d.Checksum)
 	if remoteChecksum > 0 {
 		localChecksum := m.depthCheckSum()
 
 		if remoteChecksum != localChecksum {
 			logger.LogImportant(logPrefix, "%s depth checksum failed, re-subscribe it", m.instId)
+			m.depthConsistent = false
 			return false
-		} else {
-			return true
 		}
+	}
+
+	m.depthConsistent = true
+	return true
+}
+
+func (m *CommonMarket) onTradesResp(resp interface{}) {
+	r := resp.(okexv5api.TradesWsResp)
+	now := m.ex.now()
+	for _, d := range r.Data {
+		t := common.PublicTrade{
+			LocalTime: now,
+			UTime:     util.ConvetUnix13StrToTimePanic(d.TimeStamp),
+			Id:        d.TradeID,
+			Price:     d.Price,
+			Size:      m.tradeBaseSize(d.Price, d.Size),
+			Dir:       util.ValueIf(d.Side == "buy", common.OrderDir_Buy, common.OrderDir_Sell),
+		}
+		m.trades.Publish(t)
+	}
+}
+
+// 合约成交数量为张数，折算为基础币数量(U本位乘面值，币本位乘面值再除以价格)
+func (m *CommonMarket) tradeBaseSize(px, sz decimal.Decimal) decimal.Decimal {
+	if !m.inst.CtVal.IsPositive() {
+		return sz
+	} else if m.inst.IsUsdtContract {
+		return sz.Mul(m.inst.CtVal)
+	} else if px.IsPositive() {
+		return sz.Mul(m.inst.CtVal).Div(px)
 	} else {
-		return true
+		return sz
 	}
 }
 
@@ -256,6 +356,18 @@ func (m *CommonMarket) RemoveDepthObserver(o common.DepthObserver) {
 	m.depthObservers = m.depthObserversSet.Values()
 }
 
+func (m *CommonMarket) SubscribeTrades(fn func(t common.PublicTrade)) int {
+	return m.trades.Add(fn, func() { m.ws.SubscribeTrades(m.instId, m.onTradesResp) })
+}
+
+func (m *CommonMarket) UnsubscribeTrades(id int) {
+	m.trades.Remove(id, func() { m.ws.UnsubscribeTrades(m.instId) })
+}
+
+func (m *CommonMarket) Bars(cfg common.BarConfig) *common.BarBuilder {
+	return m.barHub.Use(cfg, m.SubscribeTrades)
+}
+
 func (m *CommonMarket) Type() string {
 	return m.instId
 }
@@ -264,10 +376,22 @@ func (m *CommonMarket) LatestPrice() decimal.Decimal {
 	return m.latestPrice
 }
 
+func (m *CommonMarket) Ticker24h() common.Ticker24h {
+	return m.ticker24h
+}
+
 func (m *CommonMarket) OrderBook() *common.Orderbook {
 	return m.orderBook
 }
 
+// 本地深度是否与交易所一致(序列连续且校验通过)
+func (m *CommonMarket) DepthConsistent() bool {
+	if m.depthFromTicker {
+		return m.depthOK
+	}
+	return m.depthOK && m.depthConsistent
+}
+
 func (m *CommonMarket) AlignPriceNumber(price decimal.Decimal) decimal.Decimal {
 	return m.ex.instrumentMgr.AlignPriceNumber(m.instId, price)
 }
@@ -292,4 +416,13 @@ func (m *CommonMarket) MinSize() decimal.Decimal {
 	return m.ex.instrumentMgr.MinSize(m.instId, m.orderBook.Buy1Price())
 }
 
+// 品种状态是否允许交易
+func (m *CommonMarket) tradable() bool {
+	return m.ex.instrumentMgr.Status(m.instId).Tradable()
+}
+
+func (m *CommonMarket) TickSize() decimal.Decimal {
+	return m.ex.instrumentMgr.TickSize(m.instId)
+}
+
 // #endregion
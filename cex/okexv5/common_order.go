@@ -17,8 +17,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aztecqt/dagger/util/logger"
-
 	"github.com/aztecqt/dagger/api/okexv5api"
 	"github.com/aztecqt/dagger/cex/common"
 	"github.com/aztecqt/dagger/util"
@@ -36,8 +34,10 @@ type CommonOrder struct {
 	refreshCount          int    // 刷新次数
 
 	// 子类提供
-	getPosSide func() string
-	tradeMode  func() string
+	getPosSide  func() string
+	tradeMode   func() string
+	actionQueue *common.ActionQueue
+	isSpot      bool
 
 	// 刷新
 	muRefresh        sync.Mutex
@@ -94,45 +94,80 @@ func (o *CommonOrder) create() {
 	o.posSide = o.getPosSide()
 
 	orderType := "limit"
-	if o.MakeOnly {
+	switch o.TimeInForce {
+	case common.TimeInForce_GTX:
 		orderType = "post_only"
+	case common.TimeInForce_IOC:
+		orderType = "ioc"
+	case common.TimeInForce_FOK:
+		orderType = "fok"
 	}
 
 	// 调用api
-	logger.LogInfo(o.LogPrefix, "creating [%s]", o.String())
-	resp, err := okexv5api.MakeOrder(
-		o.InstId,
-		o.CltOrderId.(string),
-		orderTag(),
-		side,
-		o.posSide,
-		orderType,
-		o.tradeMode(),
-		o.ReduceOnly,
-		o.Price,
-		o.Size)
+	o.LogFields.LogInfo(o.LogPrefix, "creating [%s]", o.String())
+	var resp *okexv5api.MakeorderRestResp
+	var err error
+	o.RecordPlace()
+	o.actionQueue.Do(common.ActionPriority_Place, func() {
+		if o.MarketOrder {
+			// 现货需要明确指定数量的计量币种，合约不需要
+			tgtCcy, sz := "", o.InstrumentMgr.FormatSize(o.InstId, o.Size)
+			if o.isSpot {
+				tgtCcy = util.ValueIf(o.QuoteSize.IsPositive(), "quote_ccy", "base_ccy")
+				if o.QuoteSize.IsPositive() {
+					sz = o.QuoteSize.Round(8).String()
+				}
+			}
+			resp, err = okexv5api.MakeMarketOrder(
+				o.InstId,
+				o.CltOrderId.(string),
+				orderTag(),
+				side,
+				o.posSide,
+				o.tradeMode(),
+				tgtCcy,
+				o.ReduceOnly,
+				sz)
+		} else {
+			resp, err = okexv5api.MakeOrder(
+				o.InstId,
+				o.CltOrderId.(string),
+				orderTag(),
+				side,
+				o.posSide,
+				orderType,
+				o.tradeMode(),
+				o.ReduceOnly,
+				o.InstrumentMgr.FormatPrice(o.InstId, o.Price),
+				o.InstrumentMgr.FormatSize(o.InstId, o.Size))
+		}
+	})
 	if err == nil {
 		if len(resp.Data) > 0 {
-			if resp.Data[0].SCode != "0" {
+			if resp.Data[0].SCode == "51016" && o.adoptDuplicated(side) {
+				// clientOrderId重复，且交易所上就是本订单
+			} else if resp.Data[0].SCode != "0" {
 				o.ErrMsg = fmt.Sprintf("code=%s, msg=%s", resp.Data[0].SCode, resp.Data[0].SMsg)
 				o.FatalError = true // 只有这种情况可以明确的认为订单已经失败了
-				logger.LogImportant(o.LogPrefix, "create order error: %s", o.ErrMsg)
+				o.LogFields.LogImportant(o.LogPrefix, "create order error: %s", o.ErrMsg)
 			} else if resp.Data[0].OrderId != "0" {
 				o.OrderId = util.String2Int64Panic(resp.Data[0].OrderId)
-				logger.LogInfo(o.LogPrefix, "create success, order id = %v", o.OrderId)
+				o.LogFields.LogInfo(o.LogPrefix, "create success, order id = %v", o.OrderId)
+				o.RecordAck()
 			} else {
+				// 订单可能已创建，由后续的刷新按clientId确认
 				o.ErrMsg = "create success but missing order id"
-				logger.LogPanic(o.LogPrefix, "create order error, invalid order id")
+				o.LogFields.LogStrict(o.LogPrefix, "create order error, invalid order id")
 			}
 		} else {
 			o.ErrMsg = "response error, no data"
 			o.FatalError = true // 这种情况应该是服务器还没准备好，订单可以尝试重新创建
-			logger.LogInfo(o.LogPrefix, "create order error, no data")
+			o.LogFields.LogInfo(o.LogPrefix, "create order error, no data")
 		}
 	} else {
 		// 网络错误不代表订单未创建成功
 		// 应该查询时返回“订单不存在”作为订单错误的触发条件
-		logger.LogImportant(o.LogPrefix, "create order with rest error: %s", err.Error())
+		o.LogFields.LogImportant(o.LogPrefix, "create order with rest error: %s", err.Error())
 	}
 }
 
@@ -146,8 +181,13 @@ func (o *CommonOrder) cancel() {
 			o.canceling = false
 		}()
 
-		logger.LogInfo(o.LogPrefix, "canceling [%s]", o.String())
-		resp, err := okexv5api.CancelOrder(o.InstId, o.CltOrderId.(string), 0)
+		o.LogFields.LogInfo(o.LogPrefix, "canceling [%s]", o.String())
+		o.RecordCancel()
+		var resp *okexv5api.CancelOrderRestResp
+		var err error
+		o.actionQueue.Do(common.ActionPriority_Cancel, func() {
+			resp, err = okexv5api.CancelOrder(o.InstId, o.CltOrderId.(string), 0)
+		})
 		if err == nil {
 			if resp.Data[0].SCode != "0" {
 				o.ErrMsg = fmt.Sprintf("code:%s, msg:%s", resp.Data[0].SCode, resp.Data[0].SMsg)
@@ -155,14 +195,14 @@ func (o *CommonOrder) cancel() {
 				if code == 51400 /*不存在*/ || code == 51401 /*已撤销*/ || code != 51402 /*已完成*/ {
 					o.refreshImm()
 				} else if code != 51410 /*撤销中*/ && code != 51405 /*没有未成交的订单*/ && code != 51404 /*不可撤单*/ {
-					logger.LogImportant(o.LogPrefix, "cancel order error: %s", o.ErrMsg)
+					o.LogFields.LogImportant(o.LogPrefix, "cancel order error: %s", o.ErrMsg)
 				}
 				time.Sleep(time.Second)
 			} else {
-				logger.LogInfo(o.LogPrefix, "cancel responsed")
+				o.LogFields.LogInfo(o.LogPrefix, "cancel responsed")
 			}
 		} else {
-			logger.LogImportant(o.LogPrefix, "cancel order with rest error: %s", err.Error())
+			o.LogFields.LogImportant(o.LogPrefix, "cancel order with rest error: %s", err.Error())
 			time.Sleep(time.Second)
 		}
 	}
@@ -198,8 +238,19 @@ func (o *CommonOrder) modify(newPrice, newSize decimal.Decimal) {
 		}
 
 		if newSize.IsPositive() || newPrice.IsPositive() {
-			logger.LogInfo(o.LogPrefix, "modifying [%s], newPrice=%v, newSize=%v", o.String(), newPrice, newSize)
-			resp, err := okexv5api.AmendOrder(o.InstId, o.CltOrderId.(string), NewAmendId(), 0, newPrice, newSize)
+			o.LogFields.LogInfo(o.LogPrefix, "modifying [%s], newPrice=%v, newSize=%v", o.String(), newPrice, newSize)
+			o.RecordAmend(newPrice, newSize)
+			var resp *okexv5api.AmendOrderRestResp
+			var err error
+			o.actionQueue.Do(common.ActionPriority_Amend, func() {
+				resp, err = okexv5api.AmendOrder(
+					o.InstId,
+					o.CltOrderId.(string),
+					NewAmendId(),
+					0,
+					util.ValueIf(newPrice.IsPositive(), o.InstrumentMgr.FormatPrice(o.InstId, newPrice), ""),
+					util.ValueIf(newSize.IsPositive(), o.InstrumentMgr.FormatSize(o.InstId, newSize), ""))
+			})
 			if err == nil {
 				if resp.Data[0].SCode != "0" {
 					o.ErrMsg = fmt.Sprintf("code:%s, msg:%s", resp.Data[0].SCode, resp.Data[0].SMsg)
@@ -207,14 +258,14 @@ func (o *CommonOrder) modify(newPrice, newSize decimal.Decimal) {
 					if code == 51509 /*已撤销*/ || code == 51510 /*已完成*/ || code == 51503 /*订单不存在*/ {
 						o.refreshImm()
 					} else {
-						logger.LogImportant(o.LogPrefix, "modify order error: %s", o.ErrMsg)
+						o.LogFields.LogImportant(o.LogPrefix, "modify order error: %s", o.ErrMsg)
 					}
 					time.Sleep(time.Second)
 				} else {
-					logger.LogInfo(o.LogPrefix, "modify responsed")
+					o.LogFields.LogInfo(o.LogPrefix, "modify responsed")
 				}
 			} else {
-				logger.LogImportant(o.LogPrefix, "modify order with rest error: %s", err.Error())
+				o.LogFields.LogImportant(o.LogPrefix, "modify order with rest error: %s", err.Error())
 				time.Sleep(time.Second)
 			}
 		}
@@ -234,15 +285,22 @@ func (o *CommonOrder) onSnapshot(os orderSnapshot) {
 		if o.OrderId == 0 {
 			o.OrderId = os.id
 		} else if o.OrderId > 0 && o.OrderId != os.id {
-			logger.LogPanic(o.LogPrefix, "order id not match! o=%s, new id=%d", o.String(), os.id)
+			// 不属于本订单的快照，丢弃
+			o.LogFields.LogStrict(o.LogPrefix, "order id not match! o=%s, new id=%d", o.String(), os.id)
+			return
 		}
 
 		if o.CltOrderId != os.clientId {
-			logger.LogPanic(o.LogPrefix, "order client-id not match! o=%s, new id=%s", o.String(), os.clientId)
+			o.LogFields.LogStrict(o.LogPrefix, "order client-id not match! o=%s, new id=%s", o.String(), os.clientId)
+			return
+		}
+		o.RecordAck()
+		if os.source == "ws" {
+			o.RecordWsUpdate(os.localTime)
 		}
 
 		// 刷新数据
-		logger.LogInfo(o.LogPrefix, "recv order snapshot:%s", os.String())
+		o.LogFields.LogInfo(o.LogPrefix, "recv order snapshot:%s", os.String())
 		if os.updateTime.UnixMilli() >= o.UpdateTime.UnixMilli() && os.filled.GreaterThanOrEqual(o.Filled) {
 			filledOld := o.Filled
 			avgPriceOld := o.AvgPrice
@@ -258,26 +316,30 @@ func (o *CommonOrder) onSnapshot(os orderSnapshot) {
 
 			price, amount := common.CalculateOrderDeal(filledOld, avgPriceOld, filledNew, avgPricNew)
 			if price.IsPositive() && amount.IsPositive() {
-				logger.LogInfo(o.LogPrefix, "order dealing, dir=%s, price=%v, amount=%v, time=%v", common.OrderDir2Str(o.Dir), price, amount, os.updateTime)
+				o.LogFields.LogInfo(o.LogPrefix, "order dealing, dir=%s, price=%v, amount=%v, time=%v", common.OrderDir2Str(o.Dir), price, amount, os.updateTime)
 				deal = common.Deal{O: o, Price: price, Amount: amount, LocalTime: os.localTime, UTime: os.updateTime}
 			}
 
 			// 回调外部
-			if deal.Price.IsPositive() && deal.Amount.IsPositive() && len(o.Observers) > 0 {
+			if deal.Price.IsPositive() && deal.Amount.IsPositive() {
+				o.RecordDeal(deal)
 				for _, obs := range o.Observers {
 					if obs != nil {
 						obs.OnDeal(deal)
 					}
 				}
+				common.PublishDeal(exchangeName, deal)
 			}
 
 			// 注意一定要等外部回调结束后，再置订单完成状态
 			finished := o.Status == okexv5api.OrderStatus_Canceled || o.Status == okexv5api.OrderStatus_Filled
 			if !o.Finished && finished {
 				o.Finished = finished
-				logger.LogInfo(o.LogPrefix, "order finished")
+				o.LogFields.LogInfo(o.LogPrefix, "order finished")
+				o.RecordFinish()
+				common.PublishOrderUpdate(exchangeName, o, o.UpdateTime)
 			} else if o.Finished && !finished {
-				logger.LogImportant(o.LogPrefix, "order already finished but try set to unfinished? impossible!")
+				o.LogFields.LogImportant(o.LogPrefix, "order already finished but try set to unfinished? impossible!")
 			}
 
 			o.refreshCount++
@@ -291,10 +353,10 @@ func (o *CommonOrder) refreshImm() {
 }
 
 func (o *CommonOrder) doRestRefresh() {
-	logger.LogInfo(o.LogPrefix, "geting order info from rest...")
+	o.LogFields.LogInfo(o.LogPrefix, "geting order info from rest...")
 	resp, err := okexv5api.GetOrderInfo(o.InstId, 0, o.CltOrderId.(string))
 	b, _ := json.Marshal(resp)
-	logger.LogInfo(o.LogPrefix, "getted order info from rest, resp=%s", string(b))
+	o.LogFields.LogInfo(o.LogPrefix, "getted order info from rest, resp=%s", string(b))
 
 	if err == nil {
 		if resp.Code == "0" {
@@ -317,7 +379,8 @@ func (o *CommonOrder) doRestRefresh() {
 }
 
 func (o *CommonOrder) update() {
-	defer logger.LogInfo(o.LogPrefix, "update exit")
+	defer o.LogFields.LogInfo(o.LogPrefix, "update exit")
+	defer o.RecordFinish()
 
 	// go o.create()
 	o.create()
@@ -27,19 +27,50 @@ func (o *ContractOrder) Init(
 	trader *FutureTrader,
 	price, amount decimal.Decimal,
 	dir common.OrderDir,
-	makeOnly, reduceOnly bool,
+	tif common.TimeInForce,
+	reduceOnly bool,
 	purpose string) bool {
 	o.trader = trader
 	o.CltOrderId = NewClientOrderId(o.Purpose)
-	if o.CommonOrder.Init(trader, trader.exchange.instrumentMgr, trader.market.instId, price, amount, dir, makeOnly, reduceOnly, purpose) {
+	o.LogFields.Exchange = exchangeName
+	if o.CommonOrder.InitTIF(trader, trader.exchange.instrumentMgr, trader.market.instId, price, amount, dir, tif, reduceOnly, purpose) {
 		o.CommonOrder.getPosSide = o.getPosSide
 		o.CommonOrder.tradeMode = o.tradeMode
+		o.CommonOrder.actionQueue = trader.exchange.actionQueue
 		return true
 	} else {
 		return false
 	}
 }
 
+// 市价单
+func (o *ContractOrder) InitMarket(
+	trader *FutureTrader,
+	guardPrice, amount decimal.Decimal,
+	dir common.OrderDir,
+	reduceOnly bool,
+	purpose string) bool {
+	o.trader = trader
+	o.CltOrderId = NewClientOrderId(purpose)
+	o.LogFields.Exchange = exchangeName
+	if o.CommonOrder.InitMarket(trader, trader.exchange.instrumentMgr, trader.market.instId, guardPrice, amount, decimal.Zero, dir, reduceOnly, purpose) {
+		o.CommonOrder.getPosSide = o.getPosSide
+		o.CommonOrder.tradeMode = o.tradeMode
+		o.CommonOrder.actionQueue = trader.exchange.actionQueue
+		return true
+	} else {
+		return false
+	}
+}
+
+// 接管遗留订单，见reconcile.go
+func (o *ContractOrder) adopt(trader *FutureTrader, d okexv5api.OrderResp) {
+	o.trader = trader
+	o.CommonOrder.adopt(trader, trader.exchange.instrumentMgr, d, trader.exchange.actionQueue)
+	o.CommonOrder.getPosSide = o.getPosSide
+	o.CommonOrder.tradeMode = o.tradeMode
+}
+
 // #region 覆盖CommonOrder
 func (o *ContractOrder) getPosSide() string {
 	if o.trader.exchange.excfg.PositionMode == okexv5api.PositonMode_LS {
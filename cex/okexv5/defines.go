@@ -13,9 +13,11 @@ package okexv5
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/backtest"
 	"github.com/aztecqt/dagger/util"
 
 	"github.com/shopspring/decimal"
@@ -26,6 +28,10 @@ type ExchangeConfig struct {
 	// 是否从ticker来生成Depth数据。true则不订阅depth，而是ticker
 	DepthFromTicker bool `json:"depth_from_ticker"`
 
+	// 是否订阅全量深度(books频道，400档增量推送)。false则订阅books5
+	// 全量深度会校验seqId连续性和checksum，发现缺口或校验失败时自动重新订阅获取快照
+	FullDepth bool `json:"full_depth"`
+
 	// 是否通过rest拉取ticker。是的话，由exchange统一拉取所有ticker，否则各个交易对自行订阅
 	TickerFromRest bool `json:"ticker_from_rest"`
 
@@ -44,6 +50,12 @@ type ExchangeConfig struct {
 	// 是否订阅资金费率
 	SubscribeFundingFeeRate bool `json:"sub_ffr"`
 
+	// 是否订阅持仓总量
+	SubscribeOpenInterest bool `json:"sub_oi"`
+
+	// 是否订阅合约对应的指数价格
+	SubscribeIndexPrice bool `json:"sub_index_price"`
+
 	// 账号模式。见相应枚举
 	AccLevel okexv5api.AccLevel `json:"acc_level"`
 
@@ -55,19 +67,80 @@ type ExchangeConfig struct {
 	// 由于两者都可以兼容，所以在配置文件里不做指定，而是记录交易所发过来的值
 	PositionMode okexv5api.PositionMode
 
+	// 下单/撤单/改单的频率预算(次/秒)和突发容量。0使用默认值，负数表示不限制
+	OrderRateLimit int `json:"order_rate_limit"`
+	OrderRateBurst int `json:"order_rate_burst"`
+
+	// 仓位核对间隔(默认60秒)，以及锁定交易器的差异比例(0表示不锁定)，见position_check.go
+	PositionCheckIntervalSec int             `json:"position_check_interval_sec"`
+	PositionLockRatio        decimal.Decimal `json:"position_lock_ratio"`
+
+	// 余额核对间隔(默认60秒)、临时权益的过期时间(默认10秒)，以及连续几次核对出差异时报告(默认3次)，见balance_check.go
+	BalanceCheckIntervalSec int `json:"balance_check_interval_sec"`
+	TempRightsExpireSec     int `json:"temp_rights_expire_sec"`
+	BalanceMismatchTimes    int `json:"balance_mismatch_times"`
+
+	// clientOrderId前缀和序号的持久化文件，空表示不持久化，见clientid.go
+	ClientIdSeqFile string `json:"client_id_seq_file"`
+
+	// 启动时(交易器创建之前)按条件撤销挂单，配置后取代默认的按策略tag撤销，见CloseAllOrders
+	StartupCancel *StartupCancelConfig `json:"startup_cancel"`
+
+	// 启动时对之前的进程遗留的挂单(同一策略tag、非本进程创建)的处理方式，见reconcile.go
+	OrphanOrderPolicy OrphanPolicy `json:"orphan_order_policy"`
+
+	// 代理，如socks5://127.0.0.1:1080，只作用于okx的地址。空表示使用全局代理，见network.SetProxy
+	Proxy string `json:"proxy"`
+
+	// websocket请求permessage-deflate压缩，减少完整深度的带宽，代价是少量cpu
+	WsCompression bool `json:"ws_compression"`
+
+	// 模拟交易。非空时照常订阅行情，但不登录账户，订单在本地撮合，使用虚拟余额
+	PaperTrading *backtest.PaperConfig `json:"paper_trading"`
+
 	// 费率观察器设置
 	FundingFeeObserver struct {
 		UsdtSwap bool `json:"usdt_swap"`
 	} `json:"ff_obv"`
 }
 
-func newExchangeConfig() ExchangeConfig {
+// 启动撤单的筛选条件，多个条件同时满足才撤销
+type StartupCancelConfig struct {
+	All            bool   `json:"all"`              // 撤销账户下的全部挂单，忽略其他条件(包括手工下的单)
+	Tag            string `json:"tag"`              // 订单tag(策略id)，空表示本策略的tag
+	ClientIdPrefix string `json:"client_id_prefix"` // clientOrderId前缀，空表示不限
+}
+
+func (c StartupCancelConfig) match(d okexv5api.OrderResp) bool {
+	if c.All {
+		return true
+	}
+
+	tag := util.ValueIf(len(c.Tag) > 0, c.Tag, orderTag())
+	return d.Tag == tag && strings.HasPrefix(d.ClientOrderId, c.ClientIdPrefix)
+}
+
+const (
+	defaultOrderRateLimit = 30
+	defaultOrderRateBurst = 10
+)
+
+// 订单操作的频率预算，未配置的项使用默认值
+func (c ExchangeConfig) orderRate() (float64, int) {
+	limit := util.ValueIf(c.OrderRateLimit == 0, defaultOrderRateLimit, c.OrderRateLimit)
+	burst := util.ValueIf(c.OrderRateBurst == 0, defaultOrderRateBurst, c.OrderRateBurst)
+	return float64(limit), burst
+}
+
+// 默认配置，可在此基础上修改后传给Init
+func NewExchangeConfig() ExchangeConfig {
 	cfg := ExchangeConfig{
 		DepthFromTicker:   true,
 		TickerFromRest:    false,
 		AccLevel:          okexv5api.AccLevel_MultiCcy,
 		SpotTradeMode:     "cash",
 		ContractTradeMode: "cross",
+		OrphanOrderPolicy: OrphanPolicy_Cancel,
 	}
 	return cfg
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/aztecqt/dagger/api/okexv5api/cachedok"
 	"github.com/aztecqt/dagger/cex/common"
 	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/network"
 	"github.com/shopspring/decimal"
 )
 
@@ -69,15 +70,24 @@ type Exchange struct {
 	// 交易品种
 	instrumentMgr *common.InstrumentMgr
 
+	// 订单操作队列
+	actionQueue *common.ActionQueue
+
 	// 用户权益，主要针对现货，系统会自主计算币种余额，并跟交易所对齐
 	balanceMgr *common.BalanceMgr
 
 	// 交易所返回的账号信息，这里主要是为了取统一账户的几个关键数据
 	accountBal okexv5api.AccountBalanceResp
 
+	// 余额核对，ccy->连续出现差异的次数
+	balanceMismatches  map[string]int
+	balanceMismatchFns []func(m BalanceMismatch)
+	muBalanceMismatch  sync.Mutex
+
 	// 仓位
 	// instId->pos
 	ctPositions       map[string]*common.PositionImpl
+	positionRisks     map[string]map[string]positionRisk // instId-posSide-强平价格等
 	muPosition        sync.RWMutex
 	positionInstTypes map[string]int
 
@@ -103,14 +113,28 @@ type Exchange struct {
 	muTickerCallback          sync.Mutex
 	tickerRestInstType        map[string]int
 
+	// 全市场爆仓推送的回调(需开启SubscribeLiquidationOrders)
+	liquidationFns   []func(l common.Liquidation)
+	muLiquidationFns sync.Mutex
+
+	// 按指数共享的指数价格订阅(需开启SubscribeIndexPrice)
+	indexSubs   map[string] /*index*/ *indexPriceSub
+	muIndexSubs sync.Mutex
+
 	// 从rest拉取到的ticker的缓存
 	restTickers   map[string]okexv5api.TickerResp
 	muRestTickers sync.Mutex
+
+	// 模拟交易(需配置PaperTrading)
+	paper *paperTrading
+
+	// 行情、交易器的本地时间戳(见SetClock)
+	clock common.Clock
 }
 
 func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb func(e error)) {
 	logger.LogImportant(logPrefix, "exchange starting...")
-	e.excfg = newExchangeConfig()
+	e.excfg = NewExchangeConfig()
 	if excfg != nil {
 		e.excfg = *excfg
 	}
@@ -126,8 +150,14 @@ func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb fun
 	e.spotTradersSlice = make([]common.SpotTrader, 0)
 
 	e.balanceMgr = common.NewBalanceMgr(false)
+	e.balanceMgr.SetExchangeName(exchangeName)
 	e.instrumentMgr = common.NewInstrumentMgr(logPrefix)
+	e.instrumentMgr.SetTrimZeros(true)
+	orderRate, orderBurst := e.excfg.orderRate()
+	e.actionQueue = common.NewActionQueue(exchangeName, orderRate, orderBurst)
+	e.balanceMismatches = make(map[string]int)
 	e.ctPositions = make(map[string]*common.PositionImpl)
+	e.positionRisks = make(map[string]map[string]positionRisk)
 	e.positionInstTypes = make(map[string]int)
 	e.orderSnapshotFns = make(map[string]OnOrderSnapshotFn)
 	e.contractObservers = make(map[string]*ContractObserver)
@@ -135,21 +165,45 @@ func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb fun
 	e.tickerCallbacksOfInstType = make(map[string][]func(tks []okexv5api.TickerResp))
 	e.tickerRestInstType = make(map[string]int)
 	e.restTickers = make(map[string]okexv5api.TickerResp)
+	e.indexSubs = make(map[string]*indexPriceSub)
 	e.maxAvailable = make(map[string]okexv5api.MaxAvailableSizeResp)
 
 	// 初始化api
 	logger.LogImportant(logPrefix, "init api...")
+	if len(e.excfg.Proxy) > 0 {
+		if err := network.SetProxyForUrls(e.excfg.Proxy, okexv5api.Urls()...); err != nil {
+			logger.LogPanic(logPrefix, "invalid proxy %s: %s", e.excfg.Proxy, err.Error())
+		}
+	}
 	okexv5api.Init(key, secret, pass)
 	okexv5api.ErrorCallback = ecb
 
+	// 模拟交易不使用真实账户
+	hasKey := okexv5api.HasKey()
+	if e.excfg.PaperTrading != nil {
+		logger.LogImportant(logPrefix, "paper trading enabled, orders will be simulated locally")
+		e.paper = newPaperTrading(*e.excfg.PaperTrading)
+		hasKey = false
+	}
+
 	// 获取所有交易对列表
 	logger.LogImportant(logPrefix, "fetching instruments...")
 	e.refreshInstruments()
 
-	if okexv5api.HasKey() {
-		// 撤销所有订单
-		logger.LogImportant(logPrefix, "closing pending orders...")
-		e.CloseAllOrders()
+	if hasKey {
+		// 接着上次的clientOrderId序号
+		initClientIdSeq(e.excfg.ClientIdSeqFile)
+
+		// 撤销所有订单。不撤销时由各交易器处理遗留订单，见reconcile.go
+		if e.excfg.StartupCancel != nil {
+			logger.LogImportant(logPrefix, "closing pending orders, filter: %+v", *e.excfg.StartupCancel)
+			e.closeOrders(e.excfg.StartupCancel.match)
+		} else if e.excfg.OrphanOrderPolicy == OrphanPolicy_Cancel || len(e.excfg.OrphanOrderPolicy) == 0 {
+			logger.LogImportant(logPrefix, "closing pending orders...")
+			e.CloseAllOrders()
+		} else {
+			logger.LogImportant(logPrefix, "keep pending orders, orphan order policy: %s", e.excfg.OrphanOrderPolicy)
+		}
 
 		// 检查账户配置
 		logger.LogImportant(logPrefix, "checking account config...")
@@ -159,6 +213,7 @@ func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb fun
 	// 启动ws
 	logger.LogImportant(logPrefix, "starting websocket...")
 	e.ws = new(okexv5api.WsClient)
+	e.ws.SetCompression(e.excfg.WsCompression)
 	e.ws.Start()
 
 	// 启动rest拉取ticker
@@ -167,7 +222,7 @@ func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb fun
 		go e.updateTickersByRest()
 	}
 
-	if okexv5api.HasKey() {
+	if hasKey {
 		// 登录
 		e.ws.Login()
 
@@ -199,6 +254,15 @@ func (e *Exchange) Init(key, secret, pass string, excfg *ExchangeConfig, ecb fun
 	logger.LogImportant(logPrefix, "exchange started")
 }
 
+// 行情、交易器打本地时间戳用的时钟，默认为本地时间。回放行情时可注入回放时钟，需在Init之前调用
+func (e *Exchange) SetClock(c common.Clock) {
+	e.clock = c
+}
+
+func (e *Exchange) now() time.Time {
+	return common.ClockOrReal(e.clock).Now()
+}
+
 // #region 实现common.CEx接口
 func (e *Exchange) Name() string {
 	return exchangeName
@@ -221,6 +285,10 @@ func (e *Exchange) GetInstrumentManager() *common.InstrumentMgr {
 }
 
 func (e *Exchange) GetUniAccRisk() common.UniAccRisk {
+	if e.paper != nil {
+		return common.UniAccRisk{Level: common.UniAccRiskLevel_Safe}
+	}
+
 	// 根据全仓账户的维持保证金率来计算
 	// 目前三档的标准是写死的。将来有需求，可以改为配置
 	risk := common.UniAccRisk{
@@ -287,6 +355,10 @@ func (e *Exchange) UseFutureTrader(symbol string, contractType string, lever int
 		e.positionInstTypes["SWAP"] = 1
 	}
 
+	if e.paper != nil {
+		return e.usePaperFutureTrader(symbol, contractType, lever)
+	}
+
 	instId := CCyCttypeToInstId(symbol, contractType)
 	t, ok := e.futureTraders[instId]
 	if ok {
@@ -349,6 +421,10 @@ func (e *Exchange) UseSpotMarket(baseCcy string, quoteCcy string) common.SpotMar
 }
 
 func (e *Exchange) UseSpotTrader(baseCcy string, quoteCcy string) common.SpotTrader {
+	if e.paper != nil {
+		return e.usePaperSpotTrader(baseCcy, quoteCcy)
+	}
+
 	instId := SpotTypeToInstId(baseCcy, quoteCcy)
 	t, ok := e.spotTraders[instId]
 	if ok {
@@ -393,6 +469,10 @@ func (e *Exchange) GetFinance() common.Finance {
 
 // 获取全部合约仓位
 func (e *Exchange) GetAllPositions() []common.Position {
+	if e.paper != nil {
+		return e.paper.acc.Positions()
+	}
+
 	e.muPosition.Lock()
 	defer e.muPosition.Unlock()
 	positions := make([]common.Position, 0, len(e.ctPositions))
@@ -404,6 +484,10 @@ func (e *Exchange) GetAllPositions() []common.Position {
 
 // 获取全部资产
 func (e *Exchange) GetAllBalances() []common.Balance {
+	if e.paper != nil {
+		return e.paper.acc.Balances()
+	}
+
 	balImpls := e.balanceMgr.GetAllBalances()
 	bals := make([]common.Balance, 0, len(balImpls))
 	for _, bi := range balImpls {
@@ -472,6 +556,10 @@ func (e *Exchange) GetFutureDealHistory(symbol, contractType string, t0, t1 time
 }
 
 func (e *Exchange) GetDealHistory(instId string, t0, t1 time.Time) []common.DealHistory {
+	if e.paper != nil {
+		return e.paper.acc.DealHistory(instId, t0, t1)
+	}
+
 	totalSec := float64(t1.Unix() - t0.Unix())
 	deals := make([]common.DealHistory, 0)
 	for {
@@ -558,15 +646,37 @@ func (e *Exchange) Exit() {
 	exchangeReady = false
 
 	// 撤销所有订单
-	e.CloseAllOrders()
+	if e.paper != nil {
+		e.paper.exit()
+	} else {
+		e.CloseAllOrders()
+	}
+	e.actionQueue.Stop()
 }
 
 // #endregion 实现common.CEx接口
 
+// 实现common.HealthChecker
+func (e *Exchange) Ready() bool {
+	return e.UnreadyReason() == ""
+}
+
+func (e *Exchange) UnreadyReason() string {
+	if !exchangeReady {
+		return "exchange not ready"
+	} else if e.paper == nil && okexv5api.HasKey() && !e.ws.PrivateConnected() {
+		return "private websocket " + e.ws.PrivateState().String()
+	} else {
+		return ""
+	}
+}
+
 // #region account
 func (e *Exchange) updateAccount(wg *sync.WaitGroup) {
 	// 订阅Account，20秒收不到数据则超时重连
 	timeout := time.NewTicker(time.Second * 20)
+	checkSec := util.ValueIf(e.excfg.BalanceCheckIntervalSec > 0, e.excfg.BalanceCheckIntervalSec, 60)
+	tRest := time.NewTicker(time.Second * time.Duration(checkSec)) // 定时rest核对
 	accOk := false
 	s := e.ws.SubscribeAccountBalance(func(resp interface{}) {
 		r := resp.(okexv5api.AccountBalanceWsResp)
@@ -597,9 +707,13 @@ func (e *Exchange) updateAccount(wg *sync.WaitGroup) {
 	})
 
 	for {
-		<-timeout.C
-		logger.LogInfo(logPrefix, "account time out, re-subscribe it")
-		s.Reset()
+		select {
+		case <-timeout.C:
+			logger.LogInfo(logPrefix, "account time out, re-subscribe it")
+			s.Reset()
+		case <-tRest.C:
+			e.checkBalances()
+		}
 	}
 }
 
@@ -625,7 +739,8 @@ func (e *Exchange) findPosition(instId string) *common.PositionImpl {
 func (e *Exchange) updatePosition(wg *sync.WaitGroup) {
 	index := 0
 	timeout := time.NewTicker(time.Second * 20) // 20秒收不到仓位，重新订阅
-	tRest := time.NewTicker(time.Minute)        // 1分钟固定rest更新
+	checkSec := util.ValueIf(e.excfg.PositionCheckIntervalSec > 0, e.excfg.PositionCheckIntervalSec, 60)
+	tRest := time.NewTicker(time.Second * time.Duration(checkSec)) // 定时rest核对
 	posOk := false
 
 	// 订阅websocket
@@ -641,8 +756,8 @@ func (e *Exchange) updatePosition(wg *sync.WaitGroup) {
 			if index == 1 {
 				e.muPosition.RLock()
 				for _, p := range e.ctPositions {
-					p.RefreshLong(decimal.Zero, decimal.Zero, time.Now())
-					p.RefreshShort(decimal.Zero, decimal.Zero, time.Now())
+					p.RefreshLong(decimal.Zero, decimal.Zero, e.now())
+					p.RefreshShort(decimal.Zero, decimal.Zero, e.now())
 				}
 				e.muPosition.RUnlock()
 			}
@@ -662,16 +777,7 @@ func (e *Exchange) updatePosition(wg *sync.WaitGroup) {
 			logger.LogInfo(logPrefix, "position time out, re-subscribe it")
 			s.Reset()
 		case <-tRest.C:
-			// 目前只取永续合约的仓位
-			if resp, err := okexv5api.GetPositions("SWAP", ""); err == nil {
-				if resp.Code == "0" {
-					e.processPositionUnits(resp.Data)
-				} else {
-					logger.LogImportant(logPrefix, "get position from rest failed: code=%v, msg=%v", resp.Code, resp.Msg)
-				}
-			} else {
-				logger.LogImportant(logPrefix, "get positions from rest failed: %s", err.Error())
-			}
+			e.checkPositions()
 		}
 
 	}
@@ -699,10 +805,55 @@ func (e *Exchange) processPositionUnits(posUnits []okexv5api.PositionUnit) {
 			} else if d.PosSide == "short" {
 				position.RefreshShort(size, avgPx, time)
 			}
+			e.refreshPositionRisk(d, size)
 		}
 	}
 }
 
+// 仓位的强平价格和保证金，用于FutureTrader.MarginInfo
+type positionRisk struct {
+	size  decimal.Decimal
+	liqPx decimal.Decimal
+	mmr   decimal.Decimal
+}
+
+func (e *Exchange) refreshPositionRisk(d okexv5api.PositionUnit, size decimal.Decimal) {
+	e.muPosition.Lock()
+	defer e.muPosition.Unlock()
+	risks, ok := e.positionRisks[d.InstId]
+	if !ok {
+		risks = make(map[string]positionRisk)
+		e.positionRisks[d.InstId] = risks
+	}
+
+	if size.IsZero() {
+		delete(risks, d.PosSide)
+	} else {
+		risks[d.PosSide] = positionRisk{
+			size:  size,
+			liqPx: util.String2DecimalPanicUnless(d.LiqPx, ""),
+			mmr:   util.String2DecimalPanicUnless(d.Mmr, ""),
+		}
+	}
+}
+
+// 某品种离标记价格最近的强平价格，及该品种的维持保证金
+func (e *Exchange) positionLiqPx(instId string, markPx decimal.Decimal) (liqPx, mmr decimal.Decimal) {
+	e.muPosition.RLock()
+	defer e.muPosition.RUnlock()
+	for _, r := range e.positionRisks[instId] {
+		mmr = mmr.Add(r.mmr)
+		if !r.liqPx.IsPositive() {
+			continue
+		}
+
+		if liqPx.IsZero() || r.liqPx.Sub(markPx).Abs().LessThan(liqPx.Sub(markPx).Abs()) {
+			liqPx = r.liqPx
+		}
+	}
+	return
+}
+
 // #endregion position
 
 // #region orders
@@ -835,6 +986,7 @@ func (e *Exchange) updateLiquidationOrders() {
 					m.onLiquidationOrder(lod.BrokenPrice, lod.Size, util.ValueIf(lod.Side == "buy", common.OrderDir_Buy, common.OrderDir_Sell))
 				}
 			}
+			e.notifyLiquidations(v.InstId, v.Details)
 		}
 	})
 
@@ -849,6 +1001,48 @@ func (e *Exchange) updateLiquidationOrders() {
 	}
 }
 
+// 订阅全市场永续合约的爆仓推送(已统一为common.Liquidation)，需开启SubscribeLiquidationOrders
+func (e *Exchange) SubscribeLiquidations(fn func(l common.Liquidation)) {
+	e.muLiquidationFns.Lock()
+	defer e.muLiquidationFns.Unlock()
+	e.liquidationFns = append(e.liquidationFns, fn)
+}
+
+func (e *Exchange) notifyLiquidations(instId string, details []okexv5api.LiquidationOrderDetial) {
+	e.muLiquidationFns.Lock()
+	fns := e.liquidationFns
+	e.muLiquidationFns.Unlock()
+	if len(fns) == 0 {
+		return
+	}
+
+	ins := e.instrumentMgr.Get(instId)
+	if ins == nil {
+		return
+	}
+
+	for _, lod := range details {
+		// 推送中只有破产价格，以此折算金额
+		// 币本位合约面值以usd计价，U本位合约面值以币计价
+		l := common.Liquidation{
+			Exchange: exchangeName,
+			InstId:   instId,
+			Time:     lod.Time,
+			Price:    lod.BrokenPrice,
+			Size:     lod.Size,
+			Dir:      util.ValueIf(lod.Side == "buy", common.OrderDir_Buy, common.OrderDir_Sell),
+		}
+		l.Notional = lod.Size.Mul(ins.CtVal)
+		if ins.CtValCcy != "usd" {
+			l.Notional = l.Notional.Mul(lod.BrokenPrice)
+		}
+
+		for _, fn := range fns {
+			fn(l)
+		}
+	}
+}
+
 func (e *Exchange) processInstruments(instType string, isInit bool) {
 	resp, err := okexv5api.GetInstruments(instType)
 	if err == nil {
@@ -866,6 +1060,13 @@ func (e *Exchange) processInstruments(instType string, isInit bool) {
 			ins.TickSize = util.String2DecimalPanic(data.TickSize)
 			ins.LotSize = util.String2DecimalPanic(data.LotSz)
 			ins.MinSize = util.String2DecimalPanic(data.MinSz)
+			if lt, ok := util.String2Int64(data.ListTime); ok && lt > 0 {
+				ins.ListTime = time.UnixMilli(lt)
+			}
+			ins.Status = instrumentStatus(data.State, data.OpenType, ins.ListTime)
+			if prev := e.instrumentMgr.Get(instId); prev != nil && prev.Status != ins.Status {
+				logger.LogImportant(logPrefix, "instrument %s status changed: %s -> %s", instId, prev.Status.String(), ins.Status.String())
+			}
 
 			if strings.Contains(instId, "USD-SWAP") {
 				// usd合约
@@ -904,6 +1105,21 @@ func (e *Exchange) findOrGetInstrument(instType, instId string) *common.Instrume
 	}
 }
 
+// 为新币上线做准备：确保品种信息已加载，并登记上线时间
+// listTime为零值时使用交易所提供的上线时间
+func (e *Exchange) PrepareSpotListing(baseCcy, quoteCcy string, listTime time.Time) bool {
+	instId := SpotTypeToInstId(baseCcy, quoteCcy)
+	inst := e.findOrGetInstrument("SPOT", instId)
+	if inst == nil {
+		return false
+	}
+
+	if listTime.IsZero() {
+		return true
+	}
+	return e.instrumentMgr.SetListTime(instId, listTime)
+}
+
 func (e *Exchange) checkAccountConfig() {
 	resp, err := okexv5api.GetAccountConfig()
 	if err == nil {
@@ -941,13 +1157,18 @@ func (e *Exchange) checkAccountConfig() {
 }
 
 func (e *Exchange) CloseAllOrders() {
+	e.closeOrders(func(d okexv5api.OrderResp) bool { return d.Tag == orderTag() })
+}
+
+// 撤销符合条件的挂单，直到全部撤销完毕
+func (e *Exchange) closeOrders(filter func(d okexv5api.OrderResp) bool) {
 	for i := 0; ; i++ {
 		resp, err := okexv5api.GetPendingOrders("")
 		if err == nil {
 			if resp.Code == "0" {
 				orders := make([]okexv5api.OrderResp, 0)
 				for _, d := range resp.Data {
-					if d.Tag == orderTag() {
+					if filter(d) {
 						orders = append(orders, d)
 					}
 				}
@@ -957,9 +1178,9 @@ func (e *Exchange) CloseAllOrders() {
 					logger.LogImportant(logPrefix, "all pending orders closed")
 					break
 				} else {
-					// 撤销这些订单
+					// 撤销这些订单，批量撤单每次最多20个
 					cancelReqs := make([]okexv5api.CancelBatchOrderRestReq, 0, 20)
-					for _, d := range orders {
+					for _, d := range orders[:min(len(orders), 20)] {
 						req := okexv5api.CancelBatchOrderRestReq{
 							InstId:  d.InstId,
 							OrderId: d.OrderId,
@@ -986,6 +1207,27 @@ func (e *Exchange) CloseAllOrders() {
 	}
 }
 
+// 开启断线撤单。使用交易所原生的倒计时撤单(cancel-all-after)，同时本地看门狗兜底
+// 策略主循环需定时调用返回值的Heartbeat
+func (e *Exchange) EnableDeadManSwitch(timeout time.Duration) *common.DeadManSwitch {
+	sec := int(timeout.Seconds())
+	sec = util.ValueIf(sec < 10, 10, util.ValueIf(sec > 120, 120, sec)) // ok只支持10~120秒
+	timeout = time.Second * time.Duration(sec)
+
+	d := new(common.DeadManSwitch)
+	d.Init(
+		exchangeName,
+		timeout,
+		func(t time.Duration) bool {
+			resp, err := okexv5api.CancelAllAfter(int(t.Seconds()))
+			return err == nil && resp.Code == "0"
+		},
+		e.CloseAllOrders,
+		func() bool { return exchangeReady && e.ws.PrivateConnected() })
+	d.Go()
+	return d
+}
+
 func (e *Exchange) getMaxAvailable(instId string) (okexv5api.MaxAvailableSizeResp, bool) {
 	// usdt合约只查询一次，统一按btc来
 	if strings.Contains(instId, "USDT-SWAP") {
@@ -15,7 +15,9 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aztecqt/dagger/cex/common"
@@ -36,6 +38,19 @@ type FutureMarket struct {
 	nextFundingRate decimal.Decimal
 	fundingTime     time.Time
 	nextFundingTime time.Time
+	maxFundingRate  decimal.Decimal
+	minFundingRate  decimal.Decimal
+
+	// 指数价格，未开启SubscribeIndexPrice时为nil
+	indexSub *indexPriceSub
+
+	// 以okx的实时预估费率为采样，预测本期结算费率
+	fundingPredictor common.FundingPredictor
+
+	// 持仓总量，历史按分钟保留
+	muOI         sync.Mutex
+	openInterest common.OpenInterest
+	oiHistory    []common.OpenInterest
 
 	// 市场爆仓回调
 	liqObserverSet *hashset.Set
@@ -55,6 +70,8 @@ func (m *FutureMarket) Init(ex *Exchange, inst common.Instruments, depthFromTick
 	m.liqObserverSet = hashset.New()
 	m.liqObservers = nil
 
+	m.fundingPredictor.Init(common.FundingSample_Estimate)
+
 	// 执行频道订阅
 	m.subscribe(inst.Id)
 	logger.LogImportant(logPrefix, "future market(%s) inited", inst.Id)
@@ -63,6 +80,10 @@ func (m *FutureMarket) Init(ex *Exchange, inst common.Instruments, depthFromTick
 func (m *FutureMarket) Uninit() {
 	// 反订阅所有频道
 	m.unsubscribe(m.instId)
+	if m.indexSub != nil {
+		m.ex.unsubscribeIndexPrice(m.indexSub.index)
+		m.indexSub = nil
+	}
 	logger.LogImportant(logPrefix, "future market(%s) uninited", m.instId)
 }
 
@@ -89,6 +110,10 @@ func (m *FutureMarket) subscribe(instID string) {
 		m.markpriceOK = true
 	}
 
+	if m.ex.excfg.SubscribeIndexPrice {
+		m.indexSub = m.ex.subscribeIndexPrice(indexOfInstId(instID))
+	}
+
 	// 订阅限价(20秒超时，10秒触发Rest，服务器不保证推送频率)
 	if m.ex.excfg.SubscribePriceLimit {
 		go func() {
@@ -123,6 +148,10 @@ func (m *FutureMarket) subscribe(instID string) {
 		m.priceLimitOK = true
 	}
 
+	if m.ex.excfg.SubscribeOpenInterest {
+		go m.subscribeOpenInterest(instID)
+	}
+
 	if m.ex.excfg.SubscribeFundingFeeRate {
 		if strings.Contains(instID, "SWAP") {
 			// 订阅资金费率(180秒超时)
@@ -148,6 +177,76 @@ func (m *FutureMarket) subscribe(instID string) {
 	}
 }
 
+// 合约对应的指数名，如BTC-USDT-SWAP、BTC-USDT-240628对应BTC-USDT
+func indexOfInstId(instID string) string {
+	ss := strings.Split(instID, "-")
+	if len(ss) < 2 {
+		return instID
+	}
+	return ss[0] + "-" + ss[1]
+}
+
+const maxOpenInterestHistory = 60 * 24 * 3
+
+func (m *FutureMarket) subscribeOpenInterest(instID string) {
+	// 订阅持仓总量(60秒超时重新订阅，30秒没有推送则rest拉取。持仓不变时服务器不推送)
+	timeoutReSub := time.NewTicker(time.Second * 60)
+	timeoutREST := time.NewTicker(time.Second * 30)
+	defer timeoutReSub.Stop()
+	defer timeoutREST.Stop()
+	s := m.ws.SubscribeOpenInterest(instID, func(resp interface{}) {
+		r := resp.(okexv5api.OpenInterestWsResp)
+		if len(r.Data) > 0 {
+			m.onOpenInterest(r.Data[0])
+		}
+		timeoutReSub.Reset(time.Second * 60)
+		timeoutREST.Reset(time.Second * 30)
+	})
+
+	for {
+		select {
+		case <-timeoutREST.C:
+			resp, err := okexv5api.GetMarketHolding("", instID)
+			if err == nil && resp.Code == "0" && len(resp.Data) > 0 {
+				m.onOpenInterest(resp.Data[0])
+				timeoutReSub.Reset(time.Second * 60)
+			}
+		case <-timeoutReSub.C:
+			s.Reset()
+		case <-m.chStop:
+			m.ws.UnsubscribeOpenInterest(instID)
+			return
+		}
+	}
+}
+
+func (m *FutureMarket) onOpenInterest(h okexv5api.MarketHolding) {
+	oi := common.OpenInterest{
+		Time:     m.ex.now(),
+		Size:     h.Holding,
+		SizeCcy:  h.HoldingInCcy,
+		ValueUsd: h.HoldingInUsd,
+	}
+	if t, ok := util.ConvetUnix13StrToTime(h.TimeStamp); ok && len(h.TimeStamp) > 0 {
+		oi.Time = t
+	}
+
+	m.muOI.Lock()
+	defer m.muOI.Unlock()
+	m.openInterest = oi
+
+	// 同一分钟内只保留最新的一条
+	n := len(m.oiHistory)
+	if n > 0 && m.oiHistory[n-1].Time.Truncate(time.Minute).Equal(oi.Time.Truncate(time.Minute)) {
+		m.oiHistory[n-1] = oi
+	} else {
+		m.oiHistory = append(m.oiHistory, oi)
+		if len(m.oiHistory) > maxOpenInterestHistory {
+			m.oiHistory = m.oiHistory[len(m.oiHistory)-maxOpenInterestHistory:]
+		}
+	}
+}
+
 func (m *FutureMarket) onMarkPriceResp(resp okexv5api.MarkPriceResp) {
 	m.markprice = m.AlignPriceNumber(util.String2DecimalPanic(resp.MarkPrice))
 }
@@ -159,10 +258,19 @@ func (m *FutureMarket) onPriceLimitResp(resp okexv5api.PriceLimitResp) {
 
 func (m *FutureMarket) onFundingRateResp(resp interface{}) {
 	r := resp.(okexv5api.FundingRateWsResp)
+	prevRate, prevTime := m.fundingRate, m.fundingTime
 	m.fundingRate = r.Data[0].FundingRate
 	m.nextFundingRate = r.Data[0].NextFundingRate
 	m.fundingTime = r.Data[0].FundingTime
 	m.nextFundingTime = r.Data[0].NextFundingTime // okx的ws中暂时没有这个字段
+	m.maxFundingRate = r.Data[0].MaxFundingRate
+	m.minFundingRate = r.Data[0].MinFundingRate
+
+	// 结算时间变化时，上一期最后的预估费率即为上期结算费率
+	d := m.FundingDetail()
+	m.fundingPredictor.SetDetail(d.Interval, d.RateCap, d.RateFloor)
+	m.fundingPredictor.SetFundingTime(m.fundingTime, util.ValueIf(prevTime.IsZero(), m.fundingRate, prevRate))
+	m.fundingPredictor.OnSample(m.ex.now(), m.fundingRate)
 
 	m.fundingFeeOK = true
 }
@@ -180,16 +288,22 @@ func (m *FutureMarket) String() string {
 }
 
 func (m *FutureMarket) Ready() bool {
-	return m.depthOK && m.fundingFeeOK && m.markpriceOK && m.priceLimitOK
+	return m.depthOK && m.fundingFeeOK && m.markpriceOK && m.indexPriceOK() && m.priceLimitOK && m.wsConnected && m.tradable()
 }
 
 func (m *FutureMarket) UnreadyReason() string {
-	if !m.depthOK {
+	if !m.tradable() {
+		return "instrument " + m.ex.instrumentMgr.Status(m.instId).String()
+	} else if !m.wsConnected {
+		return "websocket " + m.ws.PublicState().String()
+	} else if !m.depthOK {
 		return "depth not ready"
 	} else if !m.fundingFeeOK {
 		return "funding fee not ready"
 	} else if !m.markpriceOK {
 		return "mark price not ready"
+	} else if !m.indexPriceOK() {
+		return "index price not ready"
 	} else if !m.priceLimitOK {
 		return "price limit not ready"
 	} else {
@@ -205,10 +319,58 @@ func (m *FutureMarket) MarkPrice() decimal.Decimal {
 	}
 }
 
+// 未开启SubscribeIndexPrice时返回0
+func (m *FutureMarket) IndexPrice() decimal.Decimal {
+	if m.indexSub == nil {
+		return decimal.Zero
+	}
+	return m.indexSub.Price()
+}
+
+func (m *FutureMarket) indexPriceOK() bool {
+	return m.indexSub == nil || m.indexSub.Ready()
+}
+
 func (m *FutureMarket) FundingInfo() (decimal.Decimal, decimal.Decimal, time.Time, time.Time) {
 	return m.fundingRate, m.nextFundingRate, m.fundingTime, m.nextFundingTime
 }
 
+func (m *FutureMarket) FundingDetail() common.FundingDetail {
+	d := common.FundingDetail{
+		Rate:            m.fundingRate,
+		NextRate:        m.nextFundingRate,
+		FundingTime:     m.fundingTime,
+		NextFundingTime: m.nextFundingTime,
+		Interval:        time.Hour * 8,
+		RateCap:         m.maxFundingRate,
+		RateFloor:       m.minFundingRate,
+	}
+
+	// 有下期时间的话，用两期间隔作为结算周期（部分币种为4h/2h）
+	if !m.nextFundingTime.IsZero() && m.nextFundingTime.After(m.fundingTime) {
+		d.Interval = m.nextFundingTime.Sub(m.fundingTime)
+	}
+
+	return d
+}
+
+func (m *FutureMarket) FundingForecast() common.FundingForecast {
+	return m.fundingPredictor.Forecast()
+}
+
+func (m *FutureMarket) OpenInterest() common.OpenInterest {
+	m.muOI.Lock()
+	defer m.muOI.Unlock()
+	return m.openInterest
+}
+
+func (m *FutureMarket) OpenInterestHistory(t0 time.Time) []common.OpenInterest {
+	m.muOI.Lock()
+	defer m.muOI.Unlock()
+	i, _ := slices.BinarySearchFunc(m.oiHistory, t0, func(oi common.OpenInterest, t time.Time) int { return oi.Time.Compare(t) })
+	return slices.Clone(m.oiHistory[i:])
+}
+
 func (m *FutureMarket) ValueAmount() decimal.Decimal {
 	return m.inst.CtVal
 }
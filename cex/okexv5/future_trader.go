@@ -15,6 +15,8 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -37,6 +39,7 @@ type FutureTrader struct {
 
 	balance *common.BalanceImpl // 保证金权益
 	lever   int                 // 杠杆倍率
+	tiers   common.MarginTiers  // 维持保证金阶梯
 
 	orders   map[string]*ContractOrder // clientId-order
 	muOrders sync.RWMutex
@@ -87,6 +90,9 @@ func (t *FutureTrader) Init(ex *Exchange, orderTag string, m *FutureMarket, leve
 		time.Sleep(time.Second)
 	}
 
+	// 获取维持保证金阶梯
+	t.loadMarginTiers()
+
 	// 获取balance指针
 	t.balance = ex.balanceMgr.FindBalance(m.SettlementCurrency())
 
@@ -99,8 +105,11 @@ func (t *FutureTrader) Init(ex *Exchange, orderTag string, m *FutureMarket, leve
 		var ok bool = false
 
 		if len(os.tag) > 0 && os.tag != orderTag {
-			t.errorlock = true
-			logger.LogPanic(t.logPrefix, "found order from other stratergy(%s)!", os.tag)
+			if !common.IsRunnerId(os.tag) {
+				t.errorlock = true
+				logger.LogImportant(t.logPrefix, "found order from other stratergy(%s)!", os.tag)
+			}
+			return
 		}
 
 		t.muOrders.RLock()
@@ -112,6 +121,17 @@ func (t *FutureTrader) Init(ex *Exchange, orderTag string, m *FutureMarket, leve
 		}
 	})
 
+	// 处理之前的进程遗留的挂单
+	ex.reconcileOrphans(m.instId, orderTag, func(d okexv5api.OrderResp) {
+		o := new(ContractOrder)
+		o.adopt(t, d)
+		t.muOrders.Lock()
+		t.orders[o.CltOrderId.(string)] = o
+		t.muOrders.Unlock()
+		o.AddObserver(t)
+		o.Go()
+	})
+
 	// 清理finished orders
 	go func() {
 		for !t.finished {
@@ -129,6 +149,42 @@ func (t *FutureTrader) Init(ex *Exchange, orderTag string, m *FutureMarket, leve
 	logger.LogImportant(logPrefix, "future trader(%s) inited", m.instId)
 }
 
+// 获取失败时阶梯为空，预估强平价格时维持保证金率按0计算
+func (t *FutureTrader) loadMarginTiers() {
+	instType := util.ValueIf(strings.HasSuffix(t.market.instId, "-SWAP"), "SWAP", "FUTURES")
+	ss := strings.Split(t.market.instId, "-")
+	if len(ss) < 2 {
+		return
+	}
+
+	instFamily := ss[0] + "-" + ss[1]
+	resp, err := okexv5api.GetPositionTiers(instType, string(t.exchange.excfg.ContractTradeMode), instFamily)
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "get position tiers failed: %s", err.Error())
+		return
+	} else if resp.Code != "0" {
+		logger.LogImportant(t.logPrefix, "get position tiers failed: %s", resp.Msg)
+		return
+	}
+
+	t.tiers = nil
+	for _, d := range resp.Data {
+		if len(d.InstId) > 0 && d.InstId != t.market.instId {
+			continue
+		}
+		t.tiers = append(t.tiers, common.MarginTier{
+			Tier:            d.Tier,
+			MinSize:         d.MinSz,
+			MaxSize:         d.MaxSz,
+			MaintMarginRate: d.Mmr,
+			InitMarginRate:  d.Imr,
+			MaxLever:        d.MaxLever,
+		})
+	}
+	slices.SortFunc(t.tiers, func(a, b common.MarginTier) int { return a.Tier - b.Tier })
+	logger.LogImportant(t.logPrefix, "%d position tiers loaded", len(t.tiers))
+}
+
 func (t *FutureTrader) Uninit() {
 	t.finished = true
 	t.exchange.UnregOrderSnapshot(t.market.instId)
@@ -137,6 +193,22 @@ func (t *FutureTrader) Uninit() {
 }
 
 // 实现common.OrderObserver
+// 出现异常时锁定交易器，不能再下单
+func (t *FutureTrader) lockByError(format string, params ...interface{}) {
+	if !t.errorlock {
+		t.errorlock = true
+		logger.LogImportant(t.logPrefix, "trader locked: "+format, params...)
+	}
+}
+
+// 人工确认异常处理完毕后解锁
+func (t *FutureTrader) ClearErrorLock() {
+	if t.errorlock {
+		t.errorlock = false
+		logger.LogImportant(t.logPrefix, "trader unlocked")
+	}
+}
+
 func (t *FutureTrader) OnDeal(deal common.Deal) {
 	// 记录因为成交而带来的仓位变化
 	o := deal.O.(*CommonOrder)
@@ -229,9 +301,25 @@ func (t *FutureTrader) MakeOrder(
 	makeOnly, reduceOnly bool,
 	purpose string,
 	obs common.OrderObserver) common.Order {
+	return t.MakeOrderTIF(price, amount, dir, util.ValueIf(makeOnly, common.TimeInForce_GTX, common.TimeInForce_GTC), reduceOnly, purpose, obs)
+}
+
+func (t *FutureTrader) MakeOrderTIF(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif common.TimeInForce,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
 	if t.Ready() {
+		amount, ok := t.checkReduceOnly(dir, amount, reduceOnly)
+		if !ok {
+			return nil
+		}
+
 		o := new(ContractOrder)
-		if o.Init(t, price, amount, dir, makeOnly, reduceOnly, purpose) {
+		if o.Init(t, price, amount, dir, tif, reduceOnly, purpose) {
 			t.muOrders.Lock()
 			t.orders[o.CltOrderId.(string)] = o
 			t.muOrders.Unlock()
@@ -249,6 +337,71 @@ func (t *FutureTrader) MakeOrder(
 	}
 }
 
+func (t *FutureTrader) MakeMarketOrder(
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	byQuote bool,
+	maxSlippage decimal.Decimal,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	if !t.Ready() {
+		logger.LogInfo(t.logPrefix, "trader not ready, can't make market order. reason=%s", t.UnreadyReason())
+		return nil
+	}
+
+	if byQuote {
+		logger.LogImportant(t.logPrefix, "market order by quote not supported for contract")
+		return nil
+	}
+	guardPrice, baseAmount, err := common.CheckMarketOrder(t.market.OrderBook(), dir, amount, byQuote, maxSlippage)
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "market order rejected: %s", err.Error())
+		return nil
+	}
+
+	baseAmount, ok := t.checkReduceOnly(dir, baseAmount, reduceOnly)
+	if !ok {
+		return nil
+	}
+
+	o := new(ContractOrder)
+	if o.InitMarket(t, guardPrice, baseAmount, dir, reduceOnly, purpose) {
+		t.muOrders.Lock()
+		t.orders[o.CltOrderId.(string)] = o
+		t.muOrders.Unlock()
+		o.AddObserver(t)
+		o.AddObserver(obs)
+		o.Go()
+		return o
+	} else {
+		return nil
+	}
+}
+
+// 只减仓订单的数量不超过可减仓位(扣除已挂出的只减仓订单)
+func (t *FutureTrader) checkReduceOnly(dir common.OrderDir, amount decimal.Decimal, reduceOnly bool) (decimal.Decimal, bool) {
+	if !reduceOnly || t.pos == nil {
+		return amount, true
+	}
+
+	pending := decimal.Zero
+	t.muOrders.RLock()
+	for _, o := range t.orders {
+		if o.ReduceOnly && o.Dir == dir && !o.IsFinished() {
+			pending = pending.Add(o.GetUnfilled())
+		}
+	}
+	t.muOrders.RUnlock()
+
+	amount, err := common.CheckReduceOnly(t.pos, dir, amount, pending)
+	if err != nil {
+		logger.LogInfo(t.logPrefix, err.Error())
+		return decimal.Zero, false
+	}
+	return amount, true
+}
+
 func (t *FutureTrader) Orders() []common.Order {
 	orders := make([]common.Order, 0, len(t.orders))
 	for _, o := range t.orders {
@@ -271,12 +424,13 @@ func (t *FutureTrader) AvailableAmount(dir common.OrderDir, price decimal.Decima
 	// 反向合约（USD合约）为coin x price x lever / AmountValue
 	// 正向合约（USDT合约）为usdt / price x lever / AmountValue
 	// 平仓时，可用数量以剩余仓位计算（目前不考虑对向开仓，这样比较保守和简单）
-	availableMargin := t.balance.Available().InexactFloat64()
+	// 保证金扣除安全边际，见common.AvailableOpenValue
+	availableMargin := t.balance.Available()
 	if !t.exchange.isSingleMarginMode() {
 		// 注意，单币种保证金模式和跨币种/组合保证金模式的保证金计算方式不一样。
 		// 前者为balance，后者则需要从api获取。特殊的：组合保证金模式的杠杆率以1计算（因为没有杠杆率的概念）
 		if v, ok := t.exchange.getMaxAvailable(t.market.instId); ok {
-			availableMargin = util.ValueIf(dir == common.OrderDir_Buy, v.AvailableBuy.InexactFloat64(), v.AvailableSell.InexactFloat64())
+			availableMargin = util.ValueIf(dir == common.OrderDir_Buy, v.AvailableBuy, v.AvailableSell)
 		} else {
 			logger.LogImportant(t.logPrefix, "get max available from exchange failed")
 			return decimal.Zero
@@ -290,11 +444,12 @@ func (t *FutureTrader) AvailableAmount(dir common.OrderDir, price decimal.Decima
 	}
 	px := price.InexactFloat64()
 
+	openValue := common.AvailableOpenValue(availableMargin, t.lever, t.FeeTaker()).InexactFloat64()
 	available := decimal.Zero
 	if t.market.IsUsdtContract() {
-		available = decimal.NewFromFloat(availableMargin / px * float64(t.lever) / valueAmnt * 0.95) // 按保守估计
+		available = decimal.NewFromFloat(openValue / px / valueAmnt * 0.95) // 按保守估计
 	} else {
-		available = decimal.NewFromFloat(availableMargin * px * float64(t.lever) / valueAmnt * 0.95) // 按保守估计
+		available = decimal.NewFromFloat(openValue * px / valueAmnt * 0.95) // 按保守估计
 	}
 
 	available = t.exchange.instrumentMgr.AlignSize(t.market.instId, available)
@@ -331,4 +486,53 @@ func (t *FutureTrader) Position() common.Position {
 	return t.pos
 }
 
+// 全仓模式下权益、维持保证金、保证金率为账户级(来自account频道)，强平价格来自position频道
+func (t *FutureTrader) MarginInfo() common.MarginInfo {
+	bal := t.exchange.GetAccountBal()
+	mi := common.MarginInfo{
+		Equity:      bal.AdjEq,
+		MaintMargin: bal.MaintainMargin,
+		MarginRatio: bal.MarginRatio,
+		MarkPrice:   t.market.MarkPrice(),
+		Time:        t.exchange.now(),
+	}
+	mi.LiqPrice, _ = t.exchange.positionLiqPx(t.market.instId, mi.MarkPrice)
+	if mi.LiqPrice.IsZero() && t.exchange.isSingleMarginMode() && !t.pos.Net().IsZero() {
+		// 交易所未提供强平价格时自行估算，全仓时保证金为扣除本仓位未实现盈亏后的权益
+		net := t.pos.Net()
+		dir := util.ValueIf(net.IsPositive(), common.OrderDir_Buy, common.OrderDir_Sell)
+		entry := util.ValueIf(net.IsPositive(), t.pos.LongAvgPx(), t.pos.ShortAvgPx())
+		margin := decimal.Zero
+		if t.exchange.excfg.ContractTradeMode == okexv5api.TradeMode_Cross && mi.MarkPrice.IsPositive() && entry.IsPositive() {
+			upl := net.Mul(t.market.ValueAmount()).Mul(mi.MarkPrice.Sub(entry))
+			if !t.market.IsUsdtContract() {
+				upl = net.Mul(t.market.ValueAmount()).Mul(decimal.NewFromInt(1).Div(entry).Sub(decimal.NewFromInt(1).Div(mi.MarkPrice)))
+			}
+			margin = t.balance.Rights().Sub(upl)
+		}
+		mi.LiqPrice = t.EstimateLiqPrice(dir, net.Abs(), entry, margin)
+	}
+	if mi.MarginRatio.IsZero() {
+		mi.MarginRatio = common.MarginRatioOf(mi.Equity, mi.MaintMargin)
+	}
+	return mi
+}
+
+func (t *FutureTrader) MarginTiers() common.MarginTiers {
+	return t.tiers
+}
+
+func (t *FutureTrader) EstimateLiqPrice(dir common.OrderDir, size, entryPx, margin decimal.Decimal) decimal.Decimal {
+	return common.EstimateLiqPrice(common.LiqParams{
+		Dir:        dir,
+		Size:       size,
+		EntryPrice: entryPx,
+		Margin:     margin,
+		Lever:      t.lever,
+		CtVal:      t.market.ValueAmount(),
+		Inverse:    !t.market.IsUsdtContract(),
+		Tiers:      t.tiers,
+	})
+}
+
 // #endregion 实现common.FutureTrader
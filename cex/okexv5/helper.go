@@ -13,9 +13,12 @@ package okexv5
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/aztecqt/dagger/cex/common"
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
 )
@@ -103,9 +106,21 @@ func InstIdToCcy(instId string) string {
 
 var accClientOrderId int32
 
+// 本进程的clientOrderId前缀(启动时间的4位36进制)，用于区分之前的进程留下的订单，见reconcile.go
+var clientIdNamespace = func() string {
+	ns := strconv.FormatInt(time.Now().Unix()%1679616, 36)
+	return strings.Repeat("0", 4-len(ns)) + ns
+}()
+
 func NewClientOrderId(purpose string) string {
 	newId := atomic.AddInt32(&accClientOrderId, 1)
-	return util.ToLetterNumberOnly(fmt.Sprintf("%05d%s", newId, purpose), 32)
+	leaseClientIdSeq(newId)
+	return util.ToLetterNumberOnly(fmt.Sprintf("%s%05d%s", clientIdNamespace, newId, purpose), 32)
+}
+
+// 是否为本进程创建的订单
+func isOwnClientId(clientId string) bool {
+	return strings.HasPrefix(clientId, clientIdNamespace)
 }
 
 var accAmendId int32
@@ -114,3 +129,34 @@ func NewAmendId() string {
 	newId := atomic.AddInt32(&accAmendId, 1)
 	return fmt.Sprintf("%05d", newId)
 }
+
+// okx品种状态 -> 通用状态
+// 上线前按开盘方式区分：预挂单阶段只能挂单，集合竞价阶段视为竞价
+func instrumentStatus(state, openType string, listTime time.Time) common.InstrumentStatus {
+	preOpen := func() common.InstrumentStatus {
+		switch openType {
+		case "pre_quote":
+			return common.InstrumentStatus_PostOnly
+		case "call_auction":
+			return common.InstrumentStatus_Auction
+		default:
+			return common.InstrumentStatus_PreOpen
+		}
+	}
+
+	switch state {
+	case "live":
+		if !listTime.IsZero() && time.Now().Before(listTime) {
+			return preOpen()
+		}
+		return common.InstrumentStatus_Trading
+	case "settlement":
+		// 资金费结算只持续很短的时间，不影响交易
+		return common.InstrumentStatus_Trading
+	case "preopen":
+		return preOpen()
+	default:
+		// suspend/expired/test
+		return common.InstrumentStatus_Halted
+	}
+}
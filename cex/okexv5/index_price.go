@@ -0,0 +1,105 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-15 10:21:36
+- @Description: 指数价格的订阅。同一指数被多个合约共用(如BTC-USDT-SWAP和BTC-USDT-240628)，按指数共享一个订阅，引用计数归零时退订
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+type indexPriceSub struct {
+	index  string
+	refs   int
+	mu     sync.Mutex
+	price  decimal.Decimal
+	ok     bool
+	chStop chan struct{}
+}
+
+func (s *indexPriceSub) Price() decimal.Decimal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.price
+}
+
+func (s *indexPriceSub) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ok
+}
+
+func (s *indexPriceSub) set(px decimal.Decimal, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok {
+		s.price = px
+	}
+	s.ok = ok
+}
+
+// 订阅指数价格(20秒超时重新订阅，10秒没有推送则rest拉取。价格不变时服务器不推送)
+func (s *indexPriceSub) run(ws *okexv5api.WsClient) {
+	defer util.DefaultRecover()
+	timeoutReSub := time.NewTicker(time.Second * 20)
+	timeoutREST := time.NewTicker(time.Second * 10)
+	defer timeoutReSub.Stop()
+	defer timeoutREST.Stop()
+	sub := ws.SubscribeIndexTicker(s.index, func(resp interface{}) {
+		r := resp.(okexv5api.IndexTickerWsResp)
+		if len(r.Data) > 0 {
+			s.set(r.Data[0].IndexPrice, true)
+		}
+		timeoutReSub.Reset(time.Second * 20)
+		timeoutREST.Reset(time.Second * 10)
+	})
+
+	for {
+		select {
+		case <-timeoutREST.C:
+			resp, err := okexv5api.GetIndexTickers("", s.index)
+			if err == nil && resp.Code == "0" && len(resp.Data) > 0 {
+				s.set(resp.Data[0].IndexPrice, true)
+				timeoutReSub.Reset(time.Second * 20)
+			}
+		case <-timeoutReSub.C:
+			s.set(decimal.Zero, false)
+			sub.Reset()
+		case <-s.chStop:
+			ws.UnsubscribeIndexTicker(s.index)
+			return
+		}
+	}
+}
+
+func (e *Exchange) subscribeIndexPrice(index string) *indexPriceSub {
+	e.muIndexSubs.Lock()
+	defer e.muIndexSubs.Unlock()
+	s, ok := e.indexSubs[index]
+	if !ok {
+		s = &indexPriceSub{index: index, chStop: make(chan struct{})}
+		e.indexSubs[index] = s
+		go s.run(e.ws)
+	}
+	s.refs++
+	return s
+}
+
+func (e *Exchange) unsubscribeIndexPrice(index string) {
+	e.muIndexSubs.Lock()
+	defer e.muIndexSubs.Unlock()
+	if s, ok := e.indexSubs[index]; ok {
+		s.refs--
+		if s.refs <= 0 {
+			close(s.chStop)
+			delete(e.indexSubs, index)
+		}
+	}
+}
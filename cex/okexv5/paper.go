@@ -0,0 +1,78 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-09 10:26:43
+- @Description: 模拟交易。行情来自okx实时推送，交易器由backtest包的模拟交易器实现，订单在本地撮合
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"github.com/aztecqt/dagger/backtest"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type paperTrading struct {
+	acc           *backtest.Account
+	spotTraders   map[string]*backtest.SimSpotTrader
+	futureTraders map[string]*backtest.SimFutureTrader
+}
+
+func newPaperTrading(cfg backtest.PaperConfig) *paperTrading {
+	p := new(paperTrading)
+	p.acc = cfg.NewAccount()
+	p.spotTraders = make(map[string]*backtest.SimSpotTrader)
+	p.futureTraders = make(map[string]*backtest.SimFutureTrader)
+	return p
+}
+
+// 撤销所有模拟订单
+func (p *paperTrading) exit() {
+	for _, t := range p.spotTraders {
+		for _, o := range t.Orders() {
+			o.Cancel()
+		}
+	}
+
+	for _, t := range p.futureTraders {
+		for _, o := range t.Orders() {
+			o.Cancel()
+		}
+	}
+}
+
+func (e *Exchange) usePaperSpotTrader(baseCcy, quoteCcy string) common.SpotTrader {
+	instId := SpotTypeToInstId(baseCcy, quoteCcy)
+	if t, ok := e.paper.spotTraders[instId]; ok {
+		return t
+	}
+
+	mkt := e.UseSpotMarket(baseCcy, quoteCcy)
+	if mkt == nil {
+		return nil
+	}
+
+	t := backtest.NewSpotTrader(exchangeName, e.paper.acc, mkt, e.instrumentMgr.Get(instId), e.instrumentMgr)
+	e.paper.spotTraders[instId] = t
+	e.spotTradersSlice = append(e.spotTradersSlice, t)
+	logger.LogImportant(logPrefix, "paper spot trader(%s) created", instId)
+	return t
+}
+
+func (e *Exchange) usePaperFutureTrader(symbol, contractType string, lever int) common.FutureTrader {
+	instId := CCyCttypeToInstId(symbol, contractType)
+	if t, ok := e.paper.futureTraders[instId]; ok {
+		return t
+	}
+
+	mkt := e.UseFutureMarket(symbol, contractType)
+	if mkt == nil {
+		return nil
+	}
+
+	t := backtest.NewFutureTrader(exchangeName, e.paper.acc, mkt, e.instrumentMgr.Get(instId), e.instrumentMgr, lever)
+	e.paper.futureTraders[instId] = t
+	e.futureTradersSlice = append(e.futureTradersSlice, t)
+	logger.LogImportant(logPrefix, "paper future trader(%s) created, lever=%d", instId, lever)
+	return t
+}
@@ -0,0 +1,136 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-25 15:34:08
+- @Description: 合约仓位核对。仓位平时由ws推送维护，漏推送时会与交易所不一致
+- 定时(ExchangeConfig.PositionCheckIntervalSec)拉取rest仓位进行比较，rest中没有的仓位视为0：
+- 1. 发现差异时5秒后再拉取一次，两次差异一致才认为是真实偏差(排除推送与rest的时间差)，以rest为准修正
+- 2. 差异比例(差异/较大的总仓位)超过PositionLockRatio时锁定该品种的交易器，需要人工检查后调用ClearErrorLock解锁
+- 有未确认成交(预估仓位)的品种跳过本次核对
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"time"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type restPosition struct {
+	long, short           decimal.Decimal
+	longAvgPx, shortAvgPx decimal.Decimal
+}
+
+type positionDrift struct {
+	long, short decimal.Decimal // rest-本地
+	rest        restPosition
+	ratio       decimal.Decimal
+}
+
+func (e *Exchange) fetchRestPositions() ([]okexv5api.PositionUnit, map[string]restPosition, bool) {
+	resp, err := okexv5api.GetPositions("", "")
+	if err != nil {
+		logger.LogImportant(logPrefix, "get positions from rest failed: %s", err.Error())
+		return nil, nil, false
+	} else if resp.Code != "0" {
+		logger.LogImportant(logPrefix, "get position from rest failed: code=%v, msg=%v", resp.Code, resp.Msg)
+		return nil, nil, false
+	}
+
+	result := make(map[string]restPosition)
+	for _, d := range resp.Data {
+		if d.MgnMode != "cross" || (d.InstType != "SWAP" && d.InstType != "FUTURES") {
+			continue
+		}
+
+		rp := result[d.InstId]
+		size := util.String2DecimalPanic(d.Pos)
+		avgPx := util.String2DecimalPanicUnless(d.AvgPx, "")
+		if (d.PosSide == "net" && size.IsPositive()) || d.PosSide == "long" {
+			rp.long, rp.longAvgPx = size, avgPx
+		} else if d.PosSide == "net" || d.PosSide == "short" {
+			rp.short, rp.shortAvgPx = size.Abs(), avgPx
+		}
+		result[d.InstId] = rp
+	}
+	return resp.Data, result, true
+}
+
+// 本地仓位与rest仓位的差异
+func (e *Exchange) positionDrifts(rest map[string]restPosition) map[string]positionDrift {
+	e.muPosition.RLock()
+	defer e.muPosition.RUnlock()
+
+	drifts := make(map[string]positionDrift)
+	for instId, p := range e.ctPositions {
+		if !p.Ready() {
+			continue
+		}
+
+		rp := rest[instId]
+		d := positionDrift{long: rp.long.Sub(p.Long()), short: rp.short.Sub(p.Short()), rest: rp}
+		diff := d.long.Abs().Add(d.short.Abs())
+		if diff.IsZero() {
+			continue
+		}
+
+		gross := decimal.Max(rp.long.Add(rp.short), p.Long().Add(p.Short()))
+		d.ratio = util.ValueIf(gross.IsPositive(), diff.Div(gross), decimal.NewFromInt(1))
+		drifts[instId] = d
+	}
+	return drifts
+}
+
+func (e *Exchange) checkPositions() {
+	units, rest, ok := e.fetchRestPositions()
+	if !ok {
+		return
+	}
+
+	// 强平价格等仍以rest刷新
+	for _, d := range units {
+		if d.MgnMode == "cross" && (d.InstType == "SWAP" || d.InstType == "FUTURES") {
+			e.refreshPositionRisk(d, util.String2DecimalPanic(d.Pos))
+		}
+	}
+
+	drifts := e.positionDrifts(rest)
+	if len(drifts) == 0 {
+		return
+	}
+
+	for instId, d := range drifts {
+		logger.LogInfo(logPrefix, "position drift of %s: long %v, short %v, confirming...", instId, d.long, d.short)
+	}
+
+	time.Sleep(time.Second * 5)
+	if _, rest, ok = e.fetchRestPositions(); !ok {
+		return
+	}
+
+	now := time.Now()
+	for instId, d := range e.positionDrifts(rest) {
+		if prev, ok := drifts[instId]; !ok || !prev.long.Equal(d.long) || !prev.short.Equal(d.short) {
+			continue
+		}
+
+		p := e.findPosition(instId)
+		logger.LogImportant(logPrefix, "position of %s drifted, local=%v/%v, rest=%v/%v, corrected", instId, p.Long(), p.Short(), d.rest.long, d.rest.short)
+		p.RefreshLong(d.rest.long, d.rest.longAvgPx, now)
+		p.RefreshShort(d.rest.short, d.rest.shortAvgPx, now)
+		if d.rest.long.IsZero() && d.rest.short.IsZero() {
+			e.muPosition.Lock()
+			delete(e.positionRisks, instId)
+			e.muPosition.Unlock()
+		}
+
+		if e.excfg.PositionLockRatio.IsPositive() && d.ratio.GreaterThan(e.excfg.PositionLockRatio) {
+			if t, ok := e.futureTraders[instId]; ok {
+				t.lockByError("position drift %v > %v", d.ratio.Round(4), e.excfg.PositionLockRatio)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-25 10:16:34
+- @Description: 遗留订单处理。进程崩溃后，之前下的挂单仍留在交易所，新进程看不到它们
+- 本进程的clientOrderId带有启动时生成的前缀(见helper.go)，交易器初始化时查询该品种的挂单，
+- tag与本策略相同、但不是本进程创建的订单视为遗留订单，按ExchangeConfig.OrphanOrderPolicy处理：
+- 1. cancel(默认)：撤销。交易所初始化时已经撤销了本策略的全部挂单(CloseAllOrders)，这里处理剩下的
+- 2. ignore：只记录日志，订单留在交易所
+- 3. adopt：接管为本进程的订单，之后正常刷新，可以通过交易器的Orders()取到。接管前的成交不会再推送
+- 没有设置策略名(tag为空)时无法区分，不做处理。同一策略名不应同时运行多个进程
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type OrphanPolicy string
+
+const (
+	OrphanPolicy_Ignore OrphanPolicy = "ignore"
+	OrphanPolicy_Cancel OrphanPolicy = "cancel"
+	OrphanPolicy_Adopt  OrphanPolicy = "adopt"
+)
+
+// 查询某品种的遗留订单并按配置处理，需要接管的订单交给fnAdopt
+func (e *Exchange) reconcileOrphans(instId, tag string, fnAdopt func(d okexv5api.OrderResp)) {
+	if len(tag) == 0 {
+		return
+	}
+
+	resp, err := okexv5api.GetPendingOrders(instId)
+	if err != nil {
+		logger.LogImportant(logPrefix, "query pending orders of %s failed: %s", instId, err.Error())
+		return
+	} else if resp.Code != "0" {
+		logger.LogImportant(logPrefix, "query pending orders of %s failed: %s(%s)", instId, resp.Msg, resp.Code)
+		return
+	}
+
+	policy := util.ValueIf(len(e.excfg.OrphanOrderPolicy) > 0, e.excfg.OrphanOrderPolicy, OrphanPolicy_Cancel)
+	for _, d := range resp.Data {
+		if d.Tag != tag || isOwnClientId(d.ClientOrderId) {
+			continue
+		}
+
+		logger.LogImportant(logPrefix, "orphan order found: inst=%s, id=%s, clientId=%s, %s %s@%s, filled=%s, policy=%s",
+			d.InstId, d.OrderId, d.ClientOrderId, d.Side, d.Size, d.Price, d.AccFillSize, policy)
+		switch policy {
+		case OrphanPolicy_Cancel:
+			e.actionQueue.Do(common.ActionPriority_Cancel, func() {
+				id := util.String2Int64Panic(d.OrderId)
+				if r, err := okexv5api.CancelOrder(d.InstId, "", id); err != nil {
+					logger.LogImportant(logPrefix, "cancel orphan order %s failed: %s", d.OrderId, err.Error())
+				} else if len(r.Data) > 0 && r.Data[0].SCode != "0" {
+					logger.LogImportant(logPrefix, "cancel orphan order %s failed: %s(%s)", d.OrderId, r.Data[0].SMsg, r.Data[0].SCode)
+				}
+			})
+		case OrphanPolicy_Adopt:
+			if len(d.ClientOrderId) > 0 {
+				fnAdopt(d)
+			} else {
+				logger.LogImportant(logPrefix, "orphan order %s has no clientId, can't adopt", d.OrderId)
+			}
+		}
+	}
+}
+
+// 以交易所的订单数据初始化，不再创建
+func (o *CommonOrder) adopt(trader common.CommonTrader, instrumentMgr *common.InstrumentMgr, d okexv5api.OrderResp, actionQueue *common.ActionQueue) {
+	os := orderSnapshot{}
+	os.Parse(d, "rest")
+
+	o.Trader = trader
+	o.InstrumentMgr = instrumentMgr
+	o.InstId = d.InstId
+	o.OrderId = os.id
+	o.CltOrderId = os.clientId
+	o.Dir = util.ValueIf(d.Side == "sell", common.OrderDir_Sell, common.OrderDir_Buy)
+	o.ReduceOnly = d.ReduceOnly == "true"
+	o.TimeInForce = common.TimeInForce_GTC
+	o.Purpose = "adopted"
+	o.Price = os.price
+	o.Size = os.size
+	o.Filled = os.filled
+	o.AvgPrice = os.avgPrice
+	o.Status = os.status
+	o.Borntime = time.Now()
+	o.UpdateTime = os.updateTime
+	o.Observers = make([]common.OrderObserver, 0)
+	o.LogPrefix = fmt.Sprintf("Order-%s-%v", o.InstId, o.CltOrderId)
+	o.LogFields = logger.Fields{Exchange: exchangeName, InstId: o.InstId, OrderId: fmt.Sprintf("%v", o.CltOrderId)}
+	o.actionQueue = actionQueue
+}
@@ -53,11 +53,15 @@ func (m *SpotMarket) String() string {
 }
 
 func (m *SpotMarket) Ready() bool {
-	return m.depthOK
+	return m.depthOK && m.wsOK() && m.tradable()
 }
 
 func (m *SpotMarket) UnreadyReason() string {
-	if !m.depthOK {
+	if !m.tradable() {
+		return "instrument " + m.ex.instrumentMgr.Status(m.instId).String()
+	} else if !m.wsOK() {
+		return "websocket " + m.ws.PublicState().String()
+	} else if !m.depthOK {
 		return "depth not ready"
 	} else {
 		return ""
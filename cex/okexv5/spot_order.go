@@ -12,6 +12,7 @@
 package okexv5
 
 import (
+	"github.com/aztecqt/dagger/api/okexv5api"
 	"github.com/aztecqt/dagger/cex/common"
 
 	"github.com/shopspring/decimal"
@@ -26,13 +27,36 @@ func (o *SpotOrder) Init(
 	trader *SpotTrader,
 	price, amount decimal.Decimal,
 	dir common.OrderDir,
-	makeOnly bool,
+	tif common.TimeInForce,
 	purpose string) bool {
 	o.trader = trader
 	o.CltOrderId = NewClientOrderId(o.Purpose)
-	if o.CommonOrder.Init(trader, trader.ex.instrumentMgr, trader.market.instId, price, amount, dir, makeOnly, false, purpose) {
+	o.LogFields.Exchange = exchangeName
+	if o.CommonOrder.InitTIF(trader, trader.ex.instrumentMgr, trader.market.instId, price, amount, dir, tif, false, purpose) {
 		o.CommonOrder.getPosSide = o.getPosSide
 		o.CommonOrder.tradeMode = o.tradeMode
+		o.CommonOrder.actionQueue = trader.ex.actionQueue
+		o.CommonOrder.isSpot = true
+		return true
+	} else {
+		return false
+	}
+}
+
+// 市价单。quoteAmount为正时按计价币数量下单
+func (o *SpotOrder) InitMarket(
+	trader *SpotTrader,
+	guardPrice, amount, quoteAmount decimal.Decimal,
+	dir common.OrderDir,
+	purpose string) bool {
+	o.trader = trader
+	o.CltOrderId = NewClientOrderId(purpose)
+	o.LogFields.Exchange = exchangeName
+	if o.CommonOrder.InitMarket(trader, trader.ex.instrumentMgr, trader.market.instId, guardPrice, amount, quoteAmount, dir, false, purpose) {
+		o.CommonOrder.getPosSide = o.getPosSide
+		o.CommonOrder.tradeMode = o.tradeMode
+		o.CommonOrder.actionQueue = trader.ex.actionQueue
+		o.CommonOrder.isSpot = true
 		return true
 	} else {
 		return false
@@ -40,6 +64,15 @@ func (o *SpotOrder) Init(
 }
 
 // #region 提供给CommonOrder
+// 接管遗留订单，见reconcile.go
+func (o *SpotOrder) adopt(trader *SpotTrader, d okexv5api.OrderResp) {
+	o.trader = trader
+	o.CommonOrder.adopt(trader, trader.ex.instrumentMgr, d, trader.ex.actionQueue)
+	o.CommonOrder.getPosSide = o.getPosSide
+	o.CommonOrder.tradeMode = o.tradeMode
+	o.CommonOrder.isSpot = true
+}
+
 func (o *SpotOrder) getPosSide() string {
 	return ""
 }
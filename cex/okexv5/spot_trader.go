@@ -59,8 +59,11 @@ func (t *SpotTrader) Init(ex *Exchange, orderTag string, m *SpotMarket) {
 		var ok bool = false
 
 		if len(os.tag) > 0 && os.tag != orderTag {
-			t.errorlock = true
-			logger.LogPanic(t.logPrefix, "found order from other stratergy(%s)!", os.tag)
+			if !common.IsRunnerId(os.tag) {
+				t.errorlock = true
+				logger.LogImportant(t.logPrefix, "found order from other stratergy(%s)!", os.tag)
+			}
+			return
 		}
 
 		t.muOrders.RLock()
@@ -72,6 +75,17 @@ func (t *SpotTrader) Init(ex *Exchange, orderTag string, m *SpotMarket) {
 		}
 	})
 
+	// 处理之前的进程遗留的挂单
+	ex.reconcileOrphans(m.instId, orderTag, func(d okexv5api.OrderResp) {
+		o := new(SpotOrder)
+		o.adopt(t, d)
+		t.muOrders.Lock()
+		t.orders[o.CltOrderId.(string)] = o
+		t.muOrders.Unlock()
+		o.AddObserver(t)
+		o.Go()
+	})
+
 	// 清理finished orders
 	go func() {
 		for !t.finished {
@@ -179,9 +193,20 @@ func (t *SpotTrader) MakeOrder(
 	makeOnly, reduceOnly bool,
 	purpose string,
 	obs common.OrderObserver) common.Order {
+	return t.MakeOrderTIF(price, amount, dir, util.ValueIf(makeOnly, common.TimeInForce_GTX, common.TimeInForce_GTC), reduceOnly, purpose, obs)
+}
+
+func (t *SpotTrader) MakeOrderTIF(
+	price,
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	tif common.TimeInForce,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
 	if t.Ready() {
 		o := new(SpotOrder)
-		if o.Init(t, price, amount, dir, makeOnly, purpose) {
+		if o.Init(t, price, amount, dir, tif, purpose) {
 			t.muOrders.Lock()
 			t.orders[o.CltOrderId.(string)] = o
 			t.muOrders.Unlock()
@@ -199,6 +224,38 @@ func (t *SpotTrader) MakeOrder(
 	}
 }
 
+func (t *SpotTrader) MakeMarketOrder(
+	amount decimal.Decimal,
+	dir common.OrderDir,
+	byQuote bool,
+	maxSlippage decimal.Decimal,
+	reduceOnly bool,
+	purpose string,
+	obs common.OrderObserver) common.Order {
+	if !t.Ready() {
+		logger.LogInfo(t.logPrefix, "trader not ready, can't make market order. reason=%s", t.UnreadyReason())
+		return nil
+	}
+	guardPrice, baseAmount, err := common.CheckMarketOrder(t.market.OrderBook(), dir, amount, byQuote, maxSlippage)
+	if err != nil {
+		logger.LogImportant(t.logPrefix, "market order rejected: %s", err.Error())
+		return nil
+	}
+
+	o := new(SpotOrder)
+	if o.InitMarket(t, guardPrice, baseAmount, util.ValueIf(byQuote, amount, decimal.Zero), dir, purpose) {
+		t.muOrders.Lock()
+		t.orders[o.CltOrderId.(string)] = o
+		t.muOrders.Unlock()
+		o.AddObserver(t)
+		o.AddObserver(obs)
+		o.Go()
+		return o
+	} else {
+		return nil
+	}
+}
+
 func (t *SpotTrader) Orders() []common.Order {
 	orders := make([]common.Order, 0, len(t.orders))
 
@@ -228,31 +285,31 @@ func (t *SpotTrader) AvailableAmount(dir common.OrderDir, price decimal.Decimal)
 	if tdMode == okexv5api.TradeMode_Cash {
 		// 非保证金模式（真·现货）
 		if dir == common.OrderDir_Buy {
-			// 可买数量为当前可用Quote除以购买价格，向下取整
-			amount := t.quoteBalance.Available().Div(price)
+			// 可买数量为当前可用Quote(扣除安全边际)除以购买价格，向下取整
+			amount := common.AvailableBuyAmount(t.quoteBalance.Available(), price, t.FeeTaker())
 			amount = t.market.AlignSize(amount)
 			return amount
 		} else {
-			// 可卖数量为当前可用Base
-			return t.baseBalance.Available()
+			// 可卖数量为当前可用Base(扣除安全边际)
+			return t.market.AlignSize(common.AvailableSellAmount(t.baseBalance.Available()))
 		}
 	} else if tdMode == okexv5api.TradeMode_Cross {
 		if maxAvail, ok := t.ex.getMaxAvailable(t.market.instId); ok {
 			if dir == common.OrderDir_Buy {
 				// 可买数量类似上面
-				amount := maxAvail.AvailableBuy.Div(price)
+				amount := common.AvailableBuyAmount(maxAvail.AvailableBuy, price, t.FeeTaker())
 				amount = t.market.AlignSize(amount)
 				return amount
 			} else {
 				// 可卖数量为maxAvail里的sell
-				return maxAvail.AvailableSell
+				return t.market.AlignSize(common.AvailableSellAmount(maxAvail.AvailableSell))
 			}
 		} else {
 			logger.LogImportant(t.logPrefix, "get max available from ex failed")
 			return decimal.Zero
 		}
 	} else {
-		logger.LogPanic(t.logPrefix, "invalid trade mode: %s", tdMode)
+		logger.LogStrict(t.logPrefix, "invalid trade mode: %s", tdMode)
 		return decimal.Zero
 	}
 }
@@ -0,0 +1,70 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-28 16:40:12
+- @Description: 止盈止损策略委托，实现common.TpSlArmer
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package okexv5
+
+import (
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 触发后以市价平仓。返回algoId
+func armTpSl(ex *Exchange, logPrefix, instId, tradeMode, posSide string, reduceOnly bool, dir common.OrderDir, amount, tpPrice, slPrice decimal.Decimal, purpose string) (string, bool) {
+	side := util.ValueIf(dir == common.OrderDir_Buy, "buy", "sell")
+	im := ex.instrumentMgr
+	tp := util.ValueIf(tpPrice.IsPositive(), im.FormatPrice(instId, tpPrice), "")
+	sl := util.ValueIf(slPrice.IsPositive(), im.FormatPrice(instId, slPrice), "")
+
+	var resp *okexv5api.AlgoOrderRestResp
+	var err error
+	ex.actionQueue.Do(common.ActionPriority_Place, func() {
+		resp, err = okexv5api.MakeAlgoOrderOco(instId, NewClientOrderId(purpose), orderTag(), side, posSide, tradeMode, reduceOnly, im.FormatSize(instId, amount), tp, sl)
+	})
+	if !okexv5api.CheckRestResp(resp.CommonRestResp, err, "arm tp/sl", logPrefix) || len(resp.Data) == 0 {
+		return "", false
+	}
+	if resp.Data[0].SCode != "0" {
+		logger.LogImportant(logPrefix, "arm tp/sl failed, code=%s, msg=%s", resp.Data[0].SCode, resp.Data[0].SMsg)
+		return "", false
+	}
+	return resp.Data[0].AlgoId, true
+}
+
+func disarmTpSl(ex *Exchange, logPrefix, instId, algoId string) bool {
+	var resp *okexv5api.AlgoOrderRestResp
+	var err error
+	ex.actionQueue.Do(common.ActionPriority_Cancel, func() {
+		resp, err = okexv5api.CancelAlgoOrder(instId, algoId)
+	})
+	return okexv5api.CheckRestResp(resp.CommonRestResp, err, "disarm tp/sl", logPrefix)
+}
+
+// #region 实现common.TpSlArmer
+func (t *SpotTrader) ArmTpSl(dir common.OrderDir, amount, tpPrice, slPrice decimal.Decimal, purpose string) (string, bool) {
+	return armTpSl(t.ex, t.logPrefix, t.market.instId, string(t.ex.excfg.SpotTradeMode), "", false, dir, amount, tpPrice, slPrice, purpose)
+}
+
+func (t *SpotTrader) DisarmTpSl(id string) bool {
+	return disarmTpSl(t.ex, t.logPrefix, t.market.instId, id)
+}
+
+func (t *FutureTrader) ArmTpSl(dir common.OrderDir, amount, tpPrice, slPrice decimal.Decimal, purpose string) (string, bool) {
+	// 开平仓模式下指定平哪一侧，买入平空、卖出平多。净仓模式用reduceOnly
+	posSide := ""
+	if t.exchange.excfg.PositionMode == okexv5api.PositonMode_LS {
+		posSide = util.ValueIf(dir == common.OrderDir_Buy, "short", "long")
+	}
+	return armTpSl(t.exchange, t.logPrefix, t.market.instId, string(t.exchange.excfg.ContractTradeMode), posSide, len(posSide) == 0, dir, amount, tpPrice, slPrice, purpose)
+}
+
+func (t *FutureTrader) DisarmTpSl(id string) bool {
+	return disarmTpSl(t.exchange, t.logPrefix, t.market.instId, id)
+}
+
+// #endregion
@@ -0,0 +1,199 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-14 09:48:12
+- @Description: 希腊值汇总。把期权的希腊值与合约/现货的线性delta合并，得出每个标的的组合敞口，供对冲使用
+- delta以标的币数量计
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package options
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/shopspring/decimal"
+)
+
+// 期权持仓及其希腊值（单张）
+type OptionPosition struct {
+	InstId     string
+	Underlying string  // 标的币种，小写，如btc
+	Size       float64 // 持仓张数，负数为空头
+	Multiplier float64 // 每张对应的标的数量
+	Delta      float64
+	Gamma      float64
+	Vega       float64
+	Theta      float64
+	UpdateTime time.Time
+}
+
+// 单个标的的组合敞口
+type Greeks struct {
+	Underlying  string
+	OptionDelta float64
+	LinearDelta float64 // 合约+现货
+	Delta       float64 // 总delta
+	Gamma       float64
+	Vega        float64
+	Theta       float64
+	RefreshTime time.Time
+}
+
+func (g Greeks) String() string {
+	return fmt.Sprintf("[%s delta:%.4f(opt:%.4f lin:%.4f) gamma:%.6f vega:%.4f theta:%.4f]",
+		g.Underlying, g.Delta, g.OptionDelta, g.LinearDelta, g.Gamma, g.Vega, g.Theta)
+}
+
+type GreeksAggregator struct {
+	mu           sync.Mutex
+	options      map[string]OptionPosition // instId-position
+	futureTrader []common.FutureTrader
+	spotTraders  []common.SpotTrader
+	greeks       map[string]Greeks
+	observers    []func(g Greeks)
+	chStop       chan int
+}
+
+func (a *GreeksAggregator) Init(futureTraders []common.FutureTrader, spotTraders []common.SpotTrader) {
+	a.options = make(map[string]OptionPosition)
+	a.futureTrader = futureTraders
+	a.spotTraders = spotTraders
+	a.greeks = make(map[string]Greeks)
+	a.chStop = make(chan int, 1)
+}
+
+// 注册敞口变化回调（对冲器）
+func (a *GreeksAggregator) AddObserver(fn func(g Greeks)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.observers = append(a.observers, fn)
+}
+
+// 期权持仓或标记希腊值更新时调用。Size为0表示平仓
+func (a *GreeksAggregator) UpdateOption(p OptionPosition) {
+	a.mu.Lock()
+	p.Underlying = strings.ToLower(p.Underlying)
+	if p.Size == 0 {
+		delete(a.options, p.InstId)
+	} else {
+		a.options[p.InstId] = p
+	}
+	a.mu.Unlock()
+
+	a.Refresh()
+}
+
+// 定时刷新线性部分（合约/现货持仓没有推送接口）
+func (a *GreeksAggregator) Go(interval time.Duration) {
+	go func() {
+		defer util.DefaultRecover()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.Refresh()
+			case <-a.chStop:
+				return
+			}
+		}
+	}()
+}
+
+func (a *GreeksAggregator) Stop() {
+	a.chStop <- 0
+}
+
+func (a *GreeksAggregator) Get(underlying string) (Greeks, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g, ok := a.greeks[strings.ToLower(underlying)]
+	return g, ok
+}
+
+func (a *GreeksAggregator) All() []Greeks {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	gs := make([]Greeks, 0, len(a.greeks))
+	for _, g := range a.greeks {
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+// 重新计算所有标的的敞口，并通知有变化的部分
+func (a *GreeksAggregator) Refresh() {
+	a.mu.Lock()
+	now := time.Now()
+	result := make(map[string]Greeks)
+	get := func(ul string) Greeks {
+		g, ok := result[ul]
+		if !ok {
+			g = Greeks{Underlying: ul, RefreshTime: now}
+		}
+		return g
+	}
+
+	for _, p := range a.options {
+		g := get(p.Underlying)
+		n := p.Size * p.Multiplier
+		g.OptionDelta += p.Delta * n
+		g.Gamma += p.Gamma * n
+		g.Vega += p.Vega * n
+		g.Theta += p.Theta * n
+		result[p.Underlying] = g
+	}
+
+	for _, tr := range a.futureTrader {
+		m := tr.FutureMarket()
+		g := get(strings.ToLower(m.Symbol()))
+		g.LinearDelta += futureDelta(tr.Position().Net(), m).InexactFloat64()
+		result[g.Underlying] = g
+	}
+
+	for _, tr := range a.spotTraders {
+		g := get(strings.ToLower(tr.SpotMarket().BaseCurrency()))
+		g.LinearDelta += tr.BaseBalance().Rights().InexactFloat64()
+		result[g.Underlying] = g
+	}
+
+	changed := []Greeks{}
+	for ul, g := range result {
+		g.Delta = g.OptionDelta + g.LinearDelta
+		result[ul] = g
+		if old, ok := a.greeks[ul]; !ok || !sameGreeks(old, g) {
+			changed = append(changed, g)
+		}
+	}
+	a.greeks = result
+	observers := a.observers
+	a.mu.Unlock()
+
+	for _, g := range changed {
+		for _, fn := range observers {
+			fn(g)
+		}
+	}
+}
+
+// 合约张数换算成标的币数量
+func futureDelta(net decimal.Decimal, m common.FutureMarket) decimal.Decimal {
+	if m.IsUsdtContract() {
+		return net.Mul(m.ValueAmount())
+	} else {
+		// 币本位合约面值为usd
+		px := m.MarkPrice()
+		if px.IsZero() {
+			return decimal.Zero
+		}
+		return net.Mul(m.ValueAmount()).Div(px)
+	}
+}
+
+func sameGreeks(a, b Greeks) bool {
+	return a.Delta == b.Delta && a.Gamma == b.Gamma && a.Vega == b.Vega && a.Theta == b.Theta
+}
@@ -0,0 +1,182 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-13 11:26:40
+- @Description: 波动率曲面。把各期权的标记隐含波动率汇总成 行权价x到期日 的曲面
+- 同一到期日内按行权价线性插值（两端平推），不同到期日之间按总方差(iv^2*t)线性插值
+- 超过有效期没有更新的点不参与计算
+- 目前还没有期权交易所的适配，由调用方把各交易所的标记iv喂进来
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package options
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 曲面上的一个点
+type VolPoint struct {
+	Expiry     time.Time
+	Strike     float64
+	IV         float64 // 标记隐含波动率，年化，如0.65
+	UpdateTime time.Time
+}
+
+// 一个到期日的波动率微笑
+type VolSmile struct {
+	Expiry time.Time
+	Points []VolPoint // 按行权价升序
+}
+
+type VolSurface struct {
+	mu         sync.Mutex
+	underlying string
+	maxAge     time.Duration
+	points     map[int64]map[float64]VolPoint // expiry(ms)-strike-point
+}
+
+func NewVolSurface(underlying string, maxAge time.Duration) *VolSurface {
+	s := new(VolSurface)
+	s.underlying = underlying
+	s.maxAge = maxAge
+	s.points = make(map[int64]map[float64]VolPoint)
+	return s
+}
+
+func (s *VolSurface) Underlying() string {
+	return s.underlying
+}
+
+// 更新一个点的iv
+func (s *VolSurface) Update(expiry time.Time, strike, iv float64, updateTime time.Time) {
+	if iv <= 0 || math.IsNaN(iv) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := expiry.UnixMilli()
+	if _, ok := s.points[ms]; !ok {
+		s.points[ms] = make(map[float64]VolPoint)
+	}
+	s.points[ms][strike] = VolPoint{Expiry: expiry, Strike: strike, IV: iv, UpdateTime: updateTime}
+}
+
+// 移除已到期的数据
+func (s *VolSurface) RemoveExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ms := range s.points {
+		if ms <= now.UnixMilli() {
+			delete(s.points, ms)
+		}
+	}
+}
+
+// 当前有效的曲面快照，按到期日升序
+func (s *VolSurface) Snapshot(now time.Time) []VolSmile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot(now)
+}
+
+func (s *VolSurface) snapshot(now time.Time) []VolSmile {
+	smiles := []VolSmile{}
+	for _, strikes := range s.points {
+		smile := VolSmile{}
+		for _, p := range strikes {
+			if s.maxAge > 0 && now.Sub(p.UpdateTime) > s.maxAge {
+				continue
+			}
+			if !p.Expiry.After(now) {
+				continue
+			}
+			smile.Expiry = p.Expiry
+			smile.Points = append(smile.Points, p)
+		}
+
+		if len(smile.Points) > 0 {
+			sort.Slice(smile.Points, func(i, j int) bool { return smile.Points[i].Strike < smile.Points[j].Strike })
+			smiles = append(smiles, smile)
+		}
+	}
+
+	sort.Slice(smiles, func(i, j int) bool { return smiles[i].Expiry.Before(smiles[j].Expiry) })
+	return smiles
+}
+
+// 查询任意 到期日/行权价 的iv
+func (s *VolSurface) IV(expiry time.Time, strike float64, now time.Time) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	smiles := s.snapshot(now)
+	if len(smiles) == 0 || !expiry.After(now) {
+		return 0, false
+	}
+
+	// 找到前后两个到期日
+	i := sort.Search(len(smiles), func(i int) bool { return !smiles[i].Expiry.Before(expiry) })
+	if i < len(smiles) && smiles[i].Expiry.Equal(expiry) {
+		return smiles[i].IV(strike), true
+	}
+
+	if i == 0 {
+		// 比最近的到期日还近，平推
+		return smiles[0].IV(strike), true
+	}
+
+	if i == len(smiles) {
+		// 比最远的到期日还远，平推
+		return smiles[len(smiles)-1].IV(strike), true
+	}
+
+	// 总方差线性插值
+	s0, s1 := smiles[i-1], smiles[i]
+	t0 := s0.Expiry.Sub(now).Hours() / 24 / 365
+	t1 := s1.Expiry.Sub(now).Hours() / 24 / 365
+	t := expiry.Sub(now).Hours() / 24 / 365
+	iv0, iv1 := s0.IV(strike), s1.IV(strike)
+	w0, w1 := iv0*iv0*t0, iv1*iv1*t1
+	w := w0 + (w1-w0)*(t-t0)/(t1-t0)
+	if w <= 0 || t <= 0 {
+		return 0, false
+	}
+	return math.Sqrt(w / t), true
+}
+
+// 对同一到期日的两个行权价iv之差，用于偏度交易
+func (s *VolSurface) Skew(expiry time.Time, strikeLow, strikeHigh float64, now time.Time) (float64, bool) {
+	ivLow, ok0 := s.IV(expiry, strikeLow, now)
+	ivHigh, ok1 := s.IV(expiry, strikeHigh, now)
+	if !ok0 || !ok1 {
+		return 0, false
+	}
+	return ivLow - ivHigh, true
+}
+
+// 微笑内按行权价线性插值，两端平推
+func (sm VolSmile) IV(strike float64) float64 {
+	n := len(sm.Points)
+	if n == 0 {
+		return 0
+	}
+
+	if strike <= sm.Points[0].Strike {
+		return sm.Points[0].IV
+	}
+
+	if strike >= sm.Points[n-1].Strike {
+		return sm.Points[n-1].IV
+	}
+
+	i := sort.Search(n, func(i int) bool { return sm.Points[i].Strike >= strike })
+	p0, p1 := sm.Points[i-1], sm.Points[i]
+	if p1.Strike == p0.Strike {
+		return p1.IV
+	}
+	return p0.IV + (p1.IV-p0.IV)*(strike-p0.Strike)/(p1.Strike-p0.Strike)
+}
@@ -0,0 +1,272 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-19 14:08:55
+- @Description: 回撤熔断。定时对账户权益(已添加的各币种余额按价格折算之和)采样，
+- 最近1天/7天内从最高点的回撤超过阈值时触发熔断：
+- 1. 暂停交易：拒绝所有非reduceOnly订单(作为下单前检查器，错误为LimitKind_Drawdown的*LimitError)
+- 2. 撤销已添加交易器的所有挂单
+- 3. 可选平仓：以reduceOnly市价单平掉已添加合约交易器的多空仓位，现货不处理
+- 4. 调用告警回调
+- 熔断后需调用Reset恢复交易，Reset会清空权益采样，从恢复时重新计算回撤
+- 有价格未就绪(<=0)或余额未就绪时跳过本次采样，避免行情缺失被当作回撤
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type BreakerConfig struct {
+	DailyDrawdown  decimal.Decimal `json:"daily_drawdown"`  // 1天内的最大回撤比例，如0.05，0表示不检查
+	WeeklyDrawdown decimal.Decimal `json:"weekly_drawdown"` // 7天内的最大回撤比例
+	Flatten        bool            `json:"flatten"`         // 触发时平掉合约仓位
+	IntervalSec    int             `json:"interval_sec"`    // 采样间隔，默认10秒
+}
+
+type BreakerAlert struct {
+	Time     time.Time
+	Window   string // daily/weekly
+	Peak     decimal.Decimal
+	Equity   decimal.Decimal
+	Drawdown decimal.Decimal
+	Limit    decimal.Decimal
+}
+
+type equitySample struct {
+	t time.Time
+	v decimal.Decimal
+}
+
+type breakerBalance struct {
+	b       common.Balance
+	fnPrice func() decimal.Decimal
+}
+
+type CircuitBreaker struct {
+	logPrefix string
+	cfg       BreakerConfig
+
+	mu       sync.Mutex
+	balances []breakerBalance
+	traders  []common.CommonTrader
+	samples  []equitySample
+	tripped  *BreakerAlert
+	fnAlert  func(a BreakerAlert)
+
+	chStop   chan int
+	stopOnce sync.Once
+}
+
+func (c *CircuitBreaker) Init(name string, cfg BreakerConfig) {
+	c.logPrefix = "breaker-" + name
+	c.cfg = cfg
+	c.cfg.IntervalSec = util.ValueIf(cfg.IntervalSec > 0, cfg.IntervalSec, 10)
+	c.chStop = make(chan int, 1)
+}
+
+// 计入权益的余额，fnPrice为该币种的价格，为nil时价格为1
+func (c *CircuitBreaker) AddBalance(b common.Balance, fnPrice func() decimal.Decimal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.balances = append(c.balances, breakerBalance{b: b, fnPrice: fnPrice})
+}
+
+// 熔断时撤单/平仓的交易器
+func (c *CircuitBreaker) AddTrader(t common.CommonTrader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.traders = append(c.traders, t)
+}
+
+func (c *CircuitBreaker) SetAlertFn(fn func(a BreakerAlert)) {
+	c.fnAlert = fn
+}
+
+func (c *CircuitBreaker) Go() {
+	common.AddPreTradeChecker(c)
+	logger.LogImportant(c.logPrefix, "started, daily=%v, weekly=%v, flatten=%v", c.cfg.DailyDrawdown, c.cfg.WeeklyDrawdown, c.cfg.Flatten)
+	go c.update()
+}
+
+// 可重复调用
+func (c *CircuitBreaker) Stop() {
+	c.stopOnce.Do(func() {
+		common.RemovePreTradeChecker(c)
+		c.chStop <- 0
+	})
+}
+
+// 已触发时返回触发信息
+func (c *CircuitBreaker) Tripped() (BreakerAlert, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tripped == nil {
+		return BreakerAlert{}, false
+	}
+	return *c.tripped, true
+}
+
+// 恢复交易
+func (c *CircuitBreaker) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tripped = nil
+	c.samples = nil
+	logger.LogImportant(c.logPrefix, "reset")
+}
+
+// 实现common.PreTradeChecker
+func (c *CircuitBreaker) CheckOrder(o *common.OrderImpl) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tripped != nil && !o.ReduceOnly {
+		return &LimitError{Kind: LimitKind_Drawdown, InstId: o.InstId, Value: c.tripped.Drawdown, Limit: c.tripped.Limit}
+	}
+	return nil
+}
+
+func (c *CircuitBreaker) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Duration(c.cfg.IntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.check(time.Now())
+		case <-c.chStop:
+			logger.LogImportant(c.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+// 需加锁调用。有价格或余额未就绪时返回false
+func (c *CircuitBreaker) equity() (decimal.Decimal, bool) {
+	total := decimal.Zero
+	for _, bb := range c.balances {
+		if r, ok := bb.b.(interface{ Ready() (bool, string) }); ok {
+			if ready, reason := r.Ready(); !ready {
+				logger.LogInfo(c.logPrefix, "balance of %s not ready(%s), skip sample", bb.b.Ccy(), reason)
+				return decimal.Zero, false
+			}
+		}
+
+		px := decimal.NewFromInt(1)
+		if bb.fnPrice != nil {
+			px = bb.fnPrice()
+		}
+
+		if !px.IsPositive() {
+			logger.LogInfo(c.logPrefix, "price of %s not ready, skip sample", bb.b.Ccy())
+			return decimal.Zero, false
+		}
+		total = total.Add(bb.b.Rights().Mul(px))
+	}
+	return total, true
+}
+
+// 需加锁调用。window内最高点到当前的回撤
+func (c *CircuitBreaker) drawdown(now time.Time, window time.Duration, cur decimal.Decimal) (peak, dd decimal.Decimal) {
+	peak = cur
+	for _, s := range c.samples {
+		if now.Sub(s.t) <= window && s.v.GreaterThan(peak) {
+			peak = s.v
+		}
+	}
+
+	if peak.IsPositive() {
+		dd = peak.Sub(cur).Div(peak)
+	}
+	return
+}
+
+func (c *CircuitBreaker) check(now time.Time) {
+	c.mu.Lock()
+	if c.tripped != nil || len(c.balances) == 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	cur, ok := c.equity()
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+
+	c.samples = append(c.samples, equitySample{t: now, v: cur})
+	for len(c.samples) > 0 && now.Sub(c.samples[0].t) > time.Hour*24*7 {
+		c.samples = c.samples[1:]
+	}
+
+	var alert *BreakerAlert
+	if c.cfg.DailyDrawdown.IsPositive() {
+		if peak, dd := c.drawdown(now, time.Hour*24, cur); dd.GreaterThan(c.cfg.DailyDrawdown) {
+			alert = &BreakerAlert{Time: now, Window: "daily", Peak: peak, Equity: cur, Drawdown: dd, Limit: c.cfg.DailyDrawdown}
+		}
+	}
+	if alert == nil && c.cfg.WeeklyDrawdown.IsPositive() {
+		if peak, dd := c.drawdown(now, time.Hour*24*7, cur); dd.GreaterThan(c.cfg.WeeklyDrawdown) {
+			alert = &BreakerAlert{Time: now, Window: "weekly", Peak: peak, Equity: cur, Drawdown: dd, Limit: c.cfg.WeeklyDrawdown}
+		}
+	}
+
+	c.tripped = alert
+	traders := append([]common.CommonTrader{}, c.traders...)
+	c.mu.Unlock()
+
+	if alert != nil {
+		c.trip(*alert, traders)
+	}
+}
+
+func (c *CircuitBreaker) trip(a BreakerAlert, traders []common.CommonTrader) {
+	logger.LogImportant(c.logPrefix, "triggered, %s drawdown=%v > %v, peak=%v, equity=%v", a.Window, a.Drawdown.Round(4), a.Limit, a.Peak, a.Equity)
+
+	for _, t := range traders {
+		for _, o := range t.Orders() {
+			o.Cancel()
+		}
+	}
+
+	if c.cfg.Flatten {
+		for _, t := range traders {
+			if ft, ok := t.(common.FutureTrader); ok {
+				c.flatten(ft)
+			}
+		}
+	}
+
+	if c.fnAlert != nil {
+		func() {
+			defer util.DefaultRecover()
+			c.fnAlert(a)
+		}()
+	}
+}
+
+func (c *CircuitBreaker) flatten(t common.FutureTrader) {
+	p := t.Position()
+	if p == nil {
+		return
+	}
+
+	if p.Long().IsPositive() {
+		if t.MakeMarketOrder(p.Long(), common.OrderDir_Sell, false, decimal.Zero, true, "breaker", nil) == nil {
+			logger.LogImportant(c.logPrefix, "flatten long of %s failed", t.String())
+		}
+	}
+
+	if p.Short().IsPositive() {
+		if t.MakeMarketOrder(p.Short(), common.OrderDir_Buy, false, decimal.Zero, true, "breaker", nil) == nil {
+			logger.LogImportant(c.logPrefix, "flatten short of %s failed", t.String())
+		}
+	}
+}
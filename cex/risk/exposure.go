@@ -0,0 +1,195 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-22 16:12:04
+- @Description: 跨品种敞口轧差。将已添加交易所中所有交易器的现货、永续、交割合约按标的币种合并，以币数量计算净敞口和总敞口
+- 1. 现货：基础币权益。同一交易所同一资产Id下的同一币种只计一次(多个交易对共用余额)
+- 2. U本位合约：净仓位*面值
+- 3. 币本位合约：净仓位*面值/标记价格，保证金币种的权益计入Margin(与现货同一资产Id时只计一次)
+- 标的币种、合约类型和面值来自交易所的品种信息(CEx.Instruments)
+- 价格优先取该币种的U本位/USD计价现货最新价，其次为合约标记价格
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/shopspring/decimal"
+)
+
+// 单个币种的敞口，数量单位均为币
+type CoinExposure struct {
+	Ccy        string          `json:"ccy"`
+	Spot       decimal.Decimal `json:"spot"`
+	Margin     decimal.Decimal `json:"margin"` // 币本位合约的保证金
+	Perp       decimal.Decimal `json:"perp"`
+	Futures    decimal.Decimal `json:"futures"`
+	Net        decimal.Decimal `json:"net"`   // 以上之和
+	Gross      decimal.Decimal `json:"gross"` // 各品种绝对值之和
+	Price      decimal.Decimal `json:"price"`
+	NetValue   decimal.Decimal `json:"net_value"`
+	GrossValue decimal.Decimal `json:"gross_value"`
+}
+
+func (e CoinExposure) String() string {
+	return fmt.Sprintf("%s: net=%v(%v) gross=%v(%v) [spot=%v margin=%v perp=%v futures=%v]",
+		e.Ccy, e.Net.Round(6), e.NetValue.Round(2), e.Gross.Round(6), e.GrossValue.Round(2), e.Spot.Round(6), e.Margin.Round(6), e.Perp.Round(6), e.Futures.Round(6))
+}
+
+type ExposureBook struct {
+	mu        sync.Mutex
+	exchanges []common.CEx
+}
+
+func NewExposureBook() *ExposureBook {
+	return new(ExposureBook)
+}
+
+func (b *ExposureBook) AddExchange(ex common.CEx) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.exchanges = append(b.exchanges, ex)
+}
+
+func isUsdQuote(ccy string) bool {
+	ccy = strings.ToLower(ccy)
+	return ccy == "usdt" || ccy == "usdc" || ccy == "usd"
+}
+
+func isPerp(ct common.ContractType) bool {
+	return ct == common.ContractType_UsdSwap || ct == common.ContractType_UsdtSwap
+}
+
+// 计算当前所有币种的敞口，按总敞口价值由大到小排列
+func (b *ExposureBook) Exposures() []CoinExposure {
+	b.mu.Lock()
+	exchanges := slices.Clone(b.exchanges)
+	b.mu.Unlock()
+
+	result := map[string]*CoinExposure{}
+	get := func(ccy string) *CoinExposure {
+		ccy = strings.ToLower(ccy)
+		e, ok := result[ccy]
+		if !ok {
+			e = &CoinExposure{Ccy: ccy}
+			result[ccy] = e
+		}
+		return e
+	}
+
+	gross := map[string]decimal.Decimal{}
+	addGross := func(ccy string, v decimal.Decimal) {
+		ccy = strings.ToLower(ccy)
+		gross[ccy] = gross[ccy].Add(v.Abs())
+	}
+
+	futurePx := map[string]decimal.Decimal{}
+	for _, ex := range exchanges {
+		insts := map[string]*common.Instruments{}
+		for _, inst := range ex.Instruments() {
+			insts[inst.Id] = inst
+		}
+
+		counted := map[string]bool{} // 资产Id+币种，避免重复计算共用的余额
+		countOnce := func(assetId int, ccy string) bool {
+			key := fmt.Sprintf("%d.%s", assetId, strings.ToLower(ccy))
+			if counted[key] {
+				return false
+			}
+			counted[key] = true
+			return true
+		}
+
+		for _, t := range ex.SpotTraders() {
+			m := t.SpotMarket()
+			base := m.BaseCurrency()
+			e := get(base)
+			if isUsdQuote(m.QuoteCurrency()) && e.Price.IsZero() {
+				e.Price = m.LatestPrice()
+			}
+
+			if bal := t.BaseBalance(); bal != nil && countOnce(t.AssetId(), base) {
+				e.Spot = e.Spot.Add(bal.Rights())
+				addGross(base, bal.Rights())
+			}
+		}
+
+		for _, t := range ex.FutureTraders() {
+			m := t.FutureMarket()
+			inst := insts[m.Type()]
+			if inst == nil {
+				continue
+			}
+
+			e := get(inst.CtSymbol)
+			px := m.MarkPrice()
+			if px.IsPositive() && futurePx[e.Ccy].IsZero() {
+				futurePx[e.Ccy] = px
+			}
+
+			net := decimal.Zero
+			if p := t.Position(); p != nil {
+				net = p.Net()
+			}
+
+			coins := net.Mul(inst.CtVal)
+			if !inst.IsUsdtContract {
+				coins = decimal.Zero
+				if px.IsPositive() {
+					coins = net.Mul(inst.CtVal).Div(px)
+				}
+
+				if bal := t.Balance(); bal != nil && strings.EqualFold(bal.Ccy(), inst.CtSymbol) && countOnce(t.AssetId(), bal.Ccy()) {
+					e.Margin = e.Margin.Add(bal.Rights())
+					addGross(e.Ccy, bal.Rights())
+				}
+			}
+
+			if isPerp(inst.CtType) {
+				e.Perp = e.Perp.Add(coins)
+			} else {
+				e.Futures = e.Futures.Add(coins)
+			}
+			addGross(e.Ccy, coins)
+		}
+	}
+
+	list := make([]CoinExposure, 0, len(result))
+	for ccy, e := range result {
+		if e.Price.IsZero() {
+			e.Price = futurePx[ccy]
+		}
+		e.Net = e.Spot.Add(e.Margin).Add(e.Perp).Add(e.Futures)
+		e.Gross = gross[ccy]
+		e.NetValue = e.Net.Mul(e.Price)
+		e.GrossValue = e.Gross.Mul(e.Price)
+		list = append(list, *e)
+	}
+
+	slices.SortFunc(list, func(a, b CoinExposure) int {
+		if c := b.GrossValue.Cmp(a.GrossValue); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Ccy, b.Ccy)
+	})
+	return list
+}
+
+// 某币种的敞口
+func (b *ExposureBook) Of(ccy string) CoinExposure {
+	for _, e := range b.Exposures() {
+		if strings.EqualFold(e.Ccy, ccy) {
+			return e
+		}
+	}
+	return CoinExposure{Ccy: strings.ToLower(ccy)}
+}
+
+// 使净敞口归零需要对冲的币数量，正数为买入
+func (b *ExposureBook) HedgeAmount(ccy string) decimal.Decimal {
+	return b.Of(ccy).Net.Neg()
+}
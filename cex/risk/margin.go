@@ -0,0 +1,180 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-23 14:52:40
+- @Description: 保证金监控。定时检查合约交易器的保证金率(FutureTrader.MarginInfo)和标记价格到强平价格的距离：
+- 1. 低于告警阈值时调用告警回调，恢复前不重复告警
+- 2. 低于动作阈值时自动降杠杆：以reduceOnly市价单平掉DeleverageRatio比例的仓位，两次动作之间至少间隔两个检查周期
+- 阈值为0表示不检查。okx的保证金率和强平价格来自account/position频道，回测见SimFutureTrader.MarginInfo
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type MarginMonitorConfig struct {
+	WarnRatio       decimal.Decimal `json:"warn_ratio"`       // 保证金率告警阈值，如3
+	ActionRatio     decimal.Decimal `json:"action_ratio"`     // 保证金率降杠杆阈值，如1.5
+	WarnDistance    decimal.Decimal `json:"warn_distance"`    // 强平距离告警阈值，如0.1
+	ActionDistance  decimal.Decimal `json:"action_distance"`  // 强平距离降杠杆阈值，如0.05
+	DeleverageRatio decimal.Decimal `json:"deleverage_ratio"` // 每次降杠杆平掉的仓位比例，0表示只告警
+	IntervalSec     int             `json:"interval_sec"`     // 检查间隔，默认5秒
+}
+
+type MarginAlert struct {
+	Time   time.Time
+	Trader common.FutureTrader
+	Info   common.MarginInfo
+	Action bool // 是否触发了降杠杆
+	Reason string
+}
+
+type marginState struct {
+	t          common.FutureTrader
+	warned     bool
+	lastAction time.Time
+}
+
+type MarginMonitor struct {
+	logPrefix string
+	cfg       MarginMonitorConfig
+
+	mu      sync.Mutex
+	traders []*marginState
+	fnAlert func(a MarginAlert)
+
+	chStop chan int
+}
+
+func (m *MarginMonitor) Init(name string, cfg MarginMonitorConfig) {
+	m.logPrefix = "margin-" + name
+	m.cfg = cfg
+	m.cfg.IntervalSec = util.ValueIf(cfg.IntervalSec > 0, cfg.IntervalSec, 5)
+	m.chStop = make(chan int, 1)
+}
+
+func (m *MarginMonitor) AddTrader(t common.FutureTrader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.traders = append(m.traders, &marginState{t: t})
+}
+
+func (m *MarginMonitor) SetAlertFn(fn func(a MarginAlert)) {
+	m.fnAlert = fn
+}
+
+func (m *MarginMonitor) Go() {
+	logger.LogImportant(m.logPrefix, "started, warn=%v/%v, action=%v/%v", m.cfg.WarnRatio, m.cfg.WarnDistance, m.cfg.ActionRatio, m.cfg.ActionDistance)
+	go m.update()
+}
+
+func (m *MarginMonitor) Stop() {
+	m.chStop <- 0
+}
+
+func (m *MarginMonitor) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Duration(m.cfg.IntervalSec) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.check(time.Now())
+		case <-m.chStop:
+			logger.LogImportant(m.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+// 返回是否低于告警阈值、是否低于动作阈值及原因
+func (m *MarginMonitor) evaluate(mi common.MarginInfo) (warn, action bool, reason string) {
+	below := func(v, limit decimal.Decimal) bool {
+		return limit.IsPositive() && v.LessThan(limit)
+	}
+
+	if mi.MarginRatio.IsPositive() {
+		if below(mi.MarginRatio, m.cfg.ActionRatio) {
+			return true, true, fmt.Sprintf("margin ratio %v < %v", mi.MarginRatio.Round(3), m.cfg.ActionRatio)
+		} else if below(mi.MarginRatio, m.cfg.WarnRatio) {
+			warn, reason = true, fmt.Sprintf("margin ratio %v < %v", mi.MarginRatio.Round(3), m.cfg.WarnRatio)
+		}
+	}
+
+	if dist, ok := mi.LiqDistance(); ok {
+		if below(dist, m.cfg.ActionDistance) {
+			return true, true, fmt.Sprintf("liquidation distance %v < %v", dist.Round(4), m.cfg.ActionDistance)
+		} else if !warn && below(dist, m.cfg.WarnDistance) {
+			warn, reason = true, fmt.Sprintf("liquidation distance %v < %v", dist.Round(4), m.cfg.WarnDistance)
+		}
+	}
+	return
+}
+
+func (m *MarginMonitor) check(now time.Time) {
+	m.mu.Lock()
+	states := append([]*marginState{}, m.traders...)
+	m.mu.Unlock()
+
+	for _, st := range states {
+		p := st.t.Position()
+		if p == nil || p.Net().IsZero() {
+			st.warned = false
+			continue
+		}
+
+		mi := st.t.MarginInfo()
+		warn, action, reason := m.evaluate(mi)
+		if !warn {
+			if st.warned {
+				logger.LogImportant(m.logPrefix, "%s recovered", st.t.FutureMarket().Type())
+			}
+			st.warned = false
+			continue
+		}
+
+		interval := time.Duration(m.cfg.IntervalSec) * time.Second
+		action = action && m.cfg.DeleverageRatio.IsPositive() && now.Sub(st.lastAction) >= interval*2
+		if st.warned && !action {
+			continue
+		}
+
+		st.warned = true
+		logger.LogImportant(m.logPrefix, "%s: %s, equity=%v, maint=%v, mark=%v, liq=%v", st.t.FutureMarket().Type(), reason, mi.Equity, mi.MaintMargin, mi.MarkPrice, mi.LiqPrice)
+		if action {
+			st.lastAction = now
+			m.deleverage(st.t, p)
+		}
+
+		if m.fnAlert != nil {
+			func() {
+				defer util.DefaultRecover()
+				m.fnAlert(MarginAlert{Time: now, Trader: st.t, Info: mi, Action: action, Reason: reason})
+			}()
+		}
+	}
+}
+
+func (m *MarginMonitor) deleverage(t common.FutureTrader, p common.Position) {
+	net := p.Net()
+	sz := t.FutureMarket().AlignSize(net.Abs().Mul(m.cfg.DeleverageRatio))
+	if sz.LessThan(t.FutureMarket().MinSize()) {
+		sz = net.Abs()
+	}
+
+	dir := util.ValueIf(net.IsPositive(), common.OrderDir_Sell, common.OrderDir_Buy)
+	if t.MakeMarketOrder(sz, dir, false, decimal.Zero, true, "deleverage", nil) == nil {
+		logger.LogImportant(m.logPrefix, "deleverage %s failed, size=%v", t.FutureMarket().Type(), sz)
+	} else {
+		logger.LogImportant(m.logPrefix, "deleverage %s, %s %v", t.FutureMarket().Type(), common.OrderDir2Str(dir), sz)
+	}
+}
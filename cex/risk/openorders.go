@@ -0,0 +1,153 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-26 14:40:18
+- @Description: 挂单数量限制。交易所对单品种/单账户的挂单数量有上限，超出时下单会被拒绝，白白消耗下单频率
+- 本地统计未完成订单的数量，新订单会超出限制时直接拒绝：
+- 1. 单品种：下单交易器的未完成订单数
+- 2. 单账户：所有检查过的交易器，以及AddTrader添加的交易器的未完成订单数之和。一个账户使用一个OpenOrderLimiter
+- 限制中预留Headroom个名额给reduceOnly订单，保证需要平仓时总能下单
+- 拒绝时返回*LimitError(Kind为LimitKind_OpenOrders/LimitKind_AccountOpenOrders，Value为下单后的挂单数，Limit为可用上限)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 0表示不限
+type OpenOrderLimits struct {
+	MaxPerInst    int `json:"max_per_inst"`
+	MaxPerAccount int `json:"max_per_account"`
+	Headroom      int `json:"headroom"` // 预留给reduceOnly订单的名额
+}
+
+// 各交易所公布的挂单上限，可作为默认配置
+var VenueOpenOrderLimits = map[string]OpenOrderLimits{
+	"OKEx":    {MaxPerInst: 500, MaxPerAccount: 4000},
+	"Binance": {MaxPerInst: 200},
+}
+
+type OpenOrderLimiter struct {
+	mu          sync.Mutex
+	limits      OpenOrderLimits
+	instLimits  map[string]int // 按品种Id覆盖单品种上限
+	traders     map[common.CommonTrader]bool
+	rejectCount int
+}
+
+func NewOpenOrderLimiter(limits OpenOrderLimits) *OpenOrderLimiter {
+	l := new(OpenOrderLimiter)
+	l.limits = limits
+	l.instLimits = make(map[string]int)
+	l.traders = make(map[common.CommonTrader]bool)
+	return l
+}
+
+// 开始检查所有下单
+func (l *OpenOrderLimiter) Enable() {
+	common.AddPreTradeChecker(l)
+	logger.LogImportant(logPrefix, "open order limit enabled, %+v", l.limits)
+}
+
+func (l *OpenOrderLimiter) Disable() {
+	common.RemovePreTradeChecker(l)
+	logger.LogImportant(logPrefix, "open order limit disabled")
+}
+
+// 添加同一账户下的交易器，其挂单计入账户挂单数
+func (l *OpenOrderLimiter) AddTrader(t common.CommonTrader) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.traders[t] = true
+}
+
+func (l *OpenOrderLimiter) SetLimits(limits OpenOrderLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+}
+
+func (l *OpenOrderLimiter) SetInstLimit(instId string, max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.instLimits[instId] = max
+}
+
+// 被拒绝的订单总数
+func (l *OpenOrderLimiter) RejectCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rejectCount
+}
+
+// 交易器的未完成订单数
+func openOrderCount(t common.CommonTrader) int {
+	n := 0
+	for _, o := range t.Orders() {
+		if !o.IsFinished() {
+			n++
+		}
+	}
+	return n
+}
+
+// 实现common.PreTradeChecker
+func (l *OpenOrderLimiter) CheckOrder(o *common.OrderImpl) error {
+	err := l.Check(o.Trader, o.InstId, o.ReduceOnly)
+	if err != nil {
+		l.mu.Lock()
+		l.rejectCount++
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// 检查一个订单是否可以下单，不下单。策略可以用来提前判断
+func (l *OpenOrderLimiter) Check(t common.CommonTrader, instId string, reduceOnly bool) error {
+	if t == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.traders[t] = true
+	limits := l.limits
+	maxInst := limits.MaxPerInst
+	if v, ok := l.instLimits[instId]; ok {
+		maxInst = v
+	}
+	traders := make([]common.CommonTrader, 0, len(l.traders))
+	for tr := range l.traders {
+		traders = append(traders, tr)
+	}
+	l.mu.Unlock()
+
+	// reduceOnly订单可以使用预留名额
+	fnLimit := func(max int) int {
+		if reduceOnly {
+			return max
+		}
+		return max - limits.Headroom
+	}
+
+	instCount := openOrderCount(t) + 1
+	if maxInst > 0 && instCount > fnLimit(maxInst) {
+		return &LimitError{Kind: LimitKind_OpenOrders, InstId: instId, Value: decimal.NewFromInt(int64(instCount)), Limit: decimal.NewFromInt(int64(fnLimit(maxInst)))}
+	}
+
+	if limits.MaxPerAccount > 0 {
+		accCount := 1
+		for _, tr := range traders {
+			accCount += openOrderCount(tr)
+		}
+
+		if accCount > fnLimit(limits.MaxPerAccount) {
+			return &LimitError{Kind: LimitKind_AccountOpenOrders, InstId: instId, Value: decimal.NewFromInt(int64(accCount)), Limit: decimal.NewFromInt(int64(fnLimit(limits.MaxPerAccount)))}
+		}
+	}
+	return nil
+}
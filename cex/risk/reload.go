@@ -0,0 +1,326 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-27 10:21:36
+- @Description: 风控限制热更新。定时从配置源(文件或redis的某个key)读取LimitsDoc，内容变化时：
+- 1. 校验，不通过则保留原有限制
+- 2. 通过则应用到Manager(持仓/价值限制)、OpenOrderLimiter(挂单数限制)，以及总开关KillSwitch
+- 3. 记录审计日志：谁(UpdatedBy)、何时、为什么(Reason)、改了什么，是否被接受。写入日志，可选追加到文件(每行一条json)
+- KillSwitch打开时拒绝所有非reduceOnly订单(作为下单前检查器，错误为LimitKind_KillSwitch的*LimitError)
+- 配置中没有的部分(nil)不修改
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 可热更新的风控配置
+type LimitsDoc struct {
+	Risk       *Config          `json:"risk"`        // Manager的限制
+	OpenOrders *OpenOrderLimits `json:"open_orders"` // OpenOrderLimiter的限制
+	KillSwitch bool             `json:"kill_switch"` // 为true时拒绝所有非reduceOnly订单
+	UpdatedBy  string           `json:"updated_by"`  // 修改人
+	Reason     string           `json:"reason"`      // 修改原因
+}
+
+func (d LimitsDoc) Validate() error {
+	if d.Risk != nil {
+		if d.Risk.MaxTotalValue.IsNegative() {
+			return fmt.Errorf("max_total_value can't be negative")
+		}
+
+		if err := d.Risk.Default.validate(); err != nil {
+			return fmt.Errorf("default: %s", err.Error())
+		}
+
+		for instId, l := range d.Risk.Instruments {
+			if err := l.validate(); err != nil {
+				return fmt.Errorf("%s: %s", instId, err.Error())
+			}
+		}
+	}
+
+	if d.OpenOrders != nil {
+		o := d.OpenOrders
+		if o.MaxPerInst < 0 || o.MaxPerAccount < 0 || o.Headroom < 0 {
+			return fmt.Errorf("open order limits can't be negative")
+		}
+
+		if (o.MaxPerInst > 0 && o.Headroom >= o.MaxPerInst) || (o.MaxPerAccount > 0 && o.Headroom >= o.MaxPerAccount) {
+			return fmt.Errorf("headroom %d leaves no room for orders", o.Headroom)
+		}
+	}
+	return nil
+}
+
+func (l Limits) validate() error {
+	if l.MaxNetPosition.IsNegative() || l.MaxGrossPosition.IsNegative() || l.MaxOrderValue.IsNegative() {
+		return fmt.Errorf("limits can't be negative")
+	}
+
+	if l.MaxGrossPosition.IsPositive() && l.MaxNetPosition.GreaterThan(l.MaxGrossPosition) {
+		return fmt.Errorf("max_net_position %v > max_gross_position %v", l.MaxNetPosition, l.MaxGrossPosition)
+	}
+	return nil
+}
+
+// 配置源
+type LimitsSource interface {
+	Name() string
+	Load() (string, error)
+}
+
+type fileLimitsSource struct {
+	path string
+}
+
+func NewFileLimitsSource(path string) LimitsSource {
+	return &fileLimitsSource{path: path}
+}
+
+func (s *fileLimitsSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s *fileLimitsSource) Load() (string, error) {
+	b, err := os.ReadFile(s.path)
+	return string(b), err
+}
+
+type redisLimitsSource struct {
+	rc  *util.RedisClient
+	key string
+}
+
+func NewRedisLimitsSource(rc *util.RedisClient, key string) LimitsSource {
+	return &redisLimitsSource{rc: rc, key: key}
+}
+
+func (s *redisLimitsSource) Name() string {
+	return "redis:" + s.key
+}
+
+func (s *redisLimitsSource) Load() (string, error) {
+	if v, ok := s.rc.Get(s.key); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("get %s failed", s.key)
+}
+
+// 审计记录
+type LimitsAudit struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	UpdatedBy string    `json:"updated_by"`
+	Reason    string    `json:"reason"`
+	Changes   []string  `json:"changes"`
+	Accepted  bool      `json:"accepted"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type LimitsReloader struct {
+	logPrefix string
+	src       LimitsSource
+	interval  time.Duration
+
+	mu        sync.Mutex
+	mgr       *Manager
+	ool       *OpenOrderLimiter
+	raw       string
+	current   LimitsDoc
+	auditFile string
+	fnAudit   func(a LimitsAudit)
+
+	chStop chan int
+}
+
+func (r *LimitsReloader) Init(name string, src LimitsSource, intervalSec int) {
+	r.logPrefix = "limits-" + name
+	r.src = src
+	r.interval = time.Second * time.Duration(util.ValueIf(intervalSec > 0, intervalSec, 5))
+	r.chStop = make(chan int, 1)
+}
+
+// 应用到的Manager/OpenOrderLimiter，为nil时不应用对应部分
+func (r *LimitsReloader) SetManager(m *Manager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mgr = m
+}
+
+func (r *LimitsReloader) SetOpenOrderLimiter(l *OpenOrderLimiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ool = l
+}
+
+// 审计日志同时追加到文件
+func (r *LimitsReloader) SetAuditFile(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auditFile = path
+}
+
+func (r *LimitsReloader) SetAuditFn(fn func(a LimitsAudit)) {
+	r.fnAudit = fn
+}
+
+func (r *LimitsReloader) Go() {
+	common.AddPreTradeChecker(r)
+	logger.LogImportant(r.logPrefix, "started, source=%s, interval=%v", r.src.Name(), r.interval)
+	r.reload()
+	go r.update()
+}
+
+func (r *LimitsReloader) Stop() {
+	common.RemovePreTradeChecker(r)
+	r.chStop <- 0
+}
+
+// 当前生效的配置
+func (r *LimitsReloader) Current() LimitsDoc {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// 实现common.PreTradeChecker
+func (r *LimitsReloader) CheckOrder(o *common.OrderImpl) error {
+	r.mu.Lock()
+	killed := r.current.KillSwitch
+	r.mu.Unlock()
+	if killed && !o.ReduceOnly {
+		return &LimitError{Kind: LimitKind_KillSwitch, InstId: o.InstId, Value: decimal.NewFromInt(1), Limit: decimal.Zero}
+	}
+	return nil
+}
+
+func (r *LimitsReloader) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reload()
+		case <-r.chStop:
+			logger.LogImportant(r.logPrefix, "stopped")
+			return
+		}
+	}
+}
+
+// 新旧配置的差异，每项一条
+func limitsChanges(old, cur LimitsDoc) []string {
+	var changes []string
+	fnCmp := func(name string, o, n interface{}) {
+		bo, _ := json.Marshal(o)
+		bn, _ := json.Marshal(n)
+		if string(bo) != string(bn) {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, string(bo), string(bn)))
+		}
+	}
+
+	if cur.Risk != nil {
+		fnCmp("risk", old.Risk, cur.Risk)
+	}
+	if cur.OpenOrders != nil {
+		fnCmp("open_orders", old.OpenOrders, cur.OpenOrders)
+	}
+	fnCmp("kill_switch", old.KillSwitch, cur.KillSwitch)
+	return changes
+}
+
+func (r *LimitsReloader) reload() {
+	raw, err := r.src.Load()
+	if err != nil {
+		logger.LogInfo(r.logPrefix, "load from %s failed: %s", r.src.Name(), err.Error())
+		return
+	}
+
+	r.mu.Lock()
+	if raw == r.raw {
+		r.mu.Unlock()
+		return
+	}
+	r.raw = raw
+	old := r.current
+	r.mu.Unlock()
+
+	doc := LimitsDoc{}
+	audit := LimitsAudit{Time: time.Now(), Source: r.src.Name()}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		audit.Error = "invalid json: " + err.Error()
+	} else {
+		audit.Changes = limitsChanges(old, doc)
+		if err := doc.Validate(); err != nil {
+			audit.Error = err.Error()
+		}
+	}
+
+	audit.UpdatedBy = doc.UpdatedBy
+	audit.Reason = doc.Reason
+	audit.Accepted = len(audit.Error) == 0
+
+	if audit.Accepted {
+		r.apply(doc)
+	}
+	r.audit(audit)
+}
+
+func (r *LimitsReloader) apply(doc LimitsDoc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 未配置的部分保持原值
+	if doc.Risk == nil {
+		doc.Risk = r.current.Risk
+	} else if r.mgr != nil {
+		r.mgr.SetConfig(*doc.Risk)
+	}
+
+	if doc.OpenOrders == nil {
+		doc.OpenOrders = r.current.OpenOrders
+	} else if r.ool != nil {
+		r.ool.SetLimits(*doc.OpenOrders)
+	}
+
+	r.current = doc
+}
+
+func (r *LimitsReloader) audit(a LimitsAudit) {
+	if a.Accepted {
+		logger.LogImportant(r.logPrefix, "limits updated by [%s], reason=[%s], changes=%v", a.UpdatedBy, a.Reason, a.Changes)
+	} else {
+		logger.LogImportant(r.logPrefix, "limits update by [%s] rejected: %s, changes=%v", a.UpdatedBy, a.Error, a.Changes)
+	}
+
+	r.mu.Lock()
+	path := r.auditFile
+	r.mu.Unlock()
+	if len(path) > 0 {
+		b, _ := json.Marshal(a)
+		util.MakeSureDirForFile(path)
+		if f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err == nil {
+			f.Write(append(b, '\n'))
+			f.Close()
+		} else {
+			logger.LogImportant(r.logPrefix, "write audit file failed: %s", err.Error())
+		}
+	}
+
+	if r.fnAudit != nil {
+		r.fnAudit(a)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-18 16:25:37
+- @Description: 下单前风控。Manager注册为common的下单前检查器后，所有交易所(含回测)、所有策略的下单都会经过检查：
+- 1. 单品种最大净持仓、最大总持仓(多+空，仅合约)，按最坏情况估算：当前持仓+同方向未完成订单+本订单
+- 2. 单笔订单最大价值
+- 3. 所有品种总价值上限：各交易器的持仓价值+未完成订单价值+本订单价值。交易器在首次检查时登记，Uninit交易器前应调用RemoveTrader移出统计
+- 持仓/数量的单位与下单数量一致(现货为基础币，合约为张)。现货的持仓为基础币权益
+- 价值：现货为价格*数量，U本位合约为价格*数量*面值，币本位合约为数量*面值(美元)，不做币种换算
+- reduceOnly订单只减少风险，不检查持仓和价值。合约平仓单建议使用reduceOnly，否则可能被总持仓限制拒绝
+- 拒绝时返回*LimitError，可用errors.As取出，也可订阅事件总线的EventType_OrderRejected
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+const logPrefix = "risk"
+
+type LimitKind int
+
+const (
+	LimitKind_OrderValue        LimitKind = iota // 单笔订单价值
+	LimitKind_NetPosition                        // 单品种净持仓
+	LimitKind_GrossPosition                      // 单品种总持仓
+	LimitKind_TotalValue                         // 所有品种总价值
+	LimitKind_Drawdown                           // 回撤熔断，见breaker.go
+	LimitKind_SelfTrade                          // 自成交，见selftrade.go
+	LimitKind_OpenOrders                         // 单品种挂单数，见openorders.go
+	LimitKind_AccountOpenOrders                  // 单账户挂单数
+	LimitKind_KillSwitch                         // 总开关，见reload.go
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitKind_OrderValue:
+		return "max_order_value"
+	case LimitKind_NetPosition:
+		return "max_net_position"
+	case LimitKind_GrossPosition:
+		return "max_gross_position"
+	case LimitKind_TotalValue:
+		return "max_total_value"
+	case LimitKind_Drawdown:
+		return "max_drawdown"
+	case LimitKind_SelfTrade:
+		return "self_trade"
+	case LimitKind_OpenOrders:
+		return "max_open_orders"
+	case LimitKind_AccountOpenOrders:
+		return "max_account_open_orders"
+	case LimitKind_KillSwitch:
+		return "kill_switch"
+	default:
+		return "unknown"
+	}
+}
+
+// 风控拒绝的错误
+type LimitError struct {
+	Kind    LimitKind
+	InstId  string
+	Value   decimal.Decimal // 下单后的估算值
+	Limit   decimal.Decimal
+	Suggest decimal.Decimal // 自成交时的建议价格，为0表示没有
+}
+
+func (e *LimitError) Error() string {
+	if e.Kind == LimitKind_SelfTrade {
+		if e.Suggest.IsPositive() {
+			return fmt.Sprintf("self trade, inst=%s, price=%v, resting=%v, suggest=%v", e.InstId, e.Value, e.Limit, e.Suggest)
+		}
+		return fmt.Sprintf("self trade, inst=%s, price=%v, resting=%v", e.InstId, e.Value, e.Limit)
+	} else if e.Kind == LimitKind_KillSwitch {
+		return fmt.Sprintf("kill switch on, inst=%s", e.InstId)
+	}
+	return fmt.Sprintf("%s exceeded, inst=%s, value=%v, limit=%v", e.Kind.String(), e.InstId, e.Value, e.Limit)
+}
+
+// err是否为风控拒绝
+func AsLimitError(err error) (*LimitError, bool) {
+	var le *LimitError
+	ok := errors.As(err, &le)
+	return le, ok
+}
+
+// 0表示不限
+type Limits struct {
+	MaxNetPosition   decimal.Decimal `json:"max_net_position"`
+	MaxGrossPosition decimal.Decimal `json:"max_gross_position"`
+	MaxOrderValue    decimal.Decimal `json:"max_order_value"`
+}
+
+type Config struct {
+	Default       Limits            `json:"default"`         // 所有品种的默认限制
+	Instruments   map[string]Limits `json:"instruments"`     // 按品种Id覆盖默认限制
+	MaxTotalValue decimal.Decimal   `json:"max_total_value"` // 所有品种的总价值上限
+}
+
+type Manager struct {
+	mu      sync.Mutex
+	cfg     Config
+	traders map[common.CommonTrader]*common.Instruments // 检查过的交易器及其品种，用于统计总价值
+}
+
+func NewManager(cfg Config) *Manager {
+	m := new(Manager)
+	m.cfg = cfg
+	if m.cfg.Instruments == nil {
+		m.cfg.Instruments = make(map[string]Limits)
+	}
+	m.traders = make(map[common.CommonTrader]*common.Instruments)
+	return m
+}
+
+// 开始检查所有下单
+func (m *Manager) Enable() {
+	common.AddPreTradeChecker(m)
+	logger.LogImportant(logPrefix, "enabled")
+}
+
+func (m *Manager) Disable() {
+	common.RemovePreTradeChecker(m)
+	logger.LogImportant(logPrefix, "disabled")
+}
+
+// 运行时修改某品种的限制
+func (m *Manager) SetLimits(instId string, l Limits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg.Instruments[instId] = l
+}
+
+// 整体替换配置
+func (m *Manager) SetConfig(cfg Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = cfg
+	if m.cfg.Instruments == nil {
+		m.cfg.Instruments = make(map[string]Limits)
+	}
+}
+
+// 交易器不再使用时移出总价值统计
+func (m *Manager) RemoveTrader(t common.CommonTrader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.traders, t)
+}
+
+func (m *Manager) SetMaxTotalValue(v decimal.Decimal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg.MaxTotalValue = v
+}
+
+func (m *Manager) limitsOf(instId string) Limits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.cfg.Instruments[instId]; ok {
+		return l
+	}
+	return m.cfg.Default
+}
+
+// #region 价值和持仓
+// 单位数量的价值计算
+func valueFn(inst *common.Instruments) func(px, sz decimal.Decimal) decimal.Decimal {
+	if inst == nil || inst.CtType == "" {
+		return func(px, sz decimal.Decimal) decimal.Decimal { return px.Mul(sz) }
+	} else if inst.IsUsdtContract {
+		return func(px, sz decimal.Decimal) decimal.Decimal { return px.Mul(sz).Mul(inst.CtVal) }
+	} else {
+		return func(px, sz decimal.Decimal) decimal.Decimal { return sz.Mul(inst.CtVal) }
+	}
+}
+
+// 当前持仓：净持仓、总持仓、估值价格
+func positionOf(t common.CommonTrader) (net, gross, px decimal.Decimal) {
+	px = t.Market().LatestPrice()
+	if ft, ok := t.(common.FutureTrader); ok {
+		if p := ft.Position(); p != nil {
+			net = p.Net()
+			gross = p.Long().Add(p.Short())
+		}
+		if mp := ft.FutureMarket().MarkPrice(); mp.IsPositive() {
+			px = mp
+		}
+	} else if st, ok := t.(common.SpotTrader); ok {
+		if b := st.BaseBalance(); b != nil {
+			net = b.Rights()
+			gross = net.Abs()
+		}
+	}
+	return
+}
+
+// 未完成订单的未成交数量
+func openOrders(t common.CommonTrader) (buy, sell decimal.Decimal, orders []common.Order) {
+	for _, o := range t.Orders() {
+		if o.IsFinished() {
+			continue
+		}
+
+		orders = append(orders, o)
+		if o.GetDir() == common.OrderDir_Buy {
+			buy = buy.Add(o.GetUnfilled())
+		} else {
+			sell = sell.Add(o.GetUnfilled())
+		}
+	}
+	return
+}
+
+// 某交易器的持仓价值+未完成订单价值
+func (m *Manager) traderValue(t common.CommonTrader, fnValue func(px, sz decimal.Decimal) decimal.Decimal) decimal.Decimal {
+	net, _, px := positionOf(t)
+	total := fnValue(px, net.Abs())
+	_, _, orders := openOrders(t)
+	for _, o := range orders {
+		total = total.Add(fnValue(o.GetPrice(), o.GetUnfilled()))
+	}
+	return total
+}
+
+// #endregion
+
+// 实现common.PreTradeChecker
+func (m *Manager) CheckOrder(o *common.OrderImpl) error {
+	return m.Check(o.Trader, o.InstrumentMgr.Get(o.InstId), o.InstId, o.Price, o.Size, o.Dir, o.ReduceOnly)
+}
+
+// 检查一个订单是否可以下单，不下单。策略可以用来提前判断
+func (m *Manager) Check(t common.CommonTrader, inst *common.Instruments, instId string, price, size decimal.Decimal, dir common.OrderDir, reduceOnly bool) error {
+	if reduceOnly || t == nil {
+		return nil
+	}
+
+	l := m.limitsOf(instId)
+	fnValue := valueFn(inst)
+	value := fnValue(price, size)
+	if l.MaxOrderValue.IsPositive() && value.GreaterThan(l.MaxOrderValue) {
+		return &LimitError{Kind: LimitKind_OrderValue, InstId: instId, Value: value, Limit: l.MaxOrderValue}
+	}
+
+	if l.MaxNetPosition.IsPositive() || l.MaxGrossPosition.IsPositive() {
+		net, gross, _ := positionOf(t)
+		buy, sell, _ := openOrders(t)
+		if l.MaxNetPosition.IsPositive() {
+			projected := net.Add(buy).Add(size)
+			if dir == common.OrderDir_Sell {
+				projected = net.Sub(sell).Sub(size)
+			}
+
+			// 只拒绝使净持仓超限且变大的订单
+			if projected.Abs().GreaterThan(l.MaxNetPosition) && projected.Abs().GreaterThan(net.Abs()) {
+				return &LimitError{Kind: LimitKind_NetPosition, InstId: instId, Value: projected, Limit: l.MaxNetPosition}
+			}
+		}
+
+		if _, ok := t.(common.FutureTrader); ok && l.MaxGrossPosition.IsPositive() {
+			projected := gross.Add(buy).Add(sell).Add(size)
+			if projected.GreaterThan(l.MaxGrossPosition) {
+				return &LimitError{Kind: LimitKind_GrossPosition, InstId: instId, Value: projected, Limit: l.MaxGrossPosition}
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.traders[t] = inst
+	maxTotal := m.cfg.MaxTotalValue
+	traders := make(map[common.CommonTrader]*common.Instruments, len(m.traders))
+	for k, v := range m.traders {
+		traders[k] = v
+	}
+	m.mu.Unlock()
+
+	if maxTotal.IsPositive() {
+		total := value
+		for tr, trInst := range traders {
+			total = total.Add(m.traderValue(tr, valueFn(trInst)))
+		}
+
+		if total.GreaterThan(maxTotal) {
+			return &LimitError{Kind: LimitKind_TotalValue, InstId: instId, Value: total, Limit: maxTotal}
+		}
+	}
+	return nil
+}
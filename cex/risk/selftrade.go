@@ -0,0 +1,162 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-24 15:08:26
+- @Description: 自成交防护。同一账户的多个策略在同一品种上报价时，新订单的价格如果会与自己的挂单成交，按配置处理：
+- 1. STPMode_Reject：拒绝新订单
+- 2. STPMode_PriceAround：拒绝新订单，并在错误中给出不会自成交的建议价格(买单为最低卖单价-tick，卖单为最高买单价+tick)，由策略决定是否按建议价重新下单。不修改订单价格
+- 3. STPMode_CancelResting：撤销会与之成交的挂单，新订单照常下单。撤单为异步的，撤单完成前仍有小概率成交
+- 自己的挂单来自下单交易器的Orders()，以及AddTrader添加的、同一品种的其他交易器(同一账户下不同实例的交易器)
+- 策略通过stratergy下单时，各策略共用底层交易器，因此天然覆盖跨策略的情况
+- 拒绝时返回*LimitError(Kind为LimitKind_SelfTrade，Value为新订单价格，Limit为挂单价格，PriceAround模式下Suggest为建议价格)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package risk
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type STPMode int
+
+const (
+	STPMode_Reject STPMode = iota
+	STPMode_PriceAround
+	STPMode_CancelResting
+)
+
+func (m STPMode) String() string {
+	switch m {
+	case STPMode_Reject:
+		return "reject"
+	case STPMode_PriceAround:
+		return "price_around"
+	case STPMode_CancelResting:
+		return "cancel_resting"
+	default:
+		return "unknown"
+	}
+}
+
+type SelfTradePreventer struct {
+	mu      sync.Mutex
+	mode    STPMode
+	traders []common.CommonTrader
+}
+
+func NewSelfTradePreventer(mode STPMode) *SelfTradePreventer {
+	p := new(SelfTradePreventer)
+	p.mode = mode
+	return p
+}
+
+// 开始检查所有下单
+func (p *SelfTradePreventer) Enable() {
+	common.AddPreTradeChecker(p)
+	logger.LogImportant(logPrefix, "self-trade prevention enabled, mode=%s", p.mode.String())
+}
+
+func (p *SelfTradePreventer) Disable() {
+	common.RemovePreTradeChecker(p)
+	logger.LogImportant(logPrefix, "self-trade prevention disabled")
+}
+
+// 添加同一账户下的其他交易器，其挂单也参与检查
+func (p *SelfTradePreventer) AddTrader(t common.CommonTrader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !slices.Contains(p.traders, t) {
+		p.traders = append(p.traders, t)
+	}
+}
+
+func (p *SelfTradePreventer) SetMode(mode STPMode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mode = mode
+}
+
+// 与新订单方向相反、价格交叉的挂单
+func (p *SelfTradePreventer) crossingOrders(o *common.OrderImpl) []common.Order {
+	p.mu.Lock()
+	traders := append([]common.CommonTrader{o.Trader}, p.traders...)
+	p.mu.Unlock()
+
+	var result []common.Order
+	for i, t := range traders {
+		if t == nil || (i > 0 && t == o.Trader) || t.Market().Type() != o.InstId {
+			continue
+		}
+
+		for _, r := range t.Orders() {
+			if r.IsFinished() || r.GetDir() == o.Dir || !r.GetUnfilled().IsPositive() {
+				continue
+			}
+
+			if o.MarketOrder ||
+				(o.Dir == common.OrderDir_Buy && r.GetPrice().LessThanOrEqual(o.Price)) ||
+				(o.Dir == common.OrderDir_Sell && r.GetPrice().GreaterThanOrEqual(o.Price)) {
+				result = append(result, r)
+			}
+		}
+	}
+	return result
+}
+
+// 实现common.PreTradeChecker
+func (p *SelfTradePreventer) CheckOrder(o *common.OrderImpl) error {
+	if o.Trader == nil {
+		return nil
+	}
+
+	crossing := p.crossingOrders(o)
+	if len(crossing) == 0 {
+		return nil
+	}
+
+	// 最优的挂单价格
+	best := crossing[0].GetPrice()
+	for _, r := range crossing[1:] {
+		if (o.Dir == common.OrderDir_Buy && r.GetPrice().LessThan(best)) || (o.Dir == common.OrderDir_Sell && r.GetPrice().GreaterThan(best)) {
+			best = r.GetPrice()
+		}
+	}
+
+	p.mu.Lock()
+	mode := p.mode
+	p.mu.Unlock()
+
+	err := &LimitError{Kind: LimitKind_SelfTrade, InstId: o.InstId, Value: o.Price, Limit: best}
+	switch mode {
+	case STPMode_PriceAround:
+		// 只给出建议价格，市价单没有建议价格
+		if inst := o.InstrumentMgr.Get(o.InstId); inst != nil && inst.TickSize.IsPositive() && !o.MarketOrder {
+			px := best.Add(inst.TickSize)
+			if o.Dir == common.OrderDir_Buy {
+				px = best.Sub(inst.TickSize)
+			}
+
+			if px.IsPositive() {
+				err.Suggest = px
+			}
+		}
+	case STPMode_CancelResting:
+		for _, r := range crossing {
+			o.LogFields.LogImportant(o.LogPrefix, "self-trade prevented, cancel resting order [%s]", r.String())
+			r.Cancel()
+		}
+		return nil
+	}
+
+	return err
+}
+
+// 某价格的订单是否会与自己的挂单成交，不下单。策略可以用来提前判断
+func (p *SelfTradePreventer) WouldCross(t common.CommonTrader, price decimal.Decimal, dir common.OrderDir) bool {
+	o := &common.OrderImpl{Trader: t, InstId: t.Market().Type(), Price: price, Dir: dir}
+	return len(p.crossingOrders(o)) > 0
+}
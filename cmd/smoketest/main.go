@@ -0,0 +1,181 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-19 16:20:45
+- @Description: 接入冒烟测试。新账户投入生产前跑一遍，检查时钟、品种、下撤单、权益读取是否正常
+- 测试订单为远离盘口的最小数量只挂单，下单后立即撤销。只撤销测试自己的订单，账户上已有的挂单不受影响
+- @Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi/binancespotapi"
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/cex/binance"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/cex/okexv5"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/shopspring/decimal"
+)
+
+const logPrefix = "smoketest"
+
+type checkResult struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+var results []checkResult
+
+func check(name string, ok bool, format string, a ...interface{}) bool {
+	detail := fmt.Sprintf(format, a...)
+	results = append(results, checkResult{name: name, ok: ok, detail: detail})
+	logger.LogImportant(logPrefix, "[%s] %s: %s", util.ValueIf(ok, "PASS", "FAIL"), name, detail)
+	return ok
+}
+
+func main() {
+	exName := flag.String("ex", "okex", "exchange name: okex/binance")
+	key := flag.String("key", "", "api key")
+	secret := flag.String("secret", "", "secret key")
+	pass := flag.String("pass", "", "passphrase (okex only)")
+	baseCcy := flag.String("base", "btc", "base currency")
+	quoteCcy := flag.String("quote", "usdt", "quote currency")
+	maxClockDiff := flag.Int64("clockdiff", 1000, "max clock diff in ms")
+	flag.Parse()
+
+	logger.Init(logger.SplitMode_ByDays, 7)
+	logger.ConsleLogLevel = logger.LogLevel_Important
+
+	if len(*key) == 0 || len(*secret) == 0 {
+		fmt.Println("key and secret are required")
+		os.Exit(1)
+	}
+
+	run(strings.ToLower(*exName), *key, *secret, *pass, *baseCcy, *quoteCcy, *maxClockDiff)
+	report()
+
+	for _, r := range results {
+		if !r.ok {
+			os.Exit(1)
+		}
+	}
+}
+
+func run(exName, key, secret, pass, baseCcy, quoteCcy string, maxClockDiff int64) {
+	// 时钟
+	serverTs := int64(0)
+	if exName == "okex" {
+		serverTs = okexv5api.GetServerTS()
+	} else if exName == "binance" {
+		serverTs = binancespotapi.GetServerTs()
+	} else {
+		check("exchange", false, "unknown exchange %s", exName)
+		return
+	}
+
+	diff := time.Now().UnixMilli() - serverTs
+	if !check("clock sync", serverTs > 0 && util.AbsInt64(diff) <= maxClockDiff, "server ts=%d, local-server=%dms", serverTs, diff) {
+		return
+	}
+
+	// 交易所
+	var ex common.CEx
+	if exName == "okex" {
+		// 不撤销启动前的挂单
+		okex := new(okexv5.Exchange)
+		okexv5.StratergyName = logPrefix
+		excfg := okexv5.NewExchangeConfig()
+		excfg.OrphanOrderPolicy = okexv5.OrphanPolicy_Ignore
+		okex.Init(key, secret, pass, &excfg, nil)
+		ex = okex
+	} else {
+		bn := new(binance.Exchange)
+		bn.SetKeepOpenOrders(true)
+		bn.Init(key, secret, nil)
+		ex = bn
+	}
+	defer ex.Exit()
+
+	// 品种
+	insts := ex.Instruments()
+	if !check("instruments", len(insts) > 0, "%d instruments loaded", len(insts)) {
+		return
+	}
+
+	trader := ex.UseSpotTrader(baseCcy, quoteCcy)
+	if !check("spot trader", trader != nil, "%s_%s", baseCcy, quoteCcy) {
+		return
+	}
+
+	ready := false
+	for i := 0; i < 300 && !ready; i++ {
+		ready = trader.Ready()
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !check("trader ready", ready, "%s", util.ValueIf(ready, "ok", trader.UnreadyReason())) {
+		return
+	}
+
+	// 权益
+	qb := trader.QuoteBalance()
+	bb := trader.BaseBalance()
+	if !check("balance read", qb != nil && bb != nil, "quote=%v, base=%v", qb != nil, bb != nil) {
+		return
+	}
+	logger.LogImportant(logPrefix, "%s=%v, %s=%v", quoteCcy, qb.Rights(), baseCcy, bb.Rights())
+
+	// 远离盘口的只挂单，下单+撤单
+	m := trader.Market()
+	px := m.AlignPrice(m.OrderBook().Buy1Price().Mul(decimal.NewFromFloat(0.8)), common.OrderDir_Buy, true)
+	sz := m.MinSize()
+	if !check("order balance", px.Mul(sz).LessThanOrEqual(qb.Available()), "need %v %s, available %v", px.Mul(sz), quoteCcy, qb.Available()) {
+		return
+	}
+
+	t0 := time.Now()
+	o := trader.MakeOrder(px, sz, common.OrderDir_Buy, true, false, "smoketest", nil)
+	if !check("order create", o != nil, "price=%v, size=%v", px, sz) {
+		return
+	}
+
+	// 中途失败时也要撤掉测试订单
+	defer func() {
+		if !o.IsFinished() {
+			o.Cancel()
+		}
+	}()
+
+	for i := 0; i < 100 && !o.IsAlive() && !o.IsFinished(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	id, cid := o.GetID()
+	if !check("order place", o.IsAlive(), "id=%s/%s, cost %dms", id, cid, time.Since(t0).Milliseconds()) {
+		return
+	}
+
+	t0 = time.Now()
+	o.Cancel()
+	for i := 0; i < 100 && !o.IsFinished(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	check("order cancel", o.IsFinished() && !o.HasFatalError() && o.GetFilled().IsZero(), "status=%s, cost %dms", o.GetStatus(), time.Since(t0).Milliseconds())
+}
+
+func report() {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"check", "result", "detail"})
+	for _, r := range results {
+		t.AppendRow(table.Row{r.name, util.ValueIf(r.ok, "PASS", "FAIL"), r.detail})
+	}
+	t.Render()
+}
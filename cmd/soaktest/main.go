@@ -0,0 +1,350 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-29 10:12:33
+- @Description: 长时间稳定性测试(soak test)。发版前在测试账户上连续跑数天，输出稳定性报告
+- 持续订阅行情，并定时下远离盘口的最小数量只挂单再撤销。每个检查周期验证以下不变量：
+- 行情：盘口不为空、不交叉；断线后在限定时间内恢复就绪
+- 订单：测试单能正常挂出、撤销，不成交；交易器内的订单不堆积
+- 权益：没有测试单时，冻结数量回到初始值
+- 资源：协程数、内存不持续增长
+- okx可使用模拟盘(-demo)。注意：交易所初始化时会撤销该账户下的所有挂单
+- binance没有模拟盘，需要加-live确认在实盘账户上运行，且保留账户中已有的挂单
+- @Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aztecqt/dagger/api/okexv5api"
+	"github.com/aztecqt/dagger/cex/binance"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/cex/okexv5"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/shopspring/decimal"
+)
+
+const logPrefix = "soaktest"
+
+// 单个不变量的统计
+type invariant struct {
+	Name      string    `json:"name"`
+	Checks    int       `json:"checks"`
+	Failures  int       `json:"failures"`
+	LastFail  time.Time `json:"last_fail"`
+	LastError string    `json:"last_error"`
+}
+
+type soakReport struct {
+	Exchange      string                `json:"exchange"`
+	Symbol        string                `json:"symbol"`
+	Start         time.Time             `json:"start"`
+	End           time.Time             `json:"end"`
+	Invariants    map[string]*invariant `json:"invariants"`
+	Orders        int                   `json:"orders"`
+	totalPlaceMs  int64
+	placed        int           // 成功挂出的次数，用于计算平均挂单耗时
+	AvgPlaceMs    float64       `json:"avg_place_ms"`
+	MaxPlaceMs    int64         `json:"max_place_ms"`
+	MaxCancelMs   int64         `json:"max_cancel_ms"`
+	Disconnects   int           `json:"disconnects"`
+	MaxUnready    time.Duration `json:"max_unready"`
+	BaseGoroutine int           `json:"base_goroutine"`
+	MaxGoroutine  int           `json:"max_goroutine"`
+	BaseHeapMB    float64       `json:"base_heap_mb"`
+	MaxHeapMB     float64       `json:"max_heap_mb"`
+}
+
+func (r *soakReport) assert(name string, ok bool, format string, a ...interface{}) bool {
+	inv, exist := r.Invariants[name]
+	if !exist {
+		inv = &invariant{Name: name}
+		r.Invariants[name] = inv
+	}
+
+	inv.Checks++
+	if !ok {
+		inv.Failures++
+		inv.LastFail = time.Now()
+		inv.LastError = fmt.Sprintf(format, a...)
+		logger.LogImportant(logPrefix, "[FAIL] %s: %s", name, inv.LastError)
+	}
+	return ok
+}
+
+func (r *soakReport) passed() bool {
+	for _, inv := range r.Invariants {
+		if inv.Failures > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type soaker struct {
+	trader common.SpotTrader
+	rpt    *soakReport
+	cfg    soakConfig
+
+	frozenBase  decimal.Decimal
+	frozenQuote decimal.Decimal
+	unreadyFrom time.Time
+}
+
+type soakConfig struct {
+	duration      time.Duration
+	checkInterval time.Duration
+	orderInterval time.Duration
+	recoverTime   time.Duration
+	warmup        time.Duration
+	leakFactor    float64
+}
+
+func main() {
+	exName := flag.String("ex", "okex", "exchange name: okex/binance")
+	key := flag.String("key", "", "api key")
+	secret := flag.String("secret", "", "secret key")
+	pass := flag.String("pass", "", "passphrase (okex only)")
+	demo := flag.Bool("demo", true, "use demo trading (okex only)")
+	live := flag.Bool("live", false, "confirm running on a live account (required by binance)")
+	baseCcy := flag.String("base", "btc", "base currency")
+	quoteCcy := flag.String("quote", "usdt", "quote currency")
+	duration := flag.Duration("duration", time.Hour*72, "total soak duration")
+	checkInterval := flag.Duration("check", time.Second*10, "invariant check interval")
+	orderInterval := flag.Duration("order", time.Minute*5, "test order interval")
+	recoverTime := flag.Duration("recover", time.Minute*2, "max time allowed to recover after disconnect")
+	warmup := flag.Duration("warmup", time.Minute*10, "warmup time before taking resource baseline")
+	leakFactor := flag.Float64("leak", 2, "max allowed growth factor of goroutines/heap over baseline")
+	reportPath := flag.String("report", "./soak_report.json", "report path")
+	flag.Parse()
+
+	logger.Init(logger.SplitMode_ByDays, 7)
+	logger.ConsleLogLevel = logger.LogLevel_Important
+
+	if len(*key) == 0 || len(*secret) == 0 {
+		fmt.Println("key and secret are required")
+		os.Exit(1)
+	}
+
+	// 交易所
+	*exName = strings.ToLower(*exName)
+	var ex common.CEx
+	if *exName == "okex" {
+		okexv5api.UseSimulatedTrading(*demo)
+		okex := new(okexv5.Exchange)
+		okex.Init(*key, *secret, *pass, nil, nil)
+		ex = okex
+	} else if *exName == "binance" {
+		if !*live {
+			fmt.Println("binance has no testnet support here, add -live to run on live account")
+			os.Exit(1)
+		}
+
+		logger.LogImportant(logPrefix, "running on binance live account")
+		bn := new(binance.Exchange)
+		bn.SetKeepOpenOrders(true)
+		bn.Init(*key, *secret, nil)
+		ex = bn
+	} else {
+		fmt.Printf("unknown exchange %s\n", *exName)
+		os.Exit(1)
+	}
+
+	trader := ex.UseSpotTrader(*baseCcy, *quoteCcy)
+	if trader == nil {
+		fmt.Printf("create spot trader %s_%s failed\n", *baseCcy, *quoteCcy)
+		os.Exit(1)
+	}
+
+	s := &soaker{
+		trader: trader,
+		cfg: soakConfig{
+			duration:      *duration,
+			checkInterval: *checkInterval,
+			orderInterval: *orderInterval,
+			recoverTime:   *recoverTime,
+			warmup:        *warmup,
+			leakFactor:    *leakFactor,
+		},
+		rpt: &soakReport{
+			Exchange:   *exName,
+			Symbol:     fmt.Sprintf("%s_%s", *baseCcy, *quoteCcy),
+			Start:      time.Now(),
+			Invariants: make(map[string]*invariant),
+		},
+	}
+	s.run()
+	ex.Exit()
+
+	s.rpt.End = time.Now()
+	if s.rpt.placed > 0 {
+		s.rpt.AvgPlaceMs = float64(s.rpt.totalPlaceMs) / float64(s.rpt.placed)
+	}
+	util.ObjectToFile(*reportPath, s.rpt)
+	s.report()
+
+	if !s.rpt.passed() {
+		os.Exit(1)
+	}
+}
+
+func (s *soaker) run() {
+	// 等待就绪并记录初始冻结
+	for i := 0; i < 600 && !s.trader.Ready(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if !s.rpt.assert("initial ready", s.trader.Ready(), "%s", s.trader.UnreadyReason()) {
+		return
+	}
+	s.frozenBase = s.trader.BaseBalance().Frozen()
+	s.frozenQuote = s.trader.QuoteBalance().Frozen()
+
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
+	tkCheck := time.NewTicker(s.cfg.checkInterval)
+	defer tkCheck.Stop()
+	tkOrder := time.NewTicker(s.cfg.orderInterval)
+	defer tkOrder.Stop()
+	tkWarmup := time.NewTimer(s.cfg.warmup)
+	defer tkWarmup.Stop()
+	end := time.NewTimer(s.cfg.duration)
+	defer end.Stop()
+
+	for {
+		select {
+		case <-tkCheck.C:
+			s.checkMarket()
+			s.checkResource()
+		case <-tkOrder.C:
+			if s.trader.Ready() {
+				s.testOrder()
+			}
+		case <-tkWarmup.C:
+			runtime.GC()
+			s.rpt.BaseGoroutine = runtime.NumGoroutine()
+			s.rpt.BaseHeapMB = heapMB()
+			logger.LogImportant(logPrefix, "baseline taken, goroutine=%d, heap=%.1fMB", s.rpt.BaseGoroutine, s.rpt.BaseHeapMB)
+		case <-end.C:
+			logger.LogImportant(logPrefix, "soak finished")
+			return
+		case <-chSig:
+			logger.LogImportant(logPrefix, "interrupted")
+			return
+		}
+	}
+}
+
+// 行情与连接
+func (s *soaker) checkMarket() {
+	now := time.Now()
+	if !s.trader.Ready() {
+		if s.unreadyFrom.IsZero() {
+			s.unreadyFrom = now
+			s.rpt.Disconnects++
+			logger.LogImportant(logPrefix, "trader unready: %s", s.trader.UnreadyReason())
+		}
+		unready := now.Sub(s.unreadyFrom)
+		s.rpt.MaxUnready = util.ValueIf(unready > s.rpt.MaxUnready, unready, s.rpt.MaxUnready)
+		s.rpt.assert("reconnect recover", unready <= s.cfg.recoverTime, "unready for %v: %s", unready, s.trader.UnreadyReason())
+		return
+	}
+
+	if !s.unreadyFrom.IsZero() {
+		logger.LogImportant(logPrefix, "trader recovered after %v", now.Sub(s.unreadyFrom))
+		s.unreadyFrom = time.Time{}
+	}
+
+	ob := s.trader.Market().OrderBook()
+	buy1, sell1 := ob.Buy1Price(), ob.Sell1Price()
+	s.rpt.assert("orderbook valid", buy1.IsPositive() && sell1.GreaterThan(buy1), "buy1=%v, sell1=%v", buy1, sell1)
+
+	// 测试单都已完结时，交易器内不应有残留订单，冻结应回到初始值
+	if len(s.trader.Orders()) == 0 {
+		fb, fq := s.trader.BaseBalance().Frozen(), s.trader.QuoteBalance().Frozen()
+		s.rpt.assert("balance frozen", fb.Equal(s.frozenBase) && fq.Equal(s.frozenQuote), "frozen base=%v(init %v), quote=%v(init %v)", fb, s.frozenBase, fq, s.frozenQuote)
+	}
+	s.rpt.assert("order leak", len(s.trader.Orders()) <= 1, "%d orders left in trader", len(s.trader.Orders()))
+}
+
+// 资源
+func (s *soaker) checkResource() {
+	g := runtime.NumGoroutine()
+	h := heapMB()
+	s.rpt.MaxGoroutine = util.ValueIf(g > s.rpt.MaxGoroutine, g, s.rpt.MaxGoroutine)
+	s.rpt.MaxHeapMB = util.ValueIf(h > s.rpt.MaxHeapMB, h, s.rpt.MaxHeapMB)
+
+	if s.rpt.BaseGoroutine > 0 {
+		s.rpt.assert("goroutine leak", float64(g) <= float64(s.rpt.BaseGoroutine)*s.cfg.leakFactor, "goroutine=%d, baseline=%d", g, s.rpt.BaseGoroutine)
+		s.rpt.assert("memory leak", h <= s.rpt.BaseHeapMB*s.cfg.leakFactor, "heap=%.1fMB, baseline=%.1fMB", h, s.rpt.BaseHeapMB)
+	}
+}
+
+// 远离盘口的最小数量只挂单，挂出后撤销
+func (s *soaker) testOrder() {
+	m := s.trader.Market()
+	px := m.AlignPrice(m.OrderBook().Buy1Price().Mul(decimal.NewFromFloat(0.8)), common.OrderDir_Buy, true)
+	sz := m.MinSize()
+	if px.Mul(sz).GreaterThan(s.trader.QuoteBalance().Available()) {
+		s.rpt.assert("order balance", false, "insufficient balance, need %v", px.Mul(sz))
+		return
+	}
+
+	t0 := time.Now()
+	o := s.trader.MakeOrder(px, sz, common.OrderDir_Buy, true, false, "soak", nil)
+	if !s.rpt.assert("order create", o != nil, "create failed, price=%v size=%v", px, sz) {
+		return
+	}
+	s.rpt.Orders++
+
+	for i := 0; i < 100 && !o.IsAlive() && !o.IsFinished(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	placeMs := time.Since(t0).Milliseconds()
+	if !s.rpt.assert("order alive", o.IsAlive(), "not alive after %dms, status=%s", placeMs, o.GetStatus()) {
+		o.Cancel()
+		return
+	}
+	s.rpt.totalPlaceMs += placeMs
+	s.rpt.placed++
+	s.rpt.MaxPlaceMs = util.ValueIf(placeMs > s.rpt.MaxPlaceMs, placeMs, s.rpt.MaxPlaceMs)
+
+	t0 = time.Now()
+	o.Cancel()
+	for i := 0; i < 100 && !o.IsFinished(); i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	cancelMs := time.Since(t0).Milliseconds()
+	s.rpt.MaxCancelMs = util.ValueIf(cancelMs > s.rpt.MaxCancelMs, cancelMs, s.rpt.MaxCancelMs)
+	s.rpt.assert("order cancel", o.IsFinished() && !o.HasFatalError() && o.GetFilled().IsZero(), "status=%s, filled=%v, cost %dms", o.GetStatus(), o.GetFilled(), cancelMs)
+}
+
+func heapMB() float64 {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+	return float64(ms.HeapAlloc) / 1024 / 1024
+}
+
+func (s *soaker) report() {
+	r := s.rpt
+	fmt.Printf("soak %s %s, %s ~ %s (%v)\n", r.Exchange, r.Symbol, r.Start.Format(time.DateTime), r.End.Format(time.DateTime), r.End.Sub(r.Start).Round(time.Second))
+	fmt.Printf("orders=%d, place avg/max=%.0f/%dms, cancel max=%dms, disconnects=%d, max unready=%v\n",
+		r.Orders, r.AvgPlaceMs, r.MaxPlaceMs, r.MaxCancelMs, r.Disconnects, r.MaxUnready.Round(time.Second))
+	fmt.Printf("goroutine base/max=%d/%d, heap base/max=%.1f/%.1fMB\n", r.BaseGoroutine, r.MaxGoroutine, r.BaseHeapMB, r.MaxHeapMB)
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"invariant", "result", "checks", "failures", "last error"})
+	for _, inv := range r.Invariants {
+		t.AppendRow(table.Row{inv.Name, util.ValueIf(inv.Failures == 0, "PASS", "FAIL"), inv.Checks, inv.Failures, inv.LastError})
+	}
+	t.Render()
+}
@@ -0,0 +1,158 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-02 09:30:18
+- @Description: 历史k线批量下载
+- 按天缓存和分页拉取由cachedok/cachedbn完成，这里负责统一接口、去重排序、补齐缺失的k线(见util/kline)
+- 交易所不直接支持的周期(如2分钟、12小时、1周)，用能整除它的最大周期合成，起点按交易所的时区对齐(okx为UTC+8，币安为UTC)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package klines
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/api/binanceapi/cachedbn"
+	"github.com/aztecqt/dagger/api/okexv5api/cachedok"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/kline"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const logPrefix = "kline-downloader"
+
+type Source string
+
+const (
+	Source_Okx           Source = "okx"            // symbol为okx的instId，如BTC-USDT、BTC-USDT-SWAP
+	Source_BinanceSpot   Source = "binance_spot"   // symbol如BTCUSDT
+	Source_BinanceFuture Source = "binance_future" // symbol如BTCUSDT、BTCUSD_PERP
+)
+
+// 两个交易所都支持，并且可以缓存的周期(由大到小)
+var baseIntervals = []time.Duration{
+	time.Hour * 24,
+	time.Hour * 4,
+	time.Hour * 2,
+	time.Hour,
+	time.Minute * 30,
+	time.Minute * 15,
+	time.Minute * 5,
+	time.Minute * 3,
+	time.Minute,
+}
+
+type Downloader struct {
+	src      Source
+	fillGaps bool
+	fnPrg    func(prg time.Time)
+}
+
+func (d *Downloader) Init(src Source) {
+	d.src = src
+	d.fillGaps = true
+}
+
+// 是否补齐缺失的k线(以前一根的收盘价填充，成交量为0)，默认补齐
+func (d *Downloader) SetFillGaps(b bool) {
+	d.fillGaps = b
+}
+
+// 下载进度回调
+func (d *Downloader) SetProgressFn(fn func(prg time.Time)) {
+	d.fnPrg = fn
+}
+
+// 获取[t0, t1)区间内的k线。已缓存的日期从本地读取，其余从rest拉取并写入缓存
+func (d *Downloader) GetKlines(symbol string, interval time.Duration, t0, t1 time.Time) ([]common.KUnit, bool) {
+	base, ok := baseIntervalOf(interval)
+	if !ok {
+		logger.LogImportant(logPrefix, "unsupported interval: %v", interval)
+		return nil, false
+	}
+
+	b := d.boundary()
+	t0 = kline.Align(t0, interval, b)
+	kus, ok := d.load(symbol, base, t0, t1)
+	if !ok {
+		return nil, false
+	}
+
+	// 分页边界、缓存与rest重叠处会有重复的k线，保留后出现的
+	units := kline.Dedupe(toUnits(kus))
+	if d.fillGaps {
+		n := len(units)
+		units = kline.FillGaps(units, base)
+		if filled := len(units) - n; filled > 0 {
+			logger.LogInfo(logPrefix, "%d missing klines filled", filled)
+		}
+	}
+
+	if base != interval {
+		units = kline.Resample(units, interval, b)
+	}
+	return fromUnits(units), true
+}
+
+// 日线及以上周期的起点
+func (d *Downloader) boundary() kline.Boundary {
+	return util.ValueIf(d.src == Source_Okx, kline.Boundary_Utc8, kline.Boundary_Utc)
+}
+
+func toUnits(kus []common.KUnit) []kline.Unit {
+	units := make([]kline.Unit, len(kus))
+	for i, ku := range kus {
+		units[i] = kline.Unit{Time: ku.Time, Open: ku.OpenPrice, High: ku.HighestPrice, Low: ku.LowestPrice, Close: ku.ClosePrice, Volume: ku.VolumeUSD}
+	}
+	return units
+}
+
+func fromUnits(units []kline.Unit) []common.KUnit {
+	kus := make([]common.KUnit, len(units))
+	for i, u := range units {
+		kus[i] = common.KUnit{Time: u.Time, OpenPrice: u.Open, HighestPrice: u.High, LowestPrice: u.Low, ClosePrice: u.Close, VolumeUSD: u.Volume}
+	}
+	return kus
+}
+
+// 能整除interval的最大基础周期
+func baseIntervalOf(interval time.Duration) (time.Duration, bool) {
+	for _, b := range baseIntervals {
+		if interval >= b && interval%b == 0 {
+			return b, true
+		}
+	}
+	return 0, false
+}
+
+func (d *Downloader) load(symbol string, base time.Duration, t0, t1 time.Time) ([]common.KUnit, bool) {
+	intervalSec := int(base / time.Second)
+	kus := []common.KUnit{}
+	switch d.src {
+	case Source_Okx:
+		raw, ok := cachedok.GetKline(symbol, t0, t1, intervalSec, d.fnPrg)
+		if !ok {
+			return nil, false
+		}
+		for _, ku := range raw {
+			kus = append(kus, common.KUnit{Time: ku.Time, OpenPrice: ku.Open, ClosePrice: ku.Close, HighestPrice: ku.High, LowestPrice: ku.Low, VolumeUSD: ku.VolumeUSD})
+		}
+	case Source_BinanceSpot, Source_BinanceFuture:
+		fn := cachedbn.GetSpotKline
+		if d.src == Source_BinanceFuture {
+			fn = cachedbn.GetFutureKline
+		}
+		raw, ok := fn(strings.ToUpper(symbol), t0, t1, intervalSec, d.fnPrg)
+		if !ok {
+			return nil, false
+		}
+		for _, ku := range raw {
+			kus = append(kus, common.KUnit{Time: ku.Time, OpenPrice: ku.Open, ClosePrice: ku.Close, HighestPrice: ku.High, LowestPrice: ku.Low, VolumeUSD: ku.VolumeUSD})
+		}
+	default:
+		logger.LogImportant(logPrefix, "unknown source: %s", d.src)
+		return nil, false
+	}
+	return kus, true
+}
@@ -0,0 +1,126 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-01 16:05:47
+- @Description: 录制记录的编码
+- csv：每行一条记录，深度的各档格式为px:sz，档与档之间用;分隔
+- bin：每个文件以版本头开头(4字节标识+2字节版本号，见util.VersionedHeader)，之后为小端序的记录，每条记录以int64接收时间(微秒)开头
+- 成交：ts(int64毫秒) px(float64) sz(float64) side(int8，1买2卖0未知) idLen(uint16) id。版本0(没有文件头)没有id
+- 深度：档数nAsk(uint16) nBid(uint16)，之后依次为各档px/sz(float64)，先asks后bids
+- K线：start/end(int64毫秒) open/high/low/close/volume/buyVolume(float64) trades(int32)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	tradeHeader = "recv_us,ts_ms,id,px,sz,side\n"
+	depthHeader = "recv_us,asks,bids\n"
+	barHeader   = "recv_us,start_ms,end_ms,open,high,low,close,volume,buy_volume,trades\n"
+)
+
+// bin文件的标识和当前版本
+const (
+	BinMagic_Trade = "RTRD"
+	BinMagic_Depth = "RDPT"
+	BinMagic_Bar   = "RBAR"
+	BinVersion     = 1
+)
+
+func (r *Recorder) encodeTrade(t common.PublicTrade) []byte {
+	if r.cfg.Format == Format_Binary {
+		buf := &bytes.Buffer{}
+		binary.Write(buf, binary.LittleEndian, t.LocalTime.UnixMicro())
+		binary.Write(buf, binary.LittleEndian, t.UTime.UnixMilli())
+		binary.Write(buf, binary.LittleEndian, t.Price.InexactFloat64())
+		binary.Write(buf, binary.LittleEndian, t.Size.InexactFloat64())
+		binary.Write(buf, binary.LittleEndian, int8(t.Dir))
+		binary.Write(buf, binary.LittleEndian, uint16(len(t.Id)))
+		buf.WriteString(t.Id)
+		return buf.Bytes()
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(strconv.FormatInt(t.LocalTime.UnixMicro(), 10))
+	sb.WriteByte(',')
+	sb.WriteString(strconv.FormatInt(t.UTime.UnixMilli(), 10))
+	sb.WriteByte(',')
+	sb.WriteString(t.Id)
+	sb.WriteByte(',')
+	sb.WriteString(t.Price.String())
+	sb.WriteByte(',')
+	sb.WriteString(t.Size.String())
+	sb.WriteByte(',')
+	sb.WriteString(common.OrderDir2Str(t.Dir))
+	sb.WriteByte('\n')
+	return []byte(sb.String())
+}
+
+func (r *Recorder) encodeDepth(now time.Time, asks, bids [][2]decimal.Decimal) []byte {
+	if r.cfg.Format == Format_Binary {
+		buf := &bytes.Buffer{}
+		binary.Write(buf, binary.LittleEndian, now.UnixMicro())
+		binary.Write(buf, binary.LittleEndian, uint16(len(asks)))
+		binary.Write(buf, binary.LittleEndian, uint16(len(bids)))
+		for _, levels := range [][][2]decimal.Decimal{asks, bids} {
+			for _, l := range levels {
+				binary.Write(buf, binary.LittleEndian, l[0].InexactFloat64())
+				binary.Write(buf, binary.LittleEndian, l[1].InexactFloat64())
+			}
+		}
+		return buf.Bytes()
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(strconv.FormatInt(now.UnixMicro(), 10))
+	for _, levels := range [][][2]decimal.Decimal{asks, bids} {
+		sb.WriteByte(',')
+		for i, l := range levels {
+			if i > 0 {
+				sb.WriteByte(';')
+			}
+			sb.WriteString(l[0].String())
+			sb.WriteByte(':')
+			sb.WriteString(l[1].String())
+		}
+	}
+	sb.WriteByte('\n')
+	return []byte(sb.String())
+}
+
+func (r *Recorder) encodeBar(now time.Time, b common.Bar) []byte {
+	if r.cfg.Format == Format_Binary {
+		buf := &bytes.Buffer{}
+		binary.Write(buf, binary.LittleEndian, now.UnixMicro())
+		binary.Write(buf, binary.LittleEndian, b.Start.UnixMilli())
+		binary.Write(buf, binary.LittleEndian, b.End.UnixMilli())
+		for _, v := range []decimal.Decimal{b.Open, b.High, b.Low, b.Close, b.Volume, b.BuyVolume} {
+			binary.Write(buf, binary.LittleEndian, v.InexactFloat64())
+		}
+		binary.Write(buf, binary.LittleEndian, int32(b.Trades))
+		return buf.Bytes()
+	}
+
+	fields := []string{
+		strconv.FormatInt(now.UnixMicro(), 10),
+		strconv.FormatInt(b.Start.UnixMilli(), 10),
+		strconv.FormatInt(b.End.UnixMilli(), 10),
+		b.Open.String(),
+		b.High.String(),
+		b.Low.String(),
+		b.Close.String(),
+		b.Volume.String(),
+		b.BuyVolume.String(),
+		strconv.Itoa(b.Trades),
+	}
+	return []byte(strings.Join(fields, ",") + "\n")
+}
@@ -0,0 +1,224 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-01 15:12:05
+- @Description: 行情录制。订阅指定品种的深度/逐笔成交/K线，写入按时间滚动的压缩文件，供回放和研究使用
+- 每条记录以本地接收时间(微秒)开头
+- 目录结构：<Dir>/<instId>/<depth|trades|bar_xxx>/<起始时间>.<csv|bin>.flate
+- 所有写入在同一个协程中完成，回调中只做编码和投递，队列满时丢弃并计数
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package recorder
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const logPrefix = "recorder"
+
+type Format string
+
+const (
+	Format_Csv    Format = "csv"
+	Format_Binary Format = "bin"
+)
+
+type Config struct {
+	Dir           string        `json:"dir"`
+	Format        Format        `json:"format"`         // csv/bin，默认csv
+	Rotate        time.Duration `json:"rotate"`         // 文件滚动周期，默认1小时
+	DepthLevels   int           `json:"depth_levels"`   // 深度录制档数，默认20
+	DepthInterval time.Duration `json:"depth_interval"` // 深度录制的最小间隔，0表示每次变化都录制
+}
+
+// 单个品种的录制内容
+type Options struct {
+	Depth  bool
+	Trades bool
+	Bars   []common.BarConfig
+}
+
+type record struct {
+	w    *rotatingWriter
+	t    time.Time
+	data []byte
+}
+
+type Recorder struct {
+	cfg Config
+
+	mu      sync.Mutex
+	writers []*rotatingWriter
+	unsubs  []func() // Stop时取消的订阅
+
+	chRecord chan record
+	chStop   chan int
+	chDone   chan int
+	written  int64
+	dropped  int64
+}
+
+func (r *Recorder) Init(cfg Config) {
+	cfg.Format = util.ValueIf(cfg.Format == Format_Binary, Format_Binary, Format_Csv)
+	cfg.Rotate = util.ValueIf(cfg.Rotate > 0, cfg.Rotate, time.Hour)
+	cfg.DepthLevels = util.ValueIf(cfg.DepthLevels > 0, cfg.DepthLevels, 20)
+	r.cfg = cfg
+	r.chRecord = make(chan record, 1024*64)
+	r.chStop = make(chan int, 1)
+	r.chDone = make(chan int, 1)
+}
+
+func (r *Recorder) Go() {
+	go r.update()
+}
+
+// 写完队列中剩余的记录并关闭所有文件
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	unsubs := r.unsubs
+	r.unsubs = nil
+	r.mu.Unlock()
+	for _, fn := range unsubs {
+		fn()
+	}
+
+	r.chStop <- 0
+	<-r.chDone
+}
+
+// 已写入/丢弃的记录数
+func (r *Recorder) Stats() (written, dropped int64) {
+	return atomic.LoadInt64(&r.written), atomic.LoadInt64(&r.dropped)
+}
+
+// 开始录制某个品种
+func (r *Recorder) Record(m common.CommonMarket, opt Options) {
+	if opt.Depth {
+		dr := &depthRecorder{r: r, m: m, w: r.newWriter(m.Type(), "depth", depthHeader, BinMagic_Depth)}
+		m.AddDepthObserver(dr)
+		r.addUnsub(func() { m.RemoveDepthObserver(dr) })
+	}
+
+	if opt.Trades {
+		w := r.newWriter(m.Type(), "trades", tradeHeader, BinMagic_Trade)
+		id := m.SubscribeTrades(func(t common.PublicTrade) {
+			r.post(w, t.LocalTime, r.encodeTrade(t))
+		})
+		r.addUnsub(func() { m.UnsubscribeTrades(id) })
+	}
+
+	for _, cfg := range opt.Bars {
+		b := m.Bars(cfg)
+		if b == nil {
+			logger.LogImportant(logPrefix, "%s: invalid bar config %+v", m.Type(), cfg)
+			continue
+		}
+
+		w := r.newWriter(m.Type(), barKind(cfg), barHeader, BinMagic_Bar)
+		id := b.AddBarFn(func(bar common.Bar) {
+			now := time.Now()
+			r.post(w, now, r.encodeBar(now, bar))
+		})
+		r.addUnsub(func() { b.RemoveBarFn(id) })
+	}
+
+	logger.LogImportant(logPrefix, "recording %s, depth=%v, trades=%v, bars=%d", m.Type(), opt.Depth, opt.Trades, len(opt.Bars))
+}
+
+func (r *Recorder) addUnsub(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unsubs = append(r.unsubs, fn)
+}
+
+func barKind(cfg common.BarConfig) string {
+	if cfg.Type == common.BarType_Volume {
+		return fmt.Sprintf("bar_vol%s", cfg.Volume.String())
+	}
+	return fmt.Sprintf("bar_%s", cfg.Interval.String())
+}
+
+// 每个文件开头写入文件头：csv为表头，bin为版本头
+func (r *Recorder) newWriter(instId, kind, csvHeader, binMagic string) *rotatingWriter {
+	w := new(rotatingWriter)
+	w.init(
+		fmt.Sprintf("%s/%s/%s", r.cfg.Dir, instId, kind),
+		string(r.cfg.Format),
+		util.ValueIf(r.cfg.Format == Format_Csv, []byte(csvHeader), util.VersionedHeader(binMagic, BinVersion)),
+		r.cfg.Rotate)
+
+	r.mu.Lock()
+	r.writers = append(r.writers, w)
+	r.mu.Unlock()
+	return w
+}
+
+func (r *Recorder) post(w *rotatingWriter, t time.Time, data []byte) {
+	select {
+	case r.chRecord <- record{w: w, t: t, data: data}:
+	default:
+		if atomic.AddInt64(&r.dropped, 1)%10000 == 1 {
+			logger.LogImportant(logPrefix, "record queue full, %d records dropped", atomic.LoadInt64(&r.dropped))
+		}
+	}
+}
+
+func (r *Recorder) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rec := <-r.chRecord:
+			rec.w.write(rec.t, rec.data)
+			atomic.AddInt64(&r.written, 1)
+		case <-ticker.C:
+			r.mu.Lock()
+			for _, w := range r.writers {
+				w.flush()
+			}
+			r.mu.Unlock()
+		case <-r.chStop:
+			for len(r.chRecord) > 0 {
+				rec := <-r.chRecord
+				rec.w.write(rec.t, rec.data)
+				atomic.AddInt64(&r.written, 1)
+			}
+
+			r.mu.Lock()
+			for _, w := range r.writers {
+				w.close()
+			}
+			r.mu.Unlock()
+			logger.LogImportant(logPrefix, "stopped, written=%d, dropped=%d", r.written, r.dropped)
+			r.chDone <- 0
+			return
+		}
+	}
+}
+
+// 深度观察者，按DepthInterval节流
+type depthRecorder struct {
+	r    *Recorder
+	m    common.CommonMarket
+	w    *rotatingWriter
+	last time.Time
+}
+
+func (d *depthRecorder) OnDepthChanged() {
+	now := time.Now()
+	if d.r.cfg.DepthInterval > 0 && now.Sub(d.last) < d.r.cfg.DepthInterval {
+		return
+	}
+	d.last = now
+
+	asks, bids := d.m.OrderBook().Levels(d.r.cfg.DepthLevels)
+	d.r.post(d.w, now, d.r.encodeDepth(now, asks, bids))
+}
@@ -0,0 +1,106 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-01 15:40:12
+- @Description: 按时间滚动的压缩文件(flate)
+- 文件名为<dir>/<起始时间>.<ext>.flate，可用util.OpenCompressedFile_Flate读取
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package recorder
+
+import (
+	"bufio"
+	"compress/flate"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type rotatingWriter struct {
+	dir    string
+	ext    string
+	header []byte // csv表头或bin版本头，每个新文件开头写入
+	rotate time.Duration
+
+	start time.Time
+	file  *os.File
+	zw    *flate.Writer
+	bw    *bufio.Writer
+	size  int64
+}
+
+func (w *rotatingWriter) init(dir, ext string, header []byte, rotate time.Duration) {
+	w.dir = dir
+	w.ext = ext
+	w.header = header
+	w.rotate = rotate
+}
+
+func (w *rotatingWriter) write(now time.Time, b []byte) {
+	start := now.Truncate(w.rotate)
+	if w.file == nil || !start.Equal(w.start) {
+		w.close()
+		if !w.open(start) {
+			return
+		}
+	}
+
+	if n, err := w.bw.Write(b); err == nil {
+		w.size += int64(n)
+	} else {
+		logger.LogImportant(logPrefix, "write %s failed: %s", w.file.Name(), err.Error())
+	}
+}
+
+func (w *rotatingWriter) open(start time.Time) bool {
+	path := fmt.Sprintf("%s/%s.%s.flate", w.dir, start.UTC().Format("2006-01-02_1504"), w.ext)
+	if !util.MakeSureDirForFile(path) {
+		logger.LogImportant(logPrefix, "make dir for %s failed", path)
+		return false
+	}
+
+	// 同一周期重启时追加写入。flate流可以首尾相接，但解压时只能读出第一段，所以换一个文件名
+	for i := 1; ; i++ {
+		if exist, _ := util.PathExists(path); !exist {
+			break
+		}
+		path = fmt.Sprintf("%s/%s_%d.%s.flate", w.dir, start.UTC().Format("2006-01-02_1504"), i, w.ext)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		logger.LogImportant(logPrefix, "open %s failed: %s", path, err.Error())
+		return false
+	}
+
+	zw, _ := flate.NewWriter(f, flate.DefaultCompression)
+	w.file = f
+	w.zw = zw
+	w.bw = bufio.NewWriterSize(zw, 1024*64)
+	w.start = start
+	w.size = 0
+	if len(w.header) > 0 {
+		w.bw.Write(w.header)
+	}
+	logger.LogInfo(logPrefix, "recording to %s", path)
+	return true
+}
+
+func (w *rotatingWriter) flush() {
+	if w.file != nil {
+		w.bw.Flush()
+		w.zw.Flush()
+	}
+}
+
+func (w *rotatingWriter) close() {
+	if w.file != nil {
+		w.bw.Flush()
+		w.zw.Close()
+		w.file.Close()
+		logger.LogInfo(logPrefix, "%s closed, %d bytes before compress", w.file.Name(), w.size)
+		w.file = nil
+	}
+}
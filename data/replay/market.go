@@ -0,0 +1,142 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-04 15:48:31
+- @Description: 回放用的行情，实现common.CommonMarket。深度和成交全部来自录制文件
+- 录制的深度为前N档快照，每条记录重建一次订单簿
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package replay
+
+import (
+	"fmt"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/emirpasic/gods/sets/hashset"
+	"github.com/shopspring/decimal"
+)
+
+type Market struct {
+	instId        string
+	instrumentMgr *common.InstrumentMgr
+	orderBook     *common.Orderbook
+	latestPrice   decimal.Decimal
+	depthOK       bool
+
+	// 深度变化回调
+	depthObserversSet *hashset.Set
+	depthObservers    []interface{}
+
+	// 逐笔成交回调
+	trades common.TradeHub
+	barHub common.BarHub
+}
+
+func (m *Market) init(inst common.Instruments, instrumentMgr *common.InstrumentMgr, clock common.Clock) {
+	m.instId = inst.Id
+	m.instrumentMgr = instrumentMgr
+	m.instrumentMgr.Set(inst.Id, &inst)
+	m.orderBook = common.NewOrderBook()
+	m.depthObserversSet = hashset.New()
+	m.barHub.SetClock(clock)
+}
+
+func (m *Market) onEvent(e *event) {
+	if e.kind == eventKind_Depth {
+		m.orderBook.Rebuild(e.asks, e.bids)
+		m.depthOK = !m.orderBook.Empty()
+		for _, o := range m.depthObservers {
+			o.(common.DepthObserver).OnDepthChanged()
+		}
+	} else {
+		m.latestPrice = e.trade.Price
+		m.trades.Publish(e.trade)
+	}
+}
+
+// #region 实现common.CommonMarket
+func (m *Market) Type() string {
+	return m.instId
+}
+
+func (m *Market) String() string {
+	return fmt.Sprintf("replay market: %s\nprice: %s\ndepth:\n%s", m.instId, m.latestPrice.String(), m.orderBook.String(5))
+}
+
+func (m *Market) TradingTime() common.TradingTimes {
+	return nil
+}
+
+func (m *Market) Ready() bool {
+	return m.depthOK
+}
+
+func (m *Market) UnreadyReason() string {
+	if !m.depthOK {
+		return "depth not replayed yet"
+	}
+	return ""
+}
+
+func (m *Market) Uninit() {
+}
+
+func (m *Market) LatestPrice() decimal.Decimal {
+	return m.latestPrice
+}
+
+func (m *Market) OrderBook() *common.Orderbook {
+	return m.orderBook
+}
+
+func (m *Market) DepthConsistent() bool {
+	return m.depthOK
+}
+
+func (m *Market) AlignPriceNumber(price decimal.Decimal) decimal.Decimal {
+	return m.instrumentMgr.AlignPriceNumber(m.instId, price)
+}
+
+func (m *Market) AlignPrice(price decimal.Decimal, dir common.OrderDir, makeOnly bool) decimal.Decimal {
+	return m.instrumentMgr.AlignPrice(m.instId, price, dir, makeOnly, m.orderBook.Buy1Price(), m.orderBook.Sell1Price())
+}
+
+func (m *Market) AlignSize(size decimal.Decimal) decimal.Decimal {
+	return m.instrumentMgr.AlignSize(m.instId, size)
+}
+
+func (m *Market) MinSize() decimal.Decimal {
+	return m.instrumentMgr.MinSize(m.instId, m.latestPrice)
+}
+
+func (m *Market) TickSize() decimal.Decimal {
+	return m.instrumentMgr.TickSize(m.instId)
+}
+
+func (m *Market) AddDepthObserver(o common.DepthObserver) {
+	m.depthObserversSet.Add(o)
+	m.depthObservers = m.depthObserversSet.Values()
+}
+
+func (m *Market) RemoveDepthObserver(o common.DepthObserver) {
+	m.depthObserversSet.Remove(o)
+	m.depthObservers = m.depthObserversSet.Values()
+}
+
+func (m *Market) SubscribeTrades(fn func(t common.PublicTrade)) int {
+	return m.trades.Add(fn, nil)
+}
+
+func (m *Market) UnsubscribeTrades(id int) {
+	m.trades.Remove(id, nil)
+}
+
+func (m *Market) Bars(cfg common.BarConfig) *common.BarBuilder {
+	return m.barHub.Use(cfg, m.SubscribeTrades)
+}
+
+// 录制文件中没有24小时统计，只提供最新价
+func (m *Market) Ticker24h() common.Ticker24h {
+	return common.Ticker24h{Last: m.latestPrice}
+}
+
+// #endregion
@@ -0,0 +1,278 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-04 15:20:06
+- @Description: 读取recorder录制的文件(格式见recorder/encoding.go)
+- 同一品种同一种数据的文件按文件名(即起始时间)顺序读取，逐条解码
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/data/recorder"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type eventKind int
+
+const (
+	eventKind_Depth eventKind = iota
+	eventKind_Trade
+)
+
+// 一条录制记录
+type event struct {
+	t     time.Time // 本地接收时间
+	kind  eventKind
+	trade common.PublicTrade
+	asks  []decimal.Decimal // px,sz,px,sz
+	bids  []decimal.Decimal
+}
+
+// 某个品种某种数据的顺序读取器
+type streamReader struct {
+	m     *Market
+	kind  eventKind
+	files []string
+
+	f       *util.CompressedFile
+	br      *bufio.Reader
+	binary  bool
+	version uint16 // bin文件的版本
+
+	next *event // 预读的下一条
+}
+
+func newStreamReader(m *Market, kind eventKind, dir string) *streamReader {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.flate"))
+	slices.Sort(files)
+	if len(files) == 0 {
+		return nil
+	}
+	return &streamReader{m: m, kind: kind, files: files}
+}
+
+// 查看下一条，读完时返回nil
+func (s *streamReader) peek() *event {
+	for s.next == nil {
+		if s.br == nil && !s.openNext() {
+			return nil
+		}
+
+		e, err := s.read()
+		if err == nil {
+			s.next = e
+		} else {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				logger.LogImportant(logPrefix, "read %s failed: %s", s.m.instId, err.Error())
+			}
+			s.f.Close()
+			s.f = nil
+			s.br = nil
+		}
+	}
+	return s.next
+}
+
+func (s *streamReader) pop() *event {
+	e := s.peek()
+	s.next = nil
+	return e
+}
+
+func (s *streamReader) close() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+		s.br = nil
+	}
+	s.files = nil
+}
+
+func (s *streamReader) openNext() bool {
+	for len(s.files) > 0 {
+		path := s.files[0]
+		s.files = s.files[1:]
+		f, err := util.OpenCompressedFile_Flate(path)
+		if err != nil {
+			logger.LogImportant(logPrefix, "open %s failed: %s", path, err.Error())
+			continue
+		}
+
+		s.f = f
+		s.br = bufio.NewReaderSize(f, 1024*64)
+		s.binary = strings.HasSuffix(path, ".bin.flate")
+		if s.binary {
+			s.version = util.ReadVersionedHeader(s.br, util.ValueIf(s.kind == eventKind_Depth, recorder.BinMagic_Depth, recorder.BinMagic_Trade))
+			if s.version > recorder.BinVersion {
+				logger.LogImportant(logPrefix, "%s: version %d is newer than supported version %d, skipped", path, s.version, recorder.BinVersion)
+				f.Close()
+				s.f = nil
+				s.br = nil
+				continue
+			}
+		} else {
+			s.br.ReadString('\n') // 表头
+		}
+		logger.LogInfo(logPrefix, "replaying %s", path)
+		return true
+	}
+	return false
+}
+
+func (s *streamReader) read() (*event, error) {
+	if s.binary {
+		if s.kind == eventKind_Depth {
+			return s.readDepthBin()
+		}
+		return s.readTradeBin()
+	}
+
+	line, err := s.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if s.kind == eventKind_Depth {
+		return s.parseDepthCsv(fields)
+	}
+	return s.parseTradeCsv(fields)
+}
+
+func (s *streamReader) readTradeBin() (*event, error) {
+	var recv, ts int64
+	var px, sz float64
+	var side int8
+	for _, v := range []any{&recv, &ts, &px, &sz, &side} {
+		if err := binary.Read(s.br, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+
+	id := ""
+	if s.version >= 1 {
+		var n uint16
+		if err := binary.Read(s.br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(s.br, b); err != nil {
+			return nil, err
+		}
+		id = string(b)
+	}
+
+	e := &event{t: time.UnixMicro(recv), kind: eventKind_Trade}
+	e.trade = common.PublicTrade{
+		LocalTime: e.t,
+		UTime:     time.UnixMilli(ts),
+		Id:        id,
+		Price:     decimal.NewFromFloat(px),
+		Size:      decimal.NewFromFloat(sz),
+		Dir:       common.OrderDir(side),
+	}
+	return e, nil
+}
+
+func (s *streamReader) readDepthBin() (*event, error) {
+	var recv int64
+	var nAsk, nBid uint16
+	for _, v := range []any{&recv, &nAsk, &nBid} {
+		if err := binary.Read(s.br, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+
+	raw := make([]float64, (int(nAsk)+int(nBid))*2)
+	if err := binary.Read(s.br, binary.LittleEndian, raw); err != nil {
+		return nil, err
+	}
+
+	e := &event{t: time.UnixMicro(recv), kind: eventKind_Depth}
+	levels := make([]decimal.Decimal, len(raw))
+	for i, v := range raw {
+		if !math.IsNaN(v) {
+			levels[i] = decimal.NewFromFloat(v)
+		}
+	}
+	e.asks = levels[:int(nAsk)*2]
+	e.bids = levels[int(nAsk)*2:]
+	return e, nil
+}
+
+func (s *streamReader) parseTradeCsv(fields []string) (*event, error) {
+	if len(fields) < 6 {
+		return nil, os.ErrInvalid
+	}
+
+	recv, ok0 := util.String2Int64(fields[0])
+	ts, ok1 := util.String2Int64(fields[1])
+	px, ok2 := util.String2Decimal(fields[3])
+	sz, ok3 := util.String2Decimal(fields[4])
+	if !ok0 || !ok1 || !ok2 || !ok3 {
+		return nil, os.ErrInvalid
+	}
+
+	e := &event{t: time.UnixMicro(recv), kind: eventKind_Trade}
+	e.trade = common.PublicTrade{
+		LocalTime: e.t,
+		UTime:     time.UnixMilli(ts),
+		Id:        fields[2],
+		Price:     px,
+		Size:      sz,
+		Dir:       util.ValueIf(fields[5] == "buy", common.OrderDir_Buy, util.ValueIf(fields[5] == "sell", common.OrderDir_Sell, common.OrderDir_None)),
+	}
+	return e, nil
+}
+
+func (s *streamReader) parseDepthCsv(fields []string) (*event, error) {
+	if len(fields) < 3 {
+		return nil, os.ErrInvalid
+	}
+
+	recv, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &event{t: time.UnixMicro(recv), kind: eventKind_Depth}
+	e.asks = parseLevels(fields[1])
+	e.bids = parseLevels(fields[2])
+	return e, nil
+}
+
+// px:sz;px:sz
+func parseLevels(str string) []decimal.Decimal {
+	levels := []decimal.Decimal{}
+	if len(str) == 0 {
+		return levels
+	}
+
+	for _, l := range strings.Split(str, ";") {
+		ss := strings.Split(l, ":")
+		if len(ss) != 2 {
+			continue
+		}
+		px, ok0 := util.String2Decimal(ss[0])
+		sz, ok1 := util.String2Decimal(ss[1])
+		if ok0 && ok1 {
+			levels = append(levels, px, sz)
+		}
+	}
+	return levels
+}
@@ -0,0 +1,201 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-04 15:02:44
+- @Description: 行情回放。读取recorder录制的深度/逐笔成交，按接收时间顺序驱动回放行情(replay.Market)
+- 策略使用common.CommonMarket接口，不需要关心行情来自交易所还是录制文件
+- Speed为回放倍速，1为原速，10为10倍速，0为不等待，尽快回放完毕
+- 所有回调都在回放协程中按顺序触发。回放时钟(Clock)为最近一条记录的接收时间，回放行情的K线合成等使用该时钟
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package replay
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const logPrefix = "replay"
+
+type Config struct {
+	Dir   string    `json:"dir"`   // 录制目录，与recorder.Config.Dir一致
+	Speed float64   `json:"speed"` // 回放倍速，0表示尽快回放
+	T0    time.Time `json:"t0"`    // 回放起止时间，零值表示不限制
+	T1    time.Time `json:"t1"`
+}
+
+type Replayer struct {
+	cfg           Config
+	instrumentMgr *common.InstrumentMgr
+	markets       map[string]*Market
+	streams       []*streamReader
+
+	clock      *common.VirtualClock // 回放时钟，为最近一条记录的接收时间
+	mu         sync.Mutex
+	replayed   int64
+	fnFinished func()
+
+	chStop chan int
+	chDone chan int
+}
+
+func (r *Replayer) Init(cfg Config) {
+	r.cfg = cfg
+	r.instrumentMgr = common.NewInstrumentMgr(logPrefix)
+	r.markets = make(map[string]*Market)
+	r.clock = common.NewVirtualClock(time.Time{})
+	r.chStop = make(chan int, 1)
+	r.chDone = make(chan int, 1)
+}
+
+// 添加回放品种，inst用于价格/数量对齐，至少需要Id。同一品种重复添加时返回同一个Market
+func (r *Replayer) AddMarket(inst common.Instruments) *Market {
+	if m, ok := r.markets[inst.Id]; ok {
+		return m
+	}
+
+	m := new(Market)
+	m.init(inst, r.instrumentMgr, r.clock)
+	r.markets[inst.Id] = m
+
+	for _, kind := range []eventKind{eventKind_Depth, eventKind_Trade} {
+		dir := fmt.Sprintf("%s/%s/%s", r.cfg.Dir, inst.Id, util.ValueIf(kind == eventKind_Depth, "depth", "trades"))
+		if s := newStreamReader(m, kind, dir); s != nil {
+			r.streams = append(r.streams, s)
+		}
+	}
+
+	logger.LogImportant(logPrefix, "market %s added", inst.Id)
+	return m
+}
+
+// 从t之后开始回放，并将回放时钟设为t，用于从检查点恢复。需在Go之前调用
+func (r *Replayer) ResumeFrom(t time.Time) {
+	r.cfg.T0 = t.Add(time.Nanosecond)
+	r.clock.Set(t)
+}
+
+// 回放结束(读完或到达T1)时回调
+func (r *Replayer) SetFinishedFn(fn func()) {
+	r.fnFinished = fn
+}
+
+func (r *Replayer) Go() {
+	r.startClock()
+	go r.update()
+}
+
+// 中途停止回放
+func (r *Replayer) Stop() {
+	select {
+	case r.chStop <- 0:
+	default:
+	}
+}
+
+// 等待回放结束
+func (r *Replayer) Wait() {
+	<-r.chDone
+	r.chDone <- 0
+}
+
+// 回放时钟，Go之后为第一条记录的时间
+func (r *Replayer) Now() time.Time {
+	return r.clock.Now()
+}
+
+// 回放时钟，用于注入需要定时的组件。定时器随回放推进触发
+func (r *Replayer) Clock() common.Clock {
+	return r.clock
+}
+
+// 已回放的记录数
+func (r *Replayer) Replayed() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.replayed
+}
+
+// 回放时钟从第一条记录的接收时间开始，避免从零值跳到第一条记录时触发所有定时器
+func (r *Replayer) startClock() {
+	for s := r.nextStream(); s != nil; s = r.nextStream() {
+		if t := s.peek().t; r.cfg.T0.IsZero() || !t.Before(r.cfg.T0) {
+			r.clock.Start(t)
+			return
+		}
+		s.pop()
+	}
+}
+
+// 所有数据流中接收时间最早的一条
+func (r *Replayer) nextStream() *streamReader {
+	var next *streamReader
+	for _, s := range r.streams {
+		if e := s.peek(); e != nil {
+			if next == nil || e.t.Before(next.peek().t) {
+				next = s
+			}
+		}
+	}
+	return next
+}
+
+func (r *Replayer) update() {
+	defer util.DefaultRecover()
+	defer r.finish()
+
+	var tFirst, wallStart time.Time
+	for {
+		select {
+		case <-r.chStop:
+			logger.LogImportant(logPrefix, "stopped")
+			return
+		default:
+		}
+
+		s := r.nextStream()
+		if s == nil {
+			return
+		}
+
+		e := s.pop()
+		if !r.cfg.T0.IsZero() && e.t.Before(r.cfg.T0) {
+			continue
+		}
+		if !r.cfg.T1.IsZero() && !e.t.Before(r.cfg.T1) {
+			return
+		}
+
+		// 按倍速等待
+		if tFirst.IsZero() {
+			tFirst, wallStart = e.t, time.Now()
+		} else if r.cfg.Speed > 0 {
+			wallTime := wallStart.Add(time.Duration(float64(e.t.Sub(tFirst)) / r.cfg.Speed))
+			if d := time.Until(wallTime); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		r.clock.Set(e.t)
+		r.mu.Lock()
+		r.replayed++
+		r.mu.Unlock()
+		s.m.onEvent(e)
+	}
+}
+
+func (r *Replayer) finish() {
+	for _, s := range r.streams {
+		s.close()
+	}
+
+	logger.LogImportant(logPrefix, "finished, %d records replayed, clock=%s", r.Replayed(), r.Now().Format(time.DateTime))
+	if r.fnFinished != nil {
+		r.fnFinished()
+	}
+	r.chDone <- 0
+}
@@ -0,0 +1,246 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-12 15:03:18
+- @Description: 冰山单执行器。用于不支持原生冰山单的交易所
+- 盘口上只显示一小段(clip)，成交完后隔一段随机时间再挂下一段，每段数量也做随机化，降低被识别的概率
+- 限价修改后撤掉当前段并立即按新价格重挂，不等待
+- 下单时不持有锁，模拟交易器会在MakeOrder返回前同步回调成交
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package iceberg
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 参数
+type Config struct {
+	Amount     decimal.Decimal // 总数量
+	Dir        common.OrderDir // 方向
+	Price      decimal.Decimal // 限价
+	Clip       decimal.Decimal // 每段显示数量
+	ClipRandom float64         // 每段数量随机浮动比例，如0.2表示在[0.8,1.2]*Clip之间
+	DelayMinMs int64           // 上一段完全成交后，等待多久再挂下一段
+	DelayMaxMs int64
+	MakeOnly   bool
+	ReduceOnly bool
+	Purpose    string
+}
+
+type Iceberg struct {
+	logPrefix string
+	mu        sync.Mutex
+	trader    common.CommonTrader
+	cfg       Config
+
+	filled         decimal.Decimal
+	filledMulPrice decimal.Decimal
+	clips          int
+	o              common.Order
+	nextPlaceTime  time.Time
+	repricing      bool // 当前段因限价修改而被撤
+	price          decimal.Decimal
+	running        bool
+	chStop         chan int
+
+	fnDeal   func(deal common.Deal)
+	fnFinish func(filled, avgPrice decimal.Decimal)
+}
+
+func (ib *Iceberg) Init(trader common.CommonTrader, cfg Config) {
+	ib.trader = trader
+	ib.cfg = cfg
+	ib.price = cfg.Price
+	ib.logPrefix = fmt.Sprintf("iceberg-%s-%s", trader.Market().Type(), cfg.Purpose)
+	ib.chStop = make(chan int, 1)
+}
+
+func (ib *Iceberg) SetDealFn(fn func(deal common.Deal)) {
+	ib.fnDeal = fn
+}
+
+func (ib *Iceberg) SetFinishFn(fn func(filled, avgPrice decimal.Decimal)) {
+	ib.fnFinish = fn
+}
+
+func (ib *Iceberg) Go() {
+	ib.mu.Lock()
+	ib.running = true
+	ib.mu.Unlock()
+	logger.LogInfo(ib.logPrefix, "start, %s %v@%v, clip=%v", common.OrderDir2Str(ib.cfg.Dir), ib.cfg.Amount, ib.cfg.Price, ib.cfg.Clip)
+	go ib.update()
+}
+
+// 提前终止，撤掉当前挂单
+func (ib *Iceberg) Stop() {
+	ib.chStop <- 0
+}
+
+// 修改限价，当前挂单会被撤掉并按新价格重挂
+func (ib *Iceberg) SetPrice(price decimal.Decimal) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.price = price
+}
+
+func (ib *Iceberg) Filled() decimal.Decimal {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	return ib.filled
+}
+
+func (ib *Iceberg) AvgPrice() decimal.Decimal {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	return ib.avgPrice()
+}
+
+func (ib *Iceberg) avgPrice() decimal.Decimal {
+	if ib.filled.IsZero() {
+		return decimal.Zero
+	}
+	return ib.filledMulPrice.Div(ib.filled)
+}
+
+func (ib *Iceberg) Finished() bool {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	return !ib.running
+}
+
+// 实现common.OrderObserver
+func (ib *Iceberg) OnDeal(deal common.Deal) {
+	ib.mu.Lock()
+	ib.filled = ib.filled.Add(deal.Amount)
+	ib.filledMulPrice = ib.filledMulPrice.Add(deal.Amount.Mul(deal.Price))
+	ib.mu.Unlock()
+
+	logger.LogInfo(ib.logPrefix, "clip dealing, price=%v, amount=%v", deal.Price, deal.Amount)
+	if ib.fnDeal != nil {
+		ib.fnDeal(deal)
+	}
+}
+
+func (ib *Iceberg) update() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if ib.step() {
+				ib.finish()
+				return
+			}
+		case <-ib.chStop:
+			ib.mu.Lock()
+			if ib.o != nil && !ib.o.IsFinished() {
+				ib.o.Cancel()
+			}
+			ib.mu.Unlock()
+			ib.finish()
+			return
+		}
+	}
+}
+
+// 返回true表示结束
+func (ib *Iceberg) step() bool {
+	ib.mu.Lock()
+	if ib.o != nil {
+		if ib.o.IsFinished() {
+			if !ib.o.GetUnfilled().IsPositive() {
+				// 本段完全成交，随机等待一段时间再挂下一段
+				delay := util.RandomInt64(ib.cfg.DelayMinMs, ib.cfg.DelayMaxMs)
+				ib.nextPlaceTime = time.Now().Add(time.Millisecond * time.Duration(delay))
+			} else if !ib.repricing {
+				// 被交易所撤销或拒绝，过一会儿再试
+				ib.nextPlaceTime = time.Now().Add(time.Second)
+			}
+			ib.o = nil
+			ib.repricing = false
+		} else {
+			if !ib.o.GetPrice().Equal(ib.trader.Market().AlignPrice(ib.price, ib.cfg.Dir, ib.cfg.MakeOnly)) {
+				ib.repricing = true
+				ib.o.Cancel()
+			}
+			ib.mu.Unlock()
+			return false
+		}
+	}
+
+	remain := ib.cfg.Amount.Sub(ib.filled)
+	if remain.LessThan(ib.trader.Market().MinSize()) {
+		ib.mu.Unlock()
+		return true
+	}
+
+	if time.Now().Before(ib.nextPlaceTime) {
+		ib.mu.Unlock()
+		return false
+	}
+
+	size := ib.trader.Market().AlignSize(decimal.Min(ib.randomClip(), remain))
+	if size.LessThan(ib.trader.Market().MinSize()) {
+		size = ib.trader.Market().AlignSize(remain)
+	}
+
+	px := ib.trader.Market().AlignPrice(ib.price, ib.cfg.Dir, ib.cfg.MakeOnly)
+	ib.mu.Unlock()
+
+	// 成交回调可能在MakeOrder返回前到达，只累计数量，不依赖ib.o
+	o := ib.trader.MakeOrder(px, size, ib.cfg.Dir, ib.cfg.MakeOnly, ib.cfg.ReduceOnly, ib.cfg.Purpose, ib)
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	ib.o = o
+	if ib.o == nil {
+		// 本地校验未通过，过一会儿再试
+		ib.nextPlaceTime = time.Now().Add(time.Second)
+	} else {
+		ib.clips++
+	}
+	return false
+}
+
+func (ib *Iceberg) randomClip() decimal.Decimal {
+	if ib.cfg.ClipRandom <= 0 {
+		return ib.cfg.Clip
+	}
+
+	r := 1 + (rand.Float64()*2-1)*ib.cfg.ClipRandom
+	return ib.cfg.Clip.Mul(decimal.NewFromFloat(r))
+}
+
+func (ib *Iceberg) finish() {
+	// 等待残留挂单结束
+	for i := 0; i < 100; i++ {
+		ib.mu.Lock()
+		done := ib.o == nil || ib.o.IsFinished()
+		ib.mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	ib.mu.Lock()
+	ib.running = false
+	filled := ib.filled
+	avgPrice := ib.avgPrice()
+	clips := ib.clips
+	ib.mu.Unlock()
+
+	logger.LogInfo(ib.logPrefix, "finished, filled=%v/%v, avgPrice=%v, clips=%d", filled, ib.cfg.Amount, avgPrice, clips)
+	if ib.fnFinish != nil {
+		ib.fnFinish(filled, avgPrice)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-11 10:12:35
+- @Description: TWAP执行器。把母单在时间窗口内均匀切分成子单执行
+- 每个周期计算应完成的累计数量，落后多少补多少，单个子单受盘口参与率限制
+- 上个周期的子单未完结时，撤掉并在同一周期重新下，撤单中子单的未成交部分不重复下
+- 窗口结束时撤掉残留子单，可选把剩余部分直接吃掉
+- 下单时不持有锁，模拟交易器会在MakeOrder返回前同步回调成交。成交只累计到母单，与子单是否已登记无关，不需要缓存
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package twap
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 参数
+type Config struct {
+	Amount           decimal.Decimal // 母单总数量
+	Dir              common.OrderDir // 方向
+	Duration         time.Duration   // 执行窗口
+	Interval         time.Duration   // 子单周期
+	MaxParticipation float64         // 子单数量不超过对手盘一档数量的这个比例，0表示不限制
+	MakeOnly         bool            // 子单只挂在己方一档，否则吃对手一档
+	ReduceOnly       bool            // 只减仓
+	FinishByTake     bool            // 窗口结束时，剩余部分是否直接吃单完成
+	CatchUpRatio     float64         // 落后进度超过母单的这个比例时，即使MakeOnly也改为吃单追赶，0表示不追赶
+	Purpose          string
+	Clock            common.Clock // 为nil时使用本地时间，回测时应为回放时钟
+}
+
+// 执行进度
+type Progress struct {
+	Amount    decimal.Decimal // 母单总数量
+	Target    decimal.Decimal // 当前时刻应完成数量
+	Filled    decimal.Decimal // 已成交
+	AvgPrice  decimal.Decimal // 成交均价
+	Children  int             // 已下子单数
+	StartTime time.Time
+	EndTime   time.Time
+	Finished  bool
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("[filled:%v/%v target:%v avg:%v children:%d finished:%v]",
+		p.Filled, p.Amount, p.Target, p.AvgPrice, p.Children, p.Finished)
+}
+
+// 返回t时刻应完成的累计比例[0,1]。默认为按时间线性
+type ScheduleFn func(t time.Time) decimal.Decimal
+
+type TWAP struct {
+	logPrefix string
+	mu        sync.Mutex
+	trader    common.CommonTrader
+	cfg       Config
+	clock     common.Clock
+
+	startTime      time.Time
+	endTime        time.Time
+	filled         decimal.Decimal
+	filledMulPrice decimal.Decimal
+	children       int
+	o              common.Order
+	canceling      []common.Order // 已撤但尚未完结的子单，其未成交部分仍可能成交
+	finished       bool
+	chStop         chan int
+
+	fnSchedule ScheduleFn
+	fnProgress func(p Progress)
+	fnFinish   func(p Progress)
+}
+
+// 待下的子单
+type child struct {
+	price decimal.Decimal
+	size  decimal.Decimal
+	take  bool
+}
+
+func (t *TWAP) Init(trader common.CommonTrader, cfg Config) {
+	t.trader = trader
+	t.cfg = cfg
+	if t.cfg.Interval <= 0 {
+		t.cfg.Interval = time.Second * 10
+	}
+	t.clock = common.ClockOrReal(cfg.Clock)
+	t.logPrefix = fmt.Sprintf("twap-%s-%s", trader.Market().Type(), cfg.Purpose)
+	t.chStop = make(chan int, 1)
+	t.fnSchedule = t.linearSchedule
+}
+
+func (t *TWAP) Clock() common.Clock {
+	return t.clock
+}
+
+// 替换成交进度曲线（如VWAP）
+func (t *TWAP) SetSchedule(fn ScheduleFn) {
+	t.fnSchedule = fn
+}
+
+func (t *TWAP) SetProgressFn(fn func(p Progress)) {
+	t.fnProgress = fn
+}
+
+func (t *TWAP) SetFinishFn(fn func(p Progress)) {
+	t.fnFinish = fn
+}
+
+func (t *TWAP) Go() {
+	t.mu.Lock()
+	t.startTime = t.clock.Now()
+	t.endTime = t.startTime.Add(t.cfg.Duration)
+	t.mu.Unlock()
+
+	logger.LogInfo(t.logPrefix, "start, %s %v in %v", common.OrderDir2Str(t.cfg.Dir), t.cfg.Amount, t.cfg.Duration)
+	go t.update()
+}
+
+// 提前终止，会撤掉残留子单
+func (t *TWAP) Stop() {
+	t.chStop <- 0
+}
+
+func (t *TWAP) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.progress()
+}
+
+func (t *TWAP) progress() Progress {
+	p := Progress{
+		Amount:    t.cfg.Amount,
+		Target:    t.target(t.clock.Now()),
+		Filled:    t.filled,
+		Children:  t.children,
+		StartTime: t.startTime,
+		EndTime:   t.endTime,
+		Finished:  t.finished,
+	}
+
+	if t.filled.IsPositive() {
+		p.AvgPrice = t.filledMulPrice.Div(t.filled)
+	}
+	return p
+}
+
+func (t *TWAP) linearSchedule(now time.Time) decimal.Decimal {
+	if t.cfg.Duration <= 0 || !now.Before(t.endTime) {
+		return util.DecimalOne
+	}
+
+	elapsed := now.Sub(t.startTime)
+	return decimal.NewFromFloat(float64(elapsed) / float64(t.cfg.Duration))
+}
+
+// 当前应完成数量。以周期末端计算，保证每个周期开始时就把本周期的量挂出去
+func (t *TWAP) target(now time.Time) decimal.Decimal {
+	r := util.ClampDecimal(t.fnSchedule(now.Add(t.cfg.Interval)), decimal.Zero, util.DecimalOne)
+	return t.trader.Market().AlignSize(t.cfg.Amount.Mul(r))
+}
+
+// 实现common.OrderObserver
+func (t *TWAP) OnDeal(deal common.Deal) {
+	t.mu.Lock()
+	t.filled = t.filled.Add(deal.Amount)
+	t.filledMulPrice = t.filledMulPrice.Add(deal.Amount.Mul(deal.Price))
+	p := t.progress()
+	t.mu.Unlock()
+
+	logger.LogInfo(t.logPrefix, "child dealing, price=%v, amount=%v, progress=%s", deal.Price, deal.Amount, p.String())
+	if t.fnProgress != nil {
+		t.fnProgress(p)
+	}
+}
+
+func (t *TWAP) update() {
+	defer util.DefaultRecover()
+	ticker := t.clock.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+
+	t.step()
+	for {
+		select {
+		case <-ticker.C():
+			if t.step() {
+				return
+			}
+		case <-t.chStop:
+			t.mu.Lock()
+			t.finishByTake(false)
+			t.mu.Unlock()
+			t.waitAndFinish()
+			return
+		}
+	}
+}
+
+// 返回true表示结束
+func (t *TWAP) step() bool {
+	t.mu.Lock()
+	now := t.clock.Now()
+
+	// 残留子单撤掉，本周期重新下。撤单期间仍可能成交的部分从本次下单数量中扣除，避免超量
+	if t.o != nil {
+		if !t.o.IsFinished() {
+			t.o.Cancel()
+			t.canceling = append(t.canceling, t.o)
+		}
+		t.o = nil
+	}
+	pending := t.pruneCanceling()
+
+	remain := t.cfg.Amount.Sub(t.filled)
+	if remain.LessThan(t.trader.Market().MinSize()) {
+		t.mu.Unlock()
+		t.waitAndFinish()
+		return true
+	}
+
+	if !now.Before(t.endTime) {
+		c, ok := t.finishByTake(t.cfg.FinishByTake)
+		t.mu.Unlock()
+		if ok {
+			t.placeChild(c)
+		}
+		t.waitAndFinish()
+		return true
+	}
+
+	behind := t.target(now).Sub(t.filled)
+	size := t.capByParticipation(behind.Sub(pending))
+	if size.LessThan(t.trader.Market().MinSize()) {
+		t.mu.Unlock()
+		return false
+	}
+
+	take := !t.cfg.MakeOnly
+	if t.cfg.CatchUpRatio > 0 && behind.GreaterThan(t.cfg.Amount.Mul(decimal.NewFromFloat(t.cfg.CatchUpRatio))) {
+		take = true
+	}
+	c := t.newChild(size, take)
+	t.mu.Unlock()
+
+	t.placeChild(c)
+	return false
+}
+
+// 去掉已完结的撤单中子单，返回其余子单的未成交数量之和
+func (t *TWAP) pruneCanceling() decimal.Decimal {
+	pending := decimal.Zero
+	kept := t.canceling[:0]
+	for _, o := range t.canceling {
+		if !o.IsFinished() {
+			kept = append(kept, o)
+			pending = pending.Add(o.GetUnfilled())
+		}
+	}
+	t.canceling = kept
+	return pending
+}
+
+func (t *TWAP) capByParticipation(size decimal.Decimal) decimal.Decimal {
+	if t.cfg.MaxParticipation > 0 {
+		_, topSz := t.trader.Market().OrderBook().Sell1()
+		if t.cfg.Dir == common.OrderDir_Sell {
+			_, topSz = t.trader.Market().OrderBook().Buy1()
+		}
+		size = decimal.Min(size, topSz.Mul(decimal.NewFromFloat(t.cfg.MaxParticipation)))
+	}
+	return t.trader.Market().AlignSize(size)
+}
+
+// 按盘口计算子单价格。需加锁调用
+func (t *TWAP) newChild(size decimal.Decimal, take bool) child {
+	ob := t.trader.Market().OrderBook()
+	price := decimal.Zero
+	if t.cfg.Dir == common.OrderDir_Buy {
+		price = util.ValueIf(take, ob.Sell1Price(), ob.Buy1Price())
+	} else {
+		price = util.ValueIf(take, ob.Buy1Price(), ob.Sell1Price())
+	}
+
+	price = t.trader.Market().AlignPrice(price, t.cfg.Dir, !take)
+	return child{price: price, size: size, take: take}
+}
+
+// 不持有锁调用，成交回调可能在MakeOrder返回前到达
+func (t *TWAP) placeChild(c child) {
+	o := t.trader.MakeOrder(c.price, c.size, t.cfg.Dir, !c.take && t.cfg.MakeOnly, t.cfg.ReduceOnly, t.cfg.Purpose, t)
+	if o == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.o = o
+	t.children++
+	t.mu.Unlock()
+}
+
+// 窗口结束的处理，撤掉残留子单。take为true时返回吃掉剩余数量的子单，由调用方在锁外下单。需加锁调用
+func (t *TWAP) finishByTake(take bool) (child, bool) {
+	if t.o != nil && !t.o.IsFinished() {
+		t.o.Cancel()
+		t.canceling = append(t.canceling, t.o)
+		t.o = nil
+	}
+
+	if take {
+		remain := t.trader.Market().AlignSize(t.cfg.Amount.Sub(t.filled))
+		if remain.GreaterThanOrEqual(t.trader.Market().MinSize()) {
+			logger.LogInfo(t.logPrefix, "window end, take remain %v", remain)
+			return t.newChild(remain, true), true
+		}
+	}
+	return child{}, false
+}
+
+// 等待残留子单结束后回调
+func (t *TWAP) waitAndFinish() {
+	for i := 0; i < 100; i++ {
+		t.mu.Lock()
+		t.pruneCanceling()
+		done := (t.o == nil || t.o.IsFinished()) && len(t.canceling) == 0
+		t.mu.Unlock()
+		if done {
+			break
+		}
+		<-t.clock.After(time.Millisecond * 100)
+	}
+
+	t.mu.Lock()
+	t.finished = true
+	p := t.progress()
+	t.mu.Unlock()
+
+	logger.LogInfo(t.logPrefix, "finished, %s", p.String())
+	if t.fnFinish != nil {
+		t.fnFinish(p)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-21 14:45:09
+- @Description: VWAP执行器。用过去若干天同一时段的K线成交量作为日内成交量分布，按预期成交量比例安排子单
+- 子单执行复用twap，只替换进度曲线。落后进度过多时由twap的追赶逻辑改为吃单
+- 历史数据不可用时退化为TWAP
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package vwap
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/exec/twap"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+// 参数
+type Config struct {
+	twap.Config
+	LookbackDays int // 用多少天的历史数据计算成交量分布
+	BucketSec    int // 分布的时间粒度，同时也是K线周期
+}
+
+// 获取K线的函数，一般为CEx.GetSpotKline/GetFutureKline的包装
+type KlineFn func(t0, t1 time.Time, intervalSec int) []common.KUnit
+
+type VWAP struct {
+	twap.TWAP
+	logPrefix string
+	cfg       Config
+	fnKline   KlineFn
+
+	startTime time.Time
+	profile   []float64 // 每个时间片的预期成交量占比
+}
+
+func (v *VWAP) Init(trader common.CommonTrader, cfg Config, fnKline KlineFn) {
+	if cfg.LookbackDays <= 0 {
+		cfg.LookbackDays = 5
+	}
+	if cfg.BucketSec <= 0 {
+		cfg.BucketSec = 60
+	}
+
+	v.cfg = cfg
+	v.fnKline = fnKline
+	v.logPrefix = fmt.Sprintf("vwap-%s-%s", trader.Market().Type(), cfg.Purpose)
+	v.TWAP.Init(trader, cfg.Config)
+}
+
+func (v *VWAP) Go() {
+	v.startTime = v.TWAP.Clock().Now()
+	if v.buildProfile() {
+		v.TWAP.SetSchedule(v.schedule)
+	} else {
+		logger.LogImportant(v.logPrefix, "volume profile unavailable, fallback to twap")
+	}
+	v.TWAP.Go()
+}
+
+// 预期成交量分布
+func (v *VWAP) Profile() []float64 {
+	return v.profile
+}
+
+func (v *VWAP) buildProfile() bool {
+	bucket := time.Second * time.Duration(v.cfg.BucketSec)
+	n := int((v.cfg.Duration + bucket - 1) / bucket)
+	if n <= 0 || v.fnKline == nil {
+		return false
+	}
+
+	volumes := make([]float64, n)
+	total := 0.0
+	for d := 1; d <= v.cfg.LookbackDays; d++ {
+		t0 := v.startTime.AddDate(0, 0, -d)
+		t1 := t0.Add(v.cfg.Duration)
+		for _, ku := range v.fnKline(t0, t1, v.cfg.BucketSec) {
+			i := int(ku.Time.Sub(t0) / bucket)
+			if i >= 0 && i < n {
+				vol := ku.VolumeUSD.InexactFloat64()
+				volumes[i] += vol
+				total += vol
+			}
+		}
+	}
+
+	if total <= 0 {
+		return false
+	}
+
+	v.profile = make([]float64, n)
+	for i := range volumes {
+		v.profile[i] = volumes[i] / total
+	}
+	return true
+}
+
+// 累计应完成比例：已过完整时间片的占比之和 + 当前时间片按时间线性
+func (v *VWAP) schedule(t time.Time) decimal.Decimal {
+	bucket := time.Second * time.Duration(v.cfg.BucketSec)
+	elapsed := t.Sub(v.startTime)
+	if elapsed <= 0 {
+		return decimal.Zero
+	}
+
+	i := int(elapsed / bucket)
+	if i >= len(v.profile) {
+		return decimal.NewFromInt(1)
+	}
+
+	cum := 0.0
+	for j := 0; j < i; j++ {
+		cum += v.profile[j]
+	}
+	frac := float64(elapsed-bucket*time.Duration(i)) / float64(bucket)
+	cum += v.profile[i] * frac
+	return decimal.NewFromFloat(cum)
+}
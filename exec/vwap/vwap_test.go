@@ -0,0 +1,134 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-21 17:32:08
+- @Description: VWAP在回测交易器上的执行测试。下单确认无延迟时，吃单子单的成交在MakeOrder返回前同步回调
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package vwap
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aztecqt/dagger/backtest"
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/exec/twap"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(logger.SplitMode_ByDays, 1)
+	os.Exit(m.Run())
+}
+
+// 固定盘口的行情，只实现交易器和执行器用到的方法
+type stubSpotMarket struct {
+	common.SpotMarket
+	ob     *common.Orderbook
+	mgr    *common.InstrumentMgr
+	instId string
+}
+
+func (m *stubSpotMarket) Type() string                                      { return m.instId }
+func (m *stubSpotMarket) OrderBook() *common.Orderbook                      { return m.ob }
+func (m *stubSpotMarket) AddDepthObserver(o common.DepthObserver)           {}
+func (m *stubSpotMarket) RemoveDepthObserver(o common.DepthObserver)        {}
+func (m *stubSpotMarket) SubscribeTrades(fn func(t common.PublicTrade)) int { return 1 }
+func (m *stubSpotMarket) Ready() bool                                       { return true }
+func (m *stubSpotMarket) BaseCurrency() string                              { return "BTC" }
+func (m *stubSpotMarket) QuoteCurrency() string                             { return "USDT" }
+func (m *stubSpotMarket) LatestPrice() decimal.Decimal                      { return m.ob.MiddlePrice() }
+func (m *stubSpotMarket) AlignSize(sz decimal.Decimal) decimal.Decimal {
+	return m.mgr.AlignSize(m.instId, sz)
+}
+func (m *stubSpotMarket) MinSize() decimal.Decimal { return m.mgr.MinSize(m.instId, m.LatestPrice()) }
+func (m *stubSpotMarket) AlignPriceNumber(px decimal.Decimal) decimal.Decimal {
+	return m.mgr.AlignPriceNumber(m.instId, px)
+}
+func (m *stubSpotMarket) AlignPrice(px decimal.Decimal, dir common.OrderDir, makeOnly bool) decimal.Decimal {
+	return m.mgr.AlignPrice(m.instId, px, dir, makeOnly, m.ob.Buy1Price(), m.ob.Sell1Price())
+}
+
+func dec(v float64) decimal.Decimal {
+	return decimal.NewFromFloat(v)
+}
+
+func TestTakeChildrenWithoutAckLatency(t *testing.T) {
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	clock := common.NewVirtualClock(start)
+
+	mgr := common.NewInstrumentMgr("test")
+	inst := &common.Instruments{Id: "BTC-USDT", BaseCcy: "BTC", QuoteCcy: "USDT", TickSize: dec(0.1), LotSize: dec(0.001), MinSize: dec(0.001)}
+	mgr.Set(inst.Id, inst)
+	m := &stubSpotMarket{ob: common.NewOrderBook(), mgr: mgr, instId: inst.Id}
+	m.ob.Rebuild([]decimal.Decimal{dec(101), dec(10)}, []decimal.Decimal{dec(99), dec(10)})
+
+	acc := backtest.NewAccount(map[string]decimal.Decimal{"USDT": dec(1000)}, decimal.Zero, decimal.Zero, clock)
+	acc.SetMatchConfig(backtest.MatchConfig{}) // AckLatency=0，子单同步成交
+	acc.Isolate(common.NewEventBus())
+	tr := backtest.NewSpotTrader("test", acc, m, inst, mgr)
+
+	// 历史成交量前半段为后半段的3倍
+	fnKline := func(t0, t1 time.Time, intervalSec int) []common.KUnit {
+		kus := []common.KUnit{}
+		for i := 0; i < 6; i++ {
+			kus = append(kus, common.KUnit{Time: t0.Add(time.Second * time.Duration(intervalSec*i)), VolumeUSD: dec(float64(3 - i/3*2))})
+		}
+		return kus
+	}
+
+	v := new(VWAP)
+	v.Init(tr, Config{
+		Config: twap.Config{
+			Amount:       dec(1),
+			Dir:          common.OrderDir_Buy,
+			Duration:     time.Minute,
+			Interval:     time.Second * 10,
+			FinishByTake: true,
+			Purpose:      "test",
+			Clock:        clock,
+		},
+		LookbackDays: 1,
+		BucketSec:    10,
+	}, fnKline)
+
+	chFinish := make(chan twap.Progress, 1)
+	v.SetFinishFn(func(p twap.Progress) { chFinish <- p })
+	v.Go()
+	if len(v.Profile()) != 6 {
+		t.Fatalf("profile size = %d, expect 6", len(v.Profile()))
+	}
+
+	// 首个子单按前10秒的预期成交量下单并立即成交
+	deadline := time.Now().Add(time.Second * 5)
+	for v.Progress().Children == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if p := v.Progress(); !p.Filled.Equal(dec(0.25)) {
+		t.Fatalf("first child filled = %v, expect 0.25, progress=%s", p.Filled, p.String())
+	}
+
+	// 推进回放时间直到窗口结束，剩余部分吃单完成
+	var p twap.Progress
+	for {
+		select {
+		case p = <-chFinish:
+		case <-time.After(time.Millisecond * 20):
+			if time.Now().After(deadline) {
+				t.Fatalf("vwap not finished, progress=%s", v.Progress().String())
+			}
+			clock.Set(clock.Now().Add(time.Second * 10))
+			continue
+		}
+		break
+	}
+
+	if !p.Finished || !p.Filled.Equal(dec(1)) || !p.AvgPrice.Equal(dec(101)) {
+		t.Errorf("progress=%s, expect filled 1 at 101", p.String())
+	}
+	if p.Children < 2 {
+		t.Errorf("children = %d, expect at least 2", p.Children)
+	}
+}
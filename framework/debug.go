@@ -0,0 +1,108 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-06 15:03:48
+- @Description: 调试http服务，只监听localhost，远程使用时通过ssh隧道或port-forward访问
+- /debug/pprof/   标准的pprof，如 go tool pprof http://localhost:port/debug/pprof/heap
+- /debug/pprof/goroutine?debug=1  按调用栈汇总的协程，排查协程泄漏、卡死
+- /debug/stats    协程数、内存、GC、各模块的队列深度(见util/debugstats)
+- /debug/metrics  http请求统计(network.Metrics)和订单往返延迟(common.OrderLatencies)，延迟单位为毫秒
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/debugstats"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/network"
+)
+
+type debugService struct {
+	logPrefix string
+	server    *http.Server
+}
+
+type latencySummary struct {
+	Exchange string  `json:"exchange"`
+	Name     string  `json:"name"` // http接口或订单阶段
+	Count    int64   `json:"count"`
+	Errors   int64   `json:"errors,omitempty"`
+	Avg      float64 `json:"avg"`
+	P50      float64 `json:"p50"`
+	P90      float64 `json:"p90"`
+	P99      float64 `json:"p99"`
+	Max      float64 `json:"max"`
+}
+
+func (d *debugService) start(port int, logPrefix string) {
+	d.logPrefix = logPrefix
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/stats", d.onStats)
+	mux.HandleFunc("/debug/metrics", d.onMetrics)
+	d.server = &http.Server{Addr: fmt.Sprintf("localhost:%d", port), Handler: mux}
+
+	go func() {
+		defer util.DefaultRecover()
+		logger.LogInfo(d.logPrefix, "starting debug service at port %d", port)
+		if err := d.server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.LogImportant(d.logPrefix, "debug service ListenAndServe error: %s", err.Error())
+		}
+	}()
+}
+
+func (d *debugService) onStats(w http.ResponseWriter, r *http.Request) {
+	d.write(w, debugstats.Collect())
+}
+
+func (d *debugService) onMetrics(w http.ResponseWriter, r *http.Request) {
+	ms := func(t time.Duration) float64 {
+		return float64(t.Microseconds()) / 1000
+	}
+
+	https := []latencySummary{}
+	for _, s := range network.Metrics() {
+		https = append(https, latencySummary{
+			Exchange: s.Exchange,
+			Name:     s.Endpoint,
+			Count:    s.Count,
+			Errors:   s.Errors,
+			Avg:      ms(s.AvgLatency()),
+			P50:      ms(s.Percentile(0.5)),
+			P90:      ms(s.Percentile(0.9)),
+			P99:      ms(s.Percentile(0.99)),
+			Max:      ms(s.LatencyMax),
+		})
+	}
+
+	orders := []latencySummary{}
+	for _, s := range common.OrderLatencies() {
+		orders = append(orders, latencySummary{
+			Exchange: s.Exchange,
+			Name:     s.Stage,
+			Count:    s.Count,
+			Avg:      ms(s.AvgLatency()),
+			P50:      ms(s.Percentile(0.5)),
+			P90:      ms(s.Percentile(0.9)),
+			P99:      ms(s.Percentile(0.99)),
+			Max:      ms(s.LatencyMax),
+		})
+	}
+
+	d.write(w, map[string]interface{}{"http": https, "order": orders})
+}
+
+func (d *debugService) write(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(util.Object2String(obj)))
+}
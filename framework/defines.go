@@ -16,9 +16,15 @@ type LaunchConfig struct {
 
 	// 日志设置
 	LogConfig struct {
-		PeriodConfig    string `json:"period"`     // d7, h12, m120
-		ConsoleLogLevel string `json:"console_lv"` // info, debug, important
-		FileLogLevel    string `json:"file_lv"`    // info, debug, important
+		PeriodConfig    string   `json:"period"`       // d7, h12, m120
+		ConsoleLogLevel string   `json:"console_lv"`   // info, debug, important
+		FileLogLevel    string   `json:"file_lv"`      // info, debug, important
+		Format          string   `json:"format"`       // text(默认), json
+		MaxSizeMB       int      `json:"max_size_mb"`  // 单个文件的最大大小，0为不限制
+		MaxTotalMB      int      `json:"max_total_mb"` // 日志目录的最大总大小，0为不限制
+		Compress        bool     `json:"compress"`     // 压缩切分出去的文件
+		SplitPrefix     []string `json:"split_prefix"` // 单独写文件的日志前缀，如["Order"]
+		Strict          bool     `json:"strict"`       // 可恢复的错误也panic，见logger.StrictMode
 	} `json:"log"`
 
 	// 交易密钥服务
@@ -35,12 +41,24 @@ type LaunchConfig struct {
 		UdpPort  int    `json:"udp_port"`  // udp端口
 	} `json:"cs"`
 
-	// pprof服务的端口。0表示不启动pprof
+	// 调试服务的端口，提供pprof、运行时统计、队列深度等(见debug.go)，只监听localhost。0表示不启动
+	DebugPort int `json:"debug_port"`
+
+	// 兼容旧配置，DebugPort为0时用作DebugPort
 	PProfPort int `json:"pprof_port"`
 
 	// web服务的端口号。用于搭建策略前端
 	WebServerPort int `json:"web_port"`
 
+	// 健康检查服务的端口，提供/healthz(存活)和/readyz(就绪)，供容器探针和外部看门狗使用。0表示不启动
+	HealthPort int `json:"health_port"`
+
+	// 订单操作的审计记录，见common.FileAuditWriter。Dir为空表示不记录
+	Audit struct {
+		Dir  string `json:"dir"`
+		Sync bool   `json:"sync"` // 每条记录都落盘
+	} `json:"audit"`
+
 	// 配置根目录
 	ProfileRoot string
 
@@ -0,0 +1,71 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-01 11:06:52
+- @Description: 健康检查http服务
+- /healthz 存活探针，进程能响应即返回200
+- /readyz  就绪探针，交易所及所有行情器、交易器都Ready时返回200，否则返回503
+- 两者都返回common.HealthReport的json，未就绪的组件带有原因
+- 交易所创建(Init)期间也能响应，此时就绪探针返回503
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type healthService struct {
+	logPrefix string
+	ex        atomic.Value // exHolder。atomic.Value要求每次存入的类型相同，所以包一层
+	server    *http.Server
+}
+
+type exHolder struct {
+	ex common.CEx
+}
+
+func (h *healthService) start(port int, logPrefix string) {
+	h.logPrefix = logPrefix
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.onHealthz)
+	mux.HandleFunc("/readyz", h.onReadyz)
+	h.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+
+	go func() {
+		defer util.DefaultRecover()
+		logger.LogInfo(h.logPrefix, "starting health service at port %d", port)
+		if err := h.server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.LogImportant(h.logPrefix, "health service ListenAndServe error: %s", err.Error())
+		}
+	}()
+}
+
+func (h *healthService) setExchange(ex common.CEx) {
+	h.ex.Store(exHolder{ex: ex})
+}
+
+func (h *healthService) report() common.HealthReport {
+	eh, _ := h.ex.Load().(exHolder)
+	return common.CheckHealth(eh.ex)
+}
+
+func (h *healthService) onHealthz(w http.ResponseWriter, r *http.Request) {
+	h.write(w, http.StatusOK, h.report())
+}
+
+func (h *healthService) onReadyz(w http.ResponseWriter, r *http.Request) {
+	rep := h.report()
+	h.write(w, util.ValueIf(rep.Ready, http.StatusOK, http.StatusServiceUnavailable), rep)
+}
+
+func (h *healthService) write(w http.ResponseWriter, code int, rep common.HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write([]byte(util.Object2String(rep)))
+}
@@ -54,6 +54,12 @@ type StrategyBase struct {
 	// web服务
 	WebService *webservice.Service
 
+	// 健康检查服务
+	health *healthService
+
+	// 调试服务
+	debug *debugService
+
 	// 子类实现
 	onCommand func(cmdLine string, onResp func(string))
 	onQuit    func()
@@ -81,9 +87,16 @@ func (s *StrategyBase) Start(onStart func(), onQuit func(), onCmd func(cmdLine s
 	s.LC = lc
 
 	// 初始化Log
+	logger.MaxFileSize = int64(lc.LogConfig.MaxSizeMB) * 1024 * 1024
+	logger.MaxTotalSize = int64(lc.LogConfig.MaxTotalMB) * 1024 * 1024
+	logger.CompressRotated = lc.LogConfig.Compress
+	logger.SplitByPrefix(lc.LogConfig.SplitPrefix...)
 	logger.InitByStr(lc.LogConfig.PeriodConfig)
 	logger.ConsleLogLevel = logger.String2LogLevel(lc.LogConfig.ConsoleLogLevel)
 	logger.FileLogLevel = logger.String2LogLevel(lc.LogConfig.FileLogLevel)
+	logger.LogFormat = logger.String2Format(lc.LogConfig.Format)
+	logger.StrictMode = lc.LogConfig.Strict
+	logger.SetGlobalFields(logger.Fields{Exchange: lc.ExchangeName, StrategyId: lc.Name})
 	s.LogPrefix = fmt.Sprintf("%s.%s", lc.Class, lc.Name)
 
 	// 获取apikey
@@ -96,6 +109,27 @@ func (s *StrategyBase) Start(onStart func(), onQuit func(), onCmd func(cmdLine s
 		logger.LogImportant(s.LogPrefix, "no need to get apikey")
 	}
 
+	// 启动调试服务。在交易所创建前启动，启动卡住时也能查看
+	if port := util.ValueIf(lc.DebugPort > 0, lc.DebugPort, lc.PProfPort); port > 0 {
+		s.debug = new(debugService)
+		s.debug.start(port, s.LogPrefix)
+	}
+
+	// 启动健康检查。交易所创建完成前就绪探针返回503
+	if lc.HealthPort > 0 {
+		s.health = new(healthService)
+		s.health.start(lc.HealthPort, s.LogPrefix)
+	}
+
+	// 审计记录需在任何订单创建前开启
+	if len(lc.Audit.Dir) > 0 {
+		if w, err := common.NewFileAuditWriter(lc.Audit.Dir, lc.Audit.Sync); err != nil {
+			logger.LogPanic(s.LogPrefix, "start audit failed: %s", err.Error())
+		} else {
+			common.SetAuditWriter(w)
+		}
+	}
+
 	// 创建交易所对象
 	logger.LogImportant(s.LogPrefix, "starting exchange %s ...", lc.ExchangeName)
 	if strings.ToLower(lc.ExchangeName) == "okex" {
@@ -118,6 +152,10 @@ func (s *StrategyBase) Start(onStart func(), onQuit func(), onCmd func(cmdLine s
 	} else {
 		logger.LogPanic("unknown exchange: %s", lc.ExchangeName)
 	}
+
+	if s.health != nil {
+		s.health.setExchange(s.Ex)
+	}
 	logger.LogImportant(s.LogPrefix, "%s started", lc.ExchangeName)
 
 	// 启动命令行
@@ -135,17 +173,6 @@ func (s *StrategyBase) Start(onStart func(), onQuit func(), onCmd func(cmdLine s
 		lc.Class,
 		s)
 
-	// 启动PProf
-	if lc.PProfPort > 0 {
-		pprofAddr := fmt.Sprintf("localhost:%d", lc.PProfPort)
-		go func(addr string) {
-			logger.LogInfo(s.LogPrefix, "starting pprof at port %d", lc.PProfPort)
-			if err := http.ListenAndServe(addr, nil); err != http.ErrServerClosed {
-				logger.LogImportant(s.LogPrefix, "pprof server ListenAndServe error: %s", err.Error())
-			}
-		}(pprofAddr)
-	}
-
 	// 启动web服务
 	if lc.WebServerPort > 0 {
 		s.WebService = &webservice.Service{}
@@ -252,6 +279,7 @@ func (s *StrategyBase) OnCommand(cmdLine string, onResp func(string)) {
 		sb.WriteString("cs:             print put all call stack\n")
 		sb.WriteString("exit/quit:      stop stratergy and quit\n")
 		sb.WriteString("wslog:          switch websocket log on/off\n")
+		sb.WriteString("log:            show/set log level, see logger.OnCommand\n")
 		s.onCommand("help", func(resp string) {
 			sb.WriteString("\n")
 			sb.WriteString(resp)
@@ -279,7 +307,11 @@ func (s *StrategyBase) OnCommand(cmdLine string, onResp func(string)) {
 	case "quit":
 		s.Quit(onResp)
 	default:
-		s.onCommand(cmdLine, onResp)
+		if resp, ok := logger.OnCommand(cmdLine); ok {
+			onResp(resp)
+		} else {
+			s.onCommand(cmdLine, onResp)
+		}
 	}
 }
 
@@ -0,0 +1,236 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-16 10:12:45
+- @Description: 参数热加载。定时读取参数源(文件或redis key)，内容变化时按param tag校验，校验通过后：
+- 1. 调用OnChange注册的回调，回调参数为类型化的新旧参数
+- 2. 推送给AddStratergy添加的策略(OnParamChanged)，策略不需要重启，也不会撤单
+- 参数源的内容可以是参数结构体的json，也可以是stratergy.Param格式({"ver":..,"data":{...}})，后者只取data部分
+- 校验失败时保持原参数，直到参数源再次变化
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package datamanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/stratergy"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+// #region 参数源
+type ParamSource interface {
+	Name() string
+	Read() ([]byte, error)
+}
+
+type fileParamSource struct {
+	path string
+}
+
+func FileParamSource(path string) ParamSource {
+	return fileParamSource{path: path}
+}
+
+func (s fileParamSource) Name() string {
+	return fmt.Sprintf("file:%s", s.path)
+}
+
+func (s fileParamSource) Read() ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+type redisParamSource struct {
+	rc         *util.RedisClient
+	key, field string
+}
+
+// field为空时读取字符串key，否则读取hash key中的field
+func RedisParamSource(rc *util.RedisClient, key, field string) ParamSource {
+	return redisParamSource{rc: rc, key: key, field: field}
+}
+
+func (s redisParamSource) Name() string {
+	if len(s.field) == 0 {
+		return fmt.Sprintf("redis:%s", s.key)
+	}
+	return fmt.Sprintf("redis:%s.%s", s.key, s.field)
+}
+
+func (s redisParamSource) Read() ([]byte, error) {
+	var rst string
+	var ok bool
+	if len(s.field) == 0 {
+		rst, ok = s.rc.Get(s.key)
+	} else {
+		rst, ok = s.rc.HGet(s.key, s.field)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("read %s failed", s.Name())
+	}
+	return []byte(rst), nil
+}
+
+// 去掉stratergy.Param的外层
+func unwrapParamData(b []byte) []byte {
+	m := map[string]json.RawMessage{}
+	if json.Unmarshal(b, &m) == nil {
+		_, hasVer := m["ver"]
+		if data, ok := m["data"]; ok && hasVer {
+			return data
+		}
+	}
+	return b
+}
+
+// #endregion
+
+// T为参数结构体类型(非指针)
+type ParamWatcher[T any] struct {
+	src       ParamSource
+	interval  time.Duration
+	logPrefix string
+
+	mu       sync.Mutex
+	current  T
+	raw      []byte // 最近一次读取的内容
+	onChange []func(prev, cur T)
+	targets  []stratergy.Stratergy
+
+	chStop chan int
+}
+
+// init为初始参数，参数源中没有的字段保持init中的值。interval为0时默认1秒
+func NewParamWatcher[T any](src ParamSource, init T, interval time.Duration) *ParamWatcher[T] {
+	w := new(ParamWatcher[T])
+	w.src = src
+	w.interval = util.ValueIf(interval > 0, interval, time.Second)
+	w.logPrefix = fmt.Sprintf("ParamWatcher-%s", src.Name())
+	w.current = init
+	w.chStop = make(chan int, 1)
+	return w
+}
+
+// 参数变化回调，在watcher协程中执行
+func (w *ParamWatcher[T]) OnChange(fn func(prev, cur T)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onChange = append(w.onChange, fn)
+}
+
+// 参数变化时推送给策略
+func (w *ParamWatcher[T]) AddStratergy(s stratergy.Stratergy) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.targets = append(w.targets, s)
+}
+
+func (w *ParamWatcher[T]) Current() T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// 同步读取一次参数，失败时返回错误且不启动
+func (w *ParamWatcher[T]) Start() error {
+	b, err := w.src.Read()
+	if err != nil {
+		return err
+	}
+
+	if err := w.apply(b); err != nil {
+		return err
+	}
+
+	go w.run()
+	return nil
+}
+
+func (w *ParamWatcher[T]) Stop() {
+	select {
+	case w.chStop <- 0:
+	default:
+	}
+}
+
+func (w *ParamWatcher[T]) run() {
+	defer util.DefaultRecover()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.chStop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *ParamWatcher[T]) check() {
+	b, err := w.src.Read()
+	if err != nil {
+		logger.LogInfo(w.logPrefix, "read params failed: %s", err.Error())
+		return
+	}
+
+	w.mu.Lock()
+	same := string(b) == string(w.raw)
+	w.mu.Unlock()
+	if same {
+		return
+	}
+
+	if err := w.apply(b); err != nil {
+		logger.LogImportant(w.logPrefix, "params rejected: %s", err.Error())
+	}
+}
+
+// 解析校验参数，有变化时回调并推送
+func (w *ParamWatcher[T]) apply(b []byte) error {
+	w.mu.Lock()
+	w.raw = b
+	prev := w.current
+	w.mu.Unlock()
+
+	// 在副本上解析，通过json深拷贝，避免与prev共享引用类型字段
+	var cur T
+	if pb, err := json.Marshal(prev); err == nil {
+		json.Unmarshal(pb, &cur)
+	}
+
+	if err := stratergy.DecodeParams(unwrapParamData(b), &cur); err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(prev, cur) {
+		return nil
+	}
+
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.current = cur
+	callbacks := append([]func(prev, cur T){}, w.onChange...)
+	targets := append([]stratergy.Stratergy{}, w.targets...)
+	w.mu.Unlock()
+
+	logger.LogImportant(w.logPrefix, "params changed: %s", string(data))
+	for _, fn := range callbacks {
+		fn(prev, cur)
+	}
+
+	for _, s := range targets {
+		s.OnParamChanged(data)
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-12 15:40:09
+- @Description: 策略运行框架。策略逻辑只需实现Logic的几个回调，行情/交易器的创建、订阅、定时器、参数解析由Host完成
+- 所有回调(Init/OnTick/OnDeal/OnTimer/Uninit，及参数修改、命令)串行执行，策略代码不需要加锁
+- 回调中产生的新事件(如下单后立即成交)排队到当前回调结束后执行，不会重入
+- 通过Host创建的交易器只能看到本策略的订单，并受风险预算约束(见scope.go)，多个Host可以共用一个交易所(见runner.go)
+- Host实现了Stratergy接口，可以直接交给datamanager/Terminal使用。实盘和回测(SimExchange)使用方式相同，回测时Clock应为回放时钟
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package stratergy
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+// 策略逻辑
+type Logic interface {
+	Init(h *Host) error                    // 创建行情、交易器等，返回错误时策略不会启动
+	OnTick(h *Host, m common.CommonMarket) // 已订阅行情的深度变化
+	OnDeal(h *Host, d common.Deal)         // 使用h.Observer()下单产生的成交
+	OnTimer(h *Host, now time.Time)        // 定时回调，间隔见HostConfig.TimerInterval
+	Uninit(h *Host)                        // 策略停止
+}
+
+// 以下为可选接口
+type StatusProvider interface {
+	Status(h *Host) interface{}
+}
+
+type CommandHandler interface {
+	OnCommand(h *Host, cmdLine string, onResp func(string))
+}
+
+type ParamsObserver interface {
+	OnParamsChanged(h *Host) // 新参数已写入参数结构体
+}
+
+type HostConfig struct {
+	Name          string
+	Class         string
+	TimerInterval time.Duration // 为0时不启用定时器
+	Clock         common.Clock  // 为nil时使用本地时间
+	Id            int           // 策略Id，非0时用于区分订单归属，见scope.go
+	Budget        RiskBudget    // 风险预算
+}
+
+type Host struct {
+	cfg       HostConfig
+	ex        common.CEx
+	logic     Logic
+	param     Param // Data为参数结构体指针
+	clock     common.Clock
+	logPrefix string
+	scope     *orderScope
+
+	// 串行执行回调
+	mu    sync.Mutex
+	busy  bool
+	queue []func()
+
+	markets map[common.CommonMarket]bool
+	running bool
+	status  interface{} // 最近一次StatusProvider返回的状态
+	chStop  chan int
+}
+
+// params为参数结构体指针，可以为nil
+func NewHost(cfg HostConfig, ex common.CEx, logic Logic, params interface{}) *Host {
+	h := new(Host)
+	h.cfg = cfg
+	h.ex = ex
+	h.logic = logic
+	h.param = Param{Data: params}
+	h.clock = common.ClockOrReal(cfg.Clock)
+	h.logPrefix = fmt.Sprintf("%s.%s", cfg.Class, cfg.Name)
+	h.scope = newOrderScope(cfg.Id, cfg.Budget, h.logPrefix)
+	h.markets = make(map[common.CommonMarket]bool)
+	h.chStop = make(chan int, 1)
+	return h
+}
+
+// 解析初始参数并启动策略。paramData为空时使用默认值
+func (h *Host) Start(paramData []byte) error {
+	if h.param.Data != nil {
+		if err := DecodeParams(paramData, h.param.Data); err != nil {
+			return fmt.Errorf("invalid params: %s", err.Error())
+		}
+	}
+
+	var err error
+	h.dispatch(func() {
+		if err = h.logic.Init(h); err == nil {
+			h.running = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if h.cfg.TimerInterval > 0 {
+		// 清除上次Stop残留的信号(重新启动时)
+		select {
+		case <-h.chStop:
+		default:
+		}
+		go h.runTimer()
+	}
+	h.LogFields().LogImportant(h.logPrefix, "started")
+	return nil
+}
+
+// 停止定时器并调用Uninit。不会撤销订单，由策略在Uninit中自行处理
+func (h *Host) Stop() {
+	select {
+	case h.chStop <- 0:
+	default:
+	}
+
+	h.dispatch(func() {
+		if h.running {
+			h.running = false
+			h.logic.Uninit(h)
+			h.LogFields().LogImportant(h.logPrefix, "stopped")
+		}
+	})
+}
+
+func (h *Host) runTimer() {
+	defer util.DefaultRecover()
+	ticker := h.clock.NewTicker(h.cfg.TimerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.chStop:
+			return
+		case <-ticker.C():
+			h.dispatch(func() {
+				if h.running {
+					h.logic.OnTimer(h, h.clock.Now())
+				}
+			})
+		}
+	}
+}
+
+// 串行执行fn。正在执行其他回调时排队，由当前执行者依次执行
+func (h *Host) dispatch(fn func()) {
+	h.mu.Lock()
+	h.queue = append(h.queue, fn)
+	if h.busy {
+		h.mu.Unlock()
+		return
+	}
+
+	h.busy = true
+	for len(h.queue) > 0 {
+		next := h.queue[0]
+		h.queue = h.queue[1:]
+		h.mu.Unlock()
+		h.call(next)
+		h.mu.Lock()
+	}
+	h.busy = false
+	h.mu.Unlock()
+}
+
+func (h *Host) call(fn func()) {
+	defer util.DefaultRecover()
+	fn()
+}
+
+// #region 行情/交易器
+func (h *Host) Exchange() common.CEx {
+	return h.ex
+}
+
+func (h *Host) Clock() common.Clock {
+	return h.clock
+}
+
+func (h *Host) Now() time.Time {
+	return h.clock.Now()
+}
+
+func (h *Host) LogPrefix() string {
+	return h.logPrefix
+}
+
+// 结构化日志的字段，如h.LogFields().LogInfo(h.LogPrefix(), ...)
+func (h *Host) LogFields() logger.Fields {
+	f := logger.Fields{StrategyId: h.cfg.Name}
+	if h.ex != nil {
+		f.Exchange = h.ex.Name()
+	}
+	return f
+}
+
+func (h *Host) Id() int {
+	return h.cfg.Id
+}
+
+// 撤销本策略通过UseSpotTrader/UseFutureTrader下的所有订单
+func (h *Host) CancelAll() {
+	h.scope.cancelAll()
+}
+
+// 订阅行情的深度变化，触发OnTick
+func (h *Host) Watch(m common.CommonMarket) {
+	if m == nil || h.markets[m] {
+		return
+	}
+
+	h.markets[m] = true
+	m.AddDepthObserver(&tickObserver{h: h, m: m})
+}
+
+func (h *Host) UseSpotMarket(baseCcy, quoteCcy string) common.SpotMarket {
+	m := h.ex.UseSpotMarket(baseCcy, quoteCcy)
+	if m != nil {
+		h.Watch(m)
+	}
+	return m
+}
+
+func (h *Host) UseFutureMarket(symbol, contractType string) common.FutureMarket {
+	m := h.ex.UseFutureMarket(symbol, contractType)
+	if m != nil {
+		h.Watch(m)
+	}
+	return m
+}
+
+// 返回的交易器只能看到本策略的订单，下单受风险预算约束
+func (h *Host) UseSpotTrader(baseCcy, quoteCcy string) common.SpotTrader {
+	t := h.ex.UseSpotTrader(baseCcy, quoteCcy)
+	if t == nil {
+		return nil
+	}
+	h.Watch(t.SpotMarket())
+	return newScopedSpotTrader(h.scope, t)
+}
+
+func (h *Host) UseFutureTrader(symbol, contractType string, lever int) common.FutureTrader {
+	t := h.ex.UseFutureTrader(symbol, contractType, lever)
+	if t == nil {
+		return nil
+	}
+	h.Watch(t.FutureMarket())
+	return newScopedFutureTrader(h.scope, t)
+}
+
+// 下单时传入，成交回调到OnDeal
+func (h *Host) Observer() common.OrderObserver {
+	return dealObserver{h: h}
+}
+
+type tickObserver struct {
+	h *Host
+	m common.CommonMarket
+}
+
+func (o *tickObserver) OnDepthChanged() {
+	o.h.dispatch(func() {
+		if o.h.running {
+			o.h.logic.OnTick(o.h, o.m)
+		}
+	})
+}
+
+type dealObserver struct {
+	h *Host
+}
+
+func (o dealObserver) OnDeal(d common.Deal) {
+	o.h.dispatch(func() {
+		if o.h.running {
+			o.h.logic.OnDeal(o.h, d)
+		}
+	})
+}
+
+// #endregion
+
+// #region 实现Stratergy
+func (h *Host) Name() string {
+	return h.cfg.Name
+}
+
+func (h *Host) Class() string {
+	return h.cfg.Class
+}
+
+// 在回调间隙刷新状态，返回最近一次刷新的结果(正在执行其他回调时为上一次的状态)
+func (h *Host) Status() interface{} {
+	if sp, ok := h.logic.(StatusProvider); ok {
+		h.dispatch(func() {
+			status := sp.Status(h)
+			h.mu.Lock()
+			h.status = status
+			h.mu.Unlock()
+		})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+func (h *Host) Params() *Param {
+	if h.param.Data == nil {
+		return nil
+	}
+	return &h.param
+}
+
+// 解析到参数副本，校验通过后在回调间隙写入参数结构体，校验失败或参数没有变化时保持原参数
+// 参数版本号(Param.Version)由datamanager维护
+func (h *Host) OnParamChanged(paramData []byte) {
+	if h.param.Data == nil {
+		return
+	}
+
+	h.dispatch(func() {
+		rv := reflect.ValueOf(h.param.Data)
+		cp := reflect.New(rv.Elem().Type())
+		cp.Elem().Set(rv.Elem())
+		if err := DecodeParams(paramData, cp.Interface()); err != nil {
+			h.LogFields().LogImportant(h.logPrefix, "params rejected: %s", err.Error())
+			return
+		}
+
+		if reflect.DeepEqual(rv.Elem().Interface(), cp.Elem().Interface()) {
+			return
+		}
+
+		rv.Elem().Set(cp.Elem())
+		h.LogFields().LogImportant(h.logPrefix, "params updated: %s", util.Object2String(h.param.Data))
+		if po, ok := h.logic.(ParamsObserver); ok && h.running {
+			po.OnParamsChanged(h)
+		}
+	})
+}
+
+func (h *Host) OnCommand(cmdLine string, onResp func(string)) {
+	if ch, ok := h.logic.(CommandHandler); ok {
+		h.dispatch(func() {
+			ch.OnCommand(h, cmdLine, onResp)
+		})
+	} else {
+		onResp("command not supported")
+	}
+}
+
+func (h *Host) OnQuantEvent(name string, param map[string]string) bool {
+	return false
+}
+
+func (h *Host) Quit() {
+	h.Stop()
+}
+
+// #endregion
@@ -0,0 +1,230 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-22 10:47:30
+- @Description: 亏损监控和自动降风险。按策略(Host)统计其订单成交产生的已实现盈亏，
+- 任一规则的时间窗口内亏损超过MaxLoss时，将该策略的下单数量倍数降为规则的Scale(多条规则同时触发时取最小值)，Scale为0时停止开仓
+- 亏损回落到所有规则以内(窗口滚动、盈利)后自动恢复为1。reduceOnly订单不受影响
+- 已实现盈亏按策略自身的成交以均价法计算，单位同订单价值(见scope.go)，不含手续费和资金费
+- 需在策略下单之前Watch
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package stratergy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type LossRule struct {
+	WindowSec int             `json:"window_sec"` // 统计窗口
+	MaxLoss   decimal.Decimal `json:"max_loss"`   // 窗口内的最大亏损(正数)
+	Scale     decimal.Decimal `json:"scale"`      // 超出后的下单数量倍数，0表示停止开仓
+}
+
+type LossMonitorConfig struct {
+	Rules            []LossRule `json:"rules"`
+	CheckIntervalSec int        `json:"check_interval_sec"` // 没有成交时的检查间隔，用于窗口滚动后恢复，默认10秒
+}
+
+// 策略在某个品种上的持仓，用于计算已实现盈亏
+type lossPosition struct {
+	net   decimal.Decimal
+	avgPx decimal.Decimal
+}
+
+type pnlRecord struct {
+	t   time.Time
+	pnl decimal.Decimal
+}
+
+type lossState struct {
+	h         *Host
+	positions map[string]*lossPosition
+	records   []pnlRecord
+	scale     decimal.Decimal
+}
+
+type LossMonitor struct {
+	cfg       LossMonitorConfig
+	clock     common.Clock
+	logPrefix string
+	maxWindow time.Duration
+
+	mu     sync.Mutex
+	states map[string]*lossState // 策略名-状态
+
+	chStop chan int
+}
+
+// clock为nil时使用本地时间，回测时应为回放时钟
+func NewLossMonitor(cfg LossMonitorConfig, clock common.Clock) *LossMonitor {
+	m := new(LossMonitor)
+	m.cfg = cfg
+	m.cfg.CheckIntervalSec = util.ValueIf(cfg.CheckIntervalSec > 0, cfg.CheckIntervalSec, 10)
+	m.clock = common.ClockOrReal(clock)
+	m.logPrefix = "LossMonitor"
+	for _, r := range cfg.Rules {
+		m.maxWindow = max(m.maxWindow, time.Duration(r.WindowSec)*time.Second)
+	}
+	m.states = make(map[string]*lossState)
+	m.chStop = make(chan int, 1)
+	return m
+}
+
+// 监控一个策略
+func (m *LossMonitor) Watch(h *Host) {
+	st := &lossState{h: h, positions: make(map[string]*lossPosition), scale: decimal.NewFromInt(1)}
+	m.mu.Lock()
+	m.states[h.cfg.Name] = st
+	m.mu.Unlock()
+
+	h.scope.setDealFn(func(t *scopedTrader, d common.Deal) {
+		m.onDeal(st, t, d)
+	})
+}
+
+func (m *LossMonitor) Unwatch(h *Host) {
+	h.scope.setDealFn(nil)
+	h.scope.setScale(decimal.NewFromInt(1))
+	m.mu.Lock()
+	delete(m.states, h.cfg.Name)
+	m.mu.Unlock()
+}
+
+func (m *LossMonitor) Start() {
+	go m.run()
+}
+
+func (m *LossMonitor) Stop() {
+	select {
+	case m.chStop <- 0:
+	default:
+	}
+}
+
+func (m *LossMonitor) run() {
+	defer util.DefaultRecover()
+	ticker := m.clock.NewTicker(time.Duration(m.cfg.CheckIntervalSec) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.chStop:
+			return
+		case <-ticker.C():
+			m.mu.Lock()
+			for _, st := range m.states {
+				m.evaluate(st, m.clock.Now())
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// 某策略最近window内的已实现盈亏
+func (m *LossMonitor) Realized(name string, window time.Duration) decimal.Decimal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.states[name]; ok {
+		return st.realized(m.clock.Now(), window)
+	}
+	return decimal.Zero
+}
+
+// 某策略当前的下单数量倍数
+func (m *LossMonitor) Scale(name string) decimal.Decimal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.states[name]; ok {
+		return st.scale
+	}
+	return decimal.NewFromInt(1)
+}
+
+func (st *lossState) realized(now time.Time, window time.Duration) decimal.Decimal {
+	total := decimal.Zero
+	for _, r := range st.records {
+		if now.Sub(r.t) <= window {
+			total = total.Add(r.pnl)
+		}
+	}
+	return total
+}
+
+// 按均价法更新持仓，返回平仓部分的已实现盈亏
+func (p *lossPosition) onFill(t *scopedTrader, dir common.OrderDir, px, sz decimal.Decimal) decimal.Decimal {
+	signed := util.ValueIf(dir == common.OrderDir_Buy, sz, sz.Neg())
+	if p.net.IsZero() || p.net.Sign() == signed.Sign() {
+		total := p.net.Abs().Add(sz)
+		p.avgPx = p.avgPx.Mul(p.net.Abs()).Add(px.Mul(sz)).Div(total)
+		p.net = p.net.Add(signed)
+		return decimal.Zero
+	}
+
+	closed := decimal.Min(p.net.Abs(), sz)
+	pnl := t.fnPnl(p.avgPx, px, closed)
+	if p.net.IsNegative() {
+		pnl = pnl.Neg()
+	}
+
+	p.net = p.net.Add(signed)
+	if p.net.Sign() == signed.Sign() {
+		p.avgPx = px // 反手
+	} else if p.net.IsZero() {
+		p.avgPx = decimal.Zero
+	}
+	return pnl
+}
+
+func (m *LossMonitor) onDeal(st *lossState, t *scopedTrader, d common.Deal) {
+	if d.O == nil || !d.Amount.IsPositive() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	instId := d.O.GetType()
+	p, ok := st.positions[instId]
+	if !ok {
+		p = &lossPosition{}
+		st.positions[instId] = p
+	}
+
+	now := m.clock.Now()
+	if pnl := p.onFill(t, d.O.GetDir(), d.Price, d.Amount); !pnl.IsZero() {
+		st.records = append(st.records, pnlRecord{t: now, pnl: pnl})
+	}
+	m.evaluate(st, now)
+}
+
+// 需加锁调用
+func (m *LossMonitor) evaluate(st *lossState, now time.Time) {
+	for len(st.records) > 0 && now.Sub(st.records[0].t) > m.maxWindow {
+		st.records = st.records[1:]
+	}
+
+	scale := decimal.NewFromInt(1)
+	reason := ""
+	for _, r := range m.cfg.Rules {
+		window := time.Duration(r.WindowSec) * time.Second
+		if loss := st.realized(now, window).Neg(); loss.GreaterThan(r.MaxLoss) && r.Scale.LessThan(scale) {
+			scale = r.Scale
+			reason = fmt.Sprintf("loss %v in %v > %v", loss, window, r.MaxLoss)
+		}
+	}
+
+	if !scale.Equal(st.scale) {
+		st.scale = scale
+		st.h.scope.setScale(scale)
+		if len(reason) > 0 {
+			logger.LogImportant(m.logPrefix, "%s de-risked, scale=%v, %s", st.h.cfg.Name, scale, reason)
+		} else {
+			logger.LogImportant(m.logPrefix, "%s recovered, scale=%v", st.h.cfg.Name, scale)
+		}
+	}
+}
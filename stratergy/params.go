@@ -0,0 +1,190 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-12 14:22:38
+- @Description: 策略参数的描述与校验。参数定义为结构体，字段用json tag命名，用param tag描述约束：
+- param:"min=0,max=100,default=10,required,options=a|b|c,desc=说明文字"
+- min/max适用于数字(含decimal)，options适用于字符串，required表示json中必须出现该字段，desc需放在最后(可包含逗号)
+- 支持的字段类型：整数、浮点数、字符串、bool、decimal.Decimal，其余类型只做json解析不做校验
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package stratergy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// 单个参数的描述，可导出给界面使用
+type ParamSpec struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Default  string   `json:"default,omitempty"`
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+	Desc     string   `json:"desc,omitempty"`
+
+	index int // 字段下标
+}
+
+var decimalType = reflect.TypeOf(decimal.Decimal{})
+
+func parseParamTag(tag string, spec *ParamSpec) {
+	parts := strings.Split(tag, ",")
+	for i, part := range parts {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "min":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				spec.Min = &f
+			}
+		case "max":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				spec.Max = &f
+			}
+		case "default":
+			spec.Default = v
+		case "required":
+			spec.Required = true
+		case "options":
+			spec.Options = strings.Split(v, "|")
+		case "desc":
+			_, spec.Desc, _ = strings.Cut(strings.Join(parts[i:], ","), "=")
+			return
+		}
+	}
+}
+
+func structOf(v interface{}) (reflect.Value, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return rv, false
+		}
+		rv = rv.Elem()
+	}
+	return rv, rv.Kind() == reflect.Struct
+}
+
+// 参数结构体的描述，v为结构体或其指针
+func ParamSchemaOf(v interface{}) []ParamSpec {
+	rv, ok := structOf(v)
+	if !ok {
+		return nil
+	}
+
+	result := []ParamSpec{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		spec := ParamSpec{Name: name, Type: f.Type.String(), index: i}
+		if len(spec.Name) == 0 {
+			spec.Name = f.Name
+		}
+		parseParamTag(f.Tag.Get("param"), &spec)
+		result = append(result, spec)
+	}
+	return result
+}
+
+// 为零值字段填充默认值，v需为结构体指针
+func ApplyParamDefaults(v interface{}) error {
+	rv, ok := structOf(v)
+	if !ok || !rv.CanSet() {
+		return fmt.Errorf("params must be a pointer to struct")
+	}
+
+	for _, spec := range ParamSchemaOf(v) {
+		fv := rv.Field(spec.index)
+		if len(spec.Default) == 0 || !fv.IsZero() {
+			continue
+		}
+
+		if fv.Kind() == reflect.String {
+			fv.SetString(spec.Default)
+		} else if err := json.Unmarshal([]byte(spec.Default), fv.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid default value of %s: %s", spec.Name, spec.Default)
+		}
+	}
+	return nil
+}
+
+// 按param tag校验参数取值
+func ValidateParams(v interface{}) error {
+	rv, ok := structOf(v)
+	if !ok {
+		return fmt.Errorf("params must be a struct")
+	}
+
+	for _, spec := range ParamSchemaOf(v) {
+		fv := rv.Field(spec.index)
+		num, isNum := 0.0, true
+		switch {
+		case fv.Type() == decimalType:
+			num = fv.Interface().(decimal.Decimal).InexactFloat64()
+		case fv.CanInt():
+			num = float64(fv.Int())
+		case fv.CanUint():
+			num = float64(fv.Uint())
+		case fv.CanFloat():
+			num = fv.Float()
+		default:
+			isNum = false
+		}
+
+		if isNum {
+			if spec.Min != nil && num < *spec.Min {
+				return fmt.Errorf("%s=%v is less than %v", spec.Name, num, *spec.Min)
+			}
+			if spec.Max != nil && num > *spec.Max {
+				return fmt.Errorf("%s=%v is greater than %v", spec.Name, num, *spec.Max)
+			}
+		}
+
+		if fv.Kind() == reflect.String && len(spec.Options) > 0 && !slices.Contains(spec.Options, fv.String()) {
+			return fmt.Errorf("%s=%s is not one of %v", spec.Name, fv.String(), spec.Options)
+		}
+	}
+	return nil
+}
+
+// 解析并校验参数：先填充默认值，再解析json，最后检查必填字段和取值范围。失败时v的内容不确定，调用方应使用副本
+func DecodeParams(data []byte, v interface{}) error {
+	if err := ApplyParamDefaults(v); err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+
+	keys := map[string]json.RawMessage{}
+	if len(data) > 0 {
+		json.Unmarshal(data, &keys)
+	}
+	for _, spec := range ParamSchemaOf(v) {
+		if _, ok := keys[spec.Name]; spec.Required && !ok {
+			return fmt.Errorf("missing required param: %s", spec.Name)
+		}
+	}
+
+	return ValidateParams(v)
+}
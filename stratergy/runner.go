@@ -0,0 +1,273 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-15 14:08:33
+- @Description: 在一个进程内运行多个策略(Host)。每个策略有独立的策略Id、订单空间、风险预算和日志前缀，可以单独启动/停止
+- 策略Id不能重复，为0时自动分配。停止策略时撤销其所有订单，不影响其他策略
+- 策略Id登记在common.RegisterRunnerId中，交易器收到这些Id的订单时不会锁定
+- Runner本身也实现了Stratergy接口，可以交给Terminal使用，命令格式见OnCommand
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package stratergy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const runnerLogPrefix = "runner"
+
+type runnerSlot struct {
+	host    *Host
+	running bool
+}
+
+type Runner struct {
+	mu     sync.Mutex
+	slots  map[string]*runnerSlot
+	ids    map[int]string
+	nextId int
+}
+
+func NewRunner() *Runner {
+	r := new(Runner)
+	r.slots = make(map[string]*runnerSlot)
+	r.ids = make(map[int]string)
+	r.nextId = 1
+	return r
+}
+
+// 添加一个策略(不启动)。名称和Id不能与已有策略重复，cfg.Id为0时自动分配
+func (r *Runner) Add(cfg HostConfig, ex common.CEx, logic Logic, params interface{}) (*Host, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(cfg.Name) == 0 {
+		return nil, fmt.Errorf("empty stratergy name")
+	}
+
+	if _, ok := r.slots[cfg.Name]; ok {
+		return nil, fmt.Errorf("stratergy %s already exists", cfg.Name)
+	}
+
+	if cfg.Id == 0 {
+		for r.ids[r.nextId] != "" {
+			r.nextId++
+		}
+		cfg.Id = r.nextId
+	} else if name, ok := r.ids[cfg.Id]; ok {
+		return nil, fmt.Errorf("stratergy id %d already used by %s", cfg.Id, name)
+	}
+
+	h := NewHost(cfg, ex, logic, params)
+	r.slots[cfg.Name] = &runnerSlot{host: h}
+	r.ids[cfg.Id] = cfg.Name
+	common.RegisterRunnerId(strconv.Itoa(cfg.Id))
+	logger.LogImportant(runnerLogPrefix, "stratergy %s added, id=%d", cfg.Name, cfg.Id)
+	return h, nil
+}
+
+func (r *Runner) slot(name string) (*runnerSlot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.slots[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("stratergy %s not found", name)
+}
+
+// 启动策略，paramData为空时使用当前参数(首次启动为默认值)
+func (r *Runner) Start(name string, paramData []byte) error {
+	s, err := r.slot(name)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if s.running {
+		r.mu.Unlock()
+		return fmt.Errorf("stratergy %s is running", name)
+	}
+	s.running = true
+	r.mu.Unlock()
+
+	if err := s.host.Start(paramData); err != nil {
+		r.mu.Lock()
+		s.running = false
+		r.mu.Unlock()
+		logger.LogImportant(runnerLogPrefix, "start stratergy %s failed: %s", name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// 停止策略并撤销其订单
+func (r *Runner) Stop(name string) error {
+	s, err := r.slot(name)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if !s.running {
+		r.mu.Unlock()
+		return fmt.Errorf("stratergy %s is not running", name)
+	}
+	s.running = false
+	r.mu.Unlock()
+
+	s.host.Stop()
+	s.host.CancelAll()
+	return nil
+}
+
+// 移除已停止的策略，其Id可以被重新使用
+func (r *Runner) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.slots[name]
+	if !ok {
+		return fmt.Errorf("stratergy %s not found", name)
+	}
+
+	if s.running {
+		return fmt.Errorf("stratergy %s is running", name)
+	}
+
+	delete(r.slots, name)
+	delete(r.ids, s.host.Id())
+	common.UnregisterRunnerId(strconv.Itoa(s.host.Id()))
+	logger.LogImportant(runnerLogPrefix, "stratergy %s removed", name)
+	return nil
+}
+
+func (r *Runner) StopAll() {
+	for _, name := range r.Names() {
+		if r.IsRunning(name) {
+			r.Stop(name)
+		}
+	}
+}
+
+func (r *Runner) Host(name string) *Host {
+	if s, err := r.slot(name); err == nil {
+		return s.host
+	}
+	return nil
+}
+
+// 按名称排序
+func (r *Runner) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.slots))
+	for name := range r.slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Runner) IsRunning(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.slots[name]
+	return ok && s.running
+}
+
+// #region 实现Stratergy
+func (r *Runner) Name() string {
+	return runnerLogPrefix
+}
+
+func (r *Runner) Class() string {
+	return runnerLogPrefix
+}
+
+type RunnerItemStatus struct {
+	Id      int         `json:"id"`
+	Class   string      `json:"class"`
+	Running bool        `json:"running"`
+	Status  interface{} `json:"status"`
+}
+
+// 策略名-状态
+func (r *Runner) Status() interface{} {
+	result := map[string]RunnerItemStatus{}
+	for _, name := range r.Names() {
+		if h := r.Host(name); h != nil {
+			result[name] = RunnerItemStatus{Id: h.Id(), Class: h.Class(), Running: r.IsRunning(name), Status: h.Status()}
+		}
+	}
+	return result
+}
+
+// 各策略的参数由各自的Host维护
+func (r *Runner) Params() *Param {
+	return nil
+}
+
+func (r *Runner) OnParamChanged(paramData []byte) {}
+
+// 支持的命令：
+// list: 列出所有策略
+// start <name>/stop <name>: 启动/停止某个策略
+// <name> <cmd>: 将cmd转发给某个策略
+func (r *Runner) OnCommand(cmdLine string, onResp func(string)) {
+	ss := strings.Fields(cmdLine)
+	if len(ss) == 0 {
+		return
+	}
+
+	switch {
+	case ss[0] == "help":
+		sb := strings.Builder{}
+		sb.WriteString("list:           list all stratergies\n")
+		sb.WriteString("start <name>:   start stratergy\n")
+		sb.WriteString("stop <name>:    stop stratergy and cancel its orders\n")
+		sb.WriteString("<name> <cmd>:   send cmd to stratergy\n")
+		onResp(sb.String())
+	case ss[0] == "list":
+		sb := strings.Builder{}
+		for _, name := range r.Names() {
+			if h := r.Host(name); h != nil {
+				sb.WriteString(fmt.Sprintf("%-16s id=%-4d class=%-16s running=%v\n", name, h.Id(), h.Class(), r.IsRunning(name)))
+			}
+		}
+		onResp(sb.String())
+	case (ss[0] == "start" || ss[0] == "stop") && len(ss) == 2:
+		var err error
+		if ss[0] == "start" {
+			err = r.Start(ss[1], nil)
+		} else {
+			err = r.Stop(ss[1])
+		}
+
+		if err != nil {
+			onResp(err.Error())
+		} else {
+			onResp(fmt.Sprintf("%s %s ok", ss[0], ss[1]))
+		}
+	default:
+		if h := r.Host(ss[0]); h != nil && len(ss) > 1 {
+			h.OnCommand(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cmdLine), ss[0])), onResp)
+		} else {
+			onResp("unknown command")
+		}
+	}
+}
+
+func (r *Runner) OnQuantEvent(name string, param map[string]string) bool {
+	return false
+}
+
+func (r *Runner) Quit() {
+	r.StopAll()
+}
+
+// #endregion
@@ -0,0 +1,339 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-15 10:26:51
+- @Description: 策略的订单空间和风险预算。多个策略共用一个交易所(及同一品种的交易器)时，每个策略通过自己的包装交易器下单：
+- 1. Orders()只返回本策略的订单，Uninit只撤销本策略的订单，不影响交易器本身
+- 2. 策略Id非0时，订单的purpose加上"s<Id>"前缀，交易所端可以通过clientOrderId区分订单归属
+- 3. 下单前检查风险预算：单笔订单价值、本策略未完成订单的总价值，超出时拒绝下单(返回nil)
+- 4. 非reduceOnly订单的数量乘以当前的数量倍数(默认1，由LossMonitor调整，见lossmonitor.go)并重新按精度对齐，倍数为0或对齐后不足最小下单量时暂停开仓(返回nil，不发给交易所)
+- 订单价值：现货为价格*数量，U本位合约为价格*数量*面值，币本位合约为数量*面值(美元)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package stratergy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/shopspring/decimal"
+)
+
+type RiskBudget struct {
+	MaxOrderValue decimal.Decimal `json:"max_order_value"` // 单笔订单的最大价值，0表示不限
+	MaxOpenValue  decimal.Decimal `json:"max_open_value"`  // 未完成订单未成交部分的最大总价值，0表示不限
+}
+
+// 一个策略的订单空间，由该策略的所有包装交易器共享
+type orderScope struct {
+	id        int
+	budget    RiskBudget
+	logPrefix string
+
+	mu     sync.Mutex
+	orders map[common.Order]*scopedTrader // 未完成的订单-下单的包装交易器
+
+	// 可能在下单过程中(持有mu时)被成交回调访问，单独加锁
+	muScale sync.Mutex
+	scale   decimal.Decimal                      // 下单数量倍数
+	fnDeal  func(t *scopedTrader, d common.Deal) // 本策略订单的成交
+}
+
+func newOrderScope(id int, budget RiskBudget, logPrefix string) *orderScope {
+	s := new(orderScope)
+	s.id = id
+	s.budget = budget
+	s.logPrefix = logPrefix
+	s.orders = make(map[common.Order]*scopedTrader)
+	s.scale = decimal.NewFromInt(1)
+	return s
+}
+
+func (s *orderScope) purpose(purpose string) string {
+	if s.id == 0 {
+		return purpose
+	}
+	return fmt.Sprintf("s%d%s", s.id, purpose)
+}
+
+// 需加锁调用
+func (s *orderScope) prune() {
+	for o := range s.orders {
+		if o.IsFinished() {
+			delete(s.orders, o)
+		}
+	}
+}
+
+func (s *orderScope) openValue() decimal.Decimal {
+	total := decimal.Zero
+	for o, t := range s.orders {
+		total = total.Add(t.fnValue(o.GetPrice(), o.GetUnfilled()))
+	}
+	return total
+}
+
+func (s *orderScope) setScale(scale decimal.Decimal) {
+	s.muScale.Lock()
+	defer s.muScale.Unlock()
+	s.scale = scale
+}
+
+func (s *orderScope) getScale() decimal.Decimal {
+	s.muScale.Lock()
+	defer s.muScale.Unlock()
+	return s.scale
+}
+
+func (s *orderScope) setDealFn(fn func(t *scopedTrader, d common.Deal)) {
+	s.muScale.Lock()
+	defer s.muScale.Unlock()
+	s.fnDeal = fn
+}
+
+func (s *orderScope) onDeal(t *scopedTrader, d common.Deal) {
+	s.muScale.Lock()
+	fn := s.fnDeal
+	s.muScale.Unlock()
+	if fn != nil {
+		fn(t, d)
+	}
+}
+
+// 乘以数量倍数并对齐后的下单数量，reduceOnly订单不受影响。按计价币下单的市价单不对齐
+// 返回false表示缩小后不足最小下单量，暂停开仓
+func (s *orderScope) scaled(m common.CommonMarket, amount decimal.Decimal, reduceOnly, byQuote bool) (decimal.Decimal, bool) {
+	scale := s.getScale()
+	if reduceOnly || scale.Equal(decimal.NewFromInt(1)) || scale.IsZero() {
+		return amount, true
+	}
+
+	scaled := amount.Mul(scale)
+	if byQuote {
+		return scaled, true
+	}
+
+	scaled = m.AlignSize(scaled)
+	if minSize := m.MinSize(); scaled.LessThan(minSize) {
+		logger.LogImportant(s.logPrefix, "opening halted, scaled amount %v(%v x %v) is below min size %v", scaled, amount, scale, minSize)
+		return scaled, false
+	}
+	return scaled, true
+}
+
+// 检查预算并下单，下单成功后记录订单
+func (s *orderScope) place(value decimal.Decimal, t *scopedTrader, reduceOnly bool, fnMake func() common.Order) common.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+
+	if !reduceOnly && s.getScale().IsZero() {
+		logger.LogImportant(s.logPrefix, "order rejected, opening is halted by loss monitor")
+		return nil
+	}
+
+	if s.budget.MaxOrderValue.IsPositive() && value.GreaterThan(s.budget.MaxOrderValue) {
+		logger.LogImportant(s.logPrefix, "order rejected by risk budget, value=%v > max_order_value=%v", value, s.budget.MaxOrderValue)
+		return nil
+	}
+
+	if s.budget.MaxOpenValue.IsPositive() {
+		if open := s.openValue(); open.Add(value).GreaterThan(s.budget.MaxOpenValue) {
+			logger.LogImportant(s.logPrefix, "order rejected by risk budget, open=%v + value=%v > max_open_value=%v", open, value, s.budget.MaxOpenValue)
+			return nil
+		}
+	}
+
+	o := fnMake()
+	if o != nil {
+		s.orders[o] = t
+	}
+	return o
+}
+
+// 本策略在某个交易器上的未完成订单
+func (s *orderScope) ordersOf(t common.CommonTrader) []common.Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+
+	result := []common.Order{}
+	for _, o := range t.Orders() {
+		if _, ok := s.orders[o]; ok {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// 撤销本策略的所有订单
+func (s *orderScope) cancelAll() {
+	s.mu.Lock()
+	s.prune()
+	orders := make([]common.Order, 0, len(s.orders))
+	for o := range s.orders {
+		orders = append(orders, o)
+	}
+	s.mu.Unlock()
+
+	for _, o := range orders {
+		o.Cancel()
+	}
+}
+
+// 转发成交给策略的观察者和订单空间
+type scopedObserver struct {
+	t   *scopedTrader
+	obs common.OrderObserver
+}
+
+func (o scopedObserver) OnDeal(d common.Deal) {
+	if o.obs != nil {
+		o.obs.OnDeal(d)
+	}
+	o.t.scope.onDeal(o.t, d)
+}
+
+// 包装交易器的公共部分
+type scopedTrader struct {
+	scope   *orderScope
+	trader  common.CommonTrader
+	fnValue func(px, sz decimal.Decimal) decimal.Decimal
+	fnPnl   func(avgPx, px, sz decimal.Decimal) decimal.Decimal // 以avgPx买入sz、以px卖出的盈亏，单位同价值
+}
+
+func (t *scopedTrader) MakeOrder(price, amount decimal.Decimal, dir common.OrderDir, makeOnly, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	amount, ok := t.scope.scaled(t.trader.Market(), amount, reduceOnly, false)
+	if !ok {
+		return nil
+	}
+	return t.scope.place(t.fnValue(price, amount), t, reduceOnly, func() common.Order {
+		return t.trader.MakeOrder(price, amount, dir, makeOnly, reduceOnly, t.scope.purpose(purpose), scopedObserver{t: t, obs: observer})
+	})
+}
+
+func (t *scopedTrader) MakeOrderTIF(price, amount decimal.Decimal, dir common.OrderDir, tif common.TimeInForce, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	amount, ok := t.scope.scaled(t.trader.Market(), amount, reduceOnly, false)
+	if !ok {
+		return nil
+	}
+	return t.scope.place(t.fnValue(price, amount), t, reduceOnly, func() common.Order {
+		return t.trader.MakeOrderTIF(price, amount, dir, tif, reduceOnly, t.scope.purpose(purpose), scopedObserver{t: t, obs: observer})
+	})
+}
+
+// 市价单按中间价估算价值
+func (t *scopedTrader) MakeMarketOrder(amount decimal.Decimal, dir common.OrderDir, byQuote bool, maxSlippage decimal.Decimal, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	amount, ok := t.scope.scaled(t.trader.Market(), amount, reduceOnly, byQuote)
+	if !ok {
+		return nil
+	}
+	value := amount
+	if !byQuote {
+		value = t.fnValue(t.trader.Market().OrderBook().MiddlePrice(), amount)
+	}
+	return t.scope.place(value, t, reduceOnly, func() common.Order {
+		return t.trader.MakeMarketOrder(amount, dir, byQuote, maxSlippage, reduceOnly, t.scope.purpose(purpose), scopedObserver{t: t, obs: observer})
+	})
+}
+
+func (t *scopedTrader) Orders() []common.Order {
+	return t.scope.ordersOf(t.trader)
+}
+
+// 交易器由多个策略共用，这里只撤销本策略的订单
+func (t *scopedTrader) Uninit() {
+	for _, o := range t.Orders() {
+		o.Cancel()
+	}
+}
+
+type scopedSpotTrader struct {
+	common.SpotTrader
+	scopedTrader
+}
+
+func newScopedSpotTrader(scope *orderScope, t common.SpotTrader) *scopedSpotTrader {
+	st := &scopedSpotTrader{SpotTrader: t}
+	st.scope = scope
+	st.trader = t
+	st.fnValue = func(px, sz decimal.Decimal) decimal.Decimal { return px.Mul(sz) }
+	st.fnPnl = func(avgPx, px, sz decimal.Decimal) decimal.Decimal { return px.Sub(avgPx).Mul(sz) }
+	return st
+}
+
+// #region 消除嵌入冲突
+func (t *scopedSpotTrader) MakeOrder(price, amount decimal.Decimal, dir common.OrderDir, makeOnly, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeOrder(price, amount, dir, makeOnly, reduceOnly, purpose, observer)
+}
+
+func (t *scopedSpotTrader) MakeOrderTIF(price, amount decimal.Decimal, dir common.OrderDir, tif common.TimeInForce, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeOrderTIF(price, amount, dir, tif, reduceOnly, purpose, observer)
+}
+
+func (t *scopedSpotTrader) MakeMarketOrder(amount decimal.Decimal, dir common.OrderDir, byQuote bool, maxSlippage decimal.Decimal, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeMarketOrder(amount, dir, byQuote, maxSlippage, reduceOnly, purpose, observer)
+}
+
+func (t *scopedSpotTrader) Orders() []common.Order {
+	return t.scopedTrader.Orders()
+}
+
+func (t *scopedSpotTrader) Uninit() {
+	t.scopedTrader.Uninit()
+}
+
+// #endregion
+
+type scopedFutureTrader struct {
+	common.FutureTrader
+	scopedTrader
+}
+
+func newScopedFutureTrader(scope *orderScope, t common.FutureTrader) *scopedFutureTrader {
+	ft := &scopedFutureTrader{FutureTrader: t}
+	ft.scope = scope
+	ft.trader = t
+	m := t.FutureMarket()
+	ft.fnValue = func(px, sz decimal.Decimal) decimal.Decimal {
+		if m.IsUsdtContract() {
+			return px.Mul(sz).Mul(m.ValueAmount())
+		}
+		return sz.Mul(m.ValueAmount())
+	}
+	ft.fnPnl = func(avgPx, px, sz decimal.Decimal) decimal.Decimal {
+		if m.IsUsdtContract() {
+			return px.Sub(avgPx).Mul(sz).Mul(m.ValueAmount())
+		}
+		// 币本位按平仓价格折算为美元
+		if avgPx.IsZero() {
+			return decimal.Zero
+		}
+		return sz.Mul(m.ValueAmount()).Mul(px.Div(avgPx).Sub(decimal.NewFromInt(1)))
+	}
+	return ft
+}
+
+// #region 消除嵌入冲突
+func (t *scopedFutureTrader) MakeOrder(price, amount decimal.Decimal, dir common.OrderDir, makeOnly, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeOrder(price, amount, dir, makeOnly, reduceOnly, purpose, observer)
+}
+
+func (t *scopedFutureTrader) MakeOrderTIF(price, amount decimal.Decimal, dir common.OrderDir, tif common.TimeInForce, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeOrderTIF(price, amount, dir, tif, reduceOnly, purpose, observer)
+}
+
+func (t *scopedFutureTrader) MakeMarketOrder(amount decimal.Decimal, dir common.OrderDir, byQuote bool, maxSlippage decimal.Decimal, reduceOnly bool, purpose string, observer common.OrderObserver) common.Order {
+	return t.scopedTrader.MakeMarketOrder(amount, dir, byQuote, maxSlippage, reduceOnly, purpose, observer)
+}
+
+func (t *scopedFutureTrader) Orders() []common.Order {
+	return t.scopedTrader.Orders()
+}
+
+func (t *scopedFutureTrader) Uninit() {
+	t.scopedTrader.Uninit()
+}
+
+// #endregion
@@ -23,6 +23,7 @@ import (
 
 	"github.com/aztecqt/dagger/api"
 	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
 )
 
 type Terminal struct {
@@ -65,6 +66,7 @@ func (t *Terminal) OnCommand(cmdLine string, onResp func(string)) {
 			sb.WriteString("cs:             print put all call stack\n")
 			sb.WriteString("exit/quit:      stop stratergy and quit\n")
 			sb.WriteString("wslog:          switch websocket log on/off\n")
+			sb.WriteString("log:            show/set log level, see logger.OnCommand\n")
 			sb.WriteString("status:         show stratergy's current status\n")
 			sb.WriteString("param:          show stratergy's current param\n")
 			t.s.OnCommand("help", func(resp string) {
@@ -152,7 +154,11 @@ func (t *Terminal) OnCommand(cmdLine string, onResp func(string)) {
 			onResp("websocket log switch to off")
 		}
 	default:
-		t.s.OnCommand(cmdLine, onResp)
+		if resp, ok := logger.OnCommand(cmdLine); ok {
+			onResp(resp)
+		} else {
+			t.s.OnCommand(cmdLine, onResp)
+		}
 	}
 }
 
@@ -0,0 +1,123 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-06 14:12:30
+- @Description: 运行时统计，供调试服务(framework的debug_port)查询
+- 1. 协程数、内存、GC等runtime数据
+- 2. 各模块注册的队列深度，如订单操作队列、事件总线的异步订阅、通知队列。队列持续堆积说明消费者卡住了
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package debugstats
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+var startTime = time.Now()
+
+var muQueues sync.RWMutex
+var queues = make(map[string]func() int)
+
+// 同名的会被替换。fn需要能在任意协程中调用
+func RegisterQueue(name string, fn func() int) {
+	muQueues.Lock()
+	defer muQueues.Unlock()
+	queues[name] = fn
+}
+
+func UnregisterQueue(name string) {
+	muQueues.Lock()
+	defer muQueues.Unlock()
+	delete(queues, name)
+}
+
+type QueueDepth struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+}
+
+// 按名称排序
+func QueueDepths() []QueueDepth {
+	muQueues.RLock()
+	fns := make(map[string]func() int, len(queues))
+	for k, v := range queues {
+		fns[k] = v
+	}
+	muQueues.RUnlock()
+
+	qs := make([]QueueDepth, 0, len(fns))
+	for name, fn := range fns {
+		qs = append(qs, QueueDepth{Name: name, Depth: fn()})
+	}
+	sort.Slice(qs, func(i, j int) bool { return qs[i].Name < qs[j].Name })
+	return qs
+}
+
+type MemStats struct {
+	Alloc         uint64  `json:"alloc"`
+	TotalAlloc    uint64  `json:"total_alloc"`
+	Sys           uint64  `json:"sys"`
+	HeapInuse     uint64  `json:"heap_inuse"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	StackInuse    uint64  `json:"stack_inuse"`
+	Mallocs       uint64  `json:"mallocs"`
+	Frees         uint64  `json:"frees"`
+	NextGC        uint64  `json:"next_gc"`
+	NumGC         uint32  `json:"num_gc"`
+	PauseTotalMs  int64   `json:"pause_total_ms"`
+	LastPauseUs   int64   `json:"last_pause_us"`
+	LastGC        string  `json:"last_gc"`
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+type Stats struct {
+	Time       time.Time    `json:"time"`
+	UptimeSec  int64        `json:"uptime_sec"`
+	GoVersion  string       `json:"go_version"`
+	NumCPU     int          `json:"num_cpu"`
+	GOMAXPROCS int          `json:"gomaxprocs"`
+	Goroutines int          `json:"goroutines"`
+	CgoCalls   int64        `json:"cgo_calls"`
+	Mem        MemStats     `json:"mem"`
+	Queues     []QueueDepth `json:"queues"`
+}
+
+// ReadMemStats会短暂地stop the world，不要高频调用
+func Collect() Stats {
+	ms := runtime.MemStats{}
+	runtime.ReadMemStats(&ms)
+
+	s := Stats{
+		Time:       time.Now(),
+		UptimeSec:  int64(time.Since(startTime).Seconds()),
+		GoVersion:  runtime.Version(),
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		CgoCalls:   runtime.NumCgoCall(),
+		Queues:     QueueDepths(),
+	}
+
+	s.Mem = MemStats{
+		Alloc:         ms.Alloc,
+		TotalAlloc:    ms.TotalAlloc,
+		Sys:           ms.Sys,
+		HeapInuse:     ms.HeapInuse,
+		HeapObjects:   ms.HeapObjects,
+		StackInuse:    ms.StackInuse,
+		Mallocs:       ms.Mallocs,
+		Frees:         ms.Frees,
+		NextGC:        ms.NextGC,
+		NumGC:         ms.NumGC,
+		PauseTotalMs:  time.Duration(ms.PauseTotalNs).Milliseconds(),
+		GCCPUFraction: ms.GCCPUFraction,
+	}
+
+	if ms.NumGC > 0 {
+		s.Mem.LastPauseUs = time.Duration(ms.PauseNs[(ms.NumGC+255)%256]).Microseconds()
+		s.Mem.LastGC = time.Unix(0, int64(ms.LastGC)).Format(time.RFC3339)
+	}
+	return s
+}
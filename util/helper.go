@@ -11,6 +11,8 @@ package util
 import (
 	"bytes"
 	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
 	"net"
@@ -33,6 +35,30 @@ func GzipDecode(in []byte) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
+// 按数据头自动识别gzip/zlib/deflate并解压
+// gzip以1f8b开头(如火币)，zlib头部两字节能被31整除，其余按raw deflate处理(如okex v3)
+func DecompressAuto(in []byte) ([]byte, error) {
+	if len(in) >= 2 && in[0] == 0x1f && in[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(in))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+
+	if len(in) >= 2 && in[0]&0x0f == 8 && (uint16(in[0])<<8|uint16(in[1]))%31 == 0 {
+		if reader, err := zlib.NewReader(bytes.NewReader(in)); err == nil {
+			defer reader.Close()
+			if out, err := io.ReadAll(reader); err == nil {
+				return out, nil
+			}
+		}
+	}
+
+	return GzipDecode(in)
+}
+
 func DefaultRecover() {
 	err := recover()
 
@@ -0,0 +1,163 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-30 10:05:18
+- @Description: 带版本号的持久化文件
+- json文件外包一层{kind, version, data}，二进制文件开头写入4字节标识+2字节版本号
+- 读取时识别没有版本信息的旧文件(视为版本0)，并按注册的迁移函数逐级升级到当前版本
+- 文件版本高于程序版本时拒绝读取，防止旧程序误读新格式
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+const versionedLogPrefix = "versioned"
+
+// json文件的外层结构
+type VersionedFile struct {
+	Kind    string          `json:"kind"`
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// 把from版本的数据升级为from+1版本
+type MigrateFn func(data json.RawMessage) (json.RawMessage, error)
+
+var migrations = make(map[string]map[int] /*from*/ MigrateFn)
+var muMigrations sync.RWMutex
+
+// 格式未变化，仅用于给旧文件补上版本号
+func NoopMigration(data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
+
+// 注册某类文件从from版本升级到from+1版本的迁移函数
+func RegisterMigration(kind string, from int, fn MigrateFn) {
+	muMigrations.Lock()
+	defer muMigrations.Unlock()
+	if _, ok := migrations[kind]; !ok {
+		migrations[kind] = make(map[int]MigrateFn)
+	}
+	migrations[kind][from] = fn
+}
+
+// 逐级升级到目标版本
+func migrate(kind string, data json.RawMessage, from, to int) (json.RawMessage, error) {
+	muMigrations.RLock()
+	defer muMigrations.RUnlock()
+	for v := from; v < to; v++ {
+		fn, ok := migrations[kind][v]
+		if !ok {
+			return nil, fmt.Errorf("no migration for %s from version %d", kind, v)
+		}
+
+		var err error
+		if data, err = fn(data); err != nil {
+			return nil, fmt.Errorf("migrate %s from version %d failed: %s", kind, v, err.Error())
+		}
+	}
+	return data, nil
+}
+
+func VersionedObjectToFile(filePath, kind string, version int, obj interface{}) bool {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		logger.LogImportant(versionedLogPrefix, "marshal %s failed: %s", kind, err.Error())
+		return false
+	}
+	return ObjectToFile(filePath, VersionedFile{Kind: kind, Version: version, Data: data})
+}
+
+// 读取并升级到version版本。没有版本信息的旧文件视为版本0
+func VersionedObjectFromFile(filePath, kind string, version int, obj interface{}) bool {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+
+	vf := VersionedFile{}
+	if json.Unmarshal(b, &vf) != nil || len(vf.Kind) == 0 || vf.Data == nil {
+		vf = VersionedFile{Kind: kind, Version: 0, Data: b}
+	}
+
+	if vf.Kind != kind {
+		logger.LogImportant(versionedLogPrefix, "%s: kind mismatch, want %s, got %s", filePath, kind, vf.Kind)
+		return false
+	}
+
+	if vf.Version > version {
+		logger.LogImportant(versionedLogPrefix, "%s: %s version %d is newer than supported version %d", filePath, kind, vf.Version, version)
+		return false
+	}
+
+	data := vf.Data
+	if vf.Version < version {
+		if data, err = migrate(kind, data, vf.Version, version); err != nil {
+			logger.LogImportant(versionedLogPrefix, "%s: %s", filePath, err.Error())
+			return false
+		}
+		logger.LogInfo(versionedLogPrefix, "%s: %s migrated from version %d to %d", filePath, kind, vf.Version, version)
+	}
+
+	if err := json.Unmarshal(data, obj); err != nil {
+		logger.LogImportant(versionedLogPrefix, "%s: unmarshal %s failed: %s", filePath, kind, err.Error())
+		return false
+	}
+	return true
+}
+
+// 二进制文件头，magic为4字节标识。流式写入的文件可在开头直接写入
+func VersionedHeader(magic string, version uint16) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(magic[:4])
+	binary.Write(buf, binary.LittleEndian, version)
+	return buf.Bytes()
+}
+
+// 写入带版本头的二进制文件
+func VersionedBytesToFile(filePath, magic string, version uint16, b []byte) bool {
+	return BytesToFile(filePath, append(VersionedHeader(magic, version), b...))
+}
+
+// 读取二进制文件头并返回版本号，没有文件头的旧文件版本为0(不消耗数据)
+func ReadVersionedHeader(r *bufio.Reader, magic string) uint16 {
+	if head, err := r.Peek(6); err == nil && string(head[:4]) == magic[:4] {
+		r.Discard(6)
+		return binary.LittleEndian.Uint16(head[4:])
+	}
+	return 0
+}
+
+// 从带版本头的二进制文件中反序列化一组对象
+// 没有文件头的旧文件版本为0。fnNewObj根据文件版本创建对应的解析对象，版本高于maxVersion时拒绝读取
+func FileDeserializeToObjectsVersioned[T Deserializable](
+	filePath, magic string,
+	maxVersion uint16,
+	fnNewObj func(version uint16) T,
+	fnOnNewObj func(o T) bool) bool {
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0666)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	version := ReadVersionedHeader(r, magic)
+
+	if version > maxVersion {
+		logger.LogImportant(versionedLogPrefix, "%s: version %d is newer than supported version %d", filePath, version, maxVersion)
+		return false
+	}
+
+	return DeserializeToObjects(r, func() T { return fnNewObj(version) }, fnOnNewObj)
+}
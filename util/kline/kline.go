@@ -0,0 +1,103 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-05 14:10:27
+- @Description: K线重采样和对齐工具
+- 对齐：日线及以上周期的起点因交易所而异(币安按UTC，okx默认按UTC+8)，周线从周一开始
+- 重采样：把小周期K线(如1分钟)合成为任意整数倍的周期，缺失的K线可以用前一根收盘价补齐
+- 不支持月线等不定长周期
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package kline
+
+import (
+	"slices"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Unit struct {
+	Time   time.Time // 起始时间
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// K线周期的起点所在时区
+type Boundary time.Duration
+
+const (
+	Boundary_Utc  Boundary = 0                       // 币安
+	Boundary_Utc8 Boundary = Boundary(time.Hour * 8) // okx的日线/周线
+)
+
+// t所在的K线的起始时间
+// Truncate以公元1年1月1日(周一)为起点，周线自然从周一开始，不需要额外偏移
+func Align(t time.Time, interval time.Duration, b Boundary) time.Time {
+	if interval <= 0 {
+		return t
+	}
+
+	offset := time.Duration(b)
+	return t.Add(offset).Truncate(interval).Add(-offset)
+}
+
+// 按时间排序，并去掉时间重复的K线，重复时保留后出现的(通常是更新的数据)
+func Dedupe(units []Unit) []Unit {
+	slices.SortStableFunc(units, func(a, b Unit) int { return a.Time.Compare(b.Time) })
+	result := make([]Unit, 0, len(units))
+	for _, u := range units {
+		if n := len(result); n > 0 && result[n-1].Time.Equal(u.Time) {
+			result[n-1] = u
+		} else {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// 补齐中间缺失的K线，以前一根的收盘价填充，成交量为0。units需已排序
+func FillGaps(units []Unit, interval time.Duration) []Unit {
+	if len(units) == 0 || interval <= 0 {
+		return units
+	}
+
+	result := make([]Unit, 0, len(units))
+	for i, u := range units {
+		if i > 0 {
+			prev := result[len(result)-1]
+			for t := prev.Time.Add(interval); t.Before(u.Time); t = t.Add(interval) {
+				px := prev.Close
+				result = append(result, Unit{Time: t, Open: px, High: px, Low: px, Close: px})
+			}
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// 把小周期K线合成为interval周期，units需已排序。输出K线的时间按b对齐
+func Resample(units []Unit, interval time.Duration, b Boundary) []Unit {
+	result := []Unit{}
+	for _, u := range units {
+		t := Align(u.Time, interval, b)
+		if n := len(result); n > 0 && result[n-1].Time.Equal(t) {
+			last := &result[n-1]
+			last.High = decimal.Max(last.High, u.High)
+			last.Low = decimal.Min(last.Low, u.Low)
+			last.Close = u.Close
+			last.Volume = last.Volume.Add(u.Volume)
+		} else {
+			u.Time = t
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// 最后一根K线在now时是否已经走完
+func Closed(u Unit, interval time.Duration, b Boundary, now time.Time) bool {
+	return !Align(now, interval, b).Equal(Align(u.Time, interval, b))
+}
@@ -0,0 +1,55 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-07 10:12:45
+- @Description: K线对齐测试
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package kline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignWeek(t *testing.T) {
+	week := time.Hour * 24 * 7
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	cases := []struct {
+		t      time.Time
+		b      Boundary
+		expect time.Time
+	}{
+		// 2024-08-07是周三
+		{time.Date(2024, 8, 7, 13, 0, 0, 0, time.UTC), Boundary_Utc, time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC), Boundary_Utc, time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 8, 4, 23, 59, 59, 0, time.UTC), Boundary_Utc, time.Date(2024, 7, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 8, 11, 23, 59, 59, 0, time.UTC), Boundary_Utc, time.Date(2024, 8, 5, 0, 0, 0, 0, time.UTC)},
+
+		// UTC+8的周一0点，即UTC的周日16点
+		{time.Date(2024, 8, 7, 13, 0, 0, 0, utc8), Boundary_Utc8, time.Date(2024, 8, 5, 0, 0, 0, 0, utc8)},
+		{time.Date(2024, 8, 4, 16, 0, 0, 0, time.UTC), Boundary_Utc8, time.Date(2024, 8, 5, 0, 0, 0, 0, utc8)},
+		{time.Date(2024, 8, 4, 15, 59, 59, 0, time.UTC), Boundary_Utc8, time.Date(2024, 7, 29, 0, 0, 0, 0, utc8)},
+	}
+
+	for _, c := range cases {
+		if got := Align(c.t, week, c.b); !got.Equal(c.expect) {
+			t.Errorf("Align(%s, week, %v) = %s, expect %s", c.t, time.Duration(c.b), got, c.expect)
+		}
+
+		if got := Align(c.t, week, c.b); got.Add(time.Duration(c.b)).UTC().Weekday() != time.Monday {
+			t.Errorf("Align(%s, week, %v) = %s, not monday", c.t, time.Duration(c.b), got)
+		}
+	}
+}
+
+func TestAlignDay(t *testing.T) {
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	tm := time.Date(2024, 8, 7, 3, 0, 0, 0, utc8) // UTC的8月6日19点
+	if got := Align(tm, time.Hour*24, Boundary_Utc); !got.Equal(time.Date(2024, 8, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("utc day: %s", got)
+	}
+
+	if got := Align(tm, time.Hour*24, Boundary_Utc8); !got.Equal(time.Date(2024, 8, 7, 0, 0, 0, 0, utc8)) {
+		t.Errorf("utc+8 day: %s", got)
+	}
+}
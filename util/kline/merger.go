@@ -0,0 +1,99 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-05 14:52:40
+- @Description: 合并rest历史K线和实时推送的K线
+- 推送的K线在走完前会反复更新同一根，时间相同的K线以最新一次为准
+- 历史和推送可以按任意顺序到达，重叠部分以推送为准(推送通常更新)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package kline
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type Merger struct {
+	mu       sync.Mutex
+	interval time.Duration
+	maxUnits int
+	units    []Unit
+	live     map[int64]bool // 来自推送的K线(unix毫秒)，合并历史时不会被覆盖
+	fnClosed func(u Unit)
+}
+
+// maxUnits为最多保留的K线根数，<=0表示不限制
+func (m *Merger) Init(interval time.Duration, maxUnits int) {
+	m.interval = interval
+	m.maxUnits = maxUnits
+	m.live = make(map[int64]bool)
+}
+
+// K线走完时回调(下一根K线的推送到达时触发)
+func (m *Merger) SetClosedFn(fn func(u Unit)) {
+	m.fnClosed = fn
+}
+
+// 合并一批历史K线，缺失的部分自动补齐
+func (m *Merger) AddHistory(units []Unit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merged := make([]Unit, 0, len(m.units)+len(units))
+	for _, u := range units {
+		if !m.live[u.Time.UnixMilli()] {
+			merged = append(merged, u)
+		}
+	}
+	merged = append(merged, m.units...)
+	m.units = FillGaps(Dedupe(merged), m.interval)
+	m.trim()
+}
+
+// 实时推送的K线
+func (m *Merger) OnLive(u Unit) {
+	m.mu.Lock()
+	var closed []Unit
+	n := len(m.units)
+	if n == 0 || u.Time.After(m.units[n-1].Time) {
+		// 新的一根，之前的最后一根已走完
+		if n > 0 {
+			closed = append(closed, m.units[n-1])
+		}
+		m.units = FillGaps(append(m.units, u), m.interval)
+	} else if i, found := slices.BinarySearchFunc(m.units, u.Time, func(x Unit, t time.Time) int { return x.Time.Compare(t) }); found {
+		m.units[i] = u
+	} else {
+		m.units = slices.Insert(m.units, i, u)
+	}
+	m.live[u.Time.UnixMilli()] = true
+	m.trim()
+	m.mu.Unlock()
+
+	if m.fnClosed != nil {
+		for _, c := range closed {
+			m.fnClosed(c)
+		}
+	}
+}
+
+// 最近n根K线(含未走完的一根)，n<=0表示全部
+func (m *Merger) Units(n int) []Unit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start := 0
+	if n > 0 && n < len(m.units) {
+		start = len(m.units) - n
+	}
+	return slices.Clone(m.units[start:])
+}
+
+func (m *Merger) trim() {
+	if m.maxUnits > 0 && len(m.units) > m.maxUnits {
+		for _, u := range m.units[:len(m.units)-m.maxUnits] {
+			delete(m.live, u.Time.UnixMilli())
+		}
+		m.units = m.units[len(m.units)-m.maxUnits:]
+	}
+}
@@ -0,0 +1,151 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-31 17:05:14
+- @Description: 运行时调整日志等级
+- 1. 按前缀设置等级，以该字符串开头的前缀都生效，多个匹配时取最长的。如把某个品种的深度日志设为none而不影响其他日志，或只对某个订单打开debug
+- 2. 前缀等级代替FileLogLevel，控制台等级取两者中较高的。LogPanic不受前缀等级影响
+- 3. OnCommand解析log命令，由Terminal和策略的命令处理调用，可在不重启的情况下调整：
+- log                          显示当前等级
+- log file/console <lv>        设置文件/控制台的等级
+- log prefix <prefix> <lv>     设置前缀等级
+- log prefix <prefix> reset    取消前缀等级
+- lv为debug/info/important/none
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LogPanic使用的等级，输出时按LogLevel_Important处理
+const levelPanic = LogLevel_None + 1
+
+var prefixLevels = map[string]LogLevel{}
+var prefixLevelCount int32
+var muPrefixLevels sync.RWMutex
+
+func LogLevel2String(level LogLevel) string {
+	switch level {
+	case LogLevel_Debug:
+		return "debug"
+	case LogLevel_Info:
+		return "info"
+	case LogLevel_Important:
+		return "important"
+	case LogLevel_None:
+		return "none"
+	default:
+		return "panic"
+	}
+}
+
+func SetPrefixLevel(prefix string, level LogLevel) {
+	muPrefixLevels.Lock()
+	defer muPrefixLevels.Unlock()
+	prefixLevels[prefix] = level
+	atomic.StoreInt32(&prefixLevelCount, int32(len(prefixLevels)))
+}
+
+func ResetPrefixLevel(prefix string) {
+	muPrefixLevels.Lock()
+	defer muPrefixLevels.Unlock()
+	delete(prefixLevels, prefix)
+	atomic.StoreInt32(&prefixLevelCount, int32(len(prefixLevels)))
+}
+
+func PrefixLevels() map[string]LogLevel {
+	muPrefixLevels.RLock()
+	defer muPrefixLevels.RUnlock()
+	levels := make(map[string]LogLevel, len(prefixLevels))
+	for p, l := range prefixLevels {
+		levels[p] = l
+	}
+	return levels
+}
+
+// 某个前缀的文件、控制台等级
+func levelsOf(prefix string) (file, console LogLevel) {
+	file, console = FileLogLevel, ConsleLogLevel
+	if atomic.LoadInt32(&prefixLevelCount) == 0 {
+		return
+	}
+
+	muPrefixLevels.RLock()
+	defer muPrefixLevels.RUnlock()
+	matched := ""
+	for p, l := range prefixLevels {
+		if strings.HasPrefix(prefix, p) && len(p) >= len(matched) {
+			matched = p
+			file = l
+		}
+	}
+
+	if len(matched) > 0 {
+		console = max(ConsleLogLevel, file)
+	}
+	return
+}
+
+// 处理log命令，cmdLine不是log命令时返回false
+func OnCommand(cmdLine string) (string, bool) {
+	args := strings.Fields(cmdLine)
+	if len(args) == 0 || args[0] != "log" {
+		return "", false
+	}
+
+	parseLevel := func(str string) (lv LogLevel, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("invalid log level: %s", str)
+			}
+		}()
+		return String2LogLevel(str), nil
+	}
+
+	switch {
+	case len(args) == 1:
+		sb := strings.Builder{}
+		sb.WriteString(fmt.Sprintf("file: %s\nconsole: %s\n", LogLevel2String(FileLogLevel), LogLevel2String(ConsleLogLevel)))
+		levels := PrefixLevels()
+		prefixes := make([]string, 0, len(levels))
+		for p := range levels {
+			prefixes = append(prefixes, p)
+		}
+		sort.Strings(prefixes)
+		for _, p := range prefixes {
+			sb.WriteString(fmt.Sprintf("prefix %s: %s\n", p, LogLevel2String(levels[p])))
+		}
+		return sb.String(), true
+	case len(args) == 3 && (args[1] == "file" || args[1] == "console"):
+		lv, err := parseLevel(args[2])
+		if err != nil {
+			return err.Error(), true
+		}
+
+		if args[1] == "file" {
+			FileLogLevel = lv
+		} else {
+			ConsleLogLevel = lv
+		}
+		return fmt.Sprintf("%s log level set to %s", args[1], LogLevel2String(lv)), true
+	case len(args) == 4 && args[1] == "prefix":
+		if args[3] == "reset" {
+			ResetPrefixLevel(args[2])
+			return fmt.Sprintf("log level of prefix %s reset", args[2]), true
+		}
+
+		lv, err := parseLevel(args[3])
+		if err != nil {
+			return err.Error(), true
+		}
+		SetPrefixLevel(args[2], lv)
+		return fmt.Sprintf("log level of prefix %s set to %s", args[2], LogLevel2String(lv)), true
+	default:
+		return "usage: log | log file/console <lv> | log prefix <prefix> <lv>/reset", true
+	}
+}
@@ -7,6 +7,7 @@
  * @Description:
  * 日志管理器。基于go自带的log包。以hour/day为文件夹存储个个日志文件
  * 可以设置需要保留的日志文件夹个数以防止占用过多磁盘空间
+ * 按大小切分、压缩、按前缀分文件见rotate.go
  *
  * Copyright (c) 2022 by aztec, All Rights Reserved.
 */
@@ -14,8 +15,8 @@
 package logger
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
@@ -52,6 +53,8 @@ func String2LogLevel(str string) LogLevel {
 		return LogLevel_Info
 	case "important":
 		return LogLevel_Important
+	case "none":
+		return LogLevel_None
 	default:
 		panic("invalid log level:" + str)
 	}
@@ -68,19 +71,18 @@ func MinLogLevel() LogLevel {
 	}
 }
 
-// 最大日志文件数量
+// 保留的时间片数量
 var maxFileCount = 0
 
-// 当前日志文件名
+// 日志根目录
 var fileDir string = "log/"
-var filePath string
 
-// 上次输出日志的时间
-var lastTime = time.Now()
+// 主日志文件，见rotate.go
+var mainFile *rotatingFile
 
 // go日志对象
-var fileLogger *log.Logger
 var consoleLogger *log.Logger
+var consoleJsonLogger *log.Logger // json格式自带时间，不加前缀
 
 // 初始化需要手动调用
 var inited bool
@@ -145,25 +147,26 @@ func Init(sMode SplitMode, maxCount int) {
 	}
 
 	createLogDir()
-	createInnerLogger()
-	checkLogFileCount()
+	consoleLogger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	consoleJsonLogger = log.New(os.Stdout, "", 0)
+	mainFile = newRotatingFile(fileDir)
+	mainFile.mu.Lock()
+	mainFile.rotate(nowPeriod(), -1)
+	mainFile.mu.Unlock()
 	inited = true
 }
 
-// 当前时间对应的日志文件路径
-func nowFilePath() string {
-	path := ""
+// 当前时间片，即日志文件名(不含序号和扩展名)
+func nowPeriod() string {
 	now := time.Now()
 	switch splitMode {
-	case SplitMode_ByDays:
-		path = fileDir + now.Format("2006-01-02.log")
 	case SplitMode_ByHours:
-		path = fileDir + now.Format("2006-01-02_15.log")
+		return now.Format("2006-01-02_15")
 	case SplitMode_ByMinutes:
-		path = fileDir + now.Format("2006-01-02_15-04.log")
+		return now.Format("2006-01-02_15-04")
+	default:
+		return now.Format("2006-01-02")
 	}
-
-	return path
 }
 
 // 创建日志根目录
@@ -174,140 +177,93 @@ func createLogDir() {
 	}
 }
 
-// 创建新的innerLogger
-func createInnerLogger() {
-	filePath = nowFilePath()
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		log.Panicln("failed to create log file, path:", filePath)
-	} else {
-		fileLogger = log.New(file, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+func doFileLog(prefix string, msg *string) {
+	if !inited {
+		panic("call logger.Init first!")
 	}
 
-	consoleLogger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	r := prefixFileOf(prefix)
+	if r == nil {
+		r = mainFile
+	}
+	r.println(*msg, LogFormat == Format_Json)
 }
 
-// 当日志目录下的日志文件过多时，删除一部分日志文件
-func checkLogFileCount() {
-	fileInfos, err := ioutil.ReadDir(fileDir)
-	if err != nil {
-		log.Panicln("failed to get log file list")
-	} else {
-		if len(fileInfos) > maxFileCount && maxFileCount > 0 {
-			for i := 0; i < len(fileInfos)-maxFileCount; i++ {
-				fullPath := fileDir + fileInfos[i].Name()
-				os.Remove(fullPath)
-			}
-		}
-	}
+func LogDebug(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Debug, prefix, nil, format, a...)
 }
 
-// 判断是否跨越到下一个时间片
-func needNewInnerLogger() bool {
-	now := time.Now()
-	switch splitMode {
-	case SplitMode_ByDays:
-		return lastTime.YearDay() != now.YearDay()
-	case SplitMode_ByHours:
-		return lastTime.Hour() != now.Hour() || (now.Unix()-lastTime.Unix()) > 3600
-	case SplitMode_ByMinutes:
-		return lastTime.Minute() != time.Now().Minute() || (now.Unix()-lastTime.Unix()) > 60
-	}
+func LogInfo(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Info, prefix, nil, format, a...)
+}
 
-	return false
+func LogImportant(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Important, prefix, nil, format, a...)
 }
 
-func doFileLog(msg *string) {
-	if !inited {
-		panic("call logger.Init first!")
+func LogPanic(prefix string, format string, a ...interface{}) {
+	if msg, ok := doLog(levelPanic, prefix, nil, format, a...); ok {
+		panic(msg)
 	}
+}
 
-	if needNewInnerLogger() {
-		createInnerLogger()
-		checkLogFileCount()
-	}
+// 严格模式下LogStrict会panic，用于开发调试时尽早暴露问题
+var StrictMode = false
 
-	fileLogger.Println(*msg)
+// 可恢复的错误(如订单快照不匹配、参数错误)。严格模式下panic，否则按important记录并返回error
+func LogStrict(prefix string, format string, a ...interface{}) error {
+	return logStrict(prefix, nil, format, a...)
 }
 
-func LogDebug(prefix string, format string, a ...interface{}) {
-	if FileLogLevel <= LogLevel_Debug || ConsleLogLevel <= LogLevel_Debug {
-		msg := ""
-		if len(a) > 0 {
-			msg = fmt.Sprintf(format, a...)
-			msg = fmt.Sprintf("[%s] %s", prefix, msg)
-		} else {
-			msg = fmt.Sprintf("[%s] %s", prefix, format)
-		}
-
-		if FileLogLevel <= LogLevel_Debug {
-			doFileLog(&msg)
-		}
+func logStrict(prefix string, fields *Fields, format string, a ...interface{}) error {
+	text := format
+	if len(a) > 0 {
+		text = fmt.Sprintf(format, a...)
+	}
 
-		if ConsleLogLevel <= LogLevel_Debug {
-			consoleLogger.Println(msg)
-		}
+	if StrictMode {
+		doLog(levelPanic, prefix, fields, format, a...)
+		panic(fmt.Sprintf("[%s] %s", prefix, text))
 	}
-}
 
-func LogInfo(prefix string, format string, a ...interface{}) {
-	if FileLogLevel <= LogLevel_Info || ConsleLogLevel <= LogLevel_Info {
-		msg := ""
-		if len(a) > 0 {
-			msg = fmt.Sprintf(format, a...)
-			msg = fmt.Sprintf("[%s] %s", prefix, msg)
-		} else {
-			msg = fmt.Sprintf("[%s] %s", prefix, format)
-		}
+	doLog(LogLevel_Important, prefix, fields, format, a...)
+	return errors.New(text)
+}
 
-		if FileLogLevel <= LogLevel_Info {
-			doFileLog(&msg)
-		}
+// 返回文本格式的消息，及是否输出
+func doLog(level LogLevel, prefix string, fields *Fields, format string, a ...interface{}) (string, bool) {
+	fileLevel, consoleLevel := FileLogLevel, ConsleLogLevel
+	outLevel := LogLevel_Important
+	if level != levelPanic {
+		fileLevel, consoleLevel = levelsOf(prefix)
+		outLevel = level
+	}
 
-		if ConsleLogLevel <= LogLevel_Info {
-			consoleLogger.Println(msg)
-		}
+	if fileLevel > outLevel && consoleLevel > outLevel {
+		return "", false
 	}
-}
 
-func LogImportant(prefix string, format string, a ...interface{}) {
-	if FileLogLevel <= LogLevel_Important || ConsleLogLevel <= LogLevel_Important {
-		msg := ""
-		if len(a) > 0 {
-			msg = fmt.Sprintf(format, a...)
-			msg = fmt.Sprintf("[%s] %s", prefix, msg)
-		} else {
-			msg = fmt.Sprintf("[%s] %s", prefix, format)
-		}
+	text := format
+	if len(a) > 0 {
+		text = fmt.Sprintf(format, a...)
+	}
+	msg := fmt.Sprintf("[%s] %s", prefix, text)
 
-		if FileLogLevel <= LogLevel_Important {
-			doFileLog(&msg)
-		}
+	line := msg
+	if LogFormat == Format_Json {
+		line = jsonLine(level, prefix, fields, text)
+	}
 
-		if ConsleLogLevel <= LogLevel_Important {
-			consoleLogger.Println(msg)
-		}
+	if fileLevel <= outLevel {
+		doFileLog(prefix, &line)
 	}
-}
 
-func LogPanic(prefix string, format string, a ...interface{}) {
-	if FileLogLevel <= LogLevel_Important || ConsleLogLevel <= LogLevel_Important {
-		msg := ""
-		if len(a) > 0 {
-			msg = fmt.Sprintf(format, a...)
-			msg = fmt.Sprintf("[%s] %s", prefix, msg)
+	if consoleLevel <= outLevel {
+		if LogFormat == Format_Json {
+			consoleJsonLogger.Println(line)
 		} else {
-			msg = fmt.Sprintf("[%s] %s", prefix, format)
-		}
-
-		if FileLogLevel <= LogLevel_Important {
-			doFileLog(&msg)
+			consoleLogger.Println(line)
 		}
-
-		if ConsleLogLevel <= LogLevel_Important {
-			consoleLogger.Println(msg)
-		}
-
-		panic(msg)
 	}
+	return msg, true
 }
@@ -0,0 +1,299 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-31 15:12:36
+- @Description: 日志文件的切分、压缩和清理
+- 1. 按时间片(天/小时/分钟)切分，文件名如2024-07-31.log。设置MaxFileSize后，同一时间片内超过大小时继续切分为2024-07-31.1.log、2024-07-31.2.log...
+- 2. CompressRotated为true时，切分出去的文件在后台压缩为.log.gz
+- 3. 清理：只保留最近maxFileCount个时间片的文件；设置MaxTotalSize后，目录总大小超出时从最旧的文件开始删除
+- 4. SplitByPrefix指定的前缀的日志写到单独的子目录(如log/Order/)，切分和清理规则相同，主日志中不再包含这些日志
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 单个日志文件的最大字节数，0为不限制
+var MaxFileSize int64 = 0
+
+// 单个目录下日志文件的最大总字节数，0为不限制
+var MaxTotalSize int64 = 0
+
+// 是否压缩切分出去的文件
+var CompressRotated = false
+
+type rotatingFile struct {
+	dir    string
+	mu     sync.Mutex
+	period string // 当前时间片，如2024-07-31
+	index  int    // 时间片内按大小切分的序号
+	file   *os.File
+	size   int64
+	text   *log.Logger
+	json   *log.Logger // json格式自带时间，不加前缀
+}
+
+func newRotatingFile(dir string) *rotatingFile {
+	r := &rotatingFile{dir: dir}
+	r.text = log.New(r, "", log.Ldate|log.Ltime|log.Lmicroseconds)
+	r.json = log.New(r, "", 0)
+	return r
+}
+
+// 供log.Logger写入，调用时已持有mu
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) println(msg string, jsonFormat bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if period := nowPeriod(); r.file == nil || period != r.period {
+		r.rotate(period, -1)
+	} else if MaxFileSize > 0 && r.size >= MaxFileSize {
+		r.rotate(period, r.index+1)
+	}
+
+	if jsonFormat {
+		r.json.Println(msg)
+	} else {
+		r.text.Println(msg)
+	}
+}
+
+func (r *rotatingFile) path(period string, index int) string {
+	if index == 0 {
+		return filepath.Join(r.dir, period+".log")
+	}
+	return filepath.Join(r.dir, fmt.Sprintf("%s.%d.log", period, index))
+}
+
+// 切换到新文件。index<0时接着该时间片已有的最后一个文件写
+func (r *rotatingFile) rotate(period string, index int) {
+	if r.file != nil {
+		r.file.Close()
+		if CompressRotated {
+			go compressFile(r.file.Name())
+		}
+		r.file = nil
+	}
+
+	if index < 0 {
+		index = r.lastIndex(period)
+	}
+
+	for {
+		path := r.path(period, index)
+		if _, err := os.Stat(path + ".gz"); err == nil {
+			index++
+			continue
+		}
+
+		if fi, err := os.Stat(path); err == nil && MaxFileSize > 0 && fi.Size() >= MaxFileSize {
+			index++
+			continue
+		}
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			log.Panicln("failed to create log file, path:", path)
+		}
+
+		fi, _ := file.Stat()
+		r.file = file
+		r.size = fi.Size()
+		r.period = period
+		r.index = index
+		break
+	}
+
+	cleanLogDir(r.dir, r.file.Name())
+}
+
+// 时间片内已有文件的最大序号
+func (r *rotatingFile) lastIndex(period string) int {
+	entries, _ := os.ReadDir(r.dir)
+	last := 0
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if e.IsDir() || !strings.HasPrefix(name, period+".") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+
+		mid := strings.TrimSuffix(strings.TrimPrefix(name, period+"."), "log")
+		if i, err := strconv.Atoi(strings.TrimSuffix(mid, ".")); err == nil && i > last {
+			last = i
+		}
+	}
+	return last
+}
+
+func compressFile(path string) {
+	defer func() { recover() }()
+
+	src, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+
+	gw := gzip.NewWriter(dst)
+	_, err = io.Copy(gw, src)
+	if err == nil {
+		err = gw.Close()
+	}
+	dst.Close()
+
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+
+	if err == nil {
+		os.Remove(path)
+	} else {
+		os.Remove(tmp)
+	}
+}
+
+// 文件名中第一个'.'之前为时间片
+func periodOfFile(name string) string {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// 按时间片数量、总大小清理目录，当前文件不删除
+func cleanLogDir(dir, current string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Panicln("failed to get log file list")
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+	periods := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() || !(strings.HasSuffix(e.Name(), ".log") || strings.HasSuffix(e.Name(), ".log.gz")) {
+			continue
+		}
+
+		if fi, err := e.Info(); err == nil {
+			files = append(files, fi)
+			periods[periodOfFile(fi.Name())] = true
+		}
+	}
+
+	remove := func(fi os.FileInfo) bool {
+		path := filepath.Join(dir, fi.Name())
+		if path == current {
+			return false
+		}
+		os.Remove(path)
+		return true
+	}
+
+	if maxFileCount > 0 && len(periods) > maxFileCount {
+		sorted := make([]string, 0, len(periods))
+		for p := range periods {
+			sorted = append(sorted, p)
+		}
+		sort.Strings(sorted)
+
+		expired := map[string]bool{}
+		for _, p := range sorted[:len(sorted)-maxFileCount] {
+			expired[p] = true
+		}
+
+		kept := files[:0]
+		for _, fi := range files {
+			if !expired[periodOfFile(fi.Name())] || !remove(fi) {
+				kept = append(kept, fi)
+			}
+		}
+		files = kept
+	}
+
+	if MaxTotalSize > 0 {
+		total := int64(0)
+		for _, fi := range files {
+			total += fi.Size()
+		}
+
+		sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+		for _, fi := range files {
+			if total <= MaxTotalSize {
+				break
+			}
+
+			if remove(fi) {
+				total -= fi.Size()
+			}
+		}
+	}
+}
+
+// #region 按前缀分文件
+var splitPrefixes []string
+var prefixFiles = map[string]*rotatingFile{}
+var muPrefixFiles sync.RWMutex
+
+// 以这些字符串开头的前缀的日志写到单独的子目录，如SplitByPrefix("Order")
+func SplitByPrefix(prefixes ...string) {
+	muPrefixFiles.Lock()
+	defer muPrefixFiles.Unlock()
+	splitPrefixes = append([]string{}, prefixes...)
+}
+
+// 不需要单独写文件时返回nil
+func prefixFileOf(prefix string) *rotatingFile {
+	muPrefixFiles.RLock()
+	split := ""
+	for _, p := range splitPrefixes {
+		if strings.HasPrefix(prefix, p) {
+			split = p
+			break
+		}
+	}
+
+	if len(split) == 0 {
+		muPrefixFiles.RUnlock()
+		return nil
+	}
+
+	r, ok := prefixFiles[split]
+	muPrefixFiles.RUnlock()
+	if ok {
+		return r
+	}
+
+	muPrefixFiles.Lock()
+	defer muPrefixFiles.Unlock()
+	if r, ok = prefixFiles[split]; !ok {
+		dir := filepath.Join(fileDir, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(split))
+		os.MkdirAll(dir, os.ModePerm)
+		r = newRotatingFile(dir)
+		prefixFiles[split] = r
+	}
+	return r
+}
+
+// #endregion
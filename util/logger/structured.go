@@ -0,0 +1,141 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-31 09:48:20
+- @Description: 结构化日志。LogFormat设为Format_Json后，每条日志输出为一行json，便于导入ELK/Loki后按订单、品种查询
+- 如{"time":"2024-07-31T09:48:20.123456+08:00","level":"info","prefix":"Order-BTC-USDT-xxx","msg":"...","exchange":"okx","instId":"BTC-USDT","orderId":"xxx"}
+- 带字段的日志通过Fields的LogXXX方法输出，参数与包级的LogXXX相同。SetGlobalFields设置的字段附加到所有日志，单条日志的字段优先
+- 文本格式下字段不输出，与原来完全一致
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Format int
+
+const (
+	Format_Text Format = iota
+	Format_Json
+)
+
+var LogFormat = Format_Text
+
+func String2Format(str string) Format {
+	switch strings.ToLower(str) {
+	case "", "text":
+		return Format_Text
+	case "json":
+		return Format_Json
+	default:
+		panic("invalid log format:" + str)
+	}
+}
+
+type Fields struct {
+	Exchange   string
+	InstId     string
+	StrategyId string
+	OrderId    string // 一般为自定义订单Id，下单前即确定
+}
+
+var globalFields Fields
+var muGlobalFields sync.RWMutex
+
+// 附加到所有日志的字段，如只运行一个策略的进程可设置StrategyId
+func SetGlobalFields(f Fields) {
+	muGlobalFields.Lock()
+	defer muGlobalFields.Unlock()
+	globalFields = f
+}
+
+func GlobalFields() Fields {
+	muGlobalFields.RLock()
+	defer muGlobalFields.RUnlock()
+	return globalFields
+}
+
+// f中为空的字段由o补充
+func (f Fields) Merge(o Fields) Fields {
+	if len(f.Exchange) == 0 {
+		f.Exchange = o.Exchange
+	}
+
+	if len(f.InstId) == 0 {
+		f.InstId = o.InstId
+	}
+
+	if len(f.StrategyId) == 0 {
+		f.StrategyId = o.StrategyId
+	}
+
+	if len(f.OrderId) == 0 {
+		f.OrderId = o.OrderId
+	}
+	return f
+}
+
+func (f Fields) LogDebug(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Debug, prefix, &f, format, a...)
+}
+
+func (f Fields) LogInfo(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Info, prefix, &f, format, a...)
+}
+
+func (f Fields) LogImportant(prefix string, format string, a ...interface{}) {
+	doLog(LogLevel_Important, prefix, &f, format, a...)
+}
+
+func (f Fields) LogPanic(prefix string, format string, a ...interface{}) {
+	if msg, ok := doLog(levelPanic, prefix, &f, format, a...); ok {
+		panic(msg)
+	}
+}
+
+func (f Fields) LogStrict(prefix string, format string, a ...interface{}) error {
+	return logStrict(prefix, &f, format, a...)
+}
+
+// 字段按固定顺序输出，空字段省略
+func jsonLine(level LogLevel, prefix string, fields *Fields, msg string) string {
+	muGlobalFields.RLock()
+	f := globalFields
+	muGlobalFields.RUnlock()
+	if fields != nil {
+		f = fields.Merge(f)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("{")
+	writeJsonField(&sb, "time", time.Now().Format(time.RFC3339Nano), true)
+	writeJsonField(&sb, "level", LogLevel2String(level), true)
+	writeJsonField(&sb, "prefix", prefix, true)
+	writeJsonField(&sb, "msg", msg, true)
+	writeJsonField(&sb, "exchange", f.Exchange, false)
+	writeJsonField(&sb, "instId", f.InstId, false)
+	writeJsonField(&sb, "strategyId", f.StrategyId, false)
+	writeJsonField(&sb, "orderId", f.OrderId, false)
+	sb.WriteString("}")
+	return sb.String()
+}
+
+func writeJsonField(sb *strings.Builder, key, value string, always bool) {
+	if len(value) == 0 && !always {
+		return
+	}
+
+	if sb.Len() > 1 {
+		sb.WriteString(",")
+	}
+
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	sb.Write(k)
+	sb.WriteString(":")
+	sb.Write(v)
+}
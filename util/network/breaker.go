@@ -0,0 +1,226 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-29 11:35:02
+- @Description: 按接口(method+host+path)熔断。接口持续故障时不再请求，避免反复请求一个坏掉的接口、白白消耗频率限制
+- 1. Closed：正常请求。连续失败Failures次(网络错误、5xx、429)后进入Open
+- 2. Open：直接返回*CircuitOpenError，不发出请求。Cooldown之后进入HalfOpen
+- 3. HalfOpen：只放行Probes个探测请求，成功则恢复Closed，失败则重新Open
+- 业务错误(4xx)说明接口本身可用，视为成功。Failures<=0时不熔断
+- 按host开启(SetBreakerPolicy)，默认不熔断。撤单、平仓接口(DELETE请求、路径含cancel/close)始终放行，交易所故障期间风控和dead-man switch的撤单不受影响
+- 注意：只减仓市价单走普通下单接口，开启熔断的host上仍会被拦截
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/util/logger"
+)
+
+type BreakerState int
+
+const (
+	BreakerState_Closed BreakerState = iota
+	BreakerState_Open
+	BreakerState_HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerState_Closed:
+		return "closed"
+	case BreakerState_Open:
+		return "open"
+	case BreakerState_HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerPolicy struct {
+	Failures int           // 连续失败多少次后熔断
+	Cooldown time.Duration // 熔断持续时间
+	Probes   int           // 半开时放行的探测请求数
+}
+
+var DefaultBreakerPolicy = BreakerPolicy{
+	Failures: 5,
+	Cooldown: time.Second * 30,
+	Probes:   1,
+}
+
+type CircuitOpenError struct {
+	Endpoint string
+	Until    time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s until %s", e.Endpoint, e.Until.Format("15:04:05"))
+}
+
+type breaker struct {
+	state     BreakerState
+	failures  int
+	openUntil time.Time
+	probing   int
+}
+
+var breakerPolicies = make(map[string]BreakerPolicy) // host->熔断参数
+var breakers = make(map[string]*breaker)
+var fnBreakerState func(endpoint string, state BreakerState)
+var muBreakers sync.Mutex
+
+// 对某个host开启熔断，一般使用DefaultBreakerPolicy。p.Failures<=0时关闭
+func SetBreakerPolicy(host string, p BreakerPolicy) {
+	muBreakers.Lock()
+	defer muBreakers.Unlock()
+	if p.Failures <= 0 {
+		delete(breakerPolicies, host)
+		for ep := range breakers {
+			if hostOfEndpoint(ep) == host {
+				delete(breakers, ep)
+			}
+		}
+	} else {
+		breakerPolicies[host] = p
+	}
+}
+
+// 熔断状态变化时回调，可用于报警
+func SetBreakerStateFn(fn func(endpoint string, state BreakerState)) {
+	muBreakers.Lock()
+	defer muBreakers.Unlock()
+	fnBreakerState = fn
+}
+
+// 非Closed状态的接口
+func BreakerStates() map[string]BreakerState {
+	muBreakers.Lock()
+	defer muBreakers.Unlock()
+	states := make(map[string]BreakerState)
+	for ep, b := range breakers {
+		if b.state != BreakerState_Closed {
+			states[ep] = b.state
+		}
+	}
+	return states
+}
+
+func endpointOf(req *http.Request) string {
+	return req.Method + " " + req.URL.Host + req.URL.Path
+}
+
+func hostOfEndpoint(endpoint string) string {
+	_, ep, _ := strings.Cut(endpoint, " ")
+	host, _, _ := strings.Cut(ep, "/")
+	return host
+}
+
+// 撤单、平仓等降低风险的接口，不参与熔断
+func breakerExempt(req *http.Request) bool {
+	if req.Method == http.MethodDelete {
+		return true
+	}
+
+	path := strings.ToLower(req.URL.Path)
+	return strings.Contains(path, "cancel") || strings.Contains(path, "close")
+}
+
+// 未开启熔断的host、不参与熔断的接口返回false
+func breakerPolicyOf(req *http.Request) (BreakerPolicy, bool) {
+	if breakerExempt(req) {
+		return BreakerPolicy{}, false
+	}
+
+	muBreakers.Lock()
+	defer muBreakers.Unlock()
+	p, ok := breakerPolicies[req.URL.Host]
+	return p, ok
+}
+
+// 请求是否放行
+func breakerAllow(endpoint string, p BreakerPolicy) error {
+	muBreakers.Lock()
+
+	b, ok := breakers[endpoint]
+	if !ok {
+		muBreakers.Unlock()
+		return nil
+	}
+
+	changed := false
+	if b.state == BreakerState_Open && !time.Now().Before(b.openUntil) {
+		b.state = BreakerState_HalfOpen
+		b.probing = 0
+		changed = true
+	}
+
+	var err error
+	switch b.state {
+	case BreakerState_Open:
+		err = &CircuitOpenError{Endpoint: endpoint, Until: b.openUntil}
+	case BreakerState_HalfOpen:
+		if b.probing < max(p.Probes, 1) {
+			b.probing++
+		} else {
+			err = &CircuitOpenError{Endpoint: endpoint, Until: b.openUntil}
+		}
+	}
+	fn := fnBreakerState
+	muBreakers.Unlock()
+
+	if changed {
+		notifyBreakerState(fn, endpoint, BreakerState_HalfOpen)
+	}
+	return err
+}
+
+// 记录一次请求的结果
+func breakerRecord(endpoint string, p BreakerPolicy, res *http.Response, err error) {
+	failed := err != nil || res != nil && (res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests)
+
+	muBreakers.Lock()
+
+	b, ok := breakers[endpoint]
+	if !ok {
+		if !failed {
+			muBreakers.Unlock()
+			return
+		}
+		b = &breaker{}
+		breakers[endpoint] = b
+	}
+
+	old := b.state
+	if failed {
+		b.failures++
+		if b.state == BreakerState_HalfOpen || b.failures >= p.Failures {
+			b.state = BreakerState_Open
+			b.openUntil = time.Now().Add(p.Cooldown)
+		}
+	} else {
+		b.failures = 0
+		b.state = BreakerState_Closed
+		delete(breakers, endpoint)
+	}
+	state := b.state
+	fn := fnBreakerState
+	muBreakers.Unlock()
+
+	if state != old {
+		notifyBreakerState(fn, endpoint, state)
+	}
+}
+
+func notifyBreakerState(fn func(endpoint string, state BreakerState), endpoint string, state BreakerState) {
+	logger.LogImportant("http", "circuit breaker of %s: %s", endpoint, state.String())
+	if fn != nil {
+		fn(endpoint, state)
+	}
+}
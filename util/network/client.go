@@ -0,0 +1,112 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-27 16:30:44
+- @Description: http客户端。每个host共用一个客户端，复用连接(keep-alive)和TLS会话，减少握手带来的延迟
+- 默认客户端由NewTunedClient创建，代理见proxy.go。也可以通过SetHttpClient为某个host注入自定义客户端(如设置超时)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var injectedClients = make(map[string]*http.Client) // host->注入的客户端
+var pooledClients = make(map[string]*http.Client)   // host->自动创建的客户端
+var defaultClient *http.Client
+var requestGates = make(map[string]func(method, rawUrl string)) // host->请求前调用，可阻塞以限制频率
+var muClients sync.RWMutex
+
+// 创建一个调优过的客户端：保持长连接、每个host保留较多空闲连接、缓存TLS会话
+// timeout为整个请求的超时，0表示不限
+func NewTunedClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   time.Second * 10,
+		KeepAlive: time.Second * 30,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 ProxyFunc,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       time.Second * 90,
+		TLSHandshakeTimeout:   time.Second * 10,
+		ResponseHeaderTimeout: time.Second * 30,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(64)},
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// 为某个host注入客户端，c为nil时恢复默认
+func SetHttpClient(host string, c *http.Client) {
+	muClients.Lock()
+	defer muClients.Unlock()
+	if c == nil {
+		delete(injectedClients, host)
+	} else {
+		injectedClients[host] = c
+	}
+}
+
+// 所有未注入客户端的host改用c，c为nil时恢复为每个host自动创建
+func SetDefaultHttpClient(c *http.Client) {
+	muClients.Lock()
+	defer muClients.Unlock()
+	defaultClient = c
+}
+
+// 某个url使用的客户端，host首次出现时创建
+func HttpClientOf(rawUrl string) *http.Client {
+	host := ""
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+
+	muClients.RLock()
+	c, ok := injectedClients[host]
+	if !ok && defaultClient != nil {
+		c, ok = defaultClient, true
+	}
+	if !ok {
+		c, ok = pooledClients[host]
+	}
+	muClients.RUnlock()
+	if ok {
+		return c
+	}
+
+	muClients.Lock()
+	defer muClients.Unlock()
+	if c, ok := pooledClients[host]; ok {
+		return c
+	}
+
+	c = NewTunedClient(0)
+	pooledClients[host] = c
+	return c
+}
+
+// 设置某个host的请求闸门，每次发出请求(包括重试)前调用，fn为nil时清除
+func SetRequestGate(host string, fn func(method, rawUrl string)) {
+	muClients.Lock()
+	defer muClients.Unlock()
+	if fn == nil {
+		delete(requestGates, host)
+	} else {
+		requestGates[host] = fn
+	}
+}
+
+func requestGateOf(host string) func(method, rawUrl string) {
+	muClients.RLock()
+	defer muClients.RUnlock()
+	return requestGates[host]
+}
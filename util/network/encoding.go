@@ -0,0 +1,96 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-30 14:26:47
+- @Description: 响应压缩。请求时带上Accept-Encoding: gzip, deflate，收到压缩的响应后透明解压，调用方读到的body始终是解压后的内容
+- k线、exchangeInfo等较大的响应压缩后通常只有原来的十分之一左右，能明显缩短传输时间
+- net/http只在未设置Accept-Encoding时自动处理gzip，且不支持deflate，所以这里自行处理。调用方自己设置了Accept-Encoding时不干预
+- 流量统计(metrics.go)记录的是压缩后的字节数
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var EnableCompression = true
+
+const acceptEncoding = "gzip, deflate"
+
+// 请求前调用
+func setAcceptEncoding(req *http.Request, headers map[string]string) {
+	if !EnableCompression {
+		return
+	}
+
+	for k := range headers {
+		if strings.EqualFold(k, "Accept-Encoding") {
+			return
+		}
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+}
+
+// 收到响应后调用，按Content-Encoding替换为解压后的body
+func decodeResponseBody(res *http.Response) {
+	if res == nil || res.Body == nil || res.Request == nil || res.Request.Header.Get("Accept-Encoding") != acceptEncoding {
+		return
+	}
+
+	raw := res.Body
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		r = &lazyReader{open: func() (io.Reader, error) { return gzip.NewReader(raw) }}
+	case "deflate":
+		// 标准为zlib格式，部分服务器发送的是raw deflate，按头部区分
+		br := bufio.NewReader(raw)
+		r = &lazyReader{open: func() (io.Reader, error) {
+			if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+				return zlib.NewReader(br)
+			}
+			return flate.NewReader(br), nil
+		}}
+	default:
+		return
+	}
+
+	res.Body = &decodedBody{Reader: r, raw: raw}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+}
+
+// 首次读取时才创建解压器，空body(如HEAD、204)不会出错
+type lazyReader struct {
+	open func() (io.Reader, error)
+	r    io.Reader
+	err  error
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil && l.err == nil {
+		l.r, l.err = l.open()
+	}
+
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.r.Read(p)
+}
+
+type decodedBody struct {
+	io.Reader
+	raw io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	return b.raw.Close()
+}
@@ -0,0 +1,343 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-29 15:20:11
+- @Description: 可替换的传输层，用于在没有交易所账号、不连外网的情况下测试cex里的逻辑
+- 1. http：所有请求都经过HttpClientOf取得的客户端，SetTransport替换其底层http.RoundTripper。FakeTransport按method+path匹配处理函数或预置的响应(fixture)，并记录收到的请求
+- 2. websocket：SetWsUrlRewrite改写拨号地址，指向本地的FakeWsServer。FakeWsServer按关键字回复订阅请求，可主动推送消息、断开连接以测试重连
+- fixture文件为json，内容为单个FakeResponse或数组，如{"method":"GET","path":"/api/v5/public/time","status":200,"body":{"code":"0"}}
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/gorilla/websocket"
+)
+
+// 用rt替换所有未注入客户端的host的传输层，rt为nil时恢复
+func SetTransport(rt http.RoundTripper) {
+	if rt == nil {
+		SetDefaultHttpClient(nil)
+	} else {
+		SetDefaultHttpClient(&http.Client{Transport: rt})
+	}
+}
+
+var wsUrlRewrite func(rawUrl string) string
+var muWsUrlRewrite sync.RWMutex
+
+// 改写websocket的拨号地址，fn为nil时恢复
+func SetWsUrlRewrite(fn func(rawUrl string) string) {
+	muWsUrlRewrite.Lock()
+	defer muWsUrlRewrite.Unlock()
+	wsUrlRewrite = fn
+}
+
+func RewriteWsUrl(rawUrl string) string {
+	muWsUrlRewrite.RLock()
+	fn := wsUrlRewrite
+	muWsUrlRewrite.RUnlock()
+	if fn != nil {
+		return fn(rawUrl)
+	}
+	return rawUrl
+}
+
+// #region http
+type FakeResponse struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Query  map[string]string `json:"query"` // 需要匹配的参数，未列出的参数不检查
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	Body   json.RawMessage   `json:"body"`
+}
+
+func (f FakeResponse) match(req *http.Request) bool {
+	if (len(f.Method) > 0 && f.Method != req.Method) || f.Path != req.URL.Path {
+		return false
+	}
+
+	q := req.URL.Query()
+	for k, v := range f.Query {
+		if q.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+type FakeRequest struct {
+	Method string
+	Url    string
+	Header http.Header
+	Body   string
+}
+
+type fakeHandler struct {
+	method string
+	path   string
+	fn     func(req *http.Request) (int, string)
+}
+
+type FakeTransport struct {
+	mu       sync.Mutex
+	handlers []fakeHandler
+	fixtures []FakeResponse
+	requests []FakeRequest
+}
+
+func NewFakeTransport() *FakeTransport {
+	return new(FakeTransport)
+}
+
+// 用函数处理某个接口，优先于fixture。method为空表示不限
+func (t *FakeTransport) Handle(method, path string, fn func(req *http.Request) (status int, body string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers = append(t.handlers, fakeHandler{method: method, path: path, fn: fn})
+}
+
+// 后添加的fixture优先匹配
+func (t *FakeTransport) AddFixture(f FakeResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fixtures = append(t.fixtures, f)
+}
+
+// 加载目录下所有的json文件
+func (t *FakeTransport) LoadFixtures(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		fixtures := []FakeResponse{}
+		if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+			err = json.Unmarshal(b, &fixtures)
+		} else {
+			f := FakeResponse{}
+			err = json.Unmarshal(b, &f)
+			fixtures = append(fixtures, f)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+
+		for _, f := range fixtures {
+			t.AddFixture(f)
+		}
+	}
+	return nil
+}
+
+// 收到过的请求
+func (t *FakeTransport) Requests() []FakeRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]FakeRequest{}, t.requests...)
+}
+
+// 实现http.RoundTripper
+func (t *FakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		req.Body.Close()
+		body = string(b)
+	}
+
+	t.mu.Lock()
+	t.requests = append(t.requests, FakeRequest{Method: req.Method, Url: req.URL.String(), Header: req.Header.Clone(), Body: body})
+	handlers := t.handlers
+	fixtures := t.fixtures
+	t.mu.Unlock()
+
+	for _, h := range handlers {
+		if (len(h.method) == 0 || h.method == req.Method) && h.path == req.URL.Path {
+			req.Body = io.NopCloser(strings.NewReader(body))
+			status, respBody := h.fn(req)
+			return fakeResponse(req, status, nil, []byte(respBody)), nil
+		}
+	}
+
+	for i := len(fixtures) - 1; i >= 0; i-- {
+		if f := fixtures[i]; f.match(req) {
+			return fakeResponse(req, f.Status, f.Header, f.Body), nil
+		}
+	}
+
+	return fakeResponse(req, http.StatusNotFound, nil, []byte(fmt.Sprintf(`{"error":"no fixture for %s %s"}`, req.Method, req.URL.Path))), nil
+}
+
+func fakeResponse(req *http.Request, status int, header map[string]string, body []byte) *http.Response {
+	status = util.ValueIf(status > 0, status, http.StatusOK)
+	res := &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+
+	res.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		res.Header.Set(k, v)
+	}
+	return res
+}
+
+// #endregion
+
+// #region websocket
+type fakeWsReply struct {
+	keys  []string
+	reply string
+}
+
+type FakeWsServer struct {
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]*sync.Mutex
+	replies  []fakeWsReply
+	received []string
+	accepted int
+}
+
+func NewFakeWsServer() *FakeWsServer {
+	s := new(FakeWsServer)
+	s.conns = make(map[*websocket.Conn]*sync.Mutex)
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// 拨号地址，如ws://127.0.0.1:12345
+func (s *FakeWsServer) Url() string {
+	return "ws" + strings.TrimPrefix(s.srv.URL, "http")
+}
+
+// 收到同时包含所有keys的消息时回复reply
+func (s *FakeWsServer) Reply(reply string, keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, fakeWsReply{keys: keys, reply: reply})
+}
+
+// 推送消息到所有连接
+func (s *FakeWsServer) Push(msg string) {
+	s.mu.Lock()
+	conns := make(map[*websocket.Conn]*sync.Mutex, len(s.conns))
+	for c, mu := range s.conns {
+		conns[c] = mu
+	}
+	s.mu.Unlock()
+
+	for c, mu := range conns {
+		mu.Lock()
+		c.WriteMessage(websocket.TextMessage, []byte(msg))
+		mu.Unlock()
+	}
+}
+
+// 断开所有连接，客户端应自动重连
+func (s *FakeWsServer) DropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// 收到过的消息
+func (s *FakeWsServer) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.received...)
+}
+
+// 接受过的连接数
+func (s *FakeWsServer) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *FakeWsServer) Close() {
+	s.DropAll()
+	s.srv.Close()
+}
+
+func (s *FakeWsServer) serve(w http.ResponseWriter, r *http.Request) {
+	c, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	muWrite := new(sync.Mutex)
+	s.mu.Lock()
+	s.conns[c] = muWrite
+	s.accepted++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	for {
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg := string(b)
+		s.mu.Lock()
+		s.received = append(s.received, msg)
+		replies := s.replies
+		s.mu.Unlock()
+
+		for _, r := range replies {
+			matched := true
+			for _, k := range r.keys {
+				if !strings.Contains(msg, k) {
+					matched = false
+					break
+				}
+			}
+
+			if matched {
+				muWrite.Lock()
+				c.WriteMessage(websocket.TextMessage, []byte(r.reply))
+				muWrite.Unlock()
+			}
+		}
+	}
+}
+
+// #endregion
@@ -14,9 +14,11 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/aztecqt/dagger/util"
 	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/tracing"
 )
 
 var cookies []http.Cookie
@@ -34,39 +36,84 @@ func ClearCoockies() {
 }
 
 func HttpCall(url string, method string, postData string, headers map[string]string, callback func(*http.Response, error)) {
+	HttpCallWithRetry(url, method, postData, headers, GetRetryPolicy(), callback)
+}
+
+// 按指定的重试策略调用，callback只对最后一次结果调用一次
+func HttpCallWithRetry(url string, method string, postData string, headers map[string]string, policy RetryPolicy, callback func(*http.Response, error)) {
 	logPrefix := "http"
 	if callback == nil {
 		logger.LogPanic(logPrefix, "no callback, url=%s", url)
 	}
 
-	req, err := http.NewRequest(method, url, strings.NewReader(postData))
-	if err != nil {
-		callback(nil, err)
-		return
-	}
+	client := HttpClientOf(url)
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, url, strings.NewReader(postData))
+		if err != nil {
+			callback(nil, err)
+			return
+		}
 
-	if len(postData) > 0 && (postData[0] != '{' || postData[len(postData)-1] != '}') {
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	}
+		if len(postData) > 0 && (postData[0] != '{' || postData[len(postData)-1] != '}') {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
 
-	for _, c := range cookies {
-		req.AddCookie(&c)
-	}
+		for _, c := range cookies {
+			req.AddCookie(&c)
+		}
+
+		if headers != nil {
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+		}
+		setAcceptEncoding(req, headers)
 
-	if headers != nil {
-		for k, v := range headers {
-			req.Header.Set(k, v)
+		ms := middlewaresOf(req)
+		if err := runBeforeRequest(ms, req); err != nil {
+			callback(nil, err)
+			return
 		}
-	}
 
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		callback(nil, err)
+		endpoint := endpointOf(req)
+		bp, useBreaker := breakerPolicyOf(req)
+		if useBreaker {
+			if err := breakerAllow(endpoint, bp); err != nil {
+				callback(nil, err)
+				return
+			}
+		}
+
+		gate := requestGateOf(req.URL.Host)
+		if gate != nil {
+			gate(method, url)
+		}
+
+		t0 := time.Now()
+		res, err := client.Do(req)
+		recordMetrics(req, res, err, time.Since(t0), len(postData))
+		decodeResponseBody(res)
+		if useBreaker {
+			breakerRecord(endpoint, bp, res, err)
+		}
+		runAfterResponse(ms, req, res, err)
+		if delay, retry := policy.shouldRetry(method, attempt, res, err, gate != nil); retry {
+			logger.LogInfo(logPrefix, "%s %s failed(%s), retry %d/%d after %v", method, url, retryReason(res, err), attempt, policy.MaxAttempts-1, delay)
+			if res != nil {
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+			time.Sleep(delay)
+			continue
+		}
+
+		if err != nil {
+			callback(nil, err)
+		} else {
+			defer res.Body.Close()
+			callback(res, err)
+		}
 		return
-	} else {
-		defer res.Body.Close()
-		callback(res, err)
 	}
 }
 
@@ -76,6 +123,10 @@ var HttpLogMaxLen = 256
 func ParseHttpResult[T any](logPref, funcName, url, method, postData string, headers map[string]string, cbRaw func(resp *http.Response, body []byte), cbErr func(e error)) (t *T, e error) {
 	defer util.DefaultRecover()
 
+	span := tracing.StartRest(logPref, funcName, method, url)
+	statusCode := 0
+	defer func() { tracing.EndRest(span, statusCode, e) }()
+
 	if method == "GET" {
 		if EnableHttpLog {
 			logger.LogDebug(logPref, "%s GET from url: %s", funcName, url)
@@ -93,6 +144,10 @@ func ParseHttpResult[T any](logPref, funcName, url, method, postData string, hea
 	HttpCall(url, method, postData, headers, func(resp *http.Response, err error) {
 		t = new(T)
 		var body []byte
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+
 		if err != nil {
 			e = err
 			logger.LogImportant(logPref, "%s http error, err=%s", funcName, err.Error())
@@ -0,0 +1,253 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-29 17:42:55
+- @Description: 按(交易所,接口)统计http请求：次数、错误率、状态码分布、延迟直方图、请求/响应字节数
+- 1. 每次尝试(包括重试)都计入。延迟为发出请求到收到响应头的时间，网络错误的状态码记为0
+- 2. 响应字节数在body关闭时计入，chunked响应也能统计准确
+- 3. 交易所按host后缀识别(okx.com/binance.com)，其他host直接用host，可用SetExchangeOfHost扩展
+- 4. Metrics()取快照供程序查询，SetMetricsSink注册的回调每个请求调用一次，可接入外部的指标系统
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var EnableMetrics = true
+
+// 延迟直方图的桶上限，最后一个桶为超出所有上限的部分
+var LatencyBuckets = []time.Duration{
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Millisecond * 2500,
+	time.Second * 5,
+}
+
+type EndpointStats struct {
+	Exchange    string
+	Endpoint    string // METHOD path
+	Count       int64
+	Errors      int64         // 网络错误和4xx/5xx
+	StatusCodes map[int]int64 // 状态码->次数
+	Latency     []int64       // 各个桶的次数，长度为len(LatencyBuckets)+1
+	LatencySum  time.Duration
+	LatencyMax  time.Duration
+	ReqBytes    int64
+	RespBytes   int64
+}
+
+func (s EndpointStats) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Count)
+}
+
+func (s EndpointStats) AvgLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.LatencySum / time.Duration(s.Count)
+}
+
+// 延迟分位数(0~1)，取所在桶的上限，落在最后一个桶时取最大值
+func (s EndpointStats) Percentile(p float64) time.Duration {
+	return LatencyPercentile(s.Latency, s.Count, s.LatencyMax, p)
+}
+
+// 按LatencyBuckets计数的直方图的分位数，其他模块的延迟统计也可使用
+func LatencyPercentile(hist []int64, count int64, maxLatency time.Duration, p float64) time.Duration {
+	if count == 0 {
+		return 0
+	}
+
+	target := int64(float64(count)*p + 0.5)
+	acc := int64(0)
+	for i, n := range hist {
+		acc += n
+		if acc >= target && i < len(LatencyBuckets) {
+			return min(LatencyBuckets[i], maxLatency)
+		}
+	}
+	return maxLatency
+}
+
+// 延迟所在的桶
+func LatencyBucket(latency time.Duration) int {
+	return sort.Search(len(LatencyBuckets), func(i int) bool { return latency <= LatencyBuckets[i] })
+}
+
+type metricsKey struct {
+	exchange string
+	endpoint string
+}
+
+var metrics = make(map[metricsKey]*EndpointStats)
+var muMetrics sync.Mutex
+var fnMetricsSink func(exchange, endpoint string, status int, latency time.Duration, reqBytes, respBytes int64)
+
+var exchangeHosts = map[string]string{
+	"okx.com":     "okx",
+	"binance.com": "binance",
+}
+
+// 以suffix结尾的host统计为交易所name
+func SetExchangeOfHost(suffix, name string) {
+	muMetrics.Lock()
+	defer muMetrics.Unlock()
+	exchangeHosts[suffix] = name
+}
+
+func SetMetricsSink(fn func(exchange, endpoint string, status int, latency time.Duration, reqBytes, respBytes int64)) {
+	muMetrics.Lock()
+	defer muMetrics.Unlock()
+	fnMetricsSink = fn
+}
+
+// 所有接口的统计快照，按交易所、接口排序
+func Metrics() []EndpointStats {
+	muMetrics.Lock()
+	defer muMetrics.Unlock()
+	all := make([]EndpointStats, 0, len(metrics))
+	for _, s := range metrics {
+		all = append(all, s.clone())
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Exchange != all[j].Exchange {
+			return all[i].Exchange < all[j].Exchange
+		}
+		return all[i].Endpoint < all[j].Endpoint
+	})
+	return all
+}
+
+func MetricsOf(exchange, endpoint string) (EndpointStats, bool) {
+	muMetrics.Lock()
+	defer muMetrics.Unlock()
+	if s, ok := metrics[metricsKey{exchange, endpoint}]; ok {
+		return s.clone(), true
+	}
+	return EndpointStats{}, false
+}
+
+func ResetMetrics() {
+	muMetrics.Lock()
+	defer muMetrics.Unlock()
+	metrics = make(map[metricsKey]*EndpointStats)
+}
+
+func (s *EndpointStats) clone() EndpointStats {
+	c := *s
+	c.StatusCodes = make(map[int]int64, len(s.StatusCodes))
+	for k, v := range s.StatusCodes {
+		c.StatusCodes[k] = v
+	}
+	c.Latency = append([]int64{}, s.Latency...)
+	return c
+}
+
+// 调用时需持有muMetrics
+func exchangeOfHost(host string) string {
+	hostname := host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		hostname = host[:i]
+	}
+
+	for suffix, name := range exchangeHosts {
+		if strings.HasSuffix(hostname, suffix) {
+			return name
+		}
+	}
+	return hostname
+}
+
+// 记录一次请求。响应字节数在body关闭时补记
+func recordMetrics(req *http.Request, res *http.Response, err error, latency time.Duration, reqBytes int) {
+	if !EnableMetrics {
+		return
+	}
+
+	status := 0
+	if err == nil && res != nil {
+		status = res.StatusCode
+	}
+
+	muMetrics.Lock()
+	key := metricsKey{exchangeOfHost(req.URL.Host), req.Method + " " + req.URL.Path}
+	s, ok := metrics[key]
+	if !ok {
+		s = &EndpointStats{
+			Exchange:    key.exchange,
+			Endpoint:    key.endpoint,
+			StatusCodes: make(map[int]int64),
+			Latency:     make([]int64, len(LatencyBuckets)+1),
+		}
+		metrics[key] = s
+	}
+
+	s.Count++
+	if status == 0 || status >= 400 {
+		s.Errors++
+	}
+	s.StatusCodes[status]++
+	s.Latency[LatencyBucket(latency)]++
+	s.LatencySum += latency
+	s.LatencyMax = max(s.LatencyMax, latency)
+	s.ReqBytes += int64(reqBytes)
+	fn := fnMetricsSink
+	muMetrics.Unlock()
+
+	if res == nil || res.Body == nil {
+		if fn != nil {
+			fn(key.exchange, key.endpoint, status, latency, int64(reqBytes), 0)
+		}
+		return
+	}
+
+	res.Body = &countingBody{ReadCloser: res.Body, onClose: func(n int64) {
+		muMetrics.Lock()
+		if s, ok := metrics[key]; ok {
+			s.RespBytes += n
+		}
+		muMetrics.Unlock()
+
+		if fn != nil {
+			fn(key.exchange, key.endpoint, status, latency, int64(reqBytes), n)
+		}
+	}}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n       int64
+	closed  int32
+	onClose func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		b.onClose(b.n)
+	}
+	return err
+}
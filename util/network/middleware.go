@@ -0,0 +1,116 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-29 09:12:40
+- @Description: http中间件。在不修改各个api函数的前提下，拦截所有经过HttpCall的请求和响应
+- 1. Before在请求发出前调用(每次重试都会调用)，可修改请求(如注入header)，返回错误时不再发送，调用方收到该错误
+- 2. After在收到响应(或网络错误)后调用，在重试判断之前。读取body请用PeekBody，不影响后续处理
+- 3. Before按添加顺序调用，After按相反顺序调用。Match为空表示匹配所有请求
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type Middleware struct {
+	Name   string
+	Match  func(req *http.Request) bool
+	Before func(req *http.Request) error
+	After  func(req *http.Request, res *http.Response, err error)
+}
+
+var middlewares []Middleware
+var muMiddlewares sync.RWMutex
+
+// 添加中间件，同名的中间件会被替换
+func UseMiddleware(m Middleware) {
+	muMiddlewares.Lock()
+	defer muMiddlewares.Unlock()
+	for i, old := range middlewares {
+		if old.Name == m.Name {
+			middlewares[i] = m
+			return
+		}
+	}
+	middlewares = append(middlewares, m)
+}
+
+func RemoveMiddleware(name string) {
+	muMiddlewares.Lock()
+	defer muMiddlewares.Unlock()
+	kept := make([]Middleware, 0, len(middlewares))
+	for _, m := range middlewares {
+		if m.Name != name {
+			kept = append(kept, m)
+		}
+	}
+	middlewares = kept
+}
+
+// 匹配某个请求的中间件
+func middlewaresOf(req *http.Request) []Middleware {
+	muMiddlewares.RLock()
+	defer muMiddlewares.RUnlock()
+	var ms []Middleware
+	for _, m := range middlewares {
+		if m.Match == nil || m.Match(req) {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func runBeforeRequest(ms []Middleware, req *http.Request) error {
+	for _, m := range ms {
+		if m.Before != nil {
+			if err := m.Before(req); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runAfterResponse(ms []Middleware, req *http.Request, res *http.Response, err error) {
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i].After != nil {
+			ms[i].After(req, res, err)
+		}
+	}
+}
+
+// 匹配path前缀的请求，如"/api/v5/trade"
+func MatchPathPrefix(prefix string) func(req *http.Request) bool {
+	return func(req *http.Request) bool {
+		return strings.HasPrefix(req.URL.Path, prefix)
+	}
+}
+
+// 读取响应的body并放回，后续处理仍可正常读取
+func PeekBody(res *http.Response) []byte {
+	if res == nil || res.Body == nil {
+		return nil
+	}
+
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	return b
+}
+
+// 读取请求的body并放回
+func PeekRequestBody(req *http.Request) []byte {
+	if req == nil || req.Body == nil {
+		return nil
+	}
+
+	b, _ := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(b))
+	return b
+}
@@ -0,0 +1,124 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-27 14:52:08
+- @Description: http重试策略。网络错误、5xx、429时按指数退避重试，避免偶发的错误直接变成调用失败
+- 1. 默认只重试幂等请求(GET/HEAD/OPTIONS/PUT/DELETE)，POST下单等请求重复发送可能产生副作用，需显式开启RetryNonIdempotent
+- 2. 退避时间为BaseDelay*2^(n-1)，不超过MaxDelay，并在[一半,全部]之间随机，避免多个请求同时重试
+- 3. 响应带有Retry-After时以其为准。超过MaxRetryAfter时不再重试，直接返回该响应
+- 4. 设置了请求闸门(SetRequestGate，如币安的权重限制)的host，429/418不在这里重试，直接返回给调用者，由闸门按Retry-After暂停整个host
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts        int           // 总尝试次数，1表示不重试
+	BaseDelay          time.Duration // 首次重试的退避时间
+	MaxDelay           time.Duration // 退避时间上限
+	MaxRetryAfter      time.Duration // Retry-After的上限
+	RetryNonIdempotent bool          // 是否重试非幂等请求
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     time.Millisecond * 200,
+	MaxDelay:      time.Second * 3,
+	MaxRetryAfter: time.Second * 10,
+}
+
+// 不重试
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+var retryPolicy = DefaultRetryPolicy
+var muRetryPolicy sync.RWMutex
+
+// 设置HttpCall/ParseHttpResult使用的全局重试策略
+func SetRetryPolicy(p RetryPolicy) {
+	muRetryPolicy.Lock()
+	defer muRetryPolicy.Unlock()
+	retryPolicy = p
+}
+
+func GetRetryPolicy() RetryPolicy {
+	muRetryPolicy.RLock()
+	defer muRetryPolicy.RUnlock()
+	return retryPolicy
+}
+
+var idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete}
+
+// 第attempt次请求的结果是否需要重试，以及重试前的等待时间。gated表示该host有请求闸门
+func (p RetryPolicy) shouldRetry(method string, attempt int, res *http.Response, err error, gated bool) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if gated && res != nil && (res.StatusCode == http.StatusTooManyRequests || res.StatusCode == 418) {
+		return 0, false
+	}
+
+	if !p.RetryNonIdempotent && !slices.Contains(idempotentMethods, method) {
+		return 0, false
+	}
+
+	if err == nil && res != nil && res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	delay := p.backoff(attempt)
+	if res != nil {
+		if ra, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			if p.MaxRetryAfter > 0 && ra > p.MaxRetryAfter {
+				return 0, false
+			}
+			delay = ra
+		}
+	}
+	return delay, true
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Retry-After可以是秒数，也可以是http时间
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func retryReason(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	} else if res != nil {
+		return fmt.Sprintf("status %d", res.StatusCode)
+	}
+	return "unknown"
+}
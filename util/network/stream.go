@@ -0,0 +1,257 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-30 10:08:31
+- @Description: 流式解析较大的响应(如exchangeInfo、完整深度快照，可达数MB)，避免交易过程中产生大块的临时内存和GC停顿
+- ParseHttpResult先把body全部读入内存再Unmarshal，json.Decoder直接Decode整个对象同样会缓存整个值
+- 这里逐个token地遍历顶层对象：数组字段逐个元素解析，T中没有的字段按token跳过不缓存，内存占用只和单个元素的大小有关
+- 顶层为数组时同样逐个元素解析。匿名结构体的字段按encoding/json的规则提升，T有自定义UnmarshalJSON时退化为整体Decode
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/aztecqt/dagger/util"
+	"github.com/aztecqt/dagger/util/logger"
+	"github.com/aztecqt/dagger/util/tracing"
+)
+
+// 传给cbHead的body头部长度。错误信息一般较短，会被完整包含
+const streamHeadLen = 512
+
+// 与ParseHttpResult相同，但流式解析。cbHead收到的是body的头部，用于识别错误码等
+func ParseHttpResultStream[T any](logPref, funcName, url, method, postData string, headers map[string]string, cbHead func(resp *http.Response, head []byte), cbErr func(e error)) (t *T, e error) {
+	defer util.DefaultRecover()
+
+	span := tracing.StartRest(logPref, funcName, method, url)
+	statusCode := 0
+	defer func() { tracing.EndRest(span, statusCode, e) }()
+
+	if EnableHttpLog {
+		logger.LogDebug(logPref, "%s %s from url: %s (stream)", funcName, method, url)
+	}
+
+	if method != "GET" && headers != nil {
+		headers["content-type"] = "application/json"
+	}
+
+	HttpCall(url, method, postData, headers, func(resp *http.Response, err error) {
+		t = new(T)
+		var head []byte
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+
+		if err != nil {
+			e = err
+			logger.LogImportant(logPref, "%s http error, err=%s", funcName, err.Error())
+		} else {
+			r := bufio.NewReaderSize(resp.Body, 4096)
+			head, _ = r.Peek(streamHeadLen)
+			head = append([]byte{}, head...)
+			if EnableHttpLog {
+				logger.LogDebug(logPref, "%s resp head: %s", funcName, string(head[:min(len(head), HttpLogMaxLen)]))
+			}
+
+			if err = DecodeStream(r, t); err != nil {
+				e = err
+				logger.LogImportant(logPref, "%s json decode error, err=%s", funcName, err.Error())
+			}
+		}
+
+		if cbHead != nil {
+			cbHead(resp, head)
+		}
+
+		if e != nil && cbErr != nil {
+			cbErr(e)
+		}
+	})
+
+	return
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// 从r流式解析一个json值到v(指针)
+func DecodeStream(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer")
+	}
+
+	dec := json.NewDecoder(r)
+	if !streamable(rv.Type()) {
+		return dec.Decode(v)
+	}
+
+	switch rv.Elem().Kind() {
+	case reflect.Slice:
+		return decodeSliceStream(dec, rv.Elem())
+	default:
+		return decodeStructStream(dec, rv.Elem())
+	}
+}
+
+func streamable(pt reflect.Type) bool {
+	if pt.Implements(jsonUnmarshalerType) {
+		return false
+	}
+
+	t := pt.Elem()
+	switch t.Kind() {
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8 // []byte按base64整体解析
+	case reflect.Struct:
+		return embeddable(t)
+	default:
+		return false
+	}
+}
+
+// 逐个元素解析数组，null保持原值
+func decodeSliceStream(dec *json.Decoder, v reflect.Value) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil {
+		return nil
+	} else if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("expect array, got %v", tok)
+	}
+
+	s := reflect.MakeSlice(v.Type(), 0, 0)
+	for dec.More() {
+		elem := reflect.New(v.Type().Elem())
+		if err := dec.Decode(elem.Interface()); err != nil {
+			return err
+		}
+		s = reflect.Append(s, elem.Elem())
+	}
+	v.Set(s)
+
+	_, err = dec.Token() // ']'
+	return err
+}
+
+func decodeStructStream(dec *json.Decoder, v reflect.Value) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if tok == nil {
+		return nil
+	} else if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("expect object, got %v", tok)
+	}
+
+	fields := jsonFields(v.Type())
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		key, _ := tok.(string)
+		path, ok := fields[strings.ToLower(key)]
+		if !ok {
+			if err := skipValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f := v.FieldByIndex(path)
+		if f.Kind() == reflect.Slice && streamable(reflect.PointerTo(f.Type())) {
+			err = decodeSliceStream(dec, f)
+		} else {
+			err = dec.Decode(f.Addr().Interface())
+		}
+
+		if err != nil {
+			return fmt.Errorf("field %s: %s", key, err.Error())
+		}
+	}
+
+	_, err = dec.Token() // '}'
+	return err
+}
+
+// 匿名字段需为没有json名字的结构体(非指针)，且自身可流式解析
+func embeddable(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && len(jsonName(f)) == 0 {
+			if f.Type.Kind() != reflect.Struct || reflect.PointerTo(f.Type).Implements(jsonUnmarshalerType) || !embeddable(f.Type) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func jsonName(f reflect.StructField) string {
+	tag, _ := f.Tag.Lookup("json")
+	return strings.Split(tag, ",")[0]
+}
+
+// json字段名(小写)->字段路径，与encoding/json一样不区分大小写。匿名结构体的字段提升到外层，外层同名字段优先
+func jsonFields(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int)
+	var embedded []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if f.Anonymous && len(name) == 0 {
+			embedded = append(embedded, i)
+			continue
+		}
+
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		fields[strings.ToLower(util.ValueIf(len(name) > 0, name, f.Name))] = []int{i}
+	}
+
+	for _, i := range embedded {
+		for name, path := range jsonFields(t.Field(i).Type) {
+			if _, ok := fields[name]; !ok {
+				fields[name] = append([]int{i}, path...)
+			}
+		}
+	}
+	return fields
+}
+
+// 按token跳过一个值，不缓存整个值
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if d, ok := tok.(json.Delim); ok {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
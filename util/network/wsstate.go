@@ -0,0 +1,123 @@
+/*
+- @Author: aztec
+- @Date: 2024-07-28 17:06:23
+- @Description: 长连接(websocket)的通用部分：连接状态、状态变化事件、重连退避
+- 1. 状态依次为Connecting -> Connected(已连接，登录/订阅未完成) -> Ready(登录和订阅全部完成)，断开后回到Disconnected
+- 2. 状态变化时同步回调订阅者，回调中不要做耗时操作。订阅时立即以当前状态回调一次，返回的Id用于取消订阅
+- 3. 重连间隔按指数退避并随机，连接成功后重置
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package network
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+type ConnState int
+
+const (
+	ConnState_Disconnected ConnState = iota
+	ConnState_Connecting
+	ConnState_Connected
+	ConnState_Ready
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnState_Disconnected:
+		return "disconnected"
+	case ConnState_Connecting:
+		return "connecting"
+	case ConnState_Connected:
+		return "connected"
+	case ConnState_Ready:
+		return "ready"
+	default:
+		return "unknown"
+	}
+}
+
+type ConnEvent struct {
+	Name       string    // 连接名
+	State      ConnState // 新状态
+	Time       time.Time
+	Reconnects int    // 第几次连接
+	Err        string // 断开的原因
+}
+
+type connStateSub struct {
+	id int
+	fn func(e ConnEvent)
+}
+
+// 连接状态和订阅者
+type ConnStateHub struct {
+	mu     sync.Mutex
+	last   ConnEvent
+	subs   []connStateSub
+	nextId int
+}
+
+func (h *ConnStateHub) State() ConnState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last.State
+}
+
+func (h *ConnStateHub) Last() ConnEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+func (h *ConnStateHub) Subscribe(fn func(e ConnEvent)) int {
+	h.mu.Lock()
+	h.nextId++
+	id := h.nextId
+	h.subs = append(h.subs, connStateSub{id: id, fn: fn})
+	last := h.last
+	h.mu.Unlock()
+	fn(last)
+	return id
+}
+
+func (h *ConnStateHub) Unsubscribe(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs = slices.DeleteFunc(h.subs, func(s connStateSub) bool { return s.id == id })
+}
+
+// 发布新状态，状态没有变化时忽略
+func (h *ConnStateHub) Publish(e ConnEvent) {
+	h.mu.Lock()
+	if e.State == h.last.State {
+		h.mu.Unlock()
+		return
+	}
+	h.last = e
+	subs := slices.Clone(h.subs)
+	h.mu.Unlock()
+
+	for _, s := range subs {
+		s.fn(e)
+	}
+}
+
+// 重连退避
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+	n    int
+}
+
+// 下一次重连前的等待时间
+func (b *Backoff) Next() time.Duration {
+	b.n++
+	return RetryPolicy{BaseDelay: b.Base, MaxDelay: b.Max}.backoff(b.n)
+}
+
+func (b *Backoff) Reset() {
+	b.n = 0
+}
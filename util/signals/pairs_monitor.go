@@ -0,0 +1,214 @@
+/*
+- @Author: aztec
+- @Date: 2024-06-07 16:40:22
+- @Description: 配对交易协整监控
+- 对配置的品种对，用K线收盘价的对数维护滚动对冲比例(OLS)、价差z-score、均值回归半衰期
+- 每根新K线对齐后计算一次，结果以EventType_PairSignal发布到事件总线(默认为common.DefaultEventBus)
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package signals
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aztecqt/dagger/cex/common"
+	"gonum.org/v1/gonum/stat"
+)
+
+// 配对参数
+type PairConfig struct {
+	SymbolA string `json:"symbol_a"`
+	SymbolB string `json:"symbol_b"`
+	Window  int    `json:"window"` // 滚动窗口长度（K线根数）
+}
+
+func (c PairConfig) Name() string {
+	return fmt.Sprintf("%s/%s", c.SymbolA, c.SymbolB)
+}
+
+// 配对信号
+// spread = ln(A) - HedgeRatio*ln(B) - Intercept
+type PairSignal struct {
+	Pair       PairConfig
+	Time       time.Time
+	HedgeRatio float64
+	Intercept  float64
+	Spread     float64
+	ZScore     float64
+	HalfLife   float64 // 均值回归半衰期（K线根数），不回归时为+Inf
+	Corr       float64 // 对数价格相关系数
+}
+
+func (s PairSignal) String() string {
+	return fmt.Sprintf("[%s beta=%.4f z=%.2f hl=%.1f corr=%.3f]", s.Pair.Name(), s.HedgeRatio, s.ZScore, s.HalfLife, s.Corr)
+}
+
+func (s PairSignal) event() common.PairSignal {
+	return common.PairSignal{
+		SymbolA:    s.Pair.SymbolA,
+		SymbolB:    s.Pair.SymbolB,
+		HedgeRatio: s.HedgeRatio,
+		Intercept:  s.Intercept,
+		Spread:     s.Spread,
+		ZScore:     s.ZScore,
+		HalfLife:   s.HalfLife,
+		Corr:       s.Corr,
+	}
+}
+
+type pairState struct {
+	cfg     PairConfig
+	ts      []time.Time
+	logA    []float64
+	logB    []float64
+	lastA   map[int64]float64 // 等待对齐的收盘价
+	lastB   map[int64]float64
+	latest  PairSignal
+	updated bool
+}
+
+type PairsMonitor struct {
+	mu    sync.Mutex
+	pairs []*pairState
+	bus   *common.EventBus
+}
+
+func (m *PairsMonitor) Init(cfgs []PairConfig) {
+	m.bus = common.DefaultEventBus
+	m.pairs = nil
+	for _, c := range cfgs {
+		if c.Window < 3 {
+			c.Window = 3
+		}
+
+		m.pairs = append(m.pairs, &pairState{
+			cfg:   c,
+			lastA: map[int64]float64{},
+			lastB: map[int64]float64{},
+		})
+	}
+}
+
+// 发布到指定的总线，如回测的独立总线
+func (m *PairsMonitor) SetEventBus(bus *common.EventBus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bus = bus
+}
+
+// 输入一根K线的收盘价。同一时间戳的A、B都到齐后才会计算
+func (m *PairsMonitor) OnCandle(symbol string, t time.Time, closePrice float64) {
+	if closePrice <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	sigs := []PairSignal{}
+	for _, p := range m.pairs {
+		if symbol == p.cfg.SymbolA {
+			p.lastA[t.UnixMilli()] = closePrice
+		} else if symbol == p.cfg.SymbolB {
+			p.lastB[t.UnixMilli()] = closePrice
+		} else {
+			continue
+		}
+
+		if sig, ok := p.tryUpdate(t); ok {
+			sigs = append(sigs, sig)
+		}
+	}
+	bus := m.bus
+	m.mu.Unlock()
+
+	for _, sig := range sigs {
+		bus.PublishPairSignal(sig.Pair.Name(), sig.event(), sig.Time)
+	}
+}
+
+// 获取某个品种对的最新信号
+func (m *PairsMonitor) Latest(symbolA, symbolB string) (PairSignal, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.pairs {
+		if p.cfg.SymbolA == symbolA && p.cfg.SymbolB == symbolB {
+			return p.latest, p.updated
+		}
+	}
+	return PairSignal{}, false
+}
+
+func (p *pairState) tryUpdate(t time.Time) (PairSignal, bool) {
+	ms := t.UnixMilli()
+	pa, okA := p.lastA[ms]
+	pb, okB := p.lastB[ms]
+	if !okA || !okB {
+		return PairSignal{}, false
+	}
+
+	// 已对齐，清理掉这个时间及更早的缓存
+	for k := range p.lastA {
+		if k <= ms {
+			delete(p.lastA, k)
+		}
+	}
+	for k := range p.lastB {
+		if k <= ms {
+			delete(p.lastB, k)
+		}
+	}
+
+	p.ts = append(p.ts, t)
+	p.logA = append(p.logA, math.Log(pa))
+	p.logB = append(p.logB, math.Log(pb))
+	if len(p.ts) > p.cfg.Window {
+		p.ts = p.ts[1:]
+		p.logA = p.logA[1:]
+		p.logB = p.logB[1:]
+	}
+
+	if len(p.ts) < p.cfg.Window {
+		return PairSignal{}, false
+	}
+
+	sig := PairSignal{Pair: p.cfg, Time: t}
+	sig.Intercept, sig.HedgeRatio = stat.LinearRegression(p.logB, p.logA, nil, false)
+	sig.Corr = stat.Correlation(p.logA, p.logB, nil)
+
+	spreads := make([]float64, len(p.logA))
+	for i := range spreads {
+		spreads[i] = p.logA[i] - sig.HedgeRatio*p.logB[i] - sig.Intercept
+	}
+
+	mean, std := stat.MeanStdDev(spreads, nil)
+	sig.Spread = spreads[len(spreads)-1]
+	if std > 0 {
+		sig.ZScore = (sig.Spread - mean) / std
+	}
+	sig.HalfLife = halfLife(spreads)
+
+	p.latest = sig
+	p.updated = true
+	return sig, true
+}
+
+// 用AR(1)估算均值回归半衰期：Δs(t) = a + b*s(t-1)，halflife = -ln2/b
+func halfLife(spreads []float64) float64 {
+	if len(spreads) < 3 {
+		return math.Inf(1)
+	}
+
+	lag := spreads[:len(spreads)-1]
+	delta := make([]float64, len(lag))
+	for i := range delta {
+		delta[i] = spreads[i+1] - spreads[i]
+	}
+
+	_, b := stat.LinearRegression(lag, delta, nil, false)
+	if b >= 0 || math.IsNaN(b) {
+		return math.Inf(1)
+	}
+	return -math.Ln2 / b
+}
@@ -0,0 +1,98 @@
+/*
+- @Author: aztec
+- @Date: 2024-08-01 14:35:18
+- @Description: OpenTelemetry链路追踪，用于查看从策略下单到交易所成交的端到端延迟
+- 1. 默认关闭。使用方自行配置otel sdk(exporter、采样等)后调用SetTracerProvider开启，如SetTracerProvider(otel.GetTracerProvider())
+- 2. ParseHttpResult的每次调用对应一个rest span，名称为funcName
+- 3. 每个订单对应一个span(见common.OrderImpl)，从创建开始，包含place/ack/fill等事件，订单完结时结束
+- 本包只依赖otel api，未开启时不创建span，几乎没有开销
+- Copyright (c) 2024 by aztec, All Rights Reserved.
+*/
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/aztecqt/dagger"
+
+type tracerHolder struct {
+	tracer trace.Tracer
+}
+
+var current atomic.Value // tracerHolder
+
+// tp为nil表示关闭
+func SetTracerProvider(tp trace.TracerProvider) {
+	if tp == nil {
+		current.Store(tracerHolder{})
+	} else {
+		current.Store(tracerHolder{tracer: tp.Tracer(instrumentationName)})
+	}
+}
+
+func Enabled() bool {
+	return tracer() != nil
+}
+
+func tracer() trace.Tracer {
+	h, _ := current.Load().(tracerHolder)
+	return h.tracer
+}
+
+// 未开启时返回不记录任何内容的span
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	t := tracer()
+	if t == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return t.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// rest请求的span。url中的参数可能包含签名，不记录
+func StartRest(logPrefix, funcName, method, rawUrl string) trace.Span {
+	if !Enabled() {
+		return trace.SpanFromContext(context.Background())
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("dagger.log_prefix", logPrefix),
+	}
+
+	if u, err := url.Parse(rawUrl); err == nil {
+		attrs = append(attrs, attribute.String("server.address", u.Host), attribute.String("url.path", u.Path))
+	}
+
+	_, span := Start(context.Background(), funcName, trace.SpanKindClient, attrs...)
+	return span
+}
+
+// statusCode为0表示没有收到响应
+func EndRest(span trace.Span, statusCode int, err error) {
+	if !span.IsRecording() {
+		return
+	}
+
+	if statusCode > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if statusCode >= 400 {
+		span.SetStatus(codes.Error, "")
+	}
+	span.End()
+}